@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/bot"
+	"github.com/gotlou/hister-element-bot/bot/internal/config"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/logging"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/outbox"
+	"github.com/gotlou/hister-element-bot/bot/internal/recovery"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/id"
+)
+
+// account is one Matrix account the bot runs as, with its own client,
+// outbox and bot service. The matrix section is the primary account, which
+// also posts scheduled reports; the accounts section adds more.
+type account struct {
+	cfg          config.MatrixConfig
+	mx           *mautrix.Client
+	crypto       *cryptohelper.CryptoHelper
+	registration *appservice.Registration
+	client       *matrix.Client
+	queue        *outbox.Queue
+	handler      *serviceHandler
+}
+
+// accountDeps is what the accounts share.
+type accountDeps struct {
+	store         *storage.Store
+	backend       hister.SearchBackend
+	summarizer    bot.Summarizer
+	catalog       *i18n.Catalog
+	templates     *render.Templates
+	triggers      []triggers.CustomTrigger
+	searchCommand string
+	watchSchedule schedule.Schedule
+	version       string
+	guard         *recovery.Guard
+	matrixLog     *logging.Logger
+	logger        *logging.Logger
+}
+
+// connectAccount logs in as acct and, outside appservice mode, sets up
+// end-to-end encryption.
+func connectAccount(ctx context.Context, acct config.MatrixConfig, store *storage.Store, matrixLog *logging.Logger) (*account, error) {
+	a := &account{cfg: acct}
+	accessToken := acct.AccessToken
+	if path := acct.Appservice.Registration; path != "" {
+		registration, err := loadRegistration(path, acct.UserID)
+		if err != nil {
+			return nil, err
+		}
+		a.registration = registration
+		accessToken = registration.AppToken
+	}
+	mx, err := matrix.BuildMautrixClient(ctx, matrix.Config{
+		HomeserverURL: acct.HomeserverURL,
+		UserID:        id.UserID(acct.UserID),
+		AccessToken:   accessToken,
+		Password:      acct.Password,
+		RefreshToken:  acct.RefreshToken,
+		OIDC:          matrix.OIDCConfig{TokenEndpoint: acct.OIDC.TokenEndpoint, ClientID: acct.OIDC.ClientID},
+		DeviceID:      id.DeviceID(acct.DeviceID),
+		SyncTimeout:   time.Duration(acct.SyncTimeoutMS) * time.Millisecond,
+	}, matrix.Stores{SyncStore: store, Credentials: store})
+	if err != nil {
+		return nil, err
+	}
+	mx.Log = matrixLog.Zerolog()
+	a.mx = mx
+
+	if a.registration != nil {
+		matrixLog.Printf("appservice mode user=%s; end-to-end encryption is disabled", acct.UserID)
+		return a, nil
+	}
+	a.crypto, err = matrix.NewCryptoHelper(ctx, mx, store.CryptoDB, pickleKey(acct))
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *account) close(logger *logging.Logger) {
+	if a.crypto == nil {
+		return
+	}
+	if err := a.crypto.Close(); err != nil {
+		logger.Printf("close crypto helper user=%s: %v", a.cfg.UserID, err)
+	}
+}
+
+// start creates the Matrix client, outbox and bot service of a connected
+// account.
+func (a *account) start(ctx context.Context, cfg *config.Config, deps accountDeps) error {
+	rooms, err := matrix.NewAllowedRooms(a.cfg.AllowedRoomIDs)
+	if err != nil {
+		return err
+	}
+	if a.registration == nil {
+		filterRooms := roomIDs(a.cfg.AllowedRoomIDs)
+		if a.cfg.AllowDMs {
+			filterRooms = nil
+		}
+		filter := matrix.NewSyncFilter(filterRooms)
+		if err := matrix.UseSyncFilter(ctx, a.mx, deps.store, filter); err != nil {
+			return err
+		}
+	}
+	senders, err := matrix.NewSenderRules(a.cfg.AllowedUserIDs, a.cfg.BlockedUserIDs)
+	if err != nil {
+		return err
+	}
+
+	a.handler = &serviceHandler{}
+	client, err := matrix.NewClient(a.mx, rooms, a.handler, deps.matrixLog)
+	if err != nil {
+		return err
+	}
+	client.SetPanicReporter(deps.guard.Reporter)
+	client.SetColdStartMaxAge(time.Duration(a.cfg.ColdStartMaxAgeMS) * time.Millisecond)
+	client.SetSyncStallTimeout(time.Duration(a.cfg.SyncStallTimeoutMS) * time.Millisecond)
+	client.SetReadReceipts(a.cfg.ReadReceipts)
+	client.SetSenderPolicy(senders)
+	client.SetEventLog(deps.store)
+	client.SetHandlerWorkers(a.cfg.HandlerWorkers, a.cfg.HandlerQueueSize)
+	client.SetCatchUpMaxAge(time.Duration(a.cfg.CatchUpMaxAgeMS) * time.Millisecond)
+	client.SetLeaveDisallowedRooms(a.cfg.LeaveDisallowedRooms, a.cfg.LeaveMessage)
+	client.SetMessageTypes(messageTypes(cfg.Bot))
+	if a.cfg.AllowDMs {
+		dmUsers, err := matrix.NewSenderRules(a.cfg.DMUserIDs, nil)
+		if err != nil {
+			return err
+		}
+		client.SetDirectMessages(dmUsers)
+	}
+	if a.registration != nil {
+		client.SetAppservice(matrix.AppserviceConfig{ListenAddr: a.cfg.Appservice.ListenAddr, HSToken: a.registration.ServerToken})
+	}
+	a.client = client
+
+	// With several accounts, each outbox worker only retries messages for
+	// its own rooms, and each service only runs its own rooms' watches and
+	// reminders.
+	var owns func(id.RoomID) bool
+	if len(cfg.Accounts) > 0 {
+		owns = rooms.Allowed
+	}
+	a.queue, err = outbox.New(outbox.Config{
+		Interval: cfg.OutboxRetryInterval(),
+		MaxAge:   cfg.OutboxMaxAge(),
+		Owns:     owns,
+	}, outbox.Deps{
+		Store:  deps.store,
+		Sender: client,
+		Logger: deps.logger,
+	})
+	if err != nil {
+		return fmt.Errorf("create outbox: %w", err)
+	}
+
+	store := deps.store
+	a.handler.svc, err = bot.NewService(bot.Config{
+		BotUserID:                id.UserID(a.cfg.UserID),
+		BotDisplayName:           a.cfg.BotDisplayName,
+		CommandPrefix:            cfg.Bot.CommandPrefix,
+		SearchCommand:            deps.searchCommand,
+		CommandTypos:             cfg.Bot.CommandTypos,
+		MaxResults:               cfg.Bot.MaxResults,
+		ResultPages:              cfg.Bot.ResultPages,
+		ResultReactions:          cfg.Bot.ResultReactions,
+		MaxQueryLen:              cfg.Bot.MaxQueryLen,
+		ReplyMode:                cfg.Bot.ReplyMode,
+		SearchCooldown:           limit(cfg.Bot.Cooldowns.Search),
+		SearchCacheTTL:           cfg.SearchCacheTTL(),
+		CatchMeUpCooldown:        limit(cfg.Bot.Cooldowns.CatchMeUp),
+		Admins:                   userIDs(cfg.Bot.Admins),
+		BroadcastRooms:           roomIDs(a.cfg.AllowedRoomIDs),
+		BroadcastRoomLimit:       limit(cfg.Bot.BroadcastRoomLimit),
+		IgnorePrefixes:           cfg.Bot.IgnorePrefixes,
+		CustomTriggers:           deps.triggers,
+		IndexReaction:            cfg.Bot.IndexReaction,
+		IndexedReaction:          cfg.Bot.IndexedReaction,
+		AckIndexing:              cfg.Bot.AckReactions,
+		FailedReaction:           cfg.Bot.FailedReaction,
+		MaxAutoIndexURLs:         cfg.Bot.MaxAutoIndexURLs,
+		RecrawlAfter:             cfg.RecrawlAfter(),
+		BulkIndexConfirmReaction: cfg.Bot.BulkIndexConfirmReaction,
+		URLPreviews:              cfg.Bot.URLPreviews,
+		IndexSources:             cfg.Bot.IndexSources,
+		IndexFiles:               cfg.Bot.IndexFiles,
+		IndexSenders:             bot.SenderFilter{Allow: cfg.Bot.IndexSenders.Allow, Deny: cfg.Bot.IndexSenders.Deny},
+		IgnoreBots:               bot.BotFilter{Notices: cfg.Bot.IgnoreBots.Notices, KnownBots: cfg.Bot.IgnoreBots.KnownBots, Users: cfg.Bot.IgnoreBots.Users},
+		IndexFailureNotices:      cfg.Bot.IndexFailureNotices,
+		BackfillDepth:            cfg.Bot.Backfill.Depth,
+		BackfillOnJoin:           cfg.Bot.Backfill.OnJoin,
+		WelcomeOnJoin:            cfg.Bot.WelcomeOnJoin,
+		WatchInterval:            cfg.WatchInterval(),
+		WatchSchedule:            deps.watchSchedule,
+		MaxWatchesPerUser:        cfg.Watch.MaxPerUser,
+		OwnsRoom:                 owns,
+		DeadLinkAction:           cfg.DeadLinks.Action,
+		CatchMeUpHistory:         cfg.Bot.CatchMeUp.History,
+		RoomCatchMeUpHistory:     roomModes(cfg.Bot.CatchMeUp.RoomHistory),
+		CatchMeUpLimits:          catchMeUpLimits(cfg.Summary.SummaryLimits),
+		RoomCatchMeUpLimits:      roomCatchMeUpLimits(cfg),
+		SpoilerRooms:             roomIDs(cfg.Bot.Spoilers.RoomIDs),
+		SensitiveDomains:         cfg.Bot.SensitiveDomains(),
+		ChatSearchRooms:          roomIDs(cfg.Bot.ChatSearch.RoomIDs),
+		IndexSummaries:           cfg.Summary.Index,
+		Templates:                deps.templates,
+		Version:                  deps.version,
+		Messages:                 deps.catalog,
+	}, bot.Deps{
+		Backend:       deps.backend,
+		Parser:        triggers.NewParser(deps.searchCommand),
+		Replier:       a.queue,
+		History:       client,
+		Summarizer:    deps.summarizer,
+		Messages:      client,
+		Reactor:       client,
+		Links:         store,
+		State:         store,
+		Watches:       store,
+		Reminders:     store,
+		DeadLinks:     store,
+		Searches:      store,
+		Threads:       store,
+		Documents:     store,
+		RoomDocuments: store,
+		Redactions:    store,
+		IndexLog:      store,
+		Keys:          client,
+		Files:         client,
+		Visibility:    client,
+		Direct:        client,
+		Logger:        deps.logger,
+	})
+	return err
+}
+
+// run starts the workers of the account and its sync loop, which reports
+// its result on done.
+func (a *account) run(ctx context.Context, guard *recovery.Guard, done chan<- error) {
+	name := func(worker string) string {
+		return fmt.Sprintf("%s user=%s", worker, a.cfg.UserID)
+	}
+	guard.Go(name("outbox worker"), func() { a.queue.Run(ctx) })
+	guard.Go(name("backfill worker"), func() { a.handler.svc.RunBackfills(ctx) })
+	guard.Go(name("watch checker"), func() { a.handler.svc.RunWatches(ctx) })
+	guard.Go(name("reminder scheduler"), func() { a.handler.svc.RunReminders(ctx) })
+	go func() { done <- a.client.Start(ctx) }()
+}
+
+// lastSync is the oldest last sync of accounts, or zero until every account
+// completed its first sync, so health checks fail when any account stalls.
+func lastSync(accounts []*account) func() time.Time {
+	return func() time.Time {
+		var oldest time.Time
+		for _, a := range accounts {
+			last := a.client.LastSync()
+			if last.IsZero() {
+				return time.Time{}
+			}
+			if oldest.IsZero() || last.Before(oldest) {
+				oldest = last
+			}
+		}
+		return oldest
+	}
+}
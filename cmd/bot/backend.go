@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/config"
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/logging"
+	"github.com/gotlou/hister-element-bot/bot/internal/meili"
+)
+
+// searchBackend is the configured search index, as used by run: the bot's
+// backend, the reindex job's Index, and the readiness check.
+type searchBackend interface {
+	hister.SearchBackend
+	IndexContent(ctx context.Context, rawURL string, content extractor.Result) error
+	Healthy(ctx context.Context) error
+	CloseIdleConnections()
+}
+
+// newBackend creates the client of cfg.Backend and checks that it answers.
+// Configuration the backend rejects stops the bot, since it will not fix
+// itself; the backend merely being down is left to retries and the readiness
+// check. onIndexed is called after each link is indexed.
+func newBackend(
+	ctx context.Context,
+	cfg *config.Config,
+	extract func(ctx context.Context, rawURL string) (extractor.Result, error),
+	onIndexed func(ctx context.Context, rawURL string, content extractor.Result),
+	logger *logging.Logger,
+) (searchBackend, error) {
+	if cfg.Backend == config.BackendMeilisearch {
+		return newMeilisearch(ctx, cfg, extract, onIndexed, logger)
+	}
+	backend, err := hister.NewClient(cfg.Hister.BaseURL, cfg.RequestTimeout(), func(c *hister.Client) {
+		c.AddPath = cfg.Hister.AddPath
+		c.SearchPath = cfg.Hister.SearchWSPath
+		c.DeletePath = cfg.Hister.DeletePath
+		c.HealthPath = cfg.Hister.HealthPath
+		c.BatchAddPath = cfg.Hister.BatchAddPath
+		c.BatchSize = cfg.Hister.BatchSize
+		c.SearchTransport = cfg.Hister.SearchTransport
+		c.SearchHTTPPath = cfg.Hister.SearchHTTPPath
+		c.ReuseConnections = cfg.Hister.ReuseConnections
+		c.MaxIdleConns = cfg.Hister.MaxIdleConns
+		c.IdleConnTimeout = time.Duration(cfg.Hister.IdleConnTimeoutMS) * time.Millisecond
+		c.BreakerThreshold = cfg.Hister.Breaker.Failures
+		c.BreakerCooldown = time.Duration(cfg.Hister.Breaker.CooldownMS) * time.Millisecond
+		c.PingInterval = time.Duration(cfg.Hister.PingIntervalMS) * time.Millisecond
+		c.PongTimeout = time.Duration(cfg.Hister.PongTimeoutMS) * time.Millisecond
+		c.GzipMinBytes = cfg.Hister.GzipMinBytes
+		c.SearchToken = cfg.Hister.SearchAuth.SearchToken()
+		c.SearchTokenHeader = cfg.Hister.SearchAuth.Header
+		c.SearchTokenParam = cfg.Hister.SearchAuth.QueryParam
+		c.Logger = logger
+		c.Extract = extract
+		c.OnIndexed = onIndexed
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create hister client: %w", err)
+	}
+	// A wrong base URL or health path will not fix itself.
+	if err := backend.Healthy(ctx); err != nil {
+		if hister.IsMisconfigured(err) {
+			backend.CloseIdleConnections()
+			return nil, fmt.Errorf("hister at %s: %w", cfg.Hister.BaseURL, err)
+		}
+		logger.Warnf("%v; continuing", err)
+	}
+	if cfg.Hister.Namespaces.Enabled() {
+		return roomNamespaces{Client: backend, cfg: cfg.Hister.Namespaces}, nil
+	}
+	return backend, nil
+}
+
+func newMeilisearch(
+	ctx context.Context,
+	cfg *config.Config,
+	extract func(ctx context.Context, rawURL string) (extractor.Result, error),
+	onIndexed func(ctx context.Context, rawURL string, content extractor.Result),
+	logger *logging.Logger,
+) (searchBackend, error) {
+	backend, err := meili.NewClient(cfg.Meilisearch.URL, cfg.RequestTimeout(), func(c *meili.Client) {
+		c.APIKey = cfg.Meilisearch.Key()
+		c.Index = cfg.Meilisearch.Index
+		c.Logger = logger
+		c.Extract = extract
+		c.OnIndexed = onIndexed
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create meilisearch client: %w", err)
+	}
+	// Setup is the first request that needs the API key, so a wrong key or
+	// URL shows up here.
+	if err := backend.Setup(ctx); err != nil {
+		if hister.IsMisconfigured(err) {
+			backend.CloseIdleConnections()
+			return nil, fmt.Errorf("meilisearch at %s: %w", cfg.Meilisearch.URL, err)
+		}
+		logger.Warnf("%v; continuing", err)
+	}
+	return backend, nil
+}
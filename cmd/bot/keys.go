@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/config"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+// keyPassphraseEnv holds the passphrase for export-keys and import-keys;
+// without it the passphrase is read from the first line of stdin.
+const keyPassphraseEnv = "MATRIX_BOT_KEY_PASSPHRASE"
+
+// runKeys exports the bot's megolm room keys to a file, or imports them from
+// one, in the encrypted key export format Matrix clients use. Stop the bot
+// first: it keeps the crypto store open.
+func runKeys(configPath, command string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s <file>", command)
+	}
+	path := args[0]
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	passphrase, err := keyPassphrase(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	store, err := storage.Open(cfg.Storage.StateDBPath, cfg.Storage.CryptoDBPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	mx, err := matrix.BuildMautrixClient(ctx, matrix.Config{
+		HomeserverURL: cfg.Matrix.HomeserverURL,
+		UserID:        id.UserID(cfg.Matrix.UserID),
+		AccessToken:   cfg.Matrix.AccessToken,
+		Password:      cfg.Matrix.Password,
+		RefreshToken:  cfg.Matrix.RefreshToken,
+		OIDC:          matrix.OIDCConfig{TokenEndpoint: cfg.Matrix.OIDC.TokenEndpoint, ClientID: cfg.Matrix.OIDC.ClientID},
+		DeviceID:      id.DeviceID(cfg.Matrix.DeviceID),
+	}, matrix.Stores{SyncStore: store, Credentials: store})
+	if err != nil {
+		return err
+	}
+	crypto, err := matrix.NewCryptoHelper(ctx, mx, store.CryptoDB, pickleKey(cfg.Matrix))
+	if err != nil {
+		return err
+	}
+	defer crypto.Close()
+
+	if command == "export-keys" {
+		data, count, err := matrix.ExportRoomKeys(ctx, crypto.Machine(), passphrase)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return fmt.Errorf("write key export: %w", err)
+		}
+		log.Printf("exported %d room keys to %s", count, path)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read key export: %w", err)
+	}
+	imported, total, err := matrix.ImportRoomKeys(ctx, crypto.Machine(), passphrase, data)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %d new room keys (%d in %s)", imported, total, path)
+	return nil
+}
+
+func keyPassphrase(stdin io.Reader) (string, error) {
+	if passphrase := os.Getenv(keyPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase is required: set %s or pass it on stdin", keyPassphraseEnv)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/bot"
+	"github.com/gotlou/hister-element-bot/bot/internal/config"
+	"github.com/gotlou/hister-element-bot/bot/internal/deadlinks"
+	"github.com/gotlou/hister-element-bot/bot/internal/digest"
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/health"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/llm"
+	"github.com/gotlou/hister-element-bot/bot/internal/logging"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/outbox"
+	"github.com/gotlou/hister-element-bot/bot/internal/recovery"
+	"github.com/gotlou/hister-element-bot/bot/internal/reindex"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/retention"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"github.com/gotlou/hister-element-bot/bot/internal/systemd"
+	"github.com/gotlou/hister-element-bot/bot/internal/toplinks"
+	"github.com/gotlou/hister-element-bot/bot/internal/version"
+	openai "github.com/openai/openai-go/v2"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("MATRIX_BOT_CONFIG"), "path to the YAML config file (default $MATRIX_BOT_CONFIG)")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+	if *configPath == "" {
+		log.Fatal("config path is required: pass -config or set MATRIX_BOT_CONFIG")
+	}
+
+	var err error
+	switch command := flag.Arg(0); command {
+	case "":
+		err = run(*configPath)
+	case "export-keys", "import-keys":
+		err = runKeys(*configPath, command, flag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command %q: want export-keys or import-keys", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	logs, err := cfg.Logging.Loggers(log.Default())
+	if err != nil {
+		return err
+	}
+	logger := logs.For(logging.Bot)
+	llm.SetLogger(logs.For(logging.LLM))
+	build := version.Get()
+	logger.Printf("hister-matrix-bot %s", build)
+	guard := &recovery.Guard{Logger: logger}
+	if cfg.ErrorReporting.WebhookURL != "" {
+		guard.Reporter = &recovery.Webhook{
+			URL:        cfg.ErrorReporting.WebhookURL,
+			HTTPClient: &http.Client{Timeout: cfg.RequestTimeout()},
+		}
+	}
+
+	// The root context is cancelled on SIGINT/SIGTERM. Sync stops at once;
+	// message handlers run on a context detached from it, so in-flight
+	// messages finish (and their replies are sent) before run returns.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := storage.Open(cfg.Storage.StateDBPath, cfg.Storage.CryptoDBPath)
+	if err != nil {
+		return err
+	}
+	storeLog := logs.For(logging.Storage)
+	storeLog.Debugf("opened state=%s crypto=%s", cfg.Storage.StateDBPath, cfg.Storage.CryptoDBPath)
+	defer func() {
+		if err := store.Close(); err != nil {
+			storeLog.Errorf("close storage: %v", err)
+		}
+	}()
+
+	matrixLog := logs.For(logging.Matrix)
+	var accounts []*account
+	defer func() {
+		for _, a := range accounts {
+			a.close(logger)
+		}
+	}()
+	for _, acct := range append([]config.MatrixConfig{cfg.Matrix}, cfg.Accounts...) {
+		a, err := connectAccount(ctx, acct, store, matrixLog)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", acct.UserID, err)
+		}
+		accounts = append(accounts, a)
+	}
+	primary := accounts[0]
+
+	if aliases := cfg.RoomAliases(); len(aliases) > 0 {
+		resolved, err := matrix.ResolveRoomAliases(ctx, primary.mx, store, aliases)
+		if err != nil {
+			return err
+		}
+		rooms := make(map[string]string, len(resolved))
+		for alias, roomID := range resolved {
+			matrixLog.Printf("resolved room alias %s to %s", alias, roomID)
+			rooms[alias] = string(roomID)
+		}
+		if err := cfg.ResolveRoomAliases(rooms); err != nil {
+			return err
+		}
+		primary.cfg = cfg.Matrix
+		for i, a := range accounts[1:] {
+			a.cfg = cfg.Accounts[i]
+		}
+	}
+
+	extractLog := logs.For(logging.Extractor)
+	fetchClient := &http.Client{Timeout: cfg.RequestTimeout(), Transport: cfg.HTTP.PageHeaders().Transport(nil)}
+	extract := func(ctx context.Context, rawURL string, since extractor.Validators) (extractor.Result, error) {
+		res, err := extractor.ExtractIfModified(ctx, fetchClient, rawURL, since)
+		switch {
+		case errors.Is(err, extractor.ErrNotModified):
+			extractLog.Debugf("extract url=%s not modified", rawURL)
+		case err != nil:
+			extractLog.Warnf("extract url=%s err=%v", rawURL, err)
+		default:
+			extractLog.Debugf("extract url=%s title=%q text_chars=%d", rawURL, res.Title, len(res.Text))
+		}
+		return res, err
+	}
+	var refresher *reindex.Job
+	backend, err := newBackend(ctx, cfg, func(ctx context.Context, rawURL string) (extractor.Result, error) {
+		return extract(ctx, rawURL, extractor.Validators{})
+	}, func(ctx context.Context, rawURL string, content extractor.Result) {
+		if refresher != nil {
+			refresher.Record(ctx, rawURL, content)
+		}
+	}, logs.For(logging.Hister))
+	if err != nil {
+		return err
+	}
+	defer backend.CloseIdleConnections()
+	indexRefreshed := backend.IndexContent
+	if namespaces, ok := backend.(roomNamespaces); ok {
+		indexRefreshed = namespaces.reindex(store)
+	}
+
+	if cfg.ReindexMaxAge() > 0 {
+		reindexSchedule, err := cfg.ReindexSchedule()
+		if err != nil {
+			return fmt.Errorf("reindex.schedule: %w", err)
+		}
+		refresher, err = reindex.New(reindex.Config{
+			MaxAge:    cfg.ReindexMaxAge(),
+			Interval:  cfg.ReindexInterval(),
+			Schedule:  reindexSchedule,
+			BatchSize: cfg.Reindex.BatchSize,
+		}, reindex.Deps{
+			Store:   store,
+			Extract: extract,
+			Index:   indexRefreshed,
+			Logger:  logs.For(logging.Hister),
+		})
+		if err != nil {
+			return fmt.Errorf("create reindex job: %w", err)
+		}
+	}
+
+	var llmClient *openai.Client
+	var summarizer bot.Summarizer
+	if os.Getenv("OPENAI_API_KEY") != "" {
+		client := llm.InitLLM()
+		llmClient = &client
+		summarizer = matrix.NewBucketedSummarizer(client, func(roomID id.RoomID) matrix.SummaryBuckets {
+			limits := cfg.SummaryLimitsFor(string(roomID))
+			return matrix.SummaryBuckets{Gap: limits.BucketGap(), MaxMessages: limits.BucketMaxMessages}
+		})
+	} else {
+		logger.Printf("OPENAI_API_KEY not set; /catchmeup is disabled")
+	}
+
+	catalog, err := cfg.I18n.Catalog()
+	if err != nil {
+		return err
+	}
+	templates, err := cfg.Templates.Compile()
+	if err != nil {
+		return fmt.Errorf("templates: %w", err)
+	}
+	customTriggers, err := cfg.Bot.CompileCustomTriggers()
+	if err != nil {
+		return err
+	}
+	searchCommand := cfg.Bot.Command(cfg.Bot.SearchCommand)
+	watchSchedule, err := cfg.WatchSchedule()
+	if err != nil {
+		return fmt.Errorf("watch.schedule: %w", err)
+	}
+	deps := accountDeps{
+		store:         store,
+		backend:       backend,
+		summarizer:    summarizer,
+		catalog:       catalog,
+		templates:     templates,
+		triggers:      customTriggers,
+		searchCommand: searchCommand,
+		watchSchedule: watchSchedule,
+		version:       build.String(),
+		guard:         guard,
+		matrixLog:     matrixLog,
+		logger:        logger,
+	}
+	for _, a := range accounts {
+		if err := a.start(ctx, cfg, deps); err != nil {
+			return fmt.Errorf("account %s: %w", a.cfg.UserID, err)
+		}
+	}
+	// Scheduled reports are posted by the primary account.
+	client, queue := primary.client, primary.queue
+
+	var linkChecker *deadlinks.Job
+	if cfg.DeadLinksMaxAge() > 0 {
+		deadLinksSchedule, err := cfg.DeadLinksSchedule()
+		if err != nil {
+			return fmt.Errorf("dead_links.schedule: %w", err)
+		}
+		linkChecker, err = deadlinks.New(deadlinks.Config{
+			MaxAge:     cfg.DeadLinksMaxAge(),
+			Interval:   cfg.DeadLinksInterval(),
+			Schedule:   deadLinksSchedule,
+			BatchSize:  cfg.DeadLinks.BatchSize,
+			ReportRoom: id.RoomID(cfg.DeadLinks.ReportRoomID),
+			Messages:   catalog,
+		}, deadlinks.Deps{
+			Store: store,
+			Check: func(ctx context.Context, rawURL string) (string, bool, error) {
+				return deadlinks.Check(ctx, fetchClient, rawURL)
+			},
+			Poster: queue,
+			Logger: logs.For(logging.Hister),
+		})
+		if err != nil {
+			return fmt.Errorf("create dead link checker: %w", err)
+		}
+	}
+
+	var expirer *retention.Job
+	if len(cfg.Retention.Rooms) > 0 {
+		retentionSchedule, err := cfg.RetentionSchedule()
+		if err != nil {
+			return fmt.Errorf("retention.schedule: %w", err)
+		}
+		deps := retention.Deps{Store: store, Logger: logger}
+		if deleter, ok := backend.(retention.Deleter); ok {
+			deps.Deleter = deleter
+		}
+		expirer, err = retention.New(retention.Config{
+			Rooms:    retentionPeriods(cfg.Retention.Rooms),
+			Interval: cfg.RetentionInterval(),
+			Schedule: retentionSchedule,
+		}, deps)
+		if err != nil {
+			return fmt.Errorf("create retention job: %w", err)
+		}
+	}
+
+	syncMonitor := health.NewSyncMonitor(lastSync(accounts), cfg.MaxSyncAge())
+	var healthServer *http.Server
+	if cfg.Health.ListenAddr != "" {
+		healthServer = startHealthServer(cfg, syncMonitor, store, backend, llmClient, guard, logger)
+	}
+
+	var pprofServer *http.Server
+	if cfg.Debug.PprofAddr != "" {
+		pprofServer = startPprofServer(cfg.Debug.PprofAddr, guard, logger)
+	}
+
+	if len(cfg.Digest.Rooms) > 0 {
+		if summarizer == nil {
+			logger.Printf("OPENAI_API_KEY not set; daily digests are disabled")
+		} else if err := startDigests(ctx, cfg, client, backend, queue, summarizer, store, catalog, templates, guard, logger); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.TopLinks.RoomIDs) > 0 {
+		if err := startTopLinks(ctx, cfg, queue, llmClient, store, catalog, guard, logger); err != nil {
+			return err
+		}
+	}
+
+	if refresher != nil {
+		guard.Go("reindex job", func() { refresher.Run(ctx) })
+	}
+	if linkChecker != nil {
+		guard.Go("dead link checker", func() { linkChecker.Run(ctx) })
+	}
+	if expirer != nil {
+		guard.Go("retention job", func() { expirer.Run(ctx) })
+	}
+
+	syncDone := make(chan error, len(accounts))
+	for _, a := range accounts {
+		logger.Printf("bot started user=%s rooms=%d", a.cfg.UserID, len(a.cfg.AllowedRoomIDs))
+		a.run(ctx, guard, syncDone)
+	}
+
+	// Under a Type=notify unit, report readiness after the first sync and
+	// ping the watchdog only while sync keeps making progress.
+	notifier := systemd.NewNotifier()
+	guard.Go("systemd notifier", func() {
+		notifier.Run(ctx, func() bool { return !lastSync(accounts)().IsZero() }, syncMonitor.Live, logger.Printf)
+	})
+
+	// When one account's sync fails, all of them shut down.
+	var syncErr error
+	running := len(accounts)
+	select {
+	case syncErr = <-syncDone:
+		// Sync failed on its own; shut down the rest as usual.
+		running--
+		stop()
+	case <-ctx.Done():
+		logger.Printf("shutdown requested; draining in-flight messages (timeout %s)", cfg.ShutdownTimeout())
+	}
+	for _, a := range accounts {
+		a.client.Stop()
+	}
+	timeout := time.After(cfg.ShutdownTimeout())
+	for ; running > 0; running-- {
+		select {
+		case err := <-syncDone:
+			syncErr = errors.Join(syncErr, err)
+		case <-timeout:
+			logger.Printf("shutdown timed out; closing with handlers still running")
+			running = 0
+		}
+	}
+	stop()
+	if err := notifier.Notify("STOPPING=1"); err != nil {
+		logger.Printf("systemd stopping notification failed: %v", err)
+	}
+
+	shutdownServer(healthServer, "health", cfg.ShutdownTimeout(), logger)
+	shutdownServer(pprofServer, "pprof", cfg.ShutdownTimeout(), logger)
+	logger.Printf("bot stopped")
+	return syncErr
+}
+
+// serviceHandler breaks the construction cycle between the Matrix client,
+// which needs a handler, and the bot service, which replies through the
+// client.
+type serviceHandler struct {
+	svc *bot.Service
+}
+
+// The Matrix client only subscribes to the events its handler handles, so a
+// missing method would silently drop them.
+var (
+	_ matrix.MessageHandler   = (*serviceHandler)(nil)
+	_ matrix.ReactionHandler  = (*serviceHandler)(nil)
+	_ matrix.FileHandler      = (*serviceHandler)(nil)
+	_ matrix.RedactionHandler = (*serviceHandler)(nil)
+	_ matrix.JoinHandler      = (*serviceHandler)(nil)
+)
+
+// digestPoster posts digests through the outbox and pins them with the
+// client. A digest that had to be queued is posted later but not pinned.
+// Attached digests are uploaded by the client directly.
+type digestPoster struct {
+	*outbox.Queue
+	client *matrix.Client
+}
+
+func (p digestPoster) SendFile(ctx context.Context, roomID id.RoomID, file matrix.File) (id.EventID, error) {
+	return p.client.SendFile(ctx, roomID, file)
+}
+
+func (p digestPoster) PinEvent(ctx context.Context, roomID id.RoomID, eventID, unpin id.EventID) error {
+	return p.client.PinEvent(ctx, roomID, eventID, unpin)
+}
+
+func (h *serviceHandler) HandleMatrixMessage(ctx context.Context, msg matrix.Message) error {
+	return h.svc.HandleMatrixMessage(ctx, msg)
+}
+
+func (h *serviceHandler) HandleMatrixReaction(ctx context.Context, reaction matrix.Reaction) error {
+	return h.svc.HandleMatrixReaction(ctx, reaction)
+}
+
+func (h *serviceHandler) HandleMatrixFile(ctx context.Context, file matrix.FileMessage) error {
+	return h.svc.HandleMatrixFile(ctx, file)
+}
+
+func (h *serviceHandler) HandleMatrixJoin(ctx context.Context, roomID id.RoomID) error {
+	return h.svc.HandleMatrixJoin(ctx, roomID)
+}
+
+func (h *serviceHandler) HandleMatrixRedaction(ctx context.Context, redaction matrix.Redaction) error {
+	return h.svc.HandleMatrixRedaction(ctx, redaction)
+}
+
+func startHealthServer(
+	cfg *config.Config,
+	sync *health.SyncMonitor,
+	store *storage.Store,
+	backend searchBackend,
+	llmClient *openai.Client,
+	guard *recovery.Guard,
+	logger *logging.Logger,
+) *http.Server {
+	readiness := []health.Check{
+		{Name: "sync", Run: sync.Ready},
+		{Name: "storage", Run: store.Ping},
+		{Name: cfg.Backend, Run: backend.Healthy},
+	}
+	if llmClient != nil {
+		readiness = append(readiness, health.Check{Name: "llm", Run: func(ctx context.Context) error {
+			return llm.Ping(ctx, *llmClient)
+		}})
+	}
+
+	server := &http.Server{
+		Addr: cfg.Health.ListenAddr,
+		Handler: &health.Handler{
+			Liveness:  []health.Check{{Name: "sync", Run: sync.Live}},
+			Readiness: readiness,
+			Timeout:   cfg.RequestTimeout(),
+		},
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	guard.Go("health server", func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("health server failed: %v", err)
+		}
+	})
+	logger.Printf("health endpoints listening on %s", cfg.Health.ListenAddr)
+	return server
+}
+
+// startDigests runs the daily digest scheduler until ctx is cancelled.
+func startDigests(
+	ctx context.Context,
+	cfg *config.Config,
+	client *matrix.Client,
+	backend hister.SearchBackend,
+	queue *outbox.Queue,
+	summarizer bot.Summarizer,
+	store *storage.Store,
+	catalog *i18n.Catalog,
+	templates *render.Templates,
+	guard *recovery.Guard,
+	logger *logging.Logger,
+) error {
+	loc, err := cfg.Digest.Location()
+	if err != nil {
+		return fmt.Errorf("digest.timezone: %w", err)
+	}
+	rooms := make([]digest.Room, 0, len(cfg.Digest.Rooms))
+	for _, room := range cfg.Digest.Rooms {
+		plan, err := room.CronSchedule(loc)
+		if err != nil {
+			return fmt.Errorf("digest room %s: %w", room.RoomID, err)
+		}
+		var at time.Duration
+		if plan == nil {
+			if at, err = room.At(); err != nil {
+				return fmt.Errorf("digest room %s: %w", room.RoomID, err)
+			}
+		}
+		rooms = append(rooms, digest.Room{RoomID: id.RoomID(room.RoomID), At: at, Schedule: plan, Pin: room.Pin})
+	}
+	var indexer digest.Indexer
+	if cfg.Summary.Index {
+		indexer, _ = backend.(digest.Indexer)
+	}
+
+	scheduler, err := digest.New(digest.Config{
+		Rooms:       rooms,
+		Location:    loc,
+		MaxMessages: cfg.Digest.MaxMessages,
+		BotUserID:   id.UserID(cfg.Matrix.UserID),
+		Messages:    catalog,
+		Templates:   templates,
+		AttachOver:  cfg.Digest.AttachOverChars,
+	}, digest.Deps{
+		History:       client,
+		Summarizer:    summarizer,
+		Poster:        digestPoster{Queue: queue, client: client},
+		State:         store,
+		Indexer:       indexer,
+		RoomDocuments: store,
+		Logger:        logger,
+	})
+	if err != nil {
+		return fmt.Errorf("create digest scheduler: %w", err)
+	}
+	guard.Go("digest scheduler", func() { scheduler.Run(ctx) })
+	return nil
+}
+
+func startTopLinks(
+	ctx context.Context,
+	cfg *config.Config,
+	queue *outbox.Queue,
+	llmClient *openai.Client,
+	store *storage.Store,
+	catalog *i18n.Catalog,
+	guard *recovery.Guard,
+	logger *logging.Logger,
+) error {
+	loc, err := cfg.TopLinks.Location()
+	if err != nil {
+		return fmt.Errorf("top_links.timezone: %w", err)
+	}
+	plan, err := cfg.TopLinks.CronSchedule()
+	if err != nil {
+		return fmt.Errorf("top_links: %w", err)
+	}
+	var intro func(ctx context.Context, links string) (string, error)
+	if cfg.TopLinks.Intro {
+		if llmClient == nil {
+			logger.Printf("OPENAI_API_KEY not set; top links reports are posted without an intro")
+		} else {
+			intro = func(ctx context.Context, links string) (string, error) {
+				return llm.WriteTopLinksIntro(ctx, *llmClient, links)
+			}
+		}
+	}
+
+	reporter, err := toplinks.New(toplinks.Config{
+		Rooms:    roomIDs(cfg.TopLinks.RoomIDs),
+		Schedule: plan,
+		Location: loc,
+		Limit:    cfg.TopLinks.Limit,
+		Messages: catalog,
+	}, toplinks.Deps{
+		Store:  store,
+		Poster: queue,
+		Intro:  intro,
+		Logger: logger,
+	})
+	if err != nil {
+		return fmt.Errorf("create top links reporter: %w", err)
+	}
+	guard.Go("top links reporter", func() { reporter.Run(ctx) })
+	return nil
+}
+
+// startPprofServer serves net/http/pprof on its own mux so the profiling
+// handlers are never exposed on another listener.
+func startPprofServer(addr string, guard *recovery.Guard, logger *logging.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	guard.Go("pprof server", func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("pprof server failed: %v", err)
+		}
+	})
+	logger.Printf("pprof listening on %s", addr)
+	return server
+}
+
+func shutdownServer(server *http.Server, name string, timeout time.Duration, logger *logging.Logger) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Printf("shutdown %s server: %v", name, err)
+	}
+}
+
+// loadRegistration reads an appservice registration file and checks that
+// userID is its sender.
+func loadRegistration(path, userID string) (*appservice.Registration, error) {
+	registration, err := appservice.LoadRegistration(path)
+	if err != nil {
+		return nil, fmt.Errorf("load appservice registration: %w", err)
+	}
+	if registration.AppToken == "" || registration.ServerToken == "" {
+		return nil, errors.New("appservice registration must set as_token and hs_token")
+	}
+	localpart, _, err := id.UserID(userID).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("matrix.user_id: %w", err)
+	}
+	if localpart != registration.SenderLocalpart {
+		return nil, fmt.Errorf("matrix.user_id %s does not match appservice sender_localpart %q", userID, registration.SenderLocalpart)
+	}
+	return registration, nil
+}
+
+// pickleKey returns MATRIX_PICKLE_KEY, or a key derived from the configured
+// access token. Without one the key is derived from the password or the
+// configured refresh token, since the saved access token changes whenever
+// the bot logs in again or refreshes it.
+func pickleKey(cfg config.MatrixConfig) []byte {
+	if key := os.Getenv("MATRIX_PICKLE_KEY"); key != "" {
+		return []byte(key)
+	}
+	secret := cfg.AccessToken
+	if secret == "" {
+		secret = cfg.Password
+	}
+	if secret == "" {
+		secret = cfg.RefreshToken
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func limit(r config.RateLimitConfig) bot.Limit {
+	return bot.Limit{Max: r.Max, Window: r.Window()}
+}
+
+func userIDs(raw []string) []id.UserID {
+	out := make([]id.UserID, 0, len(raw))
+	for _, v := range raw {
+		out = append(out, id.UserID(v))
+	}
+	return out
+}
+
+// messageTypes maps the configured "notice" and "text" message types to
+// their Matrix msgtypes.
+func messageTypes(cfg config.BotConfig) matrix.MessageTypes {
+	msgType := func(name string) event.MessageType {
+		if name == "text" {
+			return event.MsgText
+		}
+		return event.MsgNotice
+	}
+	types := matrix.MessageTypes{Default: msgType(cfg.MessageType), Kinds: make(map[matrix.ReplyKind]event.MessageType, len(cfg.MessageTypes))}
+	for kind, name := range cfg.MessageTypes {
+		types.Kinds[matrix.ReplyKind(kind)] = msgType(name)
+	}
+	return types
+}
+
+func roomModes(raw map[string]string) map[id.RoomID]string {
+	out := make(map[id.RoomID]string, len(raw))
+	for roomID, mode := range raw {
+		out[id.RoomID(roomID)] = mode
+	}
+	return out
+}
+
+func retentionPeriods(raw map[string]config.RoomRetention) map[id.RoomID]time.Duration {
+	out := make(map[id.RoomID]time.Duration, len(raw))
+	for roomID, room := range raw {
+		out[id.RoomID(roomID)] = time.Duration(room.MaxAgeMS) * time.Millisecond
+	}
+	return out
+}
+
+func catchMeUpLimits(limits config.SummaryLimits) bot.CatchMeUpLimits {
+	return bot.CatchMeUpLimits{Window: limits.Window(), MaxMessages: limits.MaxMessages}
+}
+
+func roomCatchMeUpLimits(cfg *config.Config) map[id.RoomID]bot.CatchMeUpLimits {
+	out := make(map[id.RoomID]bot.CatchMeUpLimits, len(cfg.Summary.Rooms))
+	for roomID := range cfg.Summary.Rooms {
+		out[id.RoomID(roomID)] = catchMeUpLimits(cfg.SummaryLimitsFor(roomID))
+	}
+	return out
+}
+
+func roomIDs(raw []string) []id.RoomID {
+	out := make([]id.RoomID, 0, len(raw))
+	for _, v := range raw {
+		out = append(out, id.RoomID(v))
+	}
+	return out
+}
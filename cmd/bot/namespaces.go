@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/config"
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"maunium.net/go/mautrix/id"
+)
+
+// roomNamespaces is the Hister client with each room's documents kept in the
+// namespace hister.namespaces gives it. The bot, retention and digests find
+// the room's namespace through ForRoom, DeleteRoomURL and IndexRoomContent.
+type roomNamespaces struct {
+	*hister.Client
+	cfg config.NamespacesConfig
+}
+
+func (r roomNamespaces) namespace(roomID id.RoomID) *hister.Namespaced {
+	return r.Client.Namespace(r.cfg.For(string(roomID)))
+}
+
+func (r roomNamespaces) ForRoom(roomID id.RoomID) hister.SearchBackend {
+	return r.namespace(roomID)
+}
+
+func (r roomNamespaces) IndexName(roomID id.RoomID) string {
+	return r.cfg.For(string(roomID))
+}
+
+func (r roomNamespaces) DeleteRoomURL(ctx context.Context, roomID id.RoomID, rawURL string) error {
+	return r.namespace(roomID).DeleteURL(ctx, rawURL)
+}
+
+func (r roomNamespaces) IndexRoomContent(ctx context.Context, roomID id.RoomID, rawURL string, content extractor.Result) error {
+	return r.namespace(roomID).IndexContent(ctx, rawURL, content)
+}
+
+// documentRooms lists the rooms that hold a document.
+type documentRooms interface {
+	DocumentRooms(ctx context.Context, rawURL string) ([]id.RoomID, error)
+}
+
+// reindex returns the reindex job's Index function: refreshed content goes
+// to the namespace of every room holding the URL, each namespace once, or to
+// the default namespace when no room does.
+func (r roomNamespaces) reindex(rooms documentRooms) func(context.Context, string, extractor.Result) error {
+	return func(ctx context.Context, rawURL string, content extractor.Result) error {
+		roomIDs, err := rooms.DocumentRooms(ctx, rawURL)
+		if err != nil {
+			return err
+		}
+		if len(roomIDs) == 0 {
+			return r.Client.IndexContent(ctx, rawURL, content)
+		}
+		seen := make(map[string]struct{}, len(roomIDs))
+		for _, roomID := range roomIDs {
+			name := r.cfg.For(string(roomID))
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			if err := r.Client.Namespace(name).IndexContent(ctx, rawURL, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	backfillCommand     = "/backfill"
+	backfillQueueSize   = 16
+	backfillStatePrefix = "backfilled:"
+	// backfillWindowMessages bounds how many past messages a backfill over
+	// a time window, such as "/backfill 90d", reads.
+	backfillWindowMessages = 10000
+)
+
+// StateStore persists which rooms were already backfilled, so rejoining or
+// restarting does not walk the same history again.
+type StateStore interface {
+	PutBotState(ctx context.Context, key, value string) error
+	GetBotState(ctx context.Context, key string) (string, error)
+}
+
+// BatchIndexer is implemented by search backends that can index many URLs
+// faster than one at a time. IndexURLs returns the error for each URL, nil
+// for those indexed. Backfills use it when available.
+type BatchIndexer interface {
+	IndexURLs(ctx context.Context, urls []string) []error
+}
+
+// backfillLink is a link found while backfilling and the message that
+// shared it first.
+type backfillLink struct {
+	url  string
+	msg  matrix.Message
+	tags []string
+	at   time.Time
+}
+
+// backfillRequest asks the backfill worker to index up to max past messages
+// of a room, back to since when it is set. When requested by a command, msg
+// is the message to reply to once it finishes.
+type backfillRequest struct {
+	roomID id.RoomID
+	since  time.Time
+	max    int
+	msg    *matrix.Message
+}
+
+// HandleMatrixJoin welcomes a newly joined room when WelcomeOnJoin is set,
+// and queues a history backfill for it when BackfillOnJoin is set. Either
+// happens only once per room.
+func (s *Service) HandleMatrixJoin(ctx context.Context, roomID id.RoomID) error {
+	s.welcome(ctx, roomID)
+	if !s.cfg.BackfillOnJoin || s.cfg.BackfillDepth <= 0 || s.history == nil {
+		return nil
+	}
+	if s.state != nil {
+		done, err := s.state.GetBotState(ctx, backfillStatePrefix+string(roomID))
+		if err != nil {
+			s.logf("backfill state lookup failed room=%s err=%v", roomID, err)
+		} else if done != "" {
+			return nil
+		}
+	}
+	if !s.enqueueBackfill(backfillRequest{roomID: roomID, max: s.cfg.BackfillDepth}) {
+		s.logf("backfill queue full, skipping room=%s", roomID)
+	}
+	return nil
+}
+
+// handleBackfill lets an admin backfill the room the command was sent in,
+// regardless of whether it was backfilled before. Without args it reads
+// BackfillDepth past messages; args such as "90d" read the messages of that
+// period instead, up to backfillWindowMessages.
+func (s *Service) handleBackfill(ctx context.Context, msg matrix.Message, args string) error {
+	if !s.isAdmin(msg.Sender) {
+		s.logf("ignoring backfill from non-admin room=%s event=%s sender=%s", msg.RoomID, msg.EventID, msg.Sender)
+		return nil
+	}
+	if s.cfg.BackfillDepth <= 0 || s.history == nil {
+		return nil
+	}
+	req := backfillRequest{roomID: msg.RoomID, max: s.cfg.BackfillDepth, msg: &msg}
+	started := s.text(msg, i18n.BackfillStarted, i18n.Vars{"depth": strconv.Itoa(s.cfg.BackfillDepth)})
+	if args = strings.TrimSpace(args); args != "" {
+		window, ok := triggers.ParseDuration(args)
+		if !ok {
+			return s.reply(ctx, msg, s.text(msg, i18n.BackfillUsage, i18n.Vars{"command": s.backfill}))
+		}
+		req.since, req.max = s.now().Add(-window), backfillWindowMessages
+		started = s.text(msg, i18n.BackfillWindow, i18n.Vars{"window": args})
+	}
+	if !s.enqueueBackfill(req) {
+		return s.reply(ctx, msg, s.text(msg, i18n.BackfillBusy, nil))
+	}
+	return s.reply(ctx, msg, started)
+}
+
+// indexBackfillLinks indexes links found in roomID in one batch if the
+// backend supports it, or else one by one, and returns the error for each.
+func (s *Service) indexBackfillLinks(ctx context.Context, roomID id.RoomID, links []backfillLink) []error {
+	urls := make([]string, len(links))
+	for i, link := range links {
+		urls[i] = link.url
+	}
+	backend := s.roomBackend(roomID)
+	if indexer, ok := backend.(SourceIndexer); ok && s.cfg.IndexSources && len(urls) > 0 {
+		sources := make([]hister.Source, len(links))
+		for i, link := range links {
+			sources[i] = linkSource(link.msg, link.at)
+		}
+		return indexer.IndexURLsFrom(ctx, urls, sources)
+	}
+	if batcher, ok := backend.(BatchIndexer); ok && len(urls) > 0 {
+		return batcher.IndexURLs(ctx, urls)
+	}
+	errs := make([]error, len(urls))
+	for i, rawURL := range urls {
+		if errs[i] = ctx.Err(); errs[i] == nil {
+			errs[i] = backend.IndexURL(ctx, rawURL)
+		}
+	}
+	return errs
+}
+
+func (s *Service) enqueueBackfill(req backfillRequest) bool {
+	select {
+	case s.backfills <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunBackfills processes queued backfills one at a time until ctx is done.
+func (s *Service) RunBackfills(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.backfills:
+			s.runBackfill(ctx, req)
+		}
+	}
+}
+
+// runBackfill indexes the URLs found in the past messages req asks for,
+// oldest first, attributing each link to the message that shared it.
+func (s *Service) runBackfill(ctx context.Context, req backfillRequest) {
+	history, err := s.history.GetRecentTextMessages(ctx, req.roomID, req.since, req.max)
+	if err != nil {
+		s.logf("backfill history failed room=%s err=%v", req.roomID, err)
+		return
+	}
+
+	seen := make(map[string]struct{})
+	var links []backfillLink
+	for i := len(history) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return
+		}
+		past := history[i]
+		body := strings.TrimSpace(past.Body)
+		if past.Sender == s.cfg.BotUserID || body == "" || s.ignored(body) || !s.cfg.IndexSenders.Allows(past.Sender) || s.cfg.IgnoreBots.IsBot(past.Sender, past.Notice) {
+			continue
+		}
+		tags := s.hashtags(body)
+		msg := matrix.Message{RoomID: req.roomID, EventID: past.EventID, Sender: past.Sender, Body: body}
+		for _, rawURL := range dedupe(s.parser.ExtractURLs(body)) {
+			if _, dup := seen[rawURL]; dup {
+				continue
+			}
+			seen[rawURL] = struct{}{}
+			links = append(links, backfillLink{url: rawURL, msg: msg, tags: tags, at: past.Timestamp})
+		}
+	}
+
+	errs := s.indexBackfillLinks(ctx, req.roomID, links)
+	if ctx.Err() != nil {
+		return
+	}
+	indexed, total := 0, len(links)
+	for i, link := range links {
+		if errs[i] != nil {
+			s.logf("backfill index failed room=%s event=%s url=%s err=%v", req.roomID, link.msg.EventID, link.url, errs[i])
+			continue
+		}
+		s.recordLinkAt(ctx, link.msg, link.url, link.tags, link.at)
+		indexed++
+	}
+	s.logf("backfill finished room=%s messages=%d indexed=%d of %d urls", req.roomID, len(history), indexed, total)
+
+	if s.state != nil {
+		if err := s.state.PutBotState(ctx, backfillStatePrefix+string(req.roomID), s.now().UTC().Format(time.RFC3339)); err != nil {
+			s.logf("backfill state save failed room=%s err=%v", req.roomID, err)
+		}
+	}
+	if req.msg != nil {
+		err := s.reply(ctx, *req.msg, s.text(*req.msg, i18n.BackfillDone, i18n.Vars{
+			"indexed": strconv.Itoa(indexed),
+			"total":   strconv.Itoa(total),
+		}))
+		if err != nil {
+			s.logf("backfill reply failed room=%s err=%v", req.roomID, err)
+		}
+	}
+}
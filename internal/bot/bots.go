@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// knownBotPatterns match the user IDs bots and bridge bots usually pick for
+// themselves, such as "@github-bot:example.org" or "@telegrambot_1:example.org".
+var knownBotPatterns = []string{"@*bot:*", "@*bot_*:*", "@*bot-*:*"}
+
+// BotFilter recognizes other bots in a room. Their messages are never
+// treated as triggers or indexed, so two bots cannot answer each other in a
+// loop.
+type BotFilter struct {
+	// Notices treats every m.notice as sent by a bot; the Matrix spec
+	// reserves notices for automated messages that bots must not answer.
+	Notices bool
+	// KnownBots matches user IDs that name themselves bots, e.g. ending in
+	// "bot" before the server name.
+	KnownBots bool
+	// Users lists further bot user IDs or glob patterns over them.
+	Users []string
+}
+
+// IsBot reports whether a message from sender, a notice if notice is set,
+// comes from a bot.
+func (f BotFilter) IsBot(sender id.UserID, notice bool) bool {
+	if f.Notices && notice {
+		return true
+	}
+	if f.KnownBots && matchesSender(knownBotPatterns, id.UserID(strings.ToLower(string(sender)))) {
+		return true
+	}
+	return matchesSender(f.Users, sender)
+}
@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// handleBroadcast posts an admin announcement to every broadcast room, or to
+// the rooms listed before the text: "/broadcast [!room:server ...] <text>".
+// Rooms over their broadcast throttle are skipped.
+func (s *Service) handleBroadcast(ctx context.Context, msg matrix.Message, args string) error {
+	if !s.isAdmin(msg.Sender) {
+		s.logf("ignoring broadcast from non-admin room=%s event=%s sender=%s", msg.RoomID, msg.EventID, msg.Sender)
+		return nil
+	}
+
+	rooms, text := splitBroadcastRooms(args)
+	if text == "" {
+		return s.reply(ctx, msg, s.text(msg, i18n.BroadcastUsage, i18n.Vars{"command": s.broadcast}))
+	}
+	if len(rooms) == 0 {
+		rooms = s.cfg.BroadcastRooms
+	}
+	for _, roomID := range rooms {
+		if !s.isBroadcastRoom(roomID) {
+			return s.reply(ctx, msg, s.text(msg, i18n.BroadcastUnknownRoom, i18n.Vars{"room": string(roomID)}))
+		}
+	}
+
+	sent := 0
+	for _, roomID := range rooms {
+		if _, ok := s.cooldowns.AllowRoom(roomID, actionBroadcast); !ok {
+			s.logf("broadcast throttled room=%s", roomID)
+			continue
+		}
+		if err := s.replier.SendReply(ctx, matrix.Reply{RoomID: roomID, Body: text}); err != nil {
+			s.logf("broadcast failed room=%s err=%v", roomID, err)
+			continue
+		}
+		sent++
+	}
+	s.logf("broadcast by %s sent to %d of %d rooms", msg.Sender, sent, len(rooms))
+	return s.reply(ctx, msg, s.text(msg, i18n.BroadcastDone, i18n.Vars{
+		"sent":  strconv.Itoa(sent),
+		"total": strconv.Itoa(len(rooms)),
+	}))
+}
+
+func (s *Service) isAdmin(userID id.UserID) bool {
+	for _, admin := range s.cfg.Admins {
+		if admin == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) isBroadcastRoom(roomID id.RoomID) bool {
+	for _, allowed := range s.cfg.BroadcastRooms {
+		if allowed == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// splitBroadcastRooms splits leading room IDs off a broadcast's arguments.
+func splitBroadcastRooms(args string) ([]id.RoomID, string) {
+	var rooms []id.RoomID
+	rest := strings.TrimSpace(args)
+	for strings.HasPrefix(rest, "!") {
+		word, tail, _ := strings.Cut(rest, " ")
+		if !strings.Contains(word, ":") {
+			break
+		}
+		rooms = append(rooms, id.RoomID(word))
+		rest = strings.TrimSpace(tail)
+	}
+	return rooms, rest
+}
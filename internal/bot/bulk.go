@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// Unconfirmed bulk index requests are forgotten after this long.
+	pendingIndexTTL = time.Hour
+	// Bound memory if many link dumps are never confirmed.
+	maxPendingIndexes = 256
+)
+
+type pendingIndex struct {
+	msg     matrix.Message
+	urls    []string
+	tags    []string
+	expires time.Time
+}
+
+// pendingIndexes holds bulk index requests awaiting confirmation, keyed by
+// the event that contained the links.
+type pendingIndexes struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[id.EventID]pendingIndex
+}
+
+func newPendingIndexes() *pendingIndexes {
+	return &pendingIndexes{
+		now:     time.Now,
+		entries: make(map[id.EventID]pendingIndex),
+	}
+}
+
+func (p *pendingIndexes) put(entry pendingIndex) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for eventID, existing := range p.entries {
+		if now.After(existing.expires) {
+			delete(p.entries, eventID)
+		}
+	}
+	if len(p.entries) >= maxPendingIndexes {
+		var oldest id.EventID
+		for eventID, existing := range p.entries {
+			if oldest == "" || existing.expires.Before(p.entries[oldest].expires) {
+				oldest = eventID
+			}
+		}
+		delete(p.entries, oldest)
+	}
+	entry.expires = now.Add(pendingIndexTTL)
+	p.entries[entry.msg.EventID] = entry
+}
+
+// take removes and returns the pending request for eventID, if still valid.
+func (p *pendingIndexes) take(eventID id.EventID) (pendingIndex, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[eventID]
+	if !ok {
+		return pendingIndex{}, false
+	}
+	delete(p.entries, eventID)
+	if p.now().After(entry.expires) {
+		return pendingIndex{}, false
+	}
+	return entry, true
+}
+
+func (p *pendingIndexes) peek(eventID id.EventID) (pendingIndex, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[eventID]
+	if !ok || p.now().After(entry.expires) {
+		return pendingIndex{}, false
+	}
+	return entry, true
+}
+
+func (s *Service) requestBulkIndexConfirmation(ctx context.Context, msg matrix.Message, urls []string, tags []string) {
+	s.pending.put(pendingIndex{msg: msg, urls: urls, tags: tags})
+	s.logf("bulk index awaiting confirmation room=%s event=%s urls=%d", msg.RoomID, msg.EventID, len(urls))
+
+	body := s.text(msg, i18n.BulkIndexPrompt, i18n.Vars{
+		"count":    strconv.Itoa(len(urls)),
+		"max":      strconv.Itoa(s.cfg.MaxAutoIndexURLs),
+		"reaction": s.cfg.BulkIndexConfirmReaction,
+	})
+	if err := s.reply(ctx, msg, body); err != nil {
+		s.logf("bulk index prompt failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+	}
+}
+
+// confirmBulkIndex indexes a pending link dump when its original sender
+// reacts with the confirmation key. It reports whether the reaction was
+// consumed as a confirmation.
+func (s *Service) confirmBulkIndex(ctx context.Context, reaction matrix.Reaction) (bool, error) {
+	entry, ok := s.pending.peek(reaction.TargetEventID)
+	if !ok || entry.msg.Sender != reaction.Sender {
+		return false, nil
+	}
+	if entry, ok = s.pending.take(reaction.TargetEventID); !ok {
+		return false, nil
+	}
+
+	indexed := s.indexAll(ctx, entry.msg, entry.urls, entry.tags)
+	return true, s.reply(ctx, entry.msg, s.text(entry.msg, i18n.BulkIndexDone, i18n.Vars{
+		"indexed": strconv.Itoa(indexed),
+		"total":   strconv.Itoa(len(entry.urls)),
+	}))
+}
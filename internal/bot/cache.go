@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"maunium.net/go/mautrix/id"
+)
+
+// refreshFlag in a search query bypasses the result cache.
+const refreshFlag = "--refresh"
+
+type cacheKey struct {
+	roomID id.RoomID
+	query  string
+}
+
+type cacheEntry struct {
+	results []hister.SearchResult
+	expires time.Time
+}
+
+// resultCache remembers recent search results per room for a short TTL.
+type resultCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	ttl     time.Duration
+	entries map[cacheKey]cacheEntry
+}
+
+// newResultCache returns a cache keeping results for ttl, or nil (which
+// caches nothing) if ttl is not positive.
+func newResultCache(ttl time.Duration) *resultCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &resultCache{
+		now:     time.Now,
+		ttl:     ttl,
+		entries: make(map[cacheKey]cacheEntry),
+	}
+}
+
+// Get returns the cached results for query in roomID, if they have not
+// expired.
+func (c *resultCache) Get(roomID id.RoomID, query string) ([]hister.SearchResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{roomID: roomID, query: normalizeQuery(query)}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return slices.Clone(entry.results), true
+}
+
+// Put caches results for query in roomID and drops expired entries.
+func (c *resultCache) Put(roomID id.RoomID, query string, results []hister.SearchResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[cacheKey{roomID: roomID, query: normalizeQuery(query)}] = cacheEntry{
+		results: slices.Clone(results),
+		expires: now.Add(c.ttl),
+	}
+}
+
+// Drop removes the result with rawURL from every cached search, e.g. once
+// it was deleted from the index.
+func (c *resultCache) Drop(rawURL string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		entry.results = slices.DeleteFunc(entry.results, func(r hister.SearchResult) bool {
+			return r.URL == rawURL
+		})
+		c.entries[key] = entry
+	}
+}
+
+// splitRefreshFlag removes refreshFlag from query and reports whether it was
+// present.
+func splitRefreshFlag(query string) (string, bool) {
+	fields := strings.Fields(query)
+	kept := slices.DeleteFunc(slices.Clone(fields), func(f string) bool {
+		return strings.EqualFold(f, refreshFlag)
+	})
+	if len(kept) == len(fields) {
+		return query, false
+	}
+	return strings.Join(kept, " "), true
+}
+
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
@@ -0,0 +1,42 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+)
+
+func TestResultCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newResultCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Put("!a:test", "golang  docs", []hister.SearchResult{{URL: "https://go.dev"}})
+	if got, ok := c.Get("!a:test", "golang docs"); !ok || len(got) != 1 || got[0].URL != "https://go.dev" {
+		t.Fatalf("Get() = %#v, %v; want cached result", got, ok)
+	}
+	if _, ok := c.Get("!b:test", "golang docs"); ok {
+		t.Fatal("expected results to be cached per room")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("!a:test", "golang docs"); ok {
+		t.Fatal("expected entry to expire after the TTL")
+	}
+
+	var disabled *resultCache = newResultCache(0)
+	disabled.Put("!a:test", "golang", nil)
+	if _, ok := disabled.Get("!a:test", "golang"); ok {
+		t.Fatal("expected a zero TTL to disable caching")
+	}
+}
+
+func TestSplitRefreshFlag(t *testing.T) {
+	if got, ok := splitRefreshFlag("golang --REFRESH docs"); !ok || got != "golang docs" {
+		t.Fatalf("splitRefreshFlag() = %q, %v", got, ok)
+	}
+	if got, ok := splitRefreshFlag("golang --refreshing"); ok || got != "golang --refreshing" {
+		t.Fatalf("splitRefreshFlag() = %q, %v", got, ok)
+	}
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// chatSearchWord starts a search over indexed room messages instead of
+// shared links: "/search chat <terms>".
+const chatSearchWord = "chat"
+
+// chatURLPrefix starts the URL of every indexed room message. Messages are
+// indexed under their matrix.to event permalink, which keeps them apart from
+// shared links and tells which room they belong to.
+const chatURLPrefix = "https://matrix.to/#/%21"
+
+// ChatIndexer is implemented by search backends that accept content the bot
+// extracted itself. Indexing room messages for chat search and uploaded
+// files needs it.
+type ChatIndexer interface {
+	IndexContent(ctx context.Context, rawURL string, content extractor.Result) error
+}
+
+// chatSearchEnabled reports whether roomID's messages are indexed for chat
+// search.
+func (s *Service) chatSearchEnabled(roomID id.RoomID) bool {
+	_, ok := s.backend.(ChatIndexer)
+	return ok && slices.Contains(s.cfg.ChatSearchRooms, roomID)
+}
+
+// indexChatMessage indexes the text of msg under its permalink so
+// "/search chat" can find it. Failures are only logged.
+func (s *Service) indexChatMessage(ctx context.Context, msg matrix.Message, body string) {
+	if !s.chatSearchEnabled(msg.RoomID) || msg.EventID == "" {
+		return
+	}
+	rawURL := chatMessageURL(msg.RoomID, msg.EventID)
+	err := s.roomBackend(msg.RoomID).(ChatIndexer).IndexContent(ctx, rawURL, extractor.Result{Title: string(msg.Sender), Text: body})
+	if err != nil {
+		s.logf("index chat message failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return
+	}
+	s.recordRoomDocument(ctx, msg.RoomID, rawURL)
+}
+
+// RoomDocumentStore records documents indexed from a room that are not
+// shared links, such as chat messages and summaries.
+type RoomDocumentStore interface {
+	RecordRoomDocument(ctx context.Context, roomID id.RoomID, rawURL string, indexedAt time.Time) error
+}
+
+func (s *Service) recordRoomDocument(ctx context.Context, roomID id.RoomID, rawURL string) {
+	if s.roomDocs == nil {
+		return
+	}
+	if err := s.roomDocs.RecordRoomDocument(ctx, roomID, rawURL, s.now()); err != nil {
+		s.logf("record room document failed room=%s url=%s err=%v", roomID, rawURL, err)
+	}
+}
+
+// chatSearchTerms returns the terms of a "chat <terms>" query in a room with
+// chat search enabled.
+func (s *Service) chatSearchTerms(roomID id.RoomID, query string) (string, bool) {
+	word, terms, _ := strings.Cut(query, " ")
+	terms = strings.TrimSpace(terms)
+	if !strings.EqualFold(word, chatSearchWord) || terms == "" || !s.chatSearchEnabled(roomID) {
+		return "", false
+	}
+	return terms, true
+}
+
+// handleChatSearch searches the indexed messages of msg's room. Messages of
+// other rooms are never returned, so encrypted rooms stay private to their
+// members.
+func (s *Service) handleChatSearch(ctx context.Context, msg matrix.Message, terms string) error {
+	results, err := s.roomBackend(msg.RoomID).Search(ctx, terms, s.resultLimit()*filteredSearchOverfetch)
+	if err != nil {
+		s.logf("chat search failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return s.reply(ctx, msg, s.searchFailedText(msg, err))
+	}
+	prefix := chatRoomPrefix(msg.RoomID)
+	var matches []hister.SearchResult
+	for _, r := range results {
+		if strings.HasPrefix(r.URL, prefix) && !matrix.IsSummaryURL(r.URL) {
+			matches = append(matches, r)
+		}
+		if len(matches) == s.resultLimit() {
+			break
+		}
+	}
+	if len(matches) == 0 {
+		return s.reply(ctx, msg, s.text(msg, i18n.NoResults, i18n.Vars{"query": terms}))
+	}
+	header := s.text(msg, i18n.ChatResults, i18n.Vars{"query": terms})
+	return s.replyResults(ctx, msg, header, matches)
+}
+
+// roomResults drops indexed room messages, and summaries of rooms other
+// than roomID, from link search results.
+func roomResults(results []hister.SearchResult, roomID id.RoomID) []hister.SearchResult {
+	prefix := chatRoomPrefix(roomID)
+	out := results[:0:0]
+	for _, r := range results {
+		if !strings.HasPrefix(r.URL, chatURLPrefix) || (matrix.IsSummaryURL(r.URL) && strings.HasPrefix(r.URL, prefix)) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func chatMessageURL(roomID id.RoomID, eventID id.EventID) string {
+	return roomID.EventURI(eventID).MatrixToURL()
+}
+
+// chatRoomPrefix starts the URL of every indexed message of roomID.
+func chatRoomPrefix(roomID id.RoomID) string {
+	return roomID.URI().MatrixToURL() + "/"
+}
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// Limit allows at most Max uses of an action per user within Window.
+type Limit struct {
+	Max    int
+	Window time.Duration
+}
+
+func (l Limit) enabled() bool {
+	return l.Max > 0 && l.Window > 0
+}
+
+type cooldownKey struct {
+	subject string
+	action  string
+}
+
+// cooldowns tracks per-user (or per-room) action timestamps in a sliding window.
+type cooldowns struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	limits map[string]Limit
+	hits   map[cooldownKey][]time.Time
+	// swept is when hits was last cleared of subjects with no recent uses.
+	swept time.Time
+}
+
+func newCooldowns(limits map[string]Limit) *cooldowns {
+	return &cooldowns{
+		now:    time.Now,
+		limits: limits,
+		hits:   make(map[cooldownKey][]time.Time),
+	}
+}
+
+// Allow records a use of action by user. If the user is over the limit, it
+// returns false and how long until the oldest use in the window expires.
+func (c *cooldowns) Allow(user id.UserID, action string) (time.Duration, bool) {
+	return c.allow(string(user), action)
+}
+
+// AllowRoom is Allow for limits that apply to a room rather than a user.
+func (c *cooldowns) AllowRoom(roomID id.RoomID, action string) (time.Duration, bool) {
+	return c.allow(string(roomID), action)
+}
+
+func (c *cooldowns) allow(subject, action string) (time.Duration, bool) {
+	if c == nil {
+		return 0, true
+	}
+	limit, ok := c.limits[action]
+	if !ok || !limit.enabled() {
+		return 0, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+	key := cooldownKey{subject: subject, action: action}
+	recent := pruneBefore(c.hits[key], now.Add(-limit.Window))
+	if len(recent) >= limit.Max {
+		c.hits[key] = recent
+		return recent[0].Add(limit.Window).Sub(now), false
+	}
+	c.hits[key] = append(recent, now)
+	return 0, true
+}
+
+// sweep drops the entries of subjects whose uses have all left their window,
+// at most once per longest window, so hits does not grow with every user and
+// room that ever ran a command.
+func (c *cooldowns) sweep(now time.Time) {
+	var longest time.Duration
+	for _, limit := range c.limits {
+		longest = max(longest, limit.Window)
+	}
+	if now.Sub(c.swept) < longest {
+		return
+	}
+	c.swept = now
+	for key, hits := range c.hits {
+		recent := pruneBefore(hits, now.Add(-c.limits[key.action].Window))
+		if len(recent) == 0 {
+			delete(c.hits, key)
+		} else {
+			c.hits[key] = recent
+		}
+	}
+}
+
+func pruneBefore(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	if i == 0 {
+		return hits
+	}
+	return append(hits[:0], hits[i:]...)
+}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCooldowns_SlidingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newCooldowns(map[string]Limit{actionSearch: {Max: 2, Window: time.Minute}})
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, ok := c.Allow("@alice:test", actionSearch); !ok {
+			t.Fatalf("expected use %d to be allowed", i+1)
+		}
+		now = now.Add(10 * time.Second)
+	}
+
+	wait, ok := c.Allow("@alice:test", actionSearch)
+	if ok {
+		t.Fatal("expected third use to be rejected")
+	}
+	if wait != 40*time.Second {
+		t.Fatalf("unexpected wait: %s", wait)
+	}
+
+	now = now.Add(41 * time.Second)
+	if _, ok := c.Allow("@alice:test", actionSearch); !ok {
+		t.Fatal("expected use to be allowed after the window slides")
+	}
+}
+
+func TestCooldowns_DisabledAndUnknownActions(t *testing.T) {
+	c := newCooldowns(map[string]Limit{actionSearch: {}})
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Allow("@alice:test", actionSearch); !ok {
+			t.Fatal("expected disabled limit to allow every use")
+		}
+		if _, ok := c.Allow("@alice:test", actionCatchMeUp); !ok {
+			t.Fatal("expected unknown action to allow every use")
+		}
+	}
+}
+
+func TestCooldowns_ForgetsIdleSubjects(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newCooldowns(map[string]Limit{actionSearch: {Max: 2, Window: time.Minute}})
+	c.now = func() time.Time { return now }
+
+	c.Allow("@alice:test", actionSearch)
+	c.AllowRoom("!room:test", actionSearch)
+	now = now.Add(2 * time.Minute)
+	c.Allow("@bob:test", actionSearch)
+
+	if len(c.hits) != 1 {
+		t.Fatalf("expected only the recent subject to be kept, got %v", c.hits)
+	}
+}
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"maunium.net/go/mautrix/id"
+)
+
+// Dead link actions select how search results the dead link checker found
+// dead are rendered.
+const (
+	DeadLinksDemote = "demote"
+	DeadLinksHide   = "hide"
+)
+
+// DeadLinkStore reports which URLs the dead link checker found dead.
+type DeadLinkStore interface {
+	DeadLinks(ctx context.Context, urls []string) (map[string]struct{}, error)
+}
+
+// applyDeadLinks hides dead results, or moves them after the live ones with
+// a marker in front of the title. Hister cannot remove documents from its
+// index, so this is where dead links are dealt with.
+func (s *Service) applyDeadLinks(ctx context.Context, roomID id.RoomID, results []hister.SearchResult) []hister.SearchResult {
+	if s.deadLinks == nil || len(results) == 0 {
+		return results
+	}
+	dead, err := s.deadLinks.DeadLinks(ctx, resultURLs(results))
+	if err != nil {
+		s.logf("dead link lookup failed room=%s err=%v", roomID, err)
+		return results
+	}
+	if len(dead) == 0 {
+		return results
+	}
+
+	live := make([]hister.SearchResult, 0, len(results))
+	var demoted []hister.SearchResult
+	for _, r := range results {
+		if _, ok := dead[r.URL]; !ok {
+			live = append(live, r)
+			continue
+		}
+		if s.cfg.DeadLinkAction == DeadLinksHide {
+			continue
+		}
+		title := r.Title
+		if title == "" {
+			title = r.URL
+		}
+		r.Title = s.cfg.Messages.Text(string(roomID), i18n.DeadLinkMarker, nil) + " " + title
+		demoted = append(demoted, r)
+	}
+	return append(live, demoted...)
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// Index failure notice modes select where the bot explains links it could
+// not index.
+const (
+	IndexFailuresOff    = "off"
+	IndexFailuresThread = "thread"
+	IndexFailuresDirect = "direct"
+)
+
+// DirectMessenger sends notices to users in a direct chat.
+type DirectMessenger interface {
+	SendDirectNotice(ctx context.Context, userID id.UserID, body string) error
+}
+
+// indexFailure is a URL that failed to index and why.
+type indexFailure struct {
+	url    string
+	reason hister.FailureReason
+}
+
+var failureReasonKeys = map[hister.FailureReason]i18n.Key{
+	hister.FailurePaywalled:   i18n.FailurePaywalled,
+	hister.FailureTooLarge:    i18n.FailureTooLarge,
+	hister.FailureServerError: i18n.FailureServer,
+	hister.FailureBlocked:     i18n.FailureBlocked,
+	hister.FailureOther:       i18n.FailureOther,
+}
+
+// notifyIndexFailures tells the sharer of msg which links failed to index and
+// why, in the message's thread or a direct chat depending on
+// IndexFailureNotices.
+func (s *Service) notifyIndexFailures(ctx context.Context, msg matrix.Message, failures []indexFailure) {
+	mode := s.cfg.IndexFailureNotices
+	if len(failures) == 0 || (mode != IndexFailuresThread && mode != IndexFailuresDirect) {
+		return
+	}
+
+	lines := []string{s.text(msg, i18n.IndexFailures, nil)}
+	for _, f := range failures {
+		key, ok := failureReasonKeys[f.reason]
+		if !ok {
+			key = i18n.FailureOther
+		}
+		lines = append(lines, "- "+f.url+": "+s.text(msg, key, nil))
+	}
+	body := strings.Join(lines, "\n")
+
+	var err error
+	if mode == IndexFailuresDirect && s.direct != nil {
+		err = s.direct.SendDirectNotice(ctx, msg.Sender, body)
+	} else {
+		err = s.reply(ctx, msg, body)
+	}
+	if err != nil {
+		s.logf("index failure notice failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+	}
+}
@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// FileDownloader fetches the file attached to a message, decrypting it in
+// encrypted rooms. It fails with matrix.ErrFileTooLarge rather than read more
+// than maxBytes.
+type FileDownloader interface {
+	DownloadFile(ctx context.Context, roomID id.RoomID, eventID id.EventID, maxBytes int64) ([]byte, error)
+}
+
+// HandleMatrixFile indexes uploaded plain text, markdown, HTML and PDF files
+// so they can be searched like shared links, together with their caption and
+// file name. Other uploads, such as images and videos, are indexed by their
+// caption and file name when they have a caption. Each is indexed under its
+// mxc URI followed by the file name. The backend must implement ChatIndexer.
+func (s *Service) HandleMatrixFile(ctx context.Context, file matrix.FileMessage) error {
+	indexer, ok := s.roomBackend(file.RoomID).(ChatIndexer)
+	if !s.cfg.IndexFiles || !ok || s.files == nil || file.URL == "" {
+		return nil
+	}
+	if !s.cfg.IndexSenders.Allows(file.Sender) || s.cfg.IgnoreBots.IsBot(file.Sender, false) {
+		return nil
+	}
+	// The size is whatever the sender put in the event, and 0 when they left
+	// it out, so it only saves a download that is sure to be too large; the
+	// download itself stops at the limit.
+	extract := extractor.SupportedFile(file.Name, file.MimeType)
+	if extract && int64(file.Size) > extractor.MaxFileBytes {
+		s.logf("skipping large file contents room=%s event=%s name=%q size=%d", file.RoomID, file.EventID, file.Name, file.Size)
+		extract = false
+	}
+	if !extract && file.Caption == "" {
+		return nil
+	}
+
+	content := extractor.Result{Title: file.Name}
+	if extract {
+		data, err := s.files.DownloadFile(ctx, file.RoomID, file.EventID, extractor.MaxFileBytes)
+		switch {
+		case errors.Is(err, matrix.ErrFileTooLarge):
+			s.logf("skipping large file contents room=%s event=%s name=%q", file.RoomID, file.EventID, file.Name)
+			if file.Caption == "" {
+				return nil
+			}
+		case err != nil:
+			return fmt.Errorf("download file: %w", err)
+		default:
+			if content, err = extractor.ExtractFile(file.Name, file.MimeType, data); err != nil {
+				return fmt.Errorf("extract %q: %w", file.Name, err)
+			}
+		}
+	}
+	content.Text = fileText(file, content)
+	rawURL := fileURL(file)
+	if err := indexer.IndexContent(ctx, rawURL, content); err != nil {
+		return fmt.Errorf("index %q: %w", file.Name, err)
+	}
+	s.logf("indexed file room=%s event=%s url=%s", file.RoomID, file.EventID, rawURL)
+	s.recordLink(ctx, matrix.Message{RoomID: file.RoomID, EventID: file.EventID, Sender: file.Sender}, rawURL, nil)
+	return nil
+}
+
+// fileText is the text file is indexed with: its caption, its name unless
+// that is already the title, and the extracted text.
+func fileText(file matrix.FileMessage, content extractor.Result) string {
+	var parts []string
+	for _, part := range []string{file.Caption, file.Name, content.Text} {
+		if part != "" && (part != file.Name || content.Title != file.Name) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// fileURL is the URL an uploaded file is indexed under.
+func fileURL(file matrix.FileMessage) string {
+	return string(file.URL) + "/" + url.PathEscape(file.Name)
+}
@@ -0,0 +1,38 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+)
+
+const forgetCommand = "/forget"
+
+// handleForget deletes a document from the search index on an admin's
+// request, e.g. to honor a removal request: "/forget <url>". It runs before
+// the message's links are indexed, so the URL is not indexed again.
+func (s *Service) handleForget(ctx context.Context, msg matrix.Message, args string) error {
+	if !s.isAdmin(msg.Sender) {
+		s.logf("ignoring forget from non-admin room=%s event=%s sender=%s", msg.RoomID, msg.EventID, msg.Sender)
+		return nil
+	}
+	deleter, ok := s.roomBackend(msg.RoomID).(DocumentDeleter)
+	if !ok {
+		s.logf("search backend cannot delete documents; ignoring forget room=%s event=%s", msg.RoomID, msg.EventID)
+		return nil
+	}
+	urls := s.parser.ExtractURLs(args)
+	if len(urls) != 1 {
+		return s.reply(ctx, msg, s.text(msg, i18n.ForgetUsage, i18n.Vars{"command": s.forget}))
+	}
+	rawURL := urls[0]
+	if err := deleter.DeleteURL(ctx, rawURL); err != nil {
+		s.logf("forget failed room=%s event=%s url=%s err=%v", msg.RoomID, msg.EventID, rawURL, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.ForgetFailed, i18n.Vars{"url": rawURL}))
+	}
+	s.logf("forgot document room=%s event=%s url=%s sender=%s", msg.RoomID, msg.EventID, rawURL, msg.Sender)
+	s.cache.Drop(rawURL)
+	s.forgetIndexed(ctx, msg.RoomID, rawURL)
+	return s.reply(ctx, msg, s.text(msg, i18n.Forgotten, i18n.Vars{"url": rawURL}))
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// IndexLog remembers when each shared URL was last submitted to the search
+// index, so a link shared again within Config.RecrawlAfter is not fetched
+// and added a second time. With a RoomScoper backend, URLs submitted to a
+// named index are logged as the index name, a space and the URL.
+type IndexLog interface {
+	URLIndexedAt(ctx context.Context, rawURL string) (time.Time, error)
+	MarkURLIndexed(ctx context.Context, rawURL string, indexedAt time.Time) error
+	ForgetIndexedURL(ctx context.Context, rawURL string) error
+}
+
+// indexLogKey is what the IndexLog knows rawURL as once it was indexed on
+// behalf of roomID, so a link indexed for one room is still added to the
+// index of another room that does not share it.
+func (s *Service) indexLogKey(roomID id.RoomID, rawURL string) string {
+	if scoper, ok := s.backend.(RoomScoper); ok {
+		if name := scoper.IndexName(roomID); name != "" {
+			return name + " " + rawURL
+		}
+	}
+	return rawURL
+}
+
+// indexedRecently reports whether rawURL was indexed into msg's room index
+// within RecrawlAfter. Lookup failures are logged and count as not indexed.
+func (s *Service) indexedRecently(ctx context.Context, msg matrix.Message, rawURL string) bool {
+	if s.indexLog == nil || s.cfg.RecrawlAfter <= 0 {
+		return false
+	}
+	indexedAt, err := s.indexLog.URLIndexedAt(ctx, s.indexLogKey(msg.RoomID, rawURL))
+	if err != nil {
+		s.logf("look up indexed url failed room=%s event=%s url=%s err=%v", msg.RoomID, msg.EventID, rawURL, err)
+		return false
+	}
+	return !indexedAt.IsZero() && s.now().Sub(indexedAt) < s.cfg.RecrawlAfter
+}
+
+func (s *Service) markIndexed(ctx context.Context, roomID id.RoomID, rawURL string) {
+	if s.indexLog == nil {
+		return
+	}
+	if err := s.indexLog.MarkURLIndexed(ctx, s.indexLogKey(roomID, rawURL), s.now()); err != nil {
+		s.logf("mark url indexed failed url=%s err=%v", rawURL, err)
+	}
+}
+
+// forgetIndexed forgets that rawURL was indexed once it was deleted from
+// roomID's index, so it is indexed again the next time it is shared there.
+func (s *Service) forgetIndexed(ctx context.Context, roomID id.RoomID, rawURL string) {
+	if s.indexLog == nil {
+		return
+	}
+	if err := s.indexLog.ForgetIndexedURL(ctx, s.indexLogKey(roomID, rawURL)); err != nil {
+		s.logf("forget indexed url failed url=%s err=%v", rawURL, err)
+	}
+}
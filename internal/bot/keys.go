@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	exportKeysCommand = "/exportkeys"
+	importKeysCommand = "/importkeys"
+
+	// maxKeyFileBytes is the largest key export /importkeys downloads. Each
+	// session takes about a kilobyte, so this leaves room for tens of
+	// thousands of them.
+	maxKeyFileBytes = 64 << 20
+)
+
+// KeyManager exports and imports the bot's end-to-end encryption room keys
+// and moves key files through a room.
+type KeyManager interface {
+	IsDirectChat(ctx context.Context, roomID id.RoomID, userID id.UserID) (bool, error)
+	ExportRoomKeys(ctx context.Context, passphrase string) ([]byte, int, error)
+	ImportRoomKeys(ctx context.Context, passphrase string, data []byte) (int, int, error)
+	SendKeyExport(ctx context.Context, roomID id.RoomID, inReplyTo id.EventID, data []byte) error
+	DownloadFile(ctx context.Context, roomID id.RoomID, eventID id.EventID, maxBytes int64) ([]byte, error)
+}
+
+// keyCommandAllowed reports whether msg may run a key command: only admins,
+// and only in a room shared with nobody but the bot.
+func (s *Service) keyCommandAllowed(ctx context.Context, msg matrix.Message, command string) (bool, error) {
+	if !s.isAdmin(msg.Sender) {
+		s.logf("ignoring %s from non-admin room=%s event=%s sender=%s", command, msg.RoomID, msg.EventID, msg.Sender)
+		return false, nil
+	}
+	direct, err := s.keys.IsDirectChat(ctx, msg.RoomID, msg.Sender)
+	if err != nil {
+		s.logf("direct chat check failed room=%s err=%v", msg.RoomID, err)
+	}
+	if !direct {
+		return false, s.reply(ctx, msg, s.text(msg, i18n.KeysDirectOnly, i18n.Vars{"command": command}))
+	}
+	return true, nil
+}
+
+// handleExportKeys sends the bot's room keys, encrypted with the given
+// passphrase, as a file in the admin's direct chat.
+func (s *Service) handleExportKeys(ctx context.Context, msg matrix.Message, passphrase string) error {
+	if ok, err := s.keyCommandAllowed(ctx, msg, s.exportKeys); !ok {
+		return err
+	}
+	passphrase = strings.TrimSpace(passphrase)
+	if passphrase == "" {
+		return s.reply(ctx, msg, s.text(msg, i18n.ExportKeysUsage, i18n.Vars{"command": s.exportKeys}))
+	}
+
+	data, count, err := s.keys.ExportRoomKeys(ctx, passphrase)
+	if errors.Is(err, matrix.ErrNoRoomKeys) {
+		return s.reply(ctx, msg, s.text(msg, i18n.KeysNone, nil))
+	}
+	if err != nil {
+		s.logf("key export failed room=%s err=%v", msg.RoomID, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.KeysFailed, nil))
+	}
+	if err := s.keys.SendKeyExport(ctx, msg.RoomID, msg.EventID, data); err != nil {
+		s.logf("key export upload failed room=%s err=%v", msg.RoomID, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.KeysFailed, nil))
+	}
+	s.logf("room keys exported room=%s sender=%s count=%d", msg.RoomID, msg.Sender, count)
+	return s.reply(ctx, msg, s.text(msg, i18n.KeysExported, i18n.Vars{"count": strconv.Itoa(count)}))
+}
+
+// handleImportKeys imports the key export file msg replies to.
+func (s *Service) handleImportKeys(ctx context.Context, msg matrix.Message, passphrase string) error {
+	if ok, err := s.keyCommandAllowed(ctx, msg, s.importKeys); !ok {
+		return err
+	}
+	passphrase = strings.TrimSpace(passphrase)
+	if passphrase == "" || msg.ReplyTo == "" {
+		return s.reply(ctx, msg, s.text(msg, i18n.ImportKeysUsage, i18n.Vars{"command": s.importKeys}))
+	}
+
+	data, err := s.keys.DownloadFile(ctx, msg.RoomID, msg.ReplyTo, maxKeyFileBytes)
+	if err != nil {
+		s.logf("key file download failed room=%s event=%s err=%v", msg.RoomID, msg.ReplyTo, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.ImportKeysUsage, i18n.Vars{"command": s.importKeys}))
+	}
+	imported, total, err := s.keys.ImportRoomKeys(ctx, passphrase, data)
+	if err != nil {
+		s.logf("key import failed room=%s err=%v", msg.RoomID, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.KeysFailed, nil))
+	}
+	s.logf("room keys imported room=%s sender=%s imported=%d total=%d", msg.RoomID, msg.Sender, imported, total)
+	return s.reply(ctx, msg, s.text(msg, i18n.KeysImported, i18n.Vars{
+		"imported": strconv.Itoa(imported),
+		"total":    strconv.Itoa(total),
+	}))
+}
@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomScoper is implemented by search backends that keep a separate index
+// for each room, or group of rooms. Everything the bot indexes, searches or
+// deletes on behalf of a room goes to the backend ForRoom returns, so links
+// shared in one room are not found by searches in another.
+type RoomScoper interface {
+	ForRoom(roomID id.RoomID) hister.SearchBackend
+	// IndexName names the index ForRoom returns; rooms with the same name
+	// share one, and the empty name is the backend's default index.
+	IndexName(roomID id.RoomID) string
+}
+
+// roomBackend returns the backend for work on behalf of roomID.
+func (s *Service) roomBackend(roomID id.RoomID) hister.SearchBackend {
+	if scoper, ok := s.backend.(RoomScoper); ok {
+		return scoper.ForRoom(roomID)
+	}
+	return s.backend
+}
+
+// linkRooms returns the rooms whose recorded links a search in roomID may
+// list: those of its room: operators that share roomID's index, or roomID
+// itself without any. The result is empty when every room named is kept
+// apart from roomID.
+func (s *Service) linkRooms(roomID id.RoomID, rooms []string) []string {
+	if len(rooms) == 0 {
+		return []string{string(roomID)}
+	}
+	scoper, ok := s.backend.(RoomScoper)
+	if !ok {
+		return rooms
+	}
+	name := scoper.IndexName(roomID)
+	var shared []string
+	for _, room := range rooms {
+		if scoper.IndexName(id.RoomID(room)) == name {
+			shared = append(shared, room)
+		}
+	}
+	return shared
+}
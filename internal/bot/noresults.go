@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+)
+
+const (
+	// closestTitleCandidates bounds how many recently seen titles are
+	// compared against a query that found nothing.
+	closestTitleCandidates = 1000
+	maxClosestTitles       = 3
+)
+
+// DocumentStore remembers the titles of documents the bot has seen, so
+// searches that find nothing can report the size of the index and suggest
+// similarly titled documents.
+type DocumentStore interface {
+	RecordDocumentTitle(ctx context.Context, rawURL, title string, seenAt time.Time) error
+	DocumentTitles(ctx context.Context, limit int) ([]storage.DocumentTitle, error)
+	DocumentCount(ctx context.Context) (int, error)
+}
+
+// recordTitles remembers the titles of results for later fuzzy matching.
+func (s *Service) recordTitles(ctx context.Context, results []hister.SearchResult) {
+	for _, r := range results {
+		s.recordTitle(ctx, r.URL, r.Title)
+	}
+}
+
+func (s *Service) recordTitle(ctx context.Context, rawURL, title string) {
+	title = strings.TrimSpace(title)
+	if s.documents == nil || title == "" {
+		return
+	}
+	if err := s.documents.RecordDocumentTitle(ctx, rawURL, title, s.now()); err != nil {
+		s.logf("record document title failed url=%s err=%v", rawURL, err)
+	}
+}
+
+// replyNoResults explains an empty search: the query as the operator parser
+// understood it (when that differs from what was typed), how many documents
+// are indexed and the closest titles the bot knows of.
+func (s *Service) replyNoResults(ctx context.Context, msg matrix.Message, query string) error {
+	lines := []string{s.text(msg, i18n.NoResults, i18n.Vars{"query": query})}
+
+	q := triggers.ParseSearchQuery(query)
+	if interpreted := q.String(); interpreted != strings.Join(strings.Fields(query), " ") {
+		lines = append(lines, s.text(msg, i18n.NoResultsQuery, i18n.Vars{"query": interpreted}))
+	}
+	if s.documents == nil {
+		return s.reply(ctx, msg, strings.Join(lines, "\n"))
+	}
+
+	if count, err := s.documents.DocumentCount(ctx); err != nil {
+		s.logf("count documents failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+	} else {
+		lines = append(lines, s.text(msg, i18n.NoResultsIndexSize, i18n.Vars{"count": strconv.Itoa(count)}))
+	}
+
+	text := q.Text
+	if text == "" {
+		text = strings.Join(q.Sites, " ")
+	}
+	if closest := s.closestTitles(ctx, msg, text); len(closest) > 0 {
+		lines = append(lines, "", s.text(msg, i18n.NoResultsClosest, nil))
+		for _, d := range closest {
+			lines = append(lines, "- "+d.Title+"\n  "+d.URL)
+		}
+	}
+	return s.reply(ctx, msg, strings.Join(lines, "\n"))
+}
+
+func (s *Service) closestTitles(ctx context.Context, msg matrix.Message, text string) []storage.DocumentTitle {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	docs, err := s.documents.DocumentTitles(ctx, closestTitleCandidates)
+	if err != nil {
+		s.logf("load document titles failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return nil
+	}
+	titles := make([]string, len(docs))
+	for i, d := range docs {
+		titles[i] = d.Title
+	}
+	var out []storage.DocumentTitle
+	for _, i := range triggers.ClosestTitles(text, titles, maxClosestTitles) {
+		out = append(out, docs[i])
+	}
+	return out
+}
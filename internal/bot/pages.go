@@ -0,0 +1,478 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/outbox"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	nextPageCommand = "/next"
+	prevPageCommand = "/prev"
+	// Replies in a results thread may page with these words alone.
+	nextPageWord = "next"
+	prevPageWord = "prev"
+	// maxPagedResults bounds how many results messages can still be paged.
+	maxPagedResults = 256
+)
+
+// Reactions the bot adds to its results messages with
+// Config.ResultReactions. Reacting with them pages through the results or,
+// for admins, deletes the top result of the page from the index.
+const (
+	prevPageReaction     = "⬅️"
+	nextPageReaction     = "➡️"
+	deleteResultReaction = "🗑️"
+)
+
+// EventReplier is implemented by repliers that return the event ID of a
+// reply. Paging through search results needs it, since the pages are shown
+// by editing the first one.
+type EventReplier interface {
+	SendReplyEvent(ctx context.Context, reply matrix.Reply) (id.EventID, error)
+}
+
+// PageSearcher is implemented by search backends that can return results
+// past the first ones. Turning the page past the results a plain search
+// fetched asks it for more.
+type PageSearcher interface {
+	SearchPage(ctx context.Context, query string, offset, limit int) (hister.Page, error)
+}
+
+// pagedResults are the results behind one results message of the bot.
+type pagedResults struct {
+	roomID  id.RoomID
+	header  string
+	results []hister.SearchResult
+	spoiler func(hister.SearchResult) bool
+	// page is the zero-based page the message shows.
+	page int
+	// query, when set, is the search the results came from, which can be
+	// continued from offset fetched. total is the number of matches the
+	// backend reported, 0 if it did not say, and exhausted is set once it
+	// ran out.
+	query     string
+	fetched   int
+	total     int
+	exhausted bool
+}
+
+// more reports whether the backend may have results past those fetched.
+func (p *pagedResults) more() bool {
+	return p.query != "" && !p.exhausted && (p.total == 0 || p.fetched < p.total)
+}
+
+// resultPages remembers the most recent results messages, by event ID, with
+// the latest one of each room and of each thread. The oldest are forgotten
+// first.
+type resultPages struct {
+	mu      sync.Mutex
+	pages   map[id.EventID]*pagedResults
+	order   []id.EventID
+	rooms   map[id.RoomID]id.EventID
+	threads map[id.EventID]id.EventID
+}
+
+func newResultPages() *resultPages {
+	return &resultPages{
+		pages:   make(map[id.EventID]*pagedResults),
+		rooms:   make(map[id.RoomID]id.EventID),
+		threads: make(map[id.EventID]id.EventID),
+	}
+}
+
+// Add remembers p as shown by eventID, the latest results of its room and of
+// the thread rooted at root.
+func (r *resultPages) Add(eventID, root id.EventID, p *pagedResults) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.order) == maxPagedResults {
+		oldest := r.order[0]
+		r.order = r.order[1:]
+		delete(r.pages, oldest)
+		for roomID, eventID := range r.rooms {
+			if eventID == oldest {
+				delete(r.rooms, roomID)
+			}
+		}
+		for root, eventID := range r.threads {
+			if eventID == oldest {
+				delete(r.threads, root)
+			}
+		}
+	}
+	r.pages[eventID] = p
+	r.order = append(r.order, eventID)
+	r.rooms[p.roomID] = eventID
+	r.threads[root] = eventID
+}
+
+// Latest returns the results message to page for a command sent in roomID,
+// in the thread rooted at root if any: that thread's latest results, or else
+// the room's.
+func (r *resultPages) Latest(roomID id.RoomID, root id.EventID) (id.EventID, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if eventID, ok := r.threads[root]; ok && root != "" {
+		return eventID, true
+	}
+	eventID, ok := r.rooms[roomID]
+	return eventID, ok
+}
+
+// Has reports whether the results shown by eventID are remembered.
+func (r *resultPages) Has(eventID id.EventID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.pages[eventID]
+	return ok
+}
+
+// HasThread reports whether the thread rooted at root has results.
+func (r *resultPages) HasThread(root id.EventID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.threads[root]
+	return ok
+}
+
+// Turn moves the results shown by eventID by delta pages and returns them
+// at the new page. It reports false when that page does not exist.
+func (r *resultPages) Turn(eventID id.EventID, delta, perPage int) (pagedResults, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pages[eventID]
+	if !ok {
+		return pagedResults{}, false
+	}
+	page := p.page + delta
+	if page < 0 || page >= pageCount(len(p.results), perPage) {
+		return pagedResults{}, false
+	}
+	p.page = page
+	return *p, true
+}
+
+// Continuation returns the query and offset to fetch further results from
+// when the page after the one eventID shows would be past those fetched.
+func (r *resultPages) Continuation(eventID id.EventID, perPage int) (string, int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pages[eventID]
+	if !ok || !p.more() || p.page+1 < pageCount(len(p.results), perPage) {
+		return "", 0, false
+	}
+	return p.query, p.fetched, true
+}
+
+// Extend appends results fetched from offset on to those shown by eventID,
+// leaving out ones already there. The search counts as exhausted when it
+// returned fewer than limit results or none that were new.
+func (r *resultPages) Extend(eventID id.EventID, offset, limit int, page hister.Page) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pages[eventID]
+	if !ok || p.fetched != offset {
+		return
+	}
+	seen := make(map[string]struct{}, len(p.results))
+	for _, result := range p.results {
+		seen[result.URL] = struct{}{}
+	}
+	added := 0
+	for _, result := range page.Results {
+		if _, ok := seen[result.URL]; ok {
+			continue
+		}
+		seen[result.URL] = struct{}{}
+		p.results = append(p.results, result)
+		added++
+	}
+	p.fetched += len(page.Results)
+	p.total = page.Total
+	p.exhausted = added == 0 || len(page.Results) < limit
+}
+
+// Top returns the URL of the first result on the page eventID shows.
+func (r *resultPages) Top(eventID id.EventID, perPage int) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pages[eventID]
+	if !ok || p.page*perPage >= len(p.results) {
+		return "", false
+	}
+	return p.results[p.page*perPage].URL, true
+}
+
+// Drop removes the result with rawURL from those shown by eventID and
+// returns them, moving back a page if the last one became empty.
+func (r *resultPages) Drop(eventID id.EventID, rawURL string, perPage int) (pagedResults, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pages[eventID]
+	if !ok {
+		return pagedResults{}, false
+	}
+	p.results = slices.DeleteFunc(slices.Clone(p.results), func(result hister.SearchResult) bool {
+		return result.URL == rawURL
+	})
+	p.page = max(min(p.page, pageCount(len(p.results), perPage)-1), 0)
+	return *p, true
+}
+
+func pageCount(results, perPage int) int {
+	return (results + perPage - 1) / perPage
+}
+
+// resultLimit is how many results a search fetches: MaxResults for each of
+// the ResultPages pages.
+func (s *Service) resultLimit() int {
+	return s.cfg.MaxResults * max(s.cfg.ResultPages, 1)
+}
+
+// pageDelta reports whether body asks to turn the page of earlier results,
+// and in which direction. The bare words are only understood in a thread
+// with results.
+func (s *Service) pageDelta(msg matrix.Message, body string) (int, bool) {
+	switch {
+	case strings.EqualFold(body, s.nextPage):
+		return 1, true
+	case strings.EqualFold(body, s.prevPage):
+		return -1, true
+	}
+	if msg.ThreadRoot == "" || !s.pages.HasThread(msg.ThreadRoot) {
+		return 0, false
+	}
+	switch strings.ToLower(strings.Trim(body, ".!")) {
+	case nextPageWord:
+		return 1, true
+	case prevPageWord:
+		return -1, true
+	}
+	return 0, false
+}
+
+// turnPage edits the results message msg refers to to show the page delta
+// pages away. Pages past either end are ignored.
+func (s *Service) turnPage(ctx context.Context, msg matrix.Message, delta int) error {
+	eventID, ok := s.pages.Latest(msg.RoomID, msg.ThreadRoot)
+	if !ok {
+		return s.reply(ctx, msg, s.text(msg, i18n.NoResultPages, nil))
+	}
+	p, ok := s.turn(ctx, msg.RoomID, eventID, delta)
+	if !ok {
+		return nil
+	}
+	return s.showPage(ctx, eventID, p)
+}
+
+// turn moves the results shown by eventID in roomID by delta pages, first
+// fetching more from the backend if the next page is past the fetched
+// results.
+func (s *Service) turn(ctx context.Context, roomID id.RoomID, eventID id.EventID, delta int) (pagedResults, bool) {
+	if delta > 0 {
+		s.fetchMoreResults(ctx, roomID, eventID)
+	}
+	return s.pages.Turn(eventID, delta, s.cfg.MaxResults)
+}
+
+// fetchMoreResults extends the results shown by eventID with the next
+// resultLimit ones of their search, if it may have more and the backend is
+// a PageSearcher. Failures leave the results as they are.
+func (s *Service) fetchMoreResults(ctx context.Context, roomID id.RoomID, eventID id.EventID) {
+	searcher, ok := s.roomBackend(roomID).(PageSearcher)
+	if !ok {
+		return
+	}
+	query, offset, ok := s.pages.Continuation(eventID, s.cfg.MaxResults)
+	if !ok {
+		return
+	}
+	limit := s.resultLimit()
+	page, err := searcher.SearchPage(ctx, query, offset, limit)
+	if err != nil {
+		s.logf("fetching more results failed event=%s offset=%d err=%v", eventID, offset, err)
+		return
+	}
+	s.recordTitles(ctx, page.Results)
+	s.pages.Extend(eventID, offset, limit, page)
+}
+
+// showPage edits the results message eventID to show the page p is at.
+func (s *Service) showPage(ctx context.Context, eventID id.EventID, p pagedResults) error {
+	body, formatted := s.formatPage(p)
+	return s.replier.SendReply(ctx, matrix.Reply{
+		RoomID:        p.roomID,
+		Body:          body,
+		FormattedBody: formatted,
+		Kind:          matrix.ReplyResults,
+		Replaces:      eventID,
+	})
+}
+
+// replyResults replies to msg with results below header. When they do not
+// fit on one page, the first page is sent and the rest are kept for /next
+// and /prev. With result reactions, the results are kept for the reactions
+// even when they fit.
+func (s *Service) replyResults(ctx context.Context, msg matrix.Message, header string, results []hister.SearchResult) error {
+	return s.replyPages(ctx, msg, &pagedResults{header: header, results: results})
+}
+
+// replySearchResults replies like replyResults with the first results of
+// query, which paging continues from the backend past the fetched ones. The
+// backend returned fetched results before any were dropped.
+func (s *Service) replySearchResults(ctx context.Context, msg matrix.Message, header, query string, results []hister.SearchResult, fetched int) error {
+	p := &pagedResults{header: header, results: results}
+	if _, ok := s.backend.(PageSearcher); ok {
+		p.query = query
+		p.fetched = fetched
+		p.exhausted = fetched < s.resultLimit()
+	}
+	return s.replyPages(ctx, msg, p)
+}
+
+func (s *Service) replyPages(ctx context.Context, msg matrix.Message, p *pagedResults) error {
+	p.roomID = msg.RoomID
+	p.spoiler = s.spoilerFunc(msg.RoomID)
+	events, ok := s.replier.(EventReplier)
+	paged := len(p.results) > s.cfg.MaxResults || p.more()
+	if !ok || (!paged && len(s.resultReactions(paged)) == 0) {
+		body, formatted := s.formatResults(p.roomID, p.header, p.results[:min(len(p.results), s.cfg.MaxResults)], p.spoiler)
+		return s.replyAs(ctx, msg, matrix.ReplyResults, body, formatted)
+	}
+
+	body, formatted := s.formatPage(*p)
+	reply := matrix.Reply{RoomID: msg.RoomID, Body: body, FormattedBody: formatted, Kind: matrix.ReplyResults}
+	eventID, err := events.SendReplyEvent(ctx, s.relate(reply, msg.EventID, threadRoot(msg)))
+	if errors.Is(err, outbox.ErrQueued) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.pages.Add(eventID, threadRoot(msg), p)
+	for _, key := range s.resultReactions(paged) {
+		if err := s.reactor.SendReaction(ctx, msg.RoomID, eventID, key); err != nil {
+			s.logf("result reaction failed room=%s event=%s key=%s err=%v", msg.RoomID, eventID, key, err)
+			break
+		}
+	}
+	return nil
+}
+
+// resultReactions are the reactions to add to a results message: paging
+// ones if it has further pages, and deletion if the backend can delete.
+func (s *Service) resultReactions(paged bool) []string {
+	if !s.cfg.ResultReactions || s.reactor == nil {
+		return nil
+	}
+	var keys []string
+	if paged {
+		keys = append(keys, prevPageReaction, nextPageReaction)
+	}
+	if _, ok := s.backend.(DocumentDeleter); ok {
+		keys = append(keys, deleteResultReaction)
+	}
+	return keys
+}
+
+// handleResultReaction pages through or deletes from the results of the
+// message reacted to. It reports false for other reactions.
+func (s *Service) handleResultReaction(ctx context.Context, reaction matrix.Reaction) (bool, error) {
+	if !s.cfg.ResultReactions || !s.pages.Has(reaction.TargetEventID) {
+		return false, nil
+	}
+	switch {
+	case sameReactionKey(reaction.Key, prevPageReaction):
+		return true, s.turnPageByReaction(ctx, reaction, -1)
+	case sameReactionKey(reaction.Key, nextPageReaction):
+		return true, s.turnPageByReaction(ctx, reaction, 1)
+	case sameReactionKey(reaction.Key, deleteResultReaction):
+		return true, s.deleteTopResult(ctx, reaction)
+	}
+	return false, nil
+}
+
+func (s *Service) turnPageByReaction(ctx context.Context, reaction matrix.Reaction, delta int) error {
+	p, ok := s.turn(ctx, reaction.RoomID, reaction.TargetEventID, delta)
+	if !ok {
+		return nil
+	}
+	return s.showPage(ctx, reaction.TargetEventID, p)
+}
+
+// deleteTopResult removes the first result on the page shown by the
+// reacted-to message from the index and from the message. Only admins may.
+func (s *Service) deleteTopResult(ctx context.Context, reaction matrix.Reaction) error {
+	deleter, ok := s.roomBackend(reaction.RoomID).(DocumentDeleter)
+	if !ok {
+		return nil
+	}
+	if !s.isAdmin(reaction.Sender) {
+		s.logf("ignoring delete reaction from non-admin room=%s event=%s sender=%s", reaction.RoomID, reaction.EventID, reaction.Sender)
+		return nil
+	}
+	rawURL, ok := s.pages.Top(reaction.TargetEventID, s.cfg.MaxResults)
+	if !ok {
+		return nil
+	}
+	if err := deleter.DeleteURL(ctx, rawURL); err != nil {
+		return fmt.Errorf("delete result: %w", err)
+	}
+	s.logf("deleted result room=%s event=%s url=%s sender=%s", reaction.RoomID, reaction.TargetEventID, rawURL, reaction.Sender)
+	s.cache.Drop(rawURL)
+	s.forgetIndexed(ctx, reaction.RoomID, rawURL)
+	p, ok := s.pages.Drop(reaction.TargetEventID, rawURL, s.cfg.MaxResults)
+	if !ok {
+		return nil
+	}
+	return s.showPage(ctx, reaction.TargetEventID, p)
+}
+
+// formatPage formats the page p is at, numbering results across pages and
+// ending with how to reach the others if there are any. While the backend
+// may have more results, the page count follows its total, or is marked
+// open-ended if it did not report one.
+func (s *Service) formatPage(p pagedResults) (string, string) {
+	start := p.page * s.cfg.MaxResults
+	end := min(start+s.cfg.MaxResults, len(p.results))
+	body, formatted := s.formatResultsFrom(p.roomID, p.header, p.results[start:end], start+1, p.spoiler)
+	pages := pageCount(len(p.results), s.cfg.MaxResults)
+	more := p.more()
+	if pages <= 1 && !more {
+		return body, formatted
+	}
+	pageTotal := strconv.Itoa(pages)
+	switch {
+	case more && p.total > len(p.results):
+		pageTotal = strconv.Itoa(pageCount(p.total, s.cfg.MaxResults))
+	case more:
+		pageTotal += "+"
+	}
+	footer := s.cfg.Messages.Text(string(p.roomID), i18n.ResultsPage, i18n.Vars{
+		"page":  strconv.Itoa(p.page + 1),
+		"pages": pageTotal,
+		"next":  s.nextPage,
+		"prev":  s.prevPage,
+	})
+	return body + "\n\n" + footer, formatted + "<p>" + htmlText(footer) + "</p>"
+}
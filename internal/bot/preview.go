@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+)
+
+// ContentIndexer is implemented by search backends that return the content
+// they extracted while indexing a URL. URL previews need it.
+type ContentIndexer interface {
+	IndexURLContent(ctx context.Context, rawURL string) (extractor.Result, error)
+}
+
+// preview is an indexed URL and the content extracted for it.
+type preview struct {
+	url     string
+	content extractor.Result
+}
+
+// indexURL indexes rawURL, shared in msg, returning its extracted content
+// when the backend provides it.
+func (s *Service) indexURL(ctx context.Context, msg matrix.Message, rawURL string) (extractor.Result, error) {
+	backend := s.roomBackend(msg.RoomID)
+	if indexer, ok := backend.(SourceIndexer); ok && s.cfg.IndexSources {
+		return indexer.IndexURLFrom(ctx, rawURL, linkSource(msg, s.now()))
+	}
+	if indexer, ok := backend.(ContentIndexer); ok && s.cfg.URLPreviews {
+		return indexer.IndexURLContent(ctx, rawURL)
+	}
+	return extractor.Result{}, backend.IndexURL(ctx, rawURL)
+}
+
+// sendPreviews replies to msg with the title and a one-line description of
+// each indexed URL.
+func (s *Service) sendPreviews(ctx context.Context, msg matrix.Message, previews []preview) {
+	if !s.cfg.URLPreviews || len(previews) == 0 {
+		return
+	}
+	if err := s.reply(ctx, msg, formatPreviews(previews)); err != nil {
+		s.logf("url preview reply failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+	}
+}
+
+func formatPreviews(previews []preview) string {
+	blocks := make([]string, 0, len(previews))
+	for _, p := range previews {
+		title := strings.TrimSpace(p.content.Title)
+		if title == "" {
+			title = p.url
+		}
+		description := p.content.Description
+		if description == "" {
+			description = p.content.Text
+		}
+		block := title
+		if description = truncate(strings.Join(strings.Fields(description), " "), maxSnippetLen); description != "" {
+			block += "\n" + description
+		}
+		blocks = append(blocks, block)
+	}
+	return strings.Join(blocks, "\n\n")
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// RedactionStore forgets what the bot recorded about a redacted event and
+// returns the documents nothing else refers to.
+type RedactionStore interface {
+	ForgetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, documents ...string) ([]string, error)
+}
+
+// DocumentDeleter is implemented by search backends that can remove a
+// document from their index.
+type DocumentDeleter interface {
+	DeleteURL(ctx context.Context, rawURL string) error
+}
+
+// HandleMatrixRedaction removes a redacted message from the search index:
+// the links no other message shared and, in chat search rooms, the message
+// itself. With a backend that cannot delete documents they stay searchable,
+// but the bot still forgets who shared them.
+func (s *Service) HandleMatrixRedaction(ctx context.Context, redaction matrix.Redaction) error {
+	s.pending.take(redaction.Redacts)
+	if s.redactions == nil {
+		return nil
+	}
+	var documents []string
+	if s.chatSearchEnabled(redaction.RoomID) {
+		documents = append(documents, chatMessageURL(redaction.RoomID, redaction.Redacts))
+	}
+	orphaned, err := s.redactions.ForgetEvent(ctx, redaction.RoomID, redaction.Redacts, documents...)
+	if err != nil {
+		return fmt.Errorf("forget redacted event: %w", err)
+	}
+	if len(orphaned) == 0 {
+		return nil
+	}
+	deleter, ok := s.roomBackend(redaction.RoomID).(DocumentDeleter)
+	if !ok {
+		s.logf("search backend cannot delete documents; redacted event's documents stay indexed room=%s event=%s urls=%d", redaction.RoomID, redaction.Redacts, len(orphaned))
+		return nil
+	}
+	for _, rawURL := range orphaned {
+		if err := deleter.DeleteURL(ctx, rawURL); err != nil {
+			s.logf("delete redacted document failed room=%s event=%s url=%s err=%v", redaction.RoomID, redaction.Redacts, rawURL, err)
+			continue
+		}
+		s.logf("deleted redacted document room=%s event=%s url=%s", redaction.RoomID, redaction.Redacts, rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// ThreadQueryStore remembers the query behind each results thread so that
+// replies in the thread can refine it.
+type ThreadQueryStore interface {
+	SaveThreadQuery(ctx context.Context, roomID id.RoomID, root id.EventID, query string, at time.Time) error
+	ThreadQuery(ctx context.Context, roomID id.RoomID, root id.EventID) (string, error)
+}
+
+// refineFillers are words dropped from a refinement reply, so "only the rust
+// ones" narrows a search by "rust" alone.
+var refineFillers = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "about": {}, "for": {}, "just": {}, "me": {},
+	"more": {}, "of": {}, "on": {}, "one": {}, "ones": {}, "only": {},
+	"please": {}, "results": {}, "show": {}, "that": {}, "the": {}, "these": {},
+	"those": {}, "with": {},
+}
+
+// refineTerms returns the keywords of a refinement reply, without filler
+// words and surrounding punctuation.
+func refineTerms(body string) string {
+	var terms []string
+	for _, word := range strings.Fields(body) {
+		word = strings.Trim(word, `.,;!?"'()`)
+		if word == "" {
+			continue
+		}
+		if _, filler := refineFillers[strings.ToLower(word)]; filler {
+			continue
+		}
+		terms = append(terms, word)
+	}
+	return strings.Join(terms, " ")
+}
+
+// threadRoot is the thread a reply to msg is posted in: the thread msg was
+// sent in, or a new one rooted at msg.
+func threadRoot(msg matrix.Message) id.EventID {
+	if msg.ThreadRoot != "" {
+		return msg.ThreadRoot
+	}
+	return msg.EventID
+}
+
+// saveThreadQuery remembers query as the search behind the thread its
+// results are posted in.
+func (s *Service) saveThreadQuery(ctx context.Context, msg matrix.Message, query string) {
+	if s.threads == nil {
+		return
+	}
+	if err := s.threads.SaveThreadQuery(ctx, msg.RoomID, threadRoot(msg), query, s.now()); err != nil {
+		s.logf("save thread query failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+	}
+}
+
+// refineQuery returns the search to run for a reply in a results thread:
+// the thread's query narrowed by the keywords of the reply. It reports false
+// for messages outside results threads and replies without keywords.
+func (s *Service) refineQuery(ctx context.Context, msg matrix.Message, body string) (string, bool) {
+	if s.threads == nil || msg.ThreadRoot == "" || len(s.parser.ExtractURLs(body)) > 0 {
+		return "", false
+	}
+	terms := refineTerms(body)
+	if terms == "" {
+		return "", false
+	}
+	query, err := s.threads.ThreadQuery(ctx, msg.RoomID, msg.ThreadRoot)
+	if err != nil {
+		s.logf("load thread query failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return "", false
+	}
+	if query == "" {
+		return "", false
+	}
+	return query + " " + terms, true
+}
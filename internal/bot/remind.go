@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+)
+
+const (
+	remindCommand        = "/remind"
+	maxReminderDelay     = 365 * 24 * time.Hour
+	reminderPollInterval = 30 * time.Second
+	reminderBatchSize    = 50
+	// Reminders that still cannot be posted this long after they were due,
+	// e.g. because the bot left the room, are dropped.
+	reminderGiveUpAfter = 24 * time.Hour
+	reminderTimeLayout  = "2006-01-02 15:04 MST"
+)
+
+// ReminderStore persists reminders so they survive restarts.
+type ReminderStore interface {
+	AddReminder(ctx context.Context, r storage.Reminder) (int64, error)
+	DueReminders(ctx context.Context, now time.Time, limit int) ([]storage.Reminder, error)
+	DeleteReminder(ctx context.Context, reminderID int64) error
+}
+
+// reminderArgs returns what follows /remind or "remind me" in body. A
+// "remind me" phrase only counts when it parses, so ordinary chat starting
+// with those words is left alone.
+func (s *Service) reminderArgs(body string) (string, bool) {
+	if word, args, _ := strings.Cut(body, " "); strings.EqualFold(word, s.remind) {
+		return args, true
+	}
+	prefix := triggers.ReminderPhrase + " "
+	if len(body) <= len(prefix) || !strings.EqualFold(body[:len(prefix)], prefix) {
+		return "", false
+	}
+	args := body[len(prefix):]
+	if _, ok := triggers.ParseReminder(args); !ok {
+		return "", false
+	}
+	return args, true
+}
+
+// handleRemind saves a reminder posted later as a threaded reply to msg.
+func (s *Service) handleRemind(ctx context.Context, msg matrix.Message, args string) error {
+	reminder, ok := triggers.ParseReminder(args)
+	if !ok || reminder.Delay > maxReminderDelay || len(reminder.Text) > s.cfg.MaxQueryLen {
+		return s.reply(ctx, msg, s.text(msg, i18n.ReminderUsage, i18n.Vars{"command": s.remind}))
+	}
+
+	due := s.now().Add(reminder.Delay)
+	reminderID, err := s.reminders.AddReminder(ctx, storage.Reminder{
+		RoomID:  msg.RoomID,
+		EventID: msg.EventID,
+		UserID:  msg.Sender,
+		Text:    reminder.Text,
+		DueAt:   due,
+	})
+	if err != nil {
+		return fmt.Errorf("add reminder: %w", err)
+	}
+	s.logf("reminder added id=%d room=%s sender=%s due=%s", reminderID, msg.RoomID, msg.Sender, due.UTC().Format(time.RFC3339))
+	return s.reply(ctx, msg, s.text(msg, i18n.ReminderSet, i18n.Vars{"time": due.UTC().Format(reminderTimeLayout)}))
+}
+
+// RunReminders posts due reminders until ctx is done. Reminders that fell due
+// while the bot was down are posted on the first check.
+func (s *Service) RunReminders(ctx context.Context) {
+	if s.reminders == nil {
+		return
+	}
+	ticker := time.NewTicker(reminderPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sendDueReminders(ctx); err != nil {
+			s.logf("reminder check failed err=%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) sendDueReminders(ctx context.Context) error {
+	now := s.now()
+	due, err := s.reminders.DueReminders(ctx, now, reminderBatchSize)
+	if err != nil {
+		return fmt.Errorf("load due reminders: %w", err)
+	}
+	for _, r := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !s.ownsRoom(r.RoomID) {
+			continue
+		}
+		body := s.cfg.Messages.Text(string(r.RoomID), i18n.ReminderDue, i18n.Vars{"user": string(r.UserID), "text": r.Text})
+		err := s.replier.SendReply(ctx, s.relate(matrix.Reply{RoomID: r.RoomID, Body: body}, r.EventID, r.EventID))
+		if err != nil {
+			s.logf("reminder send failed id=%d room=%s err=%v", r.ID, r.RoomID, err)
+			if now.Sub(r.DueAt) < reminderGiveUpAfter {
+				continue
+			}
+			s.logf("dropping reminder id=%d due=%s", r.ID, r.DueAt.UTC().Format(time.RFC3339))
+		}
+		if err := s.reminders.DeleteReminder(ctx, r.ID); err != nil {
+			s.logf("reminder delete failed id=%d err=%v", r.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"path"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// SenderFilter restricts whose links are indexed. Entries are user IDs or
+// glob patterns over them, e.g. "@*:matrix.org" for a whole server or
+// "@telegram_*:bridge.example.org" for a bridge's puppets.
+type SenderFilter struct {
+	// Allow, when not empty, lists the only senders whose links are indexed.
+	Allow []string
+	// Deny lists senders whose links are never indexed, even if allowed.
+	Deny []string
+}
+
+// Allows reports whether userID's links may be indexed.
+func (f SenderFilter) Allows(userID id.UserID) bool {
+	if matchesSender(f.Deny, userID) {
+		return false
+	}
+	return len(f.Allow) == 0 || matchesSender(f.Allow, userID)
+}
+
+func matchesSender(patterns []string, userID id.UserID) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, string(userID)); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
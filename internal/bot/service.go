@@ -0,0 +1,1151 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultCatchMeUpWindow and DefaultCatchMeUpMaxMessages bound the history
+// /catchmeup summarizes unless CatchMeUpLimits says otherwise.
+const (
+	DefaultCatchMeUpWindow      = 24 * time.Hour
+	DefaultCatchMeUpMaxMessages = 40
+)
+
+const (
+	catchMeUpCommand = "/catchmeup"
+	broadcastCommand = "/broadcast"
+	versionCommand   = "/version"
+	maxSnippetLen    = 200
+	// Search operators are applied client-side, so fetch extra results to filter from.
+	filteredSearchOverfetch = 5
+)
+
+const (
+	actionSearch    = "search"
+	actionCatchMeUp = "catchmeup"
+	actionBroadcast = "broadcast"
+)
+
+// Reply modes for Config.ReplyMode select how the bot answers a message: in
+// a thread started from it, as an m.in_reply_to reply, or as a plain room
+// message.
+const (
+	ReplyThread = "thread"
+	ReplyReply  = "reply"
+	ReplyRoom   = "room"
+)
+
+// Command typo handling modes.
+const (
+	TyposOff     = "off"
+	TyposSuggest = "suggest"
+	TyposExecute = "execute"
+)
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type TriggerParser interface {
+	ExtractSearchQuery(msg, botDisplayName string) (query string, ok bool)
+	ExtractURLs(msg string) []string
+}
+
+type Replier interface {
+	SendReply(ctx context.Context, reply matrix.Reply) error
+}
+
+type HistoryReader interface {
+	GetRecentTextMessages(ctx context.Context, roomID id.RoomID, since time.Time, max int) ([]matrix.RoomMessage, error)
+}
+
+type MessageFetcher interface {
+	GetTextMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID) (matrix.RoomMessage, error)
+}
+
+type Reactor interface {
+	SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) error
+}
+
+// LinkStore persists metadata about shared links: who shared them, when, and
+// which hashtags they were shared with.
+type LinkStore interface {
+	RecordSharedLink(ctx context.Context, link storage.SharedLink) error
+	AddDocumentTags(ctx context.Context, rawURL string, tags []string) error
+	FindLinks(ctx context.Context, filter storage.LinkFilter) ([]string, error)
+}
+
+// SearchLog keeps the query history behind the weekly top-links report.
+type SearchLog interface {
+	RecordSearch(ctx context.Context, search storage.Search) error
+}
+
+type Summarizer interface {
+	Summarize(ctx context.Context, messages []matrix.RoomMessage) (string, error)
+}
+
+// CatchMeUpLimits bounds the history one /catchmeup reads: messages from the
+// last Window, at most MaxMessages of them.
+type CatchMeUpLimits struct {
+	Window      time.Duration
+	MaxMessages int
+}
+
+type Config struct {
+	// BotUserID is used to detect mention pills in formatted messages.
+	BotUserID      id.UserID
+	BotDisplayName string
+	// CommandPrefix replaces the leading "/" of built-in commands such as
+	// /catchmeup. Empty keeps "/".
+	CommandPrefix string
+	// SearchCommand is the (prefixed) search command, used to recognize
+	// mistyped commands.
+	SearchCommand string
+	// CommandTypos selects how near-miss commands such as "/serach" are
+	// handled: TyposSuggest replies with the likely command, TyposExecute runs
+	// it, and TyposOff (or empty) ignores them.
+	CommandTypos      string
+	MaxResults        int
+	MaxQueryLen       int
+	ReplyMode         string
+	SearchCooldown    Limit
+	CatchMeUpCooldown Limit
+	// Admins may run admin-only commands such as /broadcast.
+	Admins []id.UserID
+	// BroadcastRooms are the rooms /broadcast posts to, normally the allowed
+	// rooms. BroadcastRoomLimit throttles announcements per room.
+	BroadcastRooms     []id.RoomID
+	BroadcastRoomLimit Limit
+	// IgnorePrefixes are message prefixes (matched case-insensitively) that
+	// make the bot skip a message entirely, including URL indexing.
+	IgnorePrefixes []string
+	// CustomTriggers are checked in order when no built-in trigger matches.
+	CustomTriggers []triggers.CustomTrigger
+	// IndexReaction is the reaction key that asks the bot to index the URLs in
+	// the reacted-to message. Empty disables reaction-triggered indexing.
+	IndexReaction string
+	// IndexedReaction is the key the bot reacts with once indexing succeeded.
+	IndexedReaction string
+	// AckIndexing also reacts to messages whose links were indexed
+	// automatically: with IndexedReaction when any link was indexed and with
+	// FailedReaction when any failed. An empty key skips that reaction.
+	AckIndexing    bool
+	FailedReaction string
+	// MaxAutoIndexURLs caps how many URLs a single message may index without
+	// confirmation. Zero means no limit.
+	MaxAutoIndexURLs int
+	// RecrawlAfter is how long a shared URL counts as indexed: sharing it
+	// again sooner records the share without fetching and adding the page
+	// again. Zero re-indexes every share. It needs Deps.IndexLog.
+	RecrawlAfter time.Duration
+	// BulkIndexConfirmReaction is the reaction the sender adds to their own
+	// message to confirm indexing more than MaxAutoIndexURLs links.
+	BulkIndexConfirmReaction string
+	// URLPreviews replies to shared URLs with their title and a one-line
+	// description once they are indexed. The backend must implement
+	// ContentIndexer.
+	URLPreviews bool
+	// IndexSources adds the room, sender, event and time a link was shared
+	// to its document, and shows who shared results, when and where. It is
+	// off by default since anyone searching the index would see that. The
+	// backend must implement SourceIndexer.
+	IndexSources bool
+	// IndexFiles indexes uploaded text, markdown, HTML and PDF files. It
+	// needs Deps.Files and a backend implementing ChatIndexer.
+	IndexFiles bool
+	// IndexSenders limits whose links and files are indexed. For links
+	// indexed by reaction it applies to the user who reacted.
+	IndexSenders SenderFilter
+	// IgnoreBots recognizes other bots, whose messages, files and reactions
+	// are ignored entirely.
+	IgnoreBots BotFilter
+	// IndexFailureNotices is IndexFailuresThread or IndexFailuresDirect to
+	// tell sharers which of their links failed to index and why. Empty or
+	// IndexFailuresOff keeps failures in the log only.
+	IndexFailureNotices string
+	// BackfillDepth is how many past messages a history backfill reads.
+	// Zero disables backfilling, including the admin /backfill command.
+	BackfillDepth int
+	// BackfillOnJoin backfills a room's history when the bot joins it.
+	BackfillOnJoin bool
+	// WelcomeOnJoin posts the welcome template once in each room the bot
+	// joins.
+	WelcomeOnJoin bool
+	// WatchInterval is how often saved searches are re-run; WatchSchedule,
+	// when set, replaces it. MaxWatchesPerUser caps how many each user may
+	// keep; zero means no limit.
+	WatchInterval     time.Duration
+	WatchSchedule     schedule.Schedule
+	MaxWatchesPerUser int
+	// OwnsRoom, when set, limits RunWatches and RunReminders to the watches
+	// and reminders of the rooms it reports true for, so services of several
+	// accounts can share one store.
+	OwnsRoom func(roomID id.RoomID) bool
+	// DeadLinkAction is DeadLinksDemote or DeadLinksHide and selects how
+	// results found dead are shown. Empty demotes them.
+	DeadLinkAction string
+	// CatchMeUpHistory is HistoryLimit, HistoryRefuse or HistoryOff and
+	// selects how /catchmeup treats history the requester could not see.
+	// Empty limits. RoomCatchMeUpHistory overrides it per room.
+	CatchMeUpHistory     string
+	RoomCatchMeUpHistory map[id.RoomID]string
+	// CatchMeUpLimits bounds the history /catchmeup summarizes;
+	// RoomCatchMeUpLimits overrides it per room. Zero fields use the
+	// defaults.
+	CatchMeUpLimits     CatchMeUpLimits
+	RoomCatchMeUpLimits map[id.RoomID]CatchMeUpLimits
+	// SpoilerRooms are rooms where every result snippet is wrapped in a
+	// spoiler. Elsewhere only snippets from SensitiveDomains sites (and their
+	// subdomains) are.
+	SpoilerRooms     []id.RoomID
+	SensitiveDomains []string
+	// IndexSummaries indexes /catchmeup summaries so searches in the same
+	// room find them. The backend must implement ChatIndexer.
+	IndexSummaries bool
+	// ChatSearchRooms have their text messages indexed for "/search chat".
+	// It needs a backend implementing ChatIndexer.
+	ChatSearchRooms []id.RoomID
+	// SearchCacheTTL is how long a room's search results are reused for the
+	// same query. Zero disables caching.
+	SearchCacheTTL time.Duration
+	// ResultPages is how many pages of MaxResults results a search keeps.
+	// Further pages are shown by editing the results message on /next and
+	// /prev, which needs a Replier implementing EventReplier. Zero or one
+	// sends a single page.
+	ResultPages int
+	// ResultReactions adds reactions to results messages that page through
+	// the results and, for admins, delete the top result of the page from
+	// the index. It needs a Reactor and a Replier implementing EventReplier.
+	ResultReactions bool
+	// Templates render search results and /catchmeup summaries. Nil uses the
+	// built-in formats.
+	Templates *render.Templates
+	// Version describes the running build for /version.
+	Version string
+	// Messages renders user-facing replies in each room's language. Nil
+	// renders the built-in English responses.
+	Messages *i18n.Catalog
+}
+
+// Deps are the collaborators used by Service. History and Summarizer are
+// optional; /catchmeup is ignored when either is missing. Likewise reaction
+// indexing needs both Messages and Reactor.
+type Deps struct {
+	Backend    hister.SearchBackend
+	Parser     TriggerParser
+	Replier    Replier
+	History    HistoryReader
+	Summarizer Summarizer
+	Messages   MessageFetcher
+	Reactor    Reactor
+	// Links enables #hashtag tagging and the tag:, from:, before: and after:
+	// search operators when set.
+	Links LinkStore
+	// State remembers which rooms were backfilled. Without it a room is
+	// backfilled again every time the bot joins it.
+	State StateStore
+	// Watches enables the /watch and /unwatch saved search commands.
+	Watches WatchStore
+	// Reminders enables /remind and "remind me in ..." reminders.
+	Reminders ReminderStore
+	// DeadLinks marks results the dead link checker found dead.
+	DeadLinks DeadLinkStore
+	// Searches records each search and its results when set.
+	Searches SearchLog
+	// Threads enables refining a search by replying in its results thread.
+	Threads ThreadQueryStore
+	// Documents adds the index size and closest titles to searches that
+	// find nothing.
+	Documents DocumentStore
+	// RoomDocuments records the chat messages and summaries indexed from
+	// each room, so retention can expire them.
+	RoomDocuments RoomDocumentStore
+	// Redactions removes redacted messages from the index when set.
+	Redactions RedactionStore
+	// IndexLog skips re-indexing URLs indexed within Config.RecrawlAfter.
+	IndexLog IndexLog
+	// Keys enables the admin /exportkeys and /importkeys commands.
+	Keys KeyManager
+	// Files downloads uploaded files for Config.IndexFiles.
+	Files FileDownloader
+	// Direct sends index failure notices in IndexFailuresDirect mode.
+	// Without it they are posted in the message's thread instead.
+	Direct DirectMessenger
+	// Visibility limits /catchmeup to history the requester may read.
+	// Without it history visibility is not checked.
+	Visibility VisibilityChecker
+	Logger     Logger
+}
+
+// Service implements the bot message flow: URL indexing, search triggers and
+// room catch-up summaries.
+type Service struct {
+	cfg        Config
+	backend    hister.SearchBackend
+	parser     TriggerParser
+	replier    Replier
+	history    HistoryReader
+	summarizer Summarizer
+	messages   MessageFetcher
+	reactor    Reactor
+	links      LinkStore
+	indexLog   IndexLog
+	state      StateStore
+	watches    WatchStore
+	reminders  ReminderStore
+	deadLinks  DeadLinkStore
+	searches   SearchLog
+	threads    ThreadQueryStore
+	documents  DocumentStore
+	roomDocs   RoomDocumentStore
+	redactions RedactionStore
+	keys       KeyManager
+	files      FileDownloader
+	visibility VisibilityChecker
+	direct     DirectMessenger
+	logger     Logger
+	cooldowns  *cooldowns
+	pending    *pendingIndexes
+	cache      *resultCache
+	pages      *resultPages
+	now        func() time.Time
+	catchMeUp  string
+	broadcast  string
+	backfill   string
+	watch      string
+	unwatch    string
+	remind     string
+	exportKeys string
+	importKeys string
+	version    string
+	nextPage   string
+	prevPage   string
+	forget     string
+	backfills  chan backfillRequest
+}
+
+func NewService(cfg Config, deps Deps) (*Service, error) {
+	if deps.Backend == nil {
+		return nil, errors.New("search backend is required")
+	}
+	if deps.Parser == nil {
+		return nil, errors.New("trigger parser is required")
+	}
+	if deps.Replier == nil {
+		return nil, errors.New("replier is required")
+	}
+	if cfg.MaxResults <= 0 {
+		return nil, errors.New("max results must be greater than zero")
+	}
+	if cfg.MaxQueryLen <= 0 {
+		return nil, errors.New("max query length must be greater than zero")
+	}
+
+	return &Service{
+		cfg:        cfg,
+		backend:    deps.Backend,
+		parser:     deps.Parser,
+		replier:    deps.Replier,
+		history:    deps.History,
+		summarizer: deps.Summarizer,
+		messages:   deps.Messages,
+		reactor:    deps.Reactor,
+		links:      deps.Links,
+		indexLog:   deps.IndexLog,
+		state:      deps.State,
+		watches:    deps.Watches,
+		reminders:  deps.Reminders,
+		deadLinks:  deps.DeadLinks,
+		searches:   deps.Searches,
+		threads:    deps.Threads,
+		documents:  deps.Documents,
+		roomDocs:   deps.RoomDocuments,
+		redactions: deps.Redactions,
+		keys:       deps.Keys,
+		files:      deps.Files,
+		visibility: deps.Visibility,
+		direct:     deps.Direct,
+		logger:     deps.Logger,
+		cooldowns: newCooldowns(map[string]Limit{
+			actionSearch:    cfg.SearchCooldown,
+			actionCatchMeUp: cfg.CatchMeUpCooldown,
+			actionBroadcast: cfg.BroadcastRoomLimit,
+		}),
+		pending:    newPendingIndexes(),
+		cache:      newResultCache(cfg.SearchCacheTTL),
+		pages:      newResultPages(),
+		now:        time.Now,
+		catchMeUp:  triggers.PrefixCommand(catchMeUpCommand, cfg.CommandPrefix),
+		broadcast:  triggers.PrefixCommand(broadcastCommand, cfg.CommandPrefix),
+		backfill:   triggers.PrefixCommand(backfillCommand, cfg.CommandPrefix),
+		watch:      triggers.PrefixCommand(watchCommand, cfg.CommandPrefix),
+		unwatch:    triggers.PrefixCommand(unwatchCommand, cfg.CommandPrefix),
+		remind:     triggers.PrefixCommand(remindCommand, cfg.CommandPrefix),
+		exportKeys: triggers.PrefixCommand(exportKeysCommand, cfg.CommandPrefix),
+		importKeys: triggers.PrefixCommand(importKeysCommand, cfg.CommandPrefix),
+		version:    triggers.PrefixCommand(versionCommand, cfg.CommandPrefix),
+		nextPage:   triggers.PrefixCommand(nextPageCommand, cfg.CommandPrefix),
+		prevPage:   triggers.PrefixCommand(prevPageCommand, cfg.CommandPrefix),
+		forget:     triggers.PrefixCommand(forgetCommand, cfg.CommandPrefix),
+		backfills:  make(chan backfillRequest, backfillQueueSize),
+	}, nil
+}
+
+func (s *Service) HandleMatrixMessage(ctx context.Context, msg matrix.Message) error {
+	body := strings.TrimSpace(msg.Body)
+	if body == "" || s.ignored(body) {
+		return nil
+	}
+	if s.cfg.IgnoreBots.IsBot(msg.Sender, msg.Notice) {
+		s.logf("ignoring message from bot room=%s event=%s sender=%s", msg.RoomID, msg.EventID, msg.Sender)
+		return nil
+	}
+
+	if word, args, _ := strings.Cut(body, " "); strings.EqualFold(word, s.forget) {
+		return s.handleForget(ctx, msg, args)
+	}
+	// A custom index trigger indexes its URL itself and replies with how
+	// that went.
+	custom, isCustom := triggers.MatchCustomTrigger(s.cfg.CustomTriggers, body)
+	if !isCustom || custom.Action != triggers.ActionIndex {
+		s.indexURLs(ctx, msg, body)
+	}
+
+	if strings.EqualFold(body, s.catchMeUp) {
+		return s.handleCatchMeUp(ctx, msg, false)
+	}
+	if isCatchMeUpSinceLast(body, s.catchMeUp) {
+		return s.handleCatchMeUp(ctx, msg, true)
+	}
+	if strings.EqualFold(body, s.version) && s.cfg.Version != "" {
+		return s.reply(ctx, msg, s.text(msg, i18n.VersionInfo, i18n.Vars{"version": s.cfg.Version}))
+	}
+	word, args, _ := strings.Cut(body, " ")
+	if strings.EqualFold(word, s.broadcast) {
+		return s.handleBroadcast(ctx, msg, args)
+	}
+	if s.watches != nil && strings.EqualFold(word, s.watch) {
+		return s.handleWatch(ctx, msg, args)
+	}
+	if s.watches != nil && strings.EqualFold(word, s.unwatch) {
+		return s.handleUnwatch(ctx, msg, args)
+	}
+	if args, ok := s.reminderArgs(body); ok && s.reminders != nil {
+		return s.handleRemind(ctx, msg, args)
+	}
+	if strings.EqualFold(word, s.backfill) {
+		return s.handleBackfill(ctx, msg, args)
+	}
+	if s.keys != nil && strings.EqualFold(word, s.exportKeys) {
+		return s.handleExportKeys(ctx, msg, args)
+	}
+	if s.keys != nil && strings.EqualFold(word, s.importKeys) {
+		return s.handleImportKeys(ctx, msg, args)
+	}
+	if delta, ok := s.pageDelta(msg, body); ok {
+		return s.turnPage(ctx, msg, delta)
+	}
+
+	if query, ok := s.mentionQuery(msg, body); ok {
+		return s.handleSearch(ctx, msg, query)
+	}
+	if query, ok := s.parser.ExtractSearchQuery(body, s.textMentionName(msg)); ok {
+		return s.handleSearch(ctx, msg, query)
+	}
+
+	if isCustom {
+		return s.handleCustomTrigger(ctx, msg, custom)
+	}
+	if query, ok := s.refineQuery(ctx, msg, body); ok {
+		return s.handleSearch(ctx, msg, query)
+	}
+	s.indexChatMessage(ctx, msg, body)
+	return s.handleCommandTypo(ctx, msg, body)
+}
+
+// mentionQuery returns the query of a message mentioning the bot with a pill
+// or in its m.mentions. A reply's m.mentions also lists the sender of the
+// replied-to message, so a reply to the bot only counts with a pill. A
+// search command after the mention is unwrapped.
+func (s *Service) mentionQuery(msg matrix.Message, body string) (string, bool) {
+	query, ok := triggers.ExtractPillQuery(msg.FormattedBody, string(s.cfg.BotUserID))
+	if !ok && msg.ReplyTo == "" && s.cfg.BotUserID != "" && slices.Contains(msg.Mentions, s.cfg.BotUserID) {
+		query, ok = triggers.StripMention(body, string(s.cfg.BotUserID), s.cfg.BotDisplayName)
+	}
+	if !ok {
+		return "", false
+	}
+	if inner, ok := s.parser.ExtractSearchQuery(query, ""); ok {
+		query = inner
+	}
+	return query, true
+}
+
+// textMentionName is the display name to look for as a typed "@name"
+// mention. Messages with m.mentions that do not list the bot did not mention
+// it, whatever their text says.
+func (s *Service) textMentionName(msg matrix.Message) string {
+	if msg.HasMentions && !slices.Contains(msg.Mentions, s.cfg.BotUserID) {
+		return ""
+	}
+	return s.cfg.BotDisplayName
+}
+
+// handleCommandTypo treats a message whose first word is a near miss of a
+// known command according to the CommandTypos mode.
+func (s *Service) handleCommandTypo(ctx context.Context, msg matrix.Message, body string) error {
+	if s.cfg.CommandTypos != TyposSuggest && s.cfg.CommandTypos != TyposExecute {
+		return nil
+	}
+	word, rest, _ := strings.Cut(body, " ")
+	rest = strings.TrimSpace(rest)
+	commands := []string{s.catchMeUp, s.version}
+	if s.cfg.SearchCommand != "" {
+		commands = append(commands, s.cfg.SearchCommand)
+	}
+	command, ok := triggers.NearestCommand(word, commands)
+	if !ok {
+		return nil
+	}
+
+	if s.cfg.CommandTypos == TyposExecute {
+		switch {
+		case command == s.catchMeUp && rest == "":
+			s.logf("running %s for mistyped %q room=%s event=%s", command, word, msg.RoomID, msg.EventID)
+			return s.handleCatchMeUp(ctx, msg, false)
+		case command == s.cfg.SearchCommand && rest != "":
+			s.logf("running %s for mistyped %q room=%s event=%s", command, word, msg.RoomID, msg.EventID)
+			return s.handleSearch(ctx, msg, rest)
+		}
+	}
+	return s.reply(ctx, msg, s.text(msg, i18n.DidYouMean, i18n.Vars{"command": command}))
+}
+
+func (s *Service) handleCustomTrigger(ctx context.Context, msg matrix.Message, match triggers.CustomMatch) error {
+	switch match.Action {
+	case triggers.ActionSearch:
+		return s.handleSearch(ctx, msg, match.Value)
+	case triggers.ActionIndex:
+		if !s.cfg.IndexSenders.Allows(msg.Sender) || s.cfg.IgnoreBots.IsBot(msg.Sender, msg.Notice) {
+			s.logf("not indexing urls from sender room=%s event=%s sender=%s", msg.RoomID, msg.EventID, msg.Sender)
+			return nil
+		}
+		urls := s.parser.ExtractURLs(match.Value)
+		if len(urls) == 0 || s.indexAll(ctx, msg, urls[:1], s.hashtags(msg.Body)) == 0 {
+			return s.reply(ctx, msg, s.text(msg, i18n.IndexFailed, nil))
+		}
+		return s.reply(ctx, msg, s.text(msg, i18n.Indexed, i18n.Vars{"url": urls[0]}))
+	case triggers.ActionReply:
+		return s.reply(ctx, msg, match.Value)
+	}
+	return nil
+}
+
+// HandleMatrixReaction indexes the URLs of the reacted-to message when the
+// reaction matches the configured index reaction.
+func (s *Service) HandleMatrixReaction(ctx context.Context, reaction matrix.Reaction) error {
+	if s.cfg.IgnoreBots.IsBot(reaction.Sender, false) {
+		return nil
+	}
+	if handled, err := s.handleResultReaction(ctx, reaction); handled {
+		return err
+	}
+	if sameReactionKey(reaction.Key, s.cfg.BulkIndexConfirmReaction) {
+		if handled, err := s.confirmBulkIndex(ctx, reaction); handled {
+			return err
+		}
+	}
+	if s.messages == nil || s.reactor == nil || !sameReactionKey(reaction.Key, s.cfg.IndexReaction) {
+		return nil
+	}
+	if !s.cfg.IndexSenders.Allows(reaction.Sender) {
+		s.logf("ignoring index reaction from sender room=%s event=%s sender=%s", reaction.RoomID, reaction.EventID, reaction.Sender)
+		return nil
+	}
+
+	target, err := s.messages.GetTextMessage(ctx, reaction.RoomID, reaction.TargetEventID)
+	if err != nil {
+		return fmt.Errorf("fetch reacted message: %w", err)
+	}
+
+	msg := matrix.Message{RoomID: reaction.RoomID, EventID: reaction.TargetEventID, Sender: target.Sender, Body: target.Body}
+	indexed := s.indexAll(ctx, msg, dedupe(s.parser.ExtractURLs(target.Body)), s.hashtags(target.Body))
+	s.pending.take(reaction.TargetEventID)
+	if indexed == 0 || strings.TrimSpace(s.cfg.IndexedReaction) == "" {
+		return nil
+	}
+	return s.reactor.SendReaction(ctx, reaction.RoomID, reaction.TargetEventID, s.cfg.IndexedReaction)
+}
+
+func (s *Service) ignored(body string) bool {
+	lower := strings.ToLower(body)
+	for _, prefix := range s.cfg.IgnorePrefixes {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if prefix != "" && strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) indexURLs(ctx context.Context, msg matrix.Message, body string) {
+	urls := dedupe(s.parser.ExtractURLs(body))
+	if len(urls) == 0 {
+		return
+	}
+	if !s.cfg.IndexSenders.Allows(msg.Sender) {
+		s.logf("not indexing urls from sender room=%s event=%s sender=%s", msg.RoomID, msg.EventID, msg.Sender)
+		return
+	}
+	if s.cfg.MaxAutoIndexURLs > 0 && len(urls) > s.cfg.MaxAutoIndexURLs {
+		s.requestBulkIndexConfirmation(ctx, msg, urls, s.hashtags(body))
+		return
+	}
+	indexed := s.indexAll(ctx, msg, urls, s.hashtags(body))
+	s.ackIndexing(ctx, msg, indexed, len(urls)-indexed)
+}
+
+// ackIndexing reacts to msg with the outcome of indexing its links when
+// AckIndexing is set. Failures to react are only logged.
+func (s *Service) ackIndexing(ctx context.Context, msg matrix.Message, indexed, failed int) {
+	if !s.cfg.AckIndexing || s.reactor == nil || msg.EventID == "" {
+		return
+	}
+	for _, ack := range []struct {
+		count int
+		key   string
+	}{{indexed, s.cfg.IndexedReaction}, {failed, s.cfg.FailedReaction}} {
+		if ack.count == 0 || strings.TrimSpace(ack.key) == "" {
+			continue
+		}
+		if err := s.reactor.SendReaction(ctx, msg.RoomID, msg.EventID, ack.key); err != nil {
+			s.logf("index ack reaction failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		}
+	}
+}
+
+// indexAll indexes urls shared in msg and returns how many succeeded.
+func (s *Service) indexAll(ctx context.Context, msg matrix.Message, urls []string, tags []string) int {
+	var previews []preview
+	var failures []indexFailure
+	indexed := 0
+	for _, rawURL := range urls {
+		if s.indexedRecently(ctx, msg, rawURL) {
+			s.logf("skipping recently indexed url room=%s event=%s url=%s", msg.RoomID, msg.EventID, rawURL)
+			s.recordLink(ctx, msg, rawURL, tags)
+			indexed++
+			continue
+		}
+		content, err := s.indexURL(ctx, msg, rawURL)
+		if err != nil {
+			s.logf("index url failed room=%s event=%s url=%s err=%v", msg.RoomID, msg.EventID, rawURL, err)
+			failures = append(failures, indexFailure{url: rawURL, reason: hister.ClassifyIndexError(err)})
+			continue
+		}
+		s.logf("indexed url room=%s event=%s url=%s", msg.RoomID, msg.EventID, rawURL)
+		s.markIndexed(ctx, msg.RoomID, rawURL)
+		s.recordLink(ctx, msg, rawURL, tags)
+		s.recordTitle(ctx, rawURL, content.Title)
+		previews = append(previews, preview{url: rawURL, content: content})
+	}
+	s.sendPreviews(ctx, msg, previews)
+	s.notifyIndexFailures(ctx, msg, failures)
+	return indexed + len(previews)
+}
+
+func (s *Service) hashtags(body string) []string {
+	if s.links == nil {
+		return nil
+	}
+	return triggers.ExtractHashtags(body)
+}
+
+func (s *Service) recordLink(ctx context.Context, msg matrix.Message, rawURL string, tags []string) {
+	s.recordLinkAt(ctx, msg, rawURL, tags, s.now())
+}
+
+// recordLinkAt records a link shared at a given time, such as a message found
+// while backfilling history.
+func (s *Service) recordLinkAt(ctx context.Context, msg matrix.Message, rawURL string, tags []string, sharedAt time.Time) {
+	if s.links == nil {
+		return
+	}
+	err := s.links.RecordSharedLink(ctx, storage.SharedLink{
+		URL:      rawURL,
+		RoomID:   msg.RoomID,
+		EventID:  msg.EventID,
+		Sender:   msg.Sender,
+		SharedAt: sharedAt,
+	})
+	if err != nil {
+		s.logf("record link failed room=%s event=%s url=%s err=%v", msg.RoomID, msg.EventID, rawURL, err)
+	}
+	if len(tags) == 0 {
+		return
+	}
+	if err := s.links.AddDocumentTags(ctx, rawURL, tags); err != nil {
+		s.logf("tag url failed room=%s event=%s url=%s err=%v", msg.RoomID, msg.EventID, rawURL, err)
+	}
+}
+
+func (s *Service) handleSearch(ctx context.Context, msg matrix.Message, query string) error {
+	query, refresh := splitRefreshFlag(query)
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return s.reply(ctx, msg, s.text(msg, i18n.InvalidQuery, nil))
+	}
+	if s.queryTooLong(query) {
+		return s.replyQueryTooLong(ctx, msg)
+	}
+	if wait, ok := s.cooldowns.Allow(msg.Sender, actionSearch); !ok {
+		return s.reply(ctx, msg, s.text(msg, i18n.CooldownSearch, i18n.Vars{"wait": formatWait(wait)}))
+	}
+	if terms, ok := s.chatSearchTerms(msg.RoomID, query); ok {
+		return s.handleChatSearch(ctx, msg, terms)
+	}
+
+	var results []hister.SearchResult
+	cached := false
+	if !refresh {
+		results, cached = s.cache.Get(msg.RoomID, query)
+	}
+	if !cached {
+		var err error
+		if results, err = s.search(ctx, msg.RoomID, query, s.resultLimit()); err != nil {
+			s.logf("search failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+			return s.reply(ctx, msg, s.searchFailedText(msg, err))
+		}
+		s.cache.Put(msg.RoomID, query, results)
+		s.recordTitles(ctx, results)
+	}
+	fetched := len(results)
+	results = s.applyDeadLinks(ctx, msg.RoomID, results)
+	s.recordSearch(ctx, msg, query, results)
+	s.saveThreadQuery(ctx, msg, query)
+	if len(results) == 0 {
+		return s.replyNoResults(ctx, msg, query)
+	}
+	header := s.text(msg, i18n.SearchResults, i18n.Vars{"query": query})
+	if cached {
+		header += " " + s.text(msg, i18n.SearchCached, nil)
+	}
+	if s.plainSearch(query) {
+		return s.replySearchResults(ctx, msg, header, query, results, fetched)
+	}
+	return s.replyResults(ctx, msg, header, results)
+}
+
+func (s *Service) recordSearch(ctx context.Context, msg matrix.Message, query string, results []hister.SearchResult) {
+	if s.searches == nil {
+		return
+	}
+	err := s.searches.RecordSearch(ctx, storage.Search{
+		RoomID:     msg.RoomID,
+		Sender:     msg.Sender,
+		Query:      query,
+		URLs:       resultURLs(results),
+		SearchedAt: s.now(),
+	})
+	if err != nil {
+		s.logf("record search failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+	}
+}
+
+// ownsRoom reports whether the background work of roomID is this service's.
+func (s *Service) ownsRoom(roomID id.RoomID) bool {
+	return s.cfg.OwnsRoom == nil || s.cfg.OwnsRoom(roomID)
+}
+
+// search runs query for a search in roomID, returning up to limit results.
+// Search operators are parsed out of the query: site:, room:, after: and
+// before: are forwarded to a FilteredSearcher backend, and tag:, from:,
+// room:, after: and before: are resolved against the link store when one is
+// configured, limited to links shared in roomID unless room: names other
+// rooms that share its index. Results are still checked against site: and
+// the link store. Indexed room messages and other rooms' summaries are left
+// out.
+func (s *Service) search(ctx context.Context, roomID id.RoomID, query string, limit int) ([]hister.SearchResult, error) {
+	q := triggers.ParseSearchQuery(query)
+	backend := s.roomBackend(roomID)
+	if s.plainSearch(query) {
+		return backend.Search(ctx, query, limit)
+	}
+	if !q.HasFilters() {
+		// Indexed room messages and summaries share the index, so fetch
+		// extra to make up for the ones dropped.
+		results, err := backend.Search(ctx, query, limit*filteredSearchOverfetch)
+		if err != nil {
+			return nil, err
+		}
+		return filterResults(roomResults(results, roomID), nil, nil, limit), nil
+	}
+
+	var allowed map[string]struct{}
+	if q.HasLinkFilters() && s.links != nil {
+		// The link store holds every room's links, so only those this
+		// room's searches may see count.
+		rooms := s.linkRooms(roomID, q.Rooms)
+		if len(rooms) == 0 {
+			return nil, nil
+		}
+		urls, err := s.links.FindLinks(ctx, storage.LinkFilter{
+			Tags:    q.Tags,
+			Senders: q.From,
+			Rooms:   rooms,
+			After:   q.After,
+			Before:  q.Before,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("find links: %w", err)
+		}
+		if q.Text == "" {
+			return filterResults(linkResults(urls), q.Sites, nil, limit), nil
+		}
+		allowed = make(map[string]struct{}, len(urls))
+		for _, u := range urls {
+			allowed[u] = struct{}{}
+		}
+	}
+
+	text := q.Text
+	if text == "" {
+		if len(q.Sites) == 0 {
+			return nil, nil
+		}
+		text = strings.Join(q.Sites, " ")
+	}
+	results, err := searchFiltered(ctx, backend, text, q, limit*filteredSearchOverfetch)
+	if err != nil {
+		return nil, err
+	}
+	return filterResults(roomResults(results, roomID), q.Sites, allowed, limit), nil
+}
+
+// FilteredSearcher is implemented by search backends that can apply search
+// operators themselves. Searches with operators forward them when it is
+// available, and still check the results against them.
+type FilteredSearcher interface {
+	SearchFiltered(ctx context.Context, query string, filters hister.Filters, offset, limit int) (hister.Page, error)
+}
+
+// searchFiltered searches backend for text with the site:, room: and date
+// operators of q forwarded if it is a FilteredSearcher.
+func searchFiltered(ctx context.Context, backend hister.SearchBackend, text string, q triggers.SearchQuery, limit int) ([]hister.SearchResult, error) {
+	searcher, ok := backend.(FilteredSearcher)
+	if !ok {
+		return backend.Search(ctx, text, limit)
+	}
+	page, err := searcher.SearchFiltered(ctx, text, hister.Filters{
+		Sites:  q.Sites,
+		After:  q.After,
+		Before: q.Before,
+		Rooms:  q.Rooms,
+	}, 0, limit)
+	return page.Results, err
+}
+
+// plainSearch reports whether query is passed to the backend as is, its
+// results shown unfiltered.
+func (s *Service) plainSearch(query string) bool {
+	return !triggers.ParseSearchQuery(query).HasFilters() && len(s.cfg.ChatSearchRooms) == 0 && !s.cfg.IndexSummaries
+}
+
+// handleCatchMeUp summarizes the room's recent messages. With sinceLast it
+// summarizes the messages after the room's last summary instead, falling
+// back to the usual window when there was none.
+func (s *Service) handleCatchMeUp(ctx context.Context, msg matrix.Message, sinceLast bool) error {
+	if s.history == nil || s.summarizer == nil {
+		return nil
+	}
+	if wait, ok := s.cooldowns.Allow(msg.Sender, actionCatchMeUp); !ok {
+		return s.reply(ctx, msg, s.text(msg, i18n.CooldownCatchMeUp, i18n.Vars{"wait": formatWait(wait)}))
+	}
+
+	limits := s.catchMeUpLimits(msg.RoomID)
+	since := s.now().Add(-limits.Window)
+	var last matrix.SummaryPosition
+	if sinceLast {
+		if last, sinceLast = s.lastSummary(ctx, msg.RoomID); sinceLast {
+			since = last.Timestamp
+		}
+	}
+	since, ok, err := s.catchMeUpSince(ctx, msg, since)
+	if err != nil {
+		s.logf("catchmeup visibility check failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.CatchMeUpFailed, nil))
+	}
+	if !ok {
+		return s.reply(ctx, msg, s.text(msg, i18n.CatchMeUpRestricted, nil))
+	}
+	messages, err := s.history.GetRecentTextMessages(ctx, msg.RoomID, since, limits.MaxMessages)
+	if err != nil {
+		s.logf("catchmeup history failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.CatchMeUpFailed, nil))
+	}
+	if sinceLast {
+		messages = last.After(messages)
+	}
+	messages = withoutCatchMeUpCommands(messages, s.catchMeUp)
+	if len(messages) == 0 {
+		return s.reply(ctx, msg, s.text(msg, i18n.CatchMeUpEmpty, nil))
+	}
+
+	summary, err := s.summarizer.Summarize(ctx, messages)
+	if err != nil {
+		s.logf("catchmeup summarize failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return s.reply(ctx, msg, s.text(msg, i18n.CatchMeUpFailed, nil))
+	}
+	if strings.TrimSpace(summary) == "" {
+		return s.reply(ctx, msg, s.text(msg, i18n.CatchMeUpEmpty, nil))
+	}
+	body := s.renderSummary(summary, len(messages))
+	s.saveLastSummary(ctx, msg.RoomID, messages)
+	s.indexSummary(ctx, msg, summary, messages)
+	return s.replyAs(ctx, msg, matrix.ReplySummary, body, matrix.FormatHTML(body, matrix.Senders(messages)))
+}
+
+// queryTooLong reports whether query has more than MaxQueryLen characters.
+func (s *Service) queryTooLong(query string) bool {
+	return utf8.RuneCountInString(query) > s.cfg.MaxQueryLen
+}
+
+// searchFailedText tells msg's sender that a search failed with err, or that
+// the backend is unavailable while its circuit breaker is open.
+func (s *Service) searchFailedText(msg matrix.Message, err error) string {
+	if errors.Is(err, hister.ErrUnavailable) {
+		return s.text(msg, i18n.SearchUnavailable, nil)
+	}
+	return s.text(msg, i18n.SearchFailed, nil)
+}
+
+func (s *Service) replyQueryTooLong(ctx context.Context, msg matrix.Message) error {
+	return s.reply(ctx, msg, s.text(msg, i18n.QueryTooLong, i18n.Vars{"max": strconv.Itoa(s.cfg.MaxQueryLen)}))
+}
+
+func (s *Service) reply(ctx context.Context, msg matrix.Message, body string) error {
+	return s.replyAs(ctx, msg, "", body, "")
+}
+
+// replyAs replies to msg with an optional HTML formatted body. kind selects
+// the reply's message type; the zero kind is that of plain replies.
+func (s *Service) replyAs(ctx context.Context, msg matrix.Message, kind matrix.ReplyKind, body, formatted string) error {
+	reply := matrix.Reply{RoomID: msg.RoomID, Body: body, FormattedBody: formatted, Kind: kind}
+	return s.replier.SendReply(ctx, s.relate(reply, msg.EventID, threadRoot(msg)))
+}
+
+// relate makes reply answer eventID as ReplyMode asks. Thread replies go to
+// root, the root of the thread eventID was sent in, since threads do not
+// nest.
+func (s *Service) relate(reply matrix.Reply, eventID, root id.EventID) matrix.Reply {
+	switch s.cfg.ReplyMode {
+	case ReplyRoom:
+	case ReplyReply:
+		reply.InReplyToEventID = eventID
+	default:
+		reply.InReplyToEventID = root
+		reply.Thread = true
+	}
+	return reply
+}
+
+func (s *Service) text(msg matrix.Message, key i18n.Key, vars i18n.Vars) string {
+	return s.cfg.Messages.Text(string(msg.RoomID), key, vars)
+}
+
+func (s *Service) logf(format string, args ...any) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// formatResults renders results below header with the result template, as
+// a plain-text body and an HTML body listing the results as links. Snippets
+// of results spoiler marks are hidden in a spoiler in the HTML body and
+// replaced by the localized spoiler notice in the plain-text one, so clients
+// that ignore formatting do not show them either.
+func (s *Service) formatResults(roomID id.RoomID, header string, results []hister.SearchResult, spoiler func(hister.SearchResult) bool) (string, string) {
+	return s.formatResultsFrom(roomID, header, results, 1, spoiler)
+}
+
+// formatResultsFrom is formatResults numbering the results from first.
+func (s *Service) formatResultsFrom(roomID id.RoomID, header string, results []hister.SearchResult, first int, spoiler func(hister.SearchResult) bool) (string, string) {
+	body := []string{header}
+	formatted := []string{htmlText(header), "<ul>"}
+	for i, r := range results {
+		title := strings.TrimSpace(r.Title)
+		if title == "" {
+			title = r.URL
+		}
+		item := render.Result{
+			Index:   first + i,
+			Title:   title,
+			URL:     r.URL,
+			Snippet: truncate(strings.Join(strings.Fields(r.Snippet), " "), maxSnippetLen),
+			Score:   r.Score,
+			Source:  s.resultSource(roomID, r.Source),
+		}
+		if item.Snippet == "" || spoiler == nil || !spoiler(r) {
+			body = append(body, s.renderResult(item))
+			formatted = append(formatted, "<li>"+s.renderResultHTML(item)+"</li>")
+			continue
+		}
+		snippet := item.Snippet
+		item.Snippet = s.cfg.Messages.Text(string(roomID), i18n.Spoiler, nil)
+		body = append(body, s.renderResult(item))
+		item.Snippet = spoilerPlaceholder
+		formatted = append(formatted, "<li>"+strings.ReplaceAll(s.renderResultHTML(item), spoilerPlaceholder,
+			"<span data-mx-spoiler>"+htmlText(snippet)+"</span>")+"</li>")
+	}
+	formatted = append(formatted, "</ul>")
+	return strings.Join(body, "\n\n"), strings.Join(formatted, "")
+}
+
+// renderResult renders r with the configured result template, falling back to
+// the default one if it fails.
+func (s *Service) renderResult(r render.Result) string {
+	text, err := s.cfg.Templates.Result(r)
+	if err != nil {
+		s.logf("result template failed url=%s err=%v", r.URL, err)
+		text, _ = (*render.Templates)(nil).Result(r)
+	}
+	return text
+}
+
+// renderResultHTML renders r for the HTML body: with the default HTML
+// layout, or as the custom result template's text with its URLs linked.
+func (s *Service) renderResultHTML(r render.Result) string {
+	formatted, err := s.cfg.Templates.ResultHTML(r)
+	if err != nil {
+		s.logf("result html template failed url=%s err=%v", r.URL, err)
+	}
+	if formatted == "" {
+		formatted = matrix.FormatHTML(s.renderResult(r), nil)
+	}
+	return formatted
+}
+
+// renderSummary renders a /catchmeup summary of count messages with the
+// configured summary template, falling back to the bare summary if it fails.
+func (s *Service) renderSummary(summary string, count int) string {
+	text, err := s.cfg.Templates.Summary(render.Summary{Summary: summary, Messages: count})
+	if err != nil || strings.TrimSpace(text) == "" {
+		s.logf("summary template failed err=%v", err)
+		return summary
+	}
+	return text
+}
+
+// filterResults keeps results on one of sites (if any) and in allowed (if
+// non-nil), up to limit.
+func filterResults(results []hister.SearchResult, sites []string, allowed map[string]struct{}, limit int) []hister.SearchResult {
+	out := make([]hister.SearchResult, 0, min(len(results), limit))
+	for _, r := range results {
+		if len(out) >= limit {
+			break
+		}
+		if allowed != nil {
+			if _, ok := allowed[r.URL]; !ok {
+				continue
+			}
+		}
+		if len(sites) > 0 && !onSite(r.URL, sites) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func onSite(rawURL string, sites []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, site := range sites {
+		if triggers.MatchesSite(u.Hostname(), site) {
+			return true
+		}
+	}
+	return false
+}
+
+func linkResults(urls []string) []hister.SearchResult {
+	out := make([]hister.SearchResult, 0, len(urls))
+	for _, u := range urls {
+		out = append(out, hister.SearchResult{URL: u})
+	}
+	return out
+}
+
+func formatWait(wait time.Duration) string {
+	wait = wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait.String()
+}
+
+// withoutCatchMeUpCommands drops /catchmeup invocations from fetched history so
+// they don't end up in the summary.
+func withoutCatchMeUpCommands(messages []matrix.RoomMessage, command string) []matrix.RoomMessage {
+	out := make([]matrix.RoomMessage, 0, len(messages))
+	for _, m := range messages {
+		body := strings.TrimSpace(m.Body)
+		if strings.EqualFold(body, command) || isCatchMeUpSinceLast(body, command) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// sameReactionKey compares reaction keys ignoring emoji variation selectors,
+// which clients add or drop inconsistently.
+func sameReactionKey(got, want string) bool {
+	want = strings.ReplaceAll(strings.TrimSpace(want), "\ufe0f", "")
+	if want == "" {
+		return false
+	}
+	return strings.ReplaceAll(strings.TrimSpace(got), "\ufe0f", "") == want
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+func dedupe(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,2465 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeBackend struct {
+	indexed   []string
+	indexErr  error
+	queries   []string
+	limits    []int
+	results   []hister.SearchResult
+	searchErr error
+}
+
+func (f *fakeBackend) IndexURL(_ context.Context, rawURL string) error {
+	f.indexed = append(f.indexed, rawURL)
+	return f.indexErr
+}
+
+func (f *fakeBackend) Search(_ context.Context, query string, limit int) ([]hister.SearchResult, error) {
+	f.queries = append(f.queries, query)
+	f.limits = append(f.limits, limit)
+	return f.results, f.searchErr
+}
+
+type fakeContentBackend struct {
+	fakeBackend
+	contents map[string]extractor.Result
+}
+
+func (f *fakeContentBackend) IndexURLContent(ctx context.Context, rawURL string) (extractor.Result, error) {
+	if err := f.IndexURL(ctx, rawURL); err != nil {
+		return extractor.Result{}, err
+	}
+	return f.contents[rawURL], nil
+}
+
+type fakeReplier struct {
+	replies []matrix.Reply
+}
+
+func (f *fakeReplier) SendReply(_ context.Context, reply matrix.Reply) error {
+	f.replies = append(f.replies, reply)
+	return nil
+}
+
+type fakeHistory struct {
+	messages []matrix.RoomMessage
+	err      error
+	calls    int
+	since    time.Time
+	max      int
+}
+
+func (f *fakeHistory) GetRecentTextMessages(_ context.Context, _ id.RoomID, since time.Time, max int) ([]matrix.RoomMessage, error) {
+	f.calls++
+	f.since, f.max = since, max
+	return f.messages, f.err
+}
+
+type fakeMessages struct {
+	msg matrix.RoomMessage
+	err error
+}
+
+func (f *fakeMessages) GetTextMessage(context.Context, id.RoomID, id.EventID) (matrix.RoomMessage, error) {
+	return f.msg, f.err
+}
+
+type sentReaction struct {
+	roomID  id.RoomID
+	eventID id.EventID
+	key     string
+}
+
+type fakeReactor struct {
+	sent []sentReaction
+}
+
+func (f *fakeReactor) SendReaction(_ context.Context, roomID id.RoomID, eventID id.EventID, key string) error {
+	f.sent = append(f.sent, sentReaction{roomID: roomID, eventID: eventID, key: key})
+	return nil
+}
+
+type fakeLinks struct {
+	shared  []storage.SharedLink
+	added   map[string][]string
+	filters []storage.LinkFilter
+	found   []string
+}
+
+func (f *fakeLinks) RecordSharedLink(_ context.Context, link storage.SharedLink) error {
+	f.shared = append(f.shared, link)
+	return nil
+}
+
+func (f *fakeLinks) AddDocumentTags(_ context.Context, rawURL string, tags []string) error {
+	if f.added == nil {
+		f.added = make(map[string][]string)
+	}
+	f.added[rawURL] = append(f.added[rawURL], tags...)
+	return nil
+}
+
+// FindLinks returns found when set, and otherwise the recorded links shared
+// in filter.Rooms by filter.Senders.
+func (f *fakeLinks) FindLinks(_ context.Context, filter storage.LinkFilter) ([]string, error) {
+	f.filters = append(f.filters, filter)
+	if f.found != nil {
+		return f.found, nil
+	}
+	var urls []string
+	for _, link := range f.shared {
+		if (len(filter.Rooms) == 0 || slices.Contains(filter.Rooms, string(link.RoomID))) &&
+			(len(filter.Senders) == 0 || slices.Contains(filter.Senders, string(link.Sender))) {
+			urls = append(urls, link.URL)
+		}
+	}
+	return urls, nil
+}
+
+type fakeState struct {
+	values map[string]string
+}
+
+func (f *fakeState) PutBotState(_ context.Context, key, value string) error {
+	if f.values == nil {
+		f.values = make(map[string]string)
+	}
+	f.values[key] = value
+	return nil
+}
+
+func (f *fakeState) GetBotState(_ context.Context, key string) (string, error) {
+	return f.values[key], nil
+}
+
+type fakeWatches struct {
+	watches []storage.Watch
+	seen    map[int64]map[string]struct{}
+}
+
+func (f *fakeWatches) AddWatch(_ context.Context, w storage.Watch) (int64, error) {
+	w.ID = int64(len(f.watches) + 1)
+	f.watches = append(f.watches, w)
+	return w.ID, nil
+}
+
+func (f *fakeWatches) RemoveWatch(_ context.Context, userID id.UserID, watchID int64) (bool, error) {
+	for i, w := range f.watches {
+		if w.ID == watchID && w.UserID == userID {
+			f.watches = append(f.watches[:i], f.watches[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeWatches) ListWatches(_ context.Context, userID id.UserID) ([]storage.Watch, error) {
+	var out []storage.Watch
+	for _, w := range f.watches {
+		if userID == "" || w.UserID == userID {
+			out = append(out, w)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeWatches) MarkWatchSeen(_ context.Context, watchID int64, urls []string) error {
+	if f.seen == nil {
+		f.seen = make(map[int64]map[string]struct{})
+	}
+	if f.seen[watchID] == nil {
+		f.seen[watchID] = make(map[string]struct{})
+	}
+	for _, rawURL := range urls {
+		f.seen[watchID][rawURL] = struct{}{}
+	}
+	return nil
+}
+
+func (f *fakeWatches) WatchSeen(_ context.Context, watchID int64, urls []string) (map[string]struct{}, error) {
+	out := make(map[string]struct{})
+	for _, rawURL := range urls {
+		if _, ok := f.seen[watchID][rawURL]; ok {
+			out[rawURL] = struct{}{}
+		}
+	}
+	return out, nil
+}
+
+type fakeReminders struct {
+	reminders []storage.Reminder
+	deleted   []int64
+}
+
+func (f *fakeReminders) AddReminder(_ context.Context, r storage.Reminder) (int64, error) {
+	r.ID = int64(len(f.reminders) + 1)
+	f.reminders = append(f.reminders, r)
+	return r.ID, nil
+}
+
+func (f *fakeReminders) DueReminders(_ context.Context, now time.Time, _ int) ([]storage.Reminder, error) {
+	var out []storage.Reminder
+	for _, r := range f.reminders {
+		if !r.DueAt.After(now) && !slices.Contains(f.deleted, r.ID) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeReminders) DeleteReminder(_ context.Context, reminderID int64) error {
+	f.deleted = append(f.deleted, reminderID)
+	return nil
+}
+
+type fakeDeadLinks map[string]struct{}
+
+func (f fakeDeadLinks) DeadLinks(_ context.Context, urls []string) (map[string]struct{}, error) {
+	dead := make(map[string]struct{})
+	for _, u := range urls {
+		if _, ok := f[u]; ok {
+			dead[u] = struct{}{}
+		}
+	}
+	return dead, nil
+}
+
+type fakeSearches struct {
+	searches []storage.Search
+}
+
+func (f *fakeSearches) RecordSearch(_ context.Context, search storage.Search) error {
+	f.searches = append(f.searches, search)
+	return nil
+}
+
+type fakeDocuments struct {
+	titles []storage.DocumentTitle
+	count  int
+}
+
+func (f *fakeDocuments) RecordDocumentTitle(_ context.Context, rawURL, title string, _ time.Time) error {
+	f.titles = append([]storage.DocumentTitle{{URL: rawURL, Title: title}}, f.titles...)
+	return nil
+}
+
+func (f *fakeDocuments) DocumentTitles(_ context.Context, limit int) ([]storage.DocumentTitle, error) {
+	return f.titles[:min(len(f.titles), limit)], nil
+}
+
+func (f *fakeDocuments) DocumentCount(context.Context) (int, error) {
+	return f.count, nil
+}
+
+type directNotice struct {
+	userID id.UserID
+	body   string
+}
+
+type fakeDirect struct {
+	sent []directNotice
+}
+
+func (f *fakeDirect) SendDirectNotice(_ context.Context, userID id.UserID, body string) error {
+	f.sent = append(f.sent, directNotice{userID: userID, body: body})
+	return nil
+}
+
+type fakeVisibility struct {
+	access matrix.HistoryAccess
+	err    error
+}
+
+func (f *fakeVisibility) HistoryAccess(context.Context, id.RoomID, id.UserID) (matrix.HistoryAccess, error) {
+	return f.access, f.err
+}
+
+type fakeKeys struct {
+	direct   bool
+	exported [][]byte
+	imported []string
+}
+
+func (f *fakeKeys) IsDirectChat(context.Context, id.RoomID, id.UserID) (bool, error) {
+	return f.direct, nil
+}
+
+func (f *fakeKeys) ExportRoomKeys(_ context.Context, passphrase string) ([]byte, int, error) {
+	return []byte("keys:" + passphrase), 3, nil
+}
+
+func (f *fakeKeys) ImportRoomKeys(_ context.Context, passphrase string, data []byte) (int, int, error) {
+	f.imported = append(f.imported, passphrase+"/"+string(data))
+	return 2, 3, nil
+}
+
+func (f *fakeKeys) SendKeyExport(_ context.Context, _ id.RoomID, _ id.EventID, data []byte) error {
+	f.exported = append(f.exported, data)
+	return nil
+}
+
+func (f *fakeKeys) DownloadFile(_ context.Context, _ id.RoomID, eventID id.EventID, _ int64) ([]byte, error) {
+	return []byte("file" + eventID), nil
+}
+
+type fakeSummarizer struct {
+	got     []matrix.RoomMessage
+	summary string
+	err     error
+}
+
+func (f *fakeSummarizer) Summarize(_ context.Context, messages []matrix.RoomMessage) (string, error) {
+	f.got = messages
+	return f.summary, f.err
+}
+
+func testConfig() Config {
+	return Config{
+		BotUserID:      "@bot:test",
+		BotDisplayName: "bot",
+		MaxResults:     5,
+		MaxQueryLen:    200,
+		ReplyMode:      "thread",
+	}
+}
+
+func newTestService(t *testing.T, cfg Config, deps Deps) *Service {
+	t.Helper()
+	if deps.Parser == nil {
+		deps.Parser = triggers.NewParser()
+	}
+	if deps.Replier == nil {
+		deps.Replier = &fakeReplier{}
+	}
+	if deps.Backend == nil {
+		deps.Backend = &fakeBackend{}
+	}
+	svc, err := NewService(cfg, deps)
+	if err != nil {
+		t.Fatalf("NewService failed: %v", err)
+	}
+	return svc
+}
+
+func message(body string) matrix.Message {
+	return matrix.Message{RoomID: "!room:test", EventID: "$evt", Sender: "@alice:test", Body: body}
+}
+
+func TestHandleMatrixMessage_IndexesUniqueURLs(t *testing.T) {
+	backend := &fakeBackend{indexErr: errors.New("boom")}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier})
+
+	err := svc.HandleMatrixMessage(context.Background(), message("see https://a.example and https://a.example, https://b.example"))
+	if err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.indexed) != 2 || backend.indexed[0] != "https://a.example" || backend.indexed[1] != "https://b.example" {
+		t.Fatalf("unexpected indexed urls: %#v", backend.indexed)
+	}
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected no replies, got %#v", replier.replies)
+	}
+}
+
+type fakeIndexLog map[string]time.Time
+
+func (f fakeIndexLog) URLIndexedAt(_ context.Context, rawURL string) (time.Time, error) {
+	return f[rawURL], nil
+}
+
+func (f fakeIndexLog) MarkURLIndexed(_ context.Context, rawURL string, indexedAt time.Time) error {
+	f[rawURL] = indexedAt
+	return nil
+}
+
+func (f fakeIndexLog) ForgetIndexedURL(_ context.Context, rawURL string) error {
+	delete(f, rawURL)
+	return nil
+}
+
+func TestHandleMatrixMessage_SkipsRecentlyIndexedURLs(t *testing.T) {
+	backend := &fakeBackend{}
+	links := &fakeLinks{}
+	cfg := testConfig()
+	cfg.RecrawlAfter = time.Hour
+	svc := newTestService(t, cfg, Deps{Backend: backend, Links: links, IndexLog: fakeIndexLog{}})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	share := func() {
+		t.Helper()
+		if err := svc.HandleMatrixMessage(context.Background(), message("see https://a.example")); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+	}
+	share()
+	now = now.Add(30 * time.Minute)
+	share()
+	if len(backend.indexed) != 1 {
+		t.Fatalf("expected a link shared again within the recrawl interval not to be indexed again, got %v", backend.indexed)
+	}
+	if len(links.shared) != 2 {
+		t.Fatalf("expected both shares recorded, got %#v", links.shared)
+	}
+
+	now = now.Add(time.Hour)
+	share()
+	if len(backend.indexed) != 2 {
+		t.Fatalf("expected a stale link to be indexed again, got %v", backend.indexed)
+	}
+}
+
+func TestHandleMatrixMessage_RecrawlLogPerIndex(t *testing.T) {
+	backend := &fakeRoomBackend{rooms: map[id.RoomID]*fakeBackend{}}
+	indexLog := fakeIndexLog{}
+	cfg := testConfig()
+	cfg.RecrawlAfter = time.Hour
+	svc := newTestService(t, cfg, Deps{Backend: backend, IndexLog: indexLog})
+
+	for _, roomID := range []id.RoomID{"!a:test", "!b:test", "!b:test"} {
+		msg := message("see https://a.example")
+		msg.RoomID = roomID
+		if err := svc.HandleMatrixMessage(context.Background(), msg); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+	}
+	for _, roomID := range []id.RoomID{"!a:test", "!b:test"} {
+		if got := backend.rooms[roomID]; got == nil || !slices.Equal(got.indexed, []string{"https://a.example"}) {
+			t.Fatalf("expected %s's index to get the link once, got %#v", roomID, got)
+		}
+	}
+
+	svc.forgetIndexed(context.Background(), "!b:test", "https://a.example")
+	if _, ok := indexLog["!a:test https://a.example"]; !ok || len(indexLog) != 1 {
+		t.Fatalf("expected only the other index's log entry left, got %v", indexLog)
+	}
+}
+
+func TestHandleMatrixMessage_IndexSenders(t *testing.T) {
+	backend := &fakeBackend{}
+	cfg := testConfig()
+	cfg.IndexSenders = SenderFilter{Allow: []string{"@*:test", "@bob:partner.example"}, Deny: []string{"@spam*:test"}}
+	svc := newTestService(t, cfg, Deps{Backend: backend})
+
+	for _, sender := range []id.UserID{"@alice:test", "@spambot:test", "@eve:evil.example", "@bob:partner.example"} {
+		msg := message("see https://" + strings.Split(string(sender), ":")[0][1:] + ".example")
+		msg.Sender = sender
+		if err := svc.HandleMatrixMessage(context.Background(), msg); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+	}
+	if want := []string{"https://alice.example", "https://bob.example"}; !reflect.DeepEqual(backend.indexed, want) {
+		t.Fatalf("indexed %#v, want %#v", backend.indexed, want)
+	}
+}
+
+func TestHandleMatrixMessage_IgnoresBots(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{{Title: "Go", URL: "https://go.dev"}}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.IgnoreBots = BotFilter{Notices: true, KnownBots: true, Users: []string{"@*:bots.example"}}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	notice := message("/search golang https://notice.example")
+	notice.Notice = true
+	known := message("/search golang https://known.example")
+	known.Sender = "@RSSBot:test"
+	listed := message("/search golang https://listed.example")
+	listed.Sender = "@relay:bots.example"
+	for _, msg := range []matrix.Message{notice, known, listed} {
+		if err := svc.HandleMatrixMessage(context.Background(), msg); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+	}
+	if len(replier.replies) != 0 || len(backend.indexed) != 0 {
+		t.Fatalf("expected bots to be ignored, got replies %#v, indexed %#v", replier.replies, backend.indexed)
+	}
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search golang https://human.example")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(replier.replies) != 1 || !reflect.DeepEqual(backend.indexed, []string{"https://human.example"}) {
+		t.Fatalf("expected human message to be handled, got replies %#v, indexed %#v", replier.replies, backend.indexed)
+	}
+}
+
+type fakeThreads map[id.EventID]string
+
+func (f fakeThreads) SaveThreadQuery(_ context.Context, _ id.RoomID, root id.EventID, query string, _ time.Time) error {
+	f[root] = query
+	return nil
+}
+
+func (f fakeThreads) ThreadQuery(_ context.Context, _ id.RoomID, root id.EventID) (string, error) {
+	return f[root], nil
+}
+
+func TestHandleMatrixMessage_ReplyModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode      string
+		inReplyTo id.EventID
+		thread    bool
+	}{
+		{mode: ReplyThread, inReplyTo: "$root", thread: true},
+		{mode: ReplyReply, inReplyTo: "$evt"},
+		{mode: ReplyRoom},
+	} {
+		replier := &fakeReplier{}
+		cfg := testConfig()
+		cfg.ReplyMode = tc.mode
+		svc := newTestService(t, cfg, Deps{Replier: replier, Backend: &fakeBackend{results: []hister.SearchResult{{Title: "Go", URL: "https://go.dev"}}}})
+
+		msg := message("/search golang")
+		msg.ThreadRoot = "$root"
+		if err := svc.HandleMatrixMessage(context.Background(), msg); err != nil {
+			t.Fatalf("%s: HandleMatrixMessage failed: %v", tc.mode, err)
+		}
+		if len(replier.replies) != 1 {
+			t.Fatalf("%s: expected one reply, got %#v", tc.mode, replier.replies)
+		}
+		if got := replier.replies[0]; got.InReplyToEventID != tc.inReplyTo || got.Thread != tc.thread {
+			t.Fatalf("%s: reply relates to %q thread=%v, want %q thread=%v", tc.mode, got.InReplyToEventID, got.Thread, tc.inReplyTo, tc.thread)
+		}
+	}
+}
+
+func TestHandleMatrixMessage_RefinesSearchInThread(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{{Title: "Tokio", URL: "https://tokio.rs"}}}
+	replier := &fakeReplier{}
+	threads := fakeThreads{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier, Threads: threads})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search async runtime")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	refine := message("only the rust ones!")
+	refine.EventID, refine.ThreadRoot = "$refine", "$evt"
+	if err := svc.HandleMatrixMessage(context.Background(), refine); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	again := message("stable")
+	again.EventID, again.ThreadRoot = "$again", "$evt"
+	if err := svc.HandleMatrixMessage(context.Background(), again); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+
+	want := []string{"async runtime", "async runtime rust", "async runtime rust stable"}
+	if !reflect.DeepEqual(backend.queries, want) {
+		t.Fatalf("queries = %#v, want %#v", backend.queries, want)
+	}
+	if len(replier.replies) != 3 || replier.replies[2].InReplyToEventID != "$evt" || !replier.replies[2].Thread {
+		t.Fatalf("expected refined results in the original thread, got %#v", replier.replies)
+	}
+
+	// Chat in other threads is left alone.
+	other := message("rust is great")
+	other.EventID, other.ThreadRoot = "$chat", "$unrelated"
+	if err := svc.HandleMatrixMessage(context.Background(), other); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.queries) != 3 {
+		t.Fatalf("unexpected search for unrelated thread: %#v", backend.queries)
+	}
+}
+
+func TestHandleMatrixMessage_SpoilerSnippets(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "Safe", URL: "https://go.dev", Snippet: "Go <docs>"},
+		{Title: "Risky", URL: "https://www.nsfw.example/a", Snippet: "Explicit text"},
+	}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.SensitiveDomains = []string{"nsfw.example"}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search golang")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	got := replier.replies[0]
+	wantBody := "Search results for: golang\n\n1. Safe\nhttps://go.dev\nGo <docs>\n\n2. Risky\nhttps://www.nsfw.example/a\n[Spoiler]"
+	wantHTML := `Search results for: golang<ul><li><a href="https://go.dev">Safe</a><br>Go &lt;docs&gt;</li>` +
+		`<li><a href="https://www.nsfw.example/a">Risky</a><br><span data-mx-spoiler>Explicit text</span></li></ul>`
+	if got.Body != wantBody || got.FormattedBody != wantHTML {
+		t.Fatalf("unexpected reply:\n%s\n%s", got.Body, got.FormattedBody)
+	}
+
+	cfg.SensitiveDomains = nil
+	cfg.SpoilerRooms = []id.RoomID{"!room:test"}
+	replier = &fakeReplier{}
+	svc = newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	if got := replier.replies[0]; strings.Contains(got.Body, "Explicit") || strings.Contains(got.Body, "Go <docs>") || strings.Count(got.FormattedBody, "data-mx-spoiler") != 2 {
+		t.Fatalf("expected every snippet spoilered, got:\n%s\n%s", got.Body, got.FormattedBody)
+	}
+
+	cfg.SpoilerRooms = nil
+	replier = &fakeReplier{}
+	svc = newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	if got := replier.replies[0]; strings.Contains(got.FormattedBody, "data-mx-spoiler") || !strings.Contains(got.FormattedBody, "<br>Go &lt;docs&gt;</li>") {
+		t.Fatalf("expected no spoilers without sensitive domains, got %q", got.FormattedBody)
+	}
+
+	catalog, err := i18n.NewCatalog("en", map[string]string{"!room:test": "de"}, nil)
+	if err != nil {
+		t.Fatalf("NewCatalog failed: %v", err)
+	}
+	cfg.SensitiveDomains = []string{"nsfw.example"}
+	cfg.Messages = catalog
+	replier = &fakeReplier{}
+	svc = newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	if got := replier.replies[0]; !strings.HasSuffix(got.Body, "\n[Versteckter Inhalt]") {
+		t.Fatalf("expected the room's language for the spoiler notice, got:\n%s", got.Body)
+	}
+}
+
+func TestHandleMatrixMessage_ResultTemplates(t *testing.T) {
+	templates, err := render.New("{{.Index}}) {{.Title}} <{{.URL}}> {{.Snippet}}", "Catch-up ({{.Messages}}):\n{{.Summary}}", "")
+	if err != nil {
+		t.Fatalf("render.New() error = %v", err)
+	}
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "Go", URL: "https://go.dev", Snippet: "Go docs"},
+		{Title: "Risky", URL: "https://nsfw.example", Snippet: "<b>text</b>"},
+	}}
+	history := &fakeHistory{messages: []matrix.RoomMessage{{Sender: "@bob:test", Body: "hello"}}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.Templates = templates
+	cfg.SensitiveDomains = []string{"nsfw.example"}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, History: history, Summarizer: &fakeSummarizer{summary: "- greetings"}})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/catchmeup"))
+	if len(replier.replies) != 2 {
+		t.Fatalf("expected two replies, got %#v", replier.replies)
+	}
+	results := replier.replies[0]
+	wantBody := "Search results for: golang\n\n1) Go <https://go.dev> Go docs\n\n2) Risky <https://nsfw.example> [Spoiler]"
+	wantHTML := `Search results for: golang<ul><li>1) Go &lt;<a href="https://go.dev">https://go.dev</a>&gt; Go docs</li>` +
+		`<li>2) Risky &lt;<a href="https://nsfw.example">https://nsfw.example</a>&gt; <span data-mx-spoiler>&lt;b&gt;text&lt;/b&gt;</span></li></ul>`
+	if results.Body != wantBody || results.FormattedBody != wantHTML {
+		t.Fatalf("unexpected templated results:\n%s\n%s", results.Body, results.FormattedBody)
+	}
+	if got := replier.replies[1].Body; got != "Catch-up (1):\n- greetings" {
+		t.Fatalf("unexpected templated summary: %q", got)
+	}
+}
+
+func TestHandleMatrixMessage_SearchCache(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{{Title: "Go", URL: "https://go.dev"}}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.SearchCacheTTL = time.Minute
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	for _, body := range []string{"/search golang", "/search  golang", "/search golang --refresh"} {
+		if err := svc.HandleMatrixMessage(context.Background(), message(body)); err != nil {
+			t.Fatalf("HandleMatrixMessage(%q) failed: %v", body, err)
+		}
+	}
+	if len(backend.queries) != 2 || backend.queries[1] != "golang" {
+		t.Fatalf("expected the repeat to be cached and the refresh to search, got %#v", backend.queries)
+	}
+	if len(replier.replies) != 3 {
+		t.Fatalf("expected three replies, got %#v", replier.replies)
+	}
+	for i, cached := range []bool{false, true, false} {
+		header, _, _ := strings.Cut(replier.replies[i].Body, "\n")
+		if got := strings.HasSuffix(header, " (cached)"); got != cached {
+			t.Fatalf("reply %d header %q, want cached=%v", i, header, cached)
+		}
+	}
+}
+
+func TestHandleMatrixMessage_URLPreviews(t *testing.T) {
+	backend := &fakeContentBackend{contents: map[string]extractor.Result{
+		"https://a.example": {Title: "A page", Description: "About   A.", Text: "ignored"},
+		"https://b.example": {Text: "Body text of B"},
+	}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.URLPreviews = true
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("see https://a.example and https://b.example")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	want := "A page\nAbout A.\n\nhttps://b.example\nBody text of B"
+	if len(replier.replies) != 1 || replier.replies[0].Body != want || !replier.replies[0].Thread {
+		t.Fatalf("unexpected preview replies: %#v", replier.replies)
+	}
+
+	replier.replies = nil
+	backend.indexErr = errors.New("boom")
+	_ = svc.HandleMatrixMessage(context.Background(), message("see https://a.example"))
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected no preview for failed indexing, got %#v", replier.replies)
+	}
+}
+
+type fakeRedactions struct {
+	forgotten []id.EventID
+	documents [][]string
+	orphaned  []string
+}
+
+func (f *fakeRedactions) ForgetEvent(_ context.Context, _ id.RoomID, eventID id.EventID, documents ...string) ([]string, error) {
+	f.forgotten = append(f.forgotten, eventID)
+	f.documents = append(f.documents, documents)
+	return append(append([]string(nil), f.orphaned...), documents...), nil
+}
+
+type fakeDeletingBackend struct {
+	fakeChatBackend
+	deleted []string
+}
+
+func (f *fakeDeletingBackend) DeleteURL(_ context.Context, rawURL string) error {
+	f.deleted = append(f.deleted, rawURL)
+	return nil
+}
+
+func TestHandleMatrixRedaction_DeletesDocuments(t *testing.T) {
+	backend := &fakeDeletingBackend{}
+	store := &fakeRedactions{orphaned: []string{"https://a.example"}}
+	cfg := testConfig()
+	cfg.ChatSearchRooms = []id.RoomID{"!room:test"}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Redactions: store})
+
+	err := svc.HandleMatrixRedaction(context.Background(), matrix.Redaction{RoomID: "!room:test", EventID: "$redaction", Redacts: "$evt"})
+	if err != nil {
+		t.Fatalf("HandleMatrixRedaction failed: %v", err)
+	}
+	chatURL := chatMessageURL("!room:test", "$evt")
+	if len(store.forgotten) != 1 || store.forgotten[0] != "$evt" || len(store.documents[0]) != 1 || store.documents[0][0] != chatURL {
+		t.Fatalf("unexpected forgotten events %v documents %v", store.forgotten, store.documents)
+	}
+	if want := []string{"https://a.example", chatURL}; !reflect.DeepEqual(backend.deleted, want) {
+		t.Fatalf("deleted %v, want %v", backend.deleted, want)
+	}
+}
+
+func TestHandleMatrixMessage_ForgetDeletesDocument(t *testing.T) {
+	backend := &fakeDeletingBackend{}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.Admins = []id.UserID{"@admin:test"}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/forget https://a.example/page"))
+	if len(backend.deleted) != 0 || len(replier.replies) != 0 {
+		t.Fatalf("expected non-admin forget to be ignored, got deleted=%v replies=%#v", backend.deleted, replier.replies)
+	}
+
+	admin := message("/forget https://a.example/page")
+	admin.Sender = "@admin:test"
+	if err := svc.HandleMatrixMessage(context.Background(), admin); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if want := []string{"https://a.example/page"}; !reflect.DeepEqual(backend.deleted, want) {
+		t.Fatalf("deleted %v, want %v", backend.deleted, want)
+	}
+	if len(backend.indexed) != 0 {
+		t.Fatalf("expected the forgotten URL not to be indexed, got %v", backend.indexed)
+	}
+	if len(replier.replies) != 1 || replier.replies[0].Body != "Removed https://a.example/page from the search index." {
+		t.Fatalf("unexpected replies %#v", replier.replies)
+	}
+
+	admin.Body = "/forget"
+	_ = svc.HandleMatrixMessage(context.Background(), admin)
+	if len(replier.replies) != 2 || replier.replies[1].Body != "Usage: /forget <url>" {
+		t.Fatalf("expected usage reply, got %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_AckReactions(t *testing.T) {
+	backend := &fakeBackend{}
+	reactor := &fakeReactor{}
+	cfg := testConfig()
+	cfg.AckIndexing = true
+	cfg.IndexedReaction = "✅"
+	cfg.FailedReaction = "❌"
+	svc := newTestService(t, cfg, Deps{Backend: backend, Reactor: reactor})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("see https://a.example and https://b.example")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(reactor.sent) != 1 || reactor.sent[0] != (sentReaction{roomID: "!room:test", eventID: "$evt", key: "✅"}) {
+		t.Fatalf("expected one success reaction, got %#v", reactor.sent)
+	}
+
+	reactor.sent = nil
+	backend.indexErr = errors.New("boom")
+	_ = svc.HandleMatrixMessage(context.Background(), message("see https://a.example"))
+	if len(reactor.sent) != 1 || reactor.sent[0].key != "❌" {
+		t.Fatalf("expected one failure reaction, got %#v", reactor.sent)
+	}
+
+	reactor.sent = nil
+	_ = svc.HandleMatrixMessage(context.Background(), message("no links here"))
+	if len(reactor.sent) != 0 {
+		t.Fatalf("expected no reaction without links, got %#v", reactor.sent)
+	}
+}
+
+func TestHandleMatrixMessage_IndexFailureNotices(t *testing.T) {
+	backend := &fakeBackend{indexErr: fmt.Errorf("extract URL content: %w", &extractor.StatusError{StatusCode: 402})}
+	want := "I couldn't index these links, so they won't turn up in searches:\n- https://paper.example: paywalled or behind a login"
+
+	cfg := testConfig()
+	cfg.IndexFailureNotices = IndexFailuresThread
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	_ = svc.HandleMatrixMessage(context.Background(), message("read https://paper.example"))
+	if len(replier.replies) != 1 || replier.replies[0].Body != want || replier.replies[0].InReplyToEventID != "$evt" {
+		t.Fatalf("unexpected thread notice: %#v", replier.replies)
+	}
+
+	cfg.IndexFailureNotices = IndexFailuresDirect
+	replier = &fakeReplier{}
+	direct := &fakeDirect{}
+	svc = newTestService(t, cfg, Deps{Backend: backend, Replier: replier, Direct: direct})
+	_ = svc.HandleMatrixMessage(context.Background(), message("read https://paper.example"))
+	if len(replier.replies) != 0 || len(direct.sent) != 1 || direct.sent[0] != (directNotice{userID: "@alice:test", body: want}) {
+		t.Fatalf("unexpected direct notice: replies=%#v direct=%#v", replier.replies, direct.sent)
+	}
+
+	svc = newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier})
+	_ = svc.HandleMatrixMessage(context.Background(), message("read https://paper.example"))
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected no notice by default, got %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_SearchRepliesInThread(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "Go", URL: "https://go.dev", Snippet: "The Go   programming language"},
+		{URL: "https://pkg.go.dev"},
+	}}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search golang")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.queries) != 1 || backend.queries[0] != "golang" || backend.limits[0] != 5 {
+		t.Fatalf("unexpected search calls: %#v %#v", backend.queries, backend.limits)
+	}
+	if len(replier.replies) != 1 {
+		t.Fatalf("expected one reply, got %d", len(replier.replies))
+	}
+	got := replier.replies[0]
+	if !got.Thread || got.InReplyToEventID != "$evt" || got.RoomID != "!room:test" || got.Kind != matrix.ReplyResults {
+		t.Fatalf("unexpected reply envelope: %#v", got)
+	}
+	want := "Search results for: golang\n\n1. Go\nhttps://go.dev\nThe Go programming language\n\n2. https://pkg.go.dev\nhttps://pkg.go.dev"
+	if got.Body != want {
+		t.Fatalf("unexpected reply body:\n%s", got.Body)
+	}
+}
+
+type fakeEventReplier struct {
+	fakeReplier
+}
+
+func (f *fakeEventReplier) SendReplyEvent(ctx context.Context, reply matrix.Reply) (id.EventID, error) {
+	_ = f.SendReply(ctx, reply)
+	return id.EventID(fmt.Sprintf("$reply%d", len(f.replies))), nil
+}
+
+func TestHandleMatrixMessage_PagesThroughResultsByEditing(t *testing.T) {
+	var results []hister.SearchResult
+	for i := 1; i <= 5; i++ {
+		results = append(results, hister.SearchResult{Title: fmt.Sprintf("R%d", i), URL: fmt.Sprintf("https://r%d.example", i)})
+	}
+	backend := &fakeBackend{results: results}
+	replier := &fakeEventReplier{}
+	cfg := testConfig()
+	cfg.MaxResults = 2
+	cfg.ResultPages = 3
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	ctx := context.Background()
+
+	if err := svc.HandleMatrixMessage(ctx, message("/search golang")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if backend.limits[0] != 6 {
+		t.Fatalf("expected a search for three pages, got limit %d", backend.limits[0])
+	}
+	want := "Search results for: golang\n\n1. R1\nhttps://r1.example\n\n2. R2\nhttps://r2.example\n\nPage 1 of 3. /next and /prev show the other pages."
+	if got := replier.replies[0].Body; got != want {
+		t.Fatalf("unexpected first page:\n%s", got)
+	}
+
+	inThread := message("next")
+	inThread.EventID, inThread.ThreadRoot = "$next", "$evt"
+	for _, msg := range []matrix.Message{inThread, message("/next"), message("/next"), message("/prev")} {
+		if err := svc.HandleMatrixMessage(ctx, msg); err != nil {
+			t.Fatalf("HandleMatrixMessage(%q) failed: %v", msg.Body, err)
+		}
+	}
+	if len(replier.replies) != 4 {
+		t.Fatalf("expected the page past the end to be ignored, got %d replies", len(replier.replies))
+	}
+	for _, edit := range replier.replies[1:] {
+		if edit.Replaces != "$reply1" || edit.Thread || edit.InReplyToEventID != "" || edit.Kind != matrix.ReplyResults {
+			t.Fatalf("unexpected edit envelope: %#v", edit)
+		}
+	}
+	if got := replier.replies[2].Body; !strings.HasPrefix(got, "Search results for: golang\n\n5. R5\n") || !strings.HasSuffix(got, "Page 3 of 3. /next and /prev show the other pages.") {
+		t.Fatalf("unexpected last page:\n%s", got)
+	}
+	if got := replier.replies[3].Body; !strings.Contains(got, "3. R3") || !strings.Contains(got, "Page 2 of 3.") {
+		t.Fatalf("unexpected previous page:\n%s", got)
+	}
+
+	other := message("/next")
+	other.RoomID = "!other:test"
+	if err := svc.HandleMatrixMessage(ctx, other); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if got := replier.replies[4].Body; got != "There are no search results to page through here." {
+		t.Fatalf("unexpected reply without results: %q", got)
+	}
+}
+
+type fakePagingBackend struct {
+	fakeBackend
+	offsets []int
+}
+
+func (f *fakePagingBackend) Search(ctx context.Context, query string, limit int) ([]hister.SearchResult, error) {
+	page, err := f.SearchPage(ctx, query, 0, limit)
+	return page.Results, err
+}
+
+func (f *fakePagingBackend) SearchPage(_ context.Context, query string, offset, limit int) (hister.Page, error) {
+	f.queries = append(f.queries, query)
+	f.offsets = append(f.offsets, offset)
+	end := min(offset+limit, len(f.results))
+	return hister.Page{Results: f.results[min(offset, end):end], Total: len(f.results)}, f.searchErr
+}
+
+func TestHandleMatrixMessage_PagesPastFetchedResults(t *testing.T) {
+	backend := &fakePagingBackend{}
+	for i := 1; i <= 5; i++ {
+		backend.results = append(backend.results, hister.SearchResult{Title: fmt.Sprintf("R%d", i), URL: fmt.Sprintf("https://r%d.example", i)})
+	}
+	replier := &fakeEventReplier{}
+	cfg := testConfig()
+	cfg.MaxResults = 2
+	cfg.ResultPages = 1
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	ctx := context.Background()
+
+	if err := svc.HandleMatrixMessage(ctx, message("/search golang")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if got := replier.replies[0].Body; !strings.HasSuffix(got, "Page 1 of 1+. /next and /prev show the other pages.") {
+		t.Fatalf("expected an open-ended first page, got:\n%s", got)
+	}
+	for range 3 {
+		if err := svc.HandleMatrixMessage(ctx, message("/next")); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+	}
+	if !slices.Equal(backend.offsets, []int{0, 2, 4}) {
+		t.Fatalf("unexpected search offsets: %v", backend.offsets)
+	}
+	if len(replier.replies) != 3 {
+		t.Fatalf("expected the page past the last result to be ignored, got %d replies", len(replier.replies))
+	}
+	if got := replier.replies[1].Body; !strings.Contains(got, "3. R3") || !strings.HasSuffix(got, "Page 2 of 3. /next and /prev show the other pages.") {
+		t.Fatalf("unexpected second page:\n%s", got)
+	}
+	if got := replier.replies[2].Body; !strings.Contains(got, "5. R5") || !strings.HasSuffix(got, "Page 3 of 3. /next and /prev show the other pages.") {
+		t.Fatalf("unexpected last page:\n%s", got)
+	}
+}
+
+func TestHandleMatrixReaction_PagesAndDeletesResults(t *testing.T) {
+	backend := &fakeDeletingBackend{}
+	backend.results = []hister.SearchResult{{URL: "https://a.example"}, {URL: "https://b.example"}, {URL: "https://c.example"}}
+	replier := &fakeEventReplier{}
+	reactor := &fakeReactor{}
+	cfg := testConfig()
+	cfg.MaxResults = 2
+	cfg.ResultPages = 2
+	cfg.ResultReactions = true
+	cfg.Admins = []id.UserID{"@admin:test"}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, Reactor: reactor})
+	ctx := context.Background()
+
+	if err := svc.HandleMatrixMessage(ctx, message("/search golang")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	var keys []string
+	for _, r := range reactor.sent {
+		if r.eventID != "$reply1" {
+			t.Fatalf("unexpected reaction target: %#v", r)
+		}
+		keys = append(keys, r.key)
+	}
+	if !slices.Equal(keys, []string{"⬅️", "➡️", "🗑️"}) {
+		t.Fatalf("unexpected result reactions: %q", keys)
+	}
+
+	react := func(sender id.UserID, key string) {
+		t.Helper()
+		err := svc.HandleMatrixReaction(ctx, matrix.Reaction{RoomID: "!room:test", EventID: "$react", Sender: sender, TargetEventID: "$reply1", Key: key})
+		if err != nil {
+			t.Fatalf("HandleMatrixReaction(%s) failed: %v", key, err)
+		}
+	}
+	react("@alice:test", "➡")
+	if len(replier.replies) != 2 || replier.replies[1].Replaces != "$reply1" || !strings.Contains(replier.replies[1].Body, "3. https://c.example") {
+		t.Fatalf("expected the next page, got %#v", replier.replies)
+	}
+	react("@alice:test", "🗑️")
+	if len(backend.deleted) != 0 || len(replier.replies) != 2 {
+		t.Fatalf("expected non-admin deletion to be ignored, got %q", backend.deleted)
+	}
+	react("@admin:test", "🗑️")
+	if !slices.Equal(backend.deleted, []string{"https://c.example"}) {
+		t.Fatalf("expected the top result of the page deleted, got %q", backend.deleted)
+	}
+	want := "Search results for: golang\n\n1. https://a.example\nhttps://a.example\n\n2. https://b.example\nhttps://b.example"
+	if got := replier.replies[2]; got.Replaces != "$reply1" || got.Body != want {
+		t.Fatalf("expected the remaining page, got %#v", got)
+	}
+}
+
+func TestHandleMatrixMessage_SearchSendsOnePageWithoutEventReplier(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{{URL: "https://a.example"}, {URL: "https://b.example"}}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.MaxResults = 1
+	cfg.ResultPages = 2
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search golang")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	want := "Search results for: golang\n\n1. https://a.example\nhttps://a.example"
+	if len(replier.replies) != 1 || replier.replies[0].Body != want {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_MentionsTriggerSearch(t *testing.T) {
+	backend := &fakeBackend{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend})
+	ctx := context.Background()
+
+	mentioned := message("bot: golang")
+	mentioned.Mentions, mentioned.HasMentions = []id.UserID{"@bot:test"}, true
+	wrapped := message("bot /search rust")
+	wrapped.Mentions, wrapped.HasMentions = []id.UserID{"@bot:test"}, true
+	reply := message("thanks")
+	reply.ReplyTo, reply.Mentions, reply.HasMentions = "$results", []id.UserID{"@bot:test"}, true
+	others := message("@bot zig")
+	others.HasMentions = true
+	for _, msg := range []matrix.Message{mentioned, wrapped, reply, others} {
+		if err := svc.HandleMatrixMessage(ctx, msg); err != nil {
+			t.Fatalf("HandleMatrixMessage(%q) failed: %v", msg.Body, err)
+		}
+	}
+	if want := []string{"golang", "rust"}; !slices.Equal(backend.queries, want) {
+		t.Fatalf("queries = %q, want %q", backend.queries, want)
+	}
+}
+
+func TestHandleMatrixMessage_SearchErrors(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxQueryLen = 5
+	backend := &fakeBackend{searchErr: errors.New("down")}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search much too long"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search go"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search ñandú"))
+
+	if len(replier.replies) != 3 {
+		t.Fatalf("expected three replies, got %d", len(replier.replies))
+	}
+	if replier.replies[0].Body != "Query too long (max 5 characters)." {
+		t.Fatalf("unexpected query too long reply: %q", replier.replies[0].Body)
+	}
+	if replier.replies[1].Body != "Search failed, please try again." {
+		t.Fatalf("unexpected search failure reply: %q", replier.replies[1].Body)
+	}
+	if len(backend.queries) != 2 || backend.queries[1] != "ñandú" {
+		t.Fatalf("expected a 5-character query with multibyte letters to be searched, got %#v", backend.queries)
+	}
+
+	backend.searchErr = fmt.Errorf("search: %w", hister.ErrUnavailable)
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search rust"))
+	if got := replier.replies[3].Body; got != "The search backend is unavailable right now, please try again in a few minutes." {
+		t.Fatalf("unexpected backend unavailable reply: %q", got)
+	}
+}
+
+func TestHandleMatrixMessage_SearchCooldown(t *testing.T) {
+	cfg := testConfig()
+	cfg.SearchCooldown = Limit{Max: 1, Window: time.Minute}
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search one"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search two"))
+	other := message("/search three")
+	other.Sender = "@bob:test"
+	_ = svc.HandleMatrixMessage(context.Background(), other)
+
+	if len(backend.queries) != 2 {
+		t.Fatalf("expected two backend searches, got %#v", backend.queries)
+	}
+	if len(replier.replies) != 3 || !strings.HasPrefix(replier.replies[1].Body, "You're searching too often.") {
+		t.Fatalf("expected cooldown reply, got %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_CatchMeUp(t *testing.T) {
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{Sender: "@alice:test", Body: "/catchmeup"},
+		{Sender: "@bob:test", Body: "hello"},
+	}}
+	summarizer := &fakeSummarizer{summary: "- greetings from @bob:test"}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Replier: replier, History: history, Summarizer: summarizer})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/catchmeup")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(summarizer.got) != 1 || summarizer.got[0].Body != "hello" {
+		t.Fatalf("unexpected summarizer input: %#v", summarizer.got)
+	}
+	if len(replier.replies) != 1 || replier.replies[0].Body != "- greetings from @bob:test" {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+	if want := `<ul><li>greetings from <a href="https://matrix.to/#/@bob:test">@bob:test</a></li></ul>`; replier.replies[0].FormattedBody != want {
+		t.Fatalf("formatted body = %q, want %q", replier.replies[0].FormattedBody, want)
+	}
+}
+
+func TestHandleMatrixMessage_CatchMeUpSinceLast(t *testing.T) {
+	at := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{EventID: "$4", Sender: "@alice:test", Body: "/catchmeup since last", Timestamp: at.Add(2 * time.Minute)},
+		{EventID: "$3", Sender: "@bob:test", Body: "new topic", Timestamp: at.Add(time.Minute)},
+		{EventID: "$2", Sender: "@bob:test", Body: "already summarized", Timestamp: at},
+		{EventID: "$1", Sender: "@bob:test", Body: "same millisecond, older", Timestamp: at},
+	}}
+	summarizer := &fakeSummarizer{summary: "- a new topic"}
+	state := &fakeState{values: map[string]string{
+		matrix.SummaryPositionKey("!room:test"): matrix.SummaryPosition{EventID: "$2", Timestamp: at}.String(),
+	}}
+	svc := newTestService(t, testConfig(), Deps{History: history, Summarizer: summarizer, State: state})
+	svc.now = func() time.Time { return at.Add(48 * time.Hour) }
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/catchmeup  since LAST")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if !history.since.Equal(at) {
+		t.Fatalf("history since = %s, want the last summary's position", history.since)
+	}
+	if len(summarizer.got) != 1 || summarizer.got[0].EventID != "$3" {
+		t.Fatalf("summarized %#v, want only the message after the last summary", summarizer.got)
+	}
+	pos, ok := matrix.ParseSummaryPosition(state.values[matrix.SummaryPositionKey("!room:test")])
+	if !ok || pos.EventID != "$3" || !pos.Timestamp.Equal(at.Add(time.Minute)) {
+		t.Fatalf("saved position = %#v", pos)
+	}
+}
+
+func TestHandleMatrixMessage_IndexesSummaries(t *testing.T) {
+	at := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{RoomID: "!room:test", EventID: "$2", Sender: "@bob:test", Body: "switch to dnscrypt?", Timestamp: at.Add(time.Hour)},
+		{RoomID: "!room:test", EventID: "$1", Sender: "@alice:test", Body: "our resolver leaks", Timestamp: at},
+	}}
+	summaryURL := "https://matrix.to/#/%21room:test/$1?summary=20260301T0900Z..20260301T1000Z"
+	backend := &fakeChatBackend{fakeBackend: fakeBackend{results: []hister.SearchResult{
+		{Title: "Discussion summary", URL: "https://matrix.to/#/%21other:test/$9?summary=20260301T0900Z..20260301T1000Z"},
+		{Title: "Discussion summary", URL: summaryURL},
+		{Title: "DNSCrypt", URL: "https://dnscrypt.info"},
+	}}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.IndexSummaries = true
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, History: history, Summarizer: &fakeSummarizer{summary: "- moving DNS to dnscrypt"}})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/catchmeup")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	got, ok := backend.chats[summaryURL]
+	if !ok || got.Text != "- moving DNS to dnscrypt" || got.Title != "Discussion summary, 2026-03-01 09:00 UTC to 2026-03-01 10:00 UTC" {
+		t.Fatalf("unexpected indexed summaries: %#v", backend.chats)
+	}
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search dnscrypt")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	body := replier.replies[1].Body
+	if !strings.Contains(body, summaryURL) || !strings.Contains(body, "https://dnscrypt.info") || strings.Contains(body, "other:test") {
+		t.Fatalf("expected this room's summary and links only, got %q", body)
+	}
+}
+
+func TestHandleMatrixMessage_CatchMeUpHistoryVisibility(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	joined := now.Add(-2 * time.Hour)
+	visibility := &fakeVisibility{access: matrix.HistoryAccess{
+		Visibility:  event.HistoryVisibilityJoined,
+		Joined:      true,
+		MemberSince: joined,
+	}}
+	run := func(cfg Config) (*fakeHistory, *fakeReplier) {
+		t.Helper()
+		history := &fakeHistory{messages: []matrix.RoomMessage{{Sender: "@bob:test", Body: "hello"}}}
+		replier := &fakeReplier{}
+		svc := newTestService(t, cfg, Deps{
+			Replier:    replier,
+			History:    history,
+			Summarizer: &fakeSummarizer{summary: "- greetings"},
+			Visibility: visibility,
+		})
+		svc.now = func() time.Time { return now }
+		if err := svc.HandleMatrixMessage(context.Background(), message("/catchmeup")); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+		return history, replier
+	}
+
+	history, replier := run(testConfig())
+	if !history.since.Equal(joined) || len(replier.replies) != 1 || replier.replies[0].Body != "- greetings" {
+		t.Fatalf("limit: since=%v replies=%#v", history.since, replier.replies)
+	}
+
+	cfg := testConfig()
+	cfg.RoomCatchMeUpHistory = map[id.RoomID]string{message("").RoomID: HistoryRefuse}
+	history, replier = run(cfg)
+	if history.calls != 0 || len(replier.replies) != 1 || !strings.Contains(replier.replies[0].Body, "only visible from when you joined") {
+		t.Fatalf("refuse: calls=%d replies=%#v", history.calls, replier.replies)
+	}
+
+	cfg.CatchMeUpHistory = HistoryOff
+	cfg.RoomCatchMeUpHistory = nil
+	history, _ = run(cfg)
+	if !history.since.Equal(now.Add(-DefaultCatchMeUpWindow)) {
+		t.Fatalf("off: since=%v, want full window", history.since)
+	}
+
+	visibility.access.Visibility = event.HistoryVisibilityShared
+	history, _ = run(testConfig())
+	if !history.since.Equal(now.Add(-DefaultCatchMeUpWindow)) {
+		t.Fatalf("shared: since=%v, want full window", history.since)
+	}
+
+	visibility.err = errors.New("boom")
+	history, replier = run(testConfig())
+	if history.calls != 0 || len(replier.replies) != 1 || replier.replies[0].Body != "Catch-up failed, please try again." {
+		t.Fatalf("lookup failure: calls=%d replies=%#v", history.calls, replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_CatchMeUpLimits(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	run := func(cfg Config) *fakeHistory {
+		t.Helper()
+		history := &fakeHistory{messages: []matrix.RoomMessage{{Sender: "@bob:test", Body: "hello"}}}
+		svc := newTestService(t, cfg, Deps{Replier: &fakeReplier{}, History: history, Summarizer: &fakeSummarizer{summary: "- greetings"}})
+		svc.now = func() time.Time { return now }
+		if err := svc.HandleMatrixMessage(context.Background(), message("/catchmeup")); err != nil {
+			t.Fatalf("HandleMatrixMessage failed: %v", err)
+		}
+		return history
+	}
+
+	history := run(testConfig())
+	if !history.since.Equal(now.Add(-DefaultCatchMeUpWindow)) || history.max != DefaultCatchMeUpMaxMessages {
+		t.Fatalf("defaults: since=%v max=%d", history.since, history.max)
+	}
+
+	cfg := testConfig()
+	cfg.CatchMeUpLimits = CatchMeUpLimits{Window: 6 * time.Hour, MaxMessages: 100}
+	cfg.RoomCatchMeUpLimits = map[id.RoomID]CatchMeUpLimits{message("").RoomID: {MaxMessages: 500}}
+	history = run(cfg)
+	if !history.since.Equal(now.Add(-6*time.Hour)) || history.max != 500 {
+		t.Fatalf("room override: since=%v max=%d", history.since, history.max)
+	}
+}
+
+func TestHandleMatrixMessage_CommandPrefix(t *testing.T) {
+	cfg := testConfig()
+	cfg.CommandPrefix = "!"
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{Sender: "@alice:test", Body: "!catchmeup"},
+		{Sender: "@bob:test", Body: "hello"},
+	}}
+	summarizer := &fakeSummarizer{summary: "- greetings"}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Replier: replier, History: history, Summarizer: summarizer})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/catchmeup"))
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected default prefix to be ignored, got %#v", replier.replies)
+	}
+	if err := svc.HandleMatrixMessage(context.Background(), message("!catchmeup")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(summarizer.got) != 1 || summarizer.got[0].Body != "hello" {
+		t.Fatalf("unexpected summarizer input: %#v", summarizer.got)
+	}
+}
+
+func TestHandleMatrixMessage_CommandTypos(t *testing.T) {
+	cfg := testConfig()
+	cfg.SearchCommand = "/search"
+	cfg.CommandTypos = TyposSuggest
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/serach golang"))
+	if len(backend.queries) != 0 || len(replier.replies) != 1 || replier.replies[0].Body != "Did you mean /search?" {
+		t.Fatalf("unexpected suggest handling: queries=%#v replies=%#v", backend.queries, replier.replies)
+	}
+
+	cfg.CommandTypos = TyposExecute
+	backend = &fakeBackend{}
+	replier = &fakeReplier{}
+	svc = newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/serach golang"))
+	if len(backend.queries) != 1 || backend.queries[0] != "golang" {
+		t.Fatalf("expected mistyped search to run, got %#v", backend.queries)
+	}
+	_ = svc.HandleMatrixMessage(context.Background(), message("/shrug golang"))
+	if len(backend.queries) != 1 || len(replier.replies) != 1 {
+		t.Fatalf("expected unrelated command to be ignored, got queries=%#v replies=%#v", backend.queries, replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_Broadcast(t *testing.T) {
+	cfg := testConfig()
+	cfg.Admins = []id.UserID{"@admin:test"}
+	cfg.BroadcastRooms = []id.RoomID{"!room:test", "!other:test"}
+	cfg.BroadcastRoomLimit = Limit{Max: 1, Window: time.Hour}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/broadcast Downtime at 22:00"))
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected non-admin broadcast to be ignored, got %#v", replier.replies)
+	}
+
+	admin := message("/broadcast !other:test Downtime at 22:00")
+	admin.Sender = "@admin:test"
+	_ = svc.HandleMatrixMessage(context.Background(), admin)
+	admin.Body = "/broadcast Reindexing tonight"
+	_ = svc.HandleMatrixMessage(context.Background(), admin)
+
+	want := []matrix.Reply{
+		{RoomID: "!other:test", Body: "Downtime at 22:00"},
+		{RoomID: "!room:test", InReplyToEventID: "$evt", Body: "Announcement sent to 1 of 1 rooms.", Thread: true},
+		{RoomID: "!room:test", Body: "Reindexing tonight"},
+		{RoomID: "!room:test", InReplyToEventID: "$evt", Body: "Announcement sent to 1 of 2 rooms.", Thread: true},
+	}
+	if !reflect.DeepEqual(replier.replies, want) {
+		t.Fatalf("unexpected replies:\n got %#v\nwant %#v", replier.replies, want)
+	}
+
+	admin.Body = "/broadcast !elsewhere:test hi"
+	_ = svc.HandleMatrixMessage(context.Background(), admin)
+	if got := replier.replies[len(replier.replies)-1].Body; got != "!elsewhere:test is not an allowed room." {
+		t.Fatalf("unexpected unknown room reply: %q", got)
+	}
+}
+
+func TestHandleMatrixMessage_Version(t *testing.T) {
+	cfg := testConfig()
+	cfg.Version = "v1.2.0 (commit abc123)"
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Replier: replier})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/version")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(replier.replies) != 1 || replier.replies[0].Body != "hister-matrix-bot v1.2.0 (commit abc123)" {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_IgnorePrefixes(t *testing.T) {
+	cfg := testConfig()
+	cfg.IgnorePrefixes = []string{"!", "NB:"}
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("!search golang https://a.example"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("nb: https://b.example golang @bot"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("https://c.example"))
+
+	if len(backend.indexed) != 1 || backend.indexed[0] != "https://c.example" {
+		t.Fatalf("unexpected indexed urls: %#v", backend.indexed)
+	}
+	if len(backend.queries) != 0 || len(replier.replies) != 0 {
+		t.Fatalf("expected ignored messages to skip search, got queries=%#v replies=%#v", backend.queries, replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_CustomTriggers(t *testing.T) {
+	search, err := triggers.CompileCustomTrigger(`(?i)^what is (.+?)\??$`, "search", "")
+	if err != nil {
+		t.Fatalf("compile search trigger: %v", err)
+	}
+	reply, err := triggers.CompileCustomTrigger(`^ping$`, "reply", "pong")
+	if err != nil {
+		t.Fatalf("compile reply trigger: %v", err)
+	}
+	cfg := testConfig()
+	cfg.CustomTriggers = []triggers.CustomTrigger{search, reply}
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("what is golang?"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("ping"))
+
+	if len(backend.queries) != 1 || backend.queries[0] != "golang" {
+		t.Fatalf("unexpected search calls: %#v", backend.queries)
+	}
+	if len(replier.replies) != 2 || replier.replies[1].Body != "pong" {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_CustomIndexTrigger(t *testing.T) {
+	index, err := triggers.CompileCustomTrigger(`^save (\S+)`, "index", "")
+	if err != nil {
+		t.Fatalf("compile index trigger: %v", err)
+	}
+	cfg := testConfig()
+	cfg.CustomTriggers = []triggers.CustomTrigger{index}
+	cfg.IndexSenders = SenderFilter{Deny: []string{"@mallory:test"}}
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	links := &fakeLinks{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, Links: links})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("save https://a.example #go")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.indexed) != 1 || backend.indexed[0] != "https://a.example" {
+		t.Fatalf("expected the url indexed once, got %#v", backend.indexed)
+	}
+	if len(links.shared) != 1 || links.shared[0].URL != "https://a.example" || len(links.added["https://a.example"]) != 1 {
+		t.Fatalf("expected the url recorded with its tags, got %#v %#v", links.shared, links.added)
+	}
+	if len(replier.replies) != 1 || !strings.Contains(replier.replies[0].Body, "https://a.example") {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+
+	denied := message("save https://b.example")
+	denied.Sender = "@mallory:test"
+	if err := svc.HandleMatrixMessage(context.Background(), denied); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.indexed) != 1 || len(replier.replies) != 1 {
+		t.Fatalf("expected a denied sender's trigger to be ignored, got indexed=%#v replies=%#v", backend.indexed, replier.replies)
+	}
+}
+
+func TestHandleMatrixReaction_IndexesTargetURLs(t *testing.T) {
+	cfg := testConfig()
+	cfg.IndexReaction = "🔖"
+	cfg.IndexedReaction = "✅"
+	backend := &fakeBackend{}
+	reactor := &fakeReactor{}
+	messages := &fakeMessages{msg: matrix.RoomMessage{Sender: "@bob:test", Body: "read https://a.example and https://a.example"}}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Messages: messages, Reactor: reactor})
+
+	reaction := matrix.Reaction{RoomID: "!room:test", EventID: "$r", Sender: "@alice:test", TargetEventID: "$target", Key: "👍"}
+	if err := svc.HandleMatrixReaction(context.Background(), reaction); err != nil {
+		t.Fatalf("HandleMatrixReaction failed: %v", err)
+	}
+	if len(backend.indexed) != 0 {
+		t.Fatalf("expected other reactions to be ignored, got %#v", backend.indexed)
+	}
+
+	reaction.Key = "🔖\ufe0f"
+	if err := svc.HandleMatrixReaction(context.Background(), reaction); err != nil {
+		t.Fatalf("HandleMatrixReaction failed: %v", err)
+	}
+	if len(backend.indexed) != 1 || backend.indexed[0] != "https://a.example" {
+		t.Fatalf("unexpected indexed urls: %#v", backend.indexed)
+	}
+	if len(reactor.sent) != 1 || reactor.sent[0] != (sentReaction{roomID: "!room:test", eventID: "$target", key: "✅"}) {
+		t.Fatalf("unexpected confirmation reactions: %#v", reactor.sent)
+	}
+}
+
+func TestHandleMatrixMessage_SearchFromMentionPill(t *testing.T) {
+	backend := &fakeBackend{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend})
+
+	msg := message("Hister Bot: golang generics")
+	msg.FormattedBody = `<a href="https://matrix.to/#/@bot:test">Hister Bot</a>: golang generics`
+	if err := svc.HandleMatrixMessage(context.Background(), msg); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.queries) != 1 || backend.queries[0] != "golang generics" {
+		t.Fatalf("unexpected search calls: %#v", backend.queries)
+	}
+}
+
+func TestHandleMatrixMessage_TagsSharedLinks(t *testing.T) {
+	links := &fakeLinks{}
+	svc := newTestService(t, testConfig(), Deps{Links: links})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("neat #Go #tools https://a.example")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	got := links.added["https://a.example"]
+	if len(got) != 2 || got[0] != "go" || got[1] != "tools" {
+		t.Fatalf("unexpected stored tags: %#v", links.added)
+	}
+	if len(links.shared) != 1 || links.shared[0].Sender != "@alice:test" || links.shared[0].EventID != "$evt" {
+		t.Fatalf("unexpected shared link records: %#v", links.shared)
+	}
+}
+
+func TestHandleMatrixMessage_TagFilteredSearch(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "A", URL: "https://a.example"},
+		{Title: "B", URL: "https://b.example"},
+	}}
+	links := &fakeLinks{found: []string{"https://b.example"}}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier, Links: links})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search tag:go generics"))
+	if len(backend.queries) != 1 || backend.queries[0] != "generics" || backend.limits[0] != 5*filteredSearchOverfetch {
+		t.Fatalf("unexpected search calls: %#v %#v", backend.queries, backend.limits)
+	}
+	if len(replier.replies) != 1 || !strings.Contains(replier.replies[0].Body, "1. B") || strings.Contains(replier.replies[0].Body, "https://a.example") {
+		t.Fatalf("unexpected filtered reply: %#v", replier.replies)
+	}
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search tag:go"))
+	if len(backend.queries) != 1 {
+		t.Fatalf("expected tag-only search to skip backend, got %#v", backend.queries)
+	}
+	if len(replier.replies) != 2 || !strings.Contains(replier.replies[1].Body, "https://b.example") {
+		t.Fatalf("unexpected tag-only reply: %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_SearchOperators(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "Blog", URL: "https://go.dev/blog/x"},
+		{Title: "Other", URL: "https://example.org/go"},
+		{Title: "Pkg", URL: "https://pkg.go.dev/y"},
+	}}
+	links := &fakeLinks{found: []string{"https://pkg.go.dev/y", "https://example.org/go"}}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier, Links: links})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message(`/search "type params" site:go.dev from:@bob after:2024-01-01`))
+
+	if len(backend.queries) != 1 || backend.queries[0] != `"type params"` {
+		t.Fatalf("unexpected backend query: %#v", backend.queries)
+	}
+	if len(links.filters) != 1 || len(links.filters[0].Senders) != 1 || links.filters[0].Senders[0] != "@bob" || links.filters[0].After.IsZero() {
+		t.Fatalf("unexpected link filter: %#v", links.filters)
+	}
+	if !slices.Equal(links.filters[0].Rooms, []string{"!room:test"}) {
+		t.Fatalf("expected the link filter limited to the searching room, got %#v", links.filters[0].Rooms)
+	}
+	body := replier.replies[0].Body
+	if !strings.Contains(body, "1. Pkg") || strings.Contains(body, "Blog") || strings.Contains(body, "Other") {
+		t.Fatalf("unexpected filtered results:\n%s", body)
+	}
+}
+
+type fakeFilteringBackend struct {
+	fakeBackend
+	filters []hister.Filters
+}
+
+func (f *fakeFilteringBackend) SearchFiltered(_ context.Context, query string, filters hister.Filters, _, limit int) (hister.Page, error) {
+	f.queries = append(f.queries, query)
+	f.limits = append(f.limits, limit)
+	f.filters = append(f.filters, filters)
+	return hister.Page{Results: f.results}, f.searchErr
+}
+
+func TestHandleMatrixMessage_SearchForwardsFilters(t *testing.T) {
+	backend := &fakeFilteringBackend{}
+	backend.results = []hister.SearchResult{
+		{Title: "Blog", URL: "https://go.dev/blog/x"},
+		{Title: "Other", URL: "https://example.org/go"},
+	}
+	links := &fakeLinks{found: []string{"https://go.dev/blog/x", "https://example.org/go"}}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier, Links: links})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search generics site:go.dev room:!go:test before:2024-02-01"))
+
+	if len(backend.filters) != 1 || backend.queries[0] != "generics" {
+		t.Fatalf("unexpected backend searches: %#v %#v", backend.queries, backend.filters)
+	}
+	want := hister.Filters{Sites: []string{"go.dev"}, Before: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Rooms: []string{"!go:test"}}
+	if got := backend.filters[0]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("forwarded filters = %#v, want %#v", got, want)
+	}
+	if len(links.filters) != 1 || !slices.Equal(links.filters[0].Rooms, []string{"!go:test"}) {
+		t.Fatalf("unexpected link filter: %#v", links.filters)
+	}
+	if body := replier.replies[0].Body; !strings.Contains(body, "1. Blog") || strings.Contains(body, "Other") {
+		t.Fatalf("expected results still checked against site:, got:\n%s", body)
+	}
+}
+
+type fakeSourceBackend struct {
+	fakeBackend
+	sources []hister.Source
+}
+
+func (f *fakeSourceBackend) IndexURLFrom(ctx context.Context, rawURL string, source hister.Source) (extractor.Result, error) {
+	f.sources = append(f.sources, source)
+	return extractor.Result{}, f.IndexURL(ctx, rawURL)
+}
+
+func (f *fakeSourceBackend) IndexURLsFrom(ctx context.Context, urls []string, sources []hister.Source) []error {
+	errs := make([]error, len(urls))
+	for i, rawURL := range urls {
+		_, errs[i] = f.IndexURLFrom(ctx, rawURL, sources[i])
+	}
+	return errs
+}
+
+func TestHandleMatrixMessage_IndexesAndShowsSources(t *testing.T) {
+	now := time.Date(2024, 3, 22, 12, 0, 0, 0, time.UTC)
+	backend := &fakeSourceBackend{}
+	backend.results = []hister.SearchResult{
+		{Title: "Go", URL: "https://go.dev", Source: hister.Source{RoomID: "!dev:test", Sender: "@bob:test", SharedAt: now.Add(-21 * 24 * time.Hour)}},
+		{Title: "Rust", URL: "https://rust-lang.org"},
+	}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.IndexSources = true
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+	svc.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := svc.HandleMatrixMessage(ctx, message("see https://go.dev")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	want := hister.Source{RoomID: "!room:test", Sender: "@alice:test", EventID: "$evt", SharedAt: now}
+	if len(backend.sources) != 1 || backend.sources[0] != want {
+		t.Fatalf("indexed sources = %#v, want %#v", backend.sources, want)
+	}
+
+	if err := svc.HandleMatrixMessage(ctx, message("/search go")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	body := replier.replies[len(replier.replies)-1].Body
+	if !strings.Contains(body, "https://go.dev\nShared by @bob:test 3w ago in !dev:test") || strings.Count(body, "Shared by") != 1 {
+		t.Fatalf("unexpected results:\n%s", body)
+	}
+}
+
+// fakeRoomBackend keeps a fakeBackend per room.
+type fakeRoomBackend struct {
+	fakeBackend
+	rooms map[id.RoomID]*fakeBackend
+}
+
+func (f *fakeRoomBackend) ForRoom(roomID id.RoomID) hister.SearchBackend {
+	if f.rooms[roomID] == nil {
+		f.rooms[roomID] = &fakeBackend{}
+	}
+	return f.rooms[roomID]
+}
+
+func (f *fakeRoomBackend) IndexName(roomID id.RoomID) string {
+	return string(roomID)
+}
+
+func TestHandleMatrixMessage_ScopesIndexAndSearchToRoom(t *testing.T) {
+	backend := &fakeRoomBackend{rooms: map[id.RoomID]*fakeBackend{}}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend})
+	ctx := context.Background()
+
+	if err := svc.HandleMatrixMessage(ctx, message("see https://go.dev")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	other := message("/search go")
+	other.RoomID, other.EventID = "!other:test", "$other"
+	if err := svc.HandleMatrixMessage(ctx, other); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+
+	if len(backend.indexed) != 0 || len(backend.queries) != 0 {
+		t.Fatalf("unscoped backend used: indexed=%v queries=%v", backend.indexed, backend.queries)
+	}
+	room, otherRoom := backend.rooms["!room:test"], backend.rooms["!other:test"]
+	if room == nil || !reflect.DeepEqual(room.indexed, []string{"https://go.dev"}) || len(room.queries) != 0 {
+		t.Fatalf("room backend = %#v, want only the shared link", room)
+	}
+	if otherRoom == nil || len(otherRoom.indexed) != 0 || len(otherRoom.queries) != 1 {
+		t.Fatalf("other room backend = %#v, want only the search", otherRoom)
+	}
+}
+
+func TestHandleMatrixMessage_LinkSearchScopedToRoom(t *testing.T) {
+	backend := &fakeRoomBackend{rooms: map[id.RoomID]*fakeBackend{}}
+	links := &fakeLinks{}
+	replier := &fakeReplier{}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier, Links: links})
+	ctx := context.Background()
+
+	shared := message("see https://b.example")
+	shared.RoomID, shared.EventID, shared.Sender = "!b:test", "$b", "@bob:test"
+	if err := svc.HandleMatrixMessage(ctx, shared); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+
+	for i, query := range []string{"/search from:@bob:test", "/search from:@bob:test room:!b:test"} {
+		if err := svc.HandleMatrixMessage(ctx, message(query)); err != nil {
+			t.Fatalf("HandleMatrixMessage(%q) failed: %v", query, err)
+		}
+		if body := replier.replies[i].Body; strings.Contains(body, "b.example") {
+			t.Fatalf("%q found another room's link:\n%s", query, body)
+		}
+	}
+
+	search := message("/search from:@bob:test")
+	search.RoomID, search.EventID = "!b:test", "$search"
+	if err := svc.HandleMatrixMessage(ctx, search); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if body := replier.replies[2].Body; !strings.Contains(body, "https://b.example") {
+		t.Fatalf("expected the link found in its own room, got:\n%s", body)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	for age, want := range map[time.Duration]string{
+		10 * time.Second:     "1m",
+		90 * time.Minute:     "1h",
+		50 * time.Hour:       "2d",
+		15 * 24 * time.Hour:  "2w",
+		45 * 24 * time.Hour:  "1mo",
+		800 * 24 * time.Hour: "2y",
+	} {
+		if got := formatAge(age); got != want {
+			t.Errorf("formatAge(%s) = %q, want %q", age, got, want)
+		}
+	}
+}
+
+func TestHandleMatrixMessage_BulkIndexNeedsConfirmation(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxAutoIndexURLs = 1
+	cfg.BulkIndexConfirmReaction = "👍"
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("dump https://a.example https://b.example"))
+	if len(backend.indexed) != 0 {
+		t.Fatalf("expected no automatic indexing, got %#v", backend.indexed)
+	}
+	if len(replier.replies) != 1 || !strings.Contains(replier.replies[0].Body, "React to it with 👍") {
+		t.Fatalf("expected confirmation prompt, got %#v", replier.replies)
+	}
+
+	reaction := matrix.Reaction{RoomID: "!room:test", EventID: "$r", Sender: "@bob:test", TargetEventID: "$evt", Key: "👍"}
+	_ = svc.HandleMatrixReaction(context.Background(), reaction)
+	if len(backend.indexed) != 0 {
+		t.Fatalf("expected confirmation from other users to be ignored, got %#v", backend.indexed)
+	}
+
+	reaction.Sender = "@alice:test"
+	if err := svc.HandleMatrixReaction(context.Background(), reaction); err != nil {
+		t.Fatalf("HandleMatrixReaction failed: %v", err)
+	}
+	if len(backend.indexed) != 2 {
+		t.Fatalf("expected both urls indexed after confirmation, got %#v", backend.indexed)
+	}
+	if len(replier.replies) != 2 || replier.replies[1].Body != "Indexed 2 of 2 links." {
+		t.Fatalf("unexpected confirmation reply: %#v", replier.replies)
+	}
+
+	_ = svc.HandleMatrixReaction(context.Background(), reaction)
+	if len(backend.indexed) != 2 {
+		t.Fatalf("expected repeated confirmation to be a no-op, got %#v", backend.indexed)
+	}
+}
+
+func TestHandleMatrixMessage_LocalizedReplies(t *testing.T) {
+	catalog, err := i18n.NewCatalog("en", map[string]string{"!room:test": "de"}, nil)
+	if err != nil {
+		t.Fatalf("NewCatalog failed: %v", err)
+	}
+	cfg := testConfig()
+	cfg.Messages = catalog
+	replier := &fakeReplier{}
+	svc := newTestService(t, cfg, Deps{Replier: replier})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	other := message("/search golang")
+	other.RoomID = "!other:test"
+	_ = svc.HandleMatrixMessage(context.Background(), other)
+
+	if len(replier.replies) != 2 {
+		t.Fatalf("expected two replies, got %#v", replier.replies)
+	}
+	if replier.replies[0].Body != "Keine Ergebnisse für: golang" {
+		t.Fatalf("unexpected localized reply: %q", replier.replies[0].Body)
+	}
+	if replier.replies[1].Body != "No results for: golang" {
+		t.Fatalf("unexpected default reply: %q", replier.replies[1].Body)
+	}
+}
+
+func TestHandleMatrixMessage_Backfill(t *testing.T) {
+	cfg := testConfig()
+	cfg.Admins = []id.UserID{"@admin:test"}
+	cfg.BackfillDepth = 100
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	links := &fakeLinks{}
+	older := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	// History is returned newest first, like the Matrix client does.
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{EventID: "$3", Sender: "@bob:test", Body: "again https://a.example", Timestamp: older.Add(2 * time.Hour)},
+		{EventID: "$2", Sender: "@bot:test", Body: "Indexed https://bot.example", Timestamp: older.Add(time.Hour)},
+		{EventID: "$1", Sender: "@alice:test", Body: "see https://a.example and https://b.example #go", Timestamp: older},
+	}}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, History: history, Links: links})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/backfill"))
+	if len(replier.replies) != 0 || len(svc.backfills) != 0 {
+		t.Fatalf("expected non-admin backfill to be ignored, got %#v", replier.replies)
+	}
+
+	admin := message("/backfill")
+	admin.Sender = "@admin:test"
+	_ = svc.HandleMatrixMessage(context.Background(), admin)
+	svc.runBackfill(context.Background(), <-svc.backfills)
+
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(backend.indexed, want) {
+		t.Fatalf("unexpected indexed urls: %#v", backend.indexed)
+	}
+	if len(links.shared) != 2 || links.shared[0].EventID != "$1" || links.shared[0].Sender != "@alice:test" || !links.shared[0].SharedAt.Equal(older) {
+		t.Fatalf("expected links attributed to the original message, got %#v", links.shared)
+	}
+	if !reflect.DeepEqual(links.added["https://a.example"], []string{"go"}) {
+		t.Fatalf("expected hashtags to be recorded, got %#v", links.added)
+	}
+	want := []string{
+		"Backfilling links from up to 100 past messages in this room.",
+		"Backfill finished: indexed 2 of 2 links.",
+	}
+	if len(replier.replies) != len(want) {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+	for i, body := range want {
+		if replier.replies[i].Body != body || replier.replies[i].InReplyToEventID != "$evt" {
+			t.Fatalf("unexpected reply %d: %#v", i, replier.replies[i])
+		}
+	}
+}
+
+type fakeBatchBackend struct {
+	fakeBackend
+	batches [][]string
+}
+
+func (f *fakeBatchBackend) IndexURLs(_ context.Context, urls []string) []error {
+	f.batches = append(f.batches, urls)
+	errs := make([]error, len(urls))
+	for i, rawURL := range urls {
+		if strings.Contains(rawURL, "broken") {
+			errs[i] = errors.New("boom")
+		}
+	}
+	return errs
+}
+
+func TestHandleMatrixJoin_BackfillsInOneBatch(t *testing.T) {
+	cfg := testConfig()
+	cfg.BackfillDepth = 10
+	cfg.BackfillOnJoin = true
+	backend := &fakeBatchBackend{}
+	links := &fakeLinks{}
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{EventID: "$2", Sender: "@bob:test", Body: "https://broken.example and https://a.example"},
+		{EventID: "$1", Sender: "@alice:test", Body: "https://a.example and https://b.example"},
+	}}
+	svc := newTestService(t, cfg, Deps{Backend: backend, History: history, Links: links})
+
+	if err := svc.HandleMatrixJoin(context.Background(), "!room:test"); err != nil {
+		t.Fatalf("HandleMatrixJoin failed: %v", err)
+	}
+	svc.runBackfill(context.Background(), <-svc.backfills)
+
+	want := [][]string{{"https://a.example", "https://b.example", "https://broken.example"}}
+	if !reflect.DeepEqual(backend.batches, want) || len(backend.indexed) != 0 {
+		t.Fatalf("expected one batch %v, got batches %v and single adds %v", want, backend.batches, backend.indexed)
+	}
+	if len(links.shared) != 2 || links.shared[0].EventID != "$1" || links.shared[1].URL != "https://b.example" {
+		t.Fatalf("expected only the indexed links recorded, got %#v", links.shared)
+	}
+}
+
+func TestHandleMatrixMessage_BackfillWindow(t *testing.T) {
+	cfg := testConfig()
+	cfg.Admins = []id.UserID{"@admin:test"}
+	cfg.BackfillDepth = 100
+	replier := &fakeReplier{}
+	history := &fakeHistory{}
+	svc := newTestService(t, cfg, Deps{Backend: &fakeBackend{}, Replier: replier, History: history})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	bad := message("/backfill forever")
+	bad.Sender = "@admin:test"
+	_ = svc.HandleMatrixMessage(context.Background(), bad)
+	if len(svc.backfills) != 0 || len(replier.replies) != 1 || replier.replies[0].Body != "Usage: /backfill [age, e.g. 90d]" {
+		t.Fatalf("expected usage reply for a bad window, got %#v", replier.replies)
+	}
+
+	admin := message("/backfill 90d")
+	admin.Sender = "@admin:test"
+	_ = svc.HandleMatrixMessage(context.Background(), admin)
+	svc.runBackfill(context.Background(), <-svc.backfills)
+
+	if want := now.Add(-90 * 24 * time.Hour); !history.since.Equal(want) || history.max != backfillWindowMessages {
+		t.Fatalf("expected history since %s up to %d messages, got since %s max %d", want, backfillWindowMessages, history.since, history.max)
+	}
+	if len(replier.replies) != 3 || replier.replies[1].Body != "Backfilling links from the last 90d in this room." {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixJoin_BackfillsOnce(t *testing.T) {
+	cfg := testConfig()
+	cfg.BackfillDepth = 10
+	cfg.BackfillOnJoin = true
+	backend := &fakeBackend{}
+	replier := &fakeReplier{}
+	state := &fakeState{}
+	history := &fakeHistory{messages: []matrix.RoomMessage{{EventID: "$1", Sender: "@alice:test", Body: "https://a.example"}}}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, History: history, State: state})
+
+	if err := svc.HandleMatrixJoin(context.Background(), "!room:test"); err != nil {
+		t.Fatalf("HandleMatrixJoin failed: %v", err)
+	}
+	svc.runBackfill(context.Background(), <-svc.backfills)
+	if !reflect.DeepEqual(backend.indexed, []string{"https://a.example"}) {
+		t.Fatalf("unexpected indexed urls: %#v", backend.indexed)
+	}
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected join backfill to stay silent, got %#v", replier.replies)
+	}
+	if state.values["backfilled:!room:test"] == "" {
+		t.Fatalf("expected backfill to be recorded, got %#v", state.values)
+	}
+
+	_ = svc.HandleMatrixJoin(context.Background(), "!room:test")
+	if len(svc.backfills) != 0 {
+		t.Fatal("expected a backfilled room not to be queued again")
+	}
+}
+
+func TestHandleMatrixJoin_WelcomesOnce(t *testing.T) {
+	cfg := testConfig()
+	cfg.WelcomeOnJoin = true
+	cfg.SearchCommand = "/search"
+	cfg.IgnorePrefixes = []string{"!nobot"}
+	replier := &fakeReplier{}
+	state := &fakeState{}
+	svc := newTestService(t, cfg, Deps{Replier: replier, State: state})
+
+	_ = svc.HandleMatrixJoin(context.Background(), "!room:test")
+	_ = svc.HandleMatrixJoin(context.Background(), "!room:test")
+
+	if len(replier.replies) != 1 {
+		t.Fatalf("expected one welcome, got %#v", replier.replies)
+	}
+	want := "Hi! I keep the links shared in this room searchable.\n" +
+		"- /search <terms> searches them.\n" +
+		"- Links in messages are indexed automatically.\n" +
+		"- Start a message with !nobot to keep its links out of the index."
+	if got := replier.replies[0]; got.RoomID != "!room:test" || got.InReplyToEventID != "" || got.Body != want {
+		t.Fatalf("unexpected welcome: %#v", got)
+	}
+	if !strings.Contains(replier.replies[0].FormattedBody, "<li>") {
+		t.Fatalf("expected the welcome's list in the HTML body, got %q", replier.replies[0].FormattedBody)
+	}
+
+	templates, err := render.New("", "", "Search with {{.SearchCommand}}.")
+	if err != nil {
+		t.Fatalf("render.New() error = %v", err)
+	}
+	cfg.Templates = templates
+	replier = &fakeReplier{}
+	svc = newTestService(t, cfg, Deps{Replier: replier})
+	_ = svc.HandleMatrixJoin(context.Background(), "!other:test")
+	if len(replier.replies) != 1 || replier.replies[0].Body != "Search with /search." {
+		t.Fatalf("expected the configured welcome, got %#v", replier.replies)
+	}
+
+	catalog, err := i18n.NewCatalog("en", map[string]string{"!de:test": "de"}, nil)
+	if err != nil {
+		t.Fatalf("NewCatalog failed: %v", err)
+	}
+	cfg.Templates = nil
+	cfg.Messages = catalog
+	replier = &fakeReplier{}
+	svc = newTestService(t, cfg, Deps{Replier: replier})
+	_ = svc.HandleMatrixJoin(context.Background(), "!de:test")
+	if len(replier.replies) != 1 || !strings.HasPrefix(replier.replies[0].Body, "Hallo! Ich mache die in diesem Raum geteilten Links durchsuchbar.\n- /search <Begriffe>") {
+		t.Fatalf("expected the welcome in the room's language, got %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_Watch(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxWatchesPerUser = 1
+	backend := &fakeBackend{results: []hister.SearchResult{{Title: "Old", URL: "https://old.example"}}}
+	replier := &fakeReplier{}
+	watches := &fakeWatches{}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier, Watches: watches})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/watch kubernetes"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/watch golang"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/watch"))
+
+	want := []string{
+		`Watching "kubernetes" as #1. New matches will be posted in this thread; stop with /unwatch 1.`,
+		"You already have 1 watches; remove one with /unwatch <id> first.",
+		"Your watches:\n#1 kubernetes",
+	}
+	for i, body := range want {
+		if replier.replies[i].Body != body {
+			t.Fatalf("unexpected reply %d: %q", i, replier.replies[i].Body)
+		}
+	}
+
+	// Results present when the watch was created are not reported again.
+	replier.replies = nil
+	if err := svc.checkWatches(context.Background()); err != nil {
+		t.Fatalf("checkWatches failed: %v", err)
+	}
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected no notification without new results, got %#v", replier.replies)
+	}
+
+	backend.results = append(backend.results, hister.SearchResult{Title: "New", URL: "https://new.example"})
+	_ = svc.checkWatches(context.Background())
+	_ = svc.checkWatches(context.Background())
+	if len(replier.replies) != 1 {
+		t.Fatalf("expected one notification, got %#v", replier.replies)
+	}
+	got := replier.replies[0]
+	wantBody := "@alice:test: new results for \"kubernetes\":\n\n1. New\nhttps://new.example"
+	if got.RoomID != "!room:test" || got.InReplyToEventID != "$evt" || !got.Thread || got.Body != wantBody {
+		t.Fatalf("unexpected notification: %#v", got)
+	}
+
+	replier.replies = nil
+	other := message("/unwatch 1")
+	other.Sender = "@bob:test"
+	_ = svc.HandleMatrixMessage(context.Background(), other)
+	_ = svc.HandleMatrixMessage(context.Background(), message("/unwatch #1"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/unwatch soon"))
+	want = []string{"You have no watch #1.", "Stopped watching #1.", "Usage: /unwatch <id>"}
+	for i, body := range want {
+		if replier.replies[i].Body != body {
+			t.Fatalf("unexpected unwatch reply %d: %q", i, replier.replies[i].Body)
+		}
+	}
+	if len(watches.watches) != 0 {
+		t.Fatalf("expected watch to be removed, got %#v", watches.watches)
+	}
+}
+
+func TestHandleMatrixMessage_Remind(t *testing.T) {
+	replier := &fakeReplier{}
+	reminders := &fakeReminders{}
+	svc := newTestService(t, testConfig(), Deps{Replier: replier, Reminders: reminders})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("Remind me in 2h about the deploy"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("remind me that we talked"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/remind tomorrow"))
+
+	want := []string{
+		"OK, I'll remind you at 2024-05-01 14:00 UTC.",
+		"Usage: /remind in <duration> about <text>, e.g. /remind in 2h about the deploy. Reminders can be set up to a year ahead.",
+	}
+	if len(replier.replies) != len(want) {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+	for i, body := range want {
+		if replier.replies[i].Body != body {
+			t.Fatalf("unexpected reply %d: %q", i, replier.replies[i].Body)
+		}
+	}
+	if len(reminders.reminders) != 1 || reminders.reminders[0].Text != "the deploy" || !reminders.reminders[0].DueAt.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("unexpected stored reminders: %#v", reminders.reminders)
+	}
+
+	replier.replies = nil
+	_ = svc.sendDueReminders(context.Background())
+	if len(replier.replies) != 0 {
+		t.Fatalf("expected no reminder before it is due, got %#v", replier.replies)
+	}
+	now = now.Add(3 * time.Hour)
+	_ = svc.sendDueReminders(context.Background())
+	_ = svc.sendDueReminders(context.Background())
+	wantReply := matrix.Reply{RoomID: "!room:test", InReplyToEventID: "$evt", Body: "@alice:test: reminder: the deploy", Thread: true}
+	if !reflect.DeepEqual(replier.replies, []matrix.Reply{wantReply}) {
+		t.Fatalf("unexpected reminder replies: %#v", replier.replies)
+	}
+}
+
+func TestCheckWatchesAndReminders_SharedStore(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	watches := &fakeWatches{watches: []storage.Watch{
+		{ID: 1, RoomID: "!a:test", EventID: "$wa", UserID: "@alice:test", Query: "go"},
+		{ID: 2, RoomID: "!b:test", EventID: "$wb", UserID: "@bob:test", Query: "go"},
+	}}
+	reminders := &fakeReminders{reminders: []storage.Reminder{
+		{ID: 1, RoomID: "!a:test", EventID: "$ra", UserID: "@alice:test", Text: "deploy", DueAt: now},
+		{ID: 2, RoomID: "!b:test", EventID: "$rb", UserID: "@bob:test", Text: "review", DueAt: now},
+	}}
+	backend := &fakeBackend{results: []hister.SearchResult{{Title: "Go", URL: "https://go.dev"}}}
+
+	// Two accounts' services share the store, each owning one room.
+	repliers := map[id.RoomID]*fakeReplier{}
+	for _, room := range []id.RoomID{"!a:test", "!b:test"} {
+		cfg := testConfig()
+		cfg.OwnsRoom = func(roomID id.RoomID) bool { return roomID == room }
+		repliers[room] = &fakeReplier{}
+		svc := newTestService(t, cfg, Deps{Backend: backend, Replier: repliers[room], Watches: watches, Reminders: reminders})
+		svc.now = func() time.Time { return now }
+		if err := svc.checkWatches(context.Background()); err != nil {
+			t.Fatalf("checkWatches failed: %v", err)
+		}
+		if err := svc.sendDueReminders(context.Background()); err != nil {
+			t.Fatalf("sendDueReminders failed: %v", err)
+		}
+	}
+
+	for room, replier := range repliers {
+		if len(replier.replies) != 2 {
+			t.Fatalf("expected one watch and one reminder reply in %s, got %#v", room, replier.replies)
+		}
+		for _, reply := range replier.replies {
+			if reply.RoomID != room {
+				t.Fatalf("expected %s's service to post only there, got %#v", room, replier.replies)
+			}
+		}
+	}
+	if !slices.Equal(reminders.deleted, []int64{1, 2}) {
+		t.Fatalf("expected each reminder delivered once, deleted %v", reminders.deleted)
+	}
+}
+
+func TestHandleMatrixMessage_DeadLinks(t *testing.T) {
+	results := []hister.SearchResult{
+		{Title: "Gone", URL: "https://gone.example"},
+		{Title: "Live", URL: "https://live.example"},
+	}
+	dead := fakeDeadLinks{"https://gone.example": {}}
+
+	tests := []struct {
+		action string
+		want   string
+	}{
+		{DeadLinksDemote, "Search results for: golang\n\n1. Live\nhttps://live.example\n\n2. [dead link] Gone\nhttps://gone.example"},
+		{DeadLinksHide, "Search results for: golang\n\n1. Live\nhttps://live.example"},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.DeadLinkAction = tt.action
+		replier := &fakeReplier{}
+		svc := newTestService(t, cfg, Deps{Backend: &fakeBackend{results: results}, Replier: replier, DeadLinks: dead})
+
+		_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+		if len(replier.replies) != 1 || replier.replies[0].Body != tt.want {
+			t.Fatalf("%s: unexpected replies: %#v", tt.action, replier.replies)
+		}
+	}
+}
+
+func TestHandleMatrixMessage_RecordsSearches(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "Gone", URL: "https://gone.example"},
+		{Title: "Live", URL: "https://live.example"},
+	}}
+	cfg := testConfig()
+	cfg.DeadLinkAction = DeadLinksHide
+	searches := &fakeSearches{}
+	svc := newTestService(t, cfg, Deps{
+		Backend:   backend,
+		Replier:   &fakeReplier{},
+		DeadLinks: fakeDeadLinks{"https://gone.example": {}},
+		Searches:  searches,
+	})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	if len(searches.searches) != 1 {
+		t.Fatalf("expected one recorded search, got %#v", searches.searches)
+	}
+	got := searches.searches[0]
+	if got.RoomID != "!room:test" || got.Sender != "@alice:test" || got.Query != "golang" || !reflect.DeepEqual(got.URLs, []string{"https://live.example"}) {
+		t.Fatalf("unexpected recorded search: %#v", got)
+	}
+}
+
+func TestHandleMatrixMessage_NoResultsHelp(t *testing.T) {
+	backend := &fakeBackend{results: []hister.SearchResult{
+		{Title: "Generics in Go", URL: "https://go.dev/blog/generics"},
+		{Title: "Rust ownership", URL: "https://rust.example/ownership"},
+	}}
+	replier := &fakeReplier{}
+	docs := &fakeDocuments{count: 42}
+	svc := newTestService(t, testConfig(), Deps{Backend: backend, Replier: replier, Documents: docs})
+
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search golang"))
+	if len(docs.titles) != 2 {
+		t.Fatalf("expected result titles to be recorded, got %#v", docs.titles)
+	}
+
+	backend.results = nil
+	_ = svc.HandleMatrixMessage(context.Background(), message("/search genrics   site:www.go.dev"))
+	want := "No results for: genrics   site:www.go.dev\n" +
+		"Searched for: genrics site:go.dev\n" +
+		"The index has 42 documents.\n\n" +
+		"Closest titles:\n" +
+		"- Generics in Go\n  https://go.dev/blog/generics"
+	if len(replier.replies) != 2 || replier.replies[1].Body != want {
+		t.Fatalf("unexpected no-results reply: %#v", replier.replies)
+	}
+}
+
+func TestHandleMatrixMessage_KeyCommands(t *testing.T) {
+	cfg := testConfig()
+	cfg.Admins = []id.UserID{"@alice:test"}
+	replier := &fakeReplier{}
+	keys := &fakeKeys{}
+	svc := newTestService(t, cfg, Deps{Replier: replier, Keys: keys})
+
+	outsider := message("/exportkeys hunter2")
+	outsider.Sender = "@bob:test"
+	_ = svc.HandleMatrixMessage(context.Background(), outsider)
+	_ = svc.HandleMatrixMessage(context.Background(), message("/exportkeys hunter2"))
+	keys.direct = true
+	_ = svc.HandleMatrixMessage(context.Background(), message("/exportkeys hunter2"))
+	_ = svc.HandleMatrixMessage(context.Background(), message("/importkeys hunter2"))
+	reply := message("/importkeys hunter2")
+	reply.ReplyTo = "$file"
+	_ = svc.HandleMatrixMessage(context.Background(), reply)
+
+	want := []string{
+		"/exportkeys only works in a direct chat with me.",
+		"Exported 3 room keys. Delete your message with the passphrase.",
+		"Usage: reply to a key export file with /importkeys <passphrase>",
+		"Imported 2 new room keys (3 in the file). Delete your message with the passphrase.",
+	}
+	if len(replier.replies) != len(want) {
+		t.Fatalf("unexpected replies: %#v", replier.replies)
+	}
+	for i, body := range want {
+		if replier.replies[i].Body != body {
+			t.Fatalf("unexpected reply %d: %q", i, replier.replies[i].Body)
+		}
+	}
+	if len(keys.exported) != 1 || string(keys.exported[0]) != "keys:hunter2" {
+		t.Fatalf("unexpected exports: %q", keys.exported)
+	}
+	if !reflect.DeepEqual(keys.imported, []string{"hunter2/file$file"}) {
+		t.Fatalf("unexpected imports: %q", keys.imported)
+	}
+}
+
+type fakeChatBackend struct {
+	fakeBackend
+	chats map[string]extractor.Result
+}
+
+func (f *fakeChatBackend) IndexContent(_ context.Context, rawURL string, content extractor.Result) error {
+	if f.chats == nil {
+		f.chats = make(map[string]extractor.Result)
+	}
+	f.chats[rawURL] = content
+	return nil
+}
+
+func TestHandleMatrixMessage_ChatSearch(t *testing.T) {
+	backend := &fakeChatBackend{fakeBackend: fakeBackend{results: []hister.SearchResult{
+		{Title: "@bob:test", URL: "https://matrix.to/#/%21other:test/$1", Snippet: "deploy elsewhere"},
+		{Title: "@bob:test", URL: "https://matrix.to/#/%21room:test/$2", Snippet: "deploy on friday"},
+		{Title: "Deploy guide", URL: "https://docs.example/deploy"},
+	}}}
+	replier := &fakeReplier{}
+	cfg := testConfig()
+	cfg.ChatSearchRooms = []id.RoomID{"!room:test"}
+	svc := newTestService(t, cfg, Deps{Backend: backend, Replier: replier})
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("we deploy on friday")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if got := backend.chats["https://matrix.to/#/%21room:test/$evt"]; got.Title != "@alice:test" || got.Text != "we deploy on friday" {
+		t.Fatalf("unexpected indexed chat messages: %#v", backend.chats)
+	}
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search chat deploy")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	if len(backend.chats) != 1 {
+		t.Fatalf("expected the search command not to be indexed, got %#v", backend.chats)
+	}
+	body := replier.replies[0].Body
+	if !strings.Contains(body, "deploy on friday") || strings.Contains(body, "elsewhere") || strings.Contains(body, "Deploy guide") {
+		t.Fatalf("expected only this room's messages, got %q", body)
+	}
+
+	if err := svc.HandleMatrixMessage(context.Background(), message("/search deploy")); err != nil {
+		t.Fatalf("HandleMatrixMessage failed: %v", err)
+	}
+	body = replier.replies[1].Body
+	if !strings.Contains(body, "Deploy guide") || strings.Contains(body, "matrix.to") {
+		t.Fatalf("expected link search to leave out chat messages, got %q", body)
+	}
+}
+
+type fakeFiles map[id.EventID][]byte
+
+func (f fakeFiles) DownloadFile(_ context.Context, _ id.RoomID, eventID id.EventID, maxBytes int64) ([]byte, error) {
+	data, ok := f[eventID]
+	if !ok {
+		return nil, errors.New("no such file")
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, matrix.ErrFileTooLarge
+	}
+	return data, nil
+}
+
+func TestHandleMatrixFile_IndexesDocuments(t *testing.T) {
+	backend := &fakeChatBackend{}
+	files := fakeFiles{"$notes": []byte("# Release notes\n\nShip on friday."), "$photo": []byte{0xff, 0xd8}}
+	cfg := testConfig()
+	cfg.IndexFiles = true
+	svc := newTestService(t, cfg, Deps{Backend: backend, Files: files})
+
+	upload := func(eventID id.EventID, name, mimeType string) matrix.FileMessage {
+		return matrix.FileMessage{RoomID: "!room:test", EventID: eventID, Sender: "@alice:test", Name: name, MimeType: mimeType, Size: len(files[eventID]), URL: "mxc://test/" + id.ContentURIString(eventID[1:])}
+	}
+	if err := svc.HandleMatrixFile(context.Background(), upload("$notes", "release notes.md", "text/markdown")); err != nil {
+		t.Fatalf("HandleMatrixFile failed: %v", err)
+	}
+	if err := svc.HandleMatrixFile(context.Background(), upload("$photo", "photo.jpg", "image/jpeg")); err != nil {
+		t.Fatalf("HandleMatrixFile failed for an unsupported file: %v", err)
+	}
+
+	got, ok := backend.chats["mxc://test/notes/release%20notes.md"]
+	if len(backend.chats) != 1 || !ok {
+		t.Fatalf("unexpected indexed files: %#v", backend.chats)
+	}
+	if got.Title != "Release notes" || !strings.Contains(got.Text, "Ship on friday.") {
+		t.Fatalf("unexpected indexed content: %#v", got)
+	}
+
+	svc.cfg.IndexFiles = false
+	delete(backend.chats, "mxc://test/notes/release%20notes.md")
+	if err := svc.HandleMatrixFile(context.Background(), upload("$notes", "release notes.md", "text/markdown")); err != nil || len(backend.chats) != 0 {
+		t.Fatalf("expected files not to be indexed when disabled, got %#v, %v", backend.chats, err)
+	}
+}
+
+func TestHandleMatrixFile_IndexesCaptions(t *testing.T) {
+	backend := &fakeChatBackend{}
+	files := fakeFiles{"$notes": []byte("Ship on friday."), "$photo": []byte{0xff, 0xd8}}
+	cfg := testConfig()
+	cfg.IndexFiles = true
+	svc := newTestService(t, cfg, Deps{Backend: backend, Files: files})
+
+	notes := matrix.FileMessage{RoomID: "!room:test", EventID: "$notes", Sender: "@alice:test", Name: "notes.txt", MimeType: "text/plain", Size: 15, URL: "mxc://test/notes", Caption: "plan for the launch"}
+	photo := matrix.FileMessage{RoomID: "!room:test", EventID: "$photo", Sender: "@alice:test", Name: "whiteboard.jpg", MimeType: "image/jpeg", Size: 2, URL: "mxc://test/photo", Caption: "architecture sketch"}
+	for _, file := range []matrix.FileMessage{notes, photo} {
+		if err := svc.HandleMatrixFile(context.Background(), file); err != nil {
+			t.Fatalf("HandleMatrixFile(%s) failed: %v", file.Name, err)
+		}
+	}
+
+	got := backend.chats["mxc://test/notes/notes.txt"]
+	if !strings.HasPrefix(got.Text, "plan for the launch") || !strings.Contains(got.Text, "Ship on friday.") {
+		t.Fatalf("expected the caption indexed with the file text, got %#v", got)
+	}
+	got = backend.chats["mxc://test/photo/whiteboard.jpg"]
+	if got.Title != "whiteboard.jpg" || got.Text != "architecture sketch" {
+		t.Fatalf("expected the image indexed by its caption and name, got %#v", got)
+	}
+}
+
+func TestHandleMatrixFile_UndeclaredSizeStillLimited(t *testing.T) {
+	backend := &fakeChatBackend{}
+	files := fakeFiles{"$huge": bytes.Repeat([]byte("a"), int(extractor.MaxFileBytes)+1)}
+	cfg := testConfig()
+	cfg.IndexFiles = true
+	svc := newTestService(t, cfg, Deps{Backend: backend, Files: files})
+
+	// No size in the event: the download limit, not the declared size,
+	// keeps the contents out.
+	huge := matrix.FileMessage{RoomID: "!room:test", EventID: "$huge", Sender: "@alice:test", Name: "dump.txt", MimeType: "text/plain", URL: "mxc://test/huge"}
+	if err := svc.HandleMatrixFile(context.Background(), huge); err != nil || len(backend.chats) != 0 {
+		t.Fatalf("expected an oversized file without a caption to be skipped, got %#v, %v", backend.chats, err)
+	}
+	huge.Caption = "server logs"
+	if err := svc.HandleMatrixFile(context.Background(), huge); err != nil {
+		t.Fatalf("HandleMatrixFile failed: %v", err)
+	}
+	if got := backend.chats["mxc://test/huge/dump.txt"]; got.Title != "dump.txt" || got.Text != "server logs" {
+		t.Fatalf("expected an oversized file indexed by its caption and name, got %#v", got)
+	}
+}
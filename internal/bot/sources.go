@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// SourceIndexer is implemented by search backends that can store where a
+// link was shared with its document. Config.IndexSources needs it.
+type SourceIndexer interface {
+	IndexURLFrom(ctx context.Context, rawURL string, source hister.Source) (extractor.Result, error)
+	IndexURLsFrom(ctx context.Context, urls []string, sources []hister.Source) []error
+}
+
+// linkSource is where a link in msg, sent at sharedAt, was shared.
+func linkSource(msg matrix.Message, sharedAt time.Time) hister.Source {
+	return hister.Source{
+		RoomID:   string(msg.RoomID),
+		Sender:   string(msg.Sender),
+		EventID:  string(msg.EventID),
+		SharedAt: sharedAt,
+	}
+}
+
+// resultSource describes who shared a result, when and where, for a results
+// message in roomID. It is empty without Config.IndexSources or a complete
+// source.
+func (s *Service) resultSource(roomID id.RoomID, source hister.Source) string {
+	if !s.cfg.IndexSources || source.Sender == "" || source.RoomID == "" || source.SharedAt.IsZero() {
+		return ""
+	}
+	return s.cfg.Messages.Text(string(roomID), i18n.ResultSource, i18n.Vars{
+		"sender": source.Sender,
+		"age":    formatAge(s.now().Sub(source.SharedAt)),
+		"room":   source.RoomID,
+	})
+}
+
+// formatAge renders age in its largest whole unit, such as 3w or 5d.
+func formatAge(age time.Duration) string {
+	const day = 24 * time.Hour
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{{365 * day, "y"}, {30 * day, "mo"}, {7 * day, "w"}, {day, "d"}, {time.Hour, "h"}} {
+		if age >= unit.size {
+			return strconv.Itoa(int(age/unit.size)) + unit.name
+		}
+	}
+	return strconv.Itoa(max(int(age/time.Minute), 1)) + "m"
+}
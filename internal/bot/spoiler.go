@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"html"
+	"slices"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"maunium.net/go/mautrix/id"
+)
+
+// spoilerPlaceholder stands in for a spoilered snippet while rendering the
+// HTML body, and is replaced by the spoiler markup after escaping. It uses
+// private use characters, which escaping leaves alone.
+const spoilerPlaceholder = "\ue000spoiler\ue000"
+
+// spoilerFunc returns which results posted to roomID get their snippet
+// wrapped in a spoiler: all of them in SpoilerRooms, otherwise those on a
+// SensitiveDomains site.
+func (s *Service) spoilerFunc(roomID id.RoomID) func(hister.SearchResult) bool {
+	if slices.Contains(s.cfg.SpoilerRooms, roomID) {
+		return func(hister.SearchResult) bool { return true }
+	}
+	if len(s.cfg.SensitiveDomains) == 0 {
+		return nil
+	}
+	return func(r hister.SearchResult) bool {
+		return onSite(r.URL, s.cfg.SensitiveDomains)
+	}
+}
+
+// htmlText escapes s for a formatted body, keeping its line breaks.
+func htmlText(s string) string {
+	return strings.ReplaceAll(html.EscapeString(s), "\n", "<br>")
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// summaryTimeFormat formats the time range in the titles of indexed
+// summaries.
+const summaryTimeFormat = "2006-01-02 15:04 MST"
+
+// indexSummary indexes a /catchmeup summary of messages under
+// matrix.SummaryURL, so later searches in the room can find the discussion by
+// its summary. Failures are only logged.
+func (s *Service) indexSummary(ctx context.Context, msg matrix.Message, summary string, messages []matrix.RoomMessage) {
+	indexer, ok := s.roomBackend(msg.RoomID).(ChatIndexer)
+	if !s.cfg.IndexSummaries || !ok || len(messages) == 0 {
+		return
+	}
+	first, last := matrix.MessageSpan(messages)
+	rawURL := matrix.SummaryURL(messages)
+	title := s.text(msg, i18n.SummaryTitle, i18n.Vars{
+		"from": first.Timestamp.UTC().Format(summaryTimeFormat),
+		"to":   last.Timestamp.UTC().Format(summaryTimeFormat),
+	})
+	if err := indexer.IndexContent(ctx, rawURL, extractor.Result{Title: title, Text: summary}); err != nil {
+		s.logf("index summary failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return
+	}
+	s.recordRoomDocument(ctx, msg.RoomID, rawURL)
+	s.logf("indexed summary room=%s url=%s", msg.RoomID, rawURL)
+}
+
+// sinceLastArgs follows the /catchmeup command to summarize only the
+// messages after the room's last summary.
+const sinceLastArgs = "since last"
+
+// isCatchMeUpSinceLast reports whether body is "<command> since last".
+func isCatchMeUpSinceLast(body, command string) bool {
+	word, args, ok := strings.Cut(body, " ")
+	return ok && strings.EqualFold(word, command) && strings.EqualFold(strings.Join(strings.Fields(args), " "), sinceLastArgs)
+}
+
+// lastSummary returns the newest message covered by the room's last
+// /catchmeup or digest.
+func (s *Service) lastSummary(ctx context.Context, roomID id.RoomID) (matrix.SummaryPosition, bool) {
+	if s.state == nil {
+		return matrix.SummaryPosition{}, false
+	}
+	value, err := s.state.GetBotState(ctx, matrix.SummaryPositionKey(roomID))
+	if err != nil {
+		s.logf("load last summary position failed room=%s err=%v", roomID, err)
+		return matrix.SummaryPosition{}, false
+	}
+	return matrix.ParseSummaryPosition(value)
+}
+
+// saveLastSummary records the newest of messages as covered by the room's
+// last summary.
+func (s *Service) saveLastSummary(ctx context.Context, roomID id.RoomID, messages []matrix.RoomMessage) {
+	if s.state == nil || len(messages) == 0 {
+		return
+	}
+	if err := s.state.PutBotState(ctx, matrix.SummaryPositionKey(roomID), matrix.PositionAfter(messages).String()); err != nil {
+		s.logf("save last summary position failed room=%s err=%v", roomID, err)
+	}
+}
@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// History modes select what /catchmeup does in rooms whose history visibility
+// hides messages sent before the requester joined.
+const (
+	// HistoryLimit summarizes only messages sent since the requester joined.
+	HistoryLimit = "limit"
+	// HistoryRefuse declines to summarize when the catch-up window reaches
+	// back before the requester joined.
+	HistoryRefuse = "refuse"
+	// HistoryOff ignores history visibility.
+	HistoryOff = "off"
+)
+
+// VisibilityChecker looks up a room's history visibility and a member's
+// membership.
+type VisibilityChecker interface {
+	HistoryAccess(ctx context.Context, roomID id.RoomID, userID id.UserID) (matrix.HistoryAccess, error)
+}
+
+// historyMode returns the history mode configured for roomID.
+func (s *Service) historyMode(roomID id.RoomID) string {
+	if mode, ok := s.cfg.RoomCatchMeUpHistory[roomID]; ok && mode != "" {
+		return mode
+	}
+	if s.cfg.CatchMeUpHistory == "" {
+		return HistoryLimit
+	}
+	return s.cfg.CatchMeUpHistory
+}
+
+// catchMeUpLimits returns the /catchmeup limits for roomID, falling back to
+// the global limits and then the defaults field by field.
+func (s *Service) catchMeUpLimits(roomID id.RoomID) CatchMeUpLimits {
+	limits := s.cfg.RoomCatchMeUpLimits[roomID]
+	if limits.Window <= 0 {
+		limits.Window = s.cfg.CatchMeUpLimits.Window
+	}
+	if limits.Window <= 0 {
+		limits.Window = DefaultCatchMeUpWindow
+	}
+	if limits.MaxMessages <= 0 {
+		limits.MaxMessages = s.cfg.CatchMeUpLimits.MaxMessages
+	}
+	if limits.MaxMessages <= 0 {
+		limits.MaxMessages = DefaultCatchMeUpMaxMessages
+	}
+	return limits
+}
+
+// catchMeUpSince returns the oldest message msg's sender may have summarized,
+// given the catch-up window starting at since. ok is false when the summary
+// must be refused.
+func (s *Service) catchMeUpSince(ctx context.Context, msg matrix.Message, since time.Time) (time.Time, bool, error) {
+	mode := s.historyMode(msg.RoomID)
+	if s.visibility == nil || mode == HistoryOff {
+		return since, true, nil
+	}
+	access, err := s.visibility.HistoryAccess(ctx, msg.RoomID, msg.Sender)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if !access.Restricted() {
+		return since, true, nil
+	}
+	if !access.Joined {
+		return time.Time{}, false, nil
+	}
+	if !access.MemberSince.After(since) {
+		return since, true, nil
+	}
+	if mode == HistoryRefuse {
+		return time.Time{}, false, nil
+	}
+	return access.MemberSince, true, nil
+}
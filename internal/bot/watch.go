@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	watchCommand   = "/watch"
+	unwatchCommand = "/unwatch"
+)
+
+// WatchStore persists saved searches and the results already reported for
+// each of them.
+type WatchStore interface {
+	AddWatch(ctx context.Context, w storage.Watch) (int64, error)
+	RemoveWatch(ctx context.Context, userID id.UserID, watchID int64) (bool, error)
+	ListWatches(ctx context.Context, userID id.UserID) ([]storage.Watch, error)
+	MarkWatchSeen(ctx context.Context, watchID int64, urls []string) error
+	WatchSeen(ctx context.Context, watchID int64, urls []string) (map[string]struct{}, error)
+}
+
+// handleWatch saves query as a watch, or lists the sender's watches when the
+// query is empty. Current results are marked as seen so only documents
+// indexed later are reported.
+func (s *Service) handleWatch(ctx context.Context, msg matrix.Message, query string) error {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return s.listWatches(ctx, msg)
+	}
+	if s.queryTooLong(query) {
+		return s.replyQueryTooLong(ctx, msg)
+	}
+
+	if s.cfg.MaxWatchesPerUser > 0 {
+		existing, err := s.watches.ListWatches(ctx, msg.Sender)
+		if err != nil {
+			return fmt.Errorf("list watches: %w", err)
+		}
+		if len(existing) >= s.cfg.MaxWatchesPerUser {
+			return s.reply(ctx, msg, s.text(msg, i18n.WatchLimit, i18n.Vars{
+				"max":     strconv.Itoa(s.cfg.MaxWatchesPerUser),
+				"command": s.unwatch,
+			}))
+		}
+	}
+
+	results, err := s.search(ctx, msg.RoomID, query, s.cfg.MaxResults)
+	if err != nil {
+		s.logf("watch search failed room=%s event=%s err=%v", msg.RoomID, msg.EventID, err)
+		return s.reply(ctx, msg, s.searchFailedText(msg, err))
+	}
+	watchID, err := s.watches.AddWatch(ctx, storage.Watch{
+		RoomID:    msg.RoomID,
+		EventID:   msg.EventID,
+		UserID:    msg.Sender,
+		Query:     query,
+		CreatedAt: s.now(),
+	})
+	if err != nil {
+		return fmt.Errorf("add watch: %w", err)
+	}
+	if err := s.watches.MarkWatchSeen(ctx, watchID, resultURLs(results)); err != nil {
+		s.logf("watch seed failed watch=%d err=%v", watchID, err)
+	}
+	s.logf("watch added id=%d room=%s sender=%s", watchID, msg.RoomID, msg.Sender)
+	return s.reply(ctx, msg, s.text(msg, i18n.WatchAdded, i18n.Vars{
+		"query":   query,
+		"id":      strconv.FormatInt(watchID, 10),
+		"command": s.unwatch,
+	}))
+}
+
+func (s *Service) listWatches(ctx context.Context, msg matrix.Message) error {
+	watches, err := s.watches.ListWatches(ctx, msg.Sender)
+	if err != nil {
+		return fmt.Errorf("list watches: %w", err)
+	}
+	if len(watches) == 0 {
+		return s.reply(ctx, msg, s.text(msg, i18n.WatchNone, i18n.Vars{"command": s.watch}))
+	}
+	var b strings.Builder
+	b.WriteString(s.text(msg, i18n.WatchList, nil))
+	for _, w := range watches {
+		fmt.Fprintf(&b, "\n#%d %s", w.ID, w.Query)
+	}
+	return s.reply(ctx, msg, b.String())
+}
+
+func (s *Service) handleUnwatch(ctx context.Context, msg matrix.Message, args string) error {
+	watchID, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(args), "#"), 10, 64)
+	if err != nil {
+		return s.reply(ctx, msg, s.text(msg, i18n.UnwatchUsage, i18n.Vars{"command": s.unwatch}))
+	}
+	removed, err := s.watches.RemoveWatch(ctx, msg.Sender, watchID)
+	if err != nil {
+		return fmt.Errorf("remove watch: %w", err)
+	}
+	vars := i18n.Vars{"id": strconv.FormatInt(watchID, 10)}
+	if !removed {
+		return s.reply(ctx, msg, s.text(msg, i18n.WatchNotFound, vars))
+	}
+	s.logf("watch removed id=%d sender=%s", watchID, msg.Sender)
+	return s.reply(ctx, msg, s.text(msg, i18n.WatchRemoved, vars))
+}
+
+// RunWatches checks every saved search each WatchInterval, or at each
+// WatchSchedule time, until ctx is done.
+func (s *Service) RunWatches(ctx context.Context) {
+	if s.watches == nil {
+		return
+	}
+	plan := s.cfg.WatchSchedule
+	if plan == nil {
+		if s.cfg.WatchInterval <= 0 {
+			return
+		}
+		plan = schedule.Every(s.cfg.WatchInterval)
+	}
+	for next := plan.Next(s.now()); !next.IsZero(); next = plan.Next(s.now()) {
+		if !schedule.Wait(ctx, next) {
+			return
+		}
+		if err := s.checkWatches(ctx); err != nil {
+			s.logf("watch check failed err=%v", err)
+		}
+	}
+}
+
+// checkWatches re-runs each saved search and posts results not reported
+// before in the thread of the message that created the watch.
+func (s *Service) checkWatches(ctx context.Context) error {
+	watches, err := s.watches.ListWatches(ctx, "")
+	if err != nil {
+		return fmt.Errorf("list watches: %w", err)
+	}
+	for _, w := range watches {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !s.ownsRoom(w.RoomID) {
+			continue
+		}
+		s.checkWatch(ctx, w)
+	}
+	return nil
+}
+
+func (s *Service) checkWatch(ctx context.Context, w storage.Watch) {
+	results, err := s.search(ctx, w.RoomID, w.Query, s.cfg.MaxResults)
+	if err != nil {
+		s.logf("watch search failed watch=%d err=%v", w.ID, err)
+		return
+	}
+	seen, err := s.watches.WatchSeen(ctx, w.ID, resultURLs(results))
+	if err != nil {
+		s.logf("watch lookup failed watch=%d err=%v", w.ID, err)
+		return
+	}
+	fresh := make([]hister.SearchResult, 0, len(results))
+	for _, r := range results {
+		if _, ok := seen[r.URL]; !ok {
+			fresh = append(fresh, r)
+		}
+	}
+	fresh = s.applyDeadLinks(ctx, w.RoomID, fresh)
+	if len(fresh) == 0 {
+		return
+	}
+
+	header := s.cfg.Messages.Text(string(w.RoomID), i18n.WatchMatches, i18n.Vars{"user": string(w.UserID), "query": w.Query})
+	body, formatted := s.formatResults(w.RoomID, header, fresh, s.spoilerFunc(w.RoomID))
+	err = s.replier.SendReply(ctx, s.relate(matrix.Reply{RoomID: w.RoomID, Body: body, FormattedBody: formatted, Kind: matrix.ReplyResults}, w.EventID, w.EventID))
+	if err != nil {
+		s.logf("watch notify failed watch=%d room=%s err=%v", w.ID, w.RoomID, err)
+		return
+	}
+	if err := s.watches.MarkWatchSeen(ctx, w.ID, resultURLs(fresh)); err != nil {
+		s.logf("watch mark seen failed watch=%d err=%v", w.ID, err)
+	}
+}
+
+func resultURLs(results []hister.SearchResult) []string {
+	urls := make([]string, 0, len(results))
+	for _, r := range results {
+		urls = append(urls, r.URL)
+	}
+	return urls
+}
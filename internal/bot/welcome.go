@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"maunium.net/go/mautrix/id"
+)
+
+const welcomeStatePrefix = "welcomed:"
+
+// welcome posts the welcome template in a room the bot joined, unless the
+// room was welcomed before.
+func (s *Service) welcome(ctx context.Context, roomID id.RoomID) {
+	if !s.cfg.WelcomeOnJoin {
+		return
+	}
+	key := welcomeStatePrefix + string(roomID)
+	if s.state != nil {
+		done, err := s.state.GetBotState(ctx, key)
+		if err != nil {
+			s.logf("welcome state lookup failed room=%s err=%v", roomID, err)
+			return
+		}
+		if done != "" {
+			return
+		}
+	}
+	text := s.renderWelcome(roomID)
+	if err := s.replier.SendReply(ctx, matrix.Reply{RoomID: roomID, Body: text, FormattedBody: matrix.FormatHTML(text, nil)}); err != nil {
+		s.logf("welcome failed room=%s err=%v", roomID, err)
+		return
+	}
+	if s.state != nil {
+		if err := s.state.PutBotState(ctx, key, s.now().UTC().Format(time.RFC3339)); err != nil {
+			s.logf("welcome state save failed room=%s err=%v", roomID, err)
+		}
+	}
+}
+
+// renderWelcome renders the configured welcome template, falling back to the
+// localized default welcome without one or if it fails.
+func (s *Service) renderWelcome(roomID id.RoomID) string {
+	w := render.Welcome{
+		SearchCommand:  s.cfg.SearchCommand,
+		IndexReaction:  s.cfg.IndexReaction,
+		IgnorePrefixes: s.cfg.IgnorePrefixes,
+	}
+	if s.history != nil && s.summarizer != nil {
+		w.CatchMeUpCommand = s.catchMeUp
+	}
+	text, err := s.cfg.Templates.Welcome(w)
+	if err != nil {
+		s.logf("welcome template failed err=%v", err)
+	}
+	if text == "" {
+		text = s.defaultWelcome(roomID, w)
+	}
+	return text
+}
+
+// defaultWelcome introduces the bot's commands and link indexing in roomID's
+// language.
+func (s *Service) defaultWelcome(roomID id.RoomID, w render.Welcome) string {
+	text := func(key i18n.Key, vars i18n.Vars) string {
+		return s.cfg.Messages.Text(string(roomID), key, vars)
+	}
+	lines := []string{
+		text(i18n.Welcome, nil),
+		text(i18n.WelcomeSearch, i18n.Vars{"command": w.SearchCommand}),
+	}
+	if w.CatchMeUpCommand != "" {
+		lines = append(lines, text(i18n.WelcomeCatchMeUp, i18n.Vars{"command": w.CatchMeUpCommand}))
+	}
+	indexing := text(i18n.WelcomeIndexing, nil)
+	if w.IndexReaction != "" {
+		indexing += " " + text(i18n.WelcomeIndexReaction, i18n.Vars{"reaction": w.IndexReaction})
+	}
+	lines = append(lines, indexing)
+	if len(w.IgnorePrefixes) > 0 {
+		lines = append(lines, text(i18n.WelcomeIgnore, i18n.Vars{"prefix": w.IgnorePrefixes[0]}))
+	}
+	return strings.Join(lines, "\n")
+}
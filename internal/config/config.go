@@ -3,62 +3,626 @@ package config
 import (
 	"errors"
 	"fmt"
+	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/logging"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultSyncTimeoutMS    = 30000
-	defaultSearchCommand    = "/search"
-	defaultMaxResults       = 5
-	defaultReplyMode        = "thread"
-	defaultMaxQueryLen      = 200
-	defaultAddPath          = "/add"
-	defaultSearchWSPath     = "/search"
-	defaultRequestTimeoutMS = 10000
-	defaultStateDBPath      = "/var/lib/matrix-bot/state.db"
-	defaultCryptoDBPath     = "/var/lib/matrix-bot/crypto.db"
+	defaultSyncTimeoutMS     = 30000
+	defaultSyncStallMS       = 300000
+	defaultColdStartMaxAgeMS = 600000
+	defaultHandlerWorkers    = 4
+	defaultHandlerQueueSize  = 64
+	defaultCatchUpMaxAgeMS   = 86400000
+	defaultSearchCommand     = "/search"
+	defaultMaxResults        = 5
+	defaultResultPages       = 3
+	defaultRecrawlAfterMS    = 24 * 60 * 60 * 1000
+	defaultReplyMode         = "thread"
+	defaultMessageType       = "notice"
+	defaultCommandTypos      = "suggest"
+	defaultMaxQueryLen       = 200
+	defaultIndexedReaction   = "✅"
+	defaultFailedReaction    = "❌"
+	defaultBulkConfirm       = "👍"
+	defaultBackfillDepth     = 500
+	defaultAddPath           = "/add"
+	defaultSearchWSPath      = "/search"
+	defaultDeletePath        = "/delete"
+	defaultBreakerFailures   = 5
+	defaultBreakerCooldownMS = 30000
+	defaultMeilisearchIndex  = "links"
+	defaultRequestTimeoutMS  = 10000
+	defaultStateDBPath       = "/var/lib/matrix-bot/state.db"
+	defaultCryptoDBPath      = "/var/lib/matrix-bot/crypto.db"
+	defaultMaxSyncAgeMS      = 120000
+	defaultShutdownTimeout   = 15000
+	defaultLogLevel          = "info"
+	defaultDigestMaxMessages = 200
+	defaultDigestAttachOver  = 4000
+	defaultReindexIntervalMS = 3600000
+	defaultReindexBatchSize  = 50
+	defaultWatchIntervalMS   = 900000
+	defaultWatchMaxPerUser   = 10
+	defaultDeadLinksInterval = 3600000
+	defaultDeadLinksBatch    = 50
+	defaultDeadLinksAction   = "demote"
+	defaultRetentionInterval = 3600000
+	defaultTopLinksSchedule  = "0 9 * * mon"
+	defaultTopLinksLimit     = 5
+	defaultCatchMeUpHistory  = "limit"
+	defaultIndexFailures     = "off"
+	defaultOutboxRetryMS     = 5000
+	defaultSummaryWindowMS   = 86400000
+	defaultSummaryMessages   = 40
+	defaultBucketGapMS       = 3600000
+	defaultBucketMessages    = 30
+	defaultOutboxMaxAgeMS    = 86400000
 )
 
 // Config is the root runtime configuration loaded from YAML.
 type Config struct {
-	Matrix  MatrixConfig  `yaml:"matrix"`
-	Bot     BotConfig     `yaml:"bot"`
-	Hister  HisterConfig  `yaml:"hister"`
-	HTTP    HTTPConfig    `yaml:"http"`
-	Storage StorageConfig `yaml:"storage"`
+	Matrix    MatrixConfig    `yaml:"matrix"`
+	Bot       BotConfig       `yaml:"bot"`
+	Hister    HisterConfig    `yaml:"hister"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	Storage   StorageConfig   `yaml:"storage"`
+	I18n      I18nConfig      `yaml:"i18n"`
+	Health    HealthConfig    `yaml:"health"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown"`
+	Debug     DebugConfig     `yaml:"debug"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Digest    DigestConfig    `yaml:"digest"`
+	Reindex   ReindexConfig   `yaml:"reindex"`
+	Watch     WatchConfig     `yaml:"watch"`
+	DeadLinks DeadLinksConfig `yaml:"dead_links"`
+	Retention RetentionConfig `yaml:"retention"`
+	TopLinks  TopLinksConfig  `yaml:"top_links"`
+	Templates TemplatesConfig `yaml:"templates"`
+	Outbox    OutboxConfig    `yaml:"outbox"`
+	Summary   SummaryConfig   `yaml:"summary"`
+	// Backend is the search index links are added to: "hister" (default)
+	// or "meilisearch".
+	Backend     string            `yaml:"backend"`
+	Meilisearch MeilisearchConfig `yaml:"meilisearch"`
+	// ErrorReporting forwards recovered panics to an external webhook.
+	ErrorReporting ErrorReportingConfig `yaml:"error_reporting"`
+	// Accounts are further Matrix accounts run from the same process, each
+	// with its own rooms, sharing storage, the Hister backend and the bot
+	// settings.
+	Accounts []MatrixConfig `yaml:"accounts"`
 }
 
 type MatrixConfig struct {
 	HomeserverURL  string   `yaml:"homeserver_url"`
 	UserID         string   `yaml:"user_id"`
 	AccessToken    string   `yaml:"access_token"`
+	Password       string   `yaml:"password"`
+	RefreshToken   string   `yaml:"refresh_token"`
 	DeviceID       string   `yaml:"device_id"`
 	BotDisplayName string   `yaml:"bot_display_name"`
 	SyncTimeoutMS  int      `yaml:"sync_timeout_ms"`
 	AllowedRoomIDs []string `yaml:"allowed_room_ids"`
+	// ColdStartMaxAgeMS skips messages older than this in the first sync
+	// without a saved sync token; 0 handles them all.
+	ColdStartMaxAgeMS int64 `yaml:"cold_start_max_age_ms"`
+	// SyncStallTimeoutMS restarts the sync loop after this long without a
+	// sync response; 0 disables the watchdog.
+	SyncStallTimeoutMS int64 `yaml:"sync_stall_timeout_ms"`
+	// ReadReceipts marks every handled message read.
+	ReadReceipts bool `yaml:"read_receipts"`
+	// AllowedUserIDs, when set, limits commands and link indexing to
+	// matching senders. Entries are user IDs; "*" matches any characters.
+	AllowedUserIDs []string `yaml:"allowed_user_ids"`
+	// BlockedUserIDs ignores matching senders, even allowed ones.
+	BlockedUserIDs []string `yaml:"blocked_user_ids"`
+	// OIDC refreshes RefreshToken at an OAuth 2.0 token endpoint instead of
+	// the Matrix /refresh endpoint.
+	OIDC OIDCConfig `yaml:"oidc"`
+	// Appservice runs the bot as an application service that receives
+	// events over HTTP instead of syncing.
+	Appservice AppserviceConfig `yaml:"appservice"`
+	// HandlerWorkers handles events on this many goroutines, so a slow
+	// handler does not hold up sync; 0 handles them in the sync loop.
+	HandlerWorkers int `yaml:"handler_workers"`
+	// HandlerQueueSize is how many events each worker queues before sync
+	// waits for it.
+	HandlerQueueSize int `yaml:"handler_queue_size"`
+	// CatchUpMaxAgeMS fetches and handles events a limited sync left out,
+	// such as those sent while the bot was offline, back to this age; 0
+	// disables catch-up.
+	CatchUpMaxAgeMS int64 `yaml:"catch_up_max_age_ms"`
+	// LeaveDisallowedRooms leaves joined rooms that are not in
+	// AllowedRoomIDs, posting LeaveMessage first when it is set.
+	LeaveDisallowedRooms bool   `yaml:"leave_disallowed_rooms"`
+	LeaveMessage         string `yaml:"leave_message"`
+	// AllowDMs accepts direct chat invites from users matching DMUserIDs, or
+	// from anyone when it is empty, and handles their direct chats like
+	// allowed rooms.
+	AllowDMs  bool     `yaml:"allow_dms"`
+	DMUserIDs []string `yaml:"dm_user_ids"`
+}
+
+// AppserviceConfig enables appservice mode when Registration is set. The
+// as_token in the registration file replaces access_token.
+type AppserviceConfig struct {
+	Registration string `yaml:"registration"`
+	ListenAddr   string `yaml:"listen_addr"`
+}
+
+// OIDCConfig refreshes access tokens at an OAuth 2.0 token endpoint, for
+// homeservers that delegate authentication to OIDC (MSC3861).
+type OIDCConfig struct {
+	TokenEndpoint string `yaml:"token_endpoint"`
+	ClientID      string `yaml:"client_id"`
 }
 
 type BotConfig struct {
 	SearchCommand string `yaml:"search_command"`
-	MaxResults    int    `yaml:"max_results"`
-	ReplyMode     string `yaml:"reply_mode"`
-	MaxQueryLen   int    `yaml:"max_query_len"`
+	// CommandPrefix replaces the leading "/" of every command, including
+	// SearchCommand, e.g. "!" for clients that intercept slash commands.
+	CommandPrefix string `yaml:"command_prefix"`
+	// CommandTypos handles near-miss commands: "suggest", "execute" or "off".
+	CommandTypos string          `yaml:"command_typos"`
+	MaxResults   int             `yaml:"max_results"`
+	ReplyMode    string          `yaml:"reply_mode"`
+	MaxQueryLen  int             `yaml:"max_query_len"`
+	Cooldowns    CooldownsConfig `yaml:"cooldowns"`
+	// SearchCacheTTLMS is how long a room's results are reused for a repeated
+	// query. Zero disables the cache.
+	SearchCacheTTLMS int `yaml:"search_cache_ttl_ms"`
+	// Admins are Matrix user IDs allowed to run admin commands (/broadcast).
+	Admins []string `yaml:"admins"`
+	// BroadcastRoomLimit throttles /broadcast announcements per room.
+	BroadcastRoomLimit RateLimitConfig `yaml:"broadcast_room_limit"`
+	IgnorePrefixes     []string        `yaml:"ignore_prefixes"`
+	CustomTriggers     []CustomTrigger `yaml:"custom_triggers"`
+	// IndexReaction enables indexing a message's URLs by reacting to it with
+	// this key. IndexedReaction is the bot's confirmation reaction.
+	IndexReaction   string `yaml:"index_reaction"`
+	IndexedReaction string `yaml:"indexed_reaction"`
+	// AckReactions reacts to messages whose links were indexed automatically
+	// with IndexedReaction, or FailedReaction for links that failed.
+	AckReactions   bool   `yaml:"ack_reactions"`
+	FailedReaction string `yaml:"failed_reaction"`
+	// MaxAutoIndexURLs is the most URLs one message may index without the
+	// sender confirming via BulkIndexConfirmReaction. Zero disables the limit.
+	MaxAutoIndexURLs int `yaml:"max_auto_index_urls"`
+	// RecrawlAfterMS is how long a shared URL counts as indexed; sharing it
+	// again sooner does not fetch and add it again. Zero re-indexes every
+	// share.
+	RecrawlAfterMS           int64  `yaml:"recrawl_after_ms"`
+	BulkIndexConfirmReaction string `yaml:"bulk_index_confirm_reaction"`
+	// URLPreviews replies to shared URLs with their title and description,
+	// confirming that they were indexed.
+	URLPreviews bool `yaml:"url_previews"`
+	// IndexSources sends the room, sender, event and time a link was shared
+	// with its document and shows them under search results.
+	IndexSources bool `yaml:"index_sources"`
+	// IndexFiles indexes uploaded text, markdown, HTML and PDF files, and the
+	// captions of other uploads.
+	IndexFiles bool `yaml:"index_files"`
+	// IndexSenders restricts whose links and files are indexed.
+	IndexSenders SenderFilterConfig `yaml:"index_senders"`
+	// IgnoreBots keeps other bots' messages from triggering or being indexed.
+	IgnoreBots IgnoreBotsConfig `yaml:"ignore_bots"`
+	// IndexFailureNotices tells sharers why their links failed to index:
+	// "thread" replies to the message, "direct" DMs the sharer, "off" only logs.
+	IndexFailureNotices string           `yaml:"index_failure_notices"`
+	Backfill            BackfillConfig   `yaml:"backfill"`
+	CatchMeUp           CatchMeUpConfig  `yaml:"catchmeup"`
+	Spoilers            SpoilersConfig   `yaml:"spoilers"`
+	ChatSearch          ChatSearchConfig `yaml:"chat_search"`
+	// WelcomeOnJoin posts templates.welcome once in each allowed room the bot
+	// joins.
+	WelcomeOnJoin bool `yaml:"welcome_on_join"`
+	// MessageType is the msgtype of the bot's messages, "notice" or "text".
+	// MessageTypes overrides it for the reply kinds "results" (search and
+	// watch results), "summaries" (/catchmeup) and "posts" (digests and
+	// reports).
+	MessageType  string            `yaml:"message_type"`
+	MessageTypes map[string]string `yaml:"message_types"`
+	// ResultPages is how many pages of max_results results a search keeps;
+	// /next and /prev edit the results message to show the other pages. 1
+	// turns paging off.
+	ResultPages int `yaml:"result_pages"`
+	// ResultReactions adds ⬅️/➡️ reactions to results messages for paging,
+	// and 🗑️ for admins to delete the top result from the index.
+	ResultReactions bool `yaml:"result_reactions"`
+}
+
+// SenderFilterConfig lists Matrix user IDs or glob patterns over them, such
+// as "@*:example.org". Deny wins over Allow; an empty Allow allows everyone
+// not denied.
+type SenderFilterConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// IgnoreBotsConfig recognizes other bots in a room so the bot never answers
+// them or indexes their links. Notices treats every m.notice sender as a bot,
+// KnownBots matches user IDs like "@github-bot:example.org", and Users lists
+// further user IDs or glob patterns over them.
+type IgnoreBotsConfig struct {
+	Notices   bool     `yaml:"notices"`
+	KnownBots bool     `yaml:"known_bots"`
+	Users     []string `yaml:"users"`
+}
+
+// ChatSearchConfig indexes the text messages of RoomIDs so members can find
+// them again with "/search chat <terms>". It needs a Hister backend that
+// accepts submitted content.
+type ChatSearchConfig struct {
+	RoomIDs []string `yaml:"room_ids"`
+}
+
+// SpoilersConfig hides search result snippets behind Matrix spoilers. Every
+// snippet is hidden in RoomIDs; elsewhere only those from SensitiveDomains
+// (or their subdomains) are.
+type SpoilersConfig struct {
+	RoomIDs          []string `yaml:"room_ids"`
+	SensitiveDomains []string `yaml:"sensitive_domains"`
+}
+
+// BackfillConfig controls indexing links from room history. Admins can always
+// run /backfill; OnJoin also backfills each allowed room the bot joins.
+type BackfillConfig struct {
+	OnJoin bool `yaml:"on_join"`
+	// Depth is how many past messages a backfill reads.
+	Depth int `yaml:"depth"`
+}
+
+// CustomTrigger maps a regex to an action: "search" or "index" use the first
+// capture group, "reply" sends Reply with $1-style group expansion.
+type CustomTrigger struct {
+	Pattern string `yaml:"pattern"`
+	Action  string `yaml:"action"`
+	Reply   string `yaml:"reply"`
+}
+
+// CooldownsConfig holds per-user rate limits for commands. A zero limit disables it.
+type CooldownsConfig struct {
+	Search    RateLimitConfig `yaml:"search"`
+	CatchMeUp RateLimitConfig `yaml:"catchmeup"`
+}
+
+// CatchMeUpConfig controls /catchmeup in rooms whose history visibility hides
+// messages sent before the requester joined. History is "limit", "refuse" or
+// "off"; RoomHistory overrides it per room.
+type CatchMeUpConfig struct {
+	History     string            `yaml:"history"`
+	RoomHistory map[string]string `yaml:"room_history"`
+}
+
+// RateLimitConfig allows at most Max uses per user within a sliding window.
+type RateLimitConfig struct {
+	Max      int `yaml:"max"`
+	WindowMS int `yaml:"window_ms"`
+}
+
+func (r RateLimitConfig) Enabled() bool {
+	return r.Max > 0
+}
+
+func (r RateLimitConfig) Window() time.Duration {
+	return time.Duration(r.WindowMS) * time.Millisecond
 }
 
 type HisterConfig struct {
 	BaseURL      string `yaml:"base_url"`
 	AddPath      string `yaml:"add_path"`
 	SearchWSPath string `yaml:"search_ws_path"`
+	// DeletePath is where documents are deleted, for redactions, retention,
+	// /forget and the delete reaction.
+	DeletePath string `yaml:"delete_path"`
+	// HealthPath, when set, must answer 2xx for Hister to count as healthy at
+	// startup and in readiness checks; otherwise any non-5xx answer at the
+	// base URL does.
+	HealthPath string `yaml:"health_path"`
+	// BatchAddPath, when set, is a Hister endpoint taking a JSON array of
+	// documents; backfills submit up to BatchSize (default 50) at a time.
+	BatchAddPath string `yaml:"batch_add_path"`
+	BatchSize    int    `yaml:"batch_size"`
+	// SearchTransport is "ws" (default), "http" or "auto"; auto searches
+	// over HTTP at SearchHTTPPath (default SearchWSPath) while the websocket
+	// cannot be dialed.
+	SearchTransport string `yaml:"search_transport"`
+	SearchHTTPPath  string `yaml:"search_http_path"`
+	// ReuseConnections keeps up to MaxIdleConns search websockets open for
+	// IdleConnTimeoutMS between searches. Zero values use the client
+	// defaults of 2 connections and 90 seconds.
+	ReuseConnections  bool `yaml:"reuse_connections"`
+	MaxIdleConns      int  `yaml:"max_idle_conns"`
+	IdleConnTimeoutMS int  `yaml:"idle_conn_timeout_ms"`
+	// Breaker fails Hister requests fast after repeated outages.
+	Breaker BreakerConfig `yaml:"breaker"`
+	// PingIntervalMS is how often a pending search pings the websocket; a
+	// pong later than PongTimeoutMS makes it reconnect and re-send. Zero uses
+	// the client defaults and a negative interval disables pings.
+	PingIntervalMS int `yaml:"ping_interval_ms"`
+	PongTimeoutMS  int `yaml:"pong_timeout_ms"`
+	// GzipMinBytes compresses /add request bodies of at least this size.
+	// Zero disables compression; servers answering 415 get plain bodies.
+	GzipMinBytes int              `yaml:"gzip_min_bytes"`
+	SearchAuth   SearchAuthConfig `yaml:"search_auth"`
+	// Namespaces keeps each room's documents in its own Hister namespace.
+	Namespaces NamespacesConfig `yaml:"namespaces"`
+}
+
+// NamespacesConfig names the Hister namespace each room adds to and searches.
+// PerRoom uses the room ID; Rooms overrides it per room, so several rooms can
+// share one namespace. Other rooms use Hister's default namespace.
+type NamespacesConfig struct {
+	PerRoom bool              `yaml:"per_room"`
+	Rooms   map[string]string `yaml:"rooms"`
+}
+
+func (c NamespacesConfig) Enabled() bool {
+	return c.PerRoom || len(c.Rooms) > 0
+}
+
+// For returns the namespace of roomID; empty is the default namespace.
+func (c NamespacesConfig) For(roomID string) string {
+	if name, ok := c.Rooms[roomID]; ok {
+		return strings.TrimSpace(name)
+	}
+	if c.PerRoom {
+		return roomID
+	}
+	return ""
+}
+
+const (
+	BackendHister      = "hister"
+	BackendMeilisearch = "meilisearch"
+)
+
+// MeilisearchConfig is the Meilisearch index used with backend
+// "meilisearch". An empty APIKey falls back to $MEILISEARCH_API_KEY.
+type MeilisearchConfig struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+	// Index is the uid of the index documents are kept in.
+	Index string `yaml:"index"`
+}
+
+// Key returns the configured API key or $MEILISEARCH_API_KEY.
+func (c MeilisearchConfig) Key() string {
+	if key := strings.TrimSpace(c.APIKey); key != "" {
+		return key
+	}
+	return strings.TrimSpace(os.Getenv("MEILISEARCH_API_KEY"))
+}
+
+// BreakerConfig opens the circuit after Failures Hister requests in a row
+// hit an outage: requests then fail at once for CooldownMS, after which a
+// ping probes whether Hister is back. Zero Failures disables it.
+type BreakerConfig struct {
+	Failures   int `yaml:"failures"`
+	CooldownMS int `yaml:"cooldown_ms"`
+}
+
+// SearchAuthConfig authenticates the search websocket handshake. The token
+// is sent as a bearer Authorization header, as the raw value of Header, or
+// as the QueryParam query parameter for reverse proxies that strip headers
+// from websocket upgrades. An empty Token falls back to $HISTER_SEARCH_TOKEN.
+type SearchAuthConfig struct {
+	Token      string `yaml:"token"`
+	Header     string `yaml:"header"`
+	QueryParam string `yaml:"query_param"`
+}
+
+// SearchToken returns the configured search token or $HISTER_SEARCH_TOKEN.
+func (c SearchAuthConfig) SearchToken() string {
+	if token := strings.TrimSpace(c.Token); token != "" {
+		return token
+	}
+	return strings.TrimSpace(os.Getenv("HISTER_SEARCH_TOKEN"))
 }
 
 type HTTPConfig struct {
 	RequestTimeoutMS int `yaml:"request_timeout_ms"`
+	// UserAgent replaces the User-Agent sent when fetching shared pages.
+	UserAgent string `yaml:"user_agent"`
+	// SiteHeaders maps a domain to extra headers, such as Cookie or
+	// Accept-Language, sent when fetching pages from it or its subdomains.
+	SiteHeaders map[string]map[string]string `yaml:"site_headers"`
+}
+
+// PageHeaders returns the headers to send when fetching shared pages.
+func (c HTTPConfig) PageHeaders() extractor.Headers {
+	headers := extractor.Headers{UserAgent: strings.TrimSpace(c.UserAgent)}
+	if len(c.SiteHeaders) > 0 {
+		headers.Sites = make(map[string]http.Header, len(c.SiteHeaders))
+		for domain, values := range c.SiteHeaders {
+			site := make(http.Header, len(values))
+			for key, value := range values {
+				site.Set(key, value)
+			}
+			headers.Sites[domain] = site
+		}
+	}
+	return headers
+}
+
+// I18nConfig selects the language of bot responses. BundlesDir may hold
+// <lang>.yaml files that add languages or override built-in responses.
+type I18nConfig struct {
+	DefaultLanguage string            `yaml:"default_language"`
+	RoomLanguages   map[string]string `yaml:"room_languages"`
+	BundlesDir      string            `yaml:"bundles_dir"`
+}
+
+// HealthConfig enables the /healthz and /readyz HTTP endpoints. An empty
+// ListenAddr disables them.
+type HealthConfig struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// MaxSyncAgeMS is how long Matrix sync may go without a response before
+	// the bot is reported unhealthy.
+	MaxSyncAgeMS int `yaml:"max_sync_age_ms"`
+}
+
+// ShutdownConfig bounds how long the bot waits for in-flight messages after
+// SIGINT/SIGTERM before closing storage anyway.
+type ShutdownConfig struct {
+	TimeoutMS int `yaml:"timeout_ms"`
+}
+
+// DebugConfig holds operator-only diagnostics. PprofAddr, when set, serves
+// net/http/pprof and must be a loopback address.
+type DebugConfig struct {
+	PprofAddr string `yaml:"pprof_addr"`
+}
+
+// LoggingConfig sets log verbosity: Level for every component, with
+// per-component overrides in Components (bot, matrix, hister, extractor, llm,
+// storage).
+type LoggingConfig struct {
+	Level      string            `yaml:"level"`
+	Components map[string]string `yaml:"components"`
+}
+
+// TemplatesConfig holds text/template templates for replies. Result renders
+// one search result (fields .Index, .Title, .URL, .Snippet and .Score);
+// Summary renders /catchmeup and digest summaries (.Summary and .Messages);
+// Welcome renders the bot.welcome_on_join message (.SearchCommand,
+// .CatchMeUpCommand, .IndexReaction and .IgnorePrefixes). Empty templates
+// keep the built-in format.
+type TemplatesConfig struct {
+	Result  string `yaml:"result"`
+	Summary string `yaml:"summary"`
+	Welcome string `yaml:"welcome"`
+}
+
+// DigestConfig posts a daily LLM summary of each listed room's last 24 hours.
+// Timezone is an IANA name; empty means the host's local time.
+type DigestConfig struct {
+	Timezone    string `yaml:"timezone"`
+	MaxMessages int    `yaml:"max_messages"`
+	// AttachOverChars uploads digests longer than this many characters as
+	// a markdown file with a short teaser. Zero disables it.
+	AttachOverChars int                `yaml:"attach_over_chars"`
+	Rooms           []DigestRoomConfig `yaml:"rooms"`
+}
+
+// DigestRoomConfig schedules one room's digest daily at Time (24-hour HH:MM)
+// or at the times of Schedule, a cron expression. Pin pins each digest,
+// replacing the previous one.
+type DigestRoomConfig struct {
+	RoomID   string `yaml:"room_id"`
+	Time     string `yaml:"time"`
+	Schedule string `yaml:"schedule"`
+	Pin      bool   `yaml:"pin"`
+}
+
+// ReindexConfig periodically re-fetches indexed URLs not checked within
+// MaxAgeMS and re-submits those whose content changed. Zero MaxAgeMS
+// disables it.
+type ReindexConfig struct {
+	MaxAgeMS   int64 `yaml:"max_age_ms"`
+	IntervalMS int   `yaml:"interval_ms"`
+	// Schedule is a cron expression replacing IntervalMS.
+	Schedule  string `yaml:"schedule"`
+	BatchSize int    `yaml:"batch_size"`
+}
+
+// WatchConfig controls saved search alerts (/watch). MaxPerUser zero means
+// no limit.
+type WatchConfig struct {
+	IntervalMS int `yaml:"interval_ms"`
+	// Schedule is a cron expression replacing IntervalMS.
+	Schedule   string `yaml:"schedule"`
+	MaxPerUser int    `yaml:"max_per_user"`
+}
+
+// DeadLinksConfig periodically checks indexed URLs not checked within
+// MaxAgeMS and records the ones that return 404 or 410 or whose host no
+// longer resolves. Zero MaxAgeMS disables it. Action is "demote" or "hide"
+// and selects how dead links show up in search results; ReportRoomID, when
+// set, receives a notice listing newly dead links.
+type DeadLinksConfig struct {
+	MaxAgeMS   int64 `yaml:"max_age_ms"`
+	IntervalMS int   `yaml:"interval_ms"`
+	// Schedule is a cron expression replacing IntervalMS.
+	Schedule     string `yaml:"schedule"`
+	BatchSize    int    `yaml:"batch_size"`
+	Action       string `yaml:"action"`
+	ReportRoomID string `yaml:"report_room_id"`
+}
+
+// RetentionConfig forgets what the bot indexed from each room in Rooms once
+// it is older than the room's MaxAgeMS: links, chat messages and summaries
+// are deleted from the search index and the local tables every IntervalMS.
+type RetentionConfig struct {
+	IntervalMS int `yaml:"interval_ms"`
+	// Schedule is a cron expression replacing IntervalMS.
+	Schedule string                   `yaml:"schedule"`
+	Rooms    map[string]RoomRetention `yaml:"rooms"`
+}
+
+type RoomRetention struct {
+	MaxAgeMS int64 `yaml:"max_age_ms"`
+}
+
+// TopLinksConfig posts a weekly report of the most shared and most searched
+// links in each of RoomIDs at the times of Schedule, interpreted in Timezone
+// (an IANA name; empty means the host's local time). Intro asks the LLM for
+// an intro paragraph.
+type TopLinksConfig struct {
+	RoomIDs  []string `yaml:"room_ids"`
+	Schedule string   `yaml:"schedule"`
+	Timezone string   `yaml:"timezone"`
+	Limit    int      `yaml:"limit"`
+	Intro    bool     `yaml:"intro"`
+}
+
+// SummaryConfig tunes LLM summaries. WindowMS and MaxMessages bound the
+// history /catchmeup reads; BucketGapMS and BucketMaxMessages split the
+// history of both /catchmeup and digests into the chunks sent to the LLM.
+// Rooms overrides them per room; zero fields there keep the global value.
+// Index adds every /catchmeup and digest summary to the search index.
+type SummaryConfig struct {
+	SummaryLimits `yaml:",inline"`
+	Rooms         map[string]SummaryLimits `yaml:"rooms"`
+	Index         bool                     `yaml:"index"`
+}
+
+type SummaryLimits struct {
+	WindowMS          int64 `yaml:"window_ms"`
+	MaxMessages       int   `yaml:"max_messages"`
+	BucketGapMS       int64 `yaml:"bucket_gap_ms"`
+	BucketMaxMessages int   `yaml:"bucket_max_messages"`
+}
+
+// OutboxConfig controls the queue of outgoing notices. Notices that could not
+// be sent are retried every RetryIntervalMS, backing off per message, until
+// they are MaxAgeMS old.
+type OutboxConfig struct {
+	RetryIntervalMS int   `yaml:"retry_interval_ms"`
+	MaxAgeMS        int64 `yaml:"max_age_ms"`
+}
+
+// ErrorReportingConfig posts recovered panics as JSON to WebhookURL, e.g. an
+// error tracker's ingest endpoint or a chat webhook relay.
+type ErrorReportingConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
 }
 
 type StorageConfig struct {
@@ -69,17 +633,39 @@ type StorageConfig struct {
 func DefaultConfig() Config {
 	return Config{
 		Matrix: MatrixConfig{
-			SyncTimeoutMS: defaultSyncTimeoutMS,
+			SyncTimeoutMS:      defaultSyncTimeoutMS,
+			SyncStallTimeoutMS: defaultSyncStallMS,
+			ColdStartMaxAgeMS:  defaultColdStartMaxAgeMS,
+			HandlerWorkers:     defaultHandlerWorkers,
+			HandlerQueueSize:   defaultHandlerQueueSize,
+			CatchUpMaxAgeMS:    defaultCatchUpMaxAgeMS,
 		},
 		Bot: BotConfig{
-			SearchCommand: defaultSearchCommand,
-			MaxResults:    defaultMaxResults,
-			ReplyMode:     defaultReplyMode,
-			MaxQueryLen:   defaultMaxQueryLen,
+			SearchCommand:            defaultSearchCommand,
+			CommandPrefix:            triggers.DefaultCommandPrefix,
+			CommandTypos:             defaultCommandTypos,
+			MaxResults:               defaultMaxResults,
+			ReplyMode:                defaultReplyMode,
+			MaxQueryLen:              defaultMaxQueryLen,
+			IndexedReaction:          defaultIndexedReaction,
+			FailedReaction:           defaultFailedReaction,
+			BulkIndexConfirmReaction: defaultBulkConfirm,
+			Backfill:                 BackfillConfig{Depth: defaultBackfillDepth},
+			IgnoreBots:               IgnoreBotsConfig{Notices: true},
+			CatchMeUp:                CatchMeUpConfig{History: defaultCatchMeUpHistory},
+			IndexFailureNotices:      defaultIndexFailures,
+			MessageType:              defaultMessageType,
+			ResultPages:              defaultResultPages,
+			RecrawlAfterMS:           defaultRecrawlAfterMS,
 		},
+		Backend:     BackendHister,
+		Meilisearch: MeilisearchConfig{Index: defaultMeilisearchIndex},
 		Hister: HisterConfig{
-			AddPath:      defaultAddPath,
-			SearchWSPath: defaultSearchWSPath,
+			AddPath:         defaultAddPath,
+			SearchWSPath:    defaultSearchWSPath,
+			DeletePath:      defaultDeletePath,
+			SearchTransport: "ws",
+			Breaker:         BreakerConfig{Failures: defaultBreakerFailures, CooldownMS: defaultBreakerCooldownMS},
 		},
 		HTTP: HTTPConfig{
 			RequestTimeoutMS: defaultRequestTimeoutMS,
@@ -88,6 +674,52 @@ func DefaultConfig() Config {
 			StateDBPath:  defaultStateDBPath,
 			CryptoDBPath: defaultCryptoDBPath,
 		},
+		I18n: I18nConfig{
+			DefaultLanguage: i18n.DefaultLanguage,
+		},
+		Health: HealthConfig{
+			MaxSyncAgeMS: defaultMaxSyncAgeMS,
+		},
+		Shutdown: ShutdownConfig{
+			TimeoutMS: defaultShutdownTimeout,
+		},
+		Logging: LoggingConfig{
+			Level: defaultLogLevel,
+		},
+		Digest: DigestConfig{
+			MaxMessages:     defaultDigestMaxMessages,
+			AttachOverChars: defaultDigestAttachOver,
+		},
+		Reindex: ReindexConfig{
+			IntervalMS: defaultReindexIntervalMS,
+			BatchSize:  defaultReindexBatchSize,
+		},
+		Watch: WatchConfig{
+			IntervalMS: defaultWatchIntervalMS,
+			MaxPerUser: defaultWatchMaxPerUser,
+		},
+		DeadLinks: DeadLinksConfig{
+			IntervalMS: defaultDeadLinksInterval,
+			BatchSize:  defaultDeadLinksBatch,
+			Action:     defaultDeadLinksAction,
+		},
+		Retention: RetentionConfig{
+			IntervalMS: defaultRetentionInterval,
+		},
+		TopLinks: TopLinksConfig{
+			Schedule: defaultTopLinksSchedule,
+			Limit:    defaultTopLinksLimit,
+		},
+		Summary: SummaryConfig{SummaryLimits: SummaryLimits{
+			WindowMS:          defaultSummaryWindowMS,
+			MaxMessages:       defaultSummaryMessages,
+			BucketGapMS:       defaultBucketGapMS,
+			BucketMaxMessages: defaultBucketMessages,
+		}},
+		Outbox: OutboxConfig{
+			RetryIntervalMS: defaultOutboxRetryMS,
+			MaxAgeMS:        defaultOutboxMaxAgeMS,
+		},
 	}
 }
 
@@ -105,6 +737,7 @@ func Load(path string) (*Config, error) {
 	base := filepath.Dir(path)
 	cfg.Storage.StateDBPath = resolvePath(base, cfg.Storage.StateDBPath)
 	cfg.Storage.CryptoDBPath = resolvePath(base, cfg.Storage.CryptoDBPath)
+	cfg.I18n.BundlesDir = resolvePath(base, cfg.I18n.BundlesDir)
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
@@ -130,52 +763,146 @@ func (c *Config) Validate() error {
 
 	var validationErrs []string
 
-	if err := validateHTTPURL(c.Matrix.HomeserverURL); err != nil {
-		validationErrs = append(validationErrs, fmt.Sprintf("matrix.homeserver_url: %v", err))
-	}
-	if strings.TrimSpace(c.Matrix.UserID) == "" {
-		validationErrs = append(validationErrs, "matrix.user_id is required")
-	}
-	if strings.TrimSpace(c.Matrix.AccessToken) == "" {
-		validationErrs = append(validationErrs, "matrix.access_token is required")
-	}
-	if strings.TrimSpace(c.Matrix.BotDisplayName) == "" {
-		validationErrs = append(validationErrs, "matrix.bot_display_name is required")
-	}
-	if c.Matrix.SyncTimeoutMS <= 0 {
-		validationErrs = append(validationErrs, "matrix.sync_timeout_ms must be > 0")
-	}
-	if len(c.Matrix.AllowedRoomIDs) == 0 {
-		validationErrs = append(validationErrs, "matrix.allowed_room_ids must include at least one room")
-	}
-	for i, roomID := range c.Matrix.AllowedRoomIDs {
-		roomID = strings.TrimSpace(roomID)
-		if roomID == "" {
-			validationErrs = append(validationErrs, fmt.Sprintf("matrix.allowed_room_ids[%d] is empty", i))
-			continue
-		}
-		if !strings.HasPrefix(roomID, "!") {
-			validationErrs = append(validationErrs, fmt.Sprintf("matrix.allowed_room_ids[%d] must start with '!'", i))
+	matrixErrs, allowedRooms := validateMatrix("matrix", c.Matrix)
+	validationErrs = append(validationErrs, matrixErrs...)
+	userIDs := map[string]string{strings.TrimSpace(c.Matrix.UserID): "matrix"}
+	for i, account := range c.Accounts {
+		field := fmt.Sprintf("accounts[%d]", i)
+		accountErrs, _ := validateMatrix(field, account)
+		validationErrs = append(validationErrs, accountErrs...)
+		userID := strings.TrimSpace(account.UserID)
+		if other, ok := userIDs[userID]; ok && userID != "" {
+			validationErrs = append(validationErrs, fmt.Sprintf("%s.user_id %s is already used by %s", field, userID, other))
 		}
+		userIDs[userID] = field
 	}
 
 	if strings.TrimSpace(c.Bot.SearchCommand) == "" {
 		validationErrs = append(validationErrs, "bot.search_command is required")
 	}
+	if err := validateCommandPrefix(c.Bot.CommandPrefix); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("bot.command_prefix: %v", err))
+	}
+	switch c.Bot.CommandTypos {
+	case "suggest", "execute", "off":
+	default:
+		validationErrs = append(validationErrs, "bot.command_typos must be 'suggest', 'execute' or 'off'")
+	}
 	if c.Bot.MaxResults <= 0 {
 		validationErrs = append(validationErrs, "bot.max_results must be > 0")
 	}
-	if strings.TrimSpace(c.Bot.ReplyMode) == "" {
+	if c.Bot.ResultPages <= 0 {
+		validationErrs = append(validationErrs, "bot.result_pages must be > 0")
+	}
+	switch c.Bot.ReplyMode {
+	case "thread", "reply", "room":
+	case "":
 		validationErrs = append(validationErrs, "bot.reply_mode is required")
-	} else if c.Bot.ReplyMode != "thread" {
-		validationErrs = append(validationErrs, "bot.reply_mode must be 'thread' for this PoC")
+	default:
+		validationErrs = append(validationErrs, "bot.reply_mode must be 'thread', 'reply' or 'room'")
 	}
 	if c.Bot.MaxQueryLen <= 0 {
 		validationErrs = append(validationErrs, "bot.max_query_len must be > 0")
 	}
+	validationErrs = append(validationErrs, validateRateLimit("bot.cooldowns.search", c.Bot.Cooldowns.Search)...)
+	validationErrs = append(validationErrs, validateRateLimit("bot.cooldowns.catchmeup", c.Bot.Cooldowns.CatchMeUp)...)
+	validationErrs = append(validationErrs, validateRateLimit("bot.broadcast_room_limit", c.Bot.BroadcastRoomLimit)...)
+	for i, admin := range c.Bot.Admins {
+		if !strings.HasPrefix(strings.TrimSpace(admin), "@") {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.admins[%d] must be a Matrix user ID starting with '@'", i))
+		}
+	}
+	for i, prefix := range c.Bot.IgnorePrefixes {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if prefix == "" {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.ignore_prefixes[%d] is empty", i))
+			continue
+		}
+		if strings.HasPrefix(c.Bot.CommandPrefix, prefix) {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.ignore_prefixes[%d] would ignore every command using bot.command_prefix", i))
+		}
+	}
+	if c.Bot.SearchCacheTTLMS < 0 {
+		validationErrs = append(validationErrs, "bot.search_cache_ttl_ms must be >= 0")
+	}
+	if c.Bot.MaxAutoIndexURLs < 0 {
+		validationErrs = append(validationErrs, "bot.max_auto_index_urls must be >= 0")
+	}
+	if c.Bot.RecrawlAfterMS < 0 {
+		validationErrs = append(validationErrs, "bot.recrawl_after_ms must be >= 0")
+	}
+	if c.Bot.Backfill.Depth < 0 {
+		validationErrs = append(validationErrs, "bot.backfill.depth must be >= 0")
+	}
+	switch c.Bot.IndexFailureNotices {
+	case "thread", "direct", "off":
+	default:
+		validationErrs = append(validationErrs, "bot.index_failure_notices must be 'thread', 'direct' or 'off'")
+	}
+	if !validMessageType(c.Bot.MessageType) {
+		validationErrs = append(validationErrs, "bot.message_type must be 'notice' or 'text'")
+	}
+	for kind, msgType := range c.Bot.MessageTypes {
+		switch kind {
+		case "results", "summaries", "posts":
+		default:
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.message_types key %q must be 'results', 'summaries' or 'posts'", kind))
+		}
+		if !validMessageType(msgType) {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.message_types[%s] must be 'notice' or 'text'", kind))
+		}
+	}
+	if !validHistoryMode(c.Bot.CatchMeUp.History) {
+		validationErrs = append(validationErrs, "bot.catchmeup.history must be 'limit', 'refuse' or 'off'")
+	}
+	for roomID, mode := range c.Bot.CatchMeUp.RoomHistory {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.catchmeup.room_history key %q is not in matrix.allowed_room_ids", roomID))
+		}
+		if !validHistoryMode(mode) {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.catchmeup.room_history[%s] must be 'limit', 'refuse' or 'off'", roomID))
+		}
+	}
+	for i, roomID := range c.Bot.Spoilers.RoomIDs {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.spoilers.room_ids[%d] %q is not in matrix.allowed_room_ids", i, roomID))
+		}
+	}
+	validationErrs = append(validationErrs, validateSenderPatterns("bot.index_senders.allow", c.Bot.IndexSenders.Allow)...)
+	validationErrs = append(validationErrs, validateSenderPatterns("bot.index_senders.deny", c.Bot.IndexSenders.Deny)...)
+	validationErrs = append(validationErrs, validateSenderPatterns("bot.ignore_bots.users", c.Bot.IgnoreBots.Users)...)
+	for i, roomID := range c.Bot.ChatSearch.RoomIDs {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.chat_search.room_ids[%d] %q is not in matrix.allowed_room_ids", i, roomID))
+		}
+	}
+	for i, domain := range c.Bot.Spoilers.SensitiveDomains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" || strings.ContainsAny(domain, "/: ") {
+			validationErrs = append(validationErrs, fmt.Sprintf("bot.spoilers.sensitive_domains[%d] must be a bare domain like example.com", i))
+		}
+	}
+	if _, err := c.Bot.CompileCustomTriggers(); err != nil {
+		validationErrs = append(validationErrs, err.Error())
+	}
 
-	if err := validateHTTPURL(c.Hister.BaseURL); err != nil {
-		validationErrs = append(validationErrs, fmt.Sprintf("hister.base_url: %v", err))
+	switch c.Backend {
+	case BackendHister:
+		if err := validateHTTPURL(c.Hister.BaseURL); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("hister.base_url: %v", err))
+		}
+	case BackendMeilisearch:
+		if err := validateHTTPURL(c.Meilisearch.URL); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("meilisearch.url: %v", err))
+		}
+		if !validIndexUID(c.Meilisearch.Index) {
+			validationErrs = append(validationErrs, fmt.Sprintf("meilisearch.index %q may only hold letters, digits, '-' and '_'", c.Meilisearch.Index))
+		}
+		if c.Hister.Namespaces.Enabled() {
+			validationErrs = append(validationErrs, "hister.namespaces is not supported with backend meilisearch")
+		}
+	default:
+		validationErrs = append(validationErrs, fmt.Sprintf("backend must be hister or meilisearch, got %q", c.Backend))
 	}
 	if err := validatePath(c.Hister.AddPath); err != nil {
 		validationErrs = append(validationErrs, fmt.Sprintf("hister.add_path: %v", err))
@@ -183,10 +910,74 @@ func (c *Config) Validate() error {
 	if err := validatePath(c.Hister.SearchWSPath); err != nil {
 		validationErrs = append(validationErrs, fmt.Sprintf("hister.search_ws_path: %v", err))
 	}
+	if err := validatePath(c.Hister.DeletePath); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("hister.delete_path: %v", err))
+	}
+	if c.Hister.HealthPath != "" {
+		if err := validatePath(c.Hister.HealthPath); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("hister.health_path: %v", err))
+		}
+	}
+	if c.Hister.BatchAddPath != "" {
+		if err := validatePath(c.Hister.BatchAddPath); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("hister.batch_add_path: %v", err))
+		}
+	}
+	switch c.Hister.SearchTransport {
+	case "ws", "http", "auto":
+	default:
+		validationErrs = append(validationErrs, fmt.Sprintf("hister.search_transport must be ws, http or auto, got %q", c.Hister.SearchTransport))
+	}
+	if c.Hister.SearchHTTPPath != "" {
+		if err := validatePath(c.Hister.SearchHTTPPath); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("hister.search_http_path: %v", err))
+		}
+	}
+	for roomID := range c.Hister.Namespaces.Rooms {
+		if !strings.HasPrefix(roomID, "!") {
+			validationErrs = append(validationErrs, fmt.Sprintf("hister.namespaces.rooms key %q must start with '!'", roomID))
+		}
+	}
+	if c.Hister.MaxIdleConns < 0 {
+		validationErrs = append(validationErrs, "hister.max_idle_conns must be >= 0")
+	}
+	if c.Hister.IdleConnTimeoutMS < 0 {
+		validationErrs = append(validationErrs, "hister.idle_conn_timeout_ms must be >= 0")
+	}
+	if c.Hister.Breaker.Failures < 0 {
+		validationErrs = append(validationErrs, "hister.breaker.failures must be >= 0")
+	}
+	if c.Hister.Breaker.CooldownMS < 0 {
+		validationErrs = append(validationErrs, "hister.breaker.cooldown_ms must be >= 0")
+	}
+	if c.Hister.BatchSize < 0 {
+		validationErrs = append(validationErrs, "hister.batch_size must be >= 0")
+	}
+	if c.Hister.PongTimeoutMS < 0 {
+		validationErrs = append(validationErrs, "hister.pong_timeout_ms must be >= 0")
+	}
+	if c.Hister.GzipMinBytes < 0 {
+		validationErrs = append(validationErrs, "hister.gzip_min_bytes must be >= 0")
+	}
+	if auth := c.Hister.SearchAuth; auth.Header != "" && auth.QueryParam != "" {
+		validationErrs = append(validationErrs, "hister.search_auth: set only one of header and query_param")
+	} else if auth.Header != "" && !validHeaderName(auth.Header) {
+		validationErrs = append(validationErrs, fmt.Sprintf("hister.search_auth.header %q is not a valid header name", auth.Header))
+	}
 
 	if c.HTTP.RequestTimeoutMS <= 0 {
 		validationErrs = append(validationErrs, "http.request_timeout_ms must be > 0")
 	}
+	for domain, values := range c.HTTP.SiteHeaders {
+		if domain = strings.TrimSpace(domain); domain == "" || strings.ContainsAny(domain, "/: ") {
+			validationErrs = append(validationErrs, fmt.Sprintf("http.site_headers key %q must be a bare domain like example.com", domain))
+		}
+		for key := range values {
+			if !validHeaderName(key) {
+				validationErrs = append(validationErrs, fmt.Sprintf("http.site_headers[%s] has invalid header name %q", domain, key))
+			}
+		}
+	}
 
 	if strings.TrimSpace(c.Storage.StateDBPath) == "" {
 		validationErrs = append(validationErrs, "storage.state_db_path is required")
@@ -198,6 +989,134 @@ func (c *Config) Validate() error {
 		validationErrs = append(validationErrs, "storage.state_db_path and storage.crypto_db_path must be different")
 	}
 
+	if c.Health.MaxSyncAgeMS <= c.Matrix.SyncTimeoutMS {
+		validationErrs = append(validationErrs, "health.max_sync_age_ms must be greater than matrix.sync_timeout_ms")
+	}
+
+	if c.Shutdown.TimeoutMS <= 0 {
+		validationErrs = append(validationErrs, "shutdown.timeout_ms must be > 0")
+	}
+	if c.ErrorReporting.WebhookURL != "" {
+		if err := validateHTTPURL(c.ErrorReporting.WebhookURL); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("error_reporting.webhook_url: %v", err))
+		}
+	}
+	if c.Debug.PprofAddr != "" {
+		if err := validateLoopbackAddr(c.Debug.PprofAddr); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("debug.pprof_addr: %v", err))
+		}
+	}
+
+	if _, err := logging.ParseLevel(c.Logging.Level); err != nil {
+		validationErrs = append(validationErrs, "logging.level: "+err.Error())
+	}
+	for component, level := range c.Logging.Components {
+		if !logging.IsComponent(component) {
+			validationErrs = append(validationErrs, fmt.Sprintf("logging.components has unknown component %q (want one of %s)", component, strings.Join(logging.Components, ", ")))
+		}
+		if _, err := logging.ParseLevel(level); err != nil {
+			validationErrs = append(validationErrs, fmt.Sprintf("logging.components[%s]: %v", component, err))
+		}
+	}
+
+	digestLoc, err := c.Digest.Location()
+	if err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("digest.timezone: %v", err))
+	}
+	if c.Digest.AttachOverChars < 0 {
+		validationErrs = append(validationErrs, "digest.attach_over_chars must be zero or positive")
+	}
+	digestRooms := make(map[string]struct{}, len(c.Digest.Rooms))
+	for i, room := range c.Digest.Rooms {
+		if !allowedRooms.has(room.RoomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("digest.rooms[%d].room_id %q is not in matrix.allowed_room_ids", i, room.RoomID))
+		}
+		if _, dup := digestRooms[room.RoomID]; dup {
+			validationErrs = append(validationErrs, fmt.Sprintf("digest.rooms[%d].room_id %q is listed twice", i, room.RoomID))
+		}
+		digestRooms[room.RoomID] = struct{}{}
+		switch {
+		case room.Schedule != "" && room.Time != "":
+			validationErrs = append(validationErrs, fmt.Sprintf("digest.rooms[%d]: set either time or schedule, not both", i))
+		case room.Schedule != "":
+			if _, err := schedule.Parse(room.Schedule, digestLoc); err != nil {
+				validationErrs = append(validationErrs, fmt.Sprintf("digest.rooms[%d].schedule: %v", i, err))
+			}
+		default:
+			if _, err := room.At(); err != nil {
+				validationErrs = append(validationErrs, fmt.Sprintf("digest.rooms[%d].time: %v", i, err))
+			}
+		}
+	}
+
+	if c.Reindex.MaxAgeMS < 0 {
+		validationErrs = append(validationErrs, "reindex.max_age_ms must be >= 0")
+	}
+	if _, err := c.ReindexSchedule(); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("reindex.schedule: %v", err))
+	}
+	if _, err := c.WatchSchedule(); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("watch.schedule: %v", err))
+	}
+	if c.Watch.MaxPerUser < 0 {
+		validationErrs = append(validationErrs, "watch.max_per_user must be >= 0")
+	}
+	if c.DeadLinks.MaxAgeMS < 0 {
+		validationErrs = append(validationErrs, "dead_links.max_age_ms must be >= 0")
+	}
+	if _, err := c.DeadLinksSchedule(); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("dead_links.schedule: %v", err))
+	}
+	switch c.DeadLinks.Action {
+	case "demote", "hide":
+	default:
+		validationErrs = append(validationErrs, "dead_links.action must be 'demote' or 'hide'")
+	}
+	if roomID := c.DeadLinks.ReportRoomID; roomID != "" {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("dead_links.report_room_id %q is not in matrix.allowed_room_ids", roomID))
+		}
+	}
+	if _, err := c.RetentionSchedule(); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("retention.schedule: %v", err))
+	}
+	for roomID, room := range c.Retention.Rooms {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("retention.rooms key %q is not in matrix.allowed_room_ids", roomID))
+		}
+		if room.MaxAgeMS <= 0 {
+			validationErrs = append(validationErrs, fmt.Sprintf("retention.rooms[%s].max_age_ms must be > 0", roomID))
+		}
+	}
+	validationErrs = append(validationErrs, validateSummaryLimits("summary", c.Summary.SummaryLimits)...)
+	for roomID, limits := range c.Summary.Rooms {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("summary.rooms key %q is not in matrix.allowed_room_ids", roomID))
+		}
+		validationErrs = append(validationErrs, validateSummaryLimits(fmt.Sprintf("summary.rooms[%s]", roomID), limits)...)
+	}
+	for i, roomID := range c.TopLinks.RoomIDs {
+		if !allowedRooms.has(roomID) {
+			validationErrs = append(validationErrs, fmt.Sprintf("top_links.room_ids[%d] %q is not in matrix.allowed_room_ids", i, roomID))
+		}
+	}
+	if _, err := c.TopLinks.CronSchedule(); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("top_links: %v", err))
+	}
+
+	if _, err := c.Templates.Compile(); err != nil {
+		validationErrs = append(validationErrs, fmt.Sprintf("templates: %v", err))
+	}
+
+	for roomID, lang := range c.I18n.RoomLanguages {
+		if !strings.HasPrefix(roomID, "!") {
+			validationErrs = append(validationErrs, fmt.Sprintf("i18n.room_languages key %q must start with '!'", roomID))
+		}
+		if strings.TrimSpace(lang) == "" {
+			validationErrs = append(validationErrs, fmt.Sprintf("i18n.room_languages[%s] is empty", roomID))
+		}
+	}
+
 	if len(validationErrs) > 0 {
 		return fmt.Errorf("invalid config: %s", strings.Join(validationErrs, "; "))
 	}
@@ -208,24 +1127,74 @@ func (c *Config) applyDefaults() {
 	if c.Matrix.SyncTimeoutMS <= 0 {
 		c.Matrix.SyncTimeoutMS = defaultSyncTimeoutMS
 	}
+	for i := range c.Accounts {
+		if c.Accounts[i].SyncTimeoutMS <= 0 {
+			c.Accounts[i].SyncTimeoutMS = c.Matrix.SyncTimeoutMS
+		}
+		if c.Accounts[i].HandlerWorkers == 0 {
+			c.Accounts[i].HandlerWorkers = c.Matrix.HandlerWorkers
+		}
+		if c.Accounts[i].HandlerQueueSize == 0 {
+			c.Accounts[i].HandlerQueueSize = c.Matrix.HandlerQueueSize
+		}
+		if c.Accounts[i].CatchUpMaxAgeMS == 0 {
+			c.Accounts[i].CatchUpMaxAgeMS = c.Matrix.CatchUpMaxAgeMS
+		}
+	}
 	if strings.TrimSpace(c.Bot.SearchCommand) == "" {
 		c.Bot.SearchCommand = defaultSearchCommand
 	}
+	if strings.TrimSpace(c.Bot.CommandPrefix) == "" {
+		c.Bot.CommandPrefix = triggers.DefaultCommandPrefix
+	}
+	if strings.TrimSpace(c.Bot.CommandTypos) == "" {
+		c.Bot.CommandTypos = defaultCommandTypos
+	}
 	if c.Bot.MaxResults <= 0 {
 		c.Bot.MaxResults = defaultMaxResults
 	}
+	if c.Bot.ResultPages <= 0 {
+		c.Bot.ResultPages = defaultResultPages
+	}
 	if strings.TrimSpace(c.Bot.ReplyMode) == "" {
 		c.Bot.ReplyMode = defaultReplyMode
 	}
 	if c.Bot.MaxQueryLen <= 0 {
 		c.Bot.MaxQueryLen = defaultMaxQueryLen
 	}
+	if strings.TrimSpace(c.Bot.IndexedReaction) == "" {
+		c.Bot.IndexedReaction = defaultIndexedReaction
+	}
+	if strings.TrimSpace(c.Bot.BulkIndexConfirmReaction) == "" {
+		c.Bot.BulkIndexConfirmReaction = defaultBulkConfirm
+	}
+	if strings.TrimSpace(c.Bot.IndexFailureNotices) == "" {
+		c.Bot.IndexFailureNotices = defaultIndexFailures
+	}
+	if strings.TrimSpace(c.Bot.MessageType) == "" {
+		c.Bot.MessageType = defaultMessageType
+	}
+	if strings.TrimSpace(c.Bot.CatchMeUp.History) == "" {
+		c.Bot.CatchMeUp.History = defaultCatchMeUpHistory
+	}
 	if strings.TrimSpace(c.Hister.AddPath) == "" {
 		c.Hister.AddPath = defaultAddPath
 	}
 	if strings.TrimSpace(c.Hister.SearchWSPath) == "" {
 		c.Hister.SearchWSPath = defaultSearchWSPath
 	}
+	if strings.TrimSpace(c.Hister.DeletePath) == "" {
+		c.Hister.DeletePath = defaultDeletePath
+	}
+	if strings.TrimSpace(c.Backend) == "" {
+		c.Backend = BackendHister
+	}
+	if strings.TrimSpace(c.Meilisearch.Index) == "" {
+		c.Meilisearch.Index = defaultMeilisearchIndex
+	}
+	if strings.TrimSpace(c.Hister.SearchTransport) == "" {
+		c.Hister.SearchTransport = "ws"
+	}
 	if c.HTTP.RequestTimeoutMS <= 0 {
 		c.HTTP.RequestTimeoutMS = defaultRequestTimeoutMS
 	}
@@ -235,12 +1204,443 @@ func (c *Config) applyDefaults() {
 	if strings.TrimSpace(c.Storage.CryptoDBPath) == "" {
 		c.Storage.CryptoDBPath = defaultCryptoDBPath
 	}
+	if c.Health.MaxSyncAgeMS <= 0 {
+		c.Health.MaxSyncAgeMS = defaultMaxSyncAgeMS
+	}
+	if c.Shutdown.TimeoutMS <= 0 {
+		c.Shutdown.TimeoutMS = defaultShutdownTimeout
+	}
+	if strings.TrimSpace(c.I18n.DefaultLanguage) == "" {
+		c.I18n.DefaultLanguage = i18n.DefaultLanguage
+	}
+	if strings.TrimSpace(c.Logging.Level) == "" {
+		c.Logging.Level = defaultLogLevel
+	}
+	if c.Digest.MaxMessages <= 0 {
+		c.Digest.MaxMessages = defaultDigestMaxMessages
+	}
+	if c.Reindex.IntervalMS <= 0 {
+		c.Reindex.IntervalMS = defaultReindexIntervalMS
+	}
+	if c.Reindex.BatchSize <= 0 {
+		c.Reindex.BatchSize = defaultReindexBatchSize
+	}
+	if c.Watch.IntervalMS <= 0 {
+		c.Watch.IntervalMS = defaultWatchIntervalMS
+	}
+	if c.DeadLinks.IntervalMS <= 0 {
+		c.DeadLinks.IntervalMS = defaultDeadLinksInterval
+	}
+	if c.DeadLinks.BatchSize <= 0 {
+		c.DeadLinks.BatchSize = defaultDeadLinksBatch
+	}
+	if strings.TrimSpace(c.DeadLinks.Action) == "" {
+		c.DeadLinks.Action = defaultDeadLinksAction
+	}
+	if c.Retention.IntervalMS <= 0 {
+		c.Retention.IntervalMS = defaultRetentionInterval
+	}
+	if strings.TrimSpace(c.TopLinks.Schedule) == "" {
+		c.TopLinks.Schedule = defaultTopLinksSchedule
+	}
+	if c.TopLinks.Limit <= 0 {
+		c.TopLinks.Limit = defaultTopLinksLimit
+	}
+	if c.Summary.WindowMS <= 0 {
+		c.Summary.WindowMS = defaultSummaryWindowMS
+	}
+	if c.Summary.MaxMessages <= 0 {
+		c.Summary.MaxMessages = defaultSummaryMessages
+	}
+	if c.Summary.BucketGapMS <= 0 {
+		c.Summary.BucketGapMS = defaultBucketGapMS
+	}
+	if c.Summary.BucketMaxMessages <= 0 {
+		c.Summary.BucketMaxMessages = defaultBucketMessages
+	}
+	if c.Outbox.RetryIntervalMS <= 0 {
+		c.Outbox.RetryIntervalMS = defaultOutboxRetryMS
+	}
+	if c.Outbox.MaxAgeMS <= 0 {
+		c.Outbox.MaxAgeMS = defaultOutboxMaxAgeMS
+	}
+}
+
+// roomSet holds the entries of matrix.allowed_room_ids. While some are
+// unresolved aliases, any room counts as allowed; ResolveRoomAliases checks
+// again once they are resolved.
+type roomSet struct {
+	ids     map[string]struct{}
+	aliases bool
+}
+
+func (r roomSet) has(roomID string) bool {
+	_, ok := r.ids[roomID]
+	return ok || r.aliases
+}
+
+// validateMatrix checks one Matrix account, the matrix section or an entry of
+// accounts, and returns its allowed rooms.
+func validateMatrix(field string, m MatrixConfig) ([]string, roomSet) {
+	var errs []string
+	if err := validateHTTPURL(m.HomeserverURL); err != nil {
+		errs = append(errs, fmt.Sprintf("%s.homeserver_url: %v", field, err))
+	}
+	if strings.TrimSpace(m.UserID) == "" {
+		errs = append(errs, field+".user_id is required")
+	}
+	if m.Appservice.Registration != "" {
+		if strings.TrimSpace(m.Appservice.ListenAddr) == "" {
+			errs = append(errs, fmt.Sprintf("%[1]s.appservice.listen_addr is required with %[1]s.appservice.registration", field))
+		}
+	} else if strings.TrimSpace(m.AccessToken) == "" && m.Password == "" && m.RefreshToken == "" {
+		errs = append(errs, fmt.Sprintf("%[1]s.access_token, %[1]s.password or %[1]s.refresh_token is required", field))
+	}
+	if m.OIDC.TokenEndpoint != "" {
+		if err := validateHTTPURL(m.OIDC.TokenEndpoint); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.oidc.token_endpoint: %v", field, err))
+		}
+		if strings.TrimSpace(m.OIDC.ClientID) == "" {
+			errs = append(errs, fmt.Sprintf("%[1]s.oidc.client_id is required with %[1]s.oidc.token_endpoint", field))
+		}
+		if m.RefreshToken == "" {
+			errs = append(errs, fmt.Sprintf("%[1]s.refresh_token is required with %[1]s.oidc.token_endpoint", field))
+		}
+	}
+	if strings.TrimSpace(m.BotDisplayName) == "" {
+		errs = append(errs, field+".bot_display_name is required")
+	}
+	if m.SyncTimeoutMS <= 0 {
+		errs = append(errs, field+".sync_timeout_ms must be > 0")
+	}
+	if m.ColdStartMaxAgeMS < 0 {
+		errs = append(errs, field+".cold_start_max_age_ms must be >= 0")
+	}
+	if m.SyncStallTimeoutMS < 0 {
+		errs = append(errs, field+".sync_stall_timeout_ms must be >= 0")
+	} else if m.SyncStallTimeoutMS > 0 && m.SyncStallTimeoutMS <= int64(m.SyncTimeoutMS) {
+		errs = append(errs, fmt.Sprintf("%[1]s.sync_stall_timeout_ms must be greater than %[1]s.sync_timeout_ms", field))
+	}
+	if m.CatchUpMaxAgeMS < 0 {
+		errs = append(errs, field+".catch_up_max_age_ms must be >= 0")
+	}
+	if m.HandlerWorkers < 0 {
+		errs = append(errs, field+".handler_workers must be >= 0")
+	}
+	if m.HandlerQueueSize < 0 {
+		errs = append(errs, field+".handler_queue_size must be >= 0")
+	}
+	if len(m.AllowedRoomIDs) == 0 {
+		errs = append(errs, field+".allowed_room_ids must include at least one room")
+	}
+	allowedRooms := roomSet{ids: make(map[string]struct{}, len(m.AllowedRoomIDs))}
+	for i, roomID := range m.AllowedRoomIDs {
+		roomID = strings.TrimSpace(roomID)
+		allowedRooms.ids[roomID] = struct{}{}
+		switch {
+		case roomID == "":
+			errs = append(errs, fmt.Sprintf("%s.allowed_room_ids[%d] is empty", field, i))
+		case isRoomAlias(roomID):
+			allowedRooms.aliases = true
+		case !strings.HasPrefix(roomID, "!"):
+			errs = append(errs, fmt.Sprintf("%s.allowed_room_ids[%d] must be a room ID starting with '!' or an alias like '#room:server'", field, i))
+		}
+	}
+
+	errs = append(errs, validateUserPatterns(field+".allowed_user_ids", m.AllowedUserIDs)...)
+	errs = append(errs, validateUserPatterns(field+".blocked_user_ids", m.BlockedUserIDs)...)
+	errs = append(errs, validateUserPatterns(field+".dm_user_ids", m.DMUserIDs)...)
+	return errs, allowedRooms
+}
+
+// validateUserPatterns checks user ID patterns such as "@*:example.org".
+func validateUserPatterns(field string, patterns []string) []string {
+	var errs []string
+	for i, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if !strings.HasPrefix(pattern, "@") || !strings.Contains(pattern, ":") {
+			errs = append(errs, fmt.Sprintf("%s[%d] must look like '@user:server', with '*' as a wildcard", field, i))
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("%s[%d] is not a valid pattern: %v", field, i, err))
+		}
+	}
+	return errs
+}
+
+func isRoomAlias(room string) bool {
+	return strings.HasPrefix(room, "#") && strings.Contains(room, ":")
+}
+
+// RoomAliases returns the aliases in the allowed_room_ids of matrix and
+// accounts.
+func (c Config) RoomAliases() []string {
+	var out []string
+	for _, account := range append([]MatrixConfig{c.Matrix}, c.Accounts...) {
+		for _, room := range account.AllowedRoomIDs {
+			if room = strings.TrimSpace(room); isRoomAlias(room) && !slices.Contains(out, room) {
+				out = append(out, room)
+			}
+		}
+	}
+	return out
+}
+
+// ResolveRoomAliases replaces the aliases in the allowed_room_ids of matrix
+// and accounts by the room IDs in resolved and validates the config again,
+// now checking that the rooms other sections name are allowed.
+func (c *Config) ResolveRoomAliases(resolved map[string]string) error {
+	if err := resolveAliases(c.Matrix.AllowedRoomIDs, resolved); err != nil {
+		return err
+	}
+	for _, account := range c.Accounts {
+		if err := resolveAliases(account.AllowedRoomIDs, resolved); err != nil {
+			return err
+		}
+	}
+	return c.Validate()
+}
+
+func resolveAliases(rooms []string, resolved map[string]string) error {
+	for i, room := range rooms {
+		room = strings.TrimSpace(room)
+		if !isRoomAlias(room) {
+			continue
+		}
+		roomID, ok := resolved[room]
+		if !ok {
+			return fmt.Errorf("room alias %s is not resolved", room)
+		}
+		rooms[i] = roomID
+	}
+	return nil
+}
+
+// Command returns command rewritten to use the configured command prefix.
+func (b BotConfig) Command(command string) string {
+	return triggers.PrefixCommand(command, b.CommandPrefix)
+}
+
+// SensitiveDomains returns bot.spoilers.sensitive_domains lowercased.
+func (b BotConfig) SensitiveDomains() []string {
+	out := make([]string, 0, len(b.Spoilers.SensitiveDomains))
+	for _, domain := range b.Spoilers.SensitiveDomains {
+		out = append(out, strings.ToLower(strings.TrimSpace(domain)))
+	}
+	return out
+}
+
+// CompileCustomTriggers compiles bot.custom_triggers in configured order.
+func (b BotConfig) CompileCustomTriggers() ([]triggers.CustomTrigger, error) {
+	out := make([]triggers.CustomTrigger, 0, len(b.CustomTriggers))
+	for i, def := range b.CustomTriggers {
+		compiled, err := triggers.CompileCustomTrigger(def.Pattern, def.Action, def.Reply)
+		if err != nil {
+			return nil, fmt.Errorf("bot.custom_triggers[%d]: %v", i, err)
+		}
+		out = append(out, compiled)
+	}
+	return out, nil
+}
+
+// Catalog loads i18n.bundles_dir and builds the response catalog. Unknown
+// languages and message keys are reported here, after bundles are read.
+func (c I18nConfig) Catalog() (*i18n.Catalog, error) {
+	bundles, err := i18n.LoadBundles(c.BundlesDir)
+	if err != nil {
+		return nil, fmt.Errorf("i18n.bundles_dir: %w", err)
+	}
+	catalog, err := i18n.NewCatalog(c.DefaultLanguage, c.RoomLanguages, bundles)
+	if err != nil {
+		return nil, fmt.Errorf("i18n: %w", err)
+	}
+	return catalog, nil
+}
+
+// Compile parses the reply templates.
+func (c TemplatesConfig) Compile() (*render.Templates, error) {
+	return render.New(c.Result, c.Summary, c.Welcome)
+}
+
+// Loggers builds the per-component loggers writing to out.
+func (c LoggingConfig) Loggers(out *log.Logger) (*logging.Loggers, error) {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		return nil, fmt.Errorf("logging.level: %w", err)
+	}
+	overrides := make(map[string]logging.Level, len(c.Components))
+	for component, name := range c.Components {
+		if overrides[component], err = logging.ParseLevel(name); err != nil {
+			return nil, fmt.Errorf("logging.components[%s]: %w", component, err)
+		}
+	}
+	return logging.New(out, level, overrides), nil
+}
+
+// At parses Time into an offset from midnight.
+func (r DigestRoomConfig) At() (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(r.Time))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", r.Time)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// CronSchedule parses Schedule in loc, the digest time zone. It returns nil
+// when the room uses Time instead.
+func (r DigestRoomConfig) CronSchedule(loc *time.Location) (schedule.Schedule, error) {
+	if strings.TrimSpace(r.Schedule) == "" {
+		return nil, nil
+	}
+	return schedule.Parse(r.Schedule, loc)
+}
+
+// Location loads Timezone, defaulting to the host's local time.
+func (c DigestConfig) Location() (*time.Location, error) {
+	if strings.TrimSpace(c.Timezone) == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// Location loads Timezone, defaulting to the host's local time.
+func (c TopLinksConfig) Location() (*time.Location, error) {
+	if strings.TrimSpace(c.Timezone) == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// CronSchedule parses Schedule in Timezone.
+func (c TopLinksConfig) CronSchedule() (schedule.Schedule, error) {
+	loc, err := c.Location()
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	plan, err := schedule.Parse(c.Schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %w", err)
+	}
+	return plan, nil
+}
+
+func (c Config) SearchCacheTTL() time.Duration {
+	return time.Duration(c.Bot.SearchCacheTTLMS) * time.Millisecond
+}
+
+func (c Config) RecrawlAfter() time.Duration {
+	return time.Duration(c.Bot.RecrawlAfterMS) * time.Millisecond
 }
 
 func (c Config) SyncTimeout() time.Duration {
 	return time.Duration(c.Matrix.SyncTimeoutMS) * time.Millisecond
 }
 
+func (c Config) ColdStartMaxAge() time.Duration {
+	return time.Duration(c.Matrix.ColdStartMaxAgeMS) * time.Millisecond
+}
+
+func (c Config) SyncStallTimeout() time.Duration {
+	return time.Duration(c.Matrix.SyncStallTimeoutMS) * time.Millisecond
+}
+
+func (c Config) ReindexMaxAge() time.Duration {
+	return time.Duration(c.Reindex.MaxAgeMS) * time.Millisecond
+}
+
+func (c Config) ReindexInterval() time.Duration {
+	return time.Duration(c.Reindex.IntervalMS) * time.Millisecond
+}
+
+// ReindexSchedule parses reindex.schedule in the host's local time. It
+// returns nil when unset, meaning reindex.interval_ms applies.
+func (c Config) ReindexSchedule() (schedule.Schedule, error) {
+	return optionalSchedule(c.Reindex.Schedule)
+}
+
+// WatchSchedule parses watch.schedule like ReindexSchedule.
+func (c Config) WatchSchedule() (schedule.Schedule, error) {
+	return optionalSchedule(c.Watch.Schedule)
+}
+
+func optionalSchedule(spec string) (schedule.Schedule, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return schedule.Parse(spec, time.Local)
+}
+
+func (c Config) WatchInterval() time.Duration {
+	return time.Duration(c.Watch.IntervalMS) * time.Millisecond
+}
+
+func (c Config) DeadLinksMaxAge() time.Duration {
+	return time.Duration(c.DeadLinks.MaxAgeMS) * time.Millisecond
+}
+
+func (c Config) DeadLinksInterval() time.Duration {
+	return time.Duration(c.DeadLinks.IntervalMS) * time.Millisecond
+}
+
+// DeadLinksSchedule parses dead_links.schedule like ReindexSchedule.
+func (c Config) DeadLinksSchedule() (schedule.Schedule, error) {
+	return optionalSchedule(c.DeadLinks.Schedule)
+}
+
+func (c Config) RetentionInterval() time.Duration {
+	return time.Duration(c.Retention.IntervalMS) * time.Millisecond
+}
+
+// RetentionSchedule parses retention.schedule like ReindexSchedule.
+func (c Config) RetentionSchedule() (schedule.Schedule, error) {
+	return optionalSchedule(c.Retention.Schedule)
+}
+
+// SummaryLimitsFor returns the summary limits of roomID: its summary.rooms
+// entry with zero fields filled in from the global values.
+func (c Config) SummaryLimitsFor(roomID string) SummaryLimits {
+	limits := c.Summary.SummaryLimits
+	room := c.Summary.Rooms[roomID]
+	if room.WindowMS > 0 {
+		limits.WindowMS = room.WindowMS
+	}
+	if room.MaxMessages > 0 {
+		limits.MaxMessages = room.MaxMessages
+	}
+	if room.BucketGapMS > 0 {
+		limits.BucketGapMS = room.BucketGapMS
+	}
+	if room.BucketMaxMessages > 0 {
+		limits.BucketMaxMessages = room.BucketMaxMessages
+	}
+	return limits
+}
+
+func (l SummaryLimits) Window() time.Duration {
+	return time.Duration(l.WindowMS) * time.Millisecond
+}
+
+func (l SummaryLimits) BucketGap() time.Duration {
+	return time.Duration(l.BucketGapMS) * time.Millisecond
+}
+
+func (c Config) OutboxRetryInterval() time.Duration {
+	return time.Duration(c.Outbox.RetryIntervalMS) * time.Millisecond
+}
+
+func (c Config) OutboxMaxAge() time.Duration {
+	return time.Duration(c.Outbox.MaxAgeMS) * time.Millisecond
+}
+
+func (c Config) MaxSyncAge() time.Duration {
+	return time.Duration(c.Health.MaxSyncAgeMS) * time.Millisecond
+}
+
+func (c Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.Shutdown.TimeoutMS) * time.Millisecond
+}
+
 func (c Config) RequestTimeout() time.Duration {
 	return time.Duration(c.HTTP.RequestTimeoutMS) * time.Millisecond
 }
@@ -270,6 +1670,115 @@ func validateHTTPURL(raw string) error {
 	return nil
 }
 
+func validateLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("must be host:port: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return errors.New("must bind to a loopback address such as 127.0.0.1")
+	}
+	return nil
+}
+
+func validateRateLimit(field string, limit RateLimitConfig) []string {
+	var errs []string
+	if limit.Max < 0 {
+		errs = append(errs, field+".max must be >= 0")
+	}
+	if limit.Max > 0 && limit.WindowMS <= 0 {
+		errs = append(errs, field+".window_ms must be > 0 when max is set")
+	}
+	return errs
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > unicode.MaxASCII || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSenderPatterns checks that every pattern is a glob over Matrix
+// user IDs.
+func validateSenderPatterns(field string, patterns []string) []string {
+	var errs []string
+	for i, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "@") {
+			errs = append(errs, fmt.Sprintf("%s[%d] %q must start with '@'", field, i, pattern))
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("%s[%d] %q: %v", field, i, pattern, err))
+		}
+	}
+	return errs
+}
+
+func validateSummaryLimits(field string, limits SummaryLimits) []string {
+	var errs []string
+	if limits.WindowMS < 0 {
+		errs = append(errs, field+".window_ms must be >= 0")
+	}
+	if limits.MaxMessages < 0 {
+		errs = append(errs, field+".max_messages must be >= 0")
+	}
+	if limits.BucketGapMS < 0 {
+		errs = append(errs, field+".bucket_gap_ms must be >= 0")
+	}
+	if limits.BucketMaxMessages < 0 {
+		errs = append(errs, field+".bucket_max_messages must be >= 0")
+	}
+	return errs
+}
+
+func validHistoryMode(mode string) bool {
+	switch mode {
+	case "limit", "refuse", "off":
+		return true
+	}
+	return false
+}
+
+func validMessageType(msgType string) bool {
+	return msgType == "notice" || msgType == "text"
+}
+
+func validateCommandPrefix(prefix string) error {
+	if strings.TrimSpace(prefix) == "" {
+		return errors.New("is required")
+	}
+	for _, r := range prefix {
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return errors.New("must only contain punctuation or symbols")
+		}
+	}
+	return nil
+}
+
+// validIndexUID reports whether uid is a valid Meilisearch index uid.
+func validIndexUID(uid string) bool {
+	if uid == "" {
+		return false
+	}
+	for _, r := range uid {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 func validatePath(p string) error {
 	p = strings.TrimSpace(p)
 	if p == "" {
@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestParse_AppliesDefaults(t *testing.T) {
 	raw := []byte(`
@@ -22,9 +26,48 @@ hister:
 	if cfg.Bot.MaxResults != 5 {
 		t.Fatalf("expected default max_results=5, got %d", cfg.Bot.MaxResults)
 	}
+	if cfg.RecrawlAfter() != 24*time.Hour {
+		t.Fatalf("expected default recrawl_after_ms of a day, got %v", cfg.RecrawlAfter())
+	}
+	if cfg.Bot.ResultPages != 3 {
+		t.Fatalf("expected default result_pages=3, got %d", cfg.Bot.ResultPages)
+	}
+	if cfg.Bot.CommandTypos != "suggest" {
+		t.Fatalf("expected default command_typos=suggest, got %q", cfg.Bot.CommandTypos)
+	}
+	if cfg.Bot.MessageType != "notice" {
+		t.Fatalf("expected default message_type=notice, got %q", cfg.Bot.MessageType)
+	}
 	if cfg.Hister.AddPath != "/add" {
 		t.Fatalf("expected default add_path, got %q", cfg.Hister.AddPath)
 	}
+	if cfg.Hister.Breaker.Failures != 5 || cfg.Hister.Breaker.CooldownMS != 30000 {
+		t.Fatalf("expected default breaker settings, got %#v", cfg.Hister.Breaker)
+	}
+	if cfg.Hister.SearchTransport != "ws" {
+		t.Fatalf("expected default search_transport=ws, got %q", cfg.Hister.SearchTransport)
+	}
+	if cfg.Hister.DeletePath != "/delete" {
+		t.Fatalf("expected default delete_path, got %q", cfg.Hister.DeletePath)
+	}
+	if cfg.Bot.Backfill.Depth != 500 || cfg.Bot.Backfill.OnJoin {
+		t.Fatalf("expected backfill depth 500 without on_join, got %#v", cfg.Bot.Backfill)
+	}
+	if cfg.WatchInterval() != 15*time.Minute || cfg.Watch.MaxPerUser != 10 {
+		t.Fatalf("expected default watch settings, got %#v", cfg.Watch)
+	}
+	if cfg.DeadLinksMaxAge() != 0 || cfg.DeadLinksInterval() != time.Hour || cfg.DeadLinks.Action != "demote" {
+		t.Fatalf("expected dead link checks off by default, got %#v", cfg.DeadLinks)
+	}
+	if cfg.ColdStartMaxAge() != 10*time.Minute {
+		t.Fatalf("expected default cold start max age of 10m, got %s", cfg.ColdStartMaxAge())
+	}
+	if cfg.Matrix.CatchUpMaxAgeMS != 24*60*60*1000 {
+		t.Fatalf("expected default catch-up max age of 24h, got %dms", cfg.Matrix.CatchUpMaxAgeMS)
+	}
+	if cfg.Matrix.HandlerWorkers != 4 || cfg.Matrix.HandlerQueueSize != 64 {
+		t.Fatalf("expected 4 handler workers queueing 64 events, got %d and %d", cfg.Matrix.HandlerWorkers, cfg.Matrix.HandlerQueueSize)
+	}
 }
 
 func TestValidate_RejectsInvalid(t *testing.T) {
@@ -40,3 +83,674 @@ func TestValidate_RejectsInvalid(t *testing.T) {
 		t.Fatal("expected validation error")
 	}
 }
+
+func TestValidate_RejectsCooldownWithoutWindow(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Bot.Cooldowns.Search = RateLimitConfig{Max: 5}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for cooldown without window")
+	}
+	cfg.Bot.Cooldowns.Search.WindowMS = 60000
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid cooldown config, got %v", err)
+	}
+	cfg.Bot.BroadcastRoomLimit = RateLimitConfig{Max: 1}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for broadcast limit without window")
+	}
+}
+
+func TestI18nConfig_Catalog(t *testing.T) {
+	raw := []byte(`
+matrix:
+  homeserver_url: https://matrix.example.org
+  user_id: "@bot:example.org"
+  access_token: token
+  bot_display_name: bot
+  allowed_room_ids:
+    - "!abc:example.org"
+hister:
+  base_url: http://localhost:8080
+i18n:
+  room_languages:
+    "!abc:example.org": de
+`)
+
+	cfg, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if cfg.I18n.DefaultLanguage != "en" {
+		t.Fatalf("expected default language en, got %q", cfg.I18n.DefaultLanguage)
+	}
+	if _, err := cfg.I18n.Catalog(); err != nil {
+		t.Fatalf("Catalog failed: %v", err)
+	}
+
+	cfg.I18n.RoomLanguages["#alias:example.org"] = "de"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for non-room-ID key")
+	}
+	cfg.I18n.RoomLanguages = map[string]string{"!abc:example.org": "xx"}
+	if _, err := cfg.I18n.Catalog(); err == nil {
+		t.Fatal("expected error for unknown language")
+	}
+}
+
+func TestValidate_CommandPrefix(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Bot.CommandPrefix = "!"
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid prefix, got %v", err)
+	}
+	if got := cfg.Bot.Command(cfg.Bot.SearchCommand); got != "!search" {
+		t.Fatalf("unexpected prefixed search command: %q", got)
+	}
+
+	cfg.Bot.IgnorePrefixes = []string{"!"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error when ignore_prefixes shadows the command prefix")
+	}
+	cfg.Bot.IgnorePrefixes = nil
+	cfg.Bot.CommandPrefix = "x"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for alphanumeric prefix")
+	}
+}
+
+func TestValidate_PprofAddrMustBeLoopback(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+
+	for _, addr := range []string{"127.0.0.1:6060", "[::1]:6060", "localhost:6060"} {
+		cfg.Debug.PprofAddr = addr
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("expected %s to be accepted, got %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:6060", ":6060", "10.0.0.5:6060"} {
+		cfg.Debug.PprofAddr = addr
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("expected %s to be rejected", addr)
+		}
+	}
+}
+
+func TestValidate_LoggingLevels(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+
+	cfg.Logging.Components = map[string]string{"matrix": "warn", "hister": "debug"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid logging config, got %v", err)
+	}
+	cfg.Logging.Components = map[string]string{"sync": "debug"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown component")
+	}
+	cfg.Logging.Components = map[string]string{"matrix": "loud"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown level")
+	}
+}
+
+func TestValidate_DeadLinks(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.DeadLinks.MaxAgeMS = 7 * 24 * 3600000
+	cfg.DeadLinks.Action = "hide"
+	cfg.DeadLinks.ReportRoomID = "!abc:example.org"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid dead_links config, got %v", err)
+	}
+
+	cfg.DeadLinks.Action = "delete"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown dead_links.action")
+	}
+	cfg.DeadLinks.Action = "demote"
+	cfg.DeadLinks.ReportRoomID = "!other:example.org"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for report room outside allowed rooms")
+	}
+}
+
+func TestResolveRoomAliases(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org", "#general:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.DeadLinks.ReportRoomID = "!general:example.org"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected aliases to be valid before resolving, got %v", err)
+	}
+	if got := cfg.RoomAliases(); len(got) != 1 || got[0] != "#general:example.org" {
+		t.Fatalf("RoomAliases() = %v", got)
+	}
+
+	if err := cfg.ResolveRoomAliases(map[string]string{"#general:example.org": "!general:example.org"}); err != nil {
+		t.Fatalf("ResolveRoomAliases() error = %v", err)
+	}
+	if cfg.Matrix.AllowedRoomIDs[1] != "!general:example.org" {
+		t.Fatalf("allowed rooms = %v", cfg.Matrix.AllowedRoomIDs)
+	}
+
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org", "#general:example.org"}
+	if err := cfg.ResolveRoomAliases(map[string]string{"#general:example.org": "!other:example.org"}); err == nil {
+		t.Fatal("expected validation error for a room outside the resolved allowed rooms")
+	}
+
+	cfg.Matrix.AllowedRoomIDs = []string{"general"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for an entry that is neither a room ID nor an alias")
+	}
+}
+
+func TestValidate_ReplyMode(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	for _, mode := range []string{"thread", "reply", "room"} {
+		cfg.Bot.ReplyMode = mode
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("expected reply_mode %q to be valid, got %v", mode, err)
+		}
+	}
+	cfg.Bot.ReplyMode = "dm"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown reply_mode")
+	}
+}
+
+func TestValidate_PasswordLogin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error without an access token or password")
+	}
+	cfg.Matrix.Password = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected password login to be valid, got %v", err)
+	}
+	cfg.Matrix.Password = ""
+	cfg.Matrix.RefreshToken = "refresh"
+	cfg.Matrix.OIDC.TokenEndpoint = "https://auth.example.org/oauth2/token"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for an OIDC token endpoint without a client ID")
+	}
+	cfg.Matrix.OIDC.ClientID = "bot"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected OIDC refresh to be valid, got %v", err)
+	}
+}
+
+func TestValidate_Appservice(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Matrix.Appservice.Registration = "registration.yaml"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error without an appservice listen address")
+	}
+	cfg.Matrix.Appservice.ListenAddr = "127.0.0.1:29333"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected appservice mode to be valid without an access token, got %v", err)
+	}
+}
+
+func TestValidate_MessageTypes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Bot.MessageTypes = map[string]string{"results": "text", "summaries": "notice"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected message types to be valid, got %v", err)
+	}
+
+	cfg.Bot.MessageType = "emote"
+	cfg.Bot.MessageTypes = map[string]string{"errors": "text"}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "bot.message_type must") || !strings.Contains(err.Error(), `bot.message_types key "errors"`) {
+		t.Fatalf("expected message type errors, got %v", err)
+	}
+}
+
+func TestValidate_Accounts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Accounts = []MatrixConfig{{
+		HomeserverURL:  "https://matrix.example.org",
+		UserID:         "@bot2:example.org",
+		AccessToken:    "token2",
+		BotDisplayName: "bot2",
+		AllowedRoomIDs: []string{"#other:example.org"},
+	}}
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected extra account to be valid, got %v", err)
+	}
+	if got := cfg.Accounts[0].SyncTimeoutMS; got != cfg.Matrix.SyncTimeoutMS {
+		t.Fatalf("expected account sync timeout %d, got %d", cfg.Matrix.SyncTimeoutMS, got)
+	}
+	if got := cfg.RoomAliases(); len(got) != 1 || got[0] != "#other:example.org" {
+		t.Fatalf("expected the account's alias, got %v", got)
+	}
+	if err := cfg.ResolveRoomAliases(map[string]string{"#other:example.org": "!other:example.org"}); err != nil {
+		t.Fatalf("resolve aliases: %v", err)
+	}
+	if got := cfg.Accounts[0].AllowedRoomIDs[0]; got != "!other:example.org" {
+		t.Fatalf("expected resolved room ID, got %q", got)
+	}
+
+	cfg.Accounts[0].UserID = "@bot:example.org"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "accounts[0].user_id") {
+		t.Fatalf("expected duplicate user_id error, got %v", err)
+	}
+	cfg.Accounts[0].UserID = "@bot2:example.org"
+	cfg.Accounts[0].AccessToken = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "accounts[0].access_token") {
+		t.Fatalf("expected missing credentials error, got %v", err)
+	}
+}
+
+func TestValidate_SenderPatterns(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Matrix.AllowedUserIDs = []string{"@*:example.org"}
+	cfg.Matrix.BlockedUserIDs = []string{"@spam:example.org"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected sender patterns to be valid, got %v", err)
+	}
+	cfg.Matrix.BlockedUserIDs = []string{"example.org"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for a blocked entry that is not a user ID")
+	}
+	cfg.Matrix.BlockedUserIDs = []string{"@[:example.org"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for a malformed pattern")
+	}
+}
+
+func TestValidate_Retention(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Retention.Rooms = map[string]RoomRetention{"!abc:example.org": {MaxAgeMS: 30 * 24 * 3600000}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid retention config, got %v", err)
+	}
+
+	cfg.Retention.Rooms = map[string]RoomRetention{"!abc:example.org": {}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for missing retention.rooms max_age_ms")
+	}
+	cfg.Retention.Rooms = map[string]RoomRetention{"!other:example.org": {MaxAgeMS: 3600000}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for retention room outside allowed rooms")
+	}
+}
+
+func TestValidate_Backend(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Backend = BackendMeilisearch
+	cfg.Meilisearch.URL = "http://localhost:7700"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected meilisearch without hister.base_url to be valid, got %v", err)
+	}
+
+	cfg.Meilisearch.Index = "links/all"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for invalid meilisearch.index")
+	}
+	cfg.Meilisearch.Index = "links"
+	cfg.Hister.Namespaces.PerRoom = true
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for hister.namespaces with meilisearch")
+	}
+	cfg.Hister.Namespaces.PerRoom = false
+	cfg.Backend = "solr"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown backend")
+	}
+}
+
+func TestValidate_IndexFailureNotices(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	if cfg.Bot.IndexFailureNotices != "off" {
+		t.Fatalf("default bot.index_failure_notices = %q, want off", cfg.Bot.IndexFailureNotices)
+	}
+	cfg.Bot.IndexFailureNotices = "direct"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid index failure notices, got %v", err)
+	}
+	cfg.Bot.IndexFailureNotices = "email"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown bot.index_failure_notices")
+	}
+}
+
+func TestSummaryLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Summary.Rooms = map[string]SummaryLimits{"!abc:example.org": {MaxMessages: 300, BucketGapMS: 600000}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid summary limits, got %v", err)
+	}
+	got := cfg.SummaryLimitsFor("!abc:example.org")
+	want := SummaryLimits{WindowMS: 86400000, MaxMessages: 300, BucketGapMS: 600000, BucketMaxMessages: 30}
+	if got != want {
+		t.Fatalf("SummaryLimitsFor = %+v, want %+v", got, want)
+	}
+	if got := cfg.SummaryLimitsFor("!other:example.org"); got != cfg.Summary.SummaryLimits {
+		t.Fatalf("SummaryLimitsFor(other room) = %+v, want global limits", got)
+	}
+
+	cfg.Summary.Rooms = map[string]SummaryLimits{"!other:example.org": {WindowMS: -1}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected summary.rooms validation errors, got %v", err)
+	}
+}
+
+func TestValidate_CatchMeUpHistory(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	if cfg.Bot.CatchMeUp.History != "limit" {
+		t.Fatalf("default bot.catchmeup.history = %q, want limit", cfg.Bot.CatchMeUp.History)
+	}
+	cfg.Bot.CatchMeUp.RoomHistory = map[string]string{"!abc:example.org": "refuse"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid catchmeup config, got %v", err)
+	}
+
+	cfg.Bot.CatchMeUp.History = "hide"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown bot.catchmeup.history")
+	}
+	cfg.Bot.CatchMeUp.History = "off"
+	cfg.Bot.CatchMeUp.RoomHistory = map[string]string{"!other:example.org": "limit"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for room outside allowed rooms")
+	}
+}
+
+func TestValidate_Spoilers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Bot.Spoilers.RoomIDs = []string{"!abc:example.org"}
+	cfg.Bot.Spoilers.SensitiveDomains = []string{" NSFW.Example "}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid spoilers config, got %v", err)
+	}
+	if got := cfg.Bot.SensitiveDomains(); len(got) != 1 || got[0] != "nsfw.example" {
+		t.Fatalf("SensitiveDomains() = %#v", got)
+	}
+
+	cfg.Bot.Spoilers.SensitiveDomains = []string{"https://nsfw.example/"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for a URL in sensitive_domains")
+	}
+	cfg.Bot.Spoilers.SensitiveDomains = nil
+	cfg.Bot.Spoilers.RoomIDs = []string{"!other:example.org"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for spoiler room outside allowed rooms")
+	}
+	cfg.Bot.Spoilers.RoomIDs = nil
+	cfg.Bot.ChatSearch.RoomIDs = []string{"!other:example.org"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for chat search room outside allowed rooms")
+	}
+	cfg.Bot.ChatSearch.RoomIDs = nil
+	cfg.Bot.IndexSenders.Deny = []string{"*:spam.example"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for sender pattern without '@'")
+	}
+	cfg.Bot.IndexSenders.Deny = []string{"@[a-:spam.example"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for malformed sender pattern")
+	}
+	cfg.Bot.IndexSenders.Deny = []string{"@*:spam.example"}
+	cfg.Bot.IgnoreBots.Users = []string{"relay:bots.example"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for bot pattern without '@'")
+	}
+	cfg.Bot.IgnoreBots.Users = []string{"@*:bots.example"}
+	cfg.HTTP.SiteHeaders = map[string]map[string]string{"https://example.com": {"Cookie": "a=b"}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for site header key with scheme")
+	}
+	cfg.HTTP.SiteHeaders = map[string]map[string]string{"example.com": {"Bad Header": "x"}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for invalid header name")
+	}
+	cfg.HTTP.SiteHeaders = map[string]map[string]string{"example.com": {"accept-language": "de"}}
+	if got := cfg.HTTP.PageHeaders().For("www.example.com").Get("Accept-Language"); got != "de" {
+		t.Fatalf("PageHeaders() Accept-Language = %q", got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid sender pattern, got %v", err)
+	}
+}
+
+func TestValidate_Templates(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Templates.Result = "{{.Index}}) {{.Title}} <{{.URL}}>"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid templates config, got %v", err)
+	}
+
+	cfg.Templates.Summary = "{{.Sumary}}"
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected validation error for unknown template field, got %v", err)
+	}
+}
+
+func TestValidate_SearchCacheTTL(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.Bot.SearchCacheTTLMS = 60000
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid search cache ttl, got %v", err)
+	}
+	if got := cfg.SearchCacheTTL(); got != time.Minute {
+		t.Fatalf("SearchCacheTTL() = %s, want 1m", got)
+	}
+	cfg.Bot.SearchCacheTTLMS = -1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for negative bot.search_cache_ttl_ms")
+	}
+}
+
+func TestValidate_TopLinks(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+	cfg.TopLinks.RoomIDs = []string{"!abc:example.org"}
+	cfg.TopLinks.Timezone = "UTC"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid top_links config, got %v", err)
+	}
+	plan, err := cfg.TopLinks.CronSchedule()
+	if err != nil {
+		t.Fatalf("CronSchedule() error = %v", err)
+	}
+	if got, want := plan.Next(time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)), time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("expected Monday 09:00 by default, got %s", got)
+	}
+
+	for name, mutate := range map[string]func(*TopLinksConfig){
+		"unknown room":     func(c *TopLinksConfig) { c.RoomIDs = []string{"!other:example.org"} },
+		"bad schedule":     func(c *TopLinksConfig) { c.Schedule = "weekly" },
+		"unknown timezone": func(c *TopLinksConfig) { c.Timezone = "Mars/Olympus" },
+	} {
+		bad := cfg
+		mutate(&bad.TopLinks)
+		if err := bad.Validate(); err == nil {
+			t.Fatalf("expected validation error for %s", name)
+		}
+	}
+}
+
+func TestValidate_Digest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Matrix.HomeserverURL = "https://matrix.example.org"
+	cfg.Matrix.UserID = "@bot:example.org"
+	cfg.Matrix.AccessToken = "token"
+	cfg.Matrix.BotDisplayName = "bot"
+	cfg.Matrix.AllowedRoomIDs = []string{"!abc:example.org"}
+	cfg.Hister.BaseURL = "http://localhost:8080"
+
+	cfg.Digest.Timezone = "Europe/Berlin"
+	cfg.Digest.Rooms = []DigestRoomConfig{{RoomID: "!abc:example.org", Time: "09:30", Pin: true}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid digest config, got %v", err)
+	}
+	if at, err := cfg.Digest.Rooms[0].At(); err != nil || at != 9*time.Hour+30*time.Minute {
+		t.Fatalf("At() = %s, %v", at, err)
+	}
+
+	for name, room := range map[string]DigestRoomConfig{
+		"unknown room": {RoomID: "!other:example.org", Time: "09:30"},
+		"bad time":     {RoomID: "!abc:example.org", Time: "25:00"},
+		"bad schedule": {RoomID: "!abc:example.org", Schedule: "0 9 * *"},
+		"both":         {RoomID: "!abc:example.org", Time: "09:30", Schedule: "0 9 * * 1-5"},
+	} {
+		cfg.Digest.Rooms = []DigestRoomConfig{room}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("expected validation error for %s", name)
+		}
+	}
+
+	cfg.Digest.Rooms = []DigestRoomConfig{{RoomID: "!abc:example.org", Schedule: "0 9 * * 1-5"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid digest schedule, got %v", err)
+	}
+	loc, _ := cfg.Digest.Location()
+	plan, err := cfg.Digest.Rooms[0].CronSchedule(loc)
+	if err != nil {
+		t.Fatalf("CronSchedule() error = %v", err)
+	}
+	saturday := time.Date(2026, 3, 7, 12, 0, 0, 0, loc)
+	if got, want := plan.Next(saturday), time.Date(2026, 3, 9, 9, 0, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("Next() = %s, want %s", got, want)
+	}
+
+	cfg.Reindex.Schedule = "@weekly"
+	cfg.Watch.Schedule = "*/10 * * * *"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid job schedules, got %v", err)
+	}
+	cfg.Watch.Schedule = "every 10 minutes"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for bad watch.schedule")
+	}
+	cfg.Watch.Schedule = ""
+
+	cfg.Digest.Rooms = nil
+	cfg.Digest.Timezone = "Mars/Olympus"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unknown timezone")
+	}
+}
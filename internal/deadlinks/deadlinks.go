@@ -0,0 +1,246 @@
+// Package deadlinks periodically checks indexed URLs and records the ones
+// that no longer exist, so search results can demote or hide them.
+package deadlinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	DefaultInterval  = time.Hour
+	DefaultBatchSize = 50
+	// maxReportLinks caps how many links one admin report lists.
+	maxReportLinks = 50
+	userAgent      = "Mozilla/5.0 (X11; Linux x86_64; rv:147.0) Gecko/20100101 Firefox/147.0"
+)
+
+// ErrUnknown marks a check that could not tell whether a link is dead, such
+// as a timeout or a server error. The link keeps its previous state.
+var ErrUnknown = errors.New("link state unknown")
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type Store interface {
+	LinksToCheck(ctx context.Context, cutoff time.Time, limit int) ([]string, error)
+	RecordLinkCheck(ctx context.Context, check storage.LinkCheck) (storage.LinkCheck, error)
+	MarkLinkChecked(ctx context.Context, rawURL string, checkedAt time.Time) error
+}
+
+// Poster sends the admin report of newly dead links.
+type Poster interface {
+	SendNotice(ctx context.Context, roomID id.RoomID, body string) (id.EventID, error)
+}
+
+type Config struct {
+	// MaxAge is how long a link goes unchecked before it is checked again.
+	MaxAge time.Duration
+	// Interval is how often links are checked; Schedule, when set, replaces it.
+	Interval time.Duration
+	Schedule schedule.Schedule
+	// BatchSize caps how many links one pass checks.
+	BatchSize int
+	// ReportRoom, when set, receives a notice listing links found dead in
+	// each pass.
+	ReportRoom id.RoomID
+	Messages   *i18n.Catalog
+}
+
+// Deps are the collaborators used by Job. Check defaults to an HTTP HEAD
+// check over http.DefaultClient; Poster is only needed for reports.
+type Deps struct {
+	Store  Store
+	Check  func(ctx context.Context, rawURL string) (reason string, dead bool, err error)
+	Poster Poster
+	Logger Logger
+}
+
+// Stats summarizes one check pass. Dead lists links first found dead in
+// this pass.
+type Stats struct {
+	Checked int
+	Dead    []string
+	Unknown int
+}
+
+type Job struct {
+	cfg    Config
+	store  Store
+	check  func(ctx context.Context, rawURL string) (string, bool, error)
+	poster Poster
+	logger Logger
+	now    func() time.Time
+}
+
+func New(cfg Config, deps Deps) (*Job, error) {
+	if deps.Store == nil {
+		return nil, errors.New("link store is required")
+	}
+	if cfg.MaxAge <= 0 {
+		return nil, errors.New("max age must be greater than zero")
+	}
+	if cfg.ReportRoom != "" && deps.Poster == nil {
+		return nil, errors.New("poster is required for reports")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	check := deps.Check
+	if check == nil {
+		check = func(ctx context.Context, rawURL string) (string, bool, error) {
+			return Check(ctx, http.DefaultClient, rawURL)
+		}
+	}
+	return &Job{
+		cfg:    cfg,
+		store:  deps.Store,
+		check:  check,
+		poster: deps.Poster,
+		logger: deps.Logger,
+		now:    time.Now,
+	}, nil
+}
+
+// Run checks links every Interval, or at each Schedule time, until ctx is
+// done, reporting newly dead links after each pass.
+func (j *Job) Run(ctx context.Context) {
+	plan := j.cfg.Schedule
+	next := j.now()
+	if plan == nil {
+		plan = schedule.Every(j.cfg.Interval)
+	} else {
+		next = plan.Next(next)
+	}
+	for !next.IsZero() {
+		if !schedule.Wait(ctx, next) {
+			return
+		}
+		stats, err := j.RunOnce(ctx)
+		if err != nil {
+			j.logf("dead link pass failed err=%v", err)
+		} else if stats.Checked > 0 {
+			j.logf("dead link pass checked=%d dead=%d unknown=%d", stats.Checked, len(stats.Dead), stats.Unknown)
+		}
+		if err := j.Report(ctx, stats.Dead); err != nil {
+			j.logf("dead link report failed err=%v", err)
+		}
+		next = plan.Next(j.now())
+	}
+}
+
+// RunOnce checks one batch of links not checked within MaxAge.
+func (j *Job) RunOnce(ctx context.Context) (Stats, error) {
+	var stats Stats
+	links, err := j.store.LinksToCheck(ctx, j.now().Add(-j.cfg.MaxAge), j.cfg.BatchSize)
+	if err != nil {
+		return stats, err
+	}
+	for _, rawURL := range links {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		stats.Checked++
+		now := j.now()
+		reason, dead, err := j.check(ctx, rawURL)
+		if err != nil {
+			// Keep the link's state, but note the check so a flaky host is
+			// not retried on every pass.
+			stats.Unknown++
+			j.logf("dead link check inconclusive url=%s err=%v", rawURL, err)
+			if err := j.store.MarkLinkChecked(ctx, rawURL, now); err != nil {
+				j.logf("dead link record failed url=%s err=%v", rawURL, err)
+			}
+			continue
+		}
+		stored, err := j.store.RecordLinkCheck(ctx, storage.LinkCheck{URL: rawURL, Dead: dead, Reason: reason, CheckedAt: now})
+		if err != nil {
+			j.logf("dead link record failed url=%s err=%v", rawURL, err)
+			continue
+		}
+		if dead && stored.DeadSince.Equal(now) {
+			stats.Dead = append(stats.Dead, rawURL)
+			j.logf("dead link url=%s reason=%s", rawURL, reason)
+		}
+	}
+	return stats, nil
+}
+
+// Report posts the newly dead links to the report room, if one is set.
+func (j *Job) Report(ctx context.Context, dead []string) error {
+	if j.cfg.ReportRoom == "" || len(dead) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(j.cfg.Messages.Text(string(j.cfg.ReportRoom), i18n.DeadLinksReport, i18n.Vars{"count": strconv.Itoa(len(dead))}))
+	for i, rawURL := range dead {
+		if i == maxReportLinks {
+			b.WriteString("\n…")
+			break
+		}
+		fmt.Fprintf(&b, "\n- %s", rawURL)
+	}
+	_, err := j.poster.SendNotice(ctx, j.cfg.ReportRoom, b.String())
+	return err
+}
+
+func (j *Job) logf(format string, args ...any) {
+	if j.logger != nil {
+		j.logger.Printf(format, args...)
+	}
+}
+
+// Check asks whether rawURL still exists with a HEAD request, falling back
+// to GET for servers that reject HEAD. 404, 410 and unknown hosts count as
+// dead; timeouts and server errors return ErrUnknown.
+func Check(ctx context.Context, client *http.Client, rawURL string) (reason string, dead bool, err error) {
+	status, err := request(ctx, client, http.MethodHead, rawURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = request(ctx, client, http.MethodGet, rawURL)
+	}
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "host not found", true, nil
+		}
+		return "", false, fmt.Errorf("%w: %v", ErrUnknown, err)
+	}
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return fmt.Sprintf("HTTP %d", status), true, nil
+	case status >= 500 || status == http.StatusTooManyRequests:
+		return "", false, fmt.Errorf("%w: HTTP %d", ErrUnknown, status)
+	}
+	return "", false, nil
+}
+
+func request(ctx context.Context, client *http.Client, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
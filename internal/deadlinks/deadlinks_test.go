@@ -0,0 +1,145 @@
+package deadlinks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+type memoryStore struct {
+	links   []string
+	checks  map[string]storage.LinkCheck
+	touched []string
+}
+
+func (m *memoryStore) LinksToCheck(context.Context, time.Time, int) ([]string, error) {
+	return m.links, nil
+}
+
+func (m *memoryStore) RecordLinkCheck(_ context.Context, check storage.LinkCheck) (storage.LinkCheck, error) {
+	if m.checks == nil {
+		m.checks = make(map[string]storage.LinkCheck)
+	}
+	prev, ok := m.checks[check.URL]
+	switch {
+	case !check.Dead:
+		check.DeadSince = time.Time{}
+	case ok && prev.Dead:
+		check.DeadSince = prev.DeadSince
+	default:
+		check.DeadSince = check.CheckedAt
+	}
+	m.checks[check.URL] = check
+	return check, nil
+}
+
+func (m *memoryStore) MarkLinkChecked(_ context.Context, rawURL string, _ time.Time) error {
+	m.touched = append(m.touched, rawURL)
+	return nil
+}
+
+type fakePoster struct {
+	rooms  []id.RoomID
+	bodies []string
+}
+
+func (f *fakePoster) SendNotice(_ context.Context, roomID id.RoomID, body string) (id.EventID, error) {
+	f.rooms = append(f.rooms, roomID)
+	f.bodies = append(f.bodies, body)
+	return "$report", nil
+}
+
+func TestCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		dead    bool
+		unknown bool
+	}{
+		{path: "/ok"},
+		{path: "/no-head"},
+		{path: "/gone", dead: true},
+		{path: "/missing", dead: true},
+		{path: "/broken", unknown: true},
+	}
+	for _, tt := range tests {
+		reason, dead, err := Check(context.Background(), srv.Client(), srv.URL+tt.path)
+		if tt.unknown {
+			if !errors.Is(err, ErrUnknown) {
+				t.Fatalf("Check(%s) err = %v, want ErrUnknown", tt.path, err)
+			}
+			continue
+		}
+		if err != nil || dead != tt.dead {
+			t.Fatalf("Check(%s) = %q, %v, %v; want dead=%v", tt.path, reason, dead, err, tt.dead)
+		}
+	}
+}
+
+func TestRunOnceReportsNewlyDeadLinks(t *testing.T) {
+	store := &memoryStore{links: []string{"https://a.example", "https://b.example", "https://c.example"}}
+	results := map[string]error{"https://c.example": ErrUnknown}
+	dead := map[string]bool{"https://a.example": true}
+	poster := &fakePoster{}
+	job, err := New(Config{MaxAge: time.Hour, ReportRoom: "!admins:example.org"}, Deps{
+		Store: store,
+		Check: func(_ context.Context, rawURL string) (string, bool, error) {
+			if dead[rawURL] {
+				return "HTTP 404", true, nil
+			}
+			return "", false, results[rawURL]
+		},
+		Poster: poster,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	job.now = func() time.Time { return now }
+
+	stats, err := job.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if stats.Checked != 3 || stats.Unknown != 1 || !reflect.DeepEqual(stats.Dead, []string{"https://a.example"}) {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	if !reflect.DeepEqual(store.touched, []string{"https://c.example"}) {
+		t.Fatalf("expected inconclusive check to only be noted, got %#v", store.touched)
+	}
+	if err := job.Report(context.Background(), stats.Dead); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if len(poster.bodies) != 1 || poster.rooms[0] != "!admins:example.org" || poster.bodies[0] != "Found 1 dead links:\n- https://a.example" {
+		t.Fatalf("unexpected report: %#v", poster.bodies)
+	}
+
+	// A link that stays dead is not reported again.
+	now = now.Add(2 * time.Hour)
+	stats, _ = job.RunOnce(context.Background())
+	if len(stats.Dead) != 0 {
+		t.Fatalf("expected no newly dead links, got %#v", stats.Dead)
+	}
+}
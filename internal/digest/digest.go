@@ -0,0 +1,423 @@
+// Package digest posts a summary of each configured room's last day of
+// messages daily at a fixed local time or on a cron schedule, optionally
+// pinning it.
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// Window is how far back each digest reaches.
+	Window = 24 * time.Hour
+	// DefaultMaxMessages bounds how much history a digest summarizes.
+	DefaultMaxMessages = 200
+	// teaserTopics is how many summary lines the teaser of an attached
+	// digest shows.
+	teaserTopics = 3
+	fileMimeType = "text/markdown"
+	// summaryTimeFormat formats the time range in indexed digest titles.
+	summaryTimeFormat = "2006-01-02 15:04 MST"
+	// pinStateKey prefixes the bot_state key remembering each room's pin.
+	pinStateKey = "digest_pin:"
+	// positionStateKey prefixes the bot_state key remembering the newest
+	// message each room's last digest covered.
+	positionStateKey = "digest_position:"
+)
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type HistoryReader interface {
+	GetRecentTextMessages(ctx context.Context, roomID id.RoomID, since time.Time, max int) ([]matrix.RoomMessage, error)
+}
+
+type Summarizer interface {
+	Summarize(ctx context.Context, messages []matrix.RoomMessage) (string, error)
+}
+
+type Poster interface {
+	SendFormattedNotice(ctx context.Context, roomID id.RoomID, body, formatted string) (id.EventID, error)
+	PinEvent(ctx context.Context, roomID id.RoomID, eventID, unpin id.EventID) error
+}
+
+// FilePoster is implemented by posters that can upload files. Digests
+// longer than Config.AttachOver are only attached when Poster is one.
+type FilePoster interface {
+	SendFile(ctx context.Context, roomID id.RoomID, file matrix.File) (id.EventID, error)
+}
+
+// Indexer adds documents to the search index.
+type Indexer interface {
+	IndexContent(ctx context.Context, rawURL string, content extractor.Result) error
+}
+
+// RoomIndexer is implemented by Indexers whose index is split per room; a
+// digest's summary is then added to its room's part.
+type RoomIndexer interface {
+	IndexRoomContent(ctx context.Context, roomID id.RoomID, rawURL string, content extractor.Result) error
+}
+
+// StateStore persists the last pinned digest per room, so a restart still
+// replaces the previous pin instead of piling up new ones, and the newest
+// message each digest covered, so the next one starts right after it.
+type StateStore interface {
+	PutBotState(ctx context.Context, key, value string) error
+	GetBotState(ctx context.Context, key string) (string, error)
+}
+
+// Room schedules one room's digest. At is the offset from local midnight;
+// Schedule, when set, replaces it with a cron schedule.
+type Room struct {
+	RoomID   id.RoomID
+	At       time.Duration
+	Schedule schedule.Schedule
+	Pin      bool
+}
+
+type Config struct {
+	Rooms []Room
+	// Location is the time zone At is interpreted in. Nil means time.Local.
+	Location    *time.Location
+	MaxMessages int
+	// BotUserID's own messages are left out of digests.
+	BotUserID id.UserID
+	Messages  *i18n.Catalog
+	// Templates render the summary inside the digest. Nil leaves it as is.
+	Templates *render.Templates
+	// AttachOver is the length in characters above which a digest is
+	// uploaded as a markdown file with a short teaser instead of posted as
+	// a notice. Zero always posts a notice.
+	AttachOver int
+}
+
+// RoomDocumentStore records the digest summaries indexed from each room, so
+// retention can expire them.
+type RoomDocumentStore interface {
+	RecordRoomDocument(ctx context.Context, roomID id.RoomID, rawURL string, indexedAt time.Time) error
+}
+
+// Deps are the collaborators used by Scheduler. State is optional.
+// Indexer, when set, indexes every posted digest's summary, and
+// RoomDocuments records it.
+type Deps struct {
+	History       HistoryReader
+	Summarizer    Summarizer
+	Poster        Poster
+	State         StateStore
+	Indexer       Indexer
+	RoomDocuments RoomDocumentStore
+	Logger        Logger
+}
+
+// Scheduler posts digests for its rooms until its context ends.
+type Scheduler struct {
+	cfg        Config
+	history    HistoryReader
+	summarizer Summarizer
+	poster     Poster
+	state      StateStore
+	indexer    Indexer
+	roomDocs   RoomDocumentStore
+	logger     Logger
+	now        func() time.Time
+	after      func(d time.Duration) <-chan time.Time
+}
+
+func New(cfg Config, deps Deps) (*Scheduler, error) {
+	if deps.History == nil {
+		return nil, errors.New("history reader is required")
+	}
+	if deps.Summarizer == nil {
+		return nil, errors.New("summarizer is required")
+	}
+	if deps.Poster == nil {
+		return nil, errors.New("poster is required")
+	}
+	for _, room := range cfg.Rooms {
+		if room.Schedule == nil && (room.At < 0 || room.At >= 24*time.Hour) {
+			return nil, fmt.Errorf("room %s: digest time must be within the day", room.RoomID)
+		}
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = DefaultMaxMessages
+	}
+	return &Scheduler{
+		cfg:        cfg,
+		history:    deps.History,
+		summarizer: deps.Summarizer,
+		poster:     deps.Poster,
+		state:      deps.State,
+		indexer:    deps.Indexer,
+		roomDocs:   deps.RoomDocuments,
+		logger:     deps.Logger,
+		now:        time.Now,
+		after:      time.After,
+	}, nil
+}
+
+// Run posts each room's digest at its scheduled time until ctx is done.
+// Digests missed while the bot was down are not caught up.
+func (s *Scheduler) Run(ctx context.Context) {
+	if len(s.cfg.Rooms) == 0 {
+		return
+	}
+	for {
+		next, rooms := s.nextDue(s.now())
+		if next.IsZero() {
+			s.logf("no digests scheduled")
+			return
+		}
+		s.logf("next digest at %s rooms=%d", next.Format(time.RFC3339), len(rooms))
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.after(next.Sub(s.now())):
+		}
+		for _, room := range rooms {
+			if err := s.Post(ctx, room); err != nil {
+				s.logf("digest failed room=%s err=%v", room.RoomID, err)
+			}
+		}
+	}
+}
+
+// Post summarizes the messages since the room's previous digest, or the
+// last day if it has none, and posts it now. Rooms without messages get no
+// digest.
+func (s *Scheduler) Post(ctx context.Context, room Room) error {
+	now := s.now()
+	since := now.Add(-Window)
+	last, resume := s.position(ctx, room.RoomID)
+	if resume {
+		since = last.Timestamp
+	}
+	messages, err := s.history.GetRecentTextMessages(ctx, room.RoomID, since, s.cfg.MaxMessages)
+	if err != nil {
+		return fmt.Errorf("fetch history: %w", err)
+	}
+	if resume {
+		messages = last.After(messages)
+	}
+	messages = s.withoutBot(messages)
+	if len(messages) == 0 {
+		s.logf("digest skipped room=%s: no messages", room.RoomID)
+		return nil
+	}
+
+	summary, err := s.summarizer.Summarize(ctx, messages)
+	if err != nil {
+		return fmt.Errorf("summarize: %w", err)
+	}
+	if strings.TrimSpace(summary) == "" {
+		s.logf("digest skipped room=%s: empty summary", room.RoomID)
+		return nil
+	}
+
+	if rendered, err := s.cfg.Templates.Summary(render.Summary{Summary: summary, Messages: len(messages)}); err != nil {
+		s.logf("digest summary template failed room=%s err=%v", room.RoomID, err)
+	} else if rendered != "" {
+		summary = rendered
+	}
+	date := now.In(s.cfg.Location).Format("2006-01-02")
+	body := s.cfg.Messages.Text(string(room.RoomID), i18n.Digest, i18n.Vars{
+		"date":    date,
+		"summary": summary,
+	})
+	eventID, err := s.attach(ctx, room.RoomID, date, body, summary, messages)
+	if eventID == "" {
+		if err != nil {
+			s.logf("digest attachment failed room=%s err=%v; posting it as a notice", room.RoomID, err)
+		}
+		eventID, err = s.poster.SendFormattedNotice(ctx, room.RoomID, body, matrix.FormatHTML(body, matrix.Senders(messages)))
+		if err != nil {
+			return fmt.Errorf("post digest: %w", err)
+		}
+	}
+	s.logf("digest posted room=%s event=%s messages=%d", room.RoomID, eventID, len(messages))
+	s.savePosition(ctx, room.RoomID, matrix.PositionAfter(messages))
+	s.index(ctx, room.RoomID, summary, messages)
+
+	if room.Pin {
+		return s.pin(ctx, room.RoomID, eventID)
+	}
+	return nil
+}
+
+// attach uploads body as a markdown file captioned with the first lines of
+// summary when body is longer than AttachOver. It returns an empty event ID
+// when the digest should be posted as a notice instead.
+func (s *Scheduler) attach(ctx context.Context, roomID id.RoomID, date, body, summary string, messages []matrix.RoomMessage) (id.EventID, error) {
+	files, ok := s.poster.(FilePoster)
+	if !ok || s.cfg.AttachOver <= 0 || utf8.RuneCountInString(body) <= s.cfg.AttachOver {
+		return "", nil
+	}
+	var topics []string
+	for _, line := range strings.Split(summary, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			topics = append(topics, line)
+		}
+	}
+	teaser := s.cfg.Messages.Text(string(roomID), i18n.DigestAttached, i18n.Vars{
+		"date":    date,
+		"preview": strings.Join(topics[:min(len(topics), teaserTopics)], "\n"),
+	})
+	return files.SendFile(ctx, roomID, matrix.File{
+		Name:             "digest-" + date + ".md",
+		MimeType:         fileMimeType,
+		Data:             []byte(body + "\n"),
+		Caption:          teaser,
+		FormattedCaption: matrix.MentionPills(teaser, matrix.Senders(messages)),
+	})
+}
+
+// index adds the digest's summary to the search index under
+// matrix.SummaryURL. Failures are only logged.
+func (s *Scheduler) index(ctx context.Context, roomID id.RoomID, summary string, messages []matrix.RoomMessage) {
+	if s.indexer == nil {
+		return
+	}
+	first, last := matrix.MessageSpan(messages)
+	title := s.cfg.Messages.Text(string(roomID), i18n.SummaryTitle, i18n.Vars{
+		"from": first.Timestamp.In(s.cfg.Location).Format(summaryTimeFormat),
+		"to":   last.Timestamp.In(s.cfg.Location).Format(summaryTimeFormat),
+	})
+	rawURL := matrix.SummaryURL(messages)
+	content := extractor.Result{Title: title, Text: summary}
+	var err error
+	if indexer, ok := s.indexer.(RoomIndexer); ok {
+		err = indexer.IndexRoomContent(ctx, roomID, rawURL, content)
+	} else {
+		err = s.indexer.IndexContent(ctx, rawURL, content)
+	}
+	if err != nil {
+		s.logf("index digest failed room=%s err=%v", roomID, err)
+		return
+	}
+	if s.roomDocs == nil {
+		return
+	}
+	if err := s.roomDocs.RecordRoomDocument(ctx, roomID, rawURL, s.now()); err != nil {
+		s.logf("record digest document failed room=%s err=%v", roomID, err)
+	}
+}
+
+// position returns the newest message the room's previous digest covered.
+func (s *Scheduler) position(ctx context.Context, roomID id.RoomID) (matrix.SummaryPosition, bool) {
+	if s.state == nil {
+		return matrix.SummaryPosition{}, false
+	}
+	value, err := s.state.GetBotState(ctx, positionStateKey+string(roomID))
+	if err != nil {
+		s.logf("load digest position room=%s err=%v", roomID, err)
+		return matrix.SummaryPosition{}, false
+	}
+	return matrix.ParseSummaryPosition(value)
+}
+
+// savePosition records pos as covered by the room's digests and as the
+// room's last summary, for "/catchmeup since last".
+func (s *Scheduler) savePosition(ctx context.Context, roomID id.RoomID, pos matrix.SummaryPosition) {
+	if s.state == nil {
+		return
+	}
+	for _, key := range []string{positionStateKey + string(roomID), matrix.SummaryPositionKey(roomID)} {
+		if err := s.state.PutBotState(ctx, key, pos.String()); err != nil {
+			s.logf("save digest position room=%s err=%v", roomID, err)
+		}
+	}
+}
+
+func (s *Scheduler) pin(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+	var previous id.EventID
+	if s.state != nil {
+		value, err := s.state.GetBotState(ctx, pinStateKey+string(roomID))
+		if err != nil {
+			s.logf("load previous digest pin room=%s err=%v", roomID, err)
+		}
+		previous = id.EventID(value)
+	}
+	if err := s.poster.PinEvent(ctx, roomID, eventID, previous); err != nil {
+		return fmt.Errorf("pin digest: %w", err)
+	}
+	if s.state != nil {
+		if err := s.state.PutBotState(ctx, pinStateKey+string(roomID), string(eventID)); err != nil {
+			s.logf("save digest pin room=%s err=%v", roomID, err)
+		}
+	}
+	return nil
+}
+
+// nextDue returns the earliest upcoming digest time after now and every room
+// scheduled for it. The time is zero when no room has a digest ahead.
+func (s *Scheduler) nextDue(now time.Time) (time.Time, []Room) {
+	var next time.Time
+	var due []Room
+	for _, room := range s.cfg.Rooms {
+		var at time.Time
+		if room.Schedule != nil {
+			at = room.Schedule.Next(now)
+		} else {
+			at = NextRun(now, room.At, s.cfg.Location)
+		}
+		switch {
+		case at.IsZero():
+			// The room's schedule never runs again.
+		case next.IsZero() || at.Before(next):
+			next, due = at, []Room{room}
+		case at.Equal(next):
+			due = append(due, room)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].RoomID < due[j].RoomID })
+	return next, due
+}
+
+func (s *Scheduler) withoutBot(messages []matrix.RoomMessage) []matrix.RoomMessage {
+	if s.cfg.BotUserID == "" {
+		return messages
+	}
+	out := messages[:0:0]
+	for _, msg := range messages {
+		if msg.Sender != s.cfg.BotUserID {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+func (s *Scheduler) logf(format string, args ...any) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// NextRun returns the first time strictly after now whose wall clock in loc
+// reads at past midnight. Times skipped by a DST change run at the shifted
+// time time.Date normalizes them to.
+func NextRun(now time.Time, at time.Duration, loc *time.Location) time.Time {
+	local := now.In(loc)
+	hour, minute := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !next.After(local) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, loc)
+	}
+	return next
+}
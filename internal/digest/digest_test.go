@@ -0,0 +1,320 @@
+package digest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/render"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeHistory struct {
+	messages []matrix.RoomMessage
+	since    time.Time
+	max      int
+}
+
+func (f *fakeHistory) GetRecentTextMessages(_ context.Context, _ id.RoomID, since time.Time, max int) ([]matrix.RoomMessage, error) {
+	f.since, f.max = since, max
+	return f.messages, nil
+}
+
+type fakeSummarizer struct {
+	got []matrix.RoomMessage
+}
+
+func (f *fakeSummarizer) Summarize(_ context.Context, messages []matrix.RoomMessage) (string, error) {
+	f.got = messages
+	return "- release planning with @alice:example.org", nil
+}
+
+type pin struct{ event, unpin id.EventID }
+
+type fakePoster struct {
+	bodies    []string
+	formatted []string
+	pins      []pin
+}
+
+func (f *fakePoster) SendFormattedNotice(_ context.Context, _ id.RoomID, body, formatted string) (id.EventID, error) {
+	f.bodies = append(f.bodies, body)
+	f.formatted = append(f.formatted, formatted)
+	return id.EventID("$digest" + string(rune('0'+len(f.bodies)))), nil
+}
+
+func (f *fakePoster) PinEvent(_ context.Context, _ id.RoomID, eventID, unpin id.EventID) error {
+	f.pins = append(f.pins, pin{eventID, unpin})
+	return nil
+}
+
+type fakeFilePoster struct {
+	fakePoster
+	files []matrix.File
+}
+
+func (f *fakeFilePoster) SendFile(_ context.Context, _ id.RoomID, file matrix.File) (id.EventID, error) {
+	f.files = append(f.files, file)
+	return "$file", nil
+}
+
+type fakeIndexer map[string]extractor.Result
+
+func (f fakeIndexer) IndexContent(_ context.Context, rawURL string, content extractor.Result) error {
+	f[rawURL] = content
+	return nil
+}
+
+type memoryState map[string]string
+
+func (m memoryState) PutBotState(_ context.Context, key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryState) GetBotState(_ context.Context, key string) (string, error) {
+	return m[key], nil
+}
+
+func TestNextRun(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	now := time.Date(2026, 3, 1, 6, 30, 0, 0, time.UTC) // 08:30 local
+
+	if got, want := NextRun(now, 9*time.Hour, loc), time.Date(2026, 3, 1, 9, 0, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("NextRun(later today) = %s, want %s", got, want)
+	}
+	if got, want := NextRun(now, 8*time.Hour+30*time.Minute, loc), time.Date(2026, 3, 2, 8, 30, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("NextRun(now) = %s, want tomorrow %s", got, want)
+	}
+}
+
+func TestPostSummarizesAndReplacesPin(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{Sender: "@alice:example.org", Body: "shall we plan the release?"},
+		{Sender: "@bot:example.org", Body: "Indexed https://example.org"},
+	}}
+	summarizer := &fakeSummarizer{}
+	poster := &fakePoster{}
+	state := memoryState{}
+	s, err := New(Config{BotUserID: "@bot:example.org", Location: time.UTC}, Deps{
+		History: history, Summarizer: summarizer, Poster: poster, State: state,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.now = func() time.Time { return now }
+
+	room := Room{RoomID: "!room:example.org", At: 9 * time.Hour, Pin: true}
+	for i := 0; i < 2; i++ {
+		if err := s.Post(context.Background(), room); err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+	}
+
+	if !history.since.Equal(now.Add(-Window)) || history.max != DefaultMaxMessages {
+		t.Fatalf("history since=%s max=%d", history.since, history.max)
+	}
+	if len(summarizer.got) != 1 || summarizer.got[0].Sender != "@alice:example.org" {
+		t.Fatalf("summarized %#v, want bot messages dropped", summarizer.got)
+	}
+	if len(poster.bodies) != 2 || !strings.Contains(poster.bodies[0], "2026-03-01") || !strings.Contains(poster.bodies[0], "- release planning") {
+		t.Fatalf("posted %#v", poster.bodies)
+	}
+	if !strings.Contains(poster.formatted[0], `<a href="https://matrix.to/#/@alice:example.org">@alice:example.org</a>`) {
+		t.Fatalf("formatted digest %q lacks a pill for the sender", poster.formatted[0])
+	}
+	if want := []pin{{"$digest1", ""}, {"$digest2", "$digest1"}}; !reflect.DeepEqual(poster.pins, want) {
+		t.Fatalf("pins = %#v, want %#v", poster.pins, want)
+	}
+}
+
+func TestPostResumesAfterPreviousDigest(t *testing.T) {
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{RoomID: "!room:example.org", EventID: "$3", Sender: "@bob:example.org", Body: "ship it", Timestamp: at.Add(time.Hour)},
+		{RoomID: "!room:example.org", EventID: "$2", Sender: "@alice:example.org", Body: "yesterday's topic", Timestamp: at},
+	}}
+	summarizer := &fakeSummarizer{}
+	state := memoryState{positionStateKey + "!room:example.org": matrix.SummaryPosition{EventID: "$2", Timestamp: at}.String()}
+	s, err := New(Config{Location: time.UTC}, Deps{History: history, Summarizer: summarizer, Poster: &fakePoster{}, State: state})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.now = func() time.Time { return at.Add(72 * time.Hour) }
+
+	if err := s.Post(context.Background(), Room{RoomID: "!room:example.org"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if !history.since.Equal(at) {
+		t.Fatalf("history since = %s, want the previous digest's position", history.since)
+	}
+	if len(summarizer.got) != 1 || summarizer.got[0].EventID != "$3" {
+		t.Fatalf("summarized %#v, want only the new message", summarizer.got)
+	}
+	want := matrix.SummaryPosition{EventID: "$3", Timestamp: at.Add(time.Hour)}.String()
+	if state[positionStateKey+"!room:example.org"] != want || state[matrix.SummaryPositionKey("!room:example.org")] != want {
+		t.Fatalf("saved positions %#v, want %q", state, want)
+	}
+}
+
+func TestPostIndexesSummary(t *testing.T) {
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	history := &fakeHistory{messages: []matrix.RoomMessage{
+		{RoomID: "!room:example.org", EventID: "$2", Sender: "@bob:example.org", Body: "agreed", Timestamp: at.Add(30 * time.Minute)},
+		{RoomID: "!room:example.org", EventID: "$1", Sender: "@alice:example.org", Body: "shall we plan the release?", Timestamp: at},
+	}}
+	indexer := fakeIndexer{}
+	s, err := New(Config{Location: time.UTC}, Deps{History: history, Summarizer: &fakeSummarizer{}, Poster: &fakePoster{}, Indexer: indexer})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.now = func() time.Time { return at.Add(time.Hour) }
+
+	if err := s.Post(context.Background(), Room{RoomID: "!room:example.org"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	got, ok := indexer["https://matrix.to/#/%21room:example.org/$1?summary=20260301T0800Z..20260301T0830Z"]
+	if !ok || got.Text != "- release planning with @alice:example.org" || !strings.Contains(got.Title, "2026-03-01 08:00 UTC") {
+		t.Fatalf("indexed %#v", indexer)
+	}
+}
+
+func TestPostAttachesLongDigests(t *testing.T) {
+	history := &fakeHistory{messages: []matrix.RoomMessage{{Sender: "@alice:example.org", Body: "shall we plan the release?"}}}
+	poster := &fakeFilePoster{}
+	s, err := New(Config{Location: time.UTC, AttachOver: 20}, Deps{History: history, Summarizer: &fakeSummarizer{}, Poster: poster})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.now = func() time.Time { return time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC) }
+
+	room := Room{RoomID: "!room:example.org", Pin: true}
+	if err := s.Post(context.Background(), room); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if len(poster.bodies) != 0 || len(poster.files) != 1 {
+		t.Fatalf("expected one attachment and no notice, got files=%d notices=%#v", len(poster.files), poster.bodies)
+	}
+	file := poster.files[0]
+	if file.Name != "digest-2026-03-01.md" || !strings.Contains(string(file.Data), "- release planning") {
+		t.Fatalf("unexpected attachment %q: %q", file.Name, file.Data)
+	}
+	if !strings.Contains(file.Caption, "attached") || !strings.Contains(file.Caption, "- release planning") {
+		t.Fatalf("unexpected teaser %q", file.Caption)
+	}
+	if !strings.Contains(file.FormattedCaption, `href="https://matrix.to/#/@alice:example.org"`) {
+		t.Fatalf("formatted teaser %q lacks a pill for the sender", file.FormattedCaption)
+	}
+	if want := []pin{{"$file", ""}}; !reflect.DeepEqual(poster.pins, want) {
+		t.Fatalf("pins = %#v, want %#v", poster.pins, want)
+	}
+
+	s.cfg.AttachOver = 1000
+	if err := s.Post(context.Background(), room); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if len(poster.bodies) != 1 || len(poster.files) != 1 {
+		t.Fatalf("expected a short digest as a notice, got files=%d notices=%d", len(poster.files), len(poster.bodies))
+	}
+}
+
+func TestPostRendersSummaryTemplate(t *testing.T) {
+	templates, err := render.New("", "{{.Messages}} messages:\n{{.Summary}}", "")
+	if err != nil {
+		t.Fatalf("render.New() error = %v", err)
+	}
+	history := &fakeHistory{messages: []matrix.RoomMessage{{Sender: "@alice:example.org", Body: "hi"}}}
+	poster := &fakePoster{}
+	s, err := New(Config{Templates: templates}, Deps{History: history, Summarizer: &fakeSummarizer{}, Poster: poster})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.Post(context.Background(), Room{RoomID: "!room:example.org"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if len(poster.bodies) != 1 || !strings.Contains(poster.bodies[0], "1 messages:\n- release planning") {
+		t.Fatalf("posted %#v", poster.bodies)
+	}
+}
+
+func TestPostSkipsQuietRooms(t *testing.T) {
+	poster := &fakePoster{}
+	s, err := New(Config{}, Deps{History: &fakeHistory{}, Summarizer: &fakeSummarizer{}, Poster: poster})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.Post(context.Background(), Room{RoomID: "!room:example.org"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if len(poster.bodies) != 0 {
+		t.Fatalf("posted %#v for a room without messages", poster.bodies)
+	}
+}
+
+func TestRunPostsRoomsDueTogether(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	poster := &fakePoster{}
+	history := &fakeHistory{messages: []matrix.RoomMessage{{Sender: "@alice:example.org", Body: "hi"}}}
+	s, err := New(Config{Location: time.UTC, Rooms: []Room{
+		{RoomID: "!b:example.org", At: 9 * time.Hour},
+		{RoomID: "!a:example.org", At: 9 * time.Hour},
+		{RoomID: "!c:example.org", At: 18 * time.Hour},
+	}}, Deps{History: history, Summarizer: &fakeSummarizer{}, Poster: poster})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var waits []time.Duration
+	s.now = func() time.Time { return now }
+	s.after = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		if len(waits) > 1 {
+			cancel()
+			return nil
+		}
+		now = now.Add(d)
+		ch := make(chan time.Time, 1)
+		ch <- now
+		return ch
+	}
+	s.Run(ctx)
+
+	if len(waits) != 2 || waits[0] != time.Hour || waits[1] != 9*time.Hour {
+		t.Fatalf("waits = %v, want 1h then 9h", waits)
+	}
+	if len(poster.bodies) != 2 {
+		t.Fatalf("posted %d digests at 09:00, want 2", len(poster.bodies))
+	}
+}
+
+func TestNextDueUsesCronSchedules(t *testing.T) {
+	weekdays, err := schedule.Parse("0 9 * * mon-fri", time.UTC)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	s, err := New(Config{Location: time.UTC, Rooms: []Room{
+		{RoomID: "!daily:example.org", At: 18 * time.Hour},
+		{RoomID: "!weekdays:example.org", Schedule: weekdays},
+	}}, Deps{History: &fakeHistory{}, Summarizer: &fakeSummarizer{}, Poster: &fakePoster{}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	saturday := time.Date(2026, 3, 7, 8, 0, 0, 0, time.UTC)
+	next, rooms := s.nextDue(saturday)
+	if want := time.Date(2026, 3, 7, 18, 0, 0, 0, time.UTC); !next.Equal(want) || len(rooms) != 1 || rooms[0].RoomID != "!daily:example.org" {
+		t.Fatalf("nextDue(Saturday) = %s %v, want the daily room at %s", next, rooms, want)
+	}
+	monday := time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)
+	next, rooms = s.nextDue(monday)
+	if want := time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC); !next.Equal(want) || len(rooms) != 1 || rooms[0].RoomID != "!weekdays:example.org" {
+		t.Fatalf("nextDue(Monday) = %s %v, want the weekday room at %s", next, rooms, want)
+	}
+}
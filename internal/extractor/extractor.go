@@ -3,6 +3,7 @@ package extractor
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,23 +14,62 @@ import (
 
 const defaultMaxBodyBytes int64 = 2 << 20
 
+// ErrNotModified is returned by ExtractIfModified when the server reports the
+// page unchanged since the given validators.
+var ErrNotModified = errors.New("not modified")
+
+// ErrTooLarge is returned when a page is larger than the extractor reads.
+var ErrTooLarge = errors.New("response body too large")
+
+// StatusError is returned when a page is fetched with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetch URL returned status %d", e.StatusCode)
+}
+
 type Result struct {
 	Title string
 	Text  string
+	// Description is the page's meta description, if it has one.
+	Description string
+	// Validators are the response's cache validators, for a later
+	// ExtractIfModified.
+	Validators Validators
+}
+
+// Validators are HTTP cache validators identifying a fetched version of a page.
+type Validators struct {
+	ETag         string
+	LastModified string
 }
 
-func makeHTTPRequest(ctx context.Context, client *http.Client, rawURL string, acceptHeader string) (*http.Response, error) {
+func makeHTTPRequest(ctx context.Context, client *http.Client, rawURL string, acceptHeader string, since Validators) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Accept", acceptHeader)
-	req.Header.Set("User-Agent", "hister-element-bot/1.0")
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	if since.ETag != "" {
+		req.Header.Set("If-None-Match", since.ETag)
+	}
+	if since.LastModified != "" {
+		req.Header.Set("If-Modified-Since", since.LastModified)
+	}
 
 	return client.Do(req)
 }
 
 func ExtractFromURL(ctx context.Context, httpClient *http.Client, rawURL string) (Result, error) {
+	return ExtractIfModified(ctx, httpClient, rawURL, Validators{})
+}
+
+// ExtractIfModified is ExtractFromURL with a conditional request: it returns
+// ErrNotModified if the server answers 304 for since.
+func ExtractIfModified(ctx context.Context, httpClient *http.Client, rawURL string, since Validators) (Result, error) {
 	rawURL = strings.TrimSpace(rawURL)
 	if rawURL == "" {
 		return Result{}, fmt.Errorf("empty URL")
@@ -40,20 +80,24 @@ func ExtractFromURL(ctx context.Context, httpClient *http.Client, rawURL string)
 		client = http.DefaultClient
 	}
 
-	resp, err := makeHTTPRequest(ctx, client, rawURL, "text/markdown")
-	if err != nil || (resp != nil && (resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices)) {
+	resp, err := makeHTTPRequest(ctx, client, rawURL, "text/markdown", since)
+	if err != nil || (resp != nil && resp.StatusCode != http.StatusNotModified && (resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices)) {
 		if resp != nil && resp.Body != nil {
 			resp.Body.Close()
 		}
-		resp, err = makeHTTPRequest(ctx, client, rawURL, "text/html,application/xhtml+xml")
+		resp, err = makeHTTPRequest(ctx, client, rawURL, "text/html,application/xhtml+xml", since)
 		if err != nil {
 			return Result{}, fmt.Errorf("fetch URL: %w", err)
 		}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return Result{Validators: since}, ErrNotModified
+	}
+
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		return Result{}, fmt.Errorf("fetch URL returned status %d", resp.StatusCode)
+		return Result{}, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	limited := io.LimitReader(resp.Body, defaultMaxBodyBytes+1)
@@ -62,10 +106,18 @@ func ExtractFromURL(ctx context.Context, httpClient *http.Client, rawURL string)
 		return Result{}, fmt.Errorf("read response body: %w", err)
 	}
 	if int64(len(body)) > defaultMaxBodyBytes {
-		return Result{}, fmt.Errorf("response body too large")
+		return Result{}, ErrTooLarge
 	}
 
-	return ExtractFromReader(bytes.NewReader(body))
+	result, err := ExtractFromReader(bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	result.Validators = Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return result, nil
 }
 
 func ExtractFromReader(r io.Reader) (Result, error) {
@@ -88,11 +140,45 @@ func ExtractFromReader(r io.Reader) (Result, error) {
 	}
 
 	return Result{
-		Title: title,
-		Text:  bodyText,
+		Title:       title,
+		Text:        bodyText,
+		Description: normalizeWhitespace(metaContent(doc, "description", "og:description")),
 	}, nil
 }
 
+// metaContent returns the content of the first <meta> element whose name or
+// property is one of keys, preferring earlier keys.
+func metaContent(root *html.Node, keys ...string) string {
+	found := make(map[string]string, len(keys))
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "meta") {
+			var key, content string
+			for _, attr := range n.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "name", "property":
+					key = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "content":
+					content = attr.Val
+				}
+			}
+			if _, seen := found[key]; key != "" && !seen && strings.TrimSpace(content) != "" {
+				found[key] = content
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+	for _, key := range keys {
+		if content, ok := found[key]; ok {
+			return content
+		}
+	}
+	return ""
+}
+
 func findFirstElement(root *html.Node, tag string) *html.Node {
 	if root == nil {
 		return nil
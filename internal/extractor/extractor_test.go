@@ -2,6 +2,7 @@ package extractor
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -41,6 +42,30 @@ func TestExtractFromURLExtractsTitleAndBodyText(t *testing.T) {
 	}
 }
 
+func TestExtractFromReaderDescription(t *testing.T) {
+	t.Parallel()
+
+	got, err := ExtractFromReader(strings.NewReader(`<html><head>
+<meta property="og:description" content="Open Graph text">
+<meta name="Description" content="  Plain
+  description ">
+</head><body>Body</body></html>`))
+	if err != nil {
+		t.Fatalf("ExtractFromReader() error = %v", err)
+	}
+	if got.Description != "Plain description" {
+		t.Fatalf("description = %q, want meta description over og:description", got.Description)
+	}
+
+	got, err = ExtractFromReader(strings.NewReader(`<html><head><meta property="og:description" content="Open Graph text"></head></html>`))
+	if err != nil {
+		t.Fatalf("ExtractFromReader() error = %v", err)
+	}
+	if got.Description != "Open Graph text" {
+		t.Fatalf("description = %q, want og:description fallback", got.Description)
+	}
+}
+
 func TestExtractFromURLReturnsHTTPError(t *testing.T) {
 	t.Parallel()
 
@@ -83,3 +108,59 @@ func TestExtractFromURLFallsBackToHTMLOnMarkdownHTTPError(t *testing.T) {
 		t.Fatalf("ExtractFromURL() text = %q, want %q", got.Text, "Fallback body")
 	}
 }
+
+func TestExtractIfModifiedSendsValidators(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Sun, 01 Mar 2026 09:00:00 GMT")
+		_, _ = w.Write([]byte(`<html><head><title>T</title></head><body>Body</body></html>`))
+	}))
+	defer srv.Close()
+
+	first, err := ExtractFromURL(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("ExtractFromURL() error = %v", err)
+	}
+	if first.Validators.ETag != `"v1"` || first.Validators.LastModified == "" {
+		t.Fatalf("validators = %#v", first.Validators)
+	}
+
+	if _, err := ExtractIfModified(context.Background(), srv.Client(), srv.URL, first.Validators); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("ExtractIfModified() error = %v, want ErrNotModified", err)
+	}
+}
+
+func TestHeadersTransport(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		_, _ = w.Write([]byte("<html><head><title>T</title></head><body>text</body></html>"))
+	}))
+	defer srv.Close()
+
+	headers := Headers{UserAgent: "Mozilla/5.0 test", Sites: map[string]http.Header{
+		"127.0.0.1": {"Accept-Language": {"de"}, "Cookie": {"consent=yes"}},
+		"other.org": {"Cookie": {"leak=1"}},
+	}}
+	client := &http.Client{Transport: headers.Transport(nil)}
+	if _, err := ExtractFromURL(context.Background(), client, srv.URL); err != nil {
+		t.Fatalf("ExtractFromURL() error = %v", err)
+	}
+	if got.Get("User-Agent") != "Mozilla/5.0 test" || got.Get("Accept-Language") != "de" || got.Get("Cookie") != "consent=yes" {
+		t.Fatalf("request headers = %#v", got)
+	}
+
+	headers.Sites = map[string]http.Header{"example.org": {"X-A": {"1"}}, "docs.example.org": {"X-A": {"2"}}}
+	if h := headers.For("api.docs.example.org"); h.Get("X-A") != "2" {
+		t.Fatalf("For(subdomain) = %#v", h)
+	}
+	if h := headers.For("notexample.org"); h != nil {
+		t.Fatalf("For(unrelated) = %#v", h)
+	}
+}
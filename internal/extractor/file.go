@@ -0,0 +1,119 @@
+package extractor
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxFileBytes is the largest uploaded file ExtractFile reads, the same
+// limit as for fetched pages.
+const MaxFileBytes = defaultMaxBodyBytes
+
+// ErrUnsupportedFile is returned by ExtractFile for file types it cannot
+// read.
+var ErrUnsupportedFile = errors.New("unsupported file type")
+
+type fileKind int
+
+const (
+	fileUnsupported fileKind = iota
+	filePlain
+	fileMarkdown
+	fileHTML
+	filePDF
+)
+
+// SupportedFile reports whether ExtractFile can read a file with this name
+// and MIME type.
+func SupportedFile(name, mimeType string) bool {
+	return kindOf(name, mimeType) != fileUnsupported
+}
+
+// ExtractFile extracts the title and text of an uploaded plain text,
+// markdown, HTML or PDF file. The title falls back to the file name.
+func ExtractFile(name, mimeType string, data []byte) (Result, error) {
+	if int64(len(data)) > MaxFileBytes {
+		return Result{}, ErrTooLarge
+	}
+
+	var result Result
+	switch kindOf(name, mimeType) {
+	case filePlain:
+		if !utf8.Valid(data) {
+			return Result{}, ErrUnsupportedFile
+		}
+		result.Text = normalizeWhitespace(string(data))
+	case fileMarkdown:
+		if !utf8.Valid(data) {
+			return Result{}, ErrUnsupportedFile
+		}
+		result.Title = markdownTitle(string(data))
+		result.Text = normalizeWhitespace(string(data))
+	case fileHTML:
+		var err error
+		if result, err = ExtractFromReader(bytes.NewReader(data)); err != nil {
+			return Result{}, err
+		}
+	case filePDF:
+		var err error
+		if result, err = extractPDF(data); err != nil {
+			return Result{}, err
+		}
+	default:
+		return Result{}, ErrUnsupportedFile
+	}
+	if result.Title == "" {
+		result.Title = name
+	}
+	return result, nil
+}
+
+// kindOf picks the extractor by MIME type, then by extension for the
+// generic types clients often send.
+func kindOf(name, mimeType string) fileKind {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	switch mediaType {
+	case "text/plain":
+		if kind := kindByExtension(name); kind == fileMarkdown {
+			return kind
+		}
+		return filePlain
+	case "text/markdown", "text/x-markdown":
+		return fileMarkdown
+	case "text/html", "application/xhtml+xml":
+		return fileHTML
+	case "application/pdf":
+		return filePDF
+	case "", "application/octet-stream":
+		return kindByExtension(name)
+	}
+	return fileUnsupported
+}
+
+func kindByExtension(name string) fileKind {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".txt", ".text":
+		return filePlain
+	case ".md", ".markdown":
+		return fileMarkdown
+	case ".html", ".htm":
+		return fileHTML
+	case ".pdf":
+		return filePDF
+	}
+	return fileUnsupported
+}
+
+// markdownTitle returns the text of the first top-level heading.
+func markdownTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if heading, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+			return normalizeWhitespace(heading)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package extractor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testPDF(t *testing.T, content string) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString("1 0 obj\n<< /Title (Release \\(draft\\) notes) >>\nendobj\n")
+	pdf.WriteString("2 0 obj\n<< /Length 12 /Subtype /Image /Filter /DCTDecode >>\nstream\n(Not text) Tj\nendstream\nendobj\n")
+	fmt.Fprintf(&pdf, "3 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestExtractFilePDF(t *testing.T) {
+	data := testPDF(t, "BT /F1 12 Tf 72 712 Td (Ship the) Tj ( release) Tj T* [(on Fri) -10 (day) -300 (at 5) ] TJ ET\n"+
+		"BT <486578> Tj (\\101\\102) ' ET")
+
+	result, err := ExtractFile("notes.pdf", "application/pdf", data)
+	if err != nil {
+		t.Fatalf("ExtractFile() error = %v", err)
+	}
+	if result.Title != "Release (draft) notes" {
+		t.Fatalf("Title = %q", result.Title)
+	}
+	if want := "Ship the release on Friday at 5 Hex AB"; result.Text != want {
+		t.Fatalf("Text = %q, want %q", result.Text, want)
+	}
+}
+
+func TestExtractFileTextAndMarkdown(t *testing.T) {
+	result, err := ExtractFile("plan.md", "application/octet-stream", []byte("intro\n\n# Launch  plan\n\n- step one\n"))
+	if err != nil {
+		t.Fatalf("ExtractFile() error = %v", err)
+	}
+	if result.Title != "Launch plan" || result.Text != "intro # Launch plan - step one" {
+		t.Fatalf("markdown result = %#v", result)
+	}
+
+	result, err = ExtractFile("todo.txt", "text/plain; charset=utf-8", []byte("buy  milk\n"))
+	if err != nil || result.Title != "todo.txt" || result.Text != "buy milk" {
+		t.Fatalf("text result = %#v, %v", result, err)
+	}
+
+	if SupportedFile("photo.jpg", "image/jpeg") {
+		t.Fatal("expected images to be unsupported")
+	}
+	if _, err := ExtractFile("photo.jpg", "image/jpeg", []byte{0xff, 0xd8}); !errors.Is(err, ErrUnsupportedFile) {
+		t.Fatalf("ExtractFile(image) error = %v, want ErrUnsupportedFile", err)
+	}
+}
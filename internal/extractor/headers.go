@@ -0,0 +1,66 @@
+package extractor
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultUserAgent is sent when Headers.UserAgent is empty.
+const DefaultUserAgent = "hister-element-bot/1.0"
+
+// Headers customizes the requests made to fetch pages. Some sites block
+// unknown user agents or pick the page language from Accept-Language.
+type Headers struct {
+	// UserAgent replaces the User-Agent of every request.
+	UserAgent string
+	// Sites maps a domain to headers, such as Cookie or Accept-Language,
+	// added to requests for it and its subdomains. The longest matching
+	// domain wins.
+	Sites map[string]http.Header
+}
+
+// Transport wraps base, or http.DefaultTransport if nil, so that every
+// request carries the configured headers.
+func (h Headers) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &headerTransport{base: base, headers: h}
+}
+
+// For returns the extra headers for a request to host.
+func (h Headers) For(host string) http.Header {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	var best string
+	var headers http.Header
+	for domain, extra := range h.Sites {
+		domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+		if headers == nil || len(domain) > len(best) {
+			best, headers = domain, extra
+		}
+	}
+	return headers
+}
+
+type headerTransport struct {
+	base    http.RoundTripper
+	headers Headers
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	extra := t.headers.For(req.URL.Hostname())
+	if t.headers.UserAgent == "" && len(extra) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if t.headers.UserAgent != "" {
+		req.Header.Set("User-Agent", t.headers.UserAgent)
+	}
+	for key, values := range extra {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	return t.base.RoundTrip(req)
+}
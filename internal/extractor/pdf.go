@@ -0,0 +1,306 @@
+package extractor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// maxPDFStreamBytes caps how much one compressed stream may inflate to.
+const maxPDFStreamBytes = 4 * MaxFileBytes
+
+var (
+	pdfStreamStart = regexp.MustCompile(`stream\r?\n`)
+	pdfTitleKey    = regexp.MustCompile(`/Title\s*[(<]`)
+	// pdfOtherFilters are stream filters extractPDF cannot undo; streams
+	// using them are images or fonts in practice.
+	pdfOtherFilters = []string{"/DCTDecode", "/JPXDecode", "/LZWDecode", "/ASCII85Decode", "/ASCIIHexDecode", "/CCITTFaxDecode", "/JBIG2Decode", "/RunLengthDecode"}
+)
+
+// extractPDF pulls the text shown by a PDF's page content streams. It reads
+// uncompressed and FlateDecode streams and decodes strings as single-byte
+// text, which covers PDFs using the standard fonts; glyphs of embedded CID
+// fonts are not mapped back to text.
+func extractPDF(data []byte) (Result, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return Result{}, errors.New("not a PDF file")
+	}
+	var text strings.Builder
+	for _, stream := range pdfStreams(data) {
+		text.WriteString(pdfContentText(stream))
+		text.WriteByte(' ')
+	}
+	result := Result{Title: pdfTitle(data), Text: normalizeWhitespace(text.String())}
+	if result.Text == "" {
+		return Result{}, errors.New("no text found in PDF")
+	}
+	return result, nil
+}
+
+// pdfStreams returns the decoded data of every stream that may hold page
+// content, skipping images, fonts and streams with unsupported filters.
+func pdfStreams(data []byte) [][]byte {
+	var out [][]byte
+	rest := data
+	for {
+		loc := pdfStreamStart.FindIndex(rest)
+		if loc == nil {
+			return out
+		}
+		dict := rest[:loc[0]]
+		if i := bytes.LastIndex(dict, []byte("obj")); i >= 0 {
+			dict = dict[i:]
+		}
+		rest = rest[loc[1]:]
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			return out
+		}
+		raw := bytes.TrimRight(rest[:end], "\r\n")
+		rest = rest[end+len("endstream"):]
+
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/Length1")) || bytes.Contains(dict, []byte("/FontFile")) {
+			continue
+		}
+		if containsAny(dict, pdfOtherFilters) {
+			continue
+		}
+		if !bytes.Contains(dict, []byte("/FlateDecode")) {
+			out = append(out, raw)
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			continue
+		}
+		inflated, err := io.ReadAll(io.LimitReader(zr, maxPDFStreamBytes))
+		_ = zr.Close()
+		if err != nil && len(inflated) == 0 {
+			continue
+		}
+		out = append(out, inflated)
+	}
+}
+
+func containsAny(data []byte, words []string) bool {
+	for _, word := range words {
+		if bytes.Contains(data, []byte(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfContentText returns the strings drawn by the text operators (Tj, TJ,
+// ' and ") between BT and ET in a content stream.
+func pdfContentText(content []byte) string {
+	var b strings.Builder
+	var operands []string
+	inText, inArray := false, false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			operands = append(operands, pdfDecodeText(s))
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<', c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			s, n := pdfHexString(content[i:])
+			operands = append(operands, pdfDecodeText(s))
+			i += n
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '/':
+			i++
+			for i < len(content) && !isPDFSpace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+		case isPDFDelimiter(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			token := string(content[start:i])
+			if n, err := strconv.ParseFloat(token, 64); err == nil {
+				// A large negative kerning in a TJ array is a word gap.
+				if inArray && n <= -200 {
+					operands = append(operands, " ")
+				}
+				continue
+			}
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				b.WriteByte('\n')
+			case "Tj", "TJ":
+				if inText {
+					b.WriteString(strings.Join(operands, ""))
+				}
+			case "'", `"`:
+				if inText {
+					b.WriteByte('\n')
+					b.WriteString(strings.Join(operands, ""))
+				}
+			case "T*", "Td", "TD":
+				b.WriteByte(' ')
+			}
+			operands = operands[:0]
+		}
+	}
+	return b.String()
+}
+
+// pdfLiteralString decodes the parenthesized string at the start of data and
+// returns it with the number of bytes it took.
+func pdfLiteralString(data []byte) ([]byte, int) {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return out, i + 1
+			}
+			out = append(out, c)
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r', '\n':
+				// A backslash before a line break continues the line.
+				if e == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					j := i
+					for ; j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7'; j++ {
+						n = n*8 + int(data[j]-'0')
+					}
+					out = append(out, byte(n))
+					i = j - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, len(data)
+}
+
+// pdfHexString decodes the <hex> string at the start of data and returns it
+// with the number of bytes it took.
+func pdfHexString(data []byte) ([]byte, int) {
+	end := bytes.IndexByte(data, '>')
+	if end < 0 {
+		return nil, len(data)
+	}
+	digits := make([]byte, 0, end)
+	for _, c := range data[1:end] {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return nil, end + 1
+	}
+	return out, end + 1
+}
+
+// pdfDecodeText decodes a PDF text string: UTF-16BE with a byte order mark,
+// otherwise one character per byte. Control characters are dropped.
+func pdfDecodeText(s []byte) string {
+	var runes []rune
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		runes = utf16.Decode(units)
+	} else {
+		runes = make([]rune, len(s))
+		for i, c := range s {
+			runes[i] = rune(c)
+		}
+	}
+	var b strings.Builder
+	for _, r := range runes {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case !unicode.IsControl(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// pdfTitle returns the /Title of the document information dictionary.
+func pdfTitle(data []byte) string {
+	loc := pdfTitleKey.FindIndex(data)
+	if loc == nil {
+		return ""
+	}
+	start := loc[1] - 1
+	var raw []byte
+	if data[start] == '(' {
+		raw, _ = pdfLiteralString(data[start:])
+	} else {
+		raw, _ = pdfHexString(data[start:])
+	}
+	return normalizeWhitespace(pdfDecodeText(raw))
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
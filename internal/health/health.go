@@ -0,0 +1,136 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultCheckTimeout = 5 * time.Second
+
+// Check probes one dependency. A nil error means healthy.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Report is the JSON body served by the health endpoints.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Handler serves /healthz (liveness) and /readyz (readiness). /readyz runs
+// the liveness checks too. Both answer 200 when every check passes and 503
+// otherwise.
+type Handler struct {
+	Liveness  []Check
+	Readiness []Check
+	// Timeout bounds each request's checks. Zero uses five seconds.
+	Timeout time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var checks []Check
+	switch r.URL.Path {
+	case "/healthz":
+		checks = h.Liveness
+	case "/readyz":
+		checks = append(append([]Check(nil), h.Liveness...), h.Readiness...)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	report := Run(ctx, checks)
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// Run executes checks concurrently and collects their results.
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{Status: "ok", Checks: make(map[string]string, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := check.Run(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result != "ok" {
+				report.Status = "fail"
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// SyncMonitor judges the Matrix sync loop by its last successful sync.
+type SyncMonitor struct {
+	LastSync func() time.Time
+	// MaxAge is how long the loop may go without a sync before it counts as
+	// stalled.
+	MaxAge  time.Duration
+	Started time.Time
+	now     func() time.Time
+}
+
+// NewSyncMonitor starts the grace period for the first sync now.
+func NewSyncMonitor(lastSync func() time.Time, maxAge time.Duration) *SyncMonitor {
+	return &SyncMonitor{LastSync: lastSync, MaxAge: maxAge, Started: time.Now(), now: time.Now}
+}
+
+// Live fails once no sync has completed for MaxAge, counting from startup
+// until the first sync so a slow initial sync is not reported as dead.
+func (m *SyncMonitor) Live(context.Context) error {
+	last := m.LastSync()
+	since := last
+	if since.IsZero() {
+		since = m.Started
+	}
+	if age := m.now().Sub(since); age > m.MaxAge {
+		if last.IsZero() {
+			return fmt.Errorf("no sync completed since startup %s ago", age.Round(time.Second))
+		}
+		return fmt.Errorf("last sync %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+// Ready additionally requires that a first sync has completed.
+func (m *SyncMonitor) Ready(ctx context.Context) error {
+	if m.LastSync().IsZero() {
+		return errors.New("initial sync not completed")
+	}
+	return m.Live(ctx)
+}
@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestHandler_ReportsChecks(t *testing.T) {
+	h := &Handler{
+		Liveness:  []Check{{Name: "sync", Run: ok}},
+		Readiness: []Check{{Name: "hister", Run: func(context.Context) error { return errors.New("connection refused") }}},
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected /healthz 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz 503, got %d", rec.Code)
+	}
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if report.Status != "fail" || report.Checks["sync"] != "ok" || report.Checks["hister"] != "connection refused" {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown path, got %d", rec.Code)
+	}
+}
+
+func TestSyncMonitor(t *testing.T) {
+	start := time.Unix(1000, 0)
+	now := start
+	var last time.Time
+	m := &SyncMonitor{LastSync: func() time.Time { return last }, MaxAge: time.Minute, Started: start, now: func() time.Time { return now }}
+
+	if err := m.Live(context.Background()); err != nil {
+		t.Fatalf("expected live during startup grace period, got %v", err)
+	}
+	if err := m.Ready(context.Background()); err == nil {
+		t.Fatal("expected not ready before first sync")
+	}
+
+	now = start.Add(30 * time.Second)
+	last = now
+	if err := m.Ready(context.Background()); err != nil {
+		t.Fatalf("expected ready after sync, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := m.Live(context.Background()); err == nil {
+		t.Fatal("expected stalled sync to fail liveness")
+	}
+}
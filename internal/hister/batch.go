@@ -0,0 +1,162 @@
+package hister
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+)
+
+// errBatchUnsupported means Hister has no batch add endpoint at BatchAddPath.
+var errBatchUnsupported = errors.New("batch add is not supported")
+
+// IndexURLs indexes urls like IndexURL, but submits the extracted documents
+// to BatchAddPath as JSON arrays of up to BatchSize documents, which is much
+// faster for large backfills. The result holds the error for each of urls,
+// nil for those indexed. Without BatchAddPath, or once Hister answers it with
+// 404, 405 or 501, documents are added one by one.
+func (c *Client) IndexURLs(ctx context.Context, urls []string) []error {
+	return c.IndexURLsFrom(ctx, urls, nil)
+}
+
+// IndexURLsFrom is IndexURLs, adding each document with where its link was
+// shared: sources[i] for urls[i], if sources has that many.
+func (c *Client) IndexURLsFrom(ctx context.Context, urls []string, sources []Source) []error {
+	return c.indexURLs(ctx, "", urls, sources)
+}
+
+func (c *Client) indexURLs(ctx context.Context, namespace string, urls []string, sources []Source) []error {
+	errs := make([]error, len(urls))
+	err := c.prepare()
+	if err == nil {
+		err = c.allow(ctx)
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	contents := make([]extractor.Result, len(urls))
+	var batch []int
+	for i, rawURL := range urls {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		content, err := c.Extract(ctx, rawURL)
+		if err != nil {
+			errs[i] = fmt.Errorf("extract URL content: %w", err)
+			continue
+		}
+		contents[i] = content
+		batch = append(batch, i)
+		if len(batch) == c.BatchSize {
+			c.submitBatch(ctx, namespace, urls, contents, sources, batch, errs)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		c.submitBatch(ctx, namespace, urls, contents, sources, batch, errs)
+	}
+	return errs
+}
+
+// submitBatch adds the documents of urls at indexes batch, setting their
+// entries of errs.
+func (c *Client) submitBatch(ctx context.Context, namespace string, urls []string, contents []extractor.Result, sources []Source, batch []int, errs []error) {
+	docs := make([]addRequest, len(batch))
+	for j, i := range batch {
+		var source Source
+		if i < len(sources) {
+			source = sources[i]
+		}
+		docs[j] = newAddRequest(namespace, urls[i], contents[i], source)
+	}
+
+	err := errBatchUnsupported
+	if c.BatchAddPath != "" && !c.batchRejected.Load() {
+		err = c.observe(c.addBatch(ctx, docs))
+	}
+	if errors.Is(err, errBatchUnsupported) {
+		endpoint, err := c.endpoint(c.AddPath, false)
+		for j, i := range batch {
+			if err == nil {
+				errs[i] = c.observe(c.addDocument(ctx, endpoint, docs[j]))
+			} else {
+				errs[i] = err
+			}
+		}
+	} else {
+		for _, i := range batch {
+			errs[i] = err
+		}
+	}
+
+	if c.OnIndexed == nil {
+		return
+	}
+	for _, i := range batch {
+		if errs[i] == nil {
+			c.OnIndexed(ctx, urls[i], contents[i])
+		}
+	}
+}
+
+// addBatch posts docs to BatchAddPath as one JSON array, retrying server
+// errors like addDocument.
+func (c *Client) addBatch(ctx context.Context, docs []addRequest) error {
+	endpoint, err := c.endpoint(c.BatchAddPath, false)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(docs)
+	if err != nil {
+		return fmt.Errorf("encode batch add request: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("create batch add request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.HTTPClient.Do(req)
+		if err == nil {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+			_ = resp.Body.Close()
+			c.debugf("hister batch add documents=%d status=%d", len(docs), resp.StatusCode)
+			switch code := resp.StatusCode; {
+			case code < 300:
+				return nil
+			case code == http.StatusNotFound || code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented:
+				c.logf("hister has no batch add endpoint (status %d); adding documents one by one from now on", code)
+				c.batchRejected.Store(true)
+				return errBatchUnsupported
+			case code < 500:
+				if msg := strings.TrimSpace(string(respBody)); msg != "" {
+					return fmt.Errorf("batch add request failed with status %d: %s", code, msg)
+				}
+				return fmt.Errorf("batch add request failed with status %d", code)
+			}
+			err = fmt.Errorf("batch add request failed with status %d", resp.StatusCode)
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= c.AddRetries {
+			return outage(fmt.Errorf("batch add request failed after %d attempts: %w", attempt+1, err))
+		}
+		c.logf("hister batch add retry documents=%d attempt=%d err=%v", len(docs), attempt+1, err)
+		if err := sleepWithContext(ctx, c.retryDelay(attempt)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package hister
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned without contacting Hister while the circuit
+// breaker is open after repeated outages.
+var ErrUnavailable = errors.New("hister is unavailable")
+
+const defaultBreakerCooldown = 30 * time.Second
+
+// outageError marks an error that means Hister could not be reached or
+// failed on its side, as opposed to rejecting a request.
+type outageError struct {
+	err error
+}
+
+func (e *outageError) Error() string { return e.err.Error() }
+func (e *outageError) Unwrap() error { return e.err }
+
+func outage(err error) error {
+	return &outageError{err: err}
+}
+
+// breaker fails requests fast once BreakerThreshold requests in a row hit
+// an outage. After BreakerCooldown the next request first pings Hister:
+// if it answers, the breaker closes, and otherwise it stays open for
+// another cooldown.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns ErrUnavailable while the breaker is open.
+func (c *Client) allow(ctx context.Context) error {
+	if c.BreakerThreshold <= 0 {
+		return nil
+	}
+	b := &c.breaker
+	b.mu.Lock()
+	if b.failures < c.BreakerThreshold {
+		b.mu.Unlock()
+		return nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		b.mu.Unlock()
+		return ErrUnavailable
+	}
+	b.probing = true
+	b.mu.Unlock()
+
+	err := c.Ping(ctx)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err != nil {
+		b.openUntil = time.Now().Add(c.BreakerCooldown)
+		c.logf("hister still unavailable, failing fast for %s err=%v", c.BreakerCooldown, err)
+		return ErrUnavailable
+	}
+	b.failures = 0
+	c.logf("hister reachable again, closing circuit breaker")
+	return nil
+}
+
+// observe records the outcome of a request to Hister and returns err.
+func (c *Client) observe(err error) error {
+	if c.BreakerThreshold <= 0 || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var down *outageError
+	b := &c.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !errors.As(err, &down) {
+		b.failures = 0
+		return err
+	}
+	b.failures++
+	if b.failures == c.BreakerThreshold {
+		b.openUntil = time.Now().Add(c.BreakerCooldown)
+		c.logf("hister unavailable after %d failed requests, failing fast for %s err=%v", b.failures, c.BreakerCooldown, err)
+	}
+	return err
+}
@@ -1,6 +1,8 @@
 package hister
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,7 +11,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,17 +23,68 @@ import (
 const (
 	defaultAddPath         = "/add"
 	defaultSearchPath      = "/search"
+	defaultDeletePath      = "/delete"
 	defaultTimeout         = 10 * time.Second
 	defaultRetryBackoff    = 100 * time.Millisecond
 	defaultMaxRetryBackoff = 1 * time.Second
 	defaultAddRetries      = 3
+	defaultBatchSize       = 50
 	defaultSearchRetries   = 3
+	defaultPingInterval    = 2 * time.Second
+	defaultPongTimeout     = 3 * time.Second
 )
 
 type SearchResult struct {
 	Title   string
 	URL     string
 	Snippet string
+	// Score is Hister's relevance score, zero when the response has none.
+	Score float64
+	// Source is where the document's link was shared, if it was indexed
+	// with one.
+	Source Source
+}
+
+// Page is one page of search results.
+type Page struct {
+	Results []SearchResult
+	// Total is how many documents Hister reported matching the query, or 0
+	// if it did not say.
+	Total int
+}
+
+// Filters narrow a search beyond its text. Zero fields don't filter.
+type Filters struct {
+	// Sites are domains, matching their subdomains too.
+	Sites []string
+	// After and Before bound when documents were shared, Before exclusive.
+	After  time.Time
+	Before time.Time
+	// Rooms are the Matrix room IDs documents were shared in.
+	Rooms []string
+}
+
+// searchFilters is Filters as sent to Hister, with dates in RFC 3339.
+type searchFilters struct {
+	Sites  []string `json:"sites,omitempty"`
+	After  string   `json:"after,omitempty"`
+	Before string   `json:"before,omitempty"`
+	Rooms  []string `json:"rooms,omitempty"`
+}
+
+// request returns f for a search request, or nil if it does not filter.
+func (f Filters) request() *searchFilters {
+	if len(f.Sites) == 0 && len(f.Rooms) == 0 && f.After.IsZero() && f.Before.IsZero() {
+		return nil
+	}
+	out := &searchFilters{Sites: f.Sites, Rooms: f.Rooms}
+	if !f.After.IsZero() {
+		out.After = f.After.UTC().Format(time.RFC3339)
+	}
+	if !f.Before.IsZero() {
+		out.Before = f.Before.UTC().Format(time.RFC3339)
+	}
+	return out
 }
 
 type SearchBackend interface {
@@ -44,7 +99,17 @@ type Client struct {
 
 	AddPath    string
 	SearchPath string
-	Timeout    time.Duration
+	// DeletePath is the endpoint DeleteURL posts to.
+	DeletePath string
+	// HealthPath is the endpoint Healthy checks; without it Healthy falls
+	// back to Ping.
+	HealthPath string
+	// BatchAddPath, when set, is where IndexURLs posts up to BatchSize
+	// documents at a time as a JSON array.
+	BatchAddPath  string
+	BatchSize     int
+	batchRejected atomic.Bool
+	Timeout       time.Duration
 
 	AddRetries    int
 	SearchRetries int
@@ -52,17 +117,83 @@ type Client struct {
 	RetryBackoff    time.Duration
 	MaxRetryBackoff time.Duration
 
+	// PingInterval is how often a search waiting for results pings Hister,
+	// and PongTimeout how long a pong may take before the connection counts
+	// as dropped and the search is re-sent on a new one. A negative
+	// PingInterval disables pings.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// GzipMinBytes sends /add bodies of at least this many bytes with
+	// Content-Encoding: gzip. Zero never compresses. If Hister answers a
+	// compressed request with 415, the document and all later ones are sent
+	// uncompressed.
+	GzipMinBytes int
+	gzipRejected atomic.Bool
+
+	// SearchToken authenticates the search websocket handshake. It is sent
+	// as "Authorization: Bearer <token>", as the raw value of
+	// SearchTokenHeader if that is set, or, for reverse proxies that strip
+	// headers from upgrade requests, as the SearchTokenParam query parameter.
+	SearchToken       string
+	SearchTokenHeader string
+	SearchTokenParam  string
+
+	// SearchTransport selects how Search reaches Hister: SearchTransportWS
+	// (the default) over the websocket at SearchPath, SearchTransportHTTP by
+	// POSTing the query to SearchHTTPPath, or SearchTransportAuto, which
+	// falls back to HTTP for a while when the websocket cannot be dialed,
+	// e.g. behind reverse proxies that strip Upgrade headers.
+	SearchTransport string
+	// SearchHTTPPath is the HTTP search endpoint; it defaults to SearchPath.
+	SearchHTTPPath string
+	httpUntil      atomic.Int64
+
+	// ReuseConnections keeps up to MaxIdleConns search websockets open for
+	// IdleConnTimeout after a search, so the next one skips the handshake.
+	// Each request then carries an "id"; a response echoing another id is
+	// a late answer to an earlier request and is skipped.
+	ReuseConnections bool
+	MaxIdleConns     int
+	IdleConnTimeout  time.Duration
+	pool             connPool
+	searchSeq        atomic.Uint64
+
+	// BreakerThreshold is how many requests in a row may hit a Hister
+	// outage before requests fail fast with ErrUnavailable for
+	// BreakerCooldown (default 30s); after that a ping probes whether
+	// Hister is back. Zero disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	breaker          breaker
+
 	HTTPClient *http.Client
 	Dialer     *websocket.Dialer
 	DialWS     func(ctx context.Context, wsURL string) (wsConn, error)
 	Extract    func(ctx context.Context, rawURL string) (extractor.Result, error)
+	// OnIndexed, when set, is called after IndexURL submits a document, with
+	// the content that was extracted for it.
+	OnIndexed func(ctx context.Context, rawURL string, content extractor.Result)
+	// Logger, when set, receives retry notices and, if it has a Debugf
+	// method, per-request debug lines.
+	Logger Logger
+}
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type debugLogger interface {
+	Debugf(format string, args ...any)
 }
 
 type wsConn interface {
 	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
 	ReadMessage() (messageType int, p []byte, err error)
 	SetReadDeadline(t time.Time) error
 	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
 	Close() error
 }
 
@@ -82,31 +213,81 @@ func NewClient(baseURL string, timeout time.Duration, opts ...ClientOption) (*Cl
 }
 
 func (c *Client) IndexURL(ctx context.Context, rawURL string) error {
+	_, err := c.IndexURLContent(ctx, rawURL)
+	return err
+}
+
+// IndexURLContent is IndexURL, but also returns the content extracted for the
+// document.
+func (c *Client) IndexURLContent(ctx context.Context, rawURL string) (extractor.Result, error) {
+	return c.IndexURLFrom(ctx, rawURL, Source{})
+}
+
+// IndexURLFrom is IndexURLContent, adding the document with where its link
+// was shared.
+func (c *Client) IndexURLFrom(ctx context.Context, rawURL string, source Source) (extractor.Result, error) {
+	return c.indexURL(ctx, "", rawURL, source)
+}
+
+func (c *Client) indexURL(ctx context.Context, namespace, rawURL string, source Source) (extractor.Result, error) {
 	if err := c.prepare(); err != nil {
-		return err
+		return extractor.Result{}, err
 	}
 
 	endpoint, err := c.endpoint(c.AddPath, false)
 	if err != nil {
-		return err
+		return extractor.Result{}, err
+	}
+	if err := c.allow(ctx); err != nil {
+		return extractor.Result{}, err
 	}
 
 	content, err := c.Extract(ctx, rawURL)
 	if err != nil {
-		return fmt.Errorf("extract URL content: %w", err)
+		return extractor.Result{}, fmt.Errorf("extract URL content: %w", err)
 	}
 
-	return c.addDocument(ctx, endpoint, addRequest{
-		URL:   rawURL,
-		Title: content.Title,
-		Text:  content.Text,
-	})
+	if err := c.observe(c.addDocument(ctx, endpoint, newAddRequest(namespace, rawURL, content, source))); err != nil {
+		return extractor.Result{}, err
+	}
+	if c.OnIndexed != nil {
+		c.OnIndexed(ctx, rawURL, content)
+	}
+	return content, nil
+}
+
+// IndexContent submits already extracted content for rawURL, e.g. when
+// refreshing a document whose page changed. OnIndexed is not called.
+func (c *Client) IndexContent(ctx context.Context, rawURL string, content extractor.Result) error {
+	return c.indexContent(ctx, "", rawURL, content)
+}
+
+func (c *Client) indexContent(ctx context.Context, namespace, rawURL string, content extractor.Result) error {
+	if err := c.prepare(); err != nil {
+		return err
+	}
+
+	endpoint, err := c.endpoint(c.AddPath, false)
+	if err != nil {
+		return err
+	}
+	if err := c.allow(ctx); err != nil {
+		return err
+	}
+
+	return c.observe(c.addDocument(ctx, endpoint, newAddRequest(namespace, rawURL, content, Source{})))
 }
 
 type addRequest struct {
-	URL   string `json:"url"`
-	Title string `json:"title,omitempty"`
-	Text  string `json:"text,omitempty"`
+	URL       string `json:"url"`
+	Title     string `json:"title,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	sourceFields
+}
+
+func newAddRequest(namespace, rawURL string, content extractor.Result, source Source) addRequest {
+	return addRequest{URL: rawURL, Title: content.Title, Text: content.Text, Namespace: namespace, sourceFields: source.fields()}
 }
 
 type addStatusError struct {
@@ -130,14 +311,30 @@ func (c *Client) addDocument(ctx context.Context, endpoint string, payload addRe
 	if strings.TrimSpace(payload.Text) != "" {
 		form.Set("text", payload.Text)
 	}
-	body := form.Encode()
+	if payload.Namespace != "" {
+		form.Set("namespace", payload.Namespace)
+	}
+	payload.sourceFields.setForm(form)
+	body := []byte(form.Encode())
+	compressed, err := c.compressAddBody(body)
+	if err != nil {
+		return err
+	}
 
 	for attempt := 0; ; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+		gzipped := compressed != nil && !c.gzipRejected.Load()
+		reqBody := body
+		if gzipped {
+			reqBody = compressed
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(reqBody))
 		if err != nil {
 			return fmt.Errorf("create add request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
 		req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:147.0) Gecko/20100101 Firefox/147.0")
 		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 
@@ -147,25 +344,35 @@ func (c *Client) addDocument(ctx context.Context, endpoint string, payload addRe
 				return ctx.Err()
 			}
 			if attempt < c.AddRetries {
+				c.logf("hister add retry url=%s attempt=%d err=%v", payload.URL, attempt+1, err)
 				if err := sleepWithContext(ctx, c.retryDelay(attempt)); err != nil {
 					return err
 				}
 				continue
 			}
-			return fmt.Errorf("add request failed after %d attempts: %w", attempt+1, err)
+			return outage(fmt.Errorf("add request failed after %d attempts: %w", attempt+1, err))
 		}
 
 		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
 		_ = resp.Body.Close()
 
+		c.debugf("hister add url=%s status=%d", payload.URL, resp.StatusCode)
 		if resp.StatusCode >= 500 {
 			if attempt < c.AddRetries {
+				c.logf("hister add retry url=%s attempt=%d status=%d", payload.URL, attempt+1, resp.StatusCode)
 				if err := sleepWithContext(ctx, c.retryDelay(attempt)); err != nil {
 					return err
 				}
 				continue
 			}
-			return fmt.Errorf("add request failed with status %d", resp.StatusCode)
+			return outage(&addStatusError{StatusCode: resp.StatusCode})
+		}
+
+		if gzipped && resp.StatusCode == http.StatusUnsupportedMediaType {
+			c.logf("hister add rejected gzip bodies; sending uncompressed from now on")
+			c.gzipRejected.Store(true)
+			attempt--
+			continue
 		}
 
 		if resp.StatusCode != http.StatusCreated {
@@ -178,106 +385,280 @@ func (c *Client) addDocument(ctx context.Context, endpoint string, payload addRe
 	}
 }
 
+// compressAddBody gzips body if it is at least GzipMinBytes long and Hister
+// has not rejected compressed bodies. It returns nil otherwise.
+func (c *Client) compressAddBody(body []byte) ([]byte, error) {
+	if c.GzipMinBytes <= 0 || len(body) < c.GzipMinBytes || c.gzipRejected.Load() {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, fmt.Errorf("compress add request: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress add request: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 func (c *Client) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	page, err := c.SearchPage(ctx, query, 0, limit)
+	return page.Results, err
+}
+
+// SearchPage returns up to limit results for query after skipping the first
+// offset, with the total Hister reports. The offset and limit are sent along
+// with the query; a Hister that ignores them answers with its first results,
+// so callers fetching later pages should drop results they already have.
+func (c *Client) SearchPage(ctx context.Context, query string, offset, limit int) (Page, error) {
+	return c.SearchFiltered(ctx, query, Filters{}, offset, limit)
+}
+
+// SearchFiltered is SearchPage with filters, sent as a "filters" object next
+// to the query text. A Hister that does not support some of them ignores
+// them, so callers needing exact results should check the results too.
+func (c *Client) SearchFiltered(ctx context.Context, query string, filters Filters, offset, limit int) (Page, error) {
+	return c.searchIn(ctx, "", query, filters, offset, limit)
+}
+
+func (c *Client) searchIn(ctx context.Context, namespace, query string, filters Filters, offset, limit int) (Page, error) {
 	if err := c.prepare(); err != nil {
-		return nil, err
+		return Page{}, err
+	}
+	if err := c.allow(ctx); err != nil {
+		return Page{}, err
 	}
+	page, err := c.search(ctx, namespace, query, filters, offset, limit)
+	return page, c.observe(err)
+}
 
-	wsURL, err := c.endpoint(c.SearchPath, true)
+func (c *Client) search(ctx context.Context, namespace, query string, filters Filters, offset, limit int) (Page, error) {
+	var requestID string
+	if c.ReuseConnections {
+		requestID = strconv.FormatUint(c.searchSeq.Add(1), 10)
+	}
+	reqBody, err := json.Marshal(struct {
+		Text      string         `json:"text"`
+		ID        string         `json:"id,omitempty"`
+		Namespace string         `json:"namespace,omitempty"`
+		Offset    int            `json:"offset,omitempty"`
+		Limit     int            `json:"limit,omitempty"`
+		Filters   *searchFilters `json:"filters,omitempty"`
+	}{Text: query, ID: requestID, Namespace: namespace, Offset: offset, Limit: limit, Filters: filters.request()})
 	if err != nil {
-		return nil, err
+		return Page{}, fmt.Errorf("marshal search request: %w", err)
+	}
+	if c.useHTTPSearch() {
+		return c.searchHTTP(ctx, query, reqBody, limit)
 	}
 
-	reqBody, err := json.Marshal(struct {
-		Text string `json:"text"`
-	}{Text: query})
+	wsURL, err := c.searchURL(c.SearchPath, true)
 	if err != nil {
-		return nil, fmt.Errorf("marshal search request: %w", err)
+		return Page{}, err
 	}
 
-	for attempt := 0; ; attempt++ {
-		conn, err := c.DialWS(ctx, wsURL)
+	// The whole search, including reconnects, shares one deadline.
+	deadline, _ := combinedDeadline(ctx, c.Timeout)
+	searchCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	dialFailures, drops := 0, 0
+	for {
+		conn, reused, err := c.acquireConn(searchCtx, wsURL)
 		if err != nil {
 			if ctx.Err() != nil {
-				return nil, ctx.Err()
+				return Page{}, ctx.Err()
 			}
-			if attempt < c.SearchRetries {
-				if err := sleepWithContext(ctx, c.retryDelay(attempt)); err != nil {
-					return nil, err
+			if dialFailures < c.SearchRetries && searchCtx.Err() == nil {
+				if sleepWithContext(searchCtx, c.retryDelay(dialFailures)) == nil {
+					dialFailures++
+					continue
 				}
-				continue
 			}
-			return nil, fmt.Errorf("search dial failed after %d attempts: %w", attempt+1, err)
+			err = fmt.Errorf("search dial failed after %d attempts: %w", dialFailures+1, err)
+			if c.SearchTransport == SearchTransportAuto {
+				return c.fallBackToHTTP(ctx, query, reqBody, limit, err)
+			}
+			return Page{}, outage(err)
 		}
 
-		res, err := c.searchOnce(ctx, conn, reqBody, limit)
-		_ = conn.Close()
+		page, err := c.searchOnce(searchCtx, conn, reqBody, requestID, limit)
 		if err == nil {
-			return res, nil
+			c.releaseConn(conn)
+			c.debugf("hister search query=%q offset=%d results=%d total=%d reused=%t", query, offset, len(page.Results), page.Total, reused)
+			return page, nil
 		}
+		_ = conn.Close()
 
 		var nonRetryable *nonRetryableError
 		if errors.As(err, &nonRetryable) {
-			return nil, nonRetryable.err
+			return Page{}, nonRetryable.err
 		}
 		if ctx.Err() != nil {
-			return nil, ctx.Err()
+			return Page{}, ctx.Err()
+		}
+		if reused && searchCtx.Err() == nil {
+			// The server closed the idle connection meanwhile.
+			c.debugf("hister search reused connection failed, dialing err=%v", err)
+			continue
 		}
-		if attempt >= c.SearchRetries {
-			return nil, err
+		if searchCtx.Err() != nil {
+			return Page{}, outage(err)
 		}
 		if !isRetryableWSError(err) {
-			return nil, err
+			return Page{}, err
+		}
+		// The connection dropped (or missed a pong) before the results
+		// arrived: re-dial and re-send while the deadline allows.
+		c.logf("hister search reconnecting after dropped connection attempt=%d err=%v", drops+1, err)
+		if sleepWithContext(searchCtx, c.retryDelay(drops)) != nil {
+			return Page{}, outage(err)
+		}
+		drops++
+	}
+}
+
+// DeleteURL removes the document indexed under rawURL by posting it to
+// DeletePath. A document Hister does not know counts as deleted. Server
+// errors are retried like /add requests.
+func (c *Client) DeleteURL(ctx context.Context, rawURL string) error {
+	return c.deleteIn(ctx, "", rawURL)
+}
+
+func (c *Client) deleteIn(ctx context.Context, namespace, rawURL string) error {
+	if err := c.prepare(); err != nil {
+		return err
+	}
+	if err := c.allow(ctx); err != nil {
+		return err
+	}
+	return c.observe(c.deleteURL(ctx, namespace, rawURL))
+}
+
+func (c *Client) deleteURL(ctx context.Context, namespace, rawURL string) error {
+	endpoint, err := c.endpoint(c.DeletePath, false)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"url": {rawURL}}
+	if namespace != "" {
+		form.Set("namespace", namespace)
+	}
+	body := form.Encode()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("create delete request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := c.HTTPClient.Do(req)
+		if err == nil {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+			_ = resp.Body.Close()
+			c.debugf("hister delete url=%s status=%d", rawURL, resp.StatusCode)
+			switch {
+			case resp.StatusCode < 300 || resp.StatusCode == http.StatusNotFound:
+				return nil
+			case resp.StatusCode < 500:
+				if msg := strings.TrimSpace(string(respBody)); msg != "" {
+					return fmt.Errorf("delete request failed with status %d: %s", resp.StatusCode, msg)
+				}
+				return fmt.Errorf("delete request failed with status %d", resp.StatusCode)
+			}
+			err = fmt.Errorf("delete request failed with status %d", resp.StatusCode)
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= c.AddRetries {
+			return outage(fmt.Errorf("delete request failed after %d attempts: %w", attempt+1, err))
 		}
+		c.logf("hister delete retry url=%s attempt=%d err=%v", rawURL, attempt+1, err)
 		if err := sleepWithContext(ctx, c.retryDelay(attempt)); err != nil {
-			return nil, err
+			return err
 		}
 	}
 }
 
-func (c *Client) searchOnce(ctx context.Context, conn wsConn, reqBody []byte, limit int) ([]SearchResult, error) {
-	if deadline, ok := combinedDeadline(ctx, c.Timeout); ok {
+// searchOnce sends reqBody on conn and reads its results. With a requestID,
+// responses to other requests are skipped.
+func (c *Client) searchOnce(ctx context.Context, conn wsConn, reqBody []byte, requestID string, limit int) (Page, error) {
+	if deadline, ok := ctx.Deadline(); ok {
 		_ = conn.SetWriteDeadline(deadline)
 	}
 	if err := conn.WriteMessage(websocket.TextMessage, reqBody); err != nil {
-		return nil, fmt.Errorf("write search request: %w", err)
+		return Page{}, fmt.Errorf("write search request: %w", err)
 	}
 
-	msg, err := readMessageWithContext(ctx, conn, c.Timeout)
-	if err != nil {
-		return nil, err
+	var msg []byte
+	for {
+		var err error
+		if msg, err = c.readMessage(ctx, conn); err != nil {
+			return Page{}, err
+		}
+		if id := responseID(msg); requestID == "" || id == "" || id == requestID {
+			break
+		}
+		c.debugf("hister search skipping response to another request")
 	}
 
-	results, err := parseSearchResults(msg, limit)
+	page, err := parseSearchResults(msg, limit)
 	if err != nil {
-		return nil, &nonRetryableError{err: err}
+		return Page{}, &nonRetryableError{err: err}
+	}
+	return page, nil
+}
+
+// responseID returns the request id a search response echoes, if any.
+func responseID(body []byte) string {
+	var parsed struct {
+		ID json.RawMessage `json:"id"`
 	}
-	return results, nil
+	if json.Unmarshal(body, &parsed) != nil || len(parsed.ID) == 0 {
+		return ""
+	}
+	var id string
+	if json.Unmarshal(parsed.ID, &id) == nil {
+		return id
+	}
+	return string(parsed.ID)
 }
 
-func parseSearchResults(body []byte, limit int) ([]SearchResult, error) {
+// parseSearchResults parses a search response. The total may be given next
+// to the documents, as "total" or "total_hits".
+func parseSearchResults(body []byte, limit int) (Page, error) {
 	type doc struct {
-		Title       string `json:"title"`
-		URL         string `json:"url"`
-		Text        string `json:"text"`
-		Snippet     string `json:"snippet"`
-		Description string `json:"description"`
+		Title       string  `json:"title"`
+		URL         string  `json:"url"`
+		Text        string  `json:"text"`
+		Snippet     string  `json:"snippet"`
+		Description string  `json:"description"`
+		Score       float64 `json:"score"`
+		sourceFields
+	}
+	type total struct {
+		Total     int `json:"total"`
+		TotalHits int `json:"total_hits"`
 	}
 	type response struct {
+		total
 		Documents []doc `json:"documents"`
 		Results   struct {
+			total
 			Documents []doc `json:"documents"`
 		} `json:"results"`
 	}
 
 	var parsed response
 	if err := json.Unmarshal(body, &parsed); err != nil {
-		return nil, fmt.Errorf("decode search response: %w", err)
+		return Page{}, fmt.Errorf("decode search response: %w", err)
 	}
 
-	documents := parsed.Documents
+	documents, counts := parsed.Documents, parsed.total
 	if len(documents) == 0 {
-		documents = parsed.Results.Documents
+		documents, counts = parsed.Results.Documents, parsed.Results.total
 	}
 
 	out := make([]SearchResult, 0, len(documents))
@@ -293,24 +674,46 @@ func parseSearchResults(body []byte, limit int) ([]SearchResult, error) {
 			Title:   d.Title,
 			URL:     d.URL,
 			Snippet: snippet,
+			Score:   d.Score,
+			Source:  d.source(),
 		})
 	}
 
 	if limit > 0 && len(out) > limit {
 		out = out[:limit]
 	}
-	return out, nil
+	return Page{Results: out, Total: max(counts.Total, counts.TotalHits)}, nil
 }
 
-func readMessageWithContext(ctx context.Context, conn wsConn, timeout time.Duration) ([]byte, error) {
+// readMessage waits for the search response on conn until ctx ends. Unless
+// PingInterval is negative it pings the server meanwhile, and a pong not
+// arriving within PongTimeout fails the read like a dropped connection.
+func (c *Client) readMessage(ctx context.Context, conn wsConn) ([]byte, error) {
 	type readResult struct {
 		msg []byte
 		err error
 	}
 	resultCh := make(chan readResult, 1)
 
-	if deadline, ok := combinedDeadline(ctx, timeout); ok {
-		_ = conn.SetReadDeadline(deadline)
+	deadline, hasDeadline := ctx.Deadline()
+	readDeadline := func() time.Time {
+		if c.PingInterval <= 0 {
+			return deadline
+		}
+		next := time.Now().Add(c.PingInterval + c.PongTimeout)
+		if hasDeadline && deadline.Before(next) {
+			return deadline
+		}
+		return next
+	}
+	_ = conn.SetReadDeadline(readDeadline())
+	if c.PingInterval > 0 {
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(readDeadline())
+		})
+		stop := make(chan struct{})
+		defer close(stop)
+		go c.heartbeat(conn, stop)
 	}
 
 	go func() {
@@ -333,6 +736,52 @@ func readMessageWithContext(ctx context.Context, conn wsConn, timeout time.Durat
 	}
 }
 
+// heartbeat pings conn every PingInterval until stop is closed or a ping
+// cannot be sent.
+func (c *Client) heartbeat(conn wsConn, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.PongTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// searchURL is the search endpoint at path, as a websocket URL if websocketURL
+// is set, carrying SearchToken when it is passed as a query parameter.
+func (c *Client) searchURL(path string, websocketURL bool) (string, error) {
+	searchURL, err := c.endpoint(path, websocketURL)
+	if err != nil || c.SearchToken == "" || c.SearchTokenParam == "" {
+		return searchURL, err
+	}
+	u, err := url.Parse(searchURL)
+	if err != nil {
+		return "", fmt.Errorf("parse search URL: %w", err)
+	}
+	query := u.Query()
+	query.Set(c.SearchTokenParam, c.SearchToken)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// searchHeader returns the handshake headers carrying SearchToken, or nil
+// if it is not sent as a header.
+func (c *Client) searchHeader() http.Header {
+	if c.SearchToken == "" || c.SearchTokenParam != "" {
+		return nil
+	}
+	if c.SearchTokenHeader != "" {
+		return http.Header{http.CanonicalHeaderKey(c.SearchTokenHeader): {c.SearchToken}}
+	}
+	return http.Header{"Authorization": {"Bearer " + c.SearchToken}}
+}
+
 func (c *Client) endpoint(path string, websocketURL bool) (string, error) {
 	u, err := url.Parse(c.BaseURL)
 	if err != nil {
@@ -415,6 +864,11 @@ func (c *Client) validate() error {
 	if parsed.Scheme == "" || parsed.Host == "" {
 		return errors.New("base URL must include scheme and host")
 	}
+	switch c.SearchTransport {
+	case "", SearchTransportWS, SearchTransportHTTP, SearchTransportAuto:
+	default:
+		return fmt.Errorf("unknown search transport %q", c.SearchTransport)
+	}
 	return nil
 }
 
@@ -425,6 +879,27 @@ func (c *Client) applyDefaults() {
 	if c.SearchPath == "" {
 		c.SearchPath = defaultSearchPath
 	}
+	if c.DeletePath == "" {
+		c.DeletePath = defaultDeletePath
+	}
+	if c.SearchTransport == "" {
+		c.SearchTransport = SearchTransportWS
+	}
+	if c.SearchHTTPPath == "" {
+		c.SearchHTTPPath = c.SearchPath
+	}
+	if c.BreakerCooldown <= 0 {
+		c.BreakerCooldown = defaultBreakerCooldown
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
 	if c.Timeout <= 0 {
 		c.Timeout = defaultTimeout
 	}
@@ -449,6 +924,12 @@ func (c *Client) applyDefaults() {
 	if c.MaxRetryBackoff < c.RetryBackoff {
 		c.MaxRetryBackoff = c.RetryBackoff
 	}
+	if c.PingInterval == 0 {
+		c.PingInterval = defaultPingInterval
+	}
+	if c.PongTimeout <= 0 {
+		c.PongTimeout = defaultPongTimeout
+	}
 
 	if c.HTTPClient == nil {
 		c.HTTPClient = &http.Client{Timeout: c.Timeout}
@@ -468,7 +949,7 @@ func (c *Client) applyDefaults() {
 	}
 	if c.DialWS == nil {
 		c.DialWS = func(ctx context.Context, wsURL string) (wsConn, error) {
-			conn, _, err := c.Dialer.DialContext(ctx, wsURL, nil)
+			conn, _, err := c.Dialer.DialContext(ctx, wsURL, c.searchHeader())
 			return conn, err
 		}
 	}
@@ -539,3 +1020,15 @@ func (e *nonRetryableError) Error() string {
 func (e *nonRetryableError) Unwrap() error {
 	return e.err
 }
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, args...)
+	}
+}
+
+func (c *Client) debugf(format string, args ...any) {
+	if l, ok := c.Logger.(debugLogger); ok {
+		l.Debugf(format, args...)
+	}
+}
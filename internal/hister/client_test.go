@@ -2,12 +2,19 @@ package hister
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -22,6 +29,24 @@ func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return f(req)
 }
 
+type recordingLogger struct {
+	mu     sync.Mutex
+	infos  []string
+	debugs []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
 type fakeWSConn struct {
 	written [][]byte
 	readMsg []byte
@@ -42,9 +67,11 @@ func (f *fakeWSConn) ReadMessage() (int, []byte, error) {
 	return websocket.TextMessage, f.readMsg, nil
 }
 
-func (f *fakeWSConn) SetReadDeadline(time.Time) error  { return nil }
-func (f *fakeWSConn) SetWriteDeadline(time.Time) error { return nil }
-func (f *fakeWSConn) Close() error                     { return nil }
+func (f *fakeWSConn) WriteControl(int, []byte, time.Time) error { return nil }
+func (f *fakeWSConn) SetReadDeadline(time.Time) error           { return nil }
+func (f *fakeWSConn) SetWriteDeadline(time.Time) error          { return nil }
+func (f *fakeWSConn) SetPongHandler(func(string) error)         {}
+func (f *fakeWSConn) Close() error                              { return nil }
 
 func TestClientIndexURLRetriesOnServerError(t *testing.T) {
 	t.Parallel()
@@ -93,6 +120,8 @@ func TestClientIndexURLRetriesOnServerError(t *testing.T) {
 	}
 	c.RetryBackoff = 5 * time.Millisecond
 	c.MaxRetryBackoff = 5 * time.Millisecond
+	logger := &recordingLogger{}
+	c.Logger = logger
 
 	if err := c.IndexURL(context.Background(), "https://example.com/a"); err != nil {
 		t.Fatalf("IndexURL() error = %v", err)
@@ -100,6 +129,12 @@ func TestClientIndexURLRetriesOnServerError(t *testing.T) {
 	if got := attempts.Load(); got != 3 {
 		t.Fatalf("IndexURL() attempts = %d, want 3", got)
 	}
+	if len(logger.infos) != 2 || !strings.Contains(logger.infos[0], "hister add retry") {
+		t.Fatalf("retry logs = %#v, want 2 retry notices", logger.infos)
+	}
+	if len(logger.debugs) != 3 || !strings.Contains(logger.debugs[2], "status=201") {
+		t.Fatalf("debug logs = %#v, want one line per request", logger.debugs)
+	}
 }
 
 func TestClientIndexURLRequiresCreatedStatus(t *testing.T) {
@@ -170,9 +205,30 @@ func TestClientIndexURLSendsExtractedContent(t *testing.T) {
 		return extractor.Result{Title: "Gotlou docs", Text: "Go docs and examples"}, nil
 	}
 
+	var hooked []string
+	c.OnIndexed = func(_ context.Context, rawURL string, content extractor.Result) {
+		hooked = append(hooked, rawURL+" "+content.Title)
+	}
+
 	if err := c.IndexURL(context.Background(), inputURL); err != nil {
 		t.Fatalf("IndexURL() error = %v", err)
 	}
+	if len(hooked) != 1 || hooked[0] != inputURL+" Gotlou docs" {
+		t.Fatalf("OnIndexed calls = %#v", hooked)
+	}
+
+	// IndexContent submits the same payload without extracting or calling
+	// the hook again.
+	c.Extract = func(context.Context, string) (extractor.Result, error) {
+		t.Fatal("IndexContent() must not extract")
+		return extractor.Result{}, nil
+	}
+	if err := c.IndexContent(context.Background(), inputURL, extractor.Result{Title: "Gotlou docs", Text: "Go docs and examples"}); err != nil {
+		t.Fatalf("IndexContent() error = %v", err)
+	}
+	if len(hooked) != 1 {
+		t.Fatalf("OnIndexed calls = %#v, want none from IndexContent", hooked)
+	}
 }
 
 func TestClientIndexURLReturnsExtractorError(t *testing.T) {
@@ -207,14 +263,76 @@ func TestClientIndexURLReturnsExtractorError(t *testing.T) {
 	}
 }
 
+func TestClientIndexContentGzipsLargeBodies(t *testing.T) {
+	t.Parallel()
+
+	var encodings []string
+	rejectGzip := false
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		encoding := r.Header.Get("Content-Encoding")
+		encodings = append(encodings, encoding)
+		if encoding == "gzip" && rejectGzip {
+			return &http.Response{StatusCode: http.StatusUnsupportedMediaType, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+		}
+		var body io.Reader = r.Body
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Fatalf("gzip.NewReader() error = %v", err)
+			}
+			body = zr
+		}
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		form, err := url.ParseQuery(string(raw))
+		if err != nil || form.Get("url") != "https://example.com/a" {
+			t.Fatalf("unexpected form %q, err=%v", raw, err)
+		}
+		return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.GzipMinBytes = 100
+
+	ctx := context.Background()
+	small := extractor.Result{Title: "Short"}
+	large := extractor.Result{Title: "Long", Text: strings.Repeat("lorem ipsum ", 50)}
+	for _, content := range []extractor.Result{small, large} {
+		if err := c.IndexContent(ctx, "https://example.com/a", content); err != nil {
+			t.Fatalf("IndexContent() error = %v", err)
+		}
+	}
+	if want := []string{"", "gzip"}; strings.Join(encodings, ",") != strings.Join(want, ",") {
+		t.Fatalf("encodings = %q, want %q", encodings, want)
+	}
+
+	// A server without gzip support gets the document again uncompressed,
+	// and later documents are no longer compressed.
+	encodings, rejectGzip = nil, true
+	for i := 0; i < 2; i++ {
+		if err := c.IndexContent(ctx, "https://example.com/a", large); err != nil {
+			t.Fatalf("IndexContent() error = %v", err)
+		}
+	}
+	if want := []string{"gzip", "", ""}; strings.Join(encodings, ",") != strings.Join(want, ",") {
+		t.Fatalf("encodings = %q, want %q", encodings, want)
+	}
+}
+
 func TestClientSearchReconnectsAndParsesDocuments(t *testing.T) {
 	t.Parallel()
 
 	var attempts atomic.Int32
 	conn := &fakeWSConn{}
 	resp := map[string]any{
-		"documents": []map[string]string{
-			{"title": "First", "url": "https://a.example", "text": "Snippet A"},
+		"documents": []map[string]any{
+			{"title": "First", "url": "https://a.example", "text": "Snippet A", "score": 1.5},
 			{"title": "Second", "url": "https://b.example", "text": "Snippet B"},
 		},
 	}
@@ -271,4 +389,720 @@ func TestClientSearchReconnectsAndParsesDocuments(t *testing.T) {
 	if results[0].Snippet != "Snippet A" {
 		t.Fatalf("Search() first snippet = %q, want %q", results[0].Snippet, "Snippet A")
 	}
+	if results[0].Score != 1.5 {
+		t.Fatalf("Search() first score = %v, want 1.5", results[0].Score)
+	}
+}
+
+func TestClientSearchFallsBackToHTTP(t *testing.T) {
+	t.Parallel()
+
+	var posts []string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodPost || r.URL.Path != "/search" || r.URL.Scheme != "https" {
+			t.Errorf("unexpected search request %s %s", r.Method, r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the search token", got)
+		}
+		var q struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Fatalf("decode search request: %v", err)
+		}
+		posts = append(posts, q.Text)
+		body := `{"documents":[{"title":"First","url":"https://a.example","text":"Snippet A"}]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	var dials atomic.Int32
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.DialWS = func(context.Context, string) (wsConn, error) {
+		dials.Add(1)
+		return nil, errors.New("bad handshake")
+	}
+	c.SearchTransport = SearchTransportAuto
+	c.SearchToken = "secret"
+	c.SearchRetries = 1
+	c.RetryBackoff = time.Millisecond
+	c.MaxRetryBackoff = time.Millisecond
+
+	for _, query := range []string{"golang", "rust"} {
+		results, err := c.Search(context.Background(), query, 5)
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", query, err)
+		}
+		if len(results) != 1 || results[0].URL != "https://a.example" || results[0].Snippet != "Snippet A" {
+			t.Fatalf("Search(%q) results = %#v", query, results)
+		}
+	}
+	if dials.Load() != 2 {
+		t.Fatalf("dial attempts = %d, want the second search to skip the websocket", dials.Load())
+	}
+	if want := []string{"golang", "rust"}; fmt.Sprint(posts) != fmt.Sprint(want) {
+		t.Fatalf("http searches = %v, want %v", posts, want)
+	}
+
+	c.SearchTransport = SearchTransportWS
+	c.httpUntil.Store(0)
+	if _, err := c.Search(context.Background(), "go", 5); err == nil || !strings.Contains(err.Error(), "search dial failed") {
+		t.Fatalf("Search() error = %v, want the dial failure without a fallback", err)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("expected no http search for the ws transport, got %v", posts)
+	}
+}
+
+func TestClientSearchPageSendsOffset(t *testing.T) {
+	t.Parallel()
+
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var q struct {
+			Text   string `json:"text"`
+			Offset int    `json:"offset"`
+			Limit  int    `json:"limit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Fatalf("decode search request: %v", err)
+		}
+		if q.Text != "golang" || q.Offset != 10 || q.Limit != 5 {
+			t.Errorf("unexpected search request %#v", q)
+		}
+		body := `{"results":{"total":42,"documents":[{"title":"Eleventh","url":"https://k.example"}]}}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second, func(c *Client) {
+		c.SearchTransport = SearchTransportHTTP
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+
+	page, err := c.SearchPage(context.Background(), "golang", 10, 5)
+	if err != nil {
+		t.Fatalf("SearchPage() error = %v", err)
+	}
+	if page.Total != 42 || len(page.Results) != 1 || page.Results[0].URL != "https://k.example" {
+		t.Fatalf("SearchPage() = %#v", page)
+	}
+}
+
+func TestClientSearchFilteredSendsFilters(t *testing.T) {
+	t.Parallel()
+
+	var got map[string]any
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("decode search request: %v", err)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"documents":[]}`)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second, func(c *Client) {
+		c.SearchTransport = SearchTransportHTTP
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+
+	filters := Filters{
+		Sites: []string{"go.dev"},
+		After: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Rooms: []string{"!room:example.org"},
+	}
+	if _, err := c.SearchFiltered(context.Background(), "generics", filters, 0, 5); err != nil {
+		t.Fatalf("SearchFiltered() error = %v", err)
+	}
+	want := `map[filters:map[after:2024-01-01T00:00:00Z rooms:[!room:example.org] sites:[go.dev]] limit:5 text:generics]`
+	if fmt.Sprint(got) != want {
+		t.Fatalf("search request = %v, want %v", got, want)
+	}
+
+	got = nil
+	if _, err := c.Search(context.Background(), "generics", 5); err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if _, ok := got["filters"]; ok {
+		t.Fatalf("search request without filters = %v", got)
+	}
+}
+
+func TestClientIndexURLFromSendsSource(t *testing.T) {
+	t.Parallel()
+
+	sharedAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		for key, want := range map[string]string{
+			"url":       "https://example.com/a",
+			"room_id":   "!dev:example.org",
+			"sender":    "@alice:example.org",
+			"event_id":  "$evt",
+			"shared_at": "2024-03-01T09:30:00Z",
+		} {
+			if got := r.PostForm.Get(key); got != want {
+				t.Errorf("form %s = %q, want %q", key, got, want)
+			}
+		}
+		return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.Extract = func(context.Context, string) (extractor.Result, error) {
+		return extractor.Result{Title: "A", Text: "Body"}, nil
+	}
+
+	source := Source{RoomID: "!dev:example.org", Sender: "@alice:example.org", EventID: "$evt", SharedAt: sharedAt}
+	if _, err := c.IndexURLFrom(context.Background(), "https://example.com/a", source); err != nil {
+		t.Fatalf("IndexURLFrom() error = %v", err)
+	}
+
+	page, err := parseSearchResults([]byte(`{"documents":[{"url":"https://example.com/a","room_id":"!dev:example.org","sender":"@alice:example.org","event_id":"$evt","shared_at":"2024-03-01T09:30:00Z"}]}`), 0)
+	if err != nil {
+		t.Fatalf("parseSearchResults() error = %v", err)
+	}
+	if len(page.Results) != 1 || page.Results[0].Source != source {
+		t.Fatalf("parsed results = %#v, want source %#v", page.Results, source)
+	}
+}
+
+func TestClientNamespaceScopesRequests(t *testing.T) {
+	t.Parallel()
+
+	var namespaces []string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Header.Get("Content-Type") == "application/json" {
+			var body map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatalf("decode search request: %v", err)
+			}
+			namespace, _ := body["namespace"].(string)
+			namespaces = append(namespaces, "search="+namespace)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"documents":[]}`)), Header: make(http.Header)}, nil
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		namespaces = append(namespaces, r.URL.Path+"="+r.PostForm.Get("namespace"))
+		return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second, func(c *Client) {
+		c.SearchTransport = SearchTransportHTTP
+		c.DeletePath = "/delete"
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.Extract = func(context.Context, string) (extractor.Result, error) {
+		return extractor.Result{Title: "A", Text: "Body"}, nil
+	}
+
+	ctx := context.Background()
+	dev := c.Namespace("!dev:example.org")
+	if err := dev.IndexURL(ctx, "https://example.com/a"); err != nil {
+		t.Fatalf("IndexURL() error = %v", err)
+	}
+	if _, err := dev.Search(ctx, "generics", 5); err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if err := dev.DeleteURL(ctx, "https://example.com/a"); err != nil {
+		t.Fatalf("DeleteURL() error = %v", err)
+	}
+	if _, err := c.Search(ctx, "generics", 5); err != nil {
+		t.Fatalf("default Search() error = %v", err)
+	}
+
+	want := []string{"/add=!dev:example.org", "search=!dev:example.org", "/delete=!dev:example.org", "search="}
+	if !reflect.DeepEqual(namespaces, want) {
+		t.Fatalf("namespaces = %q, want %q", namespaces, want)
+	}
+}
+
+// queuedWSConn answers each read with the next of replies, computed from the
+// requests written so far.
+type queuedWSConn struct {
+	fakeWSConn
+	replies func(written [][]byte) [][]byte
+	read    int
+	closed  bool
+}
+
+func (q *queuedWSConn) ReadMessage() (int, []byte, error) {
+	replies := q.replies(q.written)
+	if q.read >= len(replies) {
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	}
+	q.read++
+	return websocket.TextMessage, replies[q.read-1], nil
+}
+
+func (q *queuedWSConn) Close() error {
+	q.closed = true
+	return nil
+}
+
+func TestClientSearchReusesConnections(t *testing.T) {
+	t.Parallel()
+
+	conn := &queuedWSConn{replies: func(written [][]byte) [][]byte {
+		var out [][]byte
+		for _, req := range written {
+			var q struct{ Text, ID string }
+			_ = json.Unmarshal(req, &q)
+			if q.Text == "second" {
+				// A late answer to an earlier request arrives first.
+				out = append(out, []byte(`{"id":"99","documents":[{"url":"https://stale.example"}]}`))
+			}
+			out = append(out, []byte(fmt.Sprintf(`{"id":%q,"documents":[{"url":"https://%s.example"}]}`, q.ID, q.Text)))
+		}
+		return out
+	}}
+	var dials atomic.Int32
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.DialWS = func(context.Context, string) (wsConn, error) {
+		dials.Add(1)
+		return conn, nil
+	}
+	c.ReuseConnections = true
+	c.PingInterval = -1
+
+	for _, query := range []string{"first", "second"} {
+		results, err := c.Search(context.Background(), query, 5)
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", query, err)
+		}
+		if len(results) != 1 || results[0].URL != "https://"+query+".example" {
+			t.Fatalf("Search(%q) results = %#v", query, results)
+		}
+	}
+	if dials.Load() != 1 || conn.closed {
+		t.Fatalf("dials = %d closed = %t, want one connection kept open", dials.Load(), conn.closed)
+	}
+	var q struct{ ID string }
+	if err := json.Unmarshal(conn.written[1], &q); err != nil || q.ID != "2" {
+		t.Fatalf("second request = %s, want id 2", conn.written[1])
+	}
+
+	c.CloseIdleConnections()
+	if !conn.closed {
+		t.Fatal("expected CloseIdleConnections to close the idle connection")
+	}
+}
+
+func TestClientSearchResendsAfterMidReadDrop(t *testing.T) {
+	t.Parallel()
+
+	dropped := &websocket.CloseError{Code: websocket.CloseAbnormalClosure}
+	conns := []*fakeWSConn{
+		{readErr: dropped},
+		{readErr: dropped},
+		{readMsg: []byte(`{"documents":[{"title":"Go","url":"https://go.dev"}]}`)},
+	}
+	var dials atomic.Int32
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.SearchRetries = 1
+	c.RetryBackoff = time.Millisecond
+	c.DialWS = func(context.Context, string) (wsConn, error) {
+		return conns[dials.Add(1)-1], nil
+	}
+
+	results, err := c.Search(context.Background(), "golang", 5)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 1 || results[0].URL != "https://go.dev" {
+		t.Fatalf("Search() results = %#v", results)
+	}
+	for i, conn := range conns {
+		if len(conn.written) != 1 {
+			t.Fatalf("connection %d got %d queries, want the query re-sent on each", i, len(conn.written))
+		}
+	}
+}
+
+func TestClientSearchReconnectsOnMissedPong(t *testing.T) {
+	t.Parallel()
+
+	var conns atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if conns.Add(1) == 1 {
+			// Stop reading, so pings go unanswered, as on a dead link.
+			<-release
+			return
+		}
+		// Keep reading so pings are answered while the results are slow.
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(150 * time.Millisecond)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"documents":[{"url":"https://go.dev"}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.PingInterval = 20 * time.Millisecond
+	c.PongTimeout = 30 * time.Millisecond
+	c.RetryBackoff = time.Millisecond
+
+	start := time.Now()
+	results, err := c.Search(context.Background(), "golang", 5)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 1 || conns.Load() != 2 {
+		t.Fatalf("Search() results = %#v after %d connections, want one result on the second", results, conns.Load())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Search() took %s, want the dead connection detected by the heartbeat", elapsed)
+	}
+}
+
+func TestClientSearchSendsHandshakeToken(t *testing.T) {
+	t.Parallel()
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "s3cret" && r.URL.Query().Get("token") != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"documents":[{"url":"https://go.dev"}]}`))
+	}))
+	defer srv.Close()
+
+	for _, opt := range []ClientOption{
+		func(c *Client) { c.SearchTokenHeader = "x-api-key" },
+		func(c *Client) { c.SearchTokenParam = "token" },
+	} {
+		c, err := NewClient(srv.URL, 2*time.Second, func(c *Client) { c.SearchToken = "s3cret" }, opt)
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		if results, err := c.Search(context.Background(), "golang", 5); err != nil || len(results) != 1 {
+			t.Fatalf("Search() = %#v, %v", results, err)
+		}
+	}
+
+	c, err := NewClient("https://hister.local", time.Second, func(c *Client) { c.SearchToken = "s3cret" })
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if got := c.searchHeader().Get("Authorization"); got != "Bearer s3cret" {
+		t.Fatalf("Authorization = %q", got)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	t.Parallel()
+
+	status := http.StatusNotFound
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodGet || r.URL.Path != "/" {
+			t.Errorf("unexpected ping request %s %s", r.Method, r.URL.Path)
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v, want nil for a non-5xx response", err)
+	}
+	status = http.StatusBadGateway
+	if err := c.Ping(context.Background()); err == nil {
+		t.Fatal("Ping() expected error for 502, got nil")
+	}
+}
+
+func TestClientHealthy(t *testing.T) {
+	t.Parallel()
+
+	status := http.StatusOK
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodGet || r.URL.Path != "/healthz" {
+			t.Errorf("unexpected health request %s %s", r.Method, r.URL.Path)
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second, func(c *Client) {
+		c.HealthPath = "/healthz"
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+
+	if err := c.Healthy(context.Background()); err != nil {
+		t.Fatalf("Healthy() error = %v, want nil for 200", err)
+	}
+	status = http.StatusNotFound
+	err = c.Healthy(context.Background())
+	if err == nil || !IsMisconfigured(err) {
+		t.Fatalf("Healthy() error = %v, want a misconfiguration for 404", err)
+	}
+	status = http.StatusServiceUnavailable
+	err = c.Healthy(context.Background())
+	if err == nil || IsMisconfigured(err) {
+		t.Fatalf("Healthy() error = %v, want an error that is not a misconfiguration for 503", err)
+	}
+	if !IsMisconfigured(&net.DNSError{Err: "no such host", Name: "hister.local", IsNotFound: true}) {
+		t.Fatal("IsMisconfigured() = false for an unknown host")
+	}
+}
+
+func TestClientDeleteURL(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+	var deleted []string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodPost || r.URL.Path != "/delete" {
+			t.Errorf("unexpected delete request %s %s", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		rawURL := r.PostForm.Get("url")
+		status := http.StatusOK
+		switch {
+		case rawURL == "https://example.com/gone":
+			status = http.StatusNotFound
+		case rawURL == "https://example.com/denied":
+			status = http.StatusForbidden
+		case attempts.Add(1) < 2:
+			status = http.StatusServiceUnavailable
+		default:
+			deleted = append(deleted, rawURL)
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.RetryBackoff = time.Millisecond
+	c.MaxRetryBackoff = time.Millisecond
+
+	if err := c.DeleteURL(context.Background(), "https://example.com/a"); err != nil {
+		t.Fatalf("DeleteURL() error = %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "https://example.com/a" || attempts.Load() != 2 {
+		t.Fatalf("deleted = %v after %d attempts, want the URL deleted on the retry", deleted, attempts.Load())
+	}
+	if err := c.DeleteURL(context.Background(), "https://example.com/gone"); err != nil {
+		t.Fatalf("DeleteURL() error = %v, want nil for an unknown document", err)
+	}
+	if err := c.DeleteURL(context.Background(), "https://example.com/denied"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("DeleteURL() error = %v, want status 403", err)
+	}
+}
+
+func TestClientIndexURLsBatchesDocuments(t *testing.T) {
+	t.Parallel()
+
+	var batches [][]addRequest
+	var singles []string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Path {
+		case "/batch":
+			if got := r.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("unexpected batch content-type: %q", got)
+			}
+			var docs []addRequest
+			if err := json.NewDecoder(r.Body).Decode(&docs); err != nil {
+				t.Fatalf("decode batch: %v", err)
+			}
+			batches = append(batches, docs)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+		case "/add":
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("ParseForm() error = %v", err)
+			}
+			singles = append(singles, r.PostForm.Get("url"))
+			return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+		}
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.BatchAddPath = "/batch"
+	c.BatchSize = 2
+	c.Extract = func(_ context.Context, rawURL string) (extractor.Result, error) {
+		if strings.HasSuffix(rawURL, "/broken") {
+			return extractor.Result{}, errors.New("boom")
+		}
+		return extractor.Result{Title: "Title " + rawURL, Text: "Text"}, nil
+	}
+	var recorded []string
+	c.OnIndexed = func(_ context.Context, rawURL string, _ extractor.Result) {
+		recorded = append(recorded, rawURL)
+	}
+
+	urls := []string{"https://a.example", "https://a.example/broken", "https://b.example", "https://c.example"}
+	errs := c.IndexURLs(context.Background(), urls)
+	if len(errs) != 4 || errs[0] != nil || errs[1] == nil || errs[2] != nil || errs[3] != nil {
+		t.Fatalf("IndexURLs() errs = %v, want only the broken URL to fail", errs)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || batches[0][1].URL != "https://b.example" || batches[1][0].URL != "https://c.example" {
+		t.Fatalf("batches = %#v, want a.example and b.example, then c.example", batches)
+	}
+	if batches[0][0].Title != "Title https://a.example" || len(singles) != 0 {
+		t.Fatalf("unexpected batch %#v or single adds %v", batches[0][0], singles)
+	}
+	if len(recorded) != 3 {
+		t.Fatalf("OnIndexed calls = %v, want one per indexed URL", recorded)
+	}
+
+	c.BatchAddPath = "/missing"
+	errs = c.IndexURLs(context.Background(), []string{"https://d.example", "https://e.example", "https://f.example"})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("IndexURLs() errs[%d] = %v after falling back", i, err)
+		}
+	}
+	if want := []string{"https://d.example", "https://e.example", "https://f.example"}; fmt.Sprint(singles) != fmt.Sprint(want) {
+		t.Fatalf("single adds = %v, want %v", singles, want)
+	}
+}
+
+func TestClientBreakerFailsFastDuringOutage(t *testing.T) {
+	t.Parallel()
+
+	var down atomic.Bool
+	down.Store(true)
+	var pings atomic.Int32
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		pings.Add(1)
+		if down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header)}, nil
+	})
+	blob, _ := json.Marshal(map[string]any{"documents": []map[string]any{{"url": "https://a.example"}}})
+	var dials atomic.Int32
+	c, err := NewClient("https://hister.local", 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	c.DialWS = func(context.Context, string) (wsConn, error) {
+		dials.Add(1)
+		if down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeWSConn{readMsg: blob}, nil
+	}
+	c.SearchRetries = 1
+	c.RetryBackoff = time.Millisecond
+	c.MaxRetryBackoff = time.Millisecond
+	c.BreakerThreshold = 2
+	c.BreakerCooldown = time.Hour
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Search(context.Background(), "go", 5); err == nil || errors.Is(err, ErrUnavailable) {
+			t.Fatalf("Search() #%d error = %v, want the dial failure", i+1, err)
+		}
+	}
+	if _, err := c.Search(context.Background(), "go", 5); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Search() error = %v, want ErrUnavailable once the breaker is open", err)
+	}
+	if err := c.IndexContent(context.Background(), "https://a.example", extractor.Result{}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("IndexContent() error = %v, want ErrUnavailable", err)
+	}
+	if dials.Load() != 4 || pings.Load() != 0 {
+		t.Fatalf("dials = %d pings = %d, want no requests while open", dials.Load(), pings.Load())
+	}
+
+	// Once the cooldown is over, a failed probe keeps the breaker open and a
+	// successful one closes it.
+	c.breaker.openUntil = time.Time{}
+	if _, err := c.Search(context.Background(), "go", 5); !errors.Is(err, ErrUnavailable) || pings.Load() != 1 || dials.Load() != 4 {
+		t.Fatalf("Search() error = %v pings = %d dials = %d, want a failed probe", err, pings.Load(), dials.Load())
+	}
+	down.Store(false)
+	c.breaker.openUntil = time.Time{}
+	if results, err := c.Search(context.Background(), "go", 5); err != nil || len(results) != 1 {
+		t.Fatalf("Search() = %v, %v, want results after a successful probe", results, err)
+	}
+}
+
+func TestClassifyIndexError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want FailureReason
+	}{
+		{fmt.Errorf("extract URL content: %w", &extractor.StatusError{StatusCode: http.StatusPaymentRequired}), FailurePaywalled},
+		{fmt.Errorf("extract URL content: %w", &extractor.StatusError{StatusCode: http.StatusForbidden}), FailurePaywalled},
+		{fmt.Errorf("extract URL content: %w", &extractor.StatusError{StatusCode: http.StatusBadGateway}), FailureServerError},
+		{fmt.Errorf("extract URL content: %w", &extractor.StatusError{StatusCode: http.StatusNotFound}), FailureOther},
+		{fmt.Errorf("extract URL content: %w", extractor.ErrTooLarge), FailureTooLarge},
+		{&addStatusError{StatusCode: http.StatusRequestEntityTooLarge}, FailureTooLarge},
+		{&addStatusError{StatusCode: http.StatusForbidden, Body: "domain is skipped"}, FailureBlocked},
+		{&addStatusError{StatusCode: http.StatusServiceUnavailable}, FailureServerError},
+		{errors.New("dial tcp: connection refused"), FailureOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyIndexError(tt.err); got != tt.want {
+			t.Errorf("ClassifyIndexError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
 }
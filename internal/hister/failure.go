@@ -0,0 +1,58 @@
+package hister
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+)
+
+// FailureReason classifies why a URL could not be indexed.
+type FailureReason string
+
+const (
+	// FailurePaywalled means the page asked for a login or payment.
+	FailurePaywalled FailureReason = "paywalled"
+	// FailureTooLarge means the page, or the document sent to Hister, was too big.
+	FailureTooLarge FailureReason = "too_large"
+	// FailureServerError means the site or Hister answered with a 5xx status.
+	FailureServerError FailureReason = "server_error"
+	// FailureBlocked means Hister refused the URL, e.g. because its domain is
+	// on a skip list, or the site is blocked for legal reasons.
+	FailureBlocked FailureReason = "blocked"
+	// FailureOther covers everything else, such as DNS or connection errors.
+	FailureOther FailureReason = "other"
+)
+
+// ClassifyIndexError returns why IndexURL or IndexURLContent failed with err.
+func ClassifyIndexError(err error) FailureReason {
+	if errors.Is(err, extractor.ErrTooLarge) {
+		return FailureTooLarge
+	}
+
+	var page *extractor.StatusError
+	if errors.As(err, &page) {
+		switch code := page.StatusCode; {
+		case code == http.StatusUnauthorized || code == http.StatusPaymentRequired || code == http.StatusForbidden:
+			return FailurePaywalled
+		case code == http.StatusUnavailableForLegalReasons:
+			return FailureBlocked
+		case code >= 500:
+			return FailureServerError
+		}
+		return FailureOther
+	}
+
+	var add *addStatusError
+	if errors.As(err, &add) {
+		switch code := add.StatusCode; {
+		case code == http.StatusRequestEntityTooLarge:
+			return FailureTooLarge
+		case code == http.StatusForbidden || code == http.StatusNotAcceptable || code == http.StatusUnavailableForLegalReasons:
+			return FailureBlocked
+		case code >= 500:
+			return FailureServerError
+		}
+	}
+	return FailureOther
+}
@@ -0,0 +1,88 @@
+package hister
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// StatusError is a health check answered with an unexpected status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+// Ping checks that Hister answers HTTP requests at its base URL. Any response
+// below 500 counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.prepare(); err != nil {
+		return err
+	}
+	status, err := c.get(ctx, "/")
+	if err != nil {
+		return fmt.Errorf("ping hister: %w", err)
+	}
+	if status >= http.StatusInternalServerError {
+		return fmt.Errorf("ping hister: %w", &StatusError{StatusCode: status})
+	}
+	return nil
+}
+
+// Healthy checks that HealthPath answers with a 2xx status, which also
+// catches a wrong base URL or a proxy refusing the bot. Without HealthPath
+// it is Ping.
+func (c *Client) Healthy(ctx context.Context) error {
+	if err := c.prepare(); err != nil {
+		return err
+	}
+	if c.HealthPath == "" {
+		return c.Ping(ctx)
+	}
+	status, err := c.get(ctx, c.HealthPath)
+	if err != nil {
+		return fmt.Errorf("hister health check: %w", err)
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("hister health check: %w", &StatusError{StatusCode: status})
+	}
+	return nil
+}
+
+// IsMisconfigured reports whether a failed Healthy or Ping points at the
+// bot's configuration, such as an unknown host or an endpoint answering 4xx,
+// rather than at Hister being down for now.
+func IsMisconfigured(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 400 && status.StatusCode < 500
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// get requests path and returns the response status.
+func (c *Client) get(ctx context.Context, path string) (int, error) {
+	endpoint, err := c.endpoint(path, false)
+	if err != nil {
+		return 0, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, fmt.Errorf("build request: %w", err)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, nil
+}
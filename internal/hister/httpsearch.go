@@ -0,0 +1,101 @@
+package hister
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Search transports for Client.SearchTransport.
+const (
+	SearchTransportWS   = "ws"
+	SearchTransportHTTP = "http"
+	SearchTransportAuto = "auto"
+)
+
+// httpFallbackPeriod is how long SearchTransportAuto keeps searching over
+// HTTP after the websocket could not be dialed, before trying it again.
+const httpFallbackPeriod = 5 * time.Minute
+
+// useHTTPSearch reports whether Search should skip the websocket.
+func (c *Client) useHTTPSearch() bool {
+	switch c.SearchTransport {
+	case SearchTransportHTTP:
+		return true
+	case SearchTransportAuto:
+		return time.Now().UnixNano() < c.httpUntil.Load()
+	}
+	return false
+}
+
+// fallBackToHTTP retries a search whose websocket dial failed with dialErr
+// over HTTP, and keeps using HTTP for httpFallbackPeriod.
+func (c *Client) fallBackToHTTP(ctx context.Context, query string, reqBody []byte, limit int, dialErr error) (Page, error) {
+	c.logf("hister search falling back to http err=%v", dialErr)
+	c.httpUntil.Store(time.Now().Add(httpFallbackPeriod).UnixNano())
+	page, err := c.searchHTTP(ctx, query, reqBody, limit)
+	if err != nil {
+		return Page{}, outage(fmt.Errorf("%w; http fallback: %w", dialErr, err))
+	}
+	return page, nil
+}
+
+// searchHTTP POSTs the search request to SearchHTTPPath and parses the
+// response like a websocket one. Connection errors and 5xx responses are
+// retried up to SearchRetries times.
+func (c *Client) searchHTTP(ctx context.Context, query string, reqBody []byte, limit int) (Page, error) {
+	endpoint, err := c.searchURL(c.SearchHTTPPath, false)
+	if err != nil {
+		return Page{}, err
+	}
+	searchCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(searchCtx, http.MethodPost, endpoint, bytes.NewReader(reqBody))
+		if err != nil {
+			return Page{}, fmt.Errorf("create search request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, values := range c.searchHeader() {
+			req.Header[name] = values
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err == nil {
+			body, readErr := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+			_ = resp.Body.Close()
+			switch {
+			case readErr != nil:
+				err = fmt.Errorf("read search response: %w", readErr)
+			case resp.StatusCode == http.StatusOK:
+				page, err := parseSearchResults(body, limit)
+				if err != nil {
+					return Page{}, err
+				}
+				c.debugf("hister http search query=%q results=%d total=%d", query, len(page.Results), page.Total)
+				return page, nil
+			case resp.StatusCode < 500:
+				if msg := strings.TrimSpace(string(body)); msg != "" && len(msg) < 512 {
+					return Page{}, fmt.Errorf("search request failed with status %d: %s", resp.StatusCode, msg)
+				}
+				return Page{}, fmt.Errorf("search request failed with status %d", resp.StatusCode)
+			default:
+				err = fmt.Errorf("search request failed with status %d", resp.StatusCode)
+			}
+		} else if ctx.Err() != nil {
+			return Page{}, ctx.Err()
+		}
+		if attempt >= c.SearchRetries || searchCtx.Err() != nil {
+			return Page{}, outage(fmt.Errorf("search request failed after %d attempts: %w", attempt+1, err))
+		}
+		c.logf("hister http search retry attempt=%d err=%v", attempt+1, err)
+		if sleepWithContext(searchCtx, c.retryDelay(attempt)) != nil {
+			return Page{}, outage(fmt.Errorf("search request failed after %d attempts: %w", attempt+1, err))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package hister
+
+import (
+	"context"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+)
+
+// Namespaced is a Client scoped to one logical index of a Hister instance.
+// Every document it adds or deletes and every search it runs carries a
+// "namespace" field, so documents added in one namespace are not found by
+// searches in another.
+type Namespaced struct {
+	client    *Client
+	namespace string
+}
+
+// Namespace returns c scoped to namespace. An empty namespace is Hister's
+// default one, which plain Client calls use.
+func (c *Client) Namespace(namespace string) *Namespaced {
+	return &Namespaced{client: c, namespace: namespace}
+}
+
+// Name returns the namespace n is scoped to.
+func (n *Namespaced) Name() string {
+	return n.namespace
+}
+
+func (n *Namespaced) IndexURL(ctx context.Context, rawURL string) error {
+	_, err := n.IndexURLContent(ctx, rawURL)
+	return err
+}
+
+func (n *Namespaced) IndexURLContent(ctx context.Context, rawURL string) (extractor.Result, error) {
+	return n.client.indexURL(ctx, n.namespace, rawURL, Source{})
+}
+
+func (n *Namespaced) IndexURLFrom(ctx context.Context, rawURL string, source Source) (extractor.Result, error) {
+	return n.client.indexURL(ctx, n.namespace, rawURL, source)
+}
+
+func (n *Namespaced) IndexURLs(ctx context.Context, urls []string) []error {
+	return n.client.indexURLs(ctx, n.namespace, urls, nil)
+}
+
+func (n *Namespaced) IndexURLsFrom(ctx context.Context, urls []string, sources []Source) []error {
+	return n.client.indexURLs(ctx, n.namespace, urls, sources)
+}
+
+func (n *Namespaced) IndexContent(ctx context.Context, rawURL string, content extractor.Result) error {
+	return n.client.indexContent(ctx, n.namespace, rawURL, content)
+}
+
+func (n *Namespaced) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	page, err := n.client.searchIn(ctx, n.namespace, query, Filters{}, 0, limit)
+	return page.Results, err
+}
+
+func (n *Namespaced) SearchPage(ctx context.Context, query string, offset, limit int) (Page, error) {
+	return n.client.searchIn(ctx, n.namespace, query, Filters{}, offset, limit)
+}
+
+func (n *Namespaced) SearchFiltered(ctx context.Context, query string, filters Filters, offset, limit int) (Page, error) {
+	return n.client.searchIn(ctx, n.namespace, query, filters, offset, limit)
+}
+
+func (n *Namespaced) DeleteURL(ctx context.Context, rawURL string) error {
+	return n.client.deleteIn(ctx, n.namespace, rawURL)
+}
@@ -0,0 +1,85 @@
+package hister
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns    = 2
+	defaultIdleConnTimeout = 90 * time.Second
+)
+
+// connPool keeps search websockets open between searches when
+// Client.ReuseConnections is set. Idle connections are pinged every
+// PingInterval so proxies do not drop them.
+type connPool struct {
+	mu   sync.Mutex
+	idle []idleConn
+}
+
+type idleConn struct {
+	conn  wsConn
+	since time.Time
+	stop  chan struct{}
+}
+
+// acquireConn returns an idle connection that has not been idle longer than
+// IdleConnTimeout, or dials a new one. reused reports which it was.
+func (c *Client) acquireConn(ctx context.Context, wsURL string) (conn wsConn, reused bool, err error) {
+	if c.ReuseConnections {
+		if conn := c.takeIdleConn(); conn != nil {
+			return conn, true, nil
+		}
+	}
+	conn, err = c.DialWS(ctx, wsURL)
+	return conn, false, err
+}
+
+func (c *Client) takeIdleConn() wsConn {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	for len(c.pool.idle) > 0 {
+		last := c.pool.idle[len(c.pool.idle)-1]
+		c.pool.idle = c.pool.idle[:len(c.pool.idle)-1]
+		close(last.stop)
+		if time.Since(last.since) < c.IdleConnTimeout {
+			return last.conn
+		}
+		_ = last.conn.Close()
+	}
+	return nil
+}
+
+// releaseConn keeps conn for the next search if ReuseConnections is set and
+// fewer than MaxIdleConns are idle, and closes it otherwise.
+func (c *Client) releaseConn(conn wsConn) {
+	if !c.ReuseConnections {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	if len(c.pool.idle) >= c.MaxIdleConns {
+		_ = conn.Close()
+		return
+	}
+	idle := idleConn{conn: conn, since: time.Now(), stop: make(chan struct{})}
+	if c.PingInterval > 0 {
+		go c.heartbeat(conn, idle.stop)
+	}
+	c.pool.idle = append(c.pool.idle, idle)
+}
+
+// CloseIdleConnections closes the search websockets kept for reuse.
+func (c *Client) CloseIdleConnections() {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	for _, idle := range c.pool.idle {
+		close(idle.stop)
+		_ = idle.conn.Close()
+	}
+	c.pool.idle = nil
+}
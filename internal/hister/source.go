@@ -0,0 +1,61 @@
+package hister
+
+import (
+	"net/url"
+	"time"
+)
+
+// Source is where a document's link was shared in Matrix. Documents added
+// with one carry it as room_id, sender, event_id and shared_at fields, which
+// Hister returns with search results.
+type Source struct {
+	RoomID   string
+	Sender   string
+	EventID  string
+	SharedAt time.Time
+}
+
+// IsZero reports whether s has no fields set.
+func (s Source) IsZero() bool {
+	return s.RoomID == "" && s.Sender == "" && s.EventID == "" && s.SharedAt.IsZero()
+}
+
+// sourceFields is Source as sent to and returned by Hister, with the time in
+// RFC 3339.
+type sourceFields struct {
+	RoomID   string `json:"room_id,omitempty"`
+	Sender   string `json:"sender,omitempty"`
+	EventID  string `json:"event_id,omitempty"`
+	SharedAt string `json:"shared_at,omitempty"`
+}
+
+func (s Source) fields() sourceFields {
+	f := sourceFields{RoomID: s.RoomID, Sender: s.Sender, EventID: s.EventID}
+	if !s.SharedAt.IsZero() {
+		f.SharedAt = s.SharedAt.UTC().Format(time.RFC3339)
+	}
+	return f
+}
+
+// setForm adds the set fields to an /add form.
+func (f sourceFields) setForm(form url.Values) {
+	for key, value := range map[string]string{
+		"room_id":   f.RoomID,
+		"sender":    f.Sender,
+		"event_id":  f.EventID,
+		"shared_at": f.SharedAt,
+	} {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+}
+
+// source parses f from a search result. A malformed time is left out.
+func (f sourceFields) source() Source {
+	s := Source{RoomID: f.RoomID, Sender: f.Sender, EventID: f.EventID}
+	if t, err := time.Parse(time.RFC3339, f.SharedAt); err == nil {
+		s.SharedAt = t
+	}
+	return s
+}
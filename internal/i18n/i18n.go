@@ -0,0 +1,458 @@
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLanguage is the fallback for missing languages and translations.
+const DefaultLanguage = "en"
+
+// Key identifies a user-facing bot response.
+type Key string
+
+const (
+	InvalidQuery         Key = "invalid_query"
+	QueryTooLong         Key = "query_too_long"
+	SearchFailed         Key = "search_failed"
+	SearchUnavailable    Key = "search_unavailable"
+	SearchResults        Key = "search_results"
+	SearchCached         Key = "search_cached"
+	ChatResults          Key = "chat_results"
+	ResultsPage          Key = "results_page"
+	ResultSource         Key = "result_source"
+	Spoiler              Key = "spoiler"
+	NoResultPages        Key = "no_result_pages"
+	NoResults            Key = "no_results"
+	NoResultsQuery       Key = "no_results_query"
+	NoResultsIndexSize   Key = "no_results_index_size"
+	NoResultsClosest     Key = "no_results_closest"
+	IndexFailed          Key = "index_failed"
+	Indexed              Key = "indexed"
+	IndexFailures        Key = "index_failures"
+	FailurePaywalled     Key = "failure_paywalled"
+	FailureTooLarge      Key = "failure_too_large"
+	FailureServer        Key = "failure_server"
+	FailureBlocked       Key = "failure_blocked"
+	FailureOther         Key = "failure_other"
+	CatchMeUpFailed      Key = "catchmeup_failed"
+	CatchMeUpEmpty       Key = "catchmeup_empty"
+	CatchMeUpRestricted  Key = "catchmeup_restricted"
+	CooldownSearch       Key = "cooldown_search"
+	CooldownCatchMeUp    Key = "cooldown_catchmeup"
+	BulkIndexPrompt      Key = "bulk_index_prompt"
+	BulkIndexDone        Key = "bulk_index_done"
+	DidYouMean           Key = "did_you_mean"
+	BroadcastUsage       Key = "broadcast_usage"
+	BroadcastUnknownRoom Key = "broadcast_unknown_room"
+	BroadcastDone        Key = "broadcast_done"
+	VersionInfo          Key = "version_info"
+	ForgetUsage          Key = "forget_usage"
+	Forgotten            Key = "forgotten"
+	ForgetFailed         Key = "forget_failed"
+	Digest               Key = "digest"
+	DigestAttached       Key = "digest_attached"
+	SummaryTitle         Key = "summary_title"
+	BackfillStarted      Key = "backfill_started"
+	BackfillBusy         Key = "backfill_busy"
+	BackfillDone         Key = "backfill_done"
+	BackfillWindow       Key = "backfill_window"
+	BackfillUsage        Key = "backfill_usage"
+	WatchAdded           Key = "watch_added"
+	WatchList            Key = "watch_list"
+	WatchNone            Key = "watch_none"
+	WatchLimit           Key = "watch_limit"
+	WatchRemoved         Key = "watch_removed"
+	WatchNotFound        Key = "watch_not_found"
+	UnwatchUsage         Key = "unwatch_usage"
+	ReminderSet          Key = "reminder_set"
+	ReminderUsage        Key = "reminder_usage"
+	ReminderDue          Key = "reminder_due"
+	DeadLinksReport      Key = "dead_links_report"
+	DeadLinkMarker       Key = "dead_link_marker"
+	TopLinksReport       Key = "top_links_report"
+	TopLinksShared       Key = "top_links_shared"
+	TopLinksSearched     Key = "top_links_searched"
+	KeysDirectOnly       Key = "keys_direct_only"
+	ExportKeysUsage      Key = "export_keys_usage"
+	ImportKeysUsage      Key = "import_keys_usage"
+	KeysNone             Key = "keys_none"
+	KeysFailed           Key = "keys_failed"
+	KeysExported         Key = "keys_exported"
+	KeysImported         Key = "keys_imported"
+	WatchMatches         Key = "watch_matches"
+	Welcome              Key = "welcome"
+	WelcomeSearch        Key = "welcome_search"
+	WelcomeCatchMeUp     Key = "welcome_catchmeup"
+	WelcomeIndexing      Key = "welcome_indexing"
+	WelcomeIndexReaction Key = "welcome_index_reaction"
+	WelcomeIgnore        Key = "welcome_ignore"
+)
+
+// Bundle maps keys to message templates. Templates use {name} placeholders.
+type Bundle map[Key]string
+
+// Vars are placeholder values for a template.
+type Vars map[string]string
+
+var builtin = map[string]Bundle{
+	"en": {
+		InvalidQuery:         "Invalid search query.",
+		QueryTooLong:         "Query too long (max {max} characters).",
+		SearchFailed:         "Search failed, please try again.",
+		SearchUnavailable:    "The search backend is unavailable right now, please try again in a few minutes.",
+		SearchResults:        "Search results for: {query}",
+		SearchCached:         "(cached)",
+		ChatResults:          "Messages in this room matching: {query}",
+		ResultsPage:          "Page {page} of {pages}. {next} and {prev} show the other pages.",
+		ResultSource:         "Shared by {sender} {age} ago in {room}",
+		Spoiler:              "[Spoiler]",
+		NoResultPages:        "There are no search results to page through here.",
+		NoResults:            "No results for: {query}",
+		NoResultsQuery:       "Searched for: {query}",
+		NoResultsIndexSize:   "The index has {count} documents.",
+		NoResultsClosest:     "Closest titles:",
+		IndexFailed:          "Indexing failed, please try again.",
+		Indexed:              "Indexed {url}",
+		IndexFailures:        "I couldn't index these links, so they won't turn up in searches:",
+		FailurePaywalled:     "paywalled or behind a login",
+		FailureTooLarge:      "the page is too large",
+		FailureServer:        "the server returned an error",
+		FailureBlocked:       "the domain is blocked from indexing",
+		FailureOther:         "the page could not be fetched",
+		CatchMeUpFailed:      "Catch-up failed, please try again.",
+		CatchMeUpEmpty:       "No recent messages to catch up on.",
+		CatchMeUpRestricted:  "This room's history is only visible from when you joined, so I can't summarize the last day for you.",
+		CooldownSearch:       "You're searching too often. Please try again in {wait}.",
+		CooldownCatchMeUp:    "You're catching up too often. Please try again in {wait}.",
+		BulkIndexPrompt:      "This message has {count} links, more than the {max} indexed automatically. React to it with {reaction} to index them all.",
+		BulkIndexDone:        "Indexed {indexed} of {total} links.",
+		DidYouMean:           "Did you mean {command}?",
+		BroadcastUsage:       "Usage: {command} [!room:server ...] <announcement>",
+		BroadcastUnknownRoom: "{room} is not an allowed room.",
+		BroadcastDone:        "Announcement sent to {sent} of {total} rooms.",
+		VersionInfo:          "hister-matrix-bot {version}",
+		ForgetUsage:          "Usage: {command} <url>",
+		Forgotten:            "Removed {url} from the search index.",
+		ForgetFailed:         "Could not remove {url} from the search index, please try again.",
+		Digest:               "Daily digest for {date}:\n{summary}",
+		DigestAttached:       "Daily digest for {date}, full summary attached:\n{preview}",
+		SummaryTitle:         "Discussion summary, {from} to {to}",
+		BackfillStarted:      "Backfilling links from up to {depth} past messages in this room.",
+		BackfillBusy:         "Too many backfills are queued, please try again later.",
+		BackfillDone:         "Backfill finished: indexed {indexed} of {total} links.",
+		BackfillWindow:       "Backfilling links from the last {window} in this room.",
+		BackfillUsage:        "Usage: {command} [age, e.g. 90d]",
+		WatchAdded:           "Watching \"{query}\" as #{id}. New matches will be posted in this thread; stop with {command} {id}.",
+		WatchList:            "Your watches:",
+		WatchNone:            "You have no watches. Start one with {command} <query>.",
+		WatchLimit:           "You already have {max} watches; remove one with {command} <id> first.",
+		WatchRemoved:         "Stopped watching #{id}.",
+		WatchNotFound:        "You have no watch #{id}.",
+		UnwatchUsage:         "Usage: {command} <id>",
+		WatchMatches:         "{user}: new results for \"{query}\":",
+		ReminderSet:          "OK, I'll remind you at {time}.",
+		ReminderUsage:        "Usage: {command} in <duration> about <text>, e.g. {command} in 2h about the deploy. Reminders can be set up to a year ahead.",
+		ReminderDue:          "{user}: reminder: {text}",
+		DeadLinksReport:      "Found {count} dead links:",
+		DeadLinkMarker:       "[dead link]",
+		TopLinksReport:       "Top links for the week of {date}",
+		TopLinksShared:       "Most shared:",
+		TopLinksSearched:     "Most searched:",
+		KeysDirectOnly:       "{command} only works in a direct chat with me.",
+		ExportKeysUsage:      "Usage: {command} <passphrase>",
+		ImportKeysUsage:      "Usage: reply to a key export file with {command} <passphrase>",
+		KeysNone:             "I have no room keys to export yet.",
+		KeysFailed:           "Sorry, that didn't work. Check the passphrase and try again.",
+		KeysExported:         "Exported {count} room keys. Delete your message with the passphrase.",
+		KeysImported:         "Imported {imported} new room keys ({total} in the file). Delete your message with the passphrase.",
+		Welcome:              "Hi! I keep the links shared in this room searchable.",
+		WelcomeSearch:        "- {command} <terms> searches them.",
+		WelcomeCatchMeUp:     "- {command} summarizes recent chat.",
+		WelcomeIndexing:      "- Links in messages are indexed automatically.",
+		WelcomeIndexReaction: "React with {reaction} to index the links of an older message.",
+		WelcomeIgnore:        "- Start a message with {prefix} to keep its links out of the index.",
+	},
+	"de": {
+		InvalidQuery:         "Ungültige Suchanfrage.",
+		QueryTooLong:         "Suchanfrage zu lang (maximal {max} Zeichen).",
+		SearchFailed:         "Suche fehlgeschlagen, bitte versuche es erneut.",
+		SearchUnavailable:    "Die Suche ist gerade nicht erreichbar, bitte versuche es in ein paar Minuten erneut.",
+		SearchResults:        "Suchergebnisse für: {query}",
+		SearchCached:         "(zwischengespeichert)",
+		ChatResults:          "Nachrichten in diesem Raum zu: {query}",
+		ResultsPage:          "Seite {page} von {pages}. {next} und {prev} zeigen die anderen Seiten.",
+		ResultSource:         "Geteilt von {sender} vor {age} in {room}",
+		Spoiler:              "[Versteckter Inhalt]",
+		NoResultPages:        "Hier gibt es keine Suchergebnisse zum Blättern.",
+		NoResults:            "Keine Ergebnisse für: {query}",
+		NoResultsQuery:       "Gesucht wurde nach: {query}",
+		NoResultsIndexSize:   "Der Index enthält {count} Dokumente.",
+		NoResultsClosest:     "Ähnlichste Titel:",
+		IndexFailed:          "Indexierung fehlgeschlagen, bitte versuche es erneut.",
+		Indexed:              "{url} wurde indexiert",
+		IndexFailures:        "Diese Links konnte ich nicht indexieren, daher tauchen sie nicht in Suchen auf:",
+		FailurePaywalled:     "Paywall oder Anmeldung erforderlich",
+		FailureTooLarge:      "die Seite ist zu groß",
+		FailureServer:        "der Server hat einen Fehler gemeldet",
+		FailureBlocked:       "die Domain ist von der Indexierung ausgeschlossen",
+		FailureOther:         "die Seite konnte nicht abgerufen werden",
+		CatchMeUpFailed:      "Zusammenfassung fehlgeschlagen, bitte versuche es erneut.",
+		CatchMeUpEmpty:       "Keine neuen Nachrichten zum Zusammenfassen.",
+		CatchMeUpRestricted:  "Der Verlauf dieses Raums ist erst ab deinem Beitritt sichtbar, daher kann ich den letzten Tag nicht für dich zusammenfassen.",
+		CooldownSearch:       "Du suchst zu oft. Bitte versuche es in {wait} erneut.",
+		CooldownCatchMeUp:    "Du forderst zu oft Zusammenfassungen an. Bitte versuche es in {wait} erneut.",
+		BulkIndexPrompt:      "Diese Nachricht enthält {count} Links, mehr als die {max}, die automatisch indexiert werden. Reagiere mit {reaction}, um alle zu indexieren.",
+		BulkIndexDone:        "{indexed} von {total} Links indexiert.",
+		DidYouMean:           "Meintest du {command}?",
+		BroadcastUsage:       "Verwendung: {command} [!raum:server ...] <Ankündigung>",
+		BroadcastUnknownRoom: "{room} ist kein erlaubter Raum.",
+		BroadcastDone:        "Ankündigung an {sent} von {total} Räumen gesendet.",
+		VersionInfo:          "hister-matrix-bot {version}",
+		ForgetUsage:          "Verwendung: {command} <URL>",
+		Forgotten:            "{url} wurde aus dem Suchindex entfernt.",
+		ForgetFailed:         "{url} konnte nicht aus dem Suchindex entfernt werden, bitte versuche es erneut.",
+		Digest:               "Tageszusammenfassung für {date}:\n{summary}",
+		DigestAttached:       "Tageszusammenfassung für {date}, vollständig im Anhang:\n{preview}",
+		SummaryTitle:         "Zusammenfassung der Unterhaltung, {from} bis {to}",
+		BackfillStarted:      "Indexiere Links aus bis zu {depth} früheren Nachrichten in diesem Raum.",
+		BackfillBusy:         "Es warten zu viele Nachindexierungen, bitte versuche es später erneut.",
+		BackfillDone:         "Nachindexierung abgeschlossen: {indexed} von {total} Links indexiert.",
+		BackfillWindow:       "Indexiere Links aus den letzten {window} in diesem Raum.",
+		BackfillUsage:        "Verwendung: {command} [Zeitraum, z. B. 90d]",
+		WatchAdded:           "Beobachte „{query}“ als #{id}. Neue Treffer erscheinen in diesem Thread; beenden mit {command} {id}.",
+		WatchList:            "Deine Beobachtungen:",
+		WatchNone:            "Du beobachtest nichts. Starte mit {command} <Suchbegriff>.",
+		WatchLimit:           "Du hast bereits {max} Beobachtungen; entferne zuerst eine mit {command} <id>.",
+		WatchRemoved:         "Beobachtung #{id} beendet.",
+		WatchNotFound:        "Du hast keine Beobachtung #{id}.",
+		UnwatchUsage:         "Verwendung: {command} <id>",
+		WatchMatches:         "{user}: neue Ergebnisse für „{query}“:",
+		ReminderSet:          "Alles klar, ich erinnere dich am {time}.",
+		ReminderUsage:        "Verwendung: {command} in <Dauer> about <Text>, z. B. {command} in 2h about das Deployment. Erinnerungen sind bis zu einem Jahr im Voraus möglich.",
+		ReminderDue:          "{user}: Erinnerung: {text}",
+		DeadLinksReport:      "{count} tote Links gefunden:",
+		DeadLinkMarker:       "[toter Link]",
+		TopLinksReport:       "Top-Links der Woche ab {date}",
+		TopLinksShared:       "Am häufigsten geteilt:",
+		TopLinksSearched:     "Am häufigsten gesucht:",
+		KeysDirectOnly:       "{command} funktioniert nur in einem Direktchat mit mir.",
+		ExportKeysUsage:      "Verwendung: {command} <Passphrase>",
+		ImportKeysUsage:      "Verwendung: auf eine Schlüsselexport-Datei mit {command} <Passphrase> antworten",
+		KeysNone:             "Ich habe noch keine Raumschlüssel zum Exportieren.",
+		KeysFailed:           "Das hat leider nicht geklappt. Prüfe die Passphrase und versuche es erneut.",
+		KeysExported:         "{count} Raumschlüssel exportiert. Lösche deine Nachricht mit der Passphrase.",
+		KeysImported:         "{imported} neue Raumschlüssel importiert ({total} in der Datei). Lösche deine Nachricht mit der Passphrase.",
+		Welcome:              "Hallo! Ich mache die in diesem Raum geteilten Links durchsuchbar.",
+		WelcomeSearch:        "- {command} <Begriffe> durchsucht sie.",
+		WelcomeCatchMeUp:     "- {command} fasst den letzten Chatverlauf zusammen.",
+		WelcomeIndexing:      "- Links in Nachrichten werden automatisch indexiert.",
+		WelcomeIndexReaction: "Reagiere mit {reaction}, um die Links einer älteren Nachricht zu indexieren.",
+		WelcomeIgnore:        "- Beginne eine Nachricht mit {prefix}, um ihre Links aus dem Index herauszuhalten.",
+	},
+	"es": {
+		InvalidQuery:         "Consulta de búsqueda no válida.",
+		QueryTooLong:         "Consulta demasiado larga (máximo {max} caracteres).",
+		SearchFailed:         "La búsqueda falló, inténtalo de nuevo.",
+		SearchUnavailable:    "El buscador no está disponible ahora mismo, inténtalo de nuevo en unos minutos.",
+		SearchResults:        "Resultados para: {query}",
+		SearchCached:         "(en caché)",
+		ChatResults:          "Mensajes de esta sala para: {query}",
+		ResultsPage:          "Página {page} de {pages}. {next} y {prev} muestran las demás páginas.",
+		ResultSource:         "Compartido por {sender} hace {age} en {room}",
+		Spoiler:              "[Contenido oculto]",
+		NoResultPages:        "Aquí no hay resultados de búsqueda que hojear.",
+		NoResults:            "Sin resultados para: {query}",
+		NoResultsQuery:       "Se buscó: {query}",
+		NoResultsIndexSize:   "El índice tiene {count} documentos.",
+		NoResultsClosest:     "Títulos más parecidos:",
+		IndexFailed:          "La indexación falló, inténtalo de nuevo.",
+		Indexed:              "Indexado {url}",
+		IndexFailures:        "No pude indexar estos enlaces, así que no aparecerán en las búsquedas:",
+		FailurePaywalled:     "tiene muro de pago o requiere iniciar sesión",
+		FailureTooLarge:      "la página es demasiado grande",
+		FailureServer:        "el servidor devolvió un error",
+		FailureBlocked:       "el dominio está bloqueado para indexación",
+		FailureOther:         "no se pudo obtener la página",
+		CatchMeUpFailed:      "El resumen falló, inténtalo de nuevo.",
+		CatchMeUpEmpty:       "No hay mensajes recientes para resumir.",
+		CatchMeUpRestricted:  "El historial de esta sala solo es visible desde que te uniste, así que no puedo resumir el último día para ti.",
+		CooldownSearch:       "Estás buscando demasiado seguido. Inténtalo de nuevo en {wait}.",
+		CooldownCatchMeUp:    "Estás pidiendo resúmenes demasiado seguido. Inténtalo de nuevo en {wait}.",
+		BulkIndexPrompt:      "Este mensaje tiene {count} enlaces, más de los {max} que se indexan automáticamente. Reacciona con {reaction} para indexarlos todos.",
+		BulkIndexDone:        "Indexados {indexed} de {total} enlaces.",
+		DidYouMean:           "¿Quisiste decir {command}?",
+		BroadcastUsage:       "Uso: {command} [!sala:servidor ...] <anuncio>",
+		BroadcastUnknownRoom: "{room} no es una sala permitida.",
+		BroadcastDone:        "Anuncio enviado a {sent} de {total} salas.",
+		VersionInfo:          "hister-matrix-bot {version}",
+		ForgetUsage:          "Uso: {command} <url>",
+		Forgotten:            "Se eliminó {url} del índice de búsqueda.",
+		ForgetFailed:         "No se pudo eliminar {url} del índice de búsqueda, inténtalo de nuevo.",
+		Digest:               "Resumen diario del {date}:\n{summary}",
+		DigestAttached:       "Resumen diario del {date}, completo en el adjunto:\n{preview}",
+		SummaryTitle:         "Resumen de la conversación, del {from} al {to}",
+		BackfillStarted:      "Indexando enlaces de hasta {depth} mensajes anteriores de esta sala.",
+		BackfillBusy:         "Hay demasiadas indexaciones en cola, inténtalo más tarde.",
+		BackfillDone:         "Indexación histórica terminada: {indexed} de {total} enlaces indexados.",
+		BackfillWindow:       "Indexando enlaces de los últimos {window} de esta sala.",
+		BackfillUsage:        "Uso: {command} [antigüedad, p. ej. 90d]",
+		WatchAdded:           "Vigilando \"{query}\" como #{id}. Los nuevos resultados se publicarán en este hilo; detenlo con {command} {id}.",
+		WatchList:            "Tus búsquedas vigiladas:",
+		WatchNone:            "No vigilas ninguna búsqueda. Empieza con {command} <consulta>.",
+		WatchLimit:           "Ya tienes {max} búsquedas vigiladas; elimina una con {command} <id> primero.",
+		WatchRemoved:         "Se dejó de vigilar #{id}.",
+		WatchNotFound:        "No tienes la búsqueda vigilada #{id}.",
+		UnwatchUsage:         "Uso: {command} <id>",
+		WatchMatches:         "{user}: nuevos resultados para \"{query}\":",
+		ReminderSet:          "De acuerdo, te lo recordaré el {time}.",
+		ReminderUsage:        "Uso: {command} in <duración> about <texto>, p. ej. {command} in 2h about el despliegue. Los recordatorios pueden programarse con hasta un año de antelación.",
+		ReminderDue:          "{user}: recordatorio: {text}",
+		DeadLinksReport:      "Se encontraron {count} enlaces rotos:",
+		DeadLinkMarker:       "[enlace roto]",
+		TopLinksReport:       "Enlaces destacados de la semana del {date}",
+		TopLinksShared:       "Más compartidos:",
+		TopLinksSearched:     "Más buscados:",
+		KeysDirectOnly:       "{command} solo funciona en un chat directo conmigo.",
+		ExportKeysUsage:      "Uso: {command} <frase de contraseña>",
+		ImportKeysUsage:      "Uso: responde a un archivo de claves exportadas con {command} <frase de contraseña>",
+		KeysNone:             "Todavía no tengo claves de sala para exportar.",
+		KeysFailed:           "Lo siento, no funcionó. Revisa la frase de contraseña e inténtalo de nuevo.",
+		KeysExported:         "Se exportaron {count} claves de sala. Borra tu mensaje con la frase de contraseña.",
+		KeysImported:         "Se importaron {imported} claves de sala nuevas ({total} en el archivo). Borra tu mensaje con la frase de contraseña.",
+		Welcome:              "¡Hola! Hago que los enlaces compartidos en esta sala se puedan buscar.",
+		WelcomeSearch:        "- {command} <términos> los busca.",
+		WelcomeCatchMeUp:     "- {command} resume la conversación reciente.",
+		WelcomeIndexing:      "- Los enlaces de los mensajes se indexan automáticamente.",
+		WelcomeIndexReaction: "Reacciona con {reaction} para indexar los enlaces de un mensaje anterior.",
+		WelcomeIgnore:        "- Empieza un mensaje con {prefix} para dejar sus enlaces fuera del índice.",
+	},
+}
+
+// Catalog resolves responses in the language configured for a room.
+type Catalog struct {
+	defaultLang string
+	rooms       map[string]string
+	bundles     map[string]Bundle
+}
+
+// NewCatalog builds a catalog from the built-in bundles plus overrides.
+// Overrides may add languages or replace individual built-in templates.
+func NewCatalog(defaultLang string, roomLangs map[string]string, overrides map[string]Bundle) (*Catalog, error) {
+	bundles := make(map[string]Bundle, len(builtin)+len(overrides))
+	for lang, bundle := range builtin {
+		bundles[lang] = cloneBundle(bundle)
+	}
+	for lang, bundle := range overrides {
+		lang = normalizeLang(lang)
+		for key, text := range bundle {
+			if _, known := builtin[DefaultLanguage][key]; !known {
+				return nil, fmt.Errorf("language %q: unknown message key %q", lang, key)
+			}
+			if bundles[lang] == nil {
+				bundles[lang] = make(Bundle)
+			}
+			bundles[lang][key] = text
+		}
+	}
+
+	c := &Catalog{
+		defaultLang: normalizeLang(defaultLang),
+		rooms:       make(map[string]string, len(roomLangs)),
+		bundles:     bundles,
+	}
+	if c.defaultLang == "" {
+		c.defaultLang = DefaultLanguage
+	}
+	if _, ok := bundles[c.defaultLang]; !ok {
+		return nil, fmt.Errorf("unknown default language %q", c.defaultLang)
+	}
+	for room, lang := range roomLangs {
+		lang = normalizeLang(lang)
+		if _, ok := bundles[lang]; !ok {
+			return nil, fmt.Errorf("room %s: unknown language %q", room, lang)
+		}
+		c.rooms[room] = lang
+	}
+	return c, nil
+}
+
+// LoadBundles reads <lang>.yaml files from dir, each mapping message keys to
+// templates.
+func LoadBundles(dir string) (map[string]Bundle, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("list translation bundles: %w", err)
+	}
+	sort.Strings(paths)
+
+	out := make(map[string]Bundle, len(paths))
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read translation bundle: %w", err)
+		}
+		var bundle Bundle
+		if err := yaml.Unmarshal(raw, &bundle); err != nil {
+			return nil, fmt.Errorf("parse translation bundle %s: %w", filepath.Base(path), err)
+		}
+		out[strings.TrimSuffix(filepath.Base(path), ".yaml")] = bundle
+	}
+	return out, nil
+}
+
+// Text renders key for roomID, falling back to the default language and then
+// English when a translation is missing. A nil catalog renders English.
+func (c *Catalog) Text(roomID string, key Key, vars Vars) string {
+	template := c.lookup(c.language(roomID), key)
+	if len(vars) == 0 {
+		return template
+	}
+	pairs := make([]string, 0, len(vars)*2)
+	for name, value := range vars {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+func (c *Catalog) language(roomID string) string {
+	if c == nil {
+		return DefaultLanguage
+	}
+	if lang, ok := c.rooms[roomID]; ok {
+		return lang
+	}
+	return c.defaultLang
+}
+
+func (c *Catalog) lookup(lang string, key Key) string {
+	if c != nil {
+		if text, ok := c.bundles[lang][key]; ok {
+			return text
+		}
+		if text, ok := c.bundles[c.defaultLang][key]; ok {
+			return text
+		}
+	}
+	return builtin[DefaultLanguage][key]
+}
+
+func cloneBundle(b Bundle) Bundle {
+	out := make(Bundle, len(b))
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.TrimSpace(lang))
+}
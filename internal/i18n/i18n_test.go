@@ -0,0 +1,67 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuiltinBundlesAreComplete(t *testing.T) {
+	for lang, bundle := range builtin {
+		for key := range builtin[DefaultLanguage] {
+			if bundle[key] == "" {
+				t.Errorf("language %q is missing %q", lang, key)
+			}
+		}
+	}
+}
+
+func TestCatalogText_RoomLanguageAndFallback(t *testing.T) {
+	c, err := NewCatalog("es", map[string]string{"!de:test": "DE", "!xx:test": "xx"}, map[string]Bundle{
+		"xx": {NoResults: "nada {query}"},
+	})
+	if err != nil {
+		t.Fatalf("NewCatalog failed: %v", err)
+	}
+
+	if got := c.Text("!de:test", NoResults, Vars{"query": "go"}); got != "Keine Ergebnisse für: go" {
+		t.Fatalf("unexpected room language text: %q", got)
+	}
+	if got := c.Text("!other:test", InvalidQuery, nil); got != "Consulta de búsqueda no válida." {
+		t.Fatalf("unexpected default language text: %q", got)
+	}
+	if got := c.Text("!xx:test", NoResults, Vars{"query": "go"}); got != "nada go" {
+		t.Fatalf("unexpected override text: %q", got)
+	}
+	if got := c.Text("!xx:test", InvalidQuery, nil); got != "Consulta de búsqueda no válida." {
+		t.Fatalf("expected partial bundle to fall back to default language, got %q", got)
+	}
+
+	var nilCatalog *Catalog
+	if got := nilCatalog.Text("!any:test", InvalidQuery, nil); got != "Invalid search query." {
+		t.Fatalf("unexpected nil catalog text: %q", got)
+	}
+}
+
+func TestNewCatalog_RejectsUnknownLanguageAndKeys(t *testing.T) {
+	if _, err := NewCatalog("en", map[string]string{"!room:test": "klingon"}, nil); err == nil {
+		t.Fatal("expected unknown room language error")
+	}
+	if _, err := NewCatalog("en", nil, map[string]Bundle{"fr": {"bogus": "x"}}); err == nil {
+		t.Fatal("expected unknown key error")
+	}
+}
+
+func TestLoadBundles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fr.yaml"), []byte("invalid_query: \"Requête invalide.\"\n"), 0o600); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	bundles, err := LoadBundles(dir)
+	if err != nil {
+		t.Fatalf("LoadBundles failed: %v", err)
+	}
+	if bundles["fr"][InvalidQuery] != "Requête invalide." {
+		t.Fatalf("unexpected loaded bundles: %#v", bundles)
+	}
+}
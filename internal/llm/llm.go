@@ -9,26 +9,59 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 const SYSTEM_PROMPT = `Extract topics from Matrix chat text.
 
 You will receive plain text where most lines look like:
-<sender>: <message>
+[<number>] <sender>: <message>
 
 Rules:
 - Output only topic bullets, each starting with "- ".
 - Topic bullets must be short noun phrases, not full sentences.
 - Keep each bullet under 12 words.
+- End each bullet with the numbers of the 1 to 3 messages it is based on, like "[2]" or "[2] [5]".
 - Include only topics grounded in the input.
 - Include URLs only if central to a topic.
+- When naming a participant, use their exact <sender> ID.
 - No preamble, headings, code fences, or extra commentary.
 - Return 1 to 6 bullets.
 `
 
+const TOP_LINKS_PROMPT = `Write a short, friendly intro for a chat room's weekly report of its most shared and most searched links.
+
+Rules:
+- One paragraph of 1 to 3 sentences.
+- Mention only themes grounded in the listed links.
+- Do not list the links again or invent details about them.
+- No preamble, headings, code fences, or extra commentary.
+`
+
 // const MODEL = "gemma3:270m"
 const MODEL = "qwen3:0.6b"
 
+// Logger receives llm diagnostics. Loggers with a Debugf method also get
+// per-request timings.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+var logger Logger = log.Default()
+
+// SetLogger replaces the standard logger used for llm diagnostics.
+func SetLogger(l Logger) {
+	if l != nil {
+		logger = l
+	}
+}
+
+func debugf(format string, args ...any) {
+	if l, ok := logger.(interface{ Debugf(string, ...any) }); ok {
+		l.Debugf(format, args...)
+	}
+}
+
 func loadEnvFile(filepath string) error {
 	file, err := os.Open(filepath)
 	if err != nil {
@@ -68,10 +101,32 @@ func ExtractTopicsFromChats(chats string, client openai.Client, ctx context.Cont
 }
 
 func ExtractTopicsFromChatsWithError(chats string, client openai.Client, ctx context.Context) (string, error) {
-	topics := ""
+	started := time.Now()
+	topics, err := complete(ctx, client, SYSTEM_PROMPT, chats)
+	if err != nil {
+		return "", err
+	}
+	debugf("llm topics model=%s input_chars=%d output_chars=%d took=%s", MODEL, len(chats), len(topics), time.Since(started))
+	return topics, nil
+}
+
+// WriteTopLinksIntro writes an intro paragraph for a weekly top-links report
+// from its plain-text link list.
+func WriteTopLinksIntro(ctx context.Context, client openai.Client, links string) (string, error) {
+	started := time.Now()
+	intro, err := complete(ctx, client, TOP_LINKS_PROMPT, links)
+	if err != nil {
+		return "", err
+	}
+	debugf("llm top links intro model=%s input_chars=%d output_chars=%d took=%s", MODEL, len(links), len(intro), time.Since(started))
+	return strings.TrimSpace(intro), nil
+}
+
+func complete(ctx context.Context, client openai.Client, system, input string) (string, error) {
+	out := ""
 	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(SYSTEM_PROMPT),
-		openai.UserMessage(chats),
+		openai.SystemMessage(system),
+		openai.UserMessage(input),
 	}
 
 	stream := client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
@@ -84,21 +139,27 @@ func ExtractTopicsFromChatsWithError(chats string, client openai.Client, ctx con
 	for stream.Next() {
 		chunk := stream.Current()
 		if len(chunk.Choices) > 0 {
-			content := chunk.Choices[0].Delta.Content
-			topics += content
+			out += chunk.Choices[0].Delta.Content
 		}
 	}
 
 	if stream.Err() != nil {
 		return "", fmt.Errorf("llm stream: %w", stream.Err())
 	}
+	return out, nil
+}
 
-	return topics, nil
+// Ping checks that the LLM endpoint answers by listing its models.
+func Ping(ctx context.Context, client openai.Client) error {
+	if _, err := client.Models.List(ctx); err != nil {
+		return fmt.Errorf("list llm models: %w", err)
+	}
+	return nil
 }
 
 func InitLLM() openai.Client {
 	if err := loadEnvFile(".env"); err != nil {
-		log.Printf("Warning: could not load .env file: %v", err)
+		logger.Printf("Warning: could not load .env file: %v", err)
 	}
 
 	apiKey := os.Getenv("OPENAI_API_KEY")
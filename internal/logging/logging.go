@@ -0,0 +1,157 @@
+// Package logging provides leveled loggers per bot subsystem, so one noisy
+// component can be turned up without drowning in the others.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Component names accepted in logging.components.
+const (
+	Bot       = "bot"
+	Matrix    = "matrix"
+	Hister    = "hister"
+	Extractor = "extractor"
+	LLM       = "llm"
+	Storage   = "storage"
+)
+
+// Components lists every configurable component.
+var Components = []string{Bot, Matrix, Hister, Extractor, LLM, Storage}
+
+// Level is a log verbosity. Messages below a logger's level are dropped.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+	LevelOff:   "off",
+}
+
+// ParseLevel parses debug, info, warn, error or off. Empty means info.
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return LevelInfo, nil
+	}
+	for level, name := range levelNames {
+		if name == s {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (want debug, info, warn, error or off)", s)
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// IsComponent reports whether name is a configurable component.
+func IsComponent(name string) bool {
+	for _, c := range Components {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Loggers hands out component loggers sharing one output.
+type Loggers struct {
+	out       *log.Logger
+	level     Level
+	overrides map[string]Level
+}
+
+// New returns loggers writing to out at level, except for components listed
+// in overrides.
+func New(out *log.Logger, level Level, overrides map[string]Level) *Loggers {
+	return &Loggers{out: out, level: level, overrides: overrides}
+}
+
+// For returns the logger for component.
+func (l *Loggers) For(component string) *Logger {
+	level, ok := l.overrides[component]
+	if !ok {
+		level = l.level
+	}
+	return &Logger{out: l.out, component: component, level: level}
+}
+
+// Logger writes messages for one component. Printf logs at info level so it
+// satisfies the Printf-only Logger interfaces used across the bot.
+type Logger struct {
+	out       *log.Logger
+	component string
+	level     Level
+}
+
+// Enabled reports whether messages at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level && level < LevelOff
+}
+
+func (l *Logger) Debugf(format string, args ...any) { l.logf(LevelDebug, format, args...) }
+func (l *Logger) Printf(format string, args ...any) { l.logf(LevelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...any)  { l.logf(LevelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...any) { l.logf(LevelError, format, args...) }
+
+func (l *Logger) logf(level Level, format string, args ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) write(level Level, msg string) {
+	prefix := "[" + l.component + "] "
+	if level != LevelInfo {
+		prefix += strings.ToUpper(level.String()) + " "
+	}
+	_ = l.out.Output(3, prefix+msg)
+}
+
+// Zerolog adapts the logger for libraries that log through zerolog, such as
+// mautrix, keeping their output at this component's level.
+func (l *Logger) Zerolog() zerolog.Logger {
+	if l.level >= LevelOff {
+		return zerolog.Nop()
+	}
+	w := zerolog.ConsoleWriter{
+		Out:          writerFunc(func(p []byte) { l.write(LevelInfo, strings.TrimRight(string(p), "\n")) }),
+		NoColor:      true,
+		PartsExclude: []string{zerolog.TimestampFieldName},
+	}
+	return zerolog.New(w).Level(zerologLevels[l.level])
+}
+
+var zerologLevels = map[Level]zerolog.Level{
+	LevelDebug: zerolog.DebugLevel,
+	LevelInfo:  zerolog.InfoLevel,
+	LevelWarn:  zerolog.WarnLevel,
+	LevelError: zerolog.ErrorLevel,
+}
+
+type writerFunc func(p []byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]Level{"": LevelInfo, "DEBUG": LevelDebug, " warn ": LevelWarn, "off": LevelOff} {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("ParseLevel(verbose) succeeded")
+	}
+}
+
+func TestLoggersApplyComponentOverrides(t *testing.T) {
+	var buf bytes.Buffer
+	logs := New(log.New(&buf, "", 0), LevelInfo, map[string]Level{Matrix: LevelWarn, Hister: LevelDebug})
+
+	logs.For(Matrix).Printf("sync ok")
+	logs.For(Matrix).Warnf("sync slow")
+	logs.For(Hister).Debugf("search query=%q", "go")
+	logs.For(Bot).Debugf("hidden")
+	logs.For(Bot).Printf("started")
+
+	want := "[matrix] WARN sync slow\n[hister] DEBUG search query=\"go\"\n[bot] started\n"
+	if buf.String() != want {
+		t.Fatalf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestZerologFollowsComponentLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logs := New(log.New(&buf, "", 0), LevelInfo, map[string]Level{Storage: LevelOff})
+
+	zl := logs.For(Matrix).Zerolog()
+	zl.Debug().Msg("request")
+	zl.Info().Str("room", "!a").Msg("joined")
+	off := logs.For(Storage).Zerolog()
+	off.Error().Msg("hidden")
+
+	out := buf.String()
+	if strings.Contains(out, "request") || strings.Contains(out, "hidden") {
+		t.Fatalf("output = %q, want debug and off-level lines dropped", out)
+	}
+	if !strings.HasPrefix(out, "[matrix] ") || !strings.Contains(out, "joined") || !strings.Contains(out, "room=!a") {
+		t.Fatalf("output = %q, want prefixed zerolog line", out)
+	}
+}
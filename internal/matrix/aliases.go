@@ -0,0 +1,54 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// aliasStatePrefix keys the room ID an alias last resolved to.
+const aliasStatePrefix = "room_alias:"
+
+// AliasResolver looks up the room an alias points to in the room directory.
+type AliasResolver interface {
+	ResolveAlias(ctx context.Context, alias id.RoomAlias) (*mautrix.RespAliasResolve, error)
+}
+
+// AliasCache remembers resolved aliases, so the bot still starts while the
+// room directory is unreachable.
+type AliasCache interface {
+	PutBotState(ctx context.Context, key, value string) error
+	GetBotState(ctx context.Context, key string) (string, error)
+}
+
+// ResolveRoomAliases resolves each of aliases to its room ID, falling back
+// to the room ID cached from an earlier start when the lookup fails. cache
+// may be nil.
+func ResolveRoomAliases(ctx context.Context, resolver AliasResolver, cache AliasCache, aliases []string) (map[string]id.RoomID, error) {
+	resolved := make(map[string]id.RoomID, len(aliases))
+	for _, alias := range aliases {
+		resp, err := resolver.ResolveAlias(ctx, id.RoomAlias(alias))
+		if err == nil && resp.RoomID != "" {
+			resolved[alias] = resp.RoomID
+			if cache != nil {
+				if err := cache.PutBotState(ctx, aliasStatePrefix+alias, string(resp.RoomID)); err != nil {
+					return nil, fmt.Errorf("cache room alias %s: %w", alias, err)
+				}
+			}
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("directory returned no room")
+		}
+		if cache != nil {
+			if cached, cacheErr := cache.GetBotState(ctx, aliasStatePrefix+alias); cacheErr == nil && cached != "" {
+				resolved[alias] = id.RoomID(cached)
+				continue
+			}
+		}
+		return nil, fmt.Errorf("resolve room alias %s: %w", alias, err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,48 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeResolver map[id.RoomAlias]id.RoomID
+
+func (f fakeResolver) ResolveAlias(_ context.Context, alias id.RoomAlias) (*mautrix.RespAliasResolve, error) {
+	roomID, ok := f[alias]
+	if !ok {
+		return nil, errors.New("directory unavailable")
+	}
+	return &mautrix.RespAliasResolve{RoomID: roomID}, nil
+}
+
+type memoryState map[string]string
+
+func (m memoryState) PutBotState(_ context.Context, key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryState) GetBotState(_ context.Context, key string) (string, error) {
+	return m[key], nil
+}
+
+func TestResolveRoomAliases(t *testing.T) {
+	cache := memoryState{}
+	resolved, err := ResolveRoomAliases(context.Background(), fakeResolver{"#general:test": "!general:test"}, cache, []string{"#general:test"})
+	if err != nil || resolved["#general:test"] != "!general:test" {
+		t.Fatalf("ResolveRoomAliases() = %v, %v", resolved, err)
+	}
+
+	resolved, err = ResolveRoomAliases(context.Background(), fakeResolver{}, cache, []string{"#general:test"})
+	if err != nil || resolved["#general:test"] != "!general:test" {
+		t.Fatalf("expected the cached room ID when the directory fails, got %v, %v", resolved, err)
+	}
+
+	if _, err := ResolveRoomAliases(context.Background(), fakeResolver{}, cache, []string{"#unknown:test"}); err == nil {
+		t.Fatal("expected error for an alias that never resolved")
+	}
+}
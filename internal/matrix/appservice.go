@@ -0,0 +1,176 @@
+package matrix
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+)
+
+// seenTransactions is how many transaction IDs are remembered, so retried
+// transactions are acknowledged without handling their events twice.
+const seenTransactions = 256
+
+// AppserviceConfig configures appservice mode, in which the homeserver pushes
+// events to a transaction endpoint instead of the bot long-polling /sync.
+type AppserviceConfig struct {
+	// ListenAddr is the address the transaction endpoint listens on.
+	ListenAddr string
+	// HSToken is the hs_token the homeserver authenticates with.
+	HSToken string
+}
+
+// appserviceServer receives transactions and dispatches their events to the
+// syncer the sync loop would otherwise feed.
+type appserviceServer struct {
+	cfg     AppserviceConfig
+	syncer  *mautrix.DefaultSyncer
+	client  *Client
+	server  *http.Server
+	serving chan struct{}
+
+	mu   sync.Mutex
+	seen []string
+}
+
+// SetAppservice switches Start from the /sync loop to serving the appservice
+// transaction endpoint. Encrypted rooms are not supported in this mode, since
+// the homeserver does not push to-device messages to it.
+func (c *Client) SetAppservice(cfg AppserviceConfig) {
+	c.appservice = &appserviceServer{cfg: cfg, syncer: c.syncer, client: c, serving: make(chan struct{})}
+}
+
+// serve serves the transaction endpoint until ctx is done or Stop is called.
+func (a *appserviceServer) serve(ctx context.Context) error {
+	a.server = &http.Server{Addr: a.cfg.ListenAddr, Handler: a.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	listener, err := net.Listen("tcp", a.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("listen for appservice transactions: %w", err)
+	}
+	close(a.serving)
+	a.client.logf("appservice listening on %s", listener.Addr())
+
+	go func() {
+		<-ctx.Done()
+		a.stop()
+	}()
+	if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve appservice transactions: %w", err)
+	}
+	return nil
+}
+
+func (a *appserviceServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /_matrix/app/v1/transactions/{txnID}", a.handleTransaction)
+	mux.HandleFunc("POST /_matrix/app/v1/ping", a.handlePing)
+	return mux
+}
+
+func (a *appserviceServer) stop() {
+	select {
+	case <-a.serving:
+	default:
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.client.logf("appservice shutdown failed: %v", err)
+	}
+}
+
+// authorized checks the hs_token, sent as a bearer token or, by older
+// homeservers, as the access_token query parameter.
+func (a *appserviceServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.HSToken)) == 1
+}
+
+func (a *appserviceServer) handlePing(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		writeAppserviceError(w, http.StatusForbidden, "M_FORBIDDEN", "bad hs_token")
+		return
+	}
+	writeAppserviceJSON(w, http.StatusOK, struct{}{})
+}
+
+func (a *appserviceServer) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		writeAppserviceError(w, http.StatusForbidden, "M_FORBIDDEN", "bad hs_token")
+		return
+	}
+	txnID := r.PathValue("txnID")
+	var txn appservice.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+		writeAppserviceError(w, http.StatusBadRequest, "M_NOT_JSON", "invalid transaction body")
+		return
+	}
+	if a.markSeen(txnID) {
+		// Handlers finish even if the homeserver gives up on the request;
+		// its retry is then acknowledged as already seen.
+		ctx := context.WithoutCancel(r.Context())
+		for _, ev := range txn.Events {
+			a.dispatch(ctx, ev)
+		}
+	}
+	writeAppserviceJSON(w, http.StatusOK, struct{}{})
+}
+
+// markSeen records txnID and reports whether it is new.
+func (a *appserviceServer) markSeen(txnID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, seen := range a.seen {
+		if seen == txnID {
+			return false
+		}
+	}
+	if len(a.seen) == seenTransactions {
+		a.seen = a.seen[1:]
+	}
+	a.seen = append(a.seen, txnID)
+	return true
+}
+
+// dispatch hands ev to the syncer's handlers the way a /sync timeline event
+// would reach them. An invite of the bot is marked as coming from the invite
+// section, so it is answered like one.
+func (a *appserviceServer) dispatch(ctx context.Context, ev *event.Event) {
+	if ev == nil {
+		return
+	}
+	if err := prepareEvent(ev, event.SourceJoin|event.SourceTimeline); err != nil {
+		a.client.debugf("unparseable appservice event room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+	}
+	if ev.Type == event.StateMember && ev.GetStateKey() == string(a.client.botUserID) {
+		if member := ev.Content.AsMember(); member != nil && member.Membership == event.MembershipInvite {
+			ev.Mautrix.EventSource = event.SourceInvite | event.SourceState
+		}
+	}
+	a.syncer.Dispatch(ctx, ev)
+}
+
+func writeAppserviceJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeAppserviceError(w http.ResponseWriter, status int, code, message string) {
+	writeAppserviceJSON(w, status, mautrix.RespError{ErrCode: code, Err: message})
+}
@@ -0,0 +1,53 @@
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestAppservice_DispatchesTransactions(t *testing.T) {
+	mx, err := mautrix.NewClient("https://matrix.example.org", "@bot:test", "as-token")
+	if err != nil {
+		t.Fatalf("new mautrix client: %v", err)
+	}
+	handler := &fakeHandler{}
+	c, err := NewClient(mx, AllowedRooms{"!allowed:test": {}}, handler, nil)
+	if err != nil {
+		t.Fatalf("new matrix client: %v", err)
+	}
+	c.SetAppservice(AppserviceConfig{ListenAddr: "127.0.0.1:0", HSToken: "hs-token"})
+	server := httptest.NewServer(c.appservice.handler())
+	defer server.Close()
+
+	txn := `{"events":[{"type":"m.room.message","room_id":"!allowed:test","event_id":"$1","sender":"@alice:test","origin_server_ts":1,"content":{"msgtype":"m.text","body":"hello"}}]}`
+	put := func(txnID, token string) int {
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/_matrix/app/v1/transactions/"+txnID, strings.NewReader(txn))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("put transaction: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := put("t1", "wrong"); status != http.StatusForbidden {
+		t.Fatalf("expected 403 for a bad hs_token, got %d", status)
+	}
+	if status := put("t1", "hs-token"); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if status := put("t1", "hs-token"); status != http.StatusOK {
+		t.Fatalf("expected 200 for a retried transaction, got %d", status)
+	}
+	if len(handler.msgs) != 1 || handler.msgs[0].Body != "hello" || handler.msgs[0].EventID != "$1" {
+		t.Fatalf("expected the message to be handled once, got %#v", handler.msgs)
+	}
+}
@@ -0,0 +1,113 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// catchUpPageSize is how many events one /messages request fetches.
+	catchUpPageSize = 100
+	// catchUpMaxPages bounds the requests catch-up makes per room.
+	catchUpMaxPages = 20
+)
+
+// SetCatchUpMaxAge makes a sync whose timeline of an allowed room is limited,
+// as after the bot was offline for a while, fetch the events the homeserver
+// left out and handle them before the timeline. Events older than maxAge are
+// not fetched. Zero disables catch-up.
+func (c *Client) SetCatchUpMaxAge(maxAge time.Duration) {
+	c.catchUpMaxAge = maxAge
+}
+
+// catchUp handles the events missing from the limited timelines of res. An
+// initial sync has nothing to catch up on, since there is no earlier sync.
+func (c *Client) catchUp(ctx context.Context, res *mautrix.RespSync, since string) {
+	defer c.panics.Recover("matrix catch-up")
+	if c.catchUpMaxAge <= 0 || since == "" || c.syncer == nil || res == nil {
+		return
+	}
+	for roomID, room := range res.Rooms.Join {
+		if room == nil || !room.Timeline.Limited || room.Timeline.PrevBatch == "" {
+			continue
+		}
+		if !c.roomAllowed(ctx, roomID) {
+			continue
+		}
+		missed, err := c.missedEvents(ctx, roomID, room.Timeline.PrevBatch, since)
+		if err != nil {
+			c.logf("catch-up failed room=%s err=%v", roomID, err)
+		}
+		if len(missed) == 0 {
+			continue
+		}
+		c.logf("catching up room=%s events=%d", roomID, len(missed))
+		for _, ev := range missed {
+			if err := prepareEvent(ev, event.SourceJoin|event.SourceTimeline); err != nil {
+				c.debugf("unparseable missed event room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+			}
+			c.syncer.Dispatch(ctx, ev)
+		}
+	}
+}
+
+// missedEvents pages back from the start of a limited timeline to the
+// previous sync, or until events get older than catchUpMaxAge, and returns
+// the non-state events found, oldest first. State events are left out: the
+// sync's state section already brings the room state up to date.
+func (c *Client) missedEvents(ctx context.Context, roomID id.RoomID, from, since string) ([]*event.Event, error) {
+	cutoff := time.Now().Add(-c.catchUpMaxAge).UnixMilli()
+	var missed []*event.Event
+	var err error
+pages:
+	for page := 0; page < catchUpMaxPages && from != ""; page++ {
+		var resp *mautrix.RespMessages
+		resp, err = c.api.Messages(ctx, roomID, from, since, mautrix.DirectionBackward, nil, catchUpPageSize)
+		if err != nil {
+			err = fmt.Errorf("fetch missed events: %w", err)
+			break
+		}
+		for _, ev := range resp.Chunk {
+			if ev == nil {
+				continue
+			}
+			if ev.Timestamp < cutoff {
+				break pages
+			}
+			if ev.StateKey != nil {
+				continue
+			}
+			ev.RoomID = roomID
+			missed = append(missed, ev)
+		}
+		if len(resp.Chunk) == 0 || resp.End == from {
+			break
+		}
+		from = resp.End
+	}
+	slices.Reverse(missed)
+	return missed, err
+}
+
+// prepareEvent makes an event that did not come from /sync look like a sync
+// event of source to the syncer's handlers: its type class is set and its
+// content parsed. A parse error is returned, but the event is still usable;
+// handlers skip content they cannot read.
+func prepareEvent(ev *event.Event, source event.Source) error {
+	ev.Type.Class = event.MessageEventType
+	if ev.StateKey != nil {
+		ev.Type.Class = event.StateEventType
+	}
+	ev.Mautrix.EventSource = source
+	if err := ev.Content.ParseRaw(ev.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func rawMessage(t *testing.T, eventID id.EventID, age time.Duration, body string) *event.Event {
+	t.Helper()
+	content, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &event.Event{
+		Type:      event.EventMessage,
+		ID:        eventID,
+		Sender:    "@alice:test",
+		Timestamp: time.Now().Add(-age).UnixMilli(),
+		Content:   event.Content{VeryRaw: content},
+	}
+}
+
+func TestCatchUp_HandlesEventsMissingFromLimitedTimeline(t *testing.T) {
+	api := &fakeAPI{messagePages: []*mautrix.RespMessages{
+		{Chunk: []*event.Event{rawMessage(t, "$3", time.Minute, "third"), rawMessage(t, "$2", 2*time.Minute, "second")}, End: "page-2"},
+		{Chunk: []*event.Event{rawMessage(t, "$1", 30*time.Minute, "first"), rawMessage(t, "$0", 3*time.Hour, "too old")}, End: "page-3"},
+	}}
+	handler := &fakeHandler{}
+	c := &Client{api: api, handler: handler, roomPolicy: AllowedRooms{"!room:test": {}}, syncer: mautrix.NewDefaultSyncer()}
+	c.syncer.ParseEventContent = true
+	c.syncer.OnEventType(event.EventMessage, c.onMessageEvent)
+	c.SetCatchUpMaxAge(time.Hour)
+
+	res := &mautrix.RespSync{}
+	res.Rooms.Join = map[id.RoomID]*mautrix.SyncJoinedRoom{
+		"!room:test":  {Timeline: mautrix.SyncTimeline{Limited: true, PrevBatch: "prev"}},
+		"!other:test": {Timeline: mautrix.SyncTimeline{Limited: true, PrevBatch: "prev"}},
+	}
+	c.onSync(context.Background(), res, "since")
+
+	if len(api.messagesFrom) != 2 || api.messagesFrom[0] != "prev" || api.messagesFrom[1] != "page-2" {
+		t.Fatalf("expected two pages fetched for the allowed room only, got %v", api.messagesFrom)
+	}
+	var got []string
+	for _, msg := range handler.msgs {
+		got = append(got, msg.Body)
+		if msg.RoomID != "!room:test" {
+			t.Fatalf("unexpected room %s", msg.RoomID)
+		}
+	}
+	if len(got) != 3 || got[0] != "first" || got[1] != "second" || got[2] != "third" {
+		t.Fatalf("expected missed events oldest first within the max age, got %v", got)
+	}
+}
+
+func TestCatchUp_SkipsInitialSyncAndFullTimelines(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, handler: &fakeHandler{}, syncer: mautrix.NewDefaultSyncer()}
+	c.SetCatchUpMaxAge(time.Hour)
+
+	res := &mautrix.RespSync{}
+	res.Rooms.Join = map[id.RoomID]*mautrix.SyncJoinedRoom{
+		"!room:test": {Timeline: mautrix.SyncTimeline{Limited: true, PrevBatch: "prev"}},
+	}
+	c.onSync(context.Background(), res, "")
+	res.Rooms.Join["!room:test"].Timeline.Limited = false
+	c.onSync(context.Background(), res, "since")
+
+	if len(api.messagesFrom) != 0 {
+		t.Fatalf("expected no catch-up requests, got %v", api.messagesFrom)
+	}
+}
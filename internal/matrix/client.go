@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/gotlou/hister-element-bot/bot/internal/recovery"
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/cryptohelper"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -17,6 +20,12 @@ type Logger interface {
 	Printf(format string, args ...any)
 }
 
+// debugLogger is implemented by loggers with a separate debug level. Routine
+// chatter goes there when available and to Printf otherwise.
+type debugLogger interface {
+	Debugf(format string, args ...any)
+}
+
 type RoomPolicy interface {
 	Allowed(roomID id.RoomID) bool
 }
@@ -54,6 +63,20 @@ type Message struct {
 	EventID id.EventID
 	Sender  id.UserID
 	Body    string
+	// FormattedBody is the HTML formatted_body, if the event has one.
+	FormattedBody string
+	// ReplyTo is the event the message replies to, if any.
+	ReplyTo id.EventID
+	// Notice is set for m.notice messages, which are usually sent by bots.
+	Notice bool
+	// ThreadRoot is the root event of the thread the message was sent in,
+	// if any.
+	ThreadRoot id.EventID
+	// Mentions are the users listed in the event's m.mentions. HasMentions
+	// is set when the event has that field at all, so mentions need not be
+	// guessed from the text.
+	Mentions    []id.UserID
+	HasMentions bool
 }
 
 type MessageHandler interface {
@@ -66,17 +89,90 @@ func (f MessageHandlerFunc) HandleMatrixMessage(ctx context.Context, msg Message
 	return f(ctx, msg)
 }
 
+// Reaction is an m.annotation reaction to another event in an allowed room.
+type Reaction struct {
+	RoomID        id.RoomID
+	EventID       id.EventID
+	Sender        id.UserID
+	TargetEventID id.EventID
+	Key           string
+}
+
+// ReactionHandler can optionally be implemented by a MessageHandler to receive reactions.
+type ReactionHandler interface {
+	HandleMatrixReaction(ctx context.Context, reaction Reaction) error
+}
+
+// FileMessage is an m.file upload in an allowed room. URL is the mxc URI of
+// the (possibly encrypted) file.
+type FileMessage struct {
+	RoomID   id.RoomID
+	EventID  id.EventID
+	Sender   id.UserID
+	Name     string
+	MimeType string
+	Size     int
+	URL      id.ContentURIString
+	// Caption is the text sent along with the file, if any.
+	Caption string
+}
+
+// FileHandler can optionally be implemented by a MessageHandler to receive
+// file uploads.
+type FileHandler interface {
+	HandleMatrixFile(ctx context.Context, file FileMessage) error
+}
+
+// Redaction is an m.room.redaction of another event in an allowed room.
+type Redaction struct {
+	RoomID  id.RoomID
+	EventID id.EventID
+	Sender  id.UserID
+	Redacts id.EventID
+}
+
+// RedactionHandler can optionally be implemented by a MessageHandler to learn
+// when events are redacted.
+type RedactionHandler interface {
+	HandleMatrixRedaction(ctx context.Context, redaction Redaction) error
+}
+
+// JoinHandler can optionally be implemented by a MessageHandler to learn when
+// the bot joins an allowed room.
+type JoinHandler interface {
+	HandleMatrixJoin(ctx context.Context, roomID id.RoomID) error
+}
+
+// EventLog remembers which events the bot handled, so events a sync replays
+// after a restart are not handled twice.
+type EventLog interface {
+	// MarkEventProcessed records eventID as handled by userID and reports
+	// whether it was not recorded before.
+	MarkEventProcessed(ctx context.Context, userID id.UserID, roomID id.RoomID, eventID id.EventID) (bool, error)
+}
+
 type Reply struct {
 	RoomID           id.RoomID
 	InReplyToEventID id.EventID
 	Body             string
-	Thread           bool
+	// FormattedBody, when set, is an HTML rendering of Body.
+	FormattedBody string
+	Thread        bool
+	// Kind selects the message type configured by SetMessageTypes.
+	Kind ReplyKind
+	// Replaces, when set, is an earlier message of the bot that this one
+	// edits. The edit keeps the original's place, so InReplyToEventID and
+	// Thread are ignored.
+	Replaces id.EventID
 }
 
 type Config struct {
 	HomeserverURL string
 	UserID        id.UserID
 	AccessToken   string
+	Password      string
+	RefreshToken  string
+	OIDC          OIDCConfig
 	DeviceID      id.DeviceID
 	SyncTimeout   time.Duration
 }
@@ -85,6 +181,9 @@ type Stores struct {
 	SyncStore  mautrix.SyncStore
 	StateStore mautrix.StateStore
 	Crypto     mautrix.CryptoHelper
+	// Credentials keeps the tokens and device ID of a password login and
+	// rotated tokens of a refresh.
+	Credentials CredentialStore
 }
 
 type EventDecrypter interface {
@@ -100,33 +199,93 @@ type matrixAPI interface {
 		extra ...mautrix.ReqSendEvent,
 	) (*mautrix.RespSendEvent, error)
 	StateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, outContent interface{}) error
+	FullStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string) (*event.Event, error)
+	SendStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON any, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error)
 	JoinedMembers(ctx context.Context, roomID id.RoomID) (*mautrix.RespJoinedMembers, error)
 	Messages(ctx context.Context, roomID id.RoomID, from, to string, dir mautrix.Direction, filter *mautrix.FilterPart, limit int) (*mautrix.RespMessages, error)
+	GetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error)
 	SyncWithContext(ctx context.Context) error
 	StopSync()
 }
 
+// membershipAPI accepts and rejects room invites.
+type membershipAPI interface {
+	JoinRoomByID(ctx context.Context, roomID id.RoomID) (*mautrix.RespJoinRoom, error)
+	LeaveRoom(ctx context.Context, roomID id.RoomID, optionalReq ...*mautrix.ReqLeave) (*mautrix.RespLeaveRoom, error)
+	JoinedRooms(ctx context.Context) (*mautrix.RespJoinedRooms, error)
+}
+
+// receiptAPI moves the bot's read receipt and fully-read marker.
+type receiptAPI interface {
+	SetReadMarkers(ctx context.Context, roomID id.RoomID, content interface{}) error
+}
+
 type Client struct {
 	api        matrixAPI
 	crypto     EventDecrypter
 	resetGroup func(ctx context.Context, roomID id.RoomID) error
 	shareGroup func(ctx context.Context, roomID id.RoomID, users []id.UserID) error
+	machine    *crypto.OlmMachine
+	media      mediaAPI
+	direct     directAPI
+	membership membershipAPI
+	receipts   receiptAPI
+	dms        directRooms
 	stateStore mautrix.StateStore
 	roomPolicy RoomPolicy
+	senders    SenderPolicy
 	handler    MessageHandler
+	reactions  ReactionHandler
+	files      FileHandler
+	redactions RedactionHandler
+	joins      JoinHandler
 	logger     Logger
 	botUserID  id.UserID
+	panics     *recovery.Guard
+	// coldStartMaxAge, when positive, drops messages and reactions older
+	// than this from the first sync without a saved sync token.
+	coldStartMaxAge time.Duration
+	// stallTimeout, when positive, restarts the sync loop after this long
+	// without a sync response.
+	stallTimeout time.Duration
+	// closeIdle drops pooled homeserver connections before a restart.
+	closeIdle func()
+	// markRead, when set, marks every message and file read once its
+	// handler succeeded.
+	markRead bool
+	// lastSync is the UnixNano time of the last successful sync response.
+	lastSync atomic.Int64
+	// syncer dispatches events to the handlers registered by NewClient.
+	syncer *mautrix.DefaultSyncer
+	// appservice, when set, replaces the sync loop in Start.
+	appservice *appserviceServer
+	// processed, when set, skips events that were already handled.
+	processed EventLog
+	// workers, when set, runs handlers off the sync loop.
+	workers *handlerPool
+	// catchUpMaxAge, when positive, fetches events a limited sync timeline
+	// left out, back to this age.
+	catchUpMaxAge time.Duration
+	// disallowed, when set, leaves rooms outside roomPolicy.
+	disallowed *disallowedRooms
+	// msgTypes selects the msgtype of sent messages.
+	msgTypes MessageTypes
+	// dmUsers, when set, accepts direct chats from the users it allows.
+	dmUsers *directMessages
 }
 
-func BuildMautrixClient(cfg Config, stores Stores) (*mautrix.Client, error) {
+// BuildMautrixClient creates the client. Without an access token it logs in
+// with cfg.Password, reusing the credentials saved in stores.Credentials. With
+// a refresh token, expired access tokens are refreshed automatically.
+func BuildMautrixClient(ctx context.Context, cfg Config, stores Stores) (*mautrix.Client, error) {
 	if strings.TrimSpace(cfg.HomeserverURL) == "" {
 		return nil, errors.New("homeserver URL is required")
 	}
 	if cfg.UserID == "" {
 		return nil, errors.New("user ID is required")
 	}
-	if strings.TrimSpace(cfg.AccessToken) == "" {
-		return nil, errors.New("access token is required")
+	if strings.TrimSpace(cfg.AccessToken) == "" && cfg.Password == "" && cfg.RefreshToken == "" {
+		return nil, errors.New("access token, password or refresh token is required")
 	}
 
 	mx, err := mautrix.NewClient(cfg.HomeserverURL, cfg.UserID, cfg.AccessToken)
@@ -146,6 +305,9 @@ func BuildMautrixClient(cfg Config, stores Stores) (*mautrix.Client, error) {
 	if stores.Crypto != nil {
 		mx.Crypto = stores.Crypto
 	}
+	if err := authenticate(ctx, mx, cfg, stores.Credentials); err != nil {
+		return nil, err
+	}
 
 	ensureDefaultSyncer(mx)
 	return mx, nil
@@ -165,22 +327,48 @@ func NewClient(
 	}
 
 	c := &Client{
-		api:        mx,
+		api:        newRateLimitedAPI(mx, logger),
 		crypto:     mx.Crypto,
+		media:      mx,
+		direct:     mx,
+		membership: mx,
+		receipts:   mx,
 		stateStore: mx.StateStore,
 		roomPolicy: roomPolicy,
 		handler:    handler,
 		logger:     logger,
 		botUserID:  mx.UserID,
+		panics:     &recovery.Guard{Logger: logger},
+	}
+	if mx.Client != nil {
+		c.closeIdle = mx.Client.CloseIdleConnections
 	}
 	if helper, ok := mx.Crypto.(*cryptohelper.CryptoHelper); ok {
 		c.resetGroup = helper.Machine().CryptoStore.RemoveOutboundGroupSession
 		c.shareGroup = helper.Machine().ShareGroupSession
+		c.machine = helper.Machine()
 	}
 
 	syncer := ensureDefaultSyncer(mx)
+	c.syncer = syncer
+	syncer.OnSync(c.onSync)
 	syncer.OnEvent(mx.StateStoreSyncHandler)
 	syncer.OnEventType(event.EventMessage, c.onMessageEvent)
+	if reactions, ok := handler.(ReactionHandler); ok {
+		c.reactions = reactions
+		syncer.OnEventType(event.EventReaction, c.onReactionEvent)
+	}
+	if files, ok := handler.(FileHandler); ok {
+		c.files = files
+	}
+	if redactions, ok := handler.(RedactionHandler); ok {
+		c.redactions = redactions
+		syncer.OnEventType(event.EventRedaction, c.onRedactionEvent)
+	}
+	if joins, ok := handler.(JoinHandler); ok {
+		c.joins = joins
+	}
+	syncer.OnEventType(event.StateMember, c.onMemberEvent)
 	if !usesCryptoHelperAutoDecrypt(mx.Crypto) {
 		syncer.OnEventType(event.EventEncrypted, c.onEncryptedEvent)
 	}
@@ -188,38 +376,227 @@ func NewClient(
 	return c, nil
 }
 
+// SetPanicReporter reports panics recovered from event handlers, in addition
+// to logging them.
+func (c *Client) SetPanicReporter(reporter recovery.Reporter) {
+	c.panics.Reporter = reporter
+}
+
+// SetColdStartMaxAge makes the first sync after a cold start (one without a
+// saved sync token) skip messages and reactions older than maxAge, so the bot
+// does not answer or index days-old history the homeserver replays. Zero
+// handles every event.
+func (c *Client) SetColdStartMaxAge(maxAge time.Duration) {
+	c.coldStartMaxAge = maxAge
+}
+
+// SetSyncStallTimeout makes Start restart the sync loop when no sync
+// response arrived for timeout, which catches connections a proxy silently
+// stopped answering. Zero disables the watchdog.
+func (c *Client) SetSyncStallTimeout(timeout time.Duration) {
+	c.stallTimeout = timeout
+}
+
+// SetReadReceipts makes the bot send a read receipt and move its fully-read
+// marker to each message and file once the handler has handled it, so room
+// members can see the bot consumed their command.
+func (c *Client) SetReadReceipts(enabled bool) {
+	c.markRead = enabled
+}
+
+// SetSenderPolicy restricts which senders' messages, files and reactions
+// reach the handler. Redactions are still forwarded, so blocked users can
+// remove what they shared before they were blocked.
+func (c *Client) SetSenderPolicy(policy SenderPolicy) {
+	c.senders = policy
+}
+
+// SetEventLog records handled messages, files and reactions in log and skips
+// those already recorded, such as events the homeserver replays after a
+// restart. An event is recorded before its handler runs, so one whose handler
+// was interrupted is not retried.
+func (c *Client) SetEventLog(log EventLog) {
+	c.processed = log
+}
+
+// SetHandlerWorkers runs message, file, reaction and redaction handlers on
+// workers goroutines, so a slow handler does not hold up the sync loop. Events
+// of one room are handled in order by the same worker, which queues up to
+// queueSize of them before the sync loop waits. Start returns once the queued
+// events are handled. Zero workers handles events in the sync loop. Call it
+// before Start.
+func (c *Client) SetHandlerWorkers(workers, queueSize int) {
+	if workers <= 0 {
+		c.workers = nil
+		return
+	}
+	c.workers = newHandlerPool(workers, max(queueSize, 0), c.panics)
+}
+
+// handle runs job on the worker of roomID, or right away without workers.
+func (c *Client) handle(roomID id.RoomID, job func()) {
+	if c.workers == nil {
+		job()
+		return
+	}
+	c.workers.submit(roomID, job)
+}
+
 func (c *Client) Start(ctx context.Context) error {
-	if err := c.api.SyncWithContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
-		return fmt.Errorf("matrix sync failed: %w", err)
+	if c.workers != nil {
+		defer c.workers.close()
 	}
-	return nil
+	c.leaveDisallowedRooms(ctx)
+	if c.appservice != nil {
+		return c.appservice.serve(ctx)
+	}
+	for {
+		syncCtx, cancel := context.WithCancel(ctx)
+		stalled := make(chan struct{})
+		watchdogDone := make(chan struct{})
+		go func() {
+			defer close(watchdogDone)
+			if c.watchSync(syncCtx) {
+				close(stalled)
+				cancel()
+			}
+		}()
+		err := c.api.SyncWithContext(syncCtx)
+		cancel()
+		<-watchdogDone
+
+		select {
+		case <-stalled:
+			if ctx.Err() == nil {
+				if c.closeIdle != nil {
+					c.closeIdle()
+				}
+				continue
+			}
+		default:
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("matrix sync failed: %w", err)
+		}
+		return nil
+	}
+}
+
+// watchSync blocks until ctx is done and reports whether it saw the sync
+// loop stall: no sync response for stallTimeout since the later of the last
+// response and the start of this run.
+func (c *Client) watchSync(ctx context.Context) bool {
+	if c.stallTimeout <= 0 {
+		<-ctx.Done()
+		return false
+	}
+	started := time.Now()
+	interval := c.stallTimeout / 4
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case now := <-ticker.C:
+			since := c.LastSync()
+			if since.Before(started) {
+				since = started
+			}
+			if idle := now.Sub(since); idle >= c.stallTimeout {
+				c.logf("sync stalled for %s without a response; restarting sync", idle.Round(time.Second))
+				return true
+			}
+		}
+	}
+}
+
+// LastSync reports when the last sync response was processed. It is zero
+// until the first sync completes. In appservice mode, where events arrive
+// only when there are any, it is the current time while the transaction
+// endpoint is listening.
+func (c *Client) LastSync() time.Time {
+	if c.appservice != nil {
+		select {
+		case <-c.appservice.serving:
+			return time.Now()
+		default:
+			return time.Time{}
+		}
+	}
+	nanos := c.lastSync.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (c *Client) onSync(ctx context.Context, res *mautrix.RespSync, since string) bool {
+	c.lastSync.Store(time.Now().UnixNano())
+	c.catchUp(ctx, res, since)
+	return true
 }
 
 func (c *Client) Stop() {
+	if c.appservice != nil {
+		c.appservice.stop()
+		return
+	}
 	c.api.StopSync()
 }
 
 func (c *Client) SendReply(ctx context.Context, reply Reply) error {
+	_, err := c.sendMessage(ctx, reply)
+	return err
+}
+
+// SendReplyEvent is SendReply that returns the event ID of the reply, e.g.
+// to edit it later.
+func (c *Client) SendReplyEvent(ctx context.Context, reply Reply) (id.EventID, error) {
+	return c.sendMessage(ctx, reply)
+}
+
+// SendNotice posts body to roomID as a standalone post, such as a digest or
+// report, and returns its event ID, e.g. for pinning. It is sent with the
+// message type of ReplyPost, m.notice unless configured otherwise.
+func (c *Client) SendNotice(ctx context.Context, roomID id.RoomID, body string) (id.EventID, error) {
+	return c.sendMessage(ctx, Reply{RoomID: roomID, Body: body, Kind: ReplyPost})
+}
+
+// SendFormattedNotice is SendNotice with an HTML formatted body. An empty
+// formatted sends a plain notice.
+func (c *Client) SendFormattedNotice(ctx context.Context, roomID id.RoomID, body, formatted string) (id.EventID, error) {
+	return c.sendMessage(ctx, Reply{RoomID: roomID, Body: body, FormattedBody: formatted, Kind: ReplyPost})
+}
+
+func (c *Client) sendMessage(ctx context.Context, reply Reply) (id.EventID, error) {
 	body := strings.TrimSpace(reply.Body)
 	if body == "" {
-		return errors.New("reply body must not be empty")
+		return "", errors.New("reply body must not be empty")
 	}
 	if err := c.ensureRoomEncryptionState(ctx, reply.RoomID); err != nil {
-		return err
+		return "", err
 	}
 	if err := c.ensureRoomMembersForEncryption(ctx, reply.RoomID); err != nil {
-		return err
+		return "", err
 	}
 	if err := c.ensureGroupSessionForEncryption(ctx, reply.RoomID); err != nil {
-		return err
+		return "", err
 	}
 
 	content := &event.MessageEventContent{
-		MsgType: event.MsgNotice,
+		MsgType: c.msgTypes.For(reply.Kind),
 		Body:    body,
 	}
+	if reply.FormattedBody != "" {
+		content.Format = event.FormatHTML
+		content.FormattedBody = reply.FormattedBody
+	}
 
-	if reply.InReplyToEventID != "" {
+	switch {
+	case reply.Replaces != "":
+		content.SetEdit(reply.Replaces)
+	case reply.InReplyToEventID != "":
 		parent := &event.Event{ID: reply.InReplyToEventID, RoomID: reply.RoomID}
 		if reply.Thread {
 			content.SetThread(parent)
@@ -228,13 +605,78 @@ func (c *Client) SendReply(ctx context.Context, reply Reply) error {
 		}
 	}
 
-	_, err := c.api.SendMessageEvent(ctx, reply.RoomID, event.EventMessage, content)
+	resp, err := c.api.SendMessageEvent(ctx, reply.RoomID, event.EventMessage, content)
 	if err != nil {
-		return fmt.Errorf("send matrix reply: %w", err)
+		return "", fmt.Errorf("send matrix reply: %w", err)
+	}
+	return resp.EventID, nil
+}
+
+// PinEvent adds eventID to the room's pinned events. A non-empty unpin is
+// removed at the same time, so a recurring post can replace its predecessor.
+func (c *Client) PinEvent(ctx context.Context, roomID id.RoomID, eventID, unpin id.EventID) error {
+	var pins event.PinnedEventsEventContent
+	err := c.api.StateEvent(ctx, roomID, event.StatePinnedEvents, "", &pins)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return fmt.Errorf("get pinned events: %w", err)
+	}
+
+	pinned := make([]id.EventID, 0, len(pins.Pinned)+1)
+	for _, existing := range pins.Pinned {
+		if existing != unpin && existing != eventID {
+			pinned = append(pinned, existing)
+		}
+	}
+	pinned = append(pinned, eventID)
+
+	content := &event.PinnedEventsEventContent{Pinned: pinned}
+	if _, err := c.api.SendStateEvent(ctx, roomID, event.StatePinnedEvents, "", content); err != nil {
+		return fmt.Errorf("update pinned events: %w", err)
+	}
+	return nil
+}
+
+// SendReaction annotates eventID with key. Reactions are not encrypted, so no
+// group session setup is needed.
+func (c *Client) SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) error {
+	if strings.TrimSpace(key) == "" {
+		return errors.New("reaction key must not be empty")
+	}
+	content := &event.ReactionEventContent{
+		RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: eventID,
+			Key:     key,
+		},
+	}
+	if _, err := c.api.SendMessageEvent(ctx, roomID, event.EventReaction, content); err != nil {
+		return fmt.Errorf("send matrix reaction: %w", err)
 	}
 	return nil
 }
 
+// GetTextMessage fetches a single text message event, decrypting it if needed.
+func (c *Client) GetTextMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID) (RoomMessage, error) {
+	ev, err := c.api.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return RoomMessage{}, fmt.Errorf("fetch event: %w", err)
+	}
+	if ev != nil && ev.RoomID == "" {
+		ev.RoomID = roomID
+	}
+	parsed, ok := c.parseHistoryTextEvent(ctx, ev)
+	if !ok {
+		return RoomMessage{}, fmt.Errorf("event %s is not a text message", eventID)
+	}
+	return RoomMessage{
+		RoomID:    roomID,
+		EventID:   parsed.ID,
+		Sender:    parsed.Sender,
+		Body:      strings.TrimSpace(parsed.Content.AsMessage().Body),
+		Timestamp: time.UnixMilli(parsed.Timestamp),
+	}, nil
+}
+
 func (c *Client) ensureRoomEncryptionState(ctx context.Context, roomID id.RoomID) error {
 	var encryption event.EncryptionEventContent
 	err := c.api.StateEvent(ctx, roomID, event.StateEncryption, "", &encryption)
@@ -268,7 +710,7 @@ func (c *Client) ensureRoomMembersForEncryption(ctx context.Context, roomID id.R
 	if _, err = c.api.JoinedMembers(ctx, roomID); err != nil {
 		return fmt.Errorf("fetch joined members for encryption: %w", err)
 	}
-	c.logf("fetched joined members for encrypted room=%s", roomID)
+	c.debugf("fetched joined members for encrypted room=%s", roomID)
 	return nil
 }
 
@@ -288,27 +730,32 @@ func (c *Client) ensureGroupSessionForEncryption(ctx context.Context, roomID id.
 		return fmt.Errorf("load room members for group session: %w", err)
 	}
 	if len(users) == 0 {
-		c.logf("no joined/invited members in state store for encrypted room=%s; skipping explicit group share", roomID)
+		c.debugf("no joined/invited members in state store for encrypted room=%s; skipping explicit group share", roomID)
 		return nil
 	}
 	if c.resetGroup != nil {
-		c.logf("rotating outbound group session room=%s before explicit share", roomID)
+		c.debugf("rotating outbound group session room=%s before explicit share", roomID)
 		if err := c.resetGroup(ctx, roomID); err != nil {
 			return fmt.Errorf("rotate outbound group session: %w", err)
 		}
 	}
-	c.logf("sharing group session room=%s users=%d", roomID, len(users))
+	c.debugf("sharing group session room=%s users=%d", roomID, len(users))
 	if err := c.shareGroup(ctx, roomID, users); err != nil {
 		return fmt.Errorf("share group session: %w", err)
 	}
 	return nil
 }
 
+// Event callbacks recover panics themselves: a panic escaping to mautrix's
+// syncer would stop the sync loop for good.
+
 func (c *Client) onMessageEvent(ctx context.Context, ev *event.Event) {
+	defer c.panics.Recover("matrix message handler")
 	c.forwardIfMessage(ctx, ev)
 }
 
 func (c *Client) onEncryptedEvent(ctx context.Context, ev *event.Event) {
+	defer c.panics.Recover("matrix encrypted event handler")
 	if ev == nil {
 		return
 	}
@@ -322,17 +769,194 @@ func (c *Client) onEncryptedEvent(ctx context.Context, ev *event.Event) {
 		c.logf("decrypt failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
 		return
 	}
+	if decrypted != nil && decrypted.Type == event.EventReaction {
+		c.forwardIfReaction(ctx, decrypted)
+		return
+	}
 	c.forwardIfMessage(ctx, decrypted)
 }
 
+func (c *Client) onReactionEvent(ctx context.Context, ev *event.Event) {
+	defer c.panics.Recover("matrix reaction handler")
+	c.forwardIfReaction(ctx, ev)
+}
+
+func (c *Client) onRedactionEvent(ctx context.Context, ev *event.Event) {
+	defer c.panics.Recover("matrix redaction handler")
+	c.forwardIfRedaction(ctx, ev)
+}
+
+// forwardIfRedaction passes redactions in allowed rooms to the redaction
+// handler. Unlike messages, redactions replayed on a cold start are still
+// forwarded, since forgetting a redacted event is never wrong.
+func (c *Client) forwardIfRedaction(ctx context.Context, ev *event.Event) {
+	if ev == nil || c.redactions == nil || ev.Type != event.EventRedaction {
+		return
+	}
+	if !c.roomAllowed(ctx, ev.RoomID) {
+		return
+	}
+	redacts := ev.Redacts
+	if ev.Content.Parsed == nil {
+		if err := ev.Content.ParseRaw(ev.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			c.debugf("unparseable redaction room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+		}
+	}
+	if content := ev.Content.AsRedaction(); content != nil && content.Redacts != "" {
+		redacts = content.Redacts
+	}
+	if redacts == "" {
+		return
+	}
+	redaction := Redaction{
+		RoomID:  ev.RoomID,
+		EventID: ev.ID,
+		Sender:  ev.Sender,
+		Redacts: redacts,
+	}
+	c.handle(ev.RoomID, func() {
+		if err := c.redactions.HandleMatrixRedaction(handlerContext(ctx), redaction); err != nil {
+			c.logf("redaction handler failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+		}
+	})
+}
+
+func (c *Client) onMemberEvent(ctx context.Context, ev *event.Event) {
+	defer c.panics.Recover("matrix join handler")
+	if ev == nil || c.botUserID == "" || ev.GetStateKey() != string(c.botUserID) {
+		return
+	}
+	if isInvite(ev) {
+		c.answerInvite(ctx, ev)
+		return
+	}
+	if !isNewJoin(ev) {
+		return
+	}
+	if !c.roomAllowed(ctx, ev.RoomID) {
+		c.leaveDisallowed(ctx, ev.RoomID)
+		return
+	}
+	if c.joins == nil {
+		return
+	}
+	if err := c.joins.HandleMatrixJoin(handlerContext(ctx), ev.RoomID); err != nil {
+		c.logf("join handler failed room=%s err=%v", ev.RoomID, err)
+	}
+}
+
+// answerInvite joins a room the bot was invited to when the room policy
+// allows it and rejects the invite otherwise, so the bot can be added to a
+// new allowed room without joining it by hand.
+func (c *Client) answerInvite(ctx context.Context, ev *event.Event) {
+	if c.membership == nil {
+		return
+	}
+	if !c.roomAllowed(ctx, ev.RoomID) {
+		if c.acceptDirectInvite(ctx, ev) {
+			return
+		}
+		if _, err := c.membership.LeaveRoom(ctx, ev.RoomID, &mautrix.ReqLeave{Reason: leaveReason}); err != nil {
+			c.logf("reject invite failed room=%s inviter=%s err=%v", ev.RoomID, ev.Sender, err)
+			return
+		}
+		c.logf("rejected invite room=%s inviter=%s", ev.RoomID, ev.Sender)
+		return
+	}
+	if _, err := c.membership.JoinRoomByID(ctx, ev.RoomID); err != nil {
+		c.logf("accept invite failed room=%s inviter=%s err=%v", ev.RoomID, ev.Sender, err)
+		return
+	}
+	c.logf("accepted invite room=%s inviter=%s", ev.RoomID, ev.Sender)
+}
+
+// isInvite reports whether a member event is a pending invite, delivered in
+// the invite section of a sync rather than the timeline of a joined room.
+func isInvite(ev *event.Event) bool {
+	if ev.Mautrix.EventSource&event.SourceInvite == 0 {
+		return false
+	}
+	if ev.Content.Parsed == nil {
+		if err := ev.Content.ParseRaw(ev.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return false
+		}
+	}
+	member := ev.Content.AsMember()
+	return member != nil && member.Membership == event.MembershipInvite
+}
+
+// isNewJoin reports whether a member event is a join, not a profile change
+// of an existing member.
+func isNewJoin(ev *event.Event) bool {
+	if ev.Content.Parsed == nil {
+		if err := ev.Content.ParseRaw(ev.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return false
+		}
+	}
+	member := ev.Content.AsMember()
+	if member == nil || member.Membership != event.MembershipJoin {
+		return false
+	}
+	if ev.Unsigned.PrevContent == nil {
+		return true
+	}
+	if err := ev.Unsigned.PrevContent.ParseRaw(ev.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return true
+	}
+	prev := ev.Unsigned.PrevContent.AsMember()
+	return prev == nil || prev.Membership != event.MembershipJoin
+}
+
+func (c *Client) forwardIfReaction(ctx context.Context, ev *event.Event) {
+	if ev == nil || c.reactions == nil || c.replayed(ctx, ev) {
+		return
+	}
+	if c.botUserID != "" && ev.Sender == c.botUserID {
+		return
+	}
+	if !c.roomAllowed(ctx, ev.RoomID) {
+		return
+	}
+	if c.senders != nil && !c.senders.Allowed(ev.Sender) {
+		return
+	}
+	if ev.Type != event.EventReaction {
+		return
+	}
+
+	content := ev.Content.AsReaction()
+	if content == nil || content.RelatesTo.Type != event.RelAnnotation || content.RelatesTo.EventID == "" {
+		return
+	}
+	if c.processedBefore(ctx, ev) {
+		return
+	}
+
+	reaction := Reaction{
+		RoomID:        ev.RoomID,
+		EventID:       ev.ID,
+		Sender:        ev.Sender,
+		TargetEventID: content.RelatesTo.EventID,
+		Key:           content.RelatesTo.Key,
+	}
+	c.handle(ev.RoomID, func() {
+		if err := c.reactions.HandleMatrixReaction(handlerContext(ctx), reaction); err != nil {
+			c.logf("reaction handler failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+		}
+	})
+}
+
 func (c *Client) forwardIfMessage(ctx context.Context, ev *event.Event) {
-	if ev == nil || c.handler == nil {
+	if ev == nil || c.handler == nil || c.replayed(ctx, ev) {
 		return
 	}
 	if c.botUserID != "" && ev.Sender == c.botUserID {
 		return
 	}
-	if c.roomPolicy != nil && !c.roomPolicy.Allowed(ev.RoomID) {
+	if !c.roomAllowed(ctx, ev.RoomID) {
+		return
+	}
+	if c.senders != nil && !c.senders.Allowed(ev.Sender) {
 		return
 	}
 	if ev.Type != event.EventMessage {
@@ -340,19 +964,130 @@ func (c *Client) forwardIfMessage(ctx context.Context, ev *event.Event) {
 	}
 
 	content := ev.Content.AsMessage()
+	if content != nil && isFileType(content.MsgType) {
+		c.forwardFile(ctx, ev, content)
+		return
+	}
 	if content == nil || !content.MsgType.IsText() {
 		return
 	}
 
 	body := strings.TrimSpace(content.Body)
-	if body == "" {
+	if body == "" || c.processedBefore(ctx, ev) {
+		return
+	}
+
+	msg := Message{RoomID: ev.RoomID, EventID: ev.ID, Sender: ev.Sender, Body: body, ReplyTo: content.GetReplyTo(), Notice: content.MsgType == event.MsgNotice}
+	if content.RelatesTo != nil {
+		msg.ThreadRoot = content.RelatesTo.GetThreadParent()
+	}
+	if content.Format == event.FormatHTML {
+		msg.FormattedBody = content.FormattedBody
+	}
+	if content.Mentions != nil {
+		msg.Mentions, msg.HasMentions = content.Mentions.UserIDs, true
+	}
+	c.handle(ev.RoomID, func() {
+		ctx := handlerContext(ctx)
+		if err := c.handler.HandleMatrixMessage(ctx, msg); err != nil {
+			c.logf("message handler failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+			return
+		}
+		c.markHandled(ctx, ev)
+	})
+}
+
+// isFileType reports whether messages of msgType carry an uploaded file that
+// is forwarded to the file handler.
+func isFileType(msgType event.MessageType) bool {
+	switch msgType {
+	case event.MsgFile, event.MsgImage, event.MsgVideo:
+		return true
+	}
+	return false
+}
+
+// markHandled marks ev read when read receipts are enabled. Failures are
+// only logged.
+func (c *Client) markHandled(ctx context.Context, ev *event.Event) {
+	if !c.markRead || c.receipts == nil {
 		return
 	}
+	err := c.receipts.SetReadMarkers(ctx, ev.RoomID, &mautrix.ReqSetReadMarkers{Read: ev.ID, FullyRead: ev.ID})
+	if err != nil {
+		c.logf("read receipt failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+	}
+}
+
+func (c *Client) forwardFile(ctx context.Context, ev *event.Event, content *event.MessageEventContent) {
+	if c.files == nil || c.processedBefore(ctx, ev) {
+		return
+	}
+	file := FileMessage{
+		RoomID:  ev.RoomID,
+		EventID: ev.ID,
+		Sender:  ev.Sender,
+		Name:    content.GetFileName(),
+		URL:     content.URL,
+		Caption: strings.TrimSpace(content.GetCaption()),
+	}
+	if content.File != nil {
+		file.URL = content.File.URL
+	}
+	if content.Info != nil {
+		file.MimeType, file.Size = content.Info.MimeType, content.Info.Size
+	}
+	c.handle(ev.RoomID, func() {
+		ctx := handlerContext(ctx)
+		if err := c.files.HandleMatrixFile(ctx, file); err != nil {
+			c.logf("file handler failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+			return
+		}
+		c.markHandled(ctx, ev)
+	})
+}
+
+// replayed reports whether ev arrived in the first sync after a cold start
+// and is older than coldStartMaxAge. mautrix passes the since token of the
+// sync being processed in ctx; it is empty only for an initial sync.
+func (c *Client) replayed(ctx context.Context, ev *event.Event) bool {
+	if c.coldStartMaxAge <= 0 {
+		return false
+	}
+	since, ok := ctx.Value(mautrix.SyncTokenContextKey).(string)
+	if !ok || since != "" {
+		return false
+	}
+	if age := time.Since(time.UnixMilli(ev.Timestamp)); age > c.coldStartMaxAge {
+		c.debugf("skipping replayed event room=%s event=%s age=%s", ev.RoomID, ev.ID, age.Round(time.Second))
+		return true
+	}
+	return false
+}
 
-	err := c.handler.HandleMatrixMessage(ctx, Message{RoomID: ev.RoomID, EventID: ev.ID, Sender: ev.Sender, Body: body})
+// processedBefore records ev in the event log and reports whether it was
+// already there. When the log fails, the event is handled anyway.
+func (c *Client) processedBefore(ctx context.Context, ev *event.Event) bool {
+	if c.processed == nil {
+		return false
+	}
+	added, err := c.processed.MarkEventProcessed(ctx, c.botUserID, ev.RoomID, ev.ID)
 	if err != nil {
-		c.logf("message handler failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+		c.logf("event log failed room=%s event=%s err=%v", ev.RoomID, ev.ID, err)
+		return false
 	}
+	if !added {
+		c.debugf("skipping processed event room=%s event=%s", ev.RoomID, ev.ID)
+	}
+	return !added
+}
+
+// handlerContext detaches handlers from sync cancellation so a shutdown lets
+// in-flight messages finish, replies included, instead of abandoning them.
+// The sync token is saved before events are processed, so an aborted batch
+// would not be redelivered.
+func handlerContext(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
 }
 
 func ensureDefaultSyncer(mx *mautrix.Client) *mautrix.DefaultSyncer {
@@ -380,3 +1115,11 @@ func (c *Client) logf(format string, args ...any) {
 		c.logger.Printf(format, args...)
 	}
 }
+
+func (c *Client) debugf(format string, args ...any) {
+	if l, ok := c.logger.(debugLogger); ok {
+		l.Debugf(format, args...)
+		return
+	}
+	c.logf(format, args...)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,15 +26,29 @@ type fakeAPI struct {
 	stateOut     any
 	stateCalls   int
 	stateErr     error
+	stateSent    any
+	pinned       []id.EventID
 	joinedCalls  int
 	joinedErr    error
+	joined       []id.UserID
 	messagesResp *mautrix.RespMessages
 	messagePages []*mautrix.RespMessages
 	messagesErr  error
 	messagesFrom []string
 	messagesLim  []int
+	messagesFilt []*mautrix.FilterPart
+	event        *event.Event
+	eventErr     error
+	visibility   event.HistoryVisibility
+	member       *event.Event
+	memberErr    error
+	joinedRooms  []id.RoomID
+	leftRooms    []id.RoomID
+	readMarkers  []*mautrix.ReqSetReadMarkers
 	syncErr      error
+	syncFunc     func(ctx context.Context) error
 	stopped      bool
+	roomsIn      []id.RoomID
 }
 
 func (f *fakeAPI) SendMessageEvent(
@@ -47,26 +64,56 @@ func (f *fakeAPI) SendMessageEvent(
 	return &mautrix.RespSendEvent{EventID: "$reply"}, nil
 }
 
-func (f *fakeAPI) SyncWithContext(context.Context) error { return f.syncErr }
-func (f *fakeAPI) StopSync()                             { f.stopped = true }
+func (f *fakeAPI) SyncWithContext(ctx context.Context) error {
+	if f.syncFunc != nil {
+		return f.syncFunc(ctx)
+	}
+	return f.syncErr
+}
+func (f *fakeAPI) StopSync() { f.stopped = true }
 func (f *fakeAPI) StateEvent(_ context.Context, roomID id.RoomID, eventType event.Type, stateKey string, outContent interface{}) error {
 	f.stateRoomID = roomID
 	f.stateType = eventType
 	f.stateKey = stateKey
 	f.stateOut = outContent
 	f.stateCalls++
+	if pins, ok := outContent.(*event.PinnedEventsEventContent); ok {
+		pins.Pinned = f.pinned
+	}
+	if visibility, ok := outContent.(*event.HistoryVisibilityEventContent); ok {
+		if f.visibility == "" {
+			return mautrix.MNotFound
+		}
+		visibility.HistoryVisibility = f.visibility
+	}
 	return f.stateErr
 }
+func (f *fakeAPI) FullStateEvent(_ context.Context, _ id.RoomID, _ event.Type, _ string) (*event.Event, error) {
+	if f.member == nil && f.memberErr == nil {
+		return nil, mautrix.MNotFound
+	}
+	return f.member, f.memberErr
+}
+func (f *fakeAPI) SendStateEvent(_ context.Context, _ id.RoomID, eventType event.Type, _ string, contentJSON any, _ ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error) {
+	f.stateType = eventType
+	f.stateSent = contentJSON
+	return &mautrix.RespSendEvent{EventID: "$state"}, nil
+}
 func (f *fakeAPI) JoinedMembers(_ context.Context, _ id.RoomID) (*mautrix.RespJoinedMembers, error) {
 	f.joinedCalls++
 	if f.joinedErr != nil {
 		return nil, f.joinedErr
 	}
-	return &mautrix.RespJoinedMembers{}, nil
+	resp := &mautrix.RespJoinedMembers{Joined: make(map[id.UserID]mautrix.JoinedMember)}
+	for _, userID := range f.joined {
+		resp.Joined[userID] = mautrix.JoinedMember{}
+	}
+	return resp, nil
 }
-func (f *fakeAPI) Messages(_ context.Context, _ id.RoomID, from, _ string, _ mautrix.Direction, _ *mautrix.FilterPart, limit int) (*mautrix.RespMessages, error) {
+func (f *fakeAPI) Messages(_ context.Context, _ id.RoomID, from, _ string, _ mautrix.Direction, filter *mautrix.FilterPart, limit int) (*mautrix.RespMessages, error) {
 	f.messagesFrom = append(f.messagesFrom, from)
 	f.messagesLim = append(f.messagesLim, limit)
+	f.messagesFilt = append(f.messagesFilt, filter)
 	if f.messagesErr != nil {
 		return nil, f.messagesErr
 	}
@@ -81,16 +128,81 @@ func (f *fakeAPI) Messages(_ context.Context, _ id.RoomID, from, _ string, _ mau
 	return f.messagesResp, nil
 }
 
+func (f *fakeAPI) JoinRoomByID(_ context.Context, roomID id.RoomID) (*mautrix.RespJoinRoom, error) {
+	f.joinedRooms = append(f.joinedRooms, roomID)
+	return &mautrix.RespJoinRoom{RoomID: roomID}, nil
+}
+
+func (f *fakeAPI) LeaveRoom(_ context.Context, roomID id.RoomID, _ ...*mautrix.ReqLeave) (*mautrix.RespLeaveRoom, error) {
+	f.leftRooms = append(f.leftRooms, roomID)
+	return &mautrix.RespLeaveRoom{}, nil
+}
+
+func (f *fakeAPI) JoinedRooms(context.Context) (*mautrix.RespJoinedRooms, error) {
+	return &mautrix.RespJoinedRooms{JoinedRooms: f.roomsIn}, nil
+}
+
+func (f *fakeAPI) SetReadMarkers(_ context.Context, _ id.RoomID, content interface{}) error {
+	f.readMarkers = append(f.readMarkers, content.(*mautrix.ReqSetReadMarkers))
+	return nil
+}
+
+func (f *fakeAPI) GetEvent(_ context.Context, _ id.RoomID, _ id.EventID) (*event.Event, error) {
+	return f.event, f.eventErr
+}
+
 type fakeHandler struct {
-	msgs []Message
-	err  error
+	msgs    []Message
+	ctxErrs []error
+	err     error
 }
 
-func (f *fakeHandler) HandleMatrixMessage(_ context.Context, msg Message) error {
+func (f *fakeHandler) HandleMatrixMessage(ctx context.Context, msg Message) error {
 	f.msgs = append(f.msgs, msg)
+	f.ctxErrs = append(f.ctxErrs, ctx.Err())
 	return f.err
 }
 
+type fakeReactionHandler struct {
+	fakeHandler
+	reactions []Reaction
+}
+
+func (f *fakeReactionHandler) HandleMatrixReaction(_ context.Context, reaction Reaction) error {
+	f.reactions = append(f.reactions, reaction)
+	return nil
+}
+
+type fakeFileHandler struct {
+	fakeHandler
+	files []FileMessage
+}
+
+func (f *fakeFileHandler) HandleMatrixFile(_ context.Context, file FileMessage) error {
+	f.files = append(f.files, file)
+	return nil
+}
+
+type fakeJoinHandler struct {
+	fakeHandler
+	joins []id.RoomID
+}
+
+func (f *fakeJoinHandler) HandleMatrixJoin(_ context.Context, roomID id.RoomID) error {
+	f.joins = append(f.joins, roomID)
+	return nil
+}
+
+type fakeRedactionHandler struct {
+	fakeHandler
+	redactions []Redaction
+}
+
+func (f *fakeRedactionHandler) HandleMatrixRedaction(_ context.Context, redaction Redaction) error {
+	f.redactions = append(f.redactions, redaction)
+	return nil
+}
+
 type fakeCrypto struct {
 	decrypted *event.Event
 	err       error
@@ -163,6 +275,121 @@ func TestSendReply_Threaded(t *testing.T) {
 	}
 }
 
+type fakeDirect struct {
+	directs event.DirectChatsEventContent
+	created []*mautrix.ReqCreateRoom
+}
+
+func (f *fakeDirect) GetAccountData(_ context.Context, _ string, output interface{}) error {
+	if f.directs == nil {
+		return mautrix.MNotFound
+	}
+	out := output.(*event.DirectChatsEventContent)
+	for user, rooms := range f.directs {
+		(*out)[user] = rooms
+	}
+	return nil
+}
+
+func (f *fakeDirect) SetAccountData(_ context.Context, _ string, data interface{}) error {
+	f.directs = data.(event.DirectChatsEventContent)
+	return nil
+}
+
+func (f *fakeDirect) CreateRoom(_ context.Context, req *mautrix.ReqCreateRoom) (*mautrix.RespCreateRoom, error) {
+	f.created = append(f.created, req)
+	return &mautrix.RespCreateRoom{RoomID: "!dm:test"}, nil
+}
+
+func TestSendDirectNotice_CreatesAndReusesDirectChat(t *testing.T) {
+	api := &fakeAPI{}
+	direct := &fakeDirect{}
+	c := &Client{api: api, direct: direct, handler: &fakeHandler{}}
+
+	for range 2 {
+		if err := c.SendDirectNotice(context.Background(), "@alice:test", "hello"); err != nil {
+			t.Fatalf("SendDirectNotice failed: %v", err)
+		}
+	}
+	if len(direct.created) != 1 || !direct.created[0].IsDirect || direct.created[0].Invite[0] != "@alice:test" {
+		t.Fatalf("expected one direct chat to be created, got %#v", direct.created)
+	}
+	if rooms := direct.directs["@alice:test"]; len(rooms) != 1 || rooms[0] != "!dm:test" {
+		t.Fatalf("expected direct chat recorded in m.direct, got %#v", direct.directs)
+	}
+	if api.sentRoomID != "!dm:test" {
+		t.Fatalf("expected notice in direct chat, got room %s", api.sentRoomID)
+	}
+
+	known := &Client{api: api, direct: &fakeDirect{directs: event.DirectChatsEventContent{"@bob:test": {"!old:test", "!new:test"}}}, handler: &fakeHandler{}}
+	if err := known.SendDirectNotice(context.Background(), "@bob:test", "hi"); err != nil {
+		t.Fatalf("SendDirectNotice failed: %v", err)
+	}
+	if api.sentRoomID != "!new:test" {
+		t.Fatalf("expected newest existing direct chat, got room %s", api.sentRoomID)
+	}
+}
+
+func TestSendReply_FormattedBody(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, handler: &fakeHandler{}}
+
+	err := c.SendReply(context.Background(), Reply{RoomID: "!room:test", Body: "[Spoiler]", FormattedBody: "<span data-mx-spoiler>hi</span>"})
+	if err != nil {
+		t.Fatalf("SendReply failed: %v", err)
+	}
+	content := api.sentContent.(*event.MessageEventContent)
+	if content.Format != event.FormatHTML || content.FormattedBody != "<span data-mx-spoiler>hi</span>" || content.Body != "[Spoiler]" {
+		t.Fatalf("unexpected content: %#v", content)
+	}
+}
+
+func TestSendReply_MessageTypes(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, handler: &fakeHandler{}}
+	reply := func(kind ReplyKind) event.MessageType {
+		t.Helper()
+		if err := c.SendReply(context.Background(), Reply{RoomID: "!room:test", Body: "hi", Kind: kind}); err != nil {
+			t.Fatalf("SendReply failed: %v", err)
+		}
+		return api.sentContent.(*event.MessageEventContent).MsgType
+	}
+
+	if got := reply(ReplyResults); got != event.MsgNotice {
+		t.Fatalf("expected notices by default, got %s", got)
+	}
+	c.SetMessageTypes(MessageTypes{Default: event.MsgText, Kinds: map[ReplyKind]event.MessageType{ReplyPost: event.MsgNotice}})
+	if got := reply(""); got != event.MsgText {
+		t.Fatalf("expected the default type for plain replies, got %s", got)
+	}
+	if got := reply(ReplyResults); got != event.MsgText {
+		t.Fatalf("expected the default type for results without an override, got %s", got)
+	}
+	if _, err := c.SendNotice(context.Background(), "!room:test", "report"); err != nil {
+		t.Fatalf("SendNotice failed: %v", err)
+	}
+	if got := api.sentContent.(*event.MessageEventContent).MsgType; got != event.MsgNotice {
+		t.Fatalf("expected the posts override for standalone notices, got %s", got)
+	}
+}
+
+func TestSendReply_EditReplacesEarlierMessage(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, handler: &fakeHandler{}}
+
+	err := c.SendReply(context.Background(), Reply{RoomID: "!room:test", Body: "page 2", InReplyToEventID: "$evt", Thread: true, Replaces: "$results"})
+	if err != nil {
+		t.Fatalf("SendReply failed: %v", err)
+	}
+	content := api.sentContent.(*event.MessageEventContent)
+	if content.RelatesTo == nil || content.RelatesTo.Type != event.RelReplace || content.RelatesTo.EventID != "$results" {
+		t.Fatalf("expected an m.replace relation, got %#v", content.RelatesTo)
+	}
+	if content.NewContent == nil || content.NewContent.Body != "page 2" || content.Body != "* page 2" {
+		t.Fatalf("unexpected edit content: %#v", content)
+	}
+}
+
 func TestSendReply_EmptyBody(t *testing.T) {
 	c := &Client{api: &fakeAPI{}, handler: &fakeHandler{}}
 	if err := c.SendReply(context.Background(), Reply{RoomID: "!room:test", Body: "   "}); err == nil {
@@ -303,6 +530,29 @@ func TestSendReply_EncryptedRoomRotatesSessionBeforeShare(t *testing.T) {
 	}
 }
 
+func TestStart_RestartsStalledSync(t *testing.T) {
+	runs := 0
+	api := &fakeAPI{}
+	api.syncFunc = func(ctx context.Context) error {
+		runs++
+		if runs == 1 {
+			// A wedged connection: no response and no error.
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	c := &Client{api: api}
+	c.SetSyncStallTimeout(20 * time.Millisecond)
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if runs != 2 {
+		t.Fatalf("sync ran %d times, want a restart after the stall", runs)
+	}
+}
+
 func TestForwardIfMessage_FiltersAndForwards(t *testing.T) {
 	handler := &fakeHandler{}
 	c := &Client{api: &fakeAPI{}, handler: handler, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
@@ -310,14 +560,191 @@ func TestForwardIfMessage_FiltersAndForwards(t *testing.T) {
 	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!blocked:test", ID: "$1", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}})
 	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$2", Sender: "@bot:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}})
 	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$3", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "  hello world  "}}})
+	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$4", Sender: "@rss:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgNotice, Body: "new post"}}})
 
-	if len(handler.msgs) != 1 {
-		t.Fatalf("expected one forwarded message, got %d", len(handler.msgs))
+	if len(handler.msgs) != 2 {
+		t.Fatalf("expected two forwarded messages, got %d", len(handler.msgs))
 	}
 	got := handler.msgs[0]
-	if got.RoomID != "!allowed:test" || got.EventID != "$3" || got.Sender != "@alice:test" || got.Body != "hello world" {
+	if got.RoomID != "!allowed:test" || got.EventID != "$3" || got.Sender != "@alice:test" || got.Body != "hello world" || got.Notice {
 		t.Fatalf("unexpected forwarded message: %#v", got)
 	}
+	if !handler.msgs[1].Notice {
+		t.Fatalf("expected notice to be marked, got %#v", handler.msgs[1])
+	}
+}
+
+func TestForwardIfMessage_IncludesMentions(t *testing.T) {
+	handler := &fakeHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler}
+
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "bot: golang", Mentions: &event.Mentions{UserIDs: []id.UserID{"@bot:test"}}}
+	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$1", Sender: "@alice:test", Content: event.Content{Parsed: content}})
+	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$2", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}})
+
+	if len(handler.msgs) != 2 {
+		t.Fatalf("expected two forwarded messages, got %d", len(handler.msgs))
+	}
+	if got := handler.msgs[0]; !got.HasMentions || len(got.Mentions) != 1 || got.Mentions[0] != "@bot:test" {
+		t.Fatalf("unexpected mentions: %#v", got)
+	}
+	if handler.msgs[1].HasMentions {
+		t.Fatalf("expected no m.mentions, got %#v", handler.msgs[1])
+	}
+}
+
+func TestForwardIfMessage_MarksHandledMessagesRead(t *testing.T) {
+	api := &fakeAPI{}
+	handler := &fakeHandler{}
+	c := &Client{api: api, receipts: api, handler: handler, botUserID: "@bot:test"}
+	text := func(id id.EventID) *event.Event {
+		return &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: id, Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "/search go"}}}
+	}
+
+	c.forwardIfMessage(context.Background(), text("$1"))
+	if len(api.readMarkers) != 0 {
+		t.Fatalf("expected no read markers while disabled, got %#v", api.readMarkers)
+	}
+
+	c.SetReadReceipts(true)
+	c.forwardIfMessage(context.Background(), text("$2"))
+	handler.err = errors.New("boom")
+	c.forwardIfMessage(context.Background(), text("$3"))
+	if len(api.readMarkers) != 1 || api.readMarkers[0].Read != "$2" || api.readMarkers[0].FullyRead != "$2" {
+		t.Fatalf("expected only the handled message marked read, got %#v", api.readMarkers)
+	}
+}
+
+func TestForwardIfMessage_IncludesHTMLFormattedBody(t *testing.T) {
+	handler := &fakeHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler}
+
+	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$1", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          "bot: golang",
+		Format:        event.FormatHTML,
+		FormattedBody: `<a href="https://matrix.to/#/@bot:test">bot</a>: golang`,
+	}}})
+
+	if len(handler.msgs) != 1 || handler.msgs[0].FormattedBody == "" {
+		t.Fatalf("expected formatted body to be forwarded, got %#v", handler.msgs)
+	}
+}
+
+func TestForwardIfMessage_SkipsOldEventsOnColdStart(t *testing.T) {
+	handler := &fakeHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler}
+	c.SetColdStartMaxAge(10 * time.Minute)
+	old := &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$old", Sender: "@alice:test", Timestamp: time.Now().Add(-2 * time.Hour).UnixMilli(), Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "old"}}}
+	recent := &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$new", Sender: "@alice:test", Timestamp: time.Now().Add(-time.Minute).UnixMilli(), Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "new"}}}
+
+	initial := context.WithValue(context.Background(), mautrix.SyncTokenContextKey, "")
+	c.forwardIfMessage(initial, old)
+	c.forwardIfMessage(initial, recent)
+	if len(handler.msgs) != 1 || handler.msgs[0].EventID != "$new" {
+		t.Fatalf("expected only the recent message from the initial sync, got %#v", handler.msgs)
+	}
+
+	c.forwardIfMessage(context.WithValue(context.Background(), mautrix.SyncTokenContextKey, "s123"), old)
+	if len(handler.msgs) != 2 {
+		t.Fatalf("expected old messages to be handled after the initial sync, got %#v", handler.msgs)
+	}
+}
+
+type memoryEventLog struct {
+	seen map[string]bool
+	err  error
+}
+
+func (m *memoryEventLog) MarkEventProcessed(_ context.Context, userID id.UserID, roomID id.RoomID, eventID id.EventID) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	key := string(userID) + "|" + string(roomID) + "|" + string(eventID)
+	if m.seen[key] {
+		return false, nil
+	}
+	m.seen[key] = true
+	return true, nil
+}
+
+func TestForwardIfMessage_SkipsProcessedEvents(t *testing.T) {
+	handler := &fakeHandler{}
+	reactions := &fakeReactionHandler{}
+	log := &memoryEventLog{seen: map[string]bool{}}
+	c := &Client{api: &fakeAPI{}, handler: handler, reactions: reactions, botUserID: "@bot:test"}
+	c.SetEventLog(log)
+
+	msg := &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$1", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}}
+	reaction := &event.Event{Type: event.EventReaction, RoomID: "!room:test", ID: "$2", Sender: "@alice:test", Content: event.Content{Parsed: &event.ReactionEventContent{
+		RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: "$1", Key: "🔖"},
+	}}}
+	for range 2 {
+		c.forwardIfMessage(context.Background(), msg)
+		c.forwardIfReaction(context.Background(), reaction)
+	}
+	if len(handler.msgs) != 1 || len(reactions.reactions) != 1 {
+		t.Fatalf("expected replayed events to be skipped, got messages=%d reactions=%d", len(handler.msgs), len(reactions.reactions))
+	}
+
+	log.err = errors.New("db locked")
+	c.forwardIfMessage(context.Background(), msg)
+	if len(handler.msgs) != 2 {
+		t.Fatalf("expected the message to be handled when the event log fails, got %d", len(handler.msgs))
+	}
+}
+
+func TestForwardIfMessage_HandlerOutlivesSyncCancellation(t *testing.T) {
+	handler := &fakeHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.forwardIfMessage(ctx, &event.Event{Type: event.EventMessage, RoomID: "!room:test", ID: "$1", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}})
+
+	if len(handler.ctxErrs) != 1 || handler.ctxErrs[0] != nil {
+		t.Fatalf("expected handler context to survive sync cancellation, got %v", handler.ctxErrs)
+	}
+}
+
+type panicHandler struct{}
+
+type fakeLogger struct{ lines []string }
+
+func (f *fakeLogger) Printf(format string, args ...any) {
+	f.lines = append(f.lines, fmt.Sprintf(format, args...))
+}
+
+func (panicHandler) HandleMatrixMessage(context.Context, Message) error {
+	panic("malformed event")
+}
+
+func TestOnMessageEvent_RecoversHandlerPanic(t *testing.T) {
+	mx, err := mautrix.NewClient("https://example.com", "@bot:test", "token")
+	if err != nil {
+		t.Fatalf("create mautrix client: %v", err)
+	}
+	logger := &fakeLogger{}
+	if _, err := NewClient(mx, AllowedRooms{"!allowed:test": {}}, panicHandler{}, logger); err != nil {
+		t.Fatalf("new matrix client: %v", err)
+	}
+
+	err = mx.Syncer.(*mautrix.DefaultSyncer).ProcessResponse(context.Background(), &mautrix.RespSync{
+		Rooms: mautrix.RespSyncRooms{Join: map[id.RoomID]*mautrix.SyncJoinedRoom{
+			"!allowed:test": {Timeline: mautrix.SyncTimeline{SyncEventsList: mautrix.SyncEventsList{Events: []*event.Event{{
+				Type:    event.EventMessage,
+				ID:      "$1",
+				Sender:  "@alice:test",
+				Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}},
+			}}}}},
+		}},
+	}, "")
+	if err != nil {
+		t.Fatalf("expected handler panic to be recovered, got %v", err)
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "malformed event") {
+		t.Fatalf("expected recovered panic to be logged, got %#v", logger.lines)
+	}
 }
 
 func TestOnEncryptedEvent_DecryptsAndForwards(t *testing.T) {
@@ -441,6 +868,29 @@ func TestNewClient_RegistersStateStoreSyncHandler(t *testing.T) {
 	}
 }
 
+func TestNewClient_TracksLastSync(t *testing.T) {
+	mx, err := mautrix.NewClient("https://example.com", "@bot:test", "token")
+	if err != nil {
+		t.Fatalf("create mautrix client: %v", err)
+	}
+	c, err := NewClient(mx, AllowedRooms{"!allowed:test": {}}, &fakeHandler{}, nil)
+	if err != nil {
+		t.Fatalf("new matrix client: %v", err)
+	}
+	if !c.LastSync().IsZero() {
+		t.Fatalf("expected zero LastSync before syncing, got %s", c.LastSync())
+	}
+
+	before := time.Now()
+	syncer := mx.Syncer.(*mautrix.DefaultSyncer)
+	if err := syncer.ProcessResponse(context.Background(), &mautrix.RespSync{}, ""); err != nil {
+		t.Fatalf("process sync response: %v", err)
+	}
+	if c.LastSync().Before(before) {
+		t.Fatalf("expected LastSync after %s, got %s", before, c.LastSync())
+	}
+}
+
 func TestStartStop(t *testing.T) {
 	api := &fakeAPI{syncErr: errors.New("boom")}
 	c := &Client{api: api, handler: &fakeHandler{}}
@@ -476,6 +926,10 @@ func TestGetRecentTextMessages_FiltersByTypeAndTime(t *testing.T) {
 	if msgs[0].Sender != "@alice:test" || msgs[0].Body != "hello" {
 		t.Fatalf("unexpected message: %#v", msgs[0])
 	}
+	filter := api.messagesFilt[0]
+	if filter == nil || !filter.LazyLoadMembers || !reflect.DeepEqual(filter.Types, []event.Type{event.EventMessage, event.EventEncrypted}) {
+		t.Fatalf("expected /messages to be filtered to message events, got %#v", filter)
+	}
 }
 
 func TestGetRecentTextMessages_DecryptsEncryptedEvents(t *testing.T) {
@@ -554,3 +1008,260 @@ func TestGetRecentTextMessages_PaginatesToFindMatchingMessages(t *testing.T) {
 		t.Fatalf("unexpected messages: %#v", msgs)
 	}
 }
+
+func TestForwardIfReaction_FiltersAndForwards(t *testing.T) {
+	handler := &fakeReactionHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler, reactions: handler, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+
+	annotation := func(roomID id.RoomID, sender id.UserID) *event.Event {
+		return &event.Event{Type: event.EventReaction, RoomID: roomID, ID: "$r", Sender: sender, Content: event.Content{Parsed: &event.ReactionEventContent{
+			RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: "$target", Key: "🔖"},
+		}}}
+	}
+	c.forwardIfReaction(context.Background(), annotation("!blocked:test", "@alice:test"))
+	c.forwardIfReaction(context.Background(), annotation("!allowed:test", "@bot:test"))
+	c.forwardIfReaction(context.Background(), annotation("!allowed:test", "@alice:test"))
+
+	if len(handler.reactions) != 1 {
+		t.Fatalf("expected one forwarded reaction, got %d", len(handler.reactions))
+	}
+	got := handler.reactions[0]
+	if got.TargetEventID != "$target" || got.Key != "🔖" || got.Sender != "@alice:test" {
+		t.Fatalf("unexpected forwarded reaction: %#v", got)
+	}
+}
+
+func TestForwardIfMessage_ForwardsFiles(t *testing.T) {
+	handler := &fakeFileHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler, files: handler, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+
+	file := &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$file", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     "see the notes",
+		FileName: "notes.pdf",
+		Info:     &event.FileInfo{MimeType: "application/pdf", Size: 1234},
+		File:     &event.EncryptedFileInfo{URL: "mxc://example.org/abc"},
+	}}}
+	c.forwardIfMessage(context.Background(), file)
+
+	if len(handler.files) != 1 || len(handler.msgs) != 0 {
+		t.Fatalf("expected one forwarded file and no message, got files=%#v messages=%#v", handler.files, handler.msgs)
+	}
+	want := FileMessage{RoomID: "!allowed:test", EventID: "$file", Sender: "@alice:test", Name: "notes.pdf", MimeType: "application/pdf", Size: 1234, URL: "mxc://example.org/abc", Caption: "see the notes"}
+	if handler.files[0] != want {
+		t.Fatalf("forwarded file = %#v, want %#v", handler.files[0], want)
+	}
+
+	image := &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$image", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{
+		MsgType: event.MsgImage,
+		Body:    "whiteboard.png",
+		Info:    &event.FileInfo{MimeType: "image/png", Size: 99},
+		URL:     "mxc://example.org/img",
+	}}}
+	c.forwardIfMessage(context.Background(), image)
+
+	if len(handler.files) != 2 || len(handler.msgs) != 0 {
+		t.Fatalf("expected the image forwarded as a file, got files=%#v messages=%#v", handler.files, handler.msgs)
+	}
+	if got := handler.files[1]; got.Name != "whiteboard.png" || got.Caption != "" || got.URL != "mxc://example.org/img" {
+		t.Fatalf("forwarded image = %#v", got)
+	}
+}
+
+func TestForwardIfRedaction(t *testing.T) {
+	handler := &fakeRedactionHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler, redactions: handler, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+
+	c.forwardIfRedaction(context.Background(), &event.Event{Type: event.EventRedaction, RoomID: "!blocked:test", ID: "$r1", Redacts: "$1"})
+	c.forwardIfRedaction(context.Background(), &event.Event{Type: event.EventRedaction, RoomID: "!allowed:test", ID: "$r2", Sender: "@alice:test", Redacts: "$2"})
+	c.forwardIfRedaction(context.Background(), &event.Event{Type: event.EventRedaction, RoomID: "!allowed:test", ID: "$r3", Sender: "@alice:test",
+		Content: event.Content{Parsed: &event.RedactionEventContent{Redacts: "$3"}}})
+
+	want := []Redaction{
+		{RoomID: "!allowed:test", EventID: "$r2", Sender: "@alice:test", Redacts: "$2"},
+		{RoomID: "!allowed:test", EventID: "$r3", Sender: "@alice:test", Redacts: "$3"},
+	}
+	if !reflect.DeepEqual(handler.redactions, want) {
+		t.Fatalf("forwarded redactions = %#v, want %#v", handler.redactions, want)
+	}
+}
+
+func TestOnMemberEvent_ForwardsOwnJoins(t *testing.T) {
+	handler := &fakeJoinHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler, joins: handler, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+
+	member := func(roomID id.RoomID, stateKey string, membership, prev event.Membership) *event.Event {
+		ev := &event.Event{Type: event.StateMember, RoomID: roomID, StateKey: &stateKey, Content: event.Content{Parsed: &event.MemberEventContent{Membership: membership}}}
+		if prev != "" {
+			ev.Unsigned.PrevContent = &event.Content{Parsed: &event.MemberEventContent{Membership: prev}}
+		}
+		return ev
+	}
+	c.onMemberEvent(context.Background(), member("!blocked:test", "@bot:test", event.MembershipJoin, event.MembershipInvite))
+	c.onMemberEvent(context.Background(), member("!allowed:test", "@alice:test", event.MembershipJoin, ""))
+	c.onMemberEvent(context.Background(), member("!allowed:test", "@bot:test", event.MembershipLeave, event.MembershipJoin))
+	c.onMemberEvent(context.Background(), member("!allowed:test", "@bot:test", event.MembershipJoin, event.MembershipJoin))
+	c.onMemberEvent(context.Background(), member("!allowed:test", "@bot:test", event.MembershipJoin, event.MembershipInvite))
+
+	if len(handler.joins) != 1 || handler.joins[0] != "!allowed:test" {
+		t.Fatalf("expected one forwarded join, got %#v", handler.joins)
+	}
+}
+
+func TestOnMemberEvent_AnswersInvites(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, membership: api, handler: &fakeHandler{}, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+
+	invite := func(roomID id.RoomID, stateKey string, source event.Source) *event.Event {
+		ev := &event.Event{Type: event.StateMember, RoomID: roomID, Sender: "@alice:test", StateKey: &stateKey, Content: event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipInvite}}}
+		ev.Mautrix.EventSource = source
+		return ev
+	}
+	c.onMemberEvent(context.Background(), invite("!allowed:test", "@bot:test", event.SourceInvite|event.SourceState))
+	c.onMemberEvent(context.Background(), invite("!blocked:test", "@bot:test", event.SourceInvite|event.SourceState))
+	c.onMemberEvent(context.Background(), invite("!allowed:test", "@carol:test", event.SourceInvite|event.SourceState))
+	c.onMemberEvent(context.Background(), invite("!other:test", "@bot:test", event.SourceJoin|event.SourceTimeline))
+
+	if len(api.joinedRooms) != 1 || api.joinedRooms[0] != "!allowed:test" {
+		t.Fatalf("expected to join only the allowed room, joined %v", api.joinedRooms)
+	}
+	if len(api.leftRooms) != 1 || api.leftRooms[0] != "!blocked:test" {
+		t.Fatalf("expected to reject only the blocked room, left %v", api.leftRooms)
+	}
+}
+
+func TestDirectMessages_AcceptsInvitesAndForwardsMessages(t *testing.T) {
+	api := &fakeAPI{}
+	direct := &fakeDirect{directs: event.DirectChatsEventContent{"@mallory:test": {"!old:test"}}}
+	handler := &fakeHandler{}
+	c := &Client{api: api, membership: api, direct: direct, crypto: &fakeCrypto{}, handler: handler, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+	users, err := NewSenderRules([]string{"@*:example.org"}, nil)
+	if err != nil {
+		t.Fatalf("NewSenderRules failed: %v", err)
+	}
+	c.SetDirectMessages(users)
+
+	invite := func(roomID id.RoomID, sender id.UserID, isDirect bool) {
+		stateKey := "@bot:test"
+		ev := &event.Event{Type: event.StateMember, RoomID: roomID, Sender: sender, StateKey: &stateKey, Content: event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipInvite, IsDirect: isDirect}}}
+		ev.Mautrix.EventSource = event.SourceInvite | event.SourceState
+		c.onMemberEvent(context.Background(), ev)
+	}
+	invite("!dm:test", "@alice:example.org", true)
+	invite("!group:test", "@alice:example.org", false)
+	invite("!spam:test", "@mallory:test", true)
+
+	if !reflect.DeepEqual(api.joinedRooms, []id.RoomID{"!dm:test"}) || !reflect.DeepEqual(api.leftRooms, []id.RoomID{"!group:test", "!spam:test"}) {
+		t.Fatalf("joined %v and left %v", api.joinedRooms, api.leftRooms)
+	}
+	if got := direct.directs["@alice:example.org"]; len(got) != 1 || got[0] != "!dm:test" {
+		t.Fatalf("expected the direct chat in m.direct, got %v", direct.directs)
+	}
+	if content, ok := api.stateSent.(*event.EncryptionEventContent); !ok || api.stateType != event.StateEncryption || content.Algorithm != id.AlgorithmMegolmV1 {
+		t.Fatalf("expected encryption to be enabled, sent %s %#v", api.stateType, api.stateSent)
+	}
+
+	message := func(roomID id.RoomID) {
+		c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: roomID, ID: id.EventID("$" + roomID), Sender: "@alice:example.org", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "/search go"}}})
+	}
+	message("!dm:test")
+	message("!old:test")
+	message("!group:test")
+	if len(handler.msgs) != 1 || handler.msgs[0].RoomID != "!dm:test" {
+		t.Fatalf("expected only the allowed direct chat's message, got %#v", handler.msgs)
+	}
+}
+
+func TestLeaveDisallowedRooms(t *testing.T) {
+	api := &fakeAPI{roomsIn: []id.RoomID{"!allowed:test", "!stray:test", "!dm:test"}}
+	direct := &fakeDirect{directs: event.DirectChatsEventContent{"@alice:test": {"!dm:test"}}}
+	c := &Client{api: api, membership: api, direct: direct, handler: &fakeHandler{}, roomPolicy: AllowedRooms{"!allowed:test": {}}, botUserID: "@bot:test"}
+	c.SetLeaveDisallowedRooms(true, "I only serve configured rooms.")
+
+	c.leaveDisallowedRooms(context.Background())
+	join := func(roomID id.RoomID) *event.Event {
+		stateKey := "@bot:test"
+		return &event.Event{Type: event.StateMember, RoomID: roomID, StateKey: &stateKey, Content: event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipJoin}}}
+	}
+	c.onMemberEvent(context.Background(), join("!stray:test"))
+	c.onMemberEvent(context.Background(), join("!later:test"))
+
+	if want := []id.RoomID{"!stray:test", "!later:test"}; !reflect.DeepEqual(api.leftRooms, want) {
+		t.Fatalf("left rooms = %v, want %v", api.leftRooms, want)
+	}
+	content, ok := api.sentContent.(*event.MessageEventContent)
+	if !ok || api.sentRoomID != "!later:test" || content.Body != "I only serve configured rooms." {
+		t.Fatalf("expected the leave message before leaving, got room=%s content=%#v", api.sentRoomID, api.sentContent)
+	}
+
+	api.leftRooms = nil
+	c.SetLeaveDisallowedRooms(false, "")
+	c.onMemberEvent(context.Background(), join("!another:test"))
+	if len(api.leftRooms) != 0 {
+		t.Fatalf("expected disallowed rooms to be kept when disabled, left %v", api.leftRooms)
+	}
+}
+
+func TestSendReaction_SendsAnnotation(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, handler: &fakeHandler{}}
+
+	if err := c.SendReaction(context.Background(), "!room:test", "$target", "✅"); err != nil {
+		t.Fatalf("SendReaction failed: %v", err)
+	}
+	content, ok := api.sentContent.(*event.ReactionEventContent)
+	if !ok || api.sentType != event.EventReaction {
+		t.Fatalf("unexpected reaction send type=%s content=%T", api.sentType, api.sentContent)
+	}
+	if content.RelatesTo.Type != event.RelAnnotation || content.RelatesTo.EventID != "$target" || content.RelatesTo.Key != "✅" {
+		t.Fatalf("unexpected reaction relation: %#v", content.RelatesTo)
+	}
+}
+
+func TestGetTextMessage_ParsesEvent(t *testing.T) {
+	api := &fakeAPI{event: &event.Event{
+		Type:    event.EventMessage,
+		ID:      "$target",
+		Sender:  "@alice:test",
+		Content: event.Content{VeryRaw: json.RawMessage(`{"msgtype":"m.text","body":" see https://example.org "}`)},
+	}}
+	c := &Client{api: api, handler: &fakeHandler{}}
+
+	msg, err := c.GetTextMessage(context.Background(), "!room:test", "$target")
+	if err != nil {
+		t.Fatalf("GetTextMessage failed: %v", err)
+	}
+	if msg.Sender != "@alice:test" || msg.Body != "see https://example.org" {
+		t.Fatalf("unexpected message: %#v", msg)
+	}
+}
+
+func TestClientPinEventReplacesPreviousPin(t *testing.T) {
+	api := &fakeAPI{pinned: []id.EventID{"$rules", "$old-digest"}}
+	c := &Client{api: api}
+
+	if err := c.PinEvent(context.Background(), "!room:example.org", "$digest", "$old-digest"); err != nil {
+		t.Fatalf("PinEvent() error = %v", err)
+	}
+	content, ok := api.stateSent.(*event.PinnedEventsEventContent)
+	if !ok || api.stateType != event.StatePinnedEvents {
+		t.Fatalf("unexpected state event type=%v content=%#v", api.stateType, api.stateSent)
+	}
+	if want := []id.EventID{"$rules", "$digest"}; !reflect.DeepEqual(content.Pinned, want) {
+		t.Fatalf("pinned = %#v, want %#v", content.Pinned, want)
+	}
+}
+
+func TestClientSendNoticeReturnsEventID(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api}
+
+	eventID, err := c.SendNotice(context.Background(), "!room:example.org", "digest")
+	if err != nil {
+		t.Fatalf("SendNotice() error = %v", err)
+	}
+	content, ok := api.sentContent.(*event.MessageEventContent)
+	if eventID != "$reply" || !ok || content.RelatesTo != nil || content.Body != "digest" {
+		t.Fatalf("SendNotice() = %s, content %#v", eventID, api.sentContent)
+	}
+}
@@ -0,0 +1,40 @@
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+)
+
+// NewCryptoHelper builds a crypto helper that keeps olm and megolm state in
+// db, the crypto DB opened by storage.Open, pickled with pickleKey. It
+// initializes the helper and sets it as mx.Crypto, so it must be called
+// after the client has its access token. The key must stay the same across
+// restarts, or the stored account can no longer be read. Close the helper on
+// shutdown.
+func NewCryptoHelper(ctx context.Context, mx *mautrix.Client, db *sql.DB, pickleKey []byte) (*cryptohelper.CryptoHelper, error) {
+	if db == nil {
+		return nil, errors.New("crypto db is required")
+	}
+	if len(pickleKey) == 0 {
+		return nil, errors.New("pickle key is required")
+	}
+	cryptoDB, err := dbutil.NewWithDB(db, "sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("wrap crypto db: %w", err)
+	}
+	helper, err := cryptohelper.NewCryptoHelper(mx, pickleKey, cryptoDB)
+	if err != nil {
+		return nil, fmt.Errorf("create crypto helper: %w", err)
+	}
+	if err := helper.Init(ctx); err != nil {
+		return nil, fmt.Errorf("init crypto: %w", err)
+	}
+	mx.Crypto = helper
+	return helper, nil
+}
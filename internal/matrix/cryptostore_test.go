@@ -0,0 +1,25 @@
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestNewCryptoHelper_RequiresStoreAndKey(t *testing.T) {
+	mx, err := mautrix.NewClient("https://matrix.example.org", "@bot:example.org", "token")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if _, err := NewCryptoHelper(context.Background(), mx, nil, []byte("key")); err == nil {
+		t.Fatal("expected error without a crypto db")
+	}
+	if _, err := NewCryptoHelper(context.Background(), mx, &sql.DB{}, nil); err == nil {
+		t.Fatal("expected error without a pickle key")
+	}
+	if mx.Crypto != nil {
+		t.Fatal("expected mx.Crypto to stay unset after a failed setup")
+	}
+}
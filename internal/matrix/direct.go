@@ -0,0 +1,90 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// directAPI finds and creates direct chats. It is separate from matrixAPI so
+// callers that never DM users need not implement it.
+type directAPI interface {
+	GetAccountData(ctx context.Context, name string, output interface{}) error
+	SetAccountData(ctx context.Context, name string, data interface{}) error
+	CreateRoom(ctx context.Context, req *mautrix.ReqCreateRoom) (*mautrix.RespCreateRoom, error)
+}
+
+// directRooms remembers the direct chat used for each user.
+type directRooms struct {
+	mu    sync.Mutex
+	rooms map[id.UserID]id.RoomID
+}
+
+// SendDirectNotice posts body as a notice in a direct chat with userID. The
+// newest direct chat listed in the bot's m.direct account data is reused;
+// otherwise one is created and recorded there.
+func (c *Client) SendDirectNotice(ctx context.Context, userID id.UserID, body string) error {
+	roomID, err := c.directRoom(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = c.sendMessage(ctx, Reply{RoomID: roomID, Body: body})
+	return err
+}
+
+func (c *Client) directRoom(ctx context.Context, userID id.UserID) (id.RoomID, error) {
+	if c.direct == nil {
+		return "", errors.New("direct chats are not supported")
+	}
+	c.dms.mu.Lock()
+	defer c.dms.mu.Unlock()
+	if roomID, ok := c.dms.rooms[userID]; ok {
+		return roomID, nil
+	}
+
+	directs := event.DirectChatsEventContent{}
+	err := c.direct.GetAccountData(ctx, event.AccountDataDirectChats.Type, &directs)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return "", fmt.Errorf("get direct chats: %w", err)
+	}
+	if rooms := directs[userID]; len(rooms) > 0 {
+		return c.rememberDirectRoom(userID, rooms[len(rooms)-1]), nil
+	}
+
+	resp, err := c.direct.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Preset:   "trusted_private_chat",
+		IsDirect: true,
+		Invite:   []id.UserID{userID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("create direct chat: %w", err)
+	}
+	if directs == nil {
+		directs = event.DirectChatsEventContent{}
+	}
+	directs[userID] = append(directs[userID], resp.RoomID)
+	if err := c.direct.SetAccountData(ctx, event.AccountDataDirectChats.Type, directs); err != nil {
+		c.logf("record direct chat failed user=%s room=%s err=%v", userID, resp.RoomID, err)
+	}
+	return c.rememberDirectRoom(userID, resp.RoomID), nil
+}
+
+func (c *Client) rememberDirectRoom(userID id.UserID, roomID id.RoomID) id.RoomID {
+	if c.dms.rooms == nil {
+		c.dms.rooms = make(map[id.UserID]id.RoomID)
+	}
+	c.dms.rooms[userID] = roomID
+	if dms := c.dmUsers; dms != nil {
+		dms.mu.Lock()
+		if dms.loaded {
+			dms.rooms[roomID] = userID
+		}
+		dms.mu.Unlock()
+	}
+	return roomID
+}
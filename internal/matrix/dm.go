@@ -0,0 +1,143 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// directMessages lets users start direct chats with the bot. Their chats are
+// handled like allowed rooms.
+type directMessages struct {
+	users SenderPolicy
+	mu    sync.Mutex
+	// rooms maps each direct chat to its other user; it is loaded from the
+	// bot's m.direct account data on first use.
+	rooms  map[id.RoomID]id.UserID
+	loaded bool
+}
+
+// SetDirectMessages accepts direct chat invites from users allows, joining
+// them and turning on encryption, and handles messages in those chats as if
+// their rooms were allowed. Nil turns direct chats off again. Direct chats are
+// recognized by the bot's m.direct account data, so they survive restarts.
+func (c *Client) SetDirectMessages(users SenderPolicy) {
+	if users == nil {
+		c.dmUsers = nil
+		return
+	}
+	c.dmUsers = &directMessages{users: users}
+}
+
+// roomAllowed reports whether events in roomID are handled: the room policy
+// allows it, or it is a direct chat with a user SetDirectMessages allows.
+func (c *Client) roomAllowed(ctx context.Context, roomID id.RoomID) bool {
+	if c.roomPolicy == nil || c.roomPolicy.Allowed(roomID) {
+		return true
+	}
+	return c.allowedDirectChat(ctx, roomID)
+}
+
+func (c *Client) allowedDirectChat(ctx context.Context, roomID id.RoomID) bool {
+	dms := c.dmUsers
+	if dms == nil || c.direct == nil {
+		return false
+	}
+	dms.mu.Lock()
+	defer dms.mu.Unlock()
+	if !dms.loaded {
+		directs := event.DirectChatsEventContent{}
+		err := c.direct.GetAccountData(ctx, event.AccountDataDirectChats.Type, &directs)
+		if err != nil && !errors.Is(err, mautrix.MNotFound) {
+			c.logf("load direct chats failed err=%v", err)
+			return false
+		}
+		dms.rooms = make(map[id.RoomID]id.UserID)
+		for userID, rooms := range directs {
+			for _, room := range rooms {
+				dms.rooms[room] = userID
+			}
+		}
+		dms.loaded = true
+	}
+	userID, ok := dms.rooms[roomID]
+	return ok && dms.users.Allowed(userID)
+}
+
+// acceptDirectInvite joins a room the bot was invited to as a direct chat by
+// a user SetDirectMessages allows, records it in m.direct and makes sure it
+// is encrypted. It reports false for other invites.
+func (c *Client) acceptDirectInvite(ctx context.Context, ev *event.Event) bool {
+	member := ev.Content.AsMember()
+	if c.dmUsers == nil || c.direct == nil || member == nil || !member.IsDirect || !c.dmUsers.users.Allowed(ev.Sender) {
+		return false
+	}
+	if _, err := c.membership.JoinRoomByID(ctx, ev.RoomID); err != nil {
+		c.logf("accept direct chat invite failed room=%s inviter=%s err=%v", ev.RoomID, ev.Sender, err)
+		return true
+	}
+	c.logf("accepted direct chat invite room=%s inviter=%s", ev.RoomID, ev.Sender)
+	if err := c.recordDirectChat(ctx, ev.Sender, ev.RoomID); err != nil {
+		c.logf("record direct chat failed user=%s room=%s err=%v", ev.Sender, ev.RoomID, err)
+	}
+	c.ensureEncrypted(ctx, ev.RoomID)
+	return true
+}
+
+// recordDirectChat adds roomID to the bot's m.direct account data as a
+// direct chat with userID.
+func (c *Client) recordDirectChat(ctx context.Context, userID id.UserID, roomID id.RoomID) error {
+	c.dmUsers.mu.Lock()
+	if c.dmUsers.rooms == nil {
+		c.dmUsers.rooms = make(map[id.RoomID]id.UserID)
+	}
+	c.dmUsers.rooms[roomID] = userID
+	c.dmUsers.mu.Unlock()
+
+	directs := event.DirectChatsEventContent{}
+	err := c.direct.GetAccountData(ctx, event.AccountDataDirectChats.Type, &directs)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return fmt.Errorf("get direct chats: %w", err)
+	}
+	if slices.Contains(directs[userID], roomID) {
+		return nil
+	}
+	if directs == nil {
+		directs = event.DirectChatsEventContent{}
+	}
+	directs[userID] = append(directs[userID], roomID)
+	if err := c.direct.SetAccountData(ctx, event.AccountDataDirectChats.Type, directs); err != nil {
+		return fmt.Errorf("set direct chats: %w", err)
+	}
+	return nil
+}
+
+// ensureEncrypted turns on end-to-end encryption in roomID unless it already
+// is encrypted or the bot has no crypto support. Failures are only logged,
+// for example when the bot may not change the room's encryption.
+func (c *Client) ensureEncrypted(ctx context.Context, roomID id.RoomID) {
+	if c.crypto == nil {
+		return
+	}
+	var content event.EncryptionEventContent
+	err := c.api.StateEvent(ctx, roomID, event.StateEncryption, "", &content)
+	if err == nil && content.Algorithm != "" {
+		return
+	}
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		c.logf("get room encryption failed room=%s err=%v", roomID, err)
+		return
+	}
+	content = event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+	if _, err := c.api.SendStateEvent(ctx, roomID, event.StateEncryption, "", &content); err != nil {
+		c.logf("enable encryption failed room=%s err=%v", roomID, err)
+		return
+	}
+	c.logf("enabled encryption room=%s", roomID)
+}
@@ -0,0 +1,87 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// syncFilterStatePrefix keys the filter an account last synced with, followed
+// by its user ID.
+const syncFilterStatePrefix = "matrix_sync_filter:"
+
+// syncTimelineLimit is the timeline limit of mautrix's default filter.
+const syncTimelineLimit = 50
+
+// syncTimelineTypes are the timeline events the bot handles, and the state
+// events the state store and end-to-end encryption need to follow.
+var syncTimelineTypes = []event.Type{
+	event.EventMessage,
+	event.EventEncrypted,
+	event.EventReaction,
+	event.EventRedaction,
+	event.StateMember,
+	event.StateEncryption,
+	event.StateHistoryVisibility,
+	event.StatePowerLevels,
+}
+
+// FilterState remembers the filter an account synced with.
+type FilterState interface {
+	PutBotState(ctx context.Context, key, value string) error
+	GetBotState(ctx context.Context, key string) (string, error)
+}
+
+// NewSyncFilter returns a sync filter that limits room timelines to rooms and
+// to the event types the bot uses, and drops presence, typing notifications,
+// receipts and room account data. Room state and invites are not limited, so
+// invites to other rooms are still seen and rejected. Nil rooms leaves
+// timelines of all rooms in, for direct chats that are not known up front.
+func NewSyncFilter(rooms []id.RoomID) *mautrix.Filter {
+	rooms = slices.Clone(rooms)
+	slices.Sort(rooms)
+	none := []event.Type{{Type: "*"}}
+	return &mautrix.Filter{
+		EventFormat: mautrix.EventFormatClient,
+		Presence:    &mautrix.FilterPart{NotTypes: none},
+		Room: &mautrix.RoomFilter{
+			Timeline:    &mautrix.FilterPart{Rooms: rooms, Types: syncTimelineTypes, Limit: syncTimelineLimit},
+			Ephemeral:   &mautrix.FilterPart{NotTypes: none},
+			AccountData: &mautrix.FilterPart{NotTypes: none},
+		},
+	}
+}
+
+// UseSyncFilter makes mx sync with filter. mautrix uploads the filter on the
+// first sync and keeps its ID in mx.Store; that ID is reused while the filter
+// stays the same and dropped when it changed, for example because the allowed
+// rooms did, so the next sync uploads the new filter.
+func UseSyncFilter(ctx context.Context, mx *mautrix.Client, state FilterState, filter *mautrix.Filter) error {
+	syncer := ensureDefaultSyncer(mx)
+	syncer.FilterJSON = filter
+
+	raw, err := json.Marshal(filter)
+	if err != nil {
+		return fmt.Errorf("encode sync filter: %w", err)
+	}
+	key := syncFilterStatePrefix + string(mx.UserID)
+	previous, err := state.GetBotState(ctx, key)
+	if err != nil {
+		return fmt.Errorf("load sync filter: %w", err)
+	}
+	if previous == string(raw) {
+		return nil
+	}
+	if err := mx.Store.SaveFilterID(ctx, mx.UserID, ""); err != nil {
+		return fmt.Errorf("reset sync filter: %w", err)
+	}
+	if err := state.PutBotState(ctx, key, string(raw)); err != nil {
+		return fmt.Errorf("save sync filter: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package matrix
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestNewSyncFilter(t *testing.T) {
+	filter := NewSyncFilter([]id.RoomID{"!b:test", "!a:test"})
+
+	timeline := filter.Room.Timeline
+	if !slices.Equal(timeline.Rooms, []id.RoomID{"!a:test", "!b:test"}) {
+		t.Fatalf("expected sorted allowed rooms, got %v", timeline.Rooms)
+	}
+	for _, want := range []event.Type{event.EventMessage, event.EventEncrypted, event.EventReaction, event.EventRedaction, event.StateMember} {
+		if !slices.Contains(timeline.Types, want) {
+			t.Fatalf("expected timeline type %s, got %v", want.Type, timeline.Types)
+		}
+	}
+	if filter.Room.Rooms != nil || filter.Room.State != nil {
+		t.Fatalf("expected room state and invites to stay unfiltered, got %#v", filter.Room)
+	}
+	if err := filter.Validate(); err != nil {
+		t.Fatalf("invalid filter: %v", err)
+	}
+}
+
+func TestUseSyncFilter_ResetsSavedFilterWhenItChanges(t *testing.T) {
+	ctx := context.Background()
+	mx, err := mautrix.NewClient("https://matrix.test", "@bot:test", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := memoryState{}
+	if err := mx.Store.SaveFilterID(ctx, mx.UserID, "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := NewSyncFilter([]id.RoomID{"!a:test"})
+	if err := UseSyncFilter(ctx, mx, state, filter); err != nil {
+		t.Fatal(err)
+	}
+	if got := mx.Syncer.GetFilterJSON(mx.UserID); got != filter {
+		t.Fatalf("expected the syncer to use the filter, got %#v", got)
+	}
+	if id, _ := mx.Store.LoadFilterID(ctx, mx.UserID); id != "" {
+		t.Fatalf("expected the filter saved before this one to be dropped, got %q", id)
+	}
+
+	_ = mx.Store.SaveFilterID(ctx, mx.UserID, "uploaded")
+	if err := UseSyncFilter(ctx, mx, state, NewSyncFilter([]id.RoomID{"!a:test"})); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := mx.Store.LoadFilterID(ctx, mx.UserID); id != "uploaded" {
+		t.Fatalf("expected an unchanged filter to be reused, got %q", id)
+	}
+
+	if err := UseSyncFilter(ctx, mx, state, NewSyncFilter([]id.RoomID{"!a:test", "!b:test"})); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := mx.Store.LoadFilterID(ctx, mx.UserID); id != "" {
+		t.Fatalf("expected a changed filter to be uploaded again, got %q", id)
+	}
+}
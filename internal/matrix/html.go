@@ -0,0 +1,108 @@
+package matrix
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// linkPattern matches http(s) URLs in plain text.
+var linkPattern = regexp.MustCompile(`(?i)https?://[^\s<>"']+`)
+
+// FormatHTML renders plain text as HTML for formatted_body. URLs become
+// links, mentions of users become pills, and runs of lines starting with
+// "- " or "* " become bullet lists; other lines keep their line breaks.
+func FormatHTML(body string, users []id.UserID) string {
+	pills := userIDPattern(users)
+	var b strings.Builder
+	inList, afterText := false, false
+	for _, line := range strings.Split(body, "\n") {
+		if item, ok := bulletItem(line); ok {
+			if !inList {
+				b.WriteString("<ul>")
+				inList = true
+			}
+			b.WriteString("<li>" + formatInline(item, pills) + "</li>")
+			afterText = false
+			continue
+		}
+		if inList {
+			b.WriteString("</ul>")
+			inList = false
+		} else if afterText {
+			b.WriteString("<br>")
+		}
+		b.WriteString(formatInline(line, pills))
+		afterText = true
+	}
+	if inList {
+		b.WriteString("</ul>")
+	}
+	return b.String()
+}
+
+// bulletItem returns the text of a "- " or "* " list line.
+func bulletItem(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	for _, marker := range []string{"- ", "* "} {
+		if item, ok := strings.CutPrefix(trimmed, marker); ok {
+			return strings.TrimSpace(item), true
+		}
+	}
+	return "", false
+}
+
+// formatInline escapes text, linking its URLs and turning matches of pills
+// into user pills.
+func formatInline(text string, pills *regexp.Regexp) string {
+	type span struct {
+		start, end int
+		user       bool
+	}
+	var spans []span
+	for _, loc := range linkPattern.FindAllStringIndex(text, -1) {
+		spans = append(spans, span{start: loc[0], end: loc[0] + len(trimLink(text[loc[0]:loc[1]]))})
+	}
+	if pills != nil {
+		for _, loc := range pills.FindAllStringIndex(text, -1) {
+			spans = append(spans, span{start: loc[0], end: loc[1], user: true})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, sp := range spans {
+		if sp.start < last {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:sp.start]))
+		match := text[sp.start:sp.end]
+		href := match
+		if sp.user {
+			href = id.UserID(match).URI().MatrixToURL()
+		}
+		b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(match) + `</a>`)
+		last = sp.end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// trimLink drops trailing punctuation that more likely ends the sentence
+// than the URL, and a closing parenthesis without an opening one.
+func trimLink(link string) string {
+	for {
+		trimmed := strings.TrimRight(link, ".,;:!?")
+		if strings.HasSuffix(trimmed, ")") && !strings.Contains(trimmed, "(") {
+			trimmed = strings.TrimSuffix(trimmed, ")")
+		}
+		if trimmed == link {
+			return link
+		}
+		link = trimmed
+	}
+}
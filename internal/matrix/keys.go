@@ -0,0 +1,239 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// KeyExportFileName is the file name room key exports are sent as.
+	KeyExportFileName = "element-keys.txt"
+	keyExportMimeType = "text/plain"
+)
+
+var (
+	// ErrNoCrypto is returned by key commands when the client has no crypto
+	// helper.
+	ErrNoCrypto = errors.New("end-to-end encryption is not enabled")
+	// ErrNoRoomKeys is returned when there are no room keys to export.
+	ErrNoRoomKeys = crypto.ErrNoSessionsForExport
+	// ErrFileTooLarge is returned by DownloadFile when the file is larger
+	// than the caller allows.
+	ErrFileTooLarge = errors.New("file is too large")
+)
+
+// mediaAPI uploads and downloads files. It is separate from matrixAPI so
+// callers that never touch media need not implement it.
+type mediaAPI interface {
+	UploadBytesWithName(ctx context.Context, data []byte, contentType, fileName string) (*mautrix.RespMediaUpload, error)
+	Download(ctx context.Context, mxcURL id.ContentURI) (*http.Response, error)
+}
+
+// ExportRoomKeys exports every megolm session in mach's store in the
+// passphrase-encrypted key export format Matrix clients use, and returns how
+// many sessions it holds.
+func ExportRoomKeys(ctx context.Context, mach *crypto.OlmMachine, passphrase string) ([]byte, int, error) {
+	if passphrase == "" {
+		return nil, 0, errors.New("passphrase must not be empty")
+	}
+	sessions, err := mach.CryptoStore.GetAllGroupSessions(ctx).AsList()
+	if err != nil {
+		return nil, 0, fmt.Errorf("load room keys: %w", err)
+	}
+	data, err := crypto.ExportKeys(passphrase, sessions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("export room keys: %w", err)
+	}
+	return data, len(sessions), nil
+}
+
+// ImportRoomKeys imports a key export into mach's store and returns how many
+// of its sessions were new and how many it holds.
+func ImportRoomKeys(ctx context.Context, mach *crypto.OlmMachine, passphrase string, data []byte) (int, int, error) {
+	imported, total, err := mach.ImportKeys(ctx, passphrase, data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("import room keys: %w", err)
+	}
+	return imported, total, nil
+}
+
+// ExportRoomKeys exports the bot's room keys; see the package function.
+func (c *Client) ExportRoomKeys(ctx context.Context, passphrase string) ([]byte, int, error) {
+	if c.machine == nil {
+		return nil, 0, ErrNoCrypto
+	}
+	return ExportRoomKeys(ctx, c.machine, passphrase)
+}
+
+// ImportRoomKeys imports a key export into the bot's crypto store.
+func (c *Client) ImportRoomKeys(ctx context.Context, passphrase string, data []byte) (int, int, error) {
+	if c.machine == nil {
+		return 0, 0, ErrNoCrypto
+	}
+	return ImportRoomKeys(ctx, c.machine, passphrase, data)
+}
+
+// SendKeyExport posts data as a file in reply to inReplyTo. In encrypted
+// rooms the file is uploaded as an encrypted attachment.
+func (c *Client) SendKeyExport(ctx context.Context, roomID id.RoomID, inReplyTo id.EventID, data []byte) error {
+	_, err := c.SendFile(ctx, roomID, File{
+		Name:      KeyExportFileName,
+		MimeType:  keyExportMimeType,
+		Data:      data,
+		InReplyTo: inReplyTo,
+	})
+	return err
+}
+
+// File is an attachment sent with SendFile.
+type File struct {
+	Name     string
+	MimeType string
+	Data     []byte
+	// Caption, if set, is shown with the file; FormattedCaption is its HTML.
+	Caption          string
+	FormattedCaption string
+	InReplyTo        id.EventID
+}
+
+// SendFile uploads file and posts it as an m.file message, returning its
+// event ID. In encrypted rooms the file is uploaded as an encrypted
+// attachment.
+func (c *Client) SendFile(ctx context.Context, roomID id.RoomID, file File) (id.EventID, error) {
+	if c.media == nil {
+		return "", errors.New("media upload is not available")
+	}
+	if err := c.ensureRoomEncryptionState(ctx, roomID); err != nil {
+		return "", err
+	}
+	if err := c.ensureRoomMembersForEncryption(ctx, roomID); err != nil {
+		return "", err
+	}
+	if err := c.ensureGroupSessionForEncryption(ctx, roomID); err != nil {
+		return "", err
+	}
+
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     file.Name,
+		FileName: file.Name,
+		Info:     &event.FileInfo{MimeType: file.MimeType, Size: len(file.Data)},
+	}
+	if file.Caption != "" {
+		// Since Matrix 1.10 a body that differs from the file name is the
+		// file's caption.
+		content.Body = file.Caption
+		if file.FormattedCaption != "" {
+			content.Format = event.FormatHTML
+			content.FormattedBody = file.FormattedCaption
+		}
+	}
+	encrypted := false
+	if c.crypto != nil && c.stateStore != nil {
+		var err error
+		if encrypted, err = c.stateStore.IsEncrypted(ctx, roomID); err != nil {
+			return "", fmt.Errorf("check room encryption state: %w", err)
+		}
+	}
+	upload, uploadType := file.Data, file.MimeType
+	if encrypted {
+		encryptedFile := attachment.NewEncryptedFile()
+		upload, uploadType = bytes.Clone(file.Data), "application/octet-stream"
+		encryptedFile.EncryptInPlace(upload)
+		content.File = &event.EncryptedFileInfo{EncryptedFile: *encryptedFile}
+	}
+	resp, err := c.media.UploadBytesWithName(ctx, upload, uploadType, file.Name)
+	if err != nil {
+		return "", fmt.Errorf("upload %s: %w", file.Name, err)
+	}
+	if content.File != nil {
+		content.File.URL = resp.ContentURI.CUString()
+	} else {
+		content.URL = resp.ContentURI.CUString()
+	}
+	if file.InReplyTo != "" {
+		content.SetReply(&event.Event{ID: file.InReplyTo, RoomID: roomID})
+	}
+	sent, err := c.api.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+	if err != nil {
+		return "", fmt.Errorf("send %s: %w", file.Name, err)
+	}
+	return sent.EventID, nil
+}
+
+// DownloadFile fetches the file attached to a file message, decrypting the
+// event and the attachment as needed. The size the sender declares is not
+// trusted: the download stops with ErrFileTooLarge once more than maxBytes
+// have been read.
+func (c *Client) DownloadFile(ctx context.Context, roomID id.RoomID, eventID id.EventID, maxBytes int64) ([]byte, error) {
+	if c.media == nil {
+		return nil, errors.New("media download is not available")
+	}
+	ev, err := c.api.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("fetch event: %w", err)
+	}
+	if ev != nil && ev.RoomID == "" {
+		ev.RoomID = roomID
+	}
+	parsed, ok := c.parseHistoryMessageEvent(ctx, ev)
+	if !ok {
+		return nil, fmt.Errorf("event %s is not a message", eventID)
+	}
+	content := parsed.Content.AsMessage()
+	if content.MsgType != event.MsgFile {
+		return nil, fmt.Errorf("event %s is not a file", eventID)
+	}
+
+	rawURL := content.URL
+	if content.File != nil {
+		rawURL = content.File.URL
+	}
+	uri, err := rawURL.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse file url: %w", err)
+	}
+	resp, err := c.media.Download(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("download file: %w (limit %d bytes)", ErrFileTooLarge, maxBytes)
+	}
+	if content.File != nil {
+		if err := content.File.DecryptInPlace(data); err != nil {
+			return nil, fmt.Errorf("decrypt file: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// IsDirectChat reports whether userID and the bot are the only joined
+// members of roomID.
+func (c *Client) IsDirectChat(ctx context.Context, roomID id.RoomID, userID id.UserID) (bool, error) {
+	resp, err := c.api.JoinedMembers(ctx, roomID)
+	if err != nil {
+		return false, fmt.Errorf("fetch joined members: %w", err)
+	}
+	if len(resp.Joined) != 2 {
+		return false, nil
+	}
+	_, hasUser := resp.Joined[userID]
+	_, hasBot := resp.Joined[c.botUserID]
+	return hasUser && hasBot, nil
+}
@@ -0,0 +1,177 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeMedia struct {
+	uploads map[id.ContentURI][]byte
+	types   []string
+}
+
+func (f *fakeMedia) UploadBytesWithName(_ context.Context, data []byte, contentType, _ string) (*mautrix.RespMediaUpload, error) {
+	if f.uploads == nil {
+		f.uploads = make(map[id.ContentURI][]byte)
+	}
+	uri := id.ContentURI{Homeserver: "example.org", FileID: "file"}
+	f.uploads[uri] = bytes.Clone(data)
+	f.types = append(f.types, contentType)
+	return &mautrix.RespMediaUpload{ContentURI: uri}, nil
+}
+
+func (f *fakeMedia) Download(_ context.Context, uri id.ContentURI) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(f.uploads[uri]))}, nil
+}
+
+func newTestMachine(t *testing.T) *crypto.OlmMachine {
+	t.Helper()
+	mx, err := mautrix.NewClient("https://example.org", "@bot:example.org", "token")
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	mach := crypto.NewOlmMachine(mx, nil, crypto.NewMemoryStore(nil), mautrix.NewMemoryStateStore().(crypto.StateStore))
+	if err := mach.Load(context.Background()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return mach
+}
+
+func TestExportImportRoomKeys(t *testing.T) {
+	ctx := context.Background()
+	source := newTestMachine(t)
+	if _, _, err := ExportRoomKeys(ctx, source, "secret"); err == nil {
+		t.Fatal("expected export without room keys to fail")
+	}
+
+	outbound, err := crypto.NewOutboundGroupSession("!room:test", nil)
+	if err != nil {
+		t.Fatalf("NewOutboundGroupSession failed: %v", err)
+	}
+	inbound, err := crypto.NewInboundGroupSession(source.OwnIdentity().IdentityKey, source.OwnIdentity().SigningKey, "!room:test", outbound.Internal.Key(), 0, 0, false)
+	if err != nil {
+		t.Fatalf("NewInboundGroupSession failed: %v", err)
+	}
+	if err := source.CryptoStore.PutGroupSession(ctx, inbound); err != nil {
+		t.Fatalf("PutGroupSession failed: %v", err)
+	}
+
+	data, count, err := ExportRoomKeys(ctx, source, "secret")
+	if err != nil || count != 1 {
+		t.Fatalf("ExportRoomKeys() = %d, %v", count, err)
+	}
+	target := newTestMachine(t)
+	if _, _, err := ImportRoomKeys(ctx, target, "wrong", data); err == nil {
+		t.Fatal("expected import with the wrong passphrase to fail")
+	}
+	imported, total, err := ImportRoomKeys(ctx, target, "secret", data)
+	if err != nil || imported != 1 || total != 1 {
+		t.Fatalf("ImportRoomKeys() = %d, %d, %v", imported, total, err)
+	}
+}
+
+func TestSendKeyExport_EncryptsAttachmentInEncryptedRooms(t *testing.T) {
+	ctx := context.Background()
+	stateStore := mautrix.NewMemoryStateStore()
+	if err := stateStore.SetEncryptionEvent(ctx, "!dm:test", &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}); err != nil {
+		t.Fatalf("SetEncryptionEvent failed: %v", err)
+	}
+	api := &fakeAPI{}
+	media := &fakeMedia{}
+	c := &Client{api: api, media: media, crypto: &fakeCrypto{}, stateStore: stateStore}
+
+	data := []byte("-----BEGIN MEGOLM SESSION DATA-----")
+	if err := c.SendKeyExport(ctx, "!dm:test", "$cmd", data); err != nil {
+		t.Fatalf("SendKeyExport failed: %v", err)
+	}
+	content, ok := api.sentContent.(*event.MessageEventContent)
+	if !ok || content.MsgType != event.MsgFile || content.File == nil || content.URL != "" {
+		t.Fatalf("expected encrypted file message, got %#v", api.sentContent)
+	}
+	for _, upload := range media.uploads {
+		if bytes.Equal(upload, data) {
+			t.Fatal("expected uploaded bytes to be encrypted")
+		}
+	}
+
+	// The admin replies to the file; downloading it yields the plain export.
+	raw, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("marshal content: %v", err)
+	}
+	api.event = &event.Event{ID: "$file", Type: event.EventMessage, Content: event.Content{VeryRaw: raw}}
+	got, err := c.DownloadFile(ctx, "!dm:test", "$file", int64(len(data)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("DownloadFile() = %q, %v", got, err)
+	}
+}
+
+func TestDownloadFile_StopsAtLimit(t *testing.T) {
+	ctx := context.Background()
+	uri := id.ContentURI{Homeserver: "example.org", FileID: "file"}
+	media := &fakeMedia{uploads: map[id.ContentURI][]byte{uri: bytes.Repeat([]byte("x"), 11)}}
+	raw, err := json.Marshal(event.MessageEventContent{MsgType: event.MsgFile, Body: "notes.txt", URL: uri.CUString()})
+	if err != nil {
+		t.Fatalf("marshal content: %v", err)
+	}
+	// The sender claims nothing about the size; only the bytes read count.
+	api := &fakeAPI{event: &event.Event{ID: "$file", Type: event.EventMessage, Content: event.Content{VeryRaw: raw}}}
+	c := &Client{api: api, media: media}
+
+	if _, err := c.DownloadFile(ctx, "!room:test", "$file", 10); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("DownloadFile() error = %v, want ErrFileTooLarge", err)
+	}
+	got, err := c.DownloadFile(ctx, "!room:test", "$file", 11)
+	if err != nil || len(got) != 11 {
+		t.Fatalf("DownloadFile() = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestSendFile_CaptionInUnencryptedRoom(t *testing.T) {
+	api := &fakeAPI{}
+	media := &fakeMedia{}
+	c := &Client{api: api, media: media}
+
+	eventID, err := c.SendFile(context.Background(), "!room:test", File{
+		Name:             "digest.md",
+		MimeType:         "text/markdown",
+		Data:             []byte("# Digest"),
+		Caption:          "Digest attached",
+		FormattedCaption: "<b>Digest</b> attached",
+	})
+	if err != nil || eventID != "$reply" {
+		t.Fatalf("SendFile() = %q, %v", eventID, err)
+	}
+	content, ok := api.sentContent.(*event.MessageEventContent)
+	if !ok || content.MsgType != event.MsgFile || content.File != nil || content.URL == "" {
+		t.Fatalf("expected plain file message, got %#v", api.sentContent)
+	}
+	if content.FileName != "digest.md" || content.Body != "Digest attached" || content.FormattedBody != "<b>Digest</b> attached" {
+		t.Fatalf("unexpected file name or caption: %#v", content)
+	}
+	if len(media.types) != 1 || media.types[0] != "text/markdown" {
+		t.Fatalf("upload content types = %q", media.types)
+	}
+}
+
+func TestIsDirectChat(t *testing.T) {
+	api := &fakeAPI{joined: []id.UserID{"@admin:test", "@bot:test"}}
+	c := &Client{api: api, botUserID: "@bot:test"}
+	if ok, err := c.IsDirectChat(context.Background(), "!dm:test", "@admin:test"); err != nil || !ok {
+		t.Fatalf("IsDirectChat() = %v, %v; want true", ok, err)
+	}
+	api.joined = append(api.joined, "@eve:test")
+	if ok, _ := c.IsDirectChat(context.Background(), "!dm:test", "@admin:test"); ok {
+		t.Fatal("expected a room with a third member not to count as a direct chat")
+	}
+}
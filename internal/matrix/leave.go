@@ -0,0 +1,123 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// leaveReason is the reason given when leaving or rejecting a room that is
+// not allowed.
+const leaveReason = "room is not allowed"
+
+// disallowedRooms leaves rooms outside the room policy.
+type disallowedRooms struct {
+	// message, when set, is posted before leaving.
+	message string
+	mu      sync.Mutex
+	left    map[id.RoomID]struct{}
+}
+
+// SetLeaveDisallowedRooms makes the bot leave rooms the room policy does not
+// allow instead of staying in them and ignoring them: those it is in when
+// Start is called and those it is joined to later. message, when set, is
+// posted in the room first. The bot's direct chats are never left.
+func (c *Client) SetLeaveDisallowedRooms(enabled bool, message string) {
+	if !enabled {
+		c.disallowed = nil
+		return
+	}
+	c.disallowed = &disallowedRooms{message: strings.TrimSpace(message), left: make(map[id.RoomID]struct{})}
+}
+
+// leaveDisallowedRooms leaves every joined room the room policy does not
+// allow.
+func (c *Client) leaveDisallowedRooms(ctx context.Context) {
+	if c.disallowed == nil || c.roomPolicy == nil || c.membership == nil {
+		return
+	}
+	resp, err := c.membership.JoinedRooms(ctx)
+	if err != nil {
+		c.logf("list joined rooms failed err=%v", err)
+		return
+	}
+	for _, roomID := range resp.JoinedRooms {
+		if !c.roomAllowed(ctx, roomID) {
+			c.leaveDisallowed(ctx, roomID)
+		}
+	}
+}
+
+// leaveDisallowed leaves a room that is not allowed, once, after posting the
+// configured message. It does nothing unless SetLeaveDisallowedRooms enabled
+// it.
+func (c *Client) leaveDisallowed(ctx context.Context, roomID id.RoomID) {
+	if c.disallowed == nil || c.membership == nil {
+		return
+	}
+	direct, err := c.isDirectChat(ctx, roomID)
+	if err != nil {
+		c.logf("direct chat lookup failed, staying room=%s err=%v", roomID, err)
+		return
+	}
+	if direct {
+		return
+	}
+	c.disallowed.mu.Lock()
+	if _, ok := c.disallowed.left[roomID]; ok {
+		c.disallowed.mu.Unlock()
+		return
+	}
+	c.disallowed.left[roomID] = struct{}{}
+	c.disallowed.mu.Unlock()
+
+	if c.disallowed.message != "" {
+		if _, err := c.sendMessage(ctx, Reply{RoomID: roomID, Body: c.disallowed.message}); err != nil {
+			c.logf("leave message failed room=%s err=%v", roomID, err)
+		}
+	}
+	if _, err := c.membership.LeaveRoom(ctx, roomID, &mautrix.ReqLeave{Reason: leaveReason}); err != nil {
+		c.disallowed.mu.Lock()
+		delete(c.disallowed.left, roomID)
+		c.disallowed.mu.Unlock()
+		c.logf("leave disallowed room failed room=%s err=%v", roomID, err)
+		return
+	}
+	c.logf("left disallowed room room=%s", roomID)
+}
+
+// isDirectChat reports whether roomID is one of the bot's direct chats, which
+// are outside the room policy but used for direct notices.
+func (c *Client) isDirectChat(ctx context.Context, roomID id.RoomID) (bool, error) {
+	if c.direct == nil {
+		return false, nil
+	}
+	c.dms.mu.Lock()
+	defer c.dms.mu.Unlock()
+	for _, known := range c.dms.rooms {
+		if known == roomID {
+			return true, nil
+		}
+	}
+	directs := event.DirectChatsEventContent{}
+	err := c.direct.GetAccountData(ctx, event.AccountDataDirectChats.Type, &directs)
+	if errors.Is(err, mautrix.MNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get direct chats: %w", err)
+	}
+	for _, rooms := range directs {
+		if slices.Contains(rooms, roomID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,138 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// Key prefixes of the credentials a login or token refresh stores in the
+// state DB. The user ID follows, so several accounts can share one DB.
+const (
+	accessTokenStateKey  = "matrix_access_token:"
+	deviceIDStateKey     = "matrix_device_id:"
+	refreshTokenStateKey = "matrix_refresh_token:"
+)
+
+// CredentialStore persists the access token and device ID a password login
+// returned, and tokens rotated by a refresh, so restarts reuse them instead
+// of logging in again.
+type CredentialStore interface {
+	PutBotState(ctx context.Context, key, value string) error
+	GetBotState(ctx context.Context, key string) (string, error)
+}
+
+type credentials struct {
+	AccessToken  string
+	RefreshToken string
+	DeviceID     id.DeviceID
+}
+
+// loadCredentials returns the credentials saved for userID, or none when
+// store is nil.
+func loadCredentials(ctx context.Context, store CredentialStore, userID id.UserID) (credentials, error) {
+	var creds credentials
+	if store == nil {
+		return creds, nil
+	}
+	for key, into := range map[string]*string{
+		accessTokenStateKey:  &creds.AccessToken,
+		refreshTokenStateKey: &creds.RefreshToken,
+		deviceIDStateKey:     (*string)(&creds.DeviceID),
+	} {
+		value, err := store.GetBotState(ctx, key+string(userID))
+		if err != nil {
+			return credentials{}, fmt.Errorf("load %s%s: %w", key, userID, err)
+		}
+		*into = value
+	}
+	return creds, nil
+}
+
+func saveCredentials(ctx context.Context, store CredentialStore, userID id.UserID, creds credentials) error {
+	if store == nil {
+		return nil
+	}
+	for key, value := range map[string]string{
+		accessTokenStateKey:  creds.AccessToken,
+		refreshTokenStateKey: creds.RefreshToken,
+		deviceIDStateKey:     string(creds.DeviceID),
+	} {
+		if err := store.PutBotState(ctx, key+string(userID), value); err != nil {
+			return fmt.Errorf("save %s%s: %w", key, userID, err)
+		}
+	}
+	return nil
+}
+
+// authenticate gives mx a usable access token. A configured access token
+// without a refresh token is used as is. Otherwise tokens are refreshed when
+// the homeserver rejects them, and tokens saved by an earlier run take
+// precedence over the configured ones, since those may have been rotated.
+// Without an access token the bot logs in with cfg.Password.
+func authenticate(ctx context.Context, mx *mautrix.Client, cfg Config, store CredentialStore) error {
+	saved, err := loadCredentials(ctx, store, cfg.UserID)
+	if err != nil {
+		return err
+	}
+	usePassword := cfg.AccessToken == "" && cfg.Password != ""
+	refreshToken := cfg.RefreshToken
+	if saved.RefreshToken != "" {
+		refreshToken = saved.RefreshToken
+	}
+	if refreshToken == "" && !usePassword {
+		return nil
+	}
+
+	refresher := installRefresher(mx, cfg.OIDC, store, refreshToken)
+	if saved.DeviceID != "" {
+		mx.DeviceID = saved.DeviceID
+	}
+	if saved.AccessToken != "" {
+		mx.AccessToken = saved.AccessToken
+	}
+	if usePassword {
+		return passwordLogin(ctx, mx, cfg, refresher)
+	}
+	if mx.AccessToken == "" {
+		if _, err := refresher.refresh(ctx, ""); err != nil {
+			return fmt.Errorf("get access token: %w", err)
+		}
+	}
+	return nil
+}
+
+// passwordLogin reuses the saved access token while the homeserver accepts
+// it, possibly after a refresh. Otherwise the bot logs in with cfg.Password,
+// keeping its device, and saves the new credentials.
+func passwordLogin(ctx context.Context, mx *mautrix.Client, cfg Config, refresher *tokenRefresher) error {
+	if mx.AccessToken != "" {
+		_, err := mx.Whoami(ctx)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, mautrix.MUnknownToken) {
+			return fmt.Errorf("check saved access token: %w", err)
+		}
+		mx.AccessToken = ""
+	}
+
+	resp, err := mx.Login(ctx, &mautrix.ReqLogin{
+		Type:         mautrix.AuthTypePassword,
+		Identifier:   mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: string(cfg.UserID)},
+		Password:     cfg.Password,
+		DeviceID:     mx.DeviceID,
+		RefreshToken: true,
+	})
+	if err != nil {
+		return fmt.Errorf("password login: %w", err)
+	}
+	return refresher.update(ctx, credentials{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		DeviceID:     resp.DeviceID,
+	})
+}
@@ -0,0 +1,121 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildMautrixClient_PasswordLogin(t *testing.T) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v3/account/whoami":
+			if r.Header.Get("Authorization") != "Bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"revoked"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"user_id":"@bot:test","device_id":"DEVICE"}`))
+		case "/_matrix/client/v3/login":
+			logins++
+			var req struct {
+				Password string `json:"password"`
+				DeviceID string `json:"device_id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req.Password != "secret" || req.DeviceID != "DEVICE" {
+				t.Errorf("unexpected login request: %+v", req)
+			}
+			_, _ = w.Write([]byte(`{"user_id":"@bot:test","access_token":"fresh","device_id":"DEVICE"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	creds := memoryState{accessTokenStateKey + "@bot:test": "revoked", deviceIDStateKey + "@bot:test": "DEVICE"}
+	cfg := Config{HomeserverURL: server.URL, UserID: "@bot:test", Password: "secret"}
+	mx, err := BuildMautrixClient(context.Background(), cfg, Stores{Credentials: creds})
+	if err != nil {
+		t.Fatalf("BuildMautrixClient() error = %v", err)
+	}
+	if mx.AccessToken != "fresh" || mx.DeviceID != "DEVICE" || creds[accessTokenStateKey+"@bot:test"] != "fresh" {
+		t.Fatalf("expected a fresh login to be used and saved, got token=%q device=%q saved=%v", mx.AccessToken, mx.DeviceID, creds)
+	}
+
+	if _, err := BuildMautrixClient(context.Background(), cfg, Stores{Credentials: creds}); err != nil {
+		t.Fatalf("BuildMautrixClient() error = %v", err)
+	}
+	if logins != 1 {
+		t.Fatalf("expected the saved token to be reused on restart, got %d logins", logins)
+	}
+}
+
+func TestBuildMautrixClient_RefreshesExpiredTokens(t *testing.T) {
+	for _, oidc := range []bool{false, true} {
+		refreshes := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/_matrix/client/v3/account/whoami":
+				if r.Header.Get("Authorization") != "Bearer new" {
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"expired","soft_logout":true}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"user_id":"@bot:test"}`))
+			case "/_matrix/client/v3/refresh":
+				refreshes++
+				var req struct {
+					RefreshToken string `json:"refresh_token"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&req)
+				if oidc || req.RefreshToken != "r1" {
+					t.Errorf("unexpected Matrix refresh oidc=%v token=%q", oidc, req.RefreshToken)
+				}
+				_, _ = w.Write([]byte(`{"access_token":"new","refresh_token":"r2"}`))
+			case "/oauth2/token":
+				refreshes++
+				if !oidc || r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "r1" || r.FormValue("client_id") != "bot" {
+					t.Errorf("unexpected OIDC refresh oidc=%v form=%v", oidc, r.Form)
+				}
+				_, _ = w.Write([]byte(`{"access_token":"new","refresh_token":"r2","token_type":"Bearer"}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		cfg := Config{HomeserverURL: server.URL, UserID: "@bot:test", AccessToken: "old", RefreshToken: "r1"}
+		if oidc {
+			cfg.OIDC = OIDCConfig{TokenEndpoint: server.URL + "/oauth2/token", ClientID: "bot"}
+		}
+		creds := memoryState{}
+		mx, err := BuildMautrixClient(context.Background(), cfg, Stores{Credentials: creds})
+		if err != nil {
+			t.Fatalf("BuildMautrixClient() error = %v", err)
+		}
+		if _, err := mx.Whoami(context.Background()); err != nil {
+			t.Fatalf("Whoami() error = %v", err)
+		}
+		if _, err := mx.Whoami(context.Background()); err != nil {
+			t.Fatalf("Whoami() error = %v", err)
+		}
+		if refreshes != 1 || mx.AccessToken != "new" {
+			t.Fatalf("oidc=%v: expected one refresh to the new token, got %d refreshes and token %q", oidc, refreshes, mx.AccessToken)
+		}
+		if creds[accessTokenStateKey+"@bot:test"] != "new" || creds[refreshTokenStateKey+"@bot:test"] != "r2" {
+			t.Fatalf("oidc=%v: expected rotated tokens to be saved, got %v", oidc, creds)
+		}
+
+		restarted, err := BuildMautrixClient(context.Background(), cfg, Stores{Credentials: creds})
+		if err != nil {
+			t.Fatalf("BuildMautrixClient() error = %v", err)
+		}
+		if restarted.AccessToken != "new" {
+			t.Fatalf("oidc=%v: expected the saved token to win over the configured one, got %q", oidc, restarted.AccessToken)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package matrix
+
+import "maunium.net/go/mautrix/event"
+
+// ReplyKind groups the bot's messages whose message type can be configured
+// separately. The zero kind covers everything not listed below, such as
+// errors and confirmations.
+type ReplyKind string
+
+const (
+	// ReplyResults are search and watch results.
+	ReplyResults ReplyKind = "results"
+	// ReplySummary are /catchmeup summaries.
+	ReplySummary ReplyKind = "summaries"
+	// ReplyPost are standalone posts such as digests and reports.
+	ReplyPost ReplyKind = "posts"
+)
+
+// MessageTypes selects the msgtype the bot sends with: Kinds by reply kind,
+// and Default for kinds without an entry. An empty type is m.notice, which
+// clients show as coming from a bot.
+type MessageTypes struct {
+	Default event.MessageType
+	Kinds   map[ReplyKind]event.MessageType
+}
+
+// For returns the message type of a message of kind.
+func (t MessageTypes) For(kind ReplyKind) event.MessageType {
+	if msgType := t.Kinds[kind]; msgType != "" {
+		return msgType
+	}
+	if t.Default != "" {
+		return t.Default
+	}
+	return event.MsgNotice
+}
+
+// SetMessageTypes changes the message types replies and posts are sent with,
+// for clients that render notices with too little contrast.
+func (c *Client) SetMessageTypes(types MessageTypes) {
+	c.msgTypes = types
+}
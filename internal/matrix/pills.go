@@ -0,0 +1,63 @@
+package matrix
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// MentionPills renders body as HTML for formatted_body, turning every
+// occurrence of one of users into a matrix.to user pill. Clients show pills
+// with the user's avatar and display name, while body keeps the plain user
+// IDs as the fallback. It returns "" when body mentions none of users, so
+// callers can send a plain message instead.
+func MentionPills(body string, users []id.UserID) string {
+	pattern := userIDPattern(users)
+	if pattern == nil || !pattern.MatchString(body) {
+		return ""
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringIndex(body, -1) {
+		b.WriteString(html.EscapeString(body[last:loc[0]]))
+		userID := id.UserID(body[loc[0]:loc[1]])
+		b.WriteString(`<a href="` + html.EscapeString(userID.URI().MatrixToURL()) + `">` + html.EscapeString(string(userID)) + `</a>`)
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(body[last:]))
+	return strings.ReplaceAll(b.String(), "\n", "<br>")
+}
+
+// Senders returns the distinct senders of messages in order of first
+// appearance.
+func Senders(messages []RoomMessage) []id.UserID {
+	seen := make(map[id.UserID]struct{}, len(messages))
+	var out []id.UserID
+	for _, msg := range messages {
+		if _, ok := seen[msg.Sender]; ok || msg.Sender == "" {
+			continue
+		}
+		seen[msg.Sender] = struct{}{}
+		out = append(out, msg.Sender)
+	}
+	return out
+}
+
+// userIDPattern matches any of users, preferring the longest ID where one is
+// a prefix of another.
+func userIDPattern(users []id.UserID) *regexp.Regexp {
+	quoted := make([]string, 0, len(users))
+	for _, userID := range users {
+		if strings.HasPrefix(string(userID), "@") && strings.Contains(string(userID), ":") {
+			quoted = append(quoted, regexp.QuoteMeta(string(userID)))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile(strings.Join(quoted, "|"))
+}
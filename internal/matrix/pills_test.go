@@ -0,0 +1,42 @@
+package matrix
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestMentionPills(t *testing.T) {
+	users := []id.UserID{"@al:test", "@al:test.org", "@bob:test"}
+	got := MentionPills("- Release <notes> by @al:test.org\n- Review with @bob:test", users)
+	want := `- Release &lt;notes&gt; by <a href="https://matrix.to/#/@al:test.org">@al:test.org</a><br>` +
+		`- Review with <a href="https://matrix.to/#/@bob:test">@bob:test</a>`
+	if got != want {
+		t.Fatalf("unexpected formatted body:\n got %q\nwant %q", got, want)
+	}
+	if got := MentionPills("- Nobody mentioned", users); got != "" {
+		t.Fatalf("expected no formatted body without mentions, got %q", got)
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+	users := []id.UserID{"@al:test"}
+	got := FormatHTML("Release <notes>:\n- see https://go.dev/doc. (by @al:test)\n* https://example.org/a_(b)\nThanks, @al:test!", users)
+	want := `Release &lt;notes&gt;:<ul>` +
+		`<li>see <a href="https://go.dev/doc">https://go.dev/doc</a>. (by <a href="https://matrix.to/#/@al:test">@al:test</a>)</li>` +
+		`<li><a href="https://example.org/a_(b)">https://example.org/a_(b)</a></li></ul>` +
+		`Thanks, <a href="https://matrix.to/#/@al:test">@al:test</a>!`
+	if got != want {
+		t.Fatalf("unexpected formatted body:\n got %q\nwant %q", got, want)
+	}
+	if got, want := FormatHTML("one\n\ntwo", nil), "one<br><br>two"; got != want {
+		t.Fatalf("FormatHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestSenders(t *testing.T) {
+	got := Senders([]RoomMessage{{Sender: "@b:test"}, {Sender: "@a:test"}, {Sender: "@b:test"}, {}})
+	if len(got) != 2 || got[0] != "@b:test" || got[1] != "@a:test" {
+		t.Fatalf("unexpected senders: %v", got)
+	}
+}
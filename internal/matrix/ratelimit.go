@@ -0,0 +1,133 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// Rate-limited requests are retried this many times before giving up.
+	maxRateLimitRetries = 5
+	// Used when the homeserver rejects a request without retry_after_ms.
+	defaultRateLimitWait = time.Second
+	// Caps retry_after_ms so a misbehaving server cannot park a handler.
+	maxRateLimitWait = 30 * time.Second
+)
+
+// rateLimitedAPI retries requests the homeserver rejects with
+// M_LIMIT_EXCEEDED, waiting as long as the server asks. Sync is left to
+// mautrix, which already backs off between failed syncs.
+type rateLimitedAPI struct {
+	matrixAPI
+	logger Logger
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimitedAPI(api matrixAPI, logger Logger) *rateLimitedAPI {
+	return &rateLimitedAPI{matrixAPI: api, logger: logger, sleep: sleepContext}
+}
+
+func (a *rateLimitedAPI) SendMessageEvent(
+	ctx context.Context,
+	roomID id.RoomID,
+	eventType event.Type,
+	contentJSON any,
+	extra ...mautrix.ReqSendEvent,
+) (*mautrix.RespSendEvent, error) {
+	return retryRateLimited(ctx, a, "send "+eventType.Type, func() (*mautrix.RespSendEvent, error) {
+		return a.matrixAPI.SendMessageEvent(ctx, roomID, eventType, contentJSON, extra...)
+	})
+}
+
+func (a *rateLimitedAPI) StateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, outContent interface{}) error {
+	_, err := retryRateLimited(ctx, a, "get state "+eventType.Type, func() (struct{}, error) {
+		return struct{}{}, a.matrixAPI.StateEvent(ctx, roomID, eventType, stateKey, outContent)
+	})
+	return err
+}
+
+func (a *rateLimitedAPI) FullStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string) (*event.Event, error) {
+	return retryRateLimited(ctx, a, "get state "+eventType.Type, func() (*event.Event, error) {
+		return a.matrixAPI.FullStateEvent(ctx, roomID, eventType, stateKey)
+	})
+}
+
+func (a *rateLimitedAPI) SendStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON any, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error) {
+	return retryRateLimited(ctx, a, "send state "+eventType.Type, func() (*mautrix.RespSendEvent, error) {
+		return a.matrixAPI.SendStateEvent(ctx, roomID, eventType, stateKey, contentJSON, extra...)
+	})
+}
+
+func (a *rateLimitedAPI) JoinedMembers(ctx context.Context, roomID id.RoomID) (*mautrix.RespJoinedMembers, error) {
+	return retryRateLimited(ctx, a, "get joined members", func() (*mautrix.RespJoinedMembers, error) {
+		return a.matrixAPI.JoinedMembers(ctx, roomID)
+	})
+}
+
+func (a *rateLimitedAPI) Messages(ctx context.Context, roomID id.RoomID, from, to string, dir mautrix.Direction, filter *mautrix.FilterPart, limit int) (*mautrix.RespMessages, error) {
+	return retryRateLimited(ctx, a, "get messages", func() (*mautrix.RespMessages, error) {
+		return a.matrixAPI.Messages(ctx, roomID, from, to, dir, filter, limit)
+	})
+}
+
+func (a *rateLimitedAPI) GetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	return retryRateLimited(ctx, a, "get event", func() (*event.Event, error) {
+		return a.matrixAPI.GetEvent(ctx, roomID, eventID)
+	})
+}
+
+func retryRateLimited[T any](ctx context.Context, a *rateLimitedAPI, op string, call func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := call()
+		wait, limited := rateLimitWait(err)
+		if !limited || attempt >= maxRateLimitRetries {
+			return res, err
+		}
+		if a.logger != nil {
+			a.logger.Printf("matrix rate limited op=%q retry_in=%s attempt=%d", op, wait, attempt+1)
+		}
+		if err := a.sleep(ctx, wait); err != nil {
+			return res, err
+		}
+	}
+}
+
+// rateLimitWait reports whether err is a homeserver rate limit and how long
+// the server asked clients to wait.
+func rateLimitWait(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	var respErr mautrix.RespError
+	if errors.As(err, &respErr) && (respErr.ErrCode == mautrix.MLimitExceeded.ErrCode || respErr.StatusCode == http.StatusTooManyRequests) {
+		if ms, ok := respErr.ExtraData["retry_after_ms"].(float64); ok && ms > 0 {
+			if wait := time.Duration(ms) * time.Millisecond; wait < maxRateLimitWait {
+				return wait, true
+			}
+			return maxRateLimitWait, true
+		}
+		return defaultRateLimitWait, true
+	}
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.IsStatus(http.StatusTooManyRequests) {
+		return defaultRateLimitWait, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
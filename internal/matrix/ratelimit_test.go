@@ -0,0 +1,111 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type limitedSendAPI struct {
+	matrixAPI
+	errs  []error
+	calls int
+}
+
+func (a *limitedSendAPI) SendMessageEvent(context.Context, id.RoomID, event.Type, any, ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error) {
+	a.calls++
+	if len(a.errs) > 0 {
+		err := a.errs[0]
+		a.errs = a.errs[1:]
+		return nil, err
+	}
+	return &mautrix.RespSendEvent{EventID: "$sent"}, nil
+}
+
+func limitExceeded(extra map[string]any) error {
+	return mautrix.HTTPError{RespError: &mautrix.RespError{
+		ErrCode:    "M_LIMIT_EXCEEDED",
+		StatusCode: http.StatusTooManyRequests,
+		ExtraData:  extra,
+	}}
+}
+
+func TestRateLimitedAPI_RetriesAfterServerDelay(t *testing.T) {
+	inner := &limitedSendAPI{errs: []error{
+		limitExceeded(map[string]any{"retry_after_ms": float64(1500)}),
+		limitExceeded(nil),
+	}}
+	var waits []time.Duration
+	api := newRateLimitedAPI(inner, nil)
+	api.sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	resp, err := api.SendMessageEvent(context.Background(), "!room:example.org", event.EventMessage, nil)
+	if err != nil {
+		t.Fatalf("SendMessageEvent() error = %v", err)
+	}
+	if resp.EventID != "$sent" || inner.calls != 3 {
+		t.Fatalf("resp = %#v calls = %d, want success on third call", resp, inner.calls)
+	}
+	if len(waits) != 2 || waits[0] != 1500*time.Millisecond || waits[1] != defaultRateLimitWait {
+		t.Fatalf("waits = %v", waits)
+	}
+}
+
+func TestRateLimitedAPI_GivesUp(t *testing.T) {
+	errs := make([]error, maxRateLimitRetries+1)
+	for i := range errs {
+		errs[i] = limitExceeded(map[string]any{"retry_after_ms": float64(10 * time.Minute / time.Millisecond)})
+	}
+	inner := &limitedSendAPI{errs: errs}
+	api := newRateLimitedAPI(inner, nil)
+	api.sleep = func(_ context.Context, d time.Duration) error {
+		if d != maxRateLimitWait {
+			t.Fatalf("wait = %s, want capped at %s", d, maxRateLimitWait)
+		}
+		return nil
+	}
+
+	_, err := api.SendMessageEvent(context.Background(), "!room:example.org", event.EventMessage, nil)
+	if !errors.Is(err, mautrix.MLimitExceeded) {
+		t.Fatalf("SendMessageEvent() error = %v, want M_LIMIT_EXCEEDED", err)
+	}
+	if inner.calls != maxRateLimitRetries+1 {
+		t.Fatalf("calls = %d, want %d", inner.calls, maxRateLimitRetries+1)
+	}
+}
+
+func TestRateLimitedAPI_DoesNotRetryOtherErrors(t *testing.T) {
+	inner := &limitedSendAPI{errs: []error{mautrix.MForbidden}}
+	api := newRateLimitedAPI(inner, nil)
+	api.sleep = func(context.Context, time.Duration) error {
+		t.Fatal("unexpected retry")
+		return nil
+	}
+
+	if _, err := api.SendMessageEvent(context.Background(), "!room:example.org", event.EventMessage, nil); !errors.Is(err, mautrix.MForbidden) {
+		t.Fatalf("SendMessageEvent() error = %v, want M_FORBIDDEN", err)
+	}
+	if inner.calls != 1 {
+		t.Fatalf("calls = %d, want 1", inner.calls)
+	}
+}
+
+func TestRateLimitedAPI_StopsWhenContextDone(t *testing.T) {
+	inner := &limitedSendAPI{errs: []error{limitExceeded(nil)}}
+	api := newRateLimitedAPI(inner, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := api.SendMessageEvent(ctx, "!room:example.org", event.EventMessage, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SendMessageEvent() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,180 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix"
+)
+
+// maxErrorBody bounds how much of a 401 response is read to look for
+// M_UNKNOWN_TOKEN.
+const maxErrorBody = 64 << 10
+
+// OIDCConfig points token refreshes at the OAuth 2.0 token endpoint of a
+// homeserver using OIDC-native authentication (MSC3861) instead of the
+// Matrix /refresh endpoint.
+type OIDCConfig struct {
+	TokenEndpoint string
+	ClientID      string
+}
+
+// tokenRefresher is an http.RoundTripper that refreshes the access token when
+// the homeserver rejects it with M_UNKNOWN_TOKEN, saves the rotated tokens,
+// and retries the request once with the new token.
+type tokenRefresher struct {
+	mx    *mautrix.Client
+	base  http.RoundTripper
+	oidc  OIDCConfig
+	store CredentialStore
+
+	mu           sync.Mutex
+	refreshToken string
+}
+
+// installRefresher wraps the transport of mx with a tokenRefresher.
+func installRefresher(mx *mautrix.Client, oidc OIDCConfig, store CredentialStore, refreshToken string) *tokenRefresher {
+	if mx.Client == nil {
+		mx.Client = &http.Client{}
+	}
+	base := mx.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r := &tokenRefresher{mx: mx, base: base, oidc: oidc, store: store, refreshToken: refreshToken}
+	mx.Client.Transport = r
+	return r
+}
+
+func (r *tokenRefresher) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || !unknownToken(body) {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	stale := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	token, err := r.refresh(req.Context(), stale)
+	if err != nil {
+		r.mx.Log.Err(err).Msg("Failed to refresh access token")
+	}
+	if token == "" {
+		return resp, nil
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return r.base.RoundTrip(retry)
+}
+
+func unknownToken(body []byte) bool {
+	var resp mautrix.RespError
+	return json.Unmarshal(body, &resp) == nil && resp.ErrCode == mautrix.MUnknownToken.ErrCode
+}
+
+// refresh returns a fresh access token to replace stale. When another request
+// already refreshed it, the current token is returned without refreshing
+// again. The new token is returned even if saving it failed, since the old
+// refresh token may no longer be valid.
+func (r *tokenRefresher) refresh(ctx context.Context, stale string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current := r.mx.AccessToken; current != "" && current != stale {
+		return current, nil
+	}
+	if r.refreshToken == "" {
+		return "", errors.New("no refresh token")
+	}
+	creds, err := r.requestTokens(ctx)
+	if err != nil {
+		return "", err
+	}
+	if creds.RefreshToken == "" {
+		creds.RefreshToken = r.refreshToken
+	}
+	creds.DeviceID = r.mx.DeviceID
+	r.apply(creds)
+	return creds.AccessToken, saveCredentials(ctx, r.store, r.mx.UserID, creds)
+}
+
+// update replaces the tokens after a login and saves them.
+func (r *tokenRefresher) update(ctx context.Context, creds credentials) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apply(creds)
+	return saveCredentials(ctx, r.store, r.mx.UserID, creds)
+}
+
+func (r *tokenRefresher) apply(creds credentials) {
+	r.refreshToken = creds.RefreshToken
+	r.mx.AccessToken = creds.AccessToken
+	r.mx.DeviceID = creds.DeviceID
+}
+
+// requestTokens exchanges the refresh token at the OIDC token endpoint when
+// one is configured and at the Matrix /refresh endpoint otherwise. Both
+// answer with access_token and an optional rotated refresh_token.
+func (r *tokenRefresher) requestTokens(ctx context.Context) (credentials, error) {
+	var req *http.Request
+	var err error
+	if r.oidc.TokenEndpoint != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {r.refreshToken},
+			"client_id":     {r.oidc.ClientID},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, r.oidc.TokenEndpoint, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		payload, _ := json.Marshal(map[string]string{"refresh_token": r.refreshToken})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, r.mx.BuildClientURL("v3", "refresh"), bytes.NewReader(payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return credentials{}, fmt.Errorf("build refresh request: %w", err)
+	}
+
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return credentials{}, fmt.Errorf("refresh access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return credentials{}, fmt.Errorf("refresh access token: unexpected status %s", resp.Status)
+	}
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&tokens); err != nil {
+		return credentials{}, fmt.Errorf("decode refreshed tokens: %w", err)
+	}
+	if tokens.AccessToken == "" {
+		return credentials{}, errors.New("refresh access token: response has no access_token")
+	}
+	return credentials{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
@@ -0,0 +1,73 @@
+package matrix
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// SenderPolicy decides whose messages and reactions reach the handler.
+type SenderPolicy interface {
+	Allowed(sender id.UserID) bool
+}
+
+// SenderRules allows senders matching any allowed pattern, or everyone when
+// there are none, unless they match a blocked pattern. Patterns are user IDs
+// in which "*" matches any run of characters, such as "@*:example.org".
+type SenderRules struct {
+	allowed []string
+	blocked []string
+}
+
+func NewSenderRules(allowed, blocked []string) (*SenderRules, error) {
+	rules := &SenderRules{}
+	for _, list := range []struct {
+		name     string
+		patterns []string
+		into     *[]string
+	}{
+		{"allowed", allowed, &rules.allowed},
+		{"blocked", blocked, &rules.blocked},
+	} {
+		for i, pattern := range list.patterns {
+			pattern = strings.TrimSpace(pattern)
+			if err := validateSenderPattern(pattern); err != nil {
+				return nil, fmt.Errorf("%s user at index %d: %w", list.name, i, err)
+			}
+			*list.into = append(*list.into, pattern)
+		}
+	}
+	return rules, nil
+}
+
+// validateSenderPattern checks that pattern looks like a user ID, possibly
+// with "*" wildcards.
+func validateSenderPattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "@") || !strings.Contains(pattern, ":") {
+		return fmt.Errorf("%q must look like @user:server, with '*' as a wildcard", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%q: %w", pattern, err)
+	}
+	return nil
+}
+
+func (r *SenderRules) Allowed(sender id.UserID) bool {
+	if matchesSender(r.blocked, sender) {
+		return false
+	}
+	return len(r.allowed) == 0 || matchesSender(r.allowed, sender)
+}
+
+func matchesSender(patterns []string, sender id.UserID) bool {
+	for _, pattern := range patterns {
+		// User IDs never contain '/', so path.Match's '*' spans the whole
+		// localpart or server name.
+		if ok, _ := path.Match(pattern, string(sender)); ok {
+			return true
+		}
+	}
+	return false
+}
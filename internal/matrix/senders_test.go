@@ -0,0 +1,55 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestSenderRules(t *testing.T) {
+	rules, err := NewSenderRules([]string{"@*:example.org", "@carol:other.org"}, []string{"@spam*:example.org"})
+	if err != nil {
+		t.Fatalf("NewSenderRules() error = %v", err)
+	}
+	for sender, want := range map[id.UserID]bool{
+		"@alice:example.org":   true,
+		"@carol:other.org":     true,
+		"@dave:other.org":      false,
+		"@spambot:example.org": false,
+	} {
+		if got := rules.Allowed(sender); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", sender, got, want)
+		}
+	}
+
+	open, err := NewSenderRules(nil, []string{"@mallory:example.org"})
+	if err != nil {
+		t.Fatalf("NewSenderRules() error = %v", err)
+	}
+	if !open.Allowed("@anyone:other.org") || open.Allowed("@mallory:example.org") {
+		t.Fatal("expected an empty allowlist to allow everyone but blocked users")
+	}
+
+	if _, err := NewSenderRules([]string{"example.org"}, nil); err == nil {
+		t.Fatal("expected error for a pattern that is not a user ID")
+	}
+}
+
+func TestForwardIfMessage_AppliesSenderPolicy(t *testing.T) {
+	handler := &fakeHandler{}
+	c := &Client{api: &fakeAPI{}, handler: handler, botUserID: "@bot:test"}
+	rules, err := NewSenderRules(nil, []string{"@mallory:test"})
+	if err != nil {
+		t.Fatalf("NewSenderRules() error = %v", err)
+	}
+	c.SetSenderPolicy(rules)
+
+	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$1", Sender: "@mallory:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}})
+	c.forwardIfMessage(context.Background(), &event.Event{Type: event.EventMessage, RoomID: "!allowed:test", ID: "$2", Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}}})
+
+	if len(handler.msgs) != 1 || handler.msgs[0].Sender != "@alice:test" {
+		t.Fatalf("expected only alice's message to be forwarded, got %#v", handler.msgs)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,26 +19,58 @@ import (
 
 const (
 	// Split conversation buckets when two neighboring messages are farther apart than this.
-	summaryBucketGap = time.Hour
+	DefaultSummaryBucketGap = time.Hour
 	// Cap each bucket to bound prompt size and per-call output.
-	summaryBucketMaxMessages = 30
+	DefaultSummaryBucketMaxMessages = 30
+	// Cap the permalinks attached to one summary bullet.
+	maxMessageRefsPerTopic = 3
+	// summaryParam marks the URLs summaries are indexed under.
+	summaryParam      = "?summary="
+	summaryTimeFormat = "20060102T1504Z"
 )
 
+// historyFilter limits /messages to the event types history reads can use,
+// so busy rooms do not page through state changes and reactions. Encrypted
+// events are decrypted and then filtered by msgtype.
+var historyFilter = &mautrix.FilterPart{
+	Types:           []event.Type{event.EventMessage, event.EventEncrypted},
+	LazyLoadMembers: true,
+}
+
+// messageRef matches the "[n]" message numbers the summarizer is asked to
+// cite at the end of each topic.
+var messageRef = regexp.MustCompile(`\s*\[(\d+)\]`)
+
 type RoomMessage struct {
+	RoomID    id.RoomID
+	EventID   id.EventID
 	Sender    id.UserID
 	Body      string
 	Timestamp time.Time
+	// Notice is set for m.notice messages.
+	Notice bool
+}
+
+// SummaryBuckets controls how a room's history is split into the chunks
+// summarized by separate LLM calls. Zero fields use the defaults.
+type SummaryBuckets struct {
+	Gap         time.Duration
+	MaxMessages int
 }
 
 type BucketedSummarizer struct {
 	extract func(ctx context.Context, transcript string) (string, error)
+	buckets func(roomID id.RoomID) SummaryBuckets
 }
 
-func NewBucketedSummarizer(client openai.Client) *BucketedSummarizer {
+// NewBucketedSummarizer summarizes with client. buckets, if not nil, returns
+// the bucket settings for the room being summarized.
+func NewBucketedSummarizer(client openai.Client, buckets func(roomID id.RoomID) SummaryBuckets) *BucketedSummarizer {
 	return &BucketedSummarizer{
 		extract: func(ctx context.Context, transcript string) (string, error) {
 			return llm.ExtractTopicsFromChatsWithError(transcript, client, ctx)
 		},
+		buckets: buckets,
 	}
 }
 
@@ -48,11 +82,12 @@ func (s *BucketedSummarizer) Summarize(ctx context.Context, messages []RoomMessa
 		return "", nil
 	}
 
-	buckets := bucketMessagesByProximity(messages, summaryBucketGap, summaryBucketMaxMessages)
+	settings := s.bucketSettings(messages[0].RoomID)
+	buckets := bucketMessagesByProximity(messages, settings.Gap, settings.MaxMessages)
 	parts := make([]string, 0, len(buckets))
 
 	for _, bucket := range buckets {
-		transcript := formatMessagesForSummary(bucket)
+		transcript, refs := formatMessagesForSummary(bucket)
 		if strings.TrimSpace(transcript) == "" {
 			continue
 		}
@@ -60,7 +95,7 @@ func (s *BucketedSummarizer) Summarize(ctx context.Context, messages []RoomMessa
 		if err != nil {
 			return "", err
 		}
-		topics = strings.TrimSpace(topics)
+		topics = strings.TrimSpace(linkMessageRefs(topics, refs))
 		if topics != "" {
 			parts = append(parts, topics)
 		}
@@ -69,6 +104,102 @@ func (s *BucketedSummarizer) Summarize(ctx context.Context, messages []RoomMessa
 	return strings.TrimSpace(strings.Join(parts, "\n")), nil
 }
 
+func (s *BucketedSummarizer) bucketSettings(roomID id.RoomID) SummaryBuckets {
+	var settings SummaryBuckets
+	if s.buckets != nil {
+		settings = s.buckets(roomID)
+	}
+	if settings.Gap <= 0 {
+		settings.Gap = DefaultSummaryBucketGap
+	}
+	if settings.MaxMessages <= 0 {
+		settings.MaxMessages = DefaultSummaryBucketMaxMessages
+	}
+	return settings
+}
+
+// MessageSpan returns the earliest and the latest of messages.
+func MessageSpan(messages []RoomMessage) (first, last RoomMessage) {
+	for i, msg := range messages {
+		if i == 0 || msg.Timestamp.Before(first.Timestamp) {
+			first = msg
+		}
+		if i == 0 || !msg.Timestamp.Before(last.Timestamp) {
+			last = msg
+		}
+	}
+	return first, last
+}
+
+// SummaryURL is the URL a summary of messages is indexed under: the
+// matrix.to permalink of the earliest message with the summarized time range
+// appended, so it differs from the message's own permalink.
+func SummaryURL(messages []RoomMessage) string {
+	first, last := MessageSpan(messages)
+	return first.RoomID.EventURI(first.EventID).MatrixToURL() + summaryParam +
+		first.Timestamp.UTC().Format(summaryTimeFormat) + ".." + last.Timestamp.UTC().Format(summaryTimeFormat)
+}
+
+// IsSummaryURL reports whether rawURL was made by SummaryURL.
+func IsSummaryURL(rawURL string) bool {
+	return strings.Contains(rawURL, summaryParam)
+}
+
+// SummaryPositionKey is the bot_state key holding the position of the last
+// summary of any kind posted in roomID.
+func SummaryPositionKey(roomID id.RoomID) string {
+	return "summary_position:" + string(roomID)
+}
+
+// SummaryPosition is the newest message a summary covered, so the next one
+// can start right after it.
+type SummaryPosition struct {
+	EventID   id.EventID
+	Timestamp time.Time
+}
+
+// PositionAfter returns the position just after the newest of messages.
+func PositionAfter(messages []RoomMessage) SummaryPosition {
+	_, last := MessageSpan(messages)
+	return SummaryPosition{EventID: last.EventID, Timestamp: last.Timestamp}
+}
+
+// String encodes p for storage as "<unix millis> <event ID>".
+func (p SummaryPosition) String() string {
+	return strconv.FormatInt(p.Timestamp.UnixMilli(), 10) + " " + string(p.EventID)
+}
+
+// ParseSummaryPosition decodes a position encoded by String. It reports
+// false for empty or malformed values.
+func ParseSummaryPosition(value string) (SummaryPosition, bool) {
+	millis, eventID, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || eventID == "" {
+		return SummaryPosition{}, false
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return SummaryPosition{}, false
+	}
+	return SummaryPosition{EventID: id.EventID(eventID), Timestamp: time.UnixMilli(ms)}, true
+}
+
+// After returns the messages, newest first as GetRecentTextMessages returns
+// them, that came after p. History fetched since p.Timestamp still holds p's
+// message and any older ones sent in the same millisecond; they are dropped.
+func (p SummaryPosition) After(messages []RoomMessage) []RoomMessage {
+	out := make([]RoomMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.EventID == p.EventID {
+			break
+		}
+		if msg.Timestamp.Before(p.Timestamp) {
+			continue
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
 func (c *Client) GetRecentTextMessages(ctx context.Context, roomID id.RoomID, since time.Time, max int) ([]RoomMessage, error) {
 	if max <= 0 {
 		return nil, errors.New("max must be greater than zero")
@@ -83,7 +214,7 @@ func (c *Client) GetRecentTextMessages(ctx context.Context, roomID id.RoomID, si
 	}
 
 	for len(out) < max {
-		resp, err := c.api.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, nil, pageSize)
+		resp, err := c.api.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, historyFilter, pageSize)
 		if err != nil {
 			return nil, fmt.Errorf("fetch room messages: %w", err)
 		}
@@ -115,9 +246,12 @@ func (c *Client) GetRecentTextMessages(ctx context.Context, roomID id.RoomID, si
 				continue
 			}
 			out = append(out, RoomMessage{
+				RoomID:    roomID,
+				EventID:   parsed.ID,
 				Sender:    parsed.Sender,
 				Body:      body,
 				Timestamp: ts,
+				Notice:    msg.MsgType == event.MsgNotice,
 			})
 			if len(out) >= max {
 				break
@@ -137,6 +271,16 @@ func (c *Client) GetRecentTextMessages(ctx context.Context, roomID id.RoomID, si
 }
 
 func (c *Client) parseHistoryTextEvent(ctx context.Context, ev *event.Event) (*event.Event, bool) {
+	parsed, ok := c.parseHistoryMessageEvent(ctx, ev)
+	if !ok || !parsed.Content.AsMessage().MsgType.IsText() {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// parseHistoryMessageEvent decrypts ev if needed and parses it as an
+// m.room.message event of any msgtype.
+func (c *Client) parseHistoryMessageEvent(ctx context.Context, ev *event.Event) (*event.Event, bool) {
 	if ev == nil {
 		return nil, false
 	}
@@ -168,8 +312,7 @@ func (c *Client) parseHistoryTextEvent(ctx context.Context, ev *event.Event) (*e
 			return nil, false
 		}
 	}
-	msg := parsed.Content.AsMessage()
-	if msg == nil || !msg.MsgType.IsText() {
+	if parsed.Content.AsMessage() == nil {
 		return nil, false
 	}
 	return parsed, true
@@ -213,13 +356,46 @@ func bucketMessagesByProximity(messages []RoomMessage, maxGap time.Duration, max
 	return buckets
 }
 
-func formatMessagesForSummary(messages []RoomMessage) string {
+// formatMessagesForSummary renders messages as numbered transcript lines and
+// returns the messages in transcript order, so refs[n-1] is message [n].
+func formatMessagesForSummary(messages []RoomMessage) (string, []RoomMessage) {
 	lines := make([]string, 0, len(messages))
+	refs := make([]RoomMessage, 0, len(messages))
 	for _, msg := range messages {
 		if msg.Sender == "" || strings.TrimSpace(msg.Body) == "" {
 			continue
 		}
-		lines = append(lines, fmt.Sprintf("%s: %s", msg.Sender, msg.Body))
+		refs = append(refs, msg)
+		lines = append(lines, fmt.Sprintf("[%d] %s: %s", len(refs), msg.Sender, msg.Body))
+	}
+	return strings.Join(lines, "\n"), refs
+}
+
+// linkMessageRefs replaces the "[n]" message numbers cited in each line of
+// topics with matrix.to permalinks to those messages. Numbers that do not
+// match a message with a known room and event ID are dropped.
+func linkMessageRefs(topics string, refs []RoomMessage) string {
+	lines := strings.Split(topics, "\n")
+	for i, line := range lines {
+		var links []string
+		seen := make(map[id.EventID]struct{})
+		for _, m := range messageRef.FindAllStringSubmatch(line, -1) {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n < 1 || n > len(refs) || len(links) == maxMessageRefsPerTopic {
+				continue
+			}
+			ref := refs[n-1]
+			if _, ok := seen[ref.EventID]; ok || ref.RoomID == "" || ref.EventID == "" {
+				continue
+			}
+			seen[ref.EventID] = struct{}{}
+			links = append(links, ref.RoomID.EventURI(ref.EventID).MatrixToURL())
+		}
+		line = strings.TrimRight(messageRef.ReplaceAllString(line, ""), " ")
+		if len(links) > 0 {
+			line += " (" + strings.Join(links, ", ") + ")"
+		}
+		lines[i] = line
 	}
 	return strings.Join(lines, "\n")
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"testing"
 	"time"
+
+	"maunium.net/go/mautrix/id"
 )
 
 func TestBucketMessagesByProximity_SplitsByGap(t *testing.T) {
@@ -77,3 +79,78 @@ func TestBucketedSummarizer_SummarizeConcatenatesBucketOutputs(t *testing.T) {
 		t.Fatalf("unexpected summary output: %q", out)
 	}
 }
+
+func TestBucketedSummarizer_LinksCitedMessages(t *testing.T) {
+	base := time.Now().UTC()
+	msgs := []RoomMessage{
+		{RoomID: "!room:test", EventID: "$one", Sender: "@alice:test", Body: "release is out", Timestamp: base},
+		{RoomID: "!room:test", EventID: "$two", Sender: "@bob:test", Body: "changelog https://example.org", Timestamp: base.Add(time.Minute)},
+	}
+
+	var transcript string
+	s := &BucketedSummarizer{
+		extract: func(_ context.Context, got string) (string, error) {
+			transcript = got
+			return "- New release [1] [2]\n- Unrelated chatter [7]", nil
+		},
+	}
+
+	out, err := s.Summarize(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if transcript != "[1] @alice:test: release is out\n[2] @bob:test: changelog https://example.org" {
+		t.Fatalf("unexpected transcript: %q", transcript)
+	}
+	want := "- New release (https://matrix.to/#/%21room:test/$one, https://matrix.to/#/%21room:test/$two)\n- Unrelated chatter"
+	if out != want {
+		t.Fatalf("unexpected summary:\n got %q\nwant %q", out, want)
+	}
+}
+
+func TestBucketedSummarizer_UsesRoomBucketSettings(t *testing.T) {
+	base := time.Now().UTC()
+	msgs := []RoomMessage{
+		{RoomID: "!busy:test", Sender: "@alice:test", Body: "one", Timestamp: base},
+		{RoomID: "!busy:test", Sender: "@bob:test", Body: "two", Timestamp: base.Add(10 * time.Minute)},
+		{RoomID: "!busy:test", Sender: "@carol:test", Body: "three", Timestamp: base.Add(20 * time.Minute)},
+	}
+
+	var rooms []id.RoomID
+	calls := 0
+	s := &BucketedSummarizer{
+		extract: func(context.Context, string) (string, error) {
+			calls++
+			return "- topic", nil
+		},
+		buckets: func(roomID id.RoomID) SummaryBuckets {
+			rooms = append(rooms, roomID)
+			return SummaryBuckets{Gap: 5 * time.Minute}
+		},
+	}
+
+	if _, err := s.Summarize(context.Background(), msgs); err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected a 5 minute gap to split every message, got %d extractor calls", calls)
+	}
+	if len(rooms) != 1 || rooms[0] != "!busy:test" {
+		t.Fatalf("unexpected bucket lookups: %v", rooms)
+	}
+}
+
+func TestSummaryURL(t *testing.T) {
+	base := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	messages := []RoomMessage{
+		{RoomID: "!room:test", EventID: "$late", Timestamp: base.Add(2 * time.Hour)},
+		{RoomID: "!room:test", EventID: "$early", Timestamp: base},
+	}
+	got := SummaryURL(messages)
+	if want := "https://matrix.to/#/%21room:test/$early?summary=20260301T0930Z..20260301T1130Z"; got != want {
+		t.Fatalf("SummaryURL() = %q, want %q", got, want)
+	}
+	if !IsSummaryURL(got) || IsSummaryURL("https://matrix.to/#/%21room:test/$early") {
+		t.Fatal("IsSummaryURL() does not tell summaries from message permalinks")
+	}
+}
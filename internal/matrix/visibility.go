@@ -0,0 +1,58 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// HistoryAccess describes which room history a member may read.
+type HistoryAccess struct {
+	// Visibility is the room's m.room.history_visibility, "shared" when unset.
+	Visibility event.HistoryVisibility
+	// Joined reports whether the user is currently joined.
+	Joined bool
+	// MemberSince is the time of the user's current membership event. A
+	// profile change also updates it, so it may be later than the real join.
+	MemberSince time.Time
+}
+
+// Restricted reports whether members only see history from their own
+// membership on.
+func (a HistoryAccess) Restricted() bool {
+	return a.Visibility == event.HistoryVisibilityJoined || a.Visibility == event.HistoryVisibilityInvited
+}
+
+// HistoryAccess looks up roomID's history visibility and userID's membership.
+func (c *Client) HistoryAccess(ctx context.Context, roomID id.RoomID, userID id.UserID) (HistoryAccess, error) {
+	access := HistoryAccess{Visibility: event.HistoryVisibilityShared}
+	var visibility event.HistoryVisibilityEventContent
+	err := c.api.StateEvent(ctx, roomID, event.StateHistoryVisibility, "", &visibility)
+	switch {
+	case err == nil:
+		if visibility.HistoryVisibility != "" {
+			access.Visibility = visibility.HistoryVisibility
+		}
+	case !errors.Is(err, mautrix.MNotFound):
+		return HistoryAccess{}, fmt.Errorf("fetch history visibility: %w", err)
+	}
+
+	member, err := c.api.FullStateEvent(ctx, roomID, event.StateMember, string(userID))
+	if errors.Is(err, mautrix.MNotFound) {
+		return access, nil
+	}
+	if err != nil {
+		return HistoryAccess{}, fmt.Errorf("fetch membership: %w", err)
+	}
+	if err := member.Content.ParseRaw(member.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return HistoryAccess{}, fmt.Errorf("parse membership: %w", err)
+	}
+	access.Joined = member.Content.AsMember().Membership == event.MembershipJoin
+	access.MemberSince = time.UnixMilli(member.Timestamp)
+	return access, nil
+}
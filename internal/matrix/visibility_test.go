@@ -0,0 +1,38 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestHistoryAccess(t *testing.T) {
+	api := &fakeAPI{}
+	c := &Client{api: api, botUserID: "@bot:test"}
+
+	access, err := c.HistoryAccess(context.Background(), "!room:test", "@alice:test")
+	if err != nil {
+		t.Fatalf("HistoryAccess() error = %v", err)
+	}
+	if access.Visibility != event.HistoryVisibilityShared || access.Restricted() || access.Joined {
+		t.Fatalf("access = %+v, want shared and not joined", access)
+	}
+
+	joinedAt := time.UnixMilli(1_700_000_000_000)
+	api.visibility = event.HistoryVisibilityJoined
+	api.member = &event.Event{
+		Type:      event.StateMember,
+		Timestamp: joinedAt.UnixMilli(),
+		Content:   event.Content{VeryRaw: json.RawMessage(`{"membership":"join"}`)},
+	}
+	access, err = c.HistoryAccess(context.Background(), "!room:test", "@alice:test")
+	if err != nil {
+		t.Fatalf("HistoryAccess() error = %v", err)
+	}
+	if !access.Restricted() || !access.Joined || !access.MemberSince.Equal(joinedAt) {
+		t.Fatalf("access = %+v, want restricted, joined at %v", access, joinedAt)
+	}
+}
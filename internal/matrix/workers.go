@@ -0,0 +1,76 @@
+package matrix
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/recovery"
+	"maunium.net/go/mautrix/id"
+)
+
+// handlerPool runs event handlers off the sync loop. Each room is assigned
+// to one worker, so events of a room are handled in the order they arrived
+// while rooms do not wait for each other.
+type handlerPool struct {
+	queues []chan func()
+	panics *recovery.Guard
+	wg     sync.WaitGroup
+
+	// mu keeps close from closing a queue while a job is submitted to it.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newHandlerPool(workers, queueSize int, panics *recovery.Guard) *handlerPool {
+	p := &handlerPool{queues: make([]chan func(), workers), panics: panics}
+	for i := range p.queues {
+		queue := make(chan func(), queueSize)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range queue {
+				p.run(job)
+			}
+		}()
+	}
+	return p
+}
+
+func (p *handlerPool) run(job func()) {
+	defer p.panics.Recover("matrix handler worker")
+	job()
+}
+
+// submit queues job on the worker of roomID. It blocks while that worker's
+// queue is full, which holds back the sync loop instead of buffering without
+// bound. After close, job runs on the caller's goroutine.
+func (p *handlerPool) submit(roomID id.RoomID, job func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		job()
+		return
+	}
+	p.queues[shard(roomID, len(p.queues))] <- job
+}
+
+// shard picks the worker of roomID among n.
+func shard(roomID id.RoomID, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(roomID))
+	return int(h.Sum32() % uint32(n))
+}
+
+// close stops accepting jobs and waits for the queued ones to finish.
+func (p *handlerPool) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
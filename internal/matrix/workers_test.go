@@ -0,0 +1,96 @@
+package matrix
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestSetHandlerWorkers_OrdersRoomsAndDrainsOnStop(t *testing.T) {
+	var mu sync.Mutex
+	var handled []id.EventID
+	release := make(chan struct{})
+	handler := MessageHandlerFunc(func(_ context.Context, msg Message) error {
+		if msg.RoomID == "!slow:test" && msg.EventID == "$1" {
+			<-release
+		}
+		mu.Lock()
+		handled = append(handled, msg.EventID)
+		mu.Unlock()
+		return nil
+	})
+	text := func(roomID id.RoomID, eventID id.EventID) *event.Event {
+		return &event.Event{Type: event.EventMessage, RoomID: roomID, ID: eventID, Sender: "@alice:test", Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hi"}}}
+	}
+
+	api := &fakeAPI{}
+	c := &Client{api: api, handler: handler}
+	c.SetHandlerWorkers(2, 4)
+	api.syncFunc = func(ctx context.Context) error {
+		for _, eventID := range []id.EventID{"$1", "$2", "$3"} {
+			c.forwardIfMessage(ctx, text("!slow:test", eventID))
+		}
+		c.forwardIfMessage(ctx, text("!other:test", "$4"))
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Start(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("Start returned before queued handlers finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after handlers finished")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var slow []id.EventID
+	for _, eventID := range handled {
+		if eventID != "$4" {
+			slow = append(slow, eventID)
+		}
+	}
+	if len(handled) != 4 || len(slow) != 3 || slow[0] != "$1" || slow[1] != "$2" || slow[2] != "$3" {
+		t.Fatalf("expected all events handled with the slow room in order, got %v", handled)
+	}
+}
+
+func TestHandlerPool_RoomsDoNotWaitForEachOther(t *testing.T) {
+	p := newHandlerPool(8, 1, nil)
+	defer p.close()
+
+	var blocked, free id.RoomID = "!a:test", ""
+	for _, candidate := range []id.RoomID{"!b:test", "!c:test", "!d:test", "!e:test"} {
+		if shard(candidate, 8) != shard(blocked, 8) {
+			free = candidate
+			break
+		}
+	}
+	if free == "" {
+		t.Fatal("no room on another worker")
+	}
+	release := make(chan struct{})
+	defer close(release)
+	p.submit(blocked, func() { <-release })
+
+	ran := make(chan struct{})
+	p.submit(free, func() { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("a blocked room held up another room")
+	}
+}
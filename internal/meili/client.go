@@ -0,0 +1,516 @@
+// Package meili is a search backend that keeps documents in a Meilisearch
+// index, for operators who run Meilisearch instead of Hister. Pages are
+// extracted by the bot, as with Hister, and stored with where their links
+// were shared, so site, room and date filters are applied by Meilisearch.
+package meili
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+)
+
+const (
+	defaultIndex           = "links"
+	defaultTimeout         = 10 * time.Second
+	defaultRetries         = 3
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultMaxRetryBackoff = 1 * time.Second
+	// snippetWords is how many words of a document's text a result shows.
+	snippetWords = 30
+)
+
+// filterable are the document fields searches filter on.
+var filterable = []string{"sites", "room_id", "shared_at_unix"}
+
+type ClientOption func(*Client)
+
+type Client struct {
+	BaseURL string
+	// APIKey is sent as a bearer token; a key with the search, documents and
+	// settings actions of Index is enough.
+	APIKey string
+	// Index is the uid of the Meilisearch index documents are kept in.
+	Index   string
+	Timeout time.Duration
+	// Retries is how often a request failing with a network error or 5xx
+	// is retried.
+	Retries         int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	HTTPClient *http.Client
+	Extract    func(ctx context.Context, rawURL string) (extractor.Result, error)
+	// OnIndexed, when set, is called after IndexURL submits a document, with
+	// the content that was extracted for it.
+	OnIndexed func(ctx context.Context, rawURL string, content extractor.Result)
+	// Logger, when set, receives retry notices and, if it has a Debugf
+	// method, per-request debug lines.
+	Logger hister.Logger
+	setUp  atomic.Bool
+}
+
+type debugLogger interface {
+	Debugf(format string, args ...any)
+}
+
+func NewClient(baseURL string, timeout time.Duration, opts ...ClientOption) (*Client, error) {
+	c := &Client{BaseURL: baseURL, Timeout: timeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	base, err := url.Parse(strings.TrimSpace(c.BaseURL))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("meilisearch base URL must be an absolute http(s) URL, got %q", c.BaseURL)
+	}
+	c.BaseURL = strings.TrimRight(base.String(), "/")
+	if c.Index == "" {
+		c.Index = defaultIndex
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Retries == 0 {
+		c.Retries = defaultRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultRetryBackoff
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: c.Timeout}
+	}
+	if c.Extract == nil {
+		client := c.HTTPClient
+		c.Extract = func(ctx context.Context, rawURL string) (extractor.Result, error) {
+			return extractor.ExtractFromURL(ctx, client, rawURL)
+		}
+	}
+	return c, nil
+}
+
+// CloseIdleConnections closes the HTTP client's idle connections.
+func (c *Client) CloseIdleConnections() {
+	c.HTTPClient.CloseIdleConnections()
+}
+
+// Setup makes the fields searches filter on filterable. Meilisearch applies
+// settings asynchronously and creates the index if it does not exist yet.
+func (c *Client) Setup(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPatch, c.indexPath("/settings"), map[string]any{
+		"filterableAttributes": filterable,
+		"searchableAttributes": []string{"title", "text", "url"},
+	})
+	if err != nil {
+		return fmt.Errorf("meilisearch settings: %w", err)
+	}
+	c.setUp.Store(true)
+	return nil
+}
+
+// Healthy reports whether Meilisearch answers its health endpoint.
+func (c *Client) Healthy(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/health", nil)
+	return err
+}
+
+func (c *Client) IndexURL(ctx context.Context, rawURL string) error {
+	_, err := c.IndexURLContent(ctx, rawURL)
+	return err
+}
+
+// IndexURLContent is IndexURL, but also returns the content extracted for the
+// document.
+func (c *Client) IndexURLContent(ctx context.Context, rawURL string) (extractor.Result, error) {
+	return c.IndexURLFrom(ctx, rawURL, hister.Source{})
+}
+
+// IndexURLFrom is IndexURLContent, storing the document with where its link
+// was shared.
+func (c *Client) IndexURLFrom(ctx context.Context, rawURL string, source hister.Source) (extractor.Result, error) {
+	content, err := c.Extract(ctx, rawURL)
+	if err != nil {
+		return extractor.Result{}, fmt.Errorf("extract URL content: %w", err)
+	}
+	if err := c.addDocuments(ctx, []document{newDocument(rawURL, content, source)}); err != nil {
+		return extractor.Result{}, err
+	}
+	if c.OnIndexed != nil {
+		c.OnIndexed(ctx, rawURL, content)
+	}
+	return content, nil
+}
+
+// IndexURLs indexes urls with one request for all pages that could be
+// extracted. The returned errors line up with urls.
+func (c *Client) IndexURLs(ctx context.Context, urls []string) []error {
+	return c.IndexURLsFrom(ctx, urls, nil)
+}
+
+// IndexURLsFrom is IndexURLs with where each link was shared; sources may be
+// nil or line up with urls.
+func (c *Client) IndexURLsFrom(ctx context.Context, urls []string, sources []hister.Source) []error {
+	errs := make([]error, len(urls))
+	docs := make([]document, 0, len(urls))
+	contents := make([]extractor.Result, 0, len(urls))
+	added := make([]int, 0, len(urls))
+	for i, rawURL := range urls {
+		content, err := c.Extract(ctx, rawURL)
+		if err != nil {
+			errs[i] = fmt.Errorf("extract URL content: %w", err)
+			continue
+		}
+		var source hister.Source
+		if i < len(sources) {
+			source = sources[i]
+		}
+		docs = append(docs, newDocument(rawURL, content, source))
+		contents = append(contents, content)
+		added = append(added, i)
+	}
+	if len(docs) == 0 {
+		return errs
+	}
+	if err := c.addDocuments(ctx, docs); err != nil {
+		for _, i := range added {
+			errs[i] = err
+		}
+		return errs
+	}
+	if c.OnIndexed != nil {
+		for j, i := range added {
+			c.OnIndexed(ctx, urls[i], contents[j])
+		}
+	}
+	return errs
+}
+
+// IndexContent stores already extracted content for rawURL, e.g. when
+// refreshing a document whose page changed. Where its link was shared is
+// kept. OnIndexed is not called.
+func (c *Client) IndexContent(ctx context.Context, rawURL string, content extractor.Result) error {
+	return c.addDocuments(ctx, []document{newDocument(rawURL, content, hister.Source{})})
+}
+
+// addDocuments adds docs or, for URLs already indexed, updates the fields
+// they set, so a refresh does not drop a document's source.
+func (c *Client) addDocuments(ctx context.Context, docs []document) error {
+	_, err := c.do(ctx, http.MethodPut, c.indexPath("/documents?primaryKey=id"), docs)
+	if err != nil {
+		return fmt.Errorf("meilisearch add: %w", err)
+	}
+	return nil
+}
+
+// DeleteURL removes the document indexed under rawURL. A document
+// Meilisearch does not know counts as deleted.
+func (c *Client) DeleteURL(ctx context.Context, rawURL string) error {
+	_, err := c.do(ctx, http.MethodDelete, c.indexPath("/documents/"+documentID(rawURL)), nil)
+	var status *hister.StatusError
+	if errors.As(err, &status) && status.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("meilisearch delete: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]hister.SearchResult, error) {
+	page, err := c.SearchPage(ctx, query, 0, limit)
+	return page.Results, err
+}
+
+// SearchPage returns up to limit results for query after skipping the first
+// offset, with Meilisearch's estimate of the total.
+func (c *Client) SearchPage(ctx context.Context, query string, offset, limit int) (hister.Page, error) {
+	return c.SearchFiltered(ctx, query, hister.Filters{}, offset, limit)
+}
+
+// SearchFiltered is SearchPage with filters. Documents indexed without a
+// source have no room or share date, so room and date filters skip them.
+// While Meilisearch cannot filter yet, because Setup failed or its settings
+// are still being applied, the search is sent unfiltered and callers needing
+// exact results should check the results too.
+func (c *Client) SearchFiltered(ctx context.Context, query string, filters hister.Filters, offset, limit int) (hister.Page, error) {
+	expressions := filterExpressions(filters)
+	if len(expressions) > 0 && !c.setUp.Load() {
+		if err := c.Setup(ctx); err != nil {
+			c.logf("meilisearch search unfiltered: %v", err)
+			expressions = nil
+		}
+	}
+	page, err := c.search(ctx, query, expressions, offset, limit)
+	var status *hister.StatusError
+	if len(expressions) > 0 && errors.As(err, &status) && status.StatusCode == http.StatusBadRequest {
+		c.logf("meilisearch search unfiltered: %v", err)
+		return c.search(ctx, query, nil, offset, limit)
+	}
+	return page, err
+}
+
+func (c *Client) search(ctx context.Context, query string, filter []string, offset, limit int) (hister.Page, error) {
+	req := searchRequest{
+		Query:            query,
+		Offset:           offset,
+		Limit:            limit,
+		Filter:           filter,
+		AttributesToCrop: []string{"text"},
+		CropLength:       snippetWords,
+		ShowRankingScore: true,
+	}
+	body, err := c.do(ctx, http.MethodPost, c.indexPath("/search"), req)
+	if err != nil {
+		return hister.Page{}, fmt.Errorf("meilisearch search: %w", err)
+	}
+	var resp searchResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return hister.Page{}, fmt.Errorf("decode meilisearch search response: %w", err)
+	}
+	page := hister.Page{Results: make([]hister.SearchResult, 0, len(resp.Hits)), Total: resp.EstimatedTotalHits}
+	for _, hit := range resp.Hits {
+		page.Results = append(page.Results, hit.result())
+	}
+	c.debugf("meilisearch search query=%q offset=%d results=%d total=%d", query, offset, len(page.Results), page.Total)
+	return page, nil
+}
+
+func (c *Client) indexPath(path string) string {
+	return "/indexes/" + url.PathEscape(c.Index) + path
+}
+
+// do sends a JSON request and returns the response body, retrying network
+// errors and 5xx answers. Other non-2xx answers are a *hister.StatusError.
+func (c *Client) do(ctx context.Context, method, path string, payload any) ([]byte, error) {
+	var reqBody []byte
+	if payload != nil {
+		var err error
+		if reqBody, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		var respBody []byte
+		if err == nil {
+			respBody, err = io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+			_ = resp.Body.Close()
+		}
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err == nil && resp.StatusCode < 300:
+			c.debugf("meilisearch %s %s status=%d", method, path, resp.StatusCode)
+			return respBody, nil
+		case err == nil && resp.StatusCode < 500:
+			return nil, fmt.Errorf("%w: %s", &hister.StatusError{StatusCode: resp.StatusCode}, errorMessage(respBody))
+		}
+		if err == nil {
+			err = &hister.StatusError{StatusCode: resp.StatusCode}
+		}
+		if attempt >= c.Retries {
+			return nil, fmt.Errorf("failed after %d attempts: %w", attempt+1, err)
+		}
+		c.logf("meilisearch retry %s %s attempt=%d err=%v", method, path, attempt+1, err)
+		if err := sleep(ctx, c.retryDelay(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) retryDelay(attempt int) time.Duration {
+	delay := c.RetryBackoff << attempt
+	if delay <= 0 || delay > c.MaxRetryBackoff {
+		return c.MaxRetryBackoff
+	}
+	return delay
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// errorMessage returns the message of a Meilisearch error body, or the body.
+func errorMessage(body []byte) string {
+	var meiliErr struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &meiliErr) == nil && meiliErr.Message != "" {
+		return meiliErr.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, args...)
+	}
+}
+
+func (c *Client) debugf(format string, args ...any) {
+	if l, ok := c.Logger.(debugLogger); ok {
+		l.Debugf(format, args...)
+	}
+}
+
+// document is a page as stored in Meilisearch. Sites holds the page's host
+// and its parent domains, so a site filter matches subdomains, and
+// SharedAtUnix makes the share time filterable.
+type document struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	Title        string   `json:"title,omitempty"`
+	Text         string   `json:"text,omitempty"`
+	Sites        []string `json:"sites,omitempty"`
+	RoomID       string   `json:"room_id,omitempty"`
+	Sender       string   `json:"sender,omitempty"`
+	EventID      string   `json:"event_id,omitempty"`
+	SharedAt     string   `json:"shared_at,omitempty"`
+	SharedAtUnix int64    `json:"shared_at_unix,omitempty"`
+}
+
+func newDocument(rawURL string, content extractor.Result, source hister.Source) document {
+	doc := document{
+		ID:      documentID(rawURL),
+		URL:     rawURL,
+		Title:   content.Title,
+		Text:    content.Text,
+		Sites:   sites(rawURL),
+		RoomID:  source.RoomID,
+		Sender:  source.Sender,
+		EventID: source.EventID,
+	}
+	if !source.SharedAt.IsZero() {
+		doc.SharedAt = source.SharedAt.UTC().Format(time.RFC3339)
+		doc.SharedAtUnix = source.SharedAt.Unix()
+	}
+	return doc
+}
+
+// documentID derives a document's primary key from its URL, which may hold
+// characters Meilisearch does not allow in one.
+func documentID(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// sites returns the host of rawURL and its parent domains, without "www."
+// and the top-level domain: blog.go.dev gives blog.go.dev and go.dev.
+func sites(rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	out := []string{host}
+	for {
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 || !strings.Contains(host[dot+1:], ".") {
+			return out
+		}
+		host = host[dot+1:]
+		out = append(out, host)
+	}
+}
+
+type searchRequest struct {
+	Query            string   `json:"q"`
+	Offset           int      `json:"offset,omitempty"`
+	Limit            int      `json:"limit,omitempty"`
+	Filter           []string `json:"filter,omitempty"`
+	AttributesToCrop []string `json:"attributesToCrop"`
+	CropLength       int      `json:"cropLength"`
+	ShowRankingScore bool     `json:"showRankingScore"`
+}
+
+// filterExpressions turns filters into Meilisearch filter expressions, all
+// of which must match.
+func filterExpressions(filters hister.Filters) []string {
+	var out []string
+	if len(filters.Sites) > 0 {
+		sites := make([]string, len(filters.Sites))
+		for i, site := range filters.Sites {
+			sites[i] = strings.TrimPrefix(strings.ToLower(site), "www.")
+		}
+		out = append(out, "sites IN "+quoteList(sites))
+	}
+	if len(filters.Rooms) > 0 {
+		out = append(out, "room_id IN "+quoteList(filters.Rooms))
+	}
+	if !filters.After.IsZero() {
+		out = append(out, "shared_at_unix >= "+strconv.FormatInt(filters.After.Unix(), 10))
+	}
+	if !filters.Before.IsZero() {
+		out = append(out, "shared_at_unix < "+strconv.FormatInt(filters.Before.Unix(), 10))
+	}
+	return out
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+type searchResponse struct {
+	Hits               []hit `json:"hits"`
+	EstimatedTotalHits int   `json:"estimatedTotalHits"`
+}
+
+type hit struct {
+	document
+	RankingScore float64 `json:"_rankingScore"`
+	Formatted    struct {
+		Text string `json:"text"`
+	} `json:"_formatted"`
+}
+
+func (h hit) result() hister.SearchResult {
+	source := hister.Source{RoomID: h.RoomID, Sender: h.Sender, EventID: h.EventID}
+	if t, err := time.Parse(time.RFC3339, h.SharedAt); err == nil {
+		source.SharedAt = t
+	}
+	return hister.SearchResult{
+		Title:   h.Title,
+		URL:     h.URL,
+		Snippet: strings.TrimSpace(h.Formatted.Text),
+		Score:   h.RankingScore,
+		Source:  source,
+	}
+}
@@ -0,0 +1,173 @@
+package meili
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := NewClient(server.URL, 2*time.Second, func(c *Client) {
+		c.APIKey = "secret"
+		c.Retries = -1
+		c.Extract = func(_ context.Context, rawURL string) (extractor.Result, error) {
+			return extractor.Result{Title: "Title of " + rawURL, Text: "Body"}, nil
+		}
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return c
+}
+
+func TestClientIndexesAndSearches(t *testing.T) {
+	t.Parallel()
+
+	sharedAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	var added []document
+	var search searchRequest
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "PUT /indexes/links/documents":
+			if got := r.URL.Query().Get("primaryKey"); got != "id" {
+				t.Errorf("primaryKey = %q, want id", got)
+			}
+			if err := json.NewDecoder(r.Body).Decode(&added); err != nil {
+				t.Fatalf("decode documents: %v", err)
+			}
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"taskUid":1}`))
+		case "POST /indexes/links/search":
+			if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+				t.Fatalf("decode search: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"hits":[{"id":"x","url":"https://blog.go.dev/a","title":"Go","room_id":"!dev:example.org","sender":"@alice:example.org","shared_at":"2024-03-01T09:30:00Z","_rankingScore":0.75,"_formatted":{"text":"…generics are here…"}}],"estimatedTotalHits":12}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+
+	ctx := context.Background()
+	source := hister.Source{RoomID: "!dev:example.org", Sender: "@alice:example.org", EventID: "$evt", SharedAt: sharedAt}
+	if _, err := c.IndexURLFrom(ctx, "https://www.blog.go.dev/a", source); err != nil {
+		t.Fatalf("IndexURLFrom() error = %v", err)
+	}
+	want := document{
+		ID:           documentID("https://www.blog.go.dev/a"),
+		URL:          "https://www.blog.go.dev/a",
+		Title:        "Title of https://www.blog.go.dev/a",
+		Text:         "Body",
+		Sites:        []string{"blog.go.dev", "go.dev"},
+		RoomID:       "!dev:example.org",
+		Sender:       "@alice:example.org",
+		EventID:      "$evt",
+		SharedAt:     "2024-03-01T09:30:00Z",
+		SharedAtUnix: sharedAt.Unix(),
+	}
+	if len(added) != 1 || !reflect.DeepEqual(added[0], want) {
+		t.Fatalf("added = %#v, want %#v", added, want)
+	}
+
+	page, err := c.SearchPage(ctx, "generics", 5, 5)
+	if err != nil {
+		t.Fatalf("SearchPage() error = %v", err)
+	}
+	if search.Query != "generics" || search.Offset != 5 || search.Limit != 5 || search.Filter != nil {
+		t.Fatalf("search request = %#v", search)
+	}
+	wantResult := hister.SearchResult{
+		Title:   "Go",
+		URL:     "https://blog.go.dev/a",
+		Snippet: "…generics are here…",
+		Score:   0.75,
+		Source:  hister.Source{RoomID: "!dev:example.org", Sender: "@alice:example.org", SharedAt: sharedAt},
+	}
+	if page.Total != 12 || len(page.Results) != 1 || page.Results[0] != wantResult {
+		t.Fatalf("page = %#v", page)
+	}
+}
+
+func TestClientSearchFilteredFallsBackUnfiltered(t *testing.T) {
+	t.Parallel()
+
+	var filters [][]string
+	settings := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/indexes/links/settings" {
+			settings++
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		var req searchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode search: %v", err)
+		}
+		filters = append(filters, req.Filter)
+		if req.Filter != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"Attribute sites is not filterable.","code":"invalid_search_filter"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"hits":[]}`))
+	})
+
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := c.SearchFiltered(context.Background(), "generics", hister.Filters{
+		Sites: []string{"www.Go.dev"},
+		Rooms: []string{`!a"b:example.org`},
+		After: after,
+	}, 0, 5)
+	if err != nil {
+		t.Fatalf("SearchFiltered() error = %v", err)
+	}
+	want := [][]string{
+		{`sites IN ["go.dev"]`, `room_id IN ["!a\"b:example.org"]`, "shared_at_unix >= 1704067200"},
+		nil,
+	}
+	if !reflect.DeepEqual(filters, want) || settings != 1 {
+		t.Fatalf("filters = %q settings=%d, want %q after one setup", filters, settings, want)
+	}
+}
+
+func TestClientDeleteURLIgnoresUnknownDocuments(t *testing.T) {
+	t.Parallel()
+
+	var path string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if err := c.DeleteURL(context.Background(), "https://example.com/a"); err != nil {
+		t.Fatalf("DeleteURL() error = %v", err)
+	}
+	if want := "DELETE /indexes/links/documents/" + documentID("https://example.com/a"); path != want {
+		t.Fatalf("request = %q, want %q", path, want)
+	}
+}
+
+func TestClientMisconfiguredKey(t *testing.T) {
+	t.Parallel()
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"The provided API key is invalid."}`))
+	})
+	err := c.Setup(context.Background())
+	if !hister.IsMisconfigured(err) {
+		t.Fatalf("Setup() error = %v, want a misconfiguration", err)
+	}
+}
@@ -0,0 +1,273 @@
+// Package outbox persists outgoing Matrix notices before sending them, so
+// replies and reports composed right before a crash or during a homeserver
+// outage are delivered once the bot can reach the homeserver again.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	DefaultInterval      = 5 * time.Second
+	DefaultRetryDelay    = 5 * time.Second
+	DefaultMaxRetryDelay = 10 * time.Minute
+	DefaultMaxAge        = 24 * time.Hour
+	DefaultBatchSize     = 50
+)
+
+// ErrQueued is returned by SendNotice and SendFormattedNotice when the notice could not be sent right
+// away and was left for the worker. Its event ID is not known yet.
+var ErrQueued = errors.New("notice queued for later delivery")
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type Store interface {
+	EnqueueMessage(ctx context.Context, m storage.OutboxMessage) (int64, error)
+	DueMessages(ctx context.Context, now time.Time, limit int) ([]storage.OutboxMessage, error)
+	RescheduleMessage(ctx context.Context, messageID int64, attempts int, next time.Time) error
+	DeleteMessage(ctx context.Context, messageID int64) error
+}
+
+// Sender delivers notices to Matrix, normally *matrix.Client.
+type Sender interface {
+	SendReply(ctx context.Context, reply matrix.Reply) error
+	SendReplyEvent(ctx context.Context, reply matrix.Reply) (id.EventID, error)
+	SendFormattedNotice(ctx context.Context, roomID id.RoomID, body, formatted string) (id.EventID, error)
+}
+
+type Config struct {
+	// Interval is how often queued messages are retried.
+	Interval time.Duration
+	// RetryDelay is the wait after the first failed attempt; it doubles with
+	// every further failure up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// MaxAge is how long a message is retried before it is dropped.
+	MaxAge time.Duration
+	// BatchSize caps how many messages one pass sends.
+	BatchSize int
+	// Owns, when set, limits the worker to messages for the rooms it
+	// reports true for, so queues of several accounts can share one store.
+	Owns func(roomID id.RoomID) bool
+}
+
+type Deps struct {
+	Store  Store
+	Sender Sender
+	Logger Logger
+}
+
+// Queue sends notices through Sender, keeping each one in Store until it was
+// delivered. It implements the SendReply, SendNotice and SendFormattedNotice
+// methods of the Matrix client, so it can stand in for it.
+type Queue struct {
+	cfg    Config
+	store  Store
+	sender Sender
+	logger Logger
+	now    func() time.Time
+}
+
+func New(cfg Config, deps Deps) (*Queue, error) {
+	if deps.Store == nil {
+		return nil, errors.New("outbox store is required")
+	}
+	if deps.Sender == nil {
+		return nil, errors.New("sender is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+	if cfg.MaxRetryDelay < cfg.RetryDelay {
+		cfg.MaxRetryDelay = max(DefaultMaxRetryDelay, cfg.RetryDelay)
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	return &Queue{
+		cfg:    cfg,
+		store:  deps.Store,
+		sender: deps.Sender,
+		logger: deps.Logger,
+		now:    time.Now,
+	}, nil
+}
+
+// SendReply queues reply and tries to send it at once. A reply that could
+// not be sent stays queued for the worker and is not reported as an error.
+func (q *Queue) SendReply(ctx context.Context, reply matrix.Reply) error {
+	_, err := q.send(ctx, reply, func() (id.EventID, error) {
+		return "", q.sender.SendReply(ctx, reply)
+	})
+	if errors.Is(err, ErrQueued) {
+		return nil
+	}
+	return err
+}
+
+// SendReplyEvent is SendReply that returns the reply's event ID. If the
+// reply could not be sent at once it stays queued and the error wraps
+// ErrQueued.
+func (q *Queue) SendReplyEvent(ctx context.Context, reply matrix.Reply) (id.EventID, error) {
+	return q.send(ctx, reply, func() (id.EventID, error) {
+		return q.sender.SendReplyEvent(ctx, reply)
+	})
+}
+
+// SendNotice queues a standalone notice and tries to send it at once,
+// returning its event ID. If that fails the notice stays queued and the error
+// wraps ErrQueued.
+func (q *Queue) SendNotice(ctx context.Context, roomID id.RoomID, body string) (id.EventID, error) {
+	return q.SendFormattedNotice(ctx, roomID, body, "")
+}
+
+// SendFormattedNotice is SendNotice with an HTML formatted body.
+func (q *Queue) SendFormattedNotice(ctx context.Context, roomID id.RoomID, body, formatted string) (id.EventID, error) {
+	return q.send(ctx, matrix.Reply{RoomID: roomID, Body: body, FormattedBody: formatted, Kind: matrix.ReplyPost}, func() (id.EventID, error) {
+		return q.sender.SendFormattedNotice(ctx, roomID, body, formatted)
+	})
+}
+
+// send persists reply, then delivers it with deliver. The stored message is
+// scheduled one retry delay ahead so the worker does not pick it up while
+// the first attempt is in flight. If it cannot be stored, it is sent
+// directly.
+func (q *Queue) send(ctx context.Context, reply matrix.Reply, deliver func() (id.EventID, error)) (id.EventID, error) {
+	now := q.now()
+	messageID, err := q.store.EnqueueMessage(ctx, storage.OutboxMessage{
+		RoomID:        reply.RoomID,
+		InReplyTo:     reply.InReplyToEventID,
+		Body:          reply.Body,
+		FormattedBody: reply.FormattedBody,
+		Thread:        reply.Thread,
+		Kind:          string(reply.Kind),
+		Replaces:      reply.Replaces,
+		CreatedAt:     now,
+		NextAttemptAt: now.Add(q.cfg.RetryDelay),
+	})
+	if err != nil {
+		q.logf("outbox enqueue failed room=%s err=%v", reply.RoomID, err)
+		return deliver()
+	}
+
+	eventID, err := deliver()
+	if err != nil {
+		q.logf("outbox send failed room=%s message=%d err=%v; will retry", reply.RoomID, messageID, err)
+		q.reschedule(ctx, messageID, 1)
+		return "", fmt.Errorf("%w: %v", ErrQueued, err)
+	}
+	q.delete(ctx, messageID)
+	return eventID, nil
+}
+
+// Run retries queued messages every Interval until ctx is done. Messages
+// left over from before a restart are sent on the first pass.
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if sent, err := q.RunOnce(ctx); err != nil {
+			q.logf("outbox pass failed err=%v", err)
+		} else if sent > 0 {
+			q.logf("outbox delivered %d queued messages", sent)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce sends one batch of due messages and returns how many were
+// delivered. After a failure, later messages for the same room wait for the
+// next pass so a room's messages keep their order.
+func (q *Queue) RunOnce(ctx context.Context) (int, error) {
+	now := q.now()
+	messages, err := q.store.DueMessages(ctx, now, q.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	blocked := make(map[id.RoomID]struct{})
+	for _, m := range messages {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+		if _, ok := blocked[m.RoomID]; ok {
+			continue
+		}
+		if q.cfg.Owns != nil && !q.cfg.Owns(m.RoomID) {
+			continue
+		}
+		if now.Sub(m.CreatedAt) > q.cfg.MaxAge {
+			q.logf("outbox dropped room=%s message=%d attempts=%d: older than %s", m.RoomID, m.ID, m.Attempts, q.cfg.MaxAge)
+			q.delete(ctx, m.ID)
+			continue
+		}
+		err := q.sender.SendReply(ctx, matrix.Reply{
+			RoomID:           m.RoomID,
+			InReplyToEventID: m.InReplyTo,
+			Body:             m.Body,
+			FormattedBody:    m.FormattedBody,
+			Thread:           m.Thread,
+			Kind:             matrix.ReplyKind(m.Kind),
+			Replaces:         m.Replaces,
+		})
+		if err != nil {
+			q.logf("outbox retry failed room=%s message=%d attempt=%d err=%v", m.RoomID, m.ID, m.Attempts+1, err)
+			q.reschedule(ctx, m.ID, m.Attempts+1)
+			blocked[m.RoomID] = struct{}{}
+			continue
+		}
+		q.delete(ctx, m.ID)
+		sent++
+	}
+	return sent, nil
+}
+
+// retryDelay is the wait after the given number of failed attempts.
+func (q *Queue) retryDelay(attempts int) time.Duration {
+	delay := q.cfg.RetryDelay
+	for i := 1; i < attempts && delay < q.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, q.cfg.MaxRetryDelay)
+}
+
+func (q *Queue) reschedule(ctx context.Context, messageID int64, attempts int) {
+	// A cancelled request context must not keep the message from being
+	// rescheduled, or the worker would retry it right away.
+	ctx = context.WithoutCancel(ctx)
+	if err := q.store.RescheduleMessage(ctx, messageID, attempts, q.now().Add(q.retryDelay(attempts))); err != nil {
+		q.logf("outbox reschedule failed message=%d err=%v", messageID, err)
+	}
+}
+
+func (q *Queue) delete(ctx context.Context, messageID int64) {
+	if err := q.store.DeleteMessage(context.WithoutCancel(ctx), messageID); err != nil {
+		q.logf("outbox delete failed message=%d err=%v", messageID, err)
+	}
+}
+
+func (q *Queue) logf(format string, args ...any) {
+	if q.logger != nil {
+		q.logger.Printf(format, args...)
+	}
+}
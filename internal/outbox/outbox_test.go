@@ -0,0 +1,183 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeStore struct {
+	nextID   int64
+	messages map[int64]storage.OutboxMessage
+}
+
+func (f *fakeStore) EnqueueMessage(_ context.Context, m storage.OutboxMessage) (int64, error) {
+	if f.messages == nil {
+		f.messages = make(map[int64]storage.OutboxMessage)
+	}
+	f.nextID++
+	m.ID = f.nextID
+	f.messages[m.ID] = m
+	return m.ID, nil
+}
+
+func (f *fakeStore) DueMessages(_ context.Context, now time.Time, limit int) ([]storage.OutboxMessage, error) {
+	var out []storage.OutboxMessage
+	for _, m := range f.messages {
+		if !m.NextAttemptAt.After(now) {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out[:min(len(out), limit)], nil
+}
+
+func (f *fakeStore) RescheduleMessage(_ context.Context, messageID int64, attempts int, next time.Time) error {
+	m := f.messages[messageID]
+	m.Attempts, m.NextAttemptAt = attempts, next
+	f.messages[messageID] = m
+	return nil
+}
+
+func (f *fakeStore) DeleteMessage(_ context.Context, messageID int64) error {
+	delete(f.messages, messageID)
+	return nil
+}
+
+type fakeSender struct {
+	err     error
+	replies []matrix.Reply
+}
+
+func (f *fakeSender) SendReply(_ context.Context, reply matrix.Reply) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.replies = append(f.replies, reply)
+	return nil
+}
+
+func (f *fakeSender) SendReplyEvent(ctx context.Context, reply matrix.Reply) (id.EventID, error) {
+	if err := f.SendReply(ctx, reply); err != nil {
+		return "", err
+	}
+	return "$sent", nil
+}
+
+func (f *fakeSender) SendFormattedNotice(_ context.Context, roomID id.RoomID, body, formatted string) (id.EventID, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.replies = append(f.replies, matrix.Reply{RoomID: roomID, Body: body, FormattedBody: formatted})
+	return "$sent", nil
+}
+
+func newTestQueue(t *testing.T, store *fakeStore, sender *fakeSender, now *time.Time) *Queue {
+	t.Helper()
+	q, err := New(Config{RetryDelay: time.Second, MaxRetryDelay: 4 * time.Second, MaxAge: time.Hour}, Deps{Store: store, Sender: sender})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	q.now = func() time.Time { return *now }
+	return q
+}
+
+func TestSendDeliversAndForgetsMessages(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeStore{}
+	sender := &fakeSender{}
+	q := newTestQueue(t, store, sender, &now)
+
+	reply := matrix.Reply{RoomID: "!room:test", InReplyToEventID: "$evt", Body: "hi", Thread: true}
+	if err := q.SendReply(context.Background(), reply); err != nil {
+		t.Fatalf("SendReply failed: %v", err)
+	}
+	eventID, err := q.SendNotice(context.Background(), "!room:test", "digest")
+	if err != nil || eventID != "$sent" {
+		t.Fatalf("SendNotice = %q, %v", eventID, err)
+	}
+	if len(sender.replies) != 2 || sender.replies[0] != reply {
+		t.Fatalf("unexpected sent replies: %#v", sender.replies)
+	}
+	if len(store.messages) != 0 {
+		t.Fatalf("expected delivered messages to be removed, got %#v", store.messages)
+	}
+}
+
+func TestFailedSendsAreRetriedInOrder(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeStore{}
+	sender := &fakeSender{err: errors.New("homeserver down")}
+	q := newTestQueue(t, store, sender, &now)
+
+	if err := q.SendReply(context.Background(), matrix.Reply{RoomID: "!room:test", Body: "first"}); err != nil {
+		t.Fatalf("expected queued reply to report success, got %v", err)
+	}
+	if _, err := q.SendNotice(context.Background(), "!room:test", "second"); !errors.Is(err, ErrQueued) {
+		t.Fatalf("expected ErrQueued, got %v", err)
+	}
+	if len(store.messages) != 2 || store.messages[1].Attempts != 1 || !store.messages[1].NextAttemptAt.Equal(now.Add(time.Second)) {
+		t.Fatalf("unexpected queued messages: %#v", store.messages)
+	}
+
+	now = now.Add(time.Second)
+	if sent, _ := q.RunOnce(context.Background()); sent != 0 {
+		t.Fatalf("expected nothing delivered while down, got %d", sent)
+	}
+	if m := store.messages[1]; m.Attempts != 2 || !m.NextAttemptAt.Equal(now.Add(2*time.Second)) {
+		t.Fatalf("expected backoff to double, got %#v", m)
+	}
+	if store.messages[2].Attempts != 1 {
+		t.Fatalf("expected later message in the room to wait, got %#v", store.messages[2])
+	}
+
+	sender.err = nil
+	now = now.Add(2 * time.Second)
+	if sent, err := q.RunOnce(context.Background()); sent != 2 || err != nil {
+		t.Fatalf("RunOnce = %d, %v; want 2 delivered", sent, err)
+	}
+	if len(sender.replies) != 2 || sender.replies[0].Body != "first" || sender.replies[1].Body != "second" {
+		t.Fatalf("unexpected delivery order: %#v", sender.replies)
+	}
+	if sender.replies[1].Kind != matrix.ReplyPost {
+		t.Fatalf("expected a retried notice to stay a post, got %#v", sender.replies[1])
+	}
+	if len(store.messages) != 0 {
+		t.Fatalf("expected queue to be empty, got %#v", store.messages)
+	}
+}
+
+func TestRunOnceDropsExpiredMessages(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeStore{}
+	sender := &fakeSender{}
+	q := newTestQueue(t, store, sender, &now)
+	_, _ = store.EnqueueMessage(context.Background(), storage.OutboxMessage{RoomID: "!room:test", Body: "stale", CreatedAt: now.Add(-2 * time.Hour)})
+
+	if sent, _ := q.RunOnce(context.Background()); sent != 0 || len(sender.replies) != 0 || len(store.messages) != 0 {
+		t.Fatalf("expected expired message to be dropped, sent=%d replies=%#v queued=%#v", sent, sender.replies, store.messages)
+	}
+}
+
+func TestRunOnceSkipsRoomsItDoesNotOwn(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeStore{}
+	sender := &fakeSender{}
+	q := newTestQueue(t, store, sender, &now)
+	q.cfg.Owns = func(roomID id.RoomID) bool { return roomID == "!mine:test" }
+	_, _ = store.EnqueueMessage(context.Background(), storage.OutboxMessage{RoomID: "!other:test", Body: "theirs", CreatedAt: now})
+	_, _ = store.EnqueueMessage(context.Background(), storage.OutboxMessage{RoomID: "!mine:test", Body: "mine", CreatedAt: now})
+
+	if sent, _ := q.RunOnce(context.Background()); sent != 1 || len(sender.replies) != 1 || sender.replies[0].Body != "mine" {
+		t.Fatalf("expected only the owned message to be sent, sent=%d replies=%#v", sent, sender.replies)
+	}
+	if len(store.messages) != 1 {
+		t.Fatalf("expected the other account's message to stay queued, got %#v", store.messages)
+	}
+}
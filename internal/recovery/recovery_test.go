@@ -0,0 +1,69 @@
+package recovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeLogger struct{ lines []string }
+
+func (f *fakeLogger) Printf(format string, args ...any) {
+	f.lines = append(f.lines, fmt.Sprintf(format, args...))
+}
+
+type fakeReporter struct{ got []Panic }
+
+func (f *fakeReporter) ReportPanic(_ context.Context, p Panic) error {
+	f.got = append(f.got, p)
+	return nil
+}
+
+func TestGuard_RecoverLogsAndReports(t *testing.T) {
+	logger := &fakeLogger{}
+	reporter := &fakeReporter{}
+	g := &Guard{Logger: logger, Reporter: reporter}
+
+	func() {
+		defer g.Recover("message handler")
+		panic("boom")
+	}()
+
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "recovered panic in message handler: boom") {
+		t.Fatalf("unexpected log lines: %#v", logger.lines)
+	}
+	if len(reporter.got) != 1 || reporter.got[0].Value != "boom" || !strings.Contains(reporter.got[0].Stack, "recovery_test.go") {
+		t.Fatalf("unexpected reports: %#v", reporter.got)
+	}
+}
+
+func TestGuard_NilGuardStillRecovers(t *testing.T) {
+	var g *Guard
+	func() {
+		defer g.Recover("worker")
+		panic("boom")
+	}()
+}
+
+func TestWebhook_PostsJSON(t *testing.T) {
+	var got Panic
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL}
+	if err := w.ReportPanic(context.Background(), Panic{Component: "worker", Value: "boom"}); err != nil {
+		t.Fatalf("ReportPanic failed: %v", err)
+	}
+	if got.Component != "worker" || got.Value != "boom" {
+		t.Fatalf("unexpected payload: %#v", got)
+	}
+}
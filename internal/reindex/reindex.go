@@ -0,0 +1,220 @@
+// Package reindex keeps the Hister index fresh by periodically re-fetching
+// documents the bot indexed and re-submitting the ones whose content changed.
+package reindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+)
+
+const (
+	DefaultInterval  = time.Hour
+	DefaultBatchSize = 50
+)
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type Store interface {
+	RecordIndexedDocument(ctx context.Context, doc storage.IndexedDocument) error
+	StaleDocuments(ctx context.Context, cutoff time.Time, limit int) ([]storage.IndexedDocument, error)
+}
+
+type Config struct {
+	// MaxAge is how long a document goes unchecked before it is re-fetched.
+	MaxAge time.Duration
+	// Interval is how often stale documents are looked for.
+	Interval time.Duration
+	// Schedule, when set, replaces Interval: passes run only at its times
+	// rather than right away and then every Interval.
+	Schedule schedule.Schedule
+	// BatchSize caps how many documents one pass re-fetches.
+	BatchSize int
+}
+
+// Deps are the collaborators used by Job. Extract should send since as
+// conditional request headers and return extractor.ErrNotModified on 304.
+type Deps struct {
+	Store   Store
+	Extract func(ctx context.Context, rawURL string, since extractor.Validators) (extractor.Result, error)
+	Index   func(ctx context.Context, rawURL string, content extractor.Result) error
+	Logger  Logger
+}
+
+// Stats summarizes one refresh pass.
+type Stats struct {
+	Checked   int
+	Refreshed int
+	Unchanged int
+	Failed    int
+}
+
+type Job struct {
+	cfg     Config
+	store   Store
+	extract func(ctx context.Context, rawURL string, since extractor.Validators) (extractor.Result, error)
+	index   func(ctx context.Context, rawURL string, content extractor.Result) error
+	logger  Logger
+	now     func() time.Time
+}
+
+func New(cfg Config, deps Deps) (*Job, error) {
+	if deps.Store == nil {
+		return nil, errors.New("document store is required")
+	}
+	if deps.Extract == nil || deps.Index == nil {
+		return nil, errors.New("extract and index functions are required")
+	}
+	if cfg.MaxAge <= 0 {
+		return nil, errors.New("max age must be greater than zero")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	return &Job{
+		cfg:     cfg,
+		store:   deps.Store,
+		extract: deps.Extract,
+		index:   deps.Index,
+		logger:  deps.Logger,
+		now:     time.Now,
+	}, nil
+}
+
+// Hash fingerprints extracted content; a page is re-submitted only when its
+// hash changes.
+func Hash(content extractor.Result) string {
+	sum := sha256.Sum256([]byte(content.Title + "\x00" + content.Text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Record starts tracking a freshly indexed URL. It fits hister.Client's
+// OnIndexed hook.
+func (j *Job) Record(ctx context.Context, rawURL string, content extractor.Result) {
+	now := j.now()
+	err := j.store.RecordIndexedDocument(ctx, storage.IndexedDocument{
+		URL:          rawURL,
+		ContentHash:  Hash(content),
+		ETag:         content.Validators.ETag,
+		LastModified: content.Validators.LastModified,
+		IndexedAt:    now,
+		CheckedAt:    now,
+	})
+	if err != nil {
+		j.logf("reindex track failed url=%s err=%v", rawURL, err)
+	}
+}
+
+// Run refreshes stale documents every Interval, or at each Schedule time,
+// until ctx is done.
+func (j *Job) Run(ctx context.Context) {
+	plan := j.cfg.Schedule
+	next := j.now()
+	if plan == nil {
+		plan = schedule.Every(j.cfg.Interval)
+	} else {
+		next = plan.Next(next)
+	}
+	for !next.IsZero() {
+		if !schedule.Wait(ctx, next) {
+			return
+		}
+		if stats, err := j.RunOnce(ctx); err != nil {
+			j.logf("reindex pass failed err=%v", err)
+		} else if stats.Checked > 0 {
+			j.logf("reindex pass checked=%d refreshed=%d unchanged=%d failed=%d",
+				stats.Checked, stats.Refreshed, stats.Unchanged, stats.Failed)
+		}
+		next = plan.Next(j.now())
+	}
+}
+
+// RunOnce re-fetches one batch of documents not checked within MaxAge.
+func (j *Job) RunOnce(ctx context.Context) (Stats, error) {
+	var stats Stats
+	docs, err := j.store.StaleDocuments(ctx, j.now().Add(-j.cfg.MaxAge), j.cfg.BatchSize)
+	if err != nil {
+		return stats, err
+	}
+	for _, doc := range docs {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		stats.Checked++
+		switch j.refresh(ctx, doc) {
+		case refreshed:
+			stats.Refreshed++
+		case unchanged:
+			stats.Unchanged++
+		default:
+			stats.Failed++
+		}
+	}
+	return stats, nil
+}
+
+type outcome int
+
+const (
+	failed outcome = iota
+	unchanged
+	refreshed
+)
+
+func (j *Job) refresh(ctx context.Context, doc storage.IndexedDocument) outcome {
+	now := j.now()
+	content, err := j.extract(ctx, doc.URL, extractor.Validators{ETag: doc.ETag, LastModified: doc.LastModified})
+	switch {
+	case errors.Is(err, extractor.ErrNotModified):
+		doc.CheckedAt = now
+		j.save(ctx, doc)
+		return unchanged
+	case err != nil:
+		// Back off unreachable pages until the next MaxAge rather than
+		// retrying them at the head of every batch.
+		j.logf("reindex fetch failed url=%s err=%v", doc.URL, err)
+		doc.CheckedAt = now
+		j.save(ctx, doc)
+		return failed
+	}
+
+	doc.ETag = content.Validators.ETag
+	doc.LastModified = content.Validators.LastModified
+	doc.CheckedAt = now
+	if hash := Hash(content); hash != doc.ContentHash {
+		if err := j.index(ctx, doc.URL, content); err != nil {
+			// Leave the row as is so the next pass retries it.
+			j.logf("reindex submit failed url=%s err=%v", doc.URL, err)
+			return failed
+		}
+		doc.ContentHash = hash
+		doc.IndexedAt = now
+		j.save(ctx, doc)
+		return refreshed
+	}
+	j.save(ctx, doc)
+	return unchanged
+}
+
+func (j *Job) save(ctx context.Context, doc storage.IndexedDocument) {
+	if err := j.store.RecordIndexedDocument(ctx, doc); err != nil {
+		j.logf("reindex save failed url=%s err=%v", doc.URL, err)
+	}
+}
+
+func (j *Job) logf(format string, args ...any) {
+	if j.logger != nil {
+		j.logger.Printf(format, args...)
+	}
+}
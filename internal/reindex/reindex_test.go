@@ -0,0 +1,146 @@
+package reindex
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+)
+
+type fakeStore struct {
+	docs   map[string]storage.IndexedDocument
+	cutoff time.Time
+	limit  int
+}
+
+func (f *fakeStore) RecordIndexedDocument(_ context.Context, doc storage.IndexedDocument) error {
+	f.docs[doc.URL] = doc
+	return nil
+}
+
+func (f *fakeStore) StaleDocuments(_ context.Context, cutoff time.Time, limit int) ([]storage.IndexedDocument, error) {
+	f.cutoff, f.limit = cutoff, limit
+	var out []storage.IndexedDocument
+	for _, url := range []string{"https://a.example", "https://b.example", "https://c.example", "https://d.example"} {
+		if doc, ok := f.docs[url]; ok && doc.CheckedAt.Before(cutoff) {
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+func TestRunOnceRefreshesOnlyChangedPages(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-30 * 24 * time.Hour)
+	same := extractor.Result{Title: "Same", Text: "unchanged"}
+	store := &fakeStore{docs: map[string]storage.IndexedDocument{
+		"https://a.example": {URL: "https://a.example", ContentHash: Hash(same), ETag: `"a1"`, CheckedAt: old, IndexedAt: old},
+		"https://b.example": {URL: "https://b.example", ContentHash: Hash(same), CheckedAt: old, IndexedAt: old},
+		"https://c.example": {URL: "https://c.example", ContentHash: "stale", CheckedAt: old, IndexedAt: old},
+		"https://d.example": {URL: "https://d.example", ContentHash: "gone", CheckedAt: old, IndexedAt: old},
+	}}
+
+	var sentValidators extractor.Validators
+	var indexed []string
+	job, err := New(Config{MaxAge: 7 * 24 * time.Hour, BatchSize: 10}, Deps{
+		Store: store,
+		Extract: func(_ context.Context, rawURL string, since extractor.Validators) (extractor.Result, error) {
+			switch rawURL {
+			case "https://a.example":
+				sentValidators = since
+				return extractor.Result{}, extractor.ErrNotModified
+			case "https://b.example":
+				return extractor.Result{Title: same.Title, Text: same.Text, Validators: extractor.Validators{ETag: `"b2"`}}, nil
+			case "https://c.example":
+				return extractor.Result{Title: "New", Text: "changed"}, nil
+			}
+			return extractor.Result{}, errors.New("404")
+		},
+		Index: func(_ context.Context, rawURL string, _ extractor.Result) error {
+			indexed = append(indexed, rawURL)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	job.now = func() time.Time { return now }
+
+	stats, err := job.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if stats != (Stats{Checked: 4, Refreshed: 1, Unchanged: 2, Failed: 1}) {
+		t.Fatalf("stats = %#v", stats)
+	}
+	if !store.cutoff.Equal(now.Add(-7*24*time.Hour)) || store.limit != 10 {
+		t.Fatalf("stale query cutoff=%s limit=%d", store.cutoff, store.limit)
+	}
+	if sentValidators.ETag != `"a1"` {
+		t.Fatalf("conditional request validators = %#v", sentValidators)
+	}
+	if len(indexed) != 1 || indexed[0] != "https://c.example" {
+		t.Fatalf("indexed = %#v, want only the changed page", indexed)
+	}
+
+	c := store.docs["https://c.example"]
+	if c.ContentHash != Hash(extractor.Result{Title: "New", Text: "changed"}) || !c.IndexedAt.Equal(now) {
+		t.Fatalf("changed doc = %#v", c)
+	}
+	if b := store.docs["https://b.example"]; b.ETag != `"b2"` || !b.IndexedAt.Equal(old) || !b.CheckedAt.Equal(now) {
+		t.Fatalf("unchanged doc = %#v", b)
+	}
+	for url, doc := range store.docs {
+		if !doc.CheckedAt.Equal(now) {
+			t.Fatalf("%s not marked checked: %#v", url, doc)
+		}
+	}
+}
+
+func TestRunOnceRetriesFailedSubmissions(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	store := &fakeStore{docs: map[string]storage.IndexedDocument{
+		"https://a.example": {URL: "https://a.example", ContentHash: "stale", CheckedAt: old},
+	}}
+	job, err := New(Config{MaxAge: time.Hour}, Deps{
+		Store: store,
+		Extract: func(context.Context, string, extractor.Validators) (extractor.Result, error) {
+			return extractor.Result{Text: "new"}, nil
+		},
+		Index: func(context.Context, string, extractor.Result) error { return errors.New("hister down") },
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if stats, _ := job.RunOnce(context.Background()); stats.Failed != 1 {
+		t.Fatalf("stats = %#v", stats)
+	}
+	if doc := store.docs["https://a.example"]; doc.ContentHash != "stale" || !doc.CheckedAt.Equal(old) {
+		t.Fatalf("doc = %#v, want untouched for retry", doc)
+	}
+}
+
+func TestRecordTracksIndexedContent(t *testing.T) {
+	store := &fakeStore{docs: map[string]storage.IndexedDocument{}}
+	job, err := New(Config{MaxAge: time.Hour}, Deps{
+		Store: store,
+		Extract: func(context.Context, string, extractor.Validators) (extractor.Result, error) {
+			return extractor.Result{}, nil
+		},
+		Index: func(context.Context, string, extractor.Result) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	content := extractor.Result{Title: "T", Text: "body", Validators: extractor.Validators{LastModified: "Sun, 01 Mar 2026 09:00:00 GMT"}}
+	job.Record(context.Background(), "https://a.example", content)
+	doc := store.docs["https://a.example"]
+	if doc.ContentHash != Hash(content) || doc.LastModified != content.Validators.LastModified || doc.IndexedAt.IsZero() {
+		t.Fatalf("recorded %#v", doc)
+	}
+}
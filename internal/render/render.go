@@ -0,0 +1,169 @@
+// Package render formats search results, summaries and the welcome message
+// with operator-supplied text/template templates.
+package render
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+const (
+	// DefaultResult renders one search result as its index, title, URL,
+	// snippet and source on separate lines.
+	DefaultResult = "{{.Index}}. {{.Title}}\n{{.URL}}{{if .Snippet}}\n{{.Snippet}}{{end}}{{if .Source}}\n{{.Source}}{{end}}"
+	// DefaultResultHTML renders one search result for the HTML body as a
+	// link titled with the result's title, followed by its snippet and
+	// source.
+	DefaultResultHTML = `<a href="{{.URL}}">{{.Title}}</a>{{if .Snippet}}<br>{{.Snippet}}{{end}}{{if .Source}}<br><em>{{.Source}}</em>{{end}}`
+	// DefaultSummary renders a summary as is.
+	DefaultSummary = "{{.Summary}}"
+)
+
+// Result is the data a result template is executed with.
+type Result struct {
+	// Index is the result's 1-based position in the reply.
+	Index   int
+	Title   string
+	URL     string
+	Snippet string
+	// Score is the search backend's relevance score, zero if it has none.
+	Score float64
+	// Source says who shared the result's link, when and where, if the
+	// bot indexes that.
+	Source string
+}
+
+// Summary is the data a summary template is executed with.
+type Summary struct {
+	Summary string
+	// Messages is how many messages were summarized.
+	Messages int
+}
+
+// Welcome is the data the welcome template is executed with.
+type Welcome struct {
+	// SearchCommand is the search command, with the configured prefix.
+	SearchCommand string
+	// CatchMeUpCommand is the summary command, empty when summaries are not
+	// configured.
+	CatchMeUpCommand string
+	// IndexReaction is the reaction that indexes a message's links on
+	// request, empty when reaction indexing is off.
+	IndexReaction string
+	// IgnorePrefixes are the message prefixes that opt a message out of
+	// indexing.
+	IgnorePrefixes []string
+}
+
+// Templates holds the compiled result, summary and welcome templates. A nil
+// *Templates renders the defaults. resultHTML is only set while the result
+// template is the default one, and welcome only when one is configured: the
+// default welcome is localized by the caller.
+type Templates struct {
+	result     *template.Template
+	resultHTML *htmltemplate.Template
+	summary    *template.Template
+	welcome    *template.Template
+}
+
+// New compiles the result, summary and welcome templates, using the default
+// for an empty result or summary one, and checks that they execute against
+// sample data.
+func New(result, summary, welcome string) (*Templates, error) {
+	t := &Templates{}
+	if strings.TrimSpace(result) == "" {
+		result = DefaultResult
+		t.resultHTML = defaults.resultHTML
+	}
+	if strings.TrimSpace(summary) == "" {
+		summary = DefaultSummary
+	}
+	var err error
+	if t.result, err = parse("result", result); err != nil {
+		return nil, err
+	}
+	if t.summary, err = parse("summary", summary); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(welcome) != "" {
+		if t.welcome, err = parse("welcome", welcome); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := t.Result(Result{Index: 1, Title: "Title", URL: "https://example.com", Snippet: "Snippet", Score: 1, Source: "Source"}); err != nil {
+		return nil, err
+	}
+	if _, err := t.Summary(Summary{Summary: "Summary", Messages: 1}); err != nil {
+		return nil, err
+	}
+	if _, err := t.Welcome(Welcome{SearchCommand: "/search", CatchMeUpCommand: "/catchmeup", IndexReaction: "🔖", IgnorePrefixes: []string{"!nobot"}}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+var defaults = &Templates{
+	result:     template.Must(parse("result", DefaultResult)),
+	resultHTML: htmltemplate.Must(htmltemplate.New("result").Option("missingkey=error").Parse(DefaultResultHTML)),
+	summary:    template.Must(parse("summary", DefaultSummary)),
+}
+
+func parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Result renders r.
+func (t *Templates) Result(r Result) (string, error) {
+	if t == nil {
+		t = defaults
+	}
+	return execute(t.result, r)
+}
+
+// ResultHTML renders r for an HTML body. It returns "" when a custom result
+// template is configured: its output is plain text that callers format
+// themselves.
+func (t *Templates) ResultHTML(r Result) (string, error) {
+	if t == nil {
+		t = defaults
+	}
+	if t.resultHTML == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	if err := t.resultHTML.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("execute result html template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// Summary renders s.
+func (t *Templates) Summary(s Summary) (string, error) {
+	if t == nil {
+		t = defaults
+	}
+	return execute(t.summary, s)
+}
+
+// Welcome renders w. It returns "" when no welcome template is configured:
+// callers then post their localized default.
+func (t *Templates) Welcome(w Welcome) (string, error) {
+	if t == nil || t.welcome == nil {
+		return "", nil
+	}
+	return execute(t.welcome, w)
+}
+
+func execute(tmpl *template.Template, data any) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("execute %s template: %w", tmpl.Name(), err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,64 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	var tmpl *Templates
+	got, err := tmpl.Result(Result{Index: 2, Title: "Go", URL: "https://go.dev"})
+	if err != nil || got != "2. Go\nhttps://go.dev" {
+		t.Fatalf("Result() = %q, %v", got, err)
+	}
+	got, err = tmpl.Summary(Summary{Summary: "- greetings", Messages: 3})
+	if err != nil || got != "- greetings" {
+		t.Fatalf("Summary() = %q, %v", got, err)
+	}
+	got, err = tmpl.Welcome(Welcome{SearchCommand: "!search"})
+	if err != nil || got != "" {
+		t.Fatalf("Welcome() = %q, %v, want no default", got, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tmpl, err := New(`• [{{.Title}}]({{.URL}}) {{printf "%.1f" .Score}}`, "{{.Messages}} messages:\n{{.Summary}}", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	got, _ := tmpl.Result(Result{Index: 1, Title: "Go", URL: "https://go.dev", Score: 0.75})
+	if got != "• [Go](https://go.dev) 0.8" {
+		t.Fatalf("Result() = %q", got)
+	}
+	got, _ = tmpl.Summary(Summary{Summary: "- hi", Messages: 4})
+	if got != "4 messages:\n- hi" {
+		t.Fatalf("Summary() = %q", got)
+	}
+
+	if _, err := New("{{.Title", "", ""); err == nil || !strings.Contains(err.Error(), "result template") {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+	if _, err := New("{{.Rank}}", "", ""); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestResultHTML(t *testing.T) {
+	var defaults *Templates
+	got, err := defaults.ResultHTML(Result{Index: 1, Title: "Go <docs>", URL: "https://go.dev/?a=1&b=2", Snippet: "fast & simple"})
+	if want := `<a href="https://go.dev/?a=1&amp;b=2">Go &lt;docs&gt;</a><br>fast &amp; simple`; err != nil || got != want {
+		t.Fatalf("ResultHTML() = %q, %v; want %q", got, err, want)
+	}
+	got, _ = defaults.ResultHTML(Result{Title: "x", URL: "javascript:alert(1)"})
+	if strings.Contains(got, "javascript:") {
+		t.Fatalf("expected unsafe URL to be filtered, got %q", got)
+	}
+
+	custom, err := New("{{.Title}}", "", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got, err := custom.ResultHTML(Result{Title: "Go", URL: "https://go.dev"}); err != nil || got != "" {
+		t.Fatalf("expected no HTML for a custom template, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package retention forgets what the bot indexed from rooms with a
+// retention period once it is older than that period.
+package retention
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"maunium.net/go/mautrix/id"
+)
+
+const DefaultInterval = time.Hour
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// Store lists and forgets a room's contributions older than a cutoff.
+// ExpiringDocuments returns the URLs nothing else refers to, which are
+// removed from the index before ExpireRoom forgets them.
+type Store interface {
+	ExpiringDocuments(ctx context.Context, roomID id.RoomID, cutoff time.Time) ([]string, error)
+	ExpireRoom(ctx context.Context, roomID id.RoomID, cutoff time.Time) error
+}
+
+// Deleter removes documents from the search index.
+type Deleter interface {
+	DeleteURL(ctx context.Context, rawURL string) error
+}
+
+// RoomDeleter is implemented by Deleters whose index is split per room; an
+// expired document is then deleted from its room's part.
+type RoomDeleter interface {
+	DeleteRoomURL(ctx context.Context, roomID id.RoomID, rawURL string) error
+}
+
+type Config struct {
+	// Rooms maps each room with a retention period to that period.
+	Rooms map[id.RoomID]time.Duration
+	// Interval is how often expired documents are purged; Schedule, when
+	// set, replaces it.
+	Interval time.Duration
+	Schedule schedule.Schedule
+}
+
+// Deps are the collaborators used by Job. Without a Deleter, expired
+// documents are only forgotten locally and stay in the search index.
+type Deps struct {
+	Store   Store
+	Deleter Deleter
+	Logger  Logger
+}
+
+// Stats summarizes one purge pass.
+type Stats struct {
+	Expired int
+	Deleted int
+	Failed  int
+}
+
+type Job struct {
+	cfg     Config
+	store   Store
+	deleter Deleter
+	logger  Logger
+	now     func() time.Time
+}
+
+func New(cfg Config, deps Deps) (*Job, error) {
+	if deps.Store == nil {
+		return nil, errors.New("retention store is required")
+	}
+	for roomID, maxAge := range cfg.Rooms {
+		if maxAge <= 0 {
+			return nil, errors.New("retention period of " + string(roomID) + " must be greater than zero")
+		}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	return &Job{
+		cfg:     cfg,
+		store:   deps.Store,
+		deleter: deps.Deleter,
+		logger:  deps.Logger,
+		now:     time.Now,
+	}, nil
+}
+
+// Run purges expired documents right away and then every Interval, or at
+// each Schedule time, until ctx is done.
+func (j *Job) Run(ctx context.Context) {
+	if len(j.cfg.Rooms) == 0 {
+		return
+	}
+	if j.deleter == nil {
+		j.logf("search backend cannot delete documents; expired documents are only forgotten locally")
+	}
+	plan := j.cfg.Schedule
+	next := j.now()
+	if plan == nil {
+		plan = schedule.Every(j.cfg.Interval)
+	} else {
+		next = plan.Next(next)
+	}
+	for !next.IsZero() {
+		if !schedule.Wait(ctx, next) {
+			return
+		}
+		stats := j.RunOnce(ctx)
+		if stats.Expired > 0 || stats.Failed > 0 {
+			j.logf("retention pass expired=%d deleted=%d failed=%d", stats.Expired, stats.Deleted, stats.Failed)
+		}
+		next = plan.Next(j.now())
+	}
+}
+
+func (j *Job) delete(ctx context.Context, roomID id.RoomID, rawURL string) error {
+	if deleter, ok := j.deleter.(RoomDeleter); ok {
+		return deleter.DeleteRoomURL(ctx, roomID, rawURL)
+	}
+	return j.deleter.DeleteURL(ctx, rawURL)
+}
+
+// RunOnce expires every room's documents older than its retention period.
+// A room whose documents could not all be deleted from the index keeps its
+// local records, so the next pass tries again.
+func (j *Job) RunOnce(ctx context.Context) Stats {
+	var stats Stats
+	for roomID, maxAge := range j.cfg.Rooms {
+		if ctx.Err() != nil {
+			return stats
+		}
+		cutoff := j.now().Add(-maxAge)
+		expired, err := j.store.ExpiringDocuments(ctx, roomID, cutoff)
+		if err != nil {
+			j.logf("retention lookup failed room=%s err=%v", roomID, err)
+			continue
+		}
+		failed := 0
+		if j.deleter != nil {
+			for _, rawURL := range expired {
+				if err := j.delete(ctx, roomID, rawURL); err != nil {
+					failed++
+					j.logf("retention delete failed room=%s url=%s err=%v", roomID, rawURL, err)
+					continue
+				}
+				stats.Deleted++
+			}
+		}
+		stats.Failed += failed
+		if failed > 0 {
+			continue
+		}
+		if err := j.store.ExpireRoom(ctx, roomID, cutoff); err != nil {
+			j.logf("retention expire failed room=%s err=%v", roomID, err)
+			continue
+		}
+		stats.Expired += len(expired)
+	}
+	return stats
+}
+
+func (j *Job) logf(format string, args ...any) {
+	if j.logger != nil {
+		j.logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,93 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type memoryStore struct {
+	expiring map[id.RoomID][]string
+	expired  []id.RoomID
+	cutoffs  []time.Time
+}
+
+func (m *memoryStore) ExpiringDocuments(_ context.Context, roomID id.RoomID, cutoff time.Time) ([]string, error) {
+	m.cutoffs = append(m.cutoffs, cutoff)
+	return m.expiring[roomID], nil
+}
+
+func (m *memoryStore) ExpireRoom(_ context.Context, roomID id.RoomID, _ time.Time) error {
+	m.expired = append(m.expired, roomID)
+	return nil
+}
+
+type fakeDeleter struct {
+	fail    map[string]bool
+	deleted []string
+}
+
+func (f *fakeDeleter) DeleteURL(_ context.Context, rawURL string) error {
+	if f.fail[rawURL] {
+		return errors.New("unavailable")
+	}
+	f.deleted = append(f.deleted, rawURL)
+	return nil
+}
+
+func TestRunOnceDeletesExpiredDocuments(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{expiring: map[id.RoomID][]string{
+		"!private:example.org": {"https://a.example", "https://b.example"},
+	}}
+	deleter := &fakeDeleter{}
+	job, err := New(Config{Rooms: map[id.RoomID]time.Duration{"!private:example.org": 24 * time.Hour}}, Deps{Store: store, Deleter: deleter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.now = func() time.Time { return now }
+
+	stats := job.RunOnce(context.Background())
+	if stats != (Stats{Expired: 2, Deleted: 2}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(deleter.deleted, want) {
+		t.Fatalf("deleted %v, want %v", deleter.deleted, want)
+	}
+	if want := []id.RoomID{"!private:example.org"}; !reflect.DeepEqual(store.expired, want) {
+		t.Fatalf("expired %v, want %v", store.expired, want)
+	}
+	if want := now.Add(-24 * time.Hour); !store.cutoffs[0].Equal(want) {
+		t.Fatalf("cutoff %v, want %v", store.cutoffs[0], want)
+	}
+}
+
+func TestRunOnceKeepsRoomWhenDeleteFails(t *testing.T) {
+	store := &memoryStore{expiring: map[id.RoomID][]string{
+		"!private:example.org": {"https://a.example", "https://b.example"},
+	}}
+	deleter := &fakeDeleter{fail: map[string]bool{"https://b.example": true}}
+	job, err := New(Config{Rooms: map[id.RoomID]time.Duration{"!private:example.org": time.Hour}}, Deps{Store: store, Deleter: deleter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := job.RunOnce(context.Background())
+	if stats != (Stats{Deleted: 1, Failed: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(store.expired) != 0 {
+		t.Fatalf("expected the room's records to be kept for a retry, expired %v", store.expired)
+	}
+}
+
+func TestNewRejectsNonPositivePeriod(t *testing.T) {
+	_, err := New(Config{Rooms: map[id.RoomID]time.Duration{"!room:example.org": 0}}, Deps{Store: &memoryStore{}})
+	if err == nil {
+		t.Fatal("expected error for zero retention period")
+	}
+}
@@ -0,0 +1,234 @@
+// Package schedule parses cron expressions for the bot's background jobs.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears bounds how far ahead Next looks for a matching time, so
+// impossible dates such as "0 0 30 2 *" cannot loop forever.
+const maxSearchYears = 5
+
+// Schedule yields the run times of a recurring job.
+type Schedule interface {
+	// Next returns the first run time strictly after t, or the zero time
+	// when the schedule never runs again.
+	Next(t time.Time) time.Time
+}
+
+type interval time.Duration
+
+// Every runs a job every d, measured from the previous run.
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// Cron is a parsed five-field cron expression.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record unrestricted day fields: when both day
+	// fields are restricted, a day matching either one runs.
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week accepts 7 as a second Sunday, as most crons do.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression: "minute hour day-of-month month
+// day-of-week" with *, ranges, lists, /steps and month or weekday names, or
+// a macro such as @daily. A leading "CRON_TZ=Zone" selects the time zone;
+// otherwise times are interpreted in loc, or time.Local when loc is nil.
+func Parse(spec string, loc *time.Location) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		zone, rest, _ := strings.Cut(spec, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		tz, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("time zone %q: %w", name, err)
+		}
+		loc, spec = tz, strings.TrimSpace(rest)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	if expanded, ok := macros[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	c := &Cron{loc: loc, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if c.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if c.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if c.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if c.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if c.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	if c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, loc)).IsZero() {
+		return nil, errors.New("schedule never runs")
+	}
+	return c, nil
+}
+
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepExpr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepExpr)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rangeExpr != "*" {
+			first, last, isRange := strings.Cut(rangeExpr, "-")
+			var err error
+			if lo, err = f.value(first); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%s: range %q is backwards", f.name, rangeExpr)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %q is not between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Location returns the time zone the schedule is interpreted in.
+func (c *Cron) Location() *time.Location {
+	return c.loc
+}
+
+// Next returns the first matching minute strictly after t. Wall-clock times
+// skipped by a DST change do not run that day.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, c.loc).Add(time.Minute)
+	limit := next.Year() + maxSearchYears
+
+	// Each jump moves to the start of the next month, day or hour in wall
+	// clock time; advance keeps the search moving forward across DST changes.
+	advance := func(candidate time.Time) {
+		if !candidate.After(next) {
+			candidate = next.Add(time.Minute)
+		}
+		next = candidate
+	}
+	for next.Year() <= limit {
+		y, m, d := next.Date()
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			advance(time.Date(y, m+1, 1, 0, 0, 0, 0, c.loc))
+		case !c.dayMatches(next):
+			advance(time.Date(y, m, d+1, 0, 0, 0, 0, c.loc))
+		case c.hour&(1<<uint(next.Hour())) == 0:
+			advance(time.Date(y, m, d, next.Hour()+1, 0, 0, 0, c.loc))
+		case c.minute&(1<<uint(next.Minute())) == 0:
+			advance(next.Add(time.Minute))
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Wait blocks until until or until ctx is done, and reports whether the time
+// was reached.
+func Wait(ctx context.Context, until time.Time) bool {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
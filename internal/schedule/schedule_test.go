@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	from := time.Date(2024, 3, 29, 10, 30, 0, 0, berlin) // a Friday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 29, 10, 45, 0, 0, berlin)},
+		{"0 9 * * 1-5", time.Date(2024, 4, 1, 9, 0, 0, 0, berlin)},
+		{"30 10 * * fri", time.Date(2024, 4, 5, 10, 30, 0, 0, berlin)},
+		{"0 0 1 jan,jul *", time.Date(2024, 7, 1, 0, 0, 0, 0, berlin)},
+		{"@daily", time.Date(2024, 3, 30, 0, 0, 0, 0, berlin)},
+		{"0 12 * * 7", time.Date(2024, 3, 31, 12, 0, 0, 0, berlin)},
+		// Restricted day-of-month and day-of-week match either one.
+		{"0 8 15 * mon", time.Date(2024, 4, 1, 8, 0, 0, 0, berlin)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, berlin)},
+		{"CRON_TZ=UTC 0 6 * * *", time.Date(2024, 3, 30, 6, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.spec, berlin)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.spec, err)
+		}
+		if got := c.Next(from); !got.Equal(tt.want) {
+			t.Fatalf("Parse(%q).Next() = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextSkipsMissingDSTTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	c, err := Parse("30 2 * * *", berlin)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// 02:30 does not exist on 2024-03-31, when clocks jump from 02:00 to 03:00.
+	got := c.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, berlin))
+	if want := time.Date(2024, 4, 1, 2, 30, 0, 0, berlin); !got.Equal(want) {
+		t.Fatalf("Next() = %s, want %s", got, want)
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"0 0 30 2 *",
+		"CRON_TZ=Mars/Olympus 0 0 * * *",
+	} {
+		if _, err := Parse(spec, time.UTC); err == nil {
+			t.Fatalf("expected Parse(%q) to fail", spec)
+		}
+	}
+}
+
+func TestEvery(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := Every(time.Hour).Next(from); !got.Equal(from.Add(time.Hour)) {
+		t.Fatalf("Every(1h).Next() = %s", got)
+	}
+}
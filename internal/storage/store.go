@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/id"
 
 	_ "modernc.org/sqlite"
@@ -45,6 +47,10 @@ func Open(stateDBPath, cryptoDBPath string) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("initialize state db: %w", err)
 	}
+	if err := addMissingColumns(stateDB, stateColumns()); err != nil {
+		_ = stateDB.Close()
+		return nil, fmt.Errorf("initialize state db: %w", err)
+	}
 
 	cryptoDB, err := openAndInitDB(cryptoDBPath, cryptoDDL())
 	if err != nil {
@@ -77,6 +83,24 @@ func (s *Store) Close() error {
 	return nil
 }
 
+// Ping checks that both databases are still usable.
+func (s *Store) Ping(ctx context.Context) error {
+	if s == nil || s.StateDB == nil || s.CryptoDB == nil {
+		return errors.New("store is not open")
+	}
+	if err := s.StateDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping state db: %w", err)
+	}
+	if err := s.CryptoDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping crypto db: %w", err)
+	}
+	return nil
+}
+
+// Store is the mautrix sync store, so a restart resumes syncing from the
+// last saved batch instead of from scratch.
+var _ mautrix.SyncStore = (*Store)(nil)
+
 // SaveFilterID persists Matrix sync filter IDs for this user.
 func (s *Store) SaveFilterID(ctx context.Context, userID id.UserID, filterID string) error {
 	if s == nil || s.StateDB == nil {
@@ -183,6 +207,1246 @@ func (s *Store) GetBotState(ctx context.Context, key string) (string, error) {
 	return value, nil
 }
 
+// AddDocumentTags records tags for an indexed URL. Existing tags are kept.
+func (s *Store) AddDocumentTags(ctx context.Context, rawURL string, tags []string) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin add document tags: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, tag := range tags {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO document_tags (url, tag)
+			VALUES (?, ?)
+			ON CONFLICT(url, tag) DO NOTHING
+		`, rawURL, tag)
+		if err != nil {
+			return fmt.Errorf("add document tag: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit document tags: %w", err)
+	}
+	return nil
+}
+
+// SharedLink records who shared an indexed URL, where and when.
+type SharedLink struct {
+	URL      string
+	RoomID   id.RoomID
+	EventID  id.EventID
+	Sender   id.UserID
+	SharedAt time.Time
+}
+
+// LinkFilter selects recorded links. Senders match a full user ID, or a
+// localpart when given without a server name (e.g. "@alice"); Rooms match the
+// room IDs links were shared in. Tags are kept per URL rather than per room,
+// so with Rooms they match links shared in those rooms that were tagged
+// anywhere. Zero fields don't filter.
+type LinkFilter struct {
+	Tags    []string
+	Senders []string
+	Rooms   []string
+	After   time.Time
+	Before  time.Time
+	Limit   int
+}
+
+// RecordSharedLink stores sharing metadata for an indexed URL.
+func (s *Store) RecordSharedLink(ctx context.Context, link SharedLink) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO shared_links (url, room_id, event_id, sender, shared_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(url, event_id) DO NOTHING
+	`, link.URL, string(link.RoomID), string(link.EventID), string(link.Sender), link.SharedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("record shared link: %w", err)
+	}
+	return nil
+}
+
+// RecordRoomDocument notes that rawURL, a document private to roomID such as
+// an indexed chat message or summary, was indexed at indexedAt.
+func (s *Store) RecordRoomDocument(ctx context.Context, roomID id.RoomID, rawURL string, indexedAt time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO room_documents (url, room_id, indexed_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(url, room_id) DO UPDATE SET indexed_at = excluded.indexed_at
+	`, rawURL, string(roomID), indexedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("record room document: %w", err)
+	}
+	return nil
+}
+
+// DocumentRooms returns the rooms that shared rawURL or hold it as a room
+// document, in order.
+func (s *Store) DocumentRooms(ctx context.Context, rawURL string) ([]id.RoomID, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT room_id FROM shared_links WHERE url = ?
+		UNION
+		SELECT room_id FROM room_documents WHERE url = ?
+		ORDER BY room_id
+	`, rawURL, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("query document rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var out []id.RoomID
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err != nil {
+			return nil, fmt.Errorf("scan document room: %w", err)
+		}
+		out = append(out, id.RoomID(roomID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate document rooms: %w", err)
+	}
+	return out, nil
+}
+
+// expiringDocumentsQuery selects the URLs that only rows of one room older
+// than a cutoff refer to. Its parameters are the room and cutoff, six times.
+const expiringDocumentsQuery = `
+	SELECT url FROM (
+		SELECT url FROM shared_links WHERE room_id = ? AND shared_at < ?
+		UNION
+		SELECT url FROM room_documents WHERE room_id = ? AND indexed_at < ?
+	) AS expired
+	WHERE NOT EXISTS (
+		SELECT 1 FROM shared_links l
+		WHERE l.url = expired.url AND NOT (l.room_id = ? AND l.shared_at < ?)
+	) AND NOT EXISTS (
+		SELECT 1 FROM room_documents d
+		WHERE d.url = expired.url AND NOT (d.room_id = ? AND d.indexed_at < ?)
+	)
+	ORDER BY url
+`
+
+// ExpiringDocuments returns the URLs ExpireRoom would forget entirely: those
+// roomID contributed before cutoff that no other room or newer message still
+// refers to. They should be removed from the index before ExpireRoom runs.
+func (s *Store) ExpiringDocuments(ctx context.Context, roomID id.RoomID, cutoff time.Time) ([]string, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	return expiringDocuments(ctx, s.StateDB, roomID, cutoff)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func expiringDocuments(ctx context.Context, db queryer, roomID id.RoomID, cutoff time.Time) ([]string, error) {
+	room, before := string(roomID), cutoff.UnixMilli()
+	rows, err := db.QueryContext(ctx, expiringDocumentsQuery, room, before, room, before, room, before, room, before)
+	if err != nil {
+		return nil, fmt.Errorf("query expiring documents: %w", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			return nil, fmt.Errorf("scan expiring document: %w", err)
+		}
+		out = append(out, rawURL)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate expiring documents: %w", err)
+	}
+	return out, nil
+}
+
+// ExpireRoom forgets what roomID contributed before cutoff: its shared links,
+// room documents, searches and thread queries, and the titles, tags and
+// check records of the URLs ExpiringDocuments returns.
+func (s *Store) ExpireRoom(ctx context.Context, roomID id.RoomID, cutoff time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin expire room: %w", err)
+	}
+	defer tx.Rollback()
+
+	orphaned, err := expiringDocuments(ctx, tx, roomID, cutoff)
+	if err != nil {
+		return err
+	}
+	if err := forgetDocuments(ctx, tx, orphaned); err != nil {
+		return err
+	}
+	room, before := string(roomID), cutoff.UnixMilli()
+	for _, stmt := range []string{
+		`DELETE FROM shared_links WHERE room_id = ? AND shared_at < ?`,
+		`DELETE FROM room_documents WHERE room_id = ? AND indexed_at < ?`,
+		`DELETE FROM search_results WHERE query_id IN (SELECT id FROM search_queries WHERE room_id = ? AND searched_at < ?)`,
+		`DELETE FROM search_queries WHERE room_id = ? AND searched_at < ?`,
+		`DELETE FROM thread_queries WHERE room_id = ? AND updated_at < ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, room, before); err != nil {
+			return fmt.Errorf("expire room: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit expire room: %w", err)
+	}
+	return nil
+}
+
+// ForgetEvent forgets the links eventID shared in roomID and the room
+// documents among documents, such as the event's indexed chat message. It
+// returns the URLs nothing refers to any more, whose titles, tags and check
+// records it drops as well.
+func (s *Store) ForgetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, documents ...string) ([]string, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin forget event: %w", err)
+	}
+	defer tx.Rollback()
+
+	candidates := append([]string(nil), documents...)
+	rows, err := tx.QueryContext(ctx, `SELECT url FROM shared_links WHERE room_id = ? AND event_id = ?`, string(roomID), string(eventID))
+	if err != nil {
+		return nil, fmt.Errorf("query event links: %w", err)
+	}
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan event link: %w", err)
+		}
+		candidates = append(candidates, rawURL)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("iterate event links: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM shared_links WHERE room_id = ? AND event_id = ?`, string(roomID), string(eventID)); err != nil {
+		return nil, fmt.Errorf("forget event links: %w", err)
+	}
+	for _, rawURL := range documents {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM room_documents WHERE room_id = ? AND url = ?`, string(roomID), rawURL); err != nil {
+			return nil, fmt.Errorf("forget room document: %w", err)
+		}
+	}
+
+	var orphaned []string
+	for _, rawURL := range candidates {
+		var referenced bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM shared_links WHERE url = ?)
+			    OR EXISTS (SELECT 1 FROM room_documents WHERE url = ?)
+		`, rawURL, rawURL).Scan(&referenced)
+		if err != nil {
+			return nil, fmt.Errorf("check document references: %w", err)
+		}
+		if !referenced && !slices.Contains(orphaned, rawURL) {
+			orphaned = append(orphaned, rawURL)
+		}
+	}
+	if err := forgetDocuments(ctx, tx, orphaned); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit forget event: %w", err)
+	}
+	return orphaned, nil
+}
+
+// forgetDocuments drops what is stored about urls besides who shared them.
+func forgetDocuments(ctx context.Context, tx *sql.Tx, urls []string) error {
+	for _, rawURL := range urls {
+		for _, table := range []string{"document_tags", "indexed_documents", "indexed_urls", "link_checks", "document_titles"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE url = ?`, rawURL); err != nil {
+				return fmt.Errorf("forget document: %w", err)
+			}
+		}
+		// URLs indexed into a named index are logged after its name.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM indexed_urls WHERE url LIKE ? ESCAPE '\'`, "% "+escapeLike(rawURL)); err != nil {
+			return fmt.Errorf("forget document: %w", err)
+		}
+	}
+	return nil
+}
+
+// FindLinks returns URLs matching filter, most recently shared or tagged first.
+func (s *Store) FindLinks(ctx context.Context, filter LinkFilter) ([]string, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+
+	var (
+		query string
+		where []string
+		args  []any
+	)
+	if len(filter.Senders) == 0 && len(filter.Rooms) == 0 && filter.After.IsZero() && filter.Before.IsZero() {
+		// Tag-only lookups don't need sharing metadata.
+		query = `SELECT url FROM document_tags`
+		if len(filter.Tags) == 0 {
+			return nil, nil
+		}
+	} else {
+		query = `SELECT url FROM shared_links`
+		if len(filter.Senders) > 0 {
+			senders := make([]string, 0, len(filter.Senders))
+			for _, sender := range filter.Senders {
+				if strings.Contains(sender, ":") {
+					senders = append(senders, "sender = ?")
+					args = append(args, sender)
+				} else {
+					senders = append(senders, `sender LIKE ? ESCAPE '\'`)
+					args = append(args, escapeLike(sender)+":%")
+				}
+			}
+			where = append(where, "("+strings.Join(senders, " OR ")+")")
+		}
+		if len(filter.Rooms) > 0 {
+			where = append(where, "room_id IN ("+placeholders(len(filter.Rooms))+")")
+			for _, room := range filter.Rooms {
+				args = append(args, room)
+			}
+		}
+		if !filter.After.IsZero() {
+			where = append(where, "shared_at >= ?")
+			args = append(args, filter.After.UnixMilli())
+		}
+		if !filter.Before.IsZero() {
+			where = append(where, "shared_at < ?")
+			args = append(args, filter.Before.UnixMilli())
+		}
+	}
+	if len(filter.Tags) > 0 {
+		where = append(where, `url IN (
+			SELECT url FROM document_tags
+			WHERE tag IN (`+placeholders(len(filter.Tags))+`)
+			GROUP BY url
+			HAVING COUNT(DISTINCT tag) = ?
+		)`)
+		for _, tag := range filter.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(filter.Tags))
+	}
+
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY url ORDER BY MAX(rowid) DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.StateDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query links: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, fmt.Errorf("scan link: %w", err)
+		}
+		urls = append(urls, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate links: %w", err)
+	}
+	return urls, nil
+}
+
+// IndexedDocument tracks what was last submitted to Hister for a URL, so it
+// can be refreshed when the page changes.
+type IndexedDocument struct {
+	URL          string
+	ContentHash  string
+	ETag         string
+	LastModified string
+	// IndexedAt is when the content was last submitted; CheckedAt is when
+	// the page was last fetched, changed or not.
+	IndexedAt time.Time
+	CheckedAt time.Time
+}
+
+// RecordIndexedDocument inserts or replaces the tracking row for doc.URL.
+func (s *Store) RecordIndexedDocument(ctx context.Context, doc IndexedDocument) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO indexed_documents (url, content_hash, etag, last_modified, indexed_at, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			content_hash = excluded.content_hash,
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			indexed_at = excluded.indexed_at,
+			checked_at = excluded.checked_at
+	`, doc.URL, doc.ContentHash, doc.ETag, doc.LastModified, doc.IndexedAt.UnixMilli(), doc.CheckedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("record indexed document: %w", err)
+	}
+	return nil
+}
+
+// StaleDocuments returns up to limit documents last checked before cutoff,
+// least recently checked first.
+func (s *Store) StaleDocuments(ctx context.Context, cutoff time.Time, limit int) ([]IndexedDocument, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT url, content_hash, etag, last_modified, indexed_at, checked_at
+		FROM indexed_documents
+		WHERE checked_at < ?
+		ORDER BY checked_at
+		LIMIT ?
+	`, cutoff.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query stale documents: %w", err)
+	}
+	defer rows.Close()
+
+	var out []IndexedDocument
+	for rows.Next() {
+		var (
+			doc                  IndexedDocument
+			indexedAt, checkedAt int64
+		)
+		if err := rows.Scan(&doc.URL, &doc.ContentHash, &doc.ETag, &doc.LastModified, &indexedAt, &checkedAt); err != nil {
+			return nil, fmt.Errorf("scan stale document: %w", err)
+		}
+		doc.IndexedAt = time.UnixMilli(indexedAt)
+		doc.CheckedAt = time.UnixMilli(checkedAt)
+		out = append(out, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate stale documents: %w", err)
+	}
+	return out, nil
+}
+
+// MarkURLIndexed records that rawURL was submitted to the search index at
+// indexedAt.
+func (s *Store) MarkURLIndexed(ctx context.Context, rawURL string, indexedAt time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO indexed_urls (url, indexed_at) VALUES (?, ?)
+		ON CONFLICT(url) DO UPDATE SET indexed_at = excluded.indexed_at
+	`, rawURL, indexedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("mark url indexed: %w", err)
+	}
+	return nil
+}
+
+// URLIndexedAt returns when rawURL was last submitted to the search index,
+// or the zero time if it never was.
+func (s *Store) URLIndexedAt(ctx context.Context, rawURL string) (time.Time, error) {
+	if s == nil || s.StateDB == nil {
+		return time.Time{}, errors.New("state db is not initialized")
+	}
+	var indexedAt int64
+	err := s.StateDB.QueryRowContext(ctx, `SELECT indexed_at FROM indexed_urls WHERE url = ?`, rawURL).Scan(&indexedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query url indexed: %w", err)
+	}
+	return time.UnixMilli(indexedAt), nil
+}
+
+// ForgetIndexedURL forgets that rawURL was indexed, e.g. once it was deleted
+// from the search index.
+func (s *Store) ForgetIndexedURL(ctx context.Context, rawURL string) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	if _, err := s.StateDB.ExecContext(ctx, `DELETE FROM indexed_urls WHERE url = ?`, rawURL); err != nil {
+		return fmt.Errorf("forget indexed url: %w", err)
+	}
+	return nil
+}
+
+// Watch is a saved search whose new results are posted in the thread of the
+// message that created it.
+type Watch struct {
+	ID        int64
+	RoomID    id.RoomID
+	EventID   id.EventID
+	UserID    id.UserID
+	Query     string
+	CreatedAt time.Time
+}
+
+// AddWatch saves a watch and returns its ID.
+func (s *Store) AddWatch(ctx context.Context, w Watch) (int64, error) {
+	if s == nil || s.StateDB == nil {
+		return 0, errors.New("state db is not initialized")
+	}
+	res, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO watches (room_id, event_id, user_id, query, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, string(w.RoomID), string(w.EventID), string(w.UserID), w.Query, w.CreatedAt.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("add watch: %w", err)
+	}
+	watchID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("add watch: %w", err)
+	}
+	return watchID, nil
+}
+
+// RemoveWatch deletes userID's watch and reports whether it existed.
+func (s *Store) RemoveWatch(ctx context.Context, userID id.UserID, watchID int64) (bool, error) {
+	if s == nil || s.StateDB == nil {
+		return false, errors.New("state db is not initialized")
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin remove watch: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM watches WHERE id = ? AND user_id = ?`, watchID, string(userID))
+	if err != nil {
+		return false, fmt.Errorf("remove watch: %w", err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("remove watch: %w", err)
+	}
+	if removed == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM watch_seen WHERE watch_id = ?`, watchID); err != nil {
+		return false, fmt.Errorf("remove watch results: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit remove watch: %w", err)
+	}
+	return true, nil
+}
+
+// ListWatches returns userID's watches, or every watch when userID is empty,
+// oldest first.
+func (s *Store) ListWatches(ctx context.Context, userID id.UserID) ([]Watch, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	query := `SELECT id, room_id, event_id, user_id, query, created_at FROM watches`
+	var args []any
+	if userID != "" {
+		query += ` WHERE user_id = ?`
+		args = append(args, string(userID))
+	}
+	rows, err := s.StateDB.QueryContext(ctx, query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query watches: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Watch
+	for rows.Next() {
+		var (
+			w                       Watch
+			roomID, eventID, userID string
+			createdAt               int64
+		)
+		if err := rows.Scan(&w.ID, &roomID, &eventID, &userID, &w.Query, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan watch: %w", err)
+		}
+		w.RoomID = id.RoomID(roomID)
+		w.EventID = id.EventID(eventID)
+		w.UserID = id.UserID(userID)
+		w.CreatedAt = time.UnixMilli(createdAt)
+		out = append(out, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate watches: %w", err)
+	}
+	return out, nil
+}
+
+// MarkWatchSeen records URLs already reported for a watch.
+func (s *Store) MarkWatchSeen(ctx context.Context, watchID int64, urls []string) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin mark watch seen: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, rawURL := range urls {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO watch_seen (watch_id, url)
+			VALUES (?, ?)
+			ON CONFLICT(watch_id, url) DO NOTHING
+		`, watchID, rawURL)
+		if err != nil {
+			return fmt.Errorf("mark watch seen: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit watch seen: %w", err)
+	}
+	return nil
+}
+
+// WatchSeen returns which of urls were already reported for a watch.
+func (s *Store) WatchSeen(ctx context.Context, watchID int64, urls []string) (map[string]struct{}, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	seen := make(map[string]struct{})
+	if len(urls) == 0 {
+		return seen, nil
+	}
+	args := []any{watchID}
+	for _, rawURL := range urls {
+		args = append(args, rawURL)
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT url FROM watch_seen WHERE watch_id = ? AND url IN (`+placeholders(len(urls))+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query watch seen: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			return nil, fmt.Errorf("scan watch seen: %w", err)
+		}
+		seen[rawURL] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate watch seen: %w", err)
+	}
+	return seen, nil
+}
+
+// Reminder is a message to post in a thread at DueAt, mentioning UserID.
+type Reminder struct {
+	ID      int64
+	RoomID  id.RoomID
+	EventID id.EventID
+	UserID  id.UserID
+	Text    string
+	DueAt   time.Time
+}
+
+// AddReminder saves a reminder and returns its ID.
+func (s *Store) AddReminder(ctx context.Context, r Reminder) (int64, error) {
+	if s == nil || s.StateDB == nil {
+		return 0, errors.New("state db is not initialized")
+	}
+	res, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO reminders (room_id, event_id, user_id, text, due_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, string(r.RoomID), string(r.EventID), string(r.UserID), r.Text, r.DueAt.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("add reminder: %w", err)
+	}
+	reminderID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("add reminder: %w", err)
+	}
+	return reminderID, nil
+}
+
+// DueReminders returns up to limit reminders due at or before now, earliest
+// first.
+func (s *Store) DueReminders(ctx context.Context, now time.Time, limit int) ([]Reminder, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT id, room_id, event_id, user_id, text, due_at
+		FROM reminders
+		WHERE due_at <= ?
+		ORDER BY due_at
+		LIMIT ?
+	`, now.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query due reminders: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Reminder
+	for rows.Next() {
+		var (
+			r                       Reminder
+			roomID, eventID, userID string
+			dueAt                   int64
+		)
+		if err := rows.Scan(&r.ID, &roomID, &eventID, &userID, &r.Text, &dueAt); err != nil {
+			return nil, fmt.Errorf("scan reminder: %w", err)
+		}
+		r.RoomID = id.RoomID(roomID)
+		r.EventID = id.EventID(eventID)
+		r.UserID = id.UserID(userID)
+		r.DueAt = time.UnixMilli(dueAt)
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate reminders: %w", err)
+	}
+	return out, nil
+}
+
+// DeleteReminder removes a reminder once it was posted.
+func (s *Store) DeleteReminder(ctx context.Context, reminderID int64) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	if _, err := s.StateDB.ExecContext(ctx, `DELETE FROM reminders WHERE id = ?`, reminderID); err != nil {
+		return fmt.Errorf("delete reminder: %w", err)
+	}
+	return nil
+}
+
+// LinkCheck is the outcome of checking whether an indexed URL still exists.
+// DeadSince is when the link was first found dead, zero while it is alive.
+type LinkCheck struct {
+	URL       string
+	Dead      bool
+	Reason    string
+	CheckedAt time.Time
+	DeadSince time.Time
+}
+
+// LinksToCheck returns up to limit shared or indexed URLs not checked since
+// cutoff, never-checked and least recently checked first.
+func (s *Store) LinksToCheck(ctx context.Context, cutoff time.Time, limit int) ([]string, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT links.url
+		FROM (SELECT url FROM shared_links UNION SELECT url FROM indexed_documents) AS links
+		LEFT JOIN link_checks ON link_checks.url = links.url
+		WHERE link_checks.checked_at IS NULL OR link_checks.checked_at < ?
+		ORDER BY COALESCE(link_checks.checked_at, 0)
+		LIMIT ?
+	`, cutoff.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query links to check: %w", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			return nil, fmt.Errorf("scan link to check: %w", err)
+		}
+		out = append(out, rawURL)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate links to check: %w", err)
+	}
+	return out, nil
+}
+
+// RecordLinkCheck stores a check result and returns the stored state. A link
+// that stays dead keeps its original DeadSince.
+func (s *Store) RecordLinkCheck(ctx context.Context, check LinkCheck) (LinkCheck, error) {
+	if s == nil || s.StateDB == nil {
+		return LinkCheck{}, errors.New("state db is not initialized")
+	}
+	var deadSince int64
+	if check.Dead {
+		deadSince = check.CheckedAt.UnixMilli()
+	}
+	err := s.StateDB.QueryRowContext(ctx, `
+		INSERT INTO link_checks (url, dead, reason, checked_at, dead_since)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			dead = excluded.dead,
+			reason = excluded.reason,
+			checked_at = excluded.checked_at,
+			dead_since = CASE
+				WHEN excluded.dead = 0 THEN 0
+				WHEN link_checks.dead = 1 THEN link_checks.dead_since
+				ELSE excluded.dead_since
+			END
+		RETURNING dead_since
+	`, check.URL, check.Dead, check.Reason, check.CheckedAt.UnixMilli(), deadSince).Scan(&deadSince)
+	if err != nil {
+		return LinkCheck{}, fmt.Errorf("record link check: %w", err)
+	}
+	check.DeadSince = time.Time{}
+	if deadSince != 0 {
+		check.DeadSince = time.UnixMilli(deadSince)
+	}
+	return check, nil
+}
+
+// MarkLinkChecked records an inconclusive check of rawURL, keeping its
+// previous dead or alive state.
+func (s *Store) MarkLinkChecked(ctx context.Context, rawURL string, checkedAt time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO link_checks (url, dead, checked_at)
+		VALUES (?, 0, ?)
+		ON CONFLICT(url) DO UPDATE SET checked_at = excluded.checked_at
+	`, rawURL, checkedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("mark link checked: %w", err)
+	}
+	return nil
+}
+
+// DeadLinks returns which of urls were found dead on their last check.
+func (s *Store) DeadLinks(ctx context.Context, urls []string) (map[string]struct{}, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	dead := make(map[string]struct{})
+	if len(urls) == 0 {
+		return dead, nil
+	}
+	args := make([]any, 0, len(urls))
+	for _, rawURL := range urls {
+		args = append(args, rawURL)
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT url FROM link_checks WHERE dead = 1 AND url IN (`+placeholders(len(urls))+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query dead links: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			return nil, fmt.Errorf("scan dead link: %w", err)
+		}
+		dead[rawURL] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate dead links: %w", err)
+	}
+	return dead, nil
+}
+
+// Search records a search run in a room and the result URLs it returned.
+type Search struct {
+	RoomID     id.RoomID
+	Sender     id.UserID
+	Query      string
+	URLs       []string
+	SearchedAt time.Time
+}
+
+// LinkCount is how often a URL was shared or returned by searches.
+type LinkCount struct {
+	URL   string
+	Count int
+}
+
+// RecordSearch adds a search and its results to the query history.
+func (s *Store) RecordSearch(ctx context.Context, search Search) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin record search: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO search_queries (room_id, sender, query, searched_at)
+		VALUES (?, ?, ?, ?)
+	`, string(search.RoomID), string(search.Sender), search.Query, search.SearchedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("record search: %w", err)
+	}
+	queryID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("record search: %w", err)
+	}
+	for rank, rawURL := range search.URLs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO search_results (query_id, url, rank)
+			VALUES (?, ?, ?)
+			ON CONFLICT(query_id, url) DO NOTHING
+		`, queryID, rawURL, rank+1)
+		if err != nil {
+			return fmt.Errorf("record search result: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit search: %w", err)
+	}
+	return nil
+}
+
+// TopSharedLinks returns up to limit URLs shared most often in roomID since
+// since, most shared first.
+func (s *Store) TopSharedLinks(ctx context.Context, roomID id.RoomID, since time.Time, limit int) ([]LinkCount, error) {
+	return s.topLinks(ctx, "shared", `
+		SELECT url, COUNT(*) AS n
+		FROM shared_links
+		WHERE room_id = ? AND shared_at >= ?
+		GROUP BY url
+		ORDER BY n DESC, MAX(shared_at) DESC
+		LIMIT ?
+	`, string(roomID), since.UnixMilli(), limit)
+}
+
+// TopSearchedLinks returns up to limit URLs returned most often by searches
+// in roomID since since, most searched first.
+func (s *Store) TopSearchedLinks(ctx context.Context, roomID id.RoomID, since time.Time, limit int) ([]LinkCount, error) {
+	return s.topLinks(ctx, "searched", `
+		SELECT search_results.url, COUNT(*) AS n
+		FROM search_results
+		JOIN search_queries ON search_queries.id = search_results.query_id
+		WHERE search_queries.room_id = ? AND search_queries.searched_at >= ?
+		GROUP BY search_results.url
+		ORDER BY n DESC, MIN(search_results.rank)
+		LIMIT ?
+	`, string(roomID), since.UnixMilli(), limit)
+}
+
+func (s *Store) topLinks(ctx context.Context, kind, query string, args ...any) ([]LinkCount, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query top %s links: %w", kind, err)
+	}
+	defer rows.Close()
+
+	var out []LinkCount
+	for rows.Next() {
+		var c LinkCount
+		if err := rows.Scan(&c.URL, &c.Count); err != nil {
+			return nil, fmt.Errorf("scan top %s link: %w", kind, err)
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate top %s links: %w", kind, err)
+	}
+	return out, nil
+}
+
+// DocumentTitle is the last known title of an indexed URL.
+type DocumentTitle struct {
+	URL   string
+	Title string
+}
+
+// RecordDocumentTitle stores or updates rawURL's title.
+func (s *Store) RecordDocumentTitle(ctx context.Context, rawURL, title string, seenAt time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO document_titles (url, title, seen_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET title = excluded.title, seen_at = excluded.seen_at
+	`, rawURL, title, seenAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("record document title: %w", err)
+	}
+	return nil
+}
+
+// SaveThreadQuery remembers query as the search behind the results thread
+// rooted at root, replacing any earlier one.
+func (s *Store) SaveThreadQuery(ctx context.Context, roomID id.RoomID, root id.EventID, query string, at time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO thread_queries (room_id, thread_root, query, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(room_id, thread_root) DO UPDATE SET query = excluded.query, updated_at = excluded.updated_at
+	`, string(roomID), string(root), query, at.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("save thread query: %w", err)
+	}
+	return nil
+}
+
+// ThreadQuery returns the search behind the results thread rooted at root,
+// or "" if the thread has none.
+func (s *Store) ThreadQuery(ctx context.Context, roomID id.RoomID, root id.EventID) (string, error) {
+	if s == nil || s.StateDB == nil {
+		return "", errors.New("state db is not initialized")
+	}
+	var query string
+	err := s.StateDB.QueryRowContext(ctx, `
+		SELECT query FROM thread_queries WHERE room_id = ? AND thread_root = ?
+	`, string(roomID), string(root)).Scan(&query)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("query thread query: %w", err)
+	}
+	return query, nil
+}
+
+// DocumentTitles returns up to limit document titles, most recently seen
+// first.
+func (s *Store) DocumentTitles(ctx context.Context, limit int) ([]DocumentTitle, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT url, title FROM document_titles ORDER BY seen_at DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query document titles: %w", err)
+	}
+	defer rows.Close()
+
+	var out []DocumentTitle
+	for rows.Next() {
+		var d DocumentTitle
+		if err := rows.Scan(&d.URL, &d.Title); err != nil {
+			return nil, fmt.Errorf("scan document title: %w", err)
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate document titles: %w", err)
+	}
+	return out, nil
+}
+
+// DocumentCount returns how many distinct URLs the bot has shared, indexed
+// or seen in search results.
+func (s *Store) DocumentCount(ctx context.Context) (int, error) {
+	if s == nil || s.StateDB == nil {
+		return 0, errors.New("state db is not initialized")
+	}
+	var n int
+	err := s.StateDB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT url FROM shared_links
+			UNION SELECT url FROM indexed_documents
+			UNION SELECT url FROM document_titles
+		)
+	`).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count documents: %w", err)
+	}
+	return n, nil
+}
+
+// OutboxMessage is a notice waiting to be sent to a room. InReplyTo and
+// Thread are set for replies.
+type OutboxMessage struct {
+	ID            int64
+	RoomID        id.RoomID
+	InReplyTo     id.EventID
+	Body          string
+	FormattedBody string
+	Thread        bool
+	// Kind is the reply kind that selects the message's msgtype. Replaces
+	// is the event the message edits, if any.
+	Kind          string
+	Replaces      id.EventID
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+}
+
+// EnqueueMessage saves m for delivery and returns its ID.
+func (s *Store) EnqueueMessage(ctx context.Context, m OutboxMessage) (int64, error) {
+	if s == nil || s.StateDB == nil {
+		return 0, errors.New("state db is not initialized")
+	}
+	res, err := s.StateDB.ExecContext(ctx, `
+		INSERT INTO outbox (room_id, in_reply_to, body, formatted_body, thread, kind, replaces, created_at, attempts, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, string(m.RoomID), string(m.InReplyTo), m.Body, m.FormattedBody, m.Thread, m.Kind, string(m.Replaces),
+		m.CreatedAt.UnixMilli(), m.Attempts, m.NextAttemptAt.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("enqueue message: %w", err)
+	}
+	messageID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("enqueue message: %w", err)
+	}
+	return messageID, nil
+}
+
+// DueMessages returns up to limit queued messages whose next attempt is at or
+// before now, oldest first.
+func (s *Store) DueMessages(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	if s == nil || s.StateDB == nil {
+		return nil, errors.New("state db is not initialized")
+	}
+	rows, err := s.StateDB.QueryContext(ctx, `
+		SELECT id, room_id, in_reply_to, body, formatted_body, thread, kind, replaces, created_at, attempts, next_attempt_at
+		FROM outbox
+		WHERE next_attempt_at <= ?
+		ORDER BY id
+		LIMIT ?
+	`, now.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var out []OutboxMessage
+	for rows.Next() {
+		var (
+			m                      OutboxMessage
+			roomID, inReplyTo      string
+			replaces               string
+			createdAt, nextAttempt int64
+		)
+		if err := rows.Scan(&m.ID, &roomID, &inReplyTo, &m.Body, &m.FormattedBody, &m.Thread, &m.Kind, &replaces, &createdAt, &m.Attempts, &nextAttempt); err != nil {
+			return nil, fmt.Errorf("scan outbox message: %w", err)
+		}
+		m.RoomID = id.RoomID(roomID)
+		m.InReplyTo = id.EventID(inReplyTo)
+		m.Replaces = id.EventID(replaces)
+		m.CreatedAt = time.UnixMilli(createdAt)
+		m.NextAttemptAt = time.UnixMilli(nextAttempt)
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate outbox: %w", err)
+	}
+	return out, nil
+}
+
+// RescheduleMessage records a failed delivery attempt and when to try again.
+func (s *Store) RescheduleMessage(ctx context.Context, messageID int64, attempts int, next time.Time) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	_, err := s.StateDB.ExecContext(ctx, `
+		UPDATE outbox SET attempts = ?, next_attempt_at = ? WHERE id = ?
+	`, attempts, next.UnixMilli(), messageID)
+	if err != nil {
+		return fmt.Errorf("reschedule message: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessage removes a message once it was sent or given up on.
+func (s *Store) DeleteMessage(ctx context.Context, messageID int64) error {
+	if s == nil || s.StateDB == nil {
+		return errors.New("state db is not initialized")
+	}
+	if _, err := s.StateDB.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, messageID); err != nil {
+		return fmt.Errorf("delete message: %w", err)
+	}
+	return nil
+}
+
+// processedEventsPerRoom is how many handled events are remembered per bot
+// user and room, far more than a sync replays after a restart.
+const processedEventsPerRoom = 1000
+
+// MarkEventProcessed records that userID handled eventID in roomID and
+// reports whether it was not recorded before. Only the latest
+// processedEventsPerRoom events of each room are kept.
+func (s *Store) MarkEventProcessed(ctx context.Context, userID id.UserID, roomID id.RoomID, eventID id.EventID) (bool, error) {
+	if s == nil || s.StateDB == nil {
+		return false, errors.New("state db is not initialized")
+	}
+	tx, err := s.StateDB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin mark event processed: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO processed_events (user_id, room_id, event_id, processed_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, room_id, event_id) DO NOTHING
+	`, string(userID), string(roomID), string(eventID), time.Now().UnixMilli())
+	if err != nil {
+		return false, fmt.Errorf("mark event processed: %w", err)
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("mark event processed: %w", err)
+	}
+	if added == 0 {
+		return false, nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM processed_events
+		WHERE user_id = ? AND room_id = ? AND rowid NOT IN (
+			SELECT rowid FROM processed_events
+			WHERE user_id = ? AND room_id = ?
+			ORDER BY rowid DESC
+			LIMIT ?
+		)
+	`, string(userID), string(roomID), string(userID), string(roomID), processedEventsPerRoom)
+	if err != nil {
+		return false, fmt.Errorf("prune processed events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit mark event processed: %w", err)
+	}
+	return true, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
 func openAndInitDB(path string, ddl []string) (*sql.DB, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
 		return nil, fmt.Errorf("ensure db directory: %w", err)
@@ -212,6 +1476,41 @@ func openAndInitDB(path string, ddl []string) (*sql.DB, error) {
 	return db, nil
 }
 
+// column is a column added to a table after the table was first released.
+// It is also part of the table's CREATE TABLE statement.
+type column struct {
+	table, name, definition string
+}
+
+// stateColumns are the state DB columns that databases created by older
+// versions lack.
+func stateColumns() []column {
+	return []column{
+		{table: "outbox", name: "kind", definition: "TEXT NOT NULL DEFAULT ''"},
+		{table: "outbox", name: "replaces", definition: "TEXT NOT NULL DEFAULT ''"},
+	}
+}
+
+// addMissingColumns adds the columns an existing database does not have yet.
+func addMissingColumns(db *sql.DB, columns []column) error {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	for _, c := range columns {
+		var exists bool
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, c.table, c.name).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("inspect %s.%s: %w", c.table, c.name, err)
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+	return nil
+}
+
 func stateDDL() []string {
 	return []string{
 		`CREATE TABLE IF NOT EXISTS bot_state (
@@ -225,6 +1524,122 @@ func stateDDL() []string {
 			next_batch TEXT,
 			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
+		`CREATE TABLE IF NOT EXISTS document_tags (
+			url TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (url, tag)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_document_tags_tag ON document_tags (tag);`,
+		`CREATE TABLE IF NOT EXISTS shared_links (
+			url TEXT NOT NULL,
+			room_id TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			shared_at INTEGER NOT NULL,
+			PRIMARY KEY (url, event_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_shared_links_sender ON shared_links (sender);`,
+		`CREATE INDEX IF NOT EXISTS idx_shared_links_shared_at ON shared_links (shared_at);`,
+		`CREATE TABLE IF NOT EXISTS room_documents (
+			url TEXT NOT NULL,
+			room_id TEXT NOT NULL,
+			indexed_at INTEGER NOT NULL,
+			PRIMARY KEY (url, room_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_room_documents_room_indexed_at ON room_documents (room_id, indexed_at);`,
+		`CREATE TABLE IF NOT EXISTS indexed_documents (
+			url TEXT PRIMARY KEY,
+			content_hash TEXT NOT NULL,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			indexed_at INTEGER NOT NULL,
+			checked_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_indexed_documents_checked_at ON indexed_documents (checked_at);`,
+		`CREATE TABLE IF NOT EXISTS indexed_urls (
+			url TEXT PRIMARY KEY,
+			indexed_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS watches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			room_id TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			query TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_watches_user_id ON watches (user_id);`,
+		`CREATE TABLE IF NOT EXISTS watch_seen (
+			watch_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			PRIMARY KEY (watch_id, url)
+		);`,
+		`CREATE TABLE IF NOT EXISTS reminders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			room_id TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			text TEXT NOT NULL,
+			due_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_reminders_due_at ON reminders (due_at);`,
+		`CREATE TABLE IF NOT EXISTS link_checks (
+			url TEXT PRIMARY KEY,
+			dead INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			checked_at INTEGER NOT NULL,
+			dead_since INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_link_checks_checked_at ON link_checks (checked_at);`,
+		`CREATE TABLE IF NOT EXISTS search_queries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			room_id TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			query TEXT NOT NULL,
+			searched_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_search_queries_room_searched_at ON search_queries (room_id, searched_at);`,
+		`CREATE TABLE IF NOT EXISTS search_results (
+			query_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			rank INTEGER NOT NULL,
+			PRIMARY KEY (query_id, url)
+		);`,
+		`CREATE TABLE IF NOT EXISTS document_titles (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL,
+			seen_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_document_titles_seen_at ON document_titles (seen_at);`,
+		`CREATE TABLE IF NOT EXISTS thread_queries (
+			room_id TEXT NOT NULL,
+			thread_root TEXT NOT NULL,
+			query TEXT NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (room_id, thread_root)
+		);`,
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			room_id TEXT NOT NULL,
+			in_reply_to TEXT NOT NULL,
+			body TEXT NOT NULL,
+			formatted_body TEXT NOT NULL,
+			thread INTEGER NOT NULL,
+			kind TEXT NOT NULL DEFAULT '',
+			replaces TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			attempts INTEGER NOT NULL,
+			next_attempt_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_next_attempt_at ON outbox (next_attempt_at);`,
+		`CREATE TABLE IF NOT EXISTS processed_events (
+			user_id TEXT NOT NULL,
+			room_id TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			processed_at INTEGER NOT NULL,
+			PRIMARY KEY (user_id, room_id, event_id)
+		);`,
 	}
 }
 
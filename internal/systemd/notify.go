@@ -0,0 +1,99 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notifier sends sd_notify(3) state updates to systemd. Without
+// NOTIFY_SOCKET (not running under a Type=notify unit) it does nothing.
+type Notifier struct {
+	socket string
+	// WatchdogInterval is WatchdogSec from the unit, zero when disabled.
+	WatchdogInterval time.Duration
+}
+
+// NewNotifier reads NOTIFY_SOCKET and the watchdog settings from the
+// environment systemd sets up for the service.
+func NewNotifier() *Notifier {
+	n := &Notifier{socket: os.Getenv("NOTIFY_SOCKET")}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return n
+	}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		n.WatchdogInterval = time.Duration(usec) * time.Microsecond
+	}
+	return n
+}
+
+// Enabled reports whether systemd is listening for notifications.
+func (n *Notifier) Enabled() bool {
+	return n != nil && n.socket != ""
+}
+
+// Notify sends state, e.g. "READY=1".
+func (n *Notifier) Notify(state string) error {
+	if !n.Enabled() {
+		return nil
+	}
+	socket := n.socket
+	if socket[0] == '@' {
+		// Abstract namespace socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("dial notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("write notify socket: %w", err)
+	}
+	return nil
+}
+
+// Run sends READY=1 once ready reports true, then WATCHDOG=1 at half the
+// watchdog interval for as long as live succeeds. A wedged bot stops pinging
+// and systemd restarts it. Run returns when ctx is done.
+func (n *Notifier) Run(ctx context.Context, ready func() bool, live func(context.Context) error, logf func(string, ...any)) {
+	if !n.Enabled() {
+		return
+	}
+
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	for !ready() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		}
+	}
+	if err := n.Notify("READY=1"); err != nil {
+		logf("systemd ready notification failed: %v", err)
+	}
+
+	if n.WatchdogInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(n.WatchdogInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := live(ctx); err != nil {
+			logf("skipping systemd watchdog ping: %v", err)
+			continue
+		}
+		if err := n.Notify("WATCHDOG=1"); err != nil {
+			logf("systemd watchdog ping failed: %v", err)
+		}
+	}
+}
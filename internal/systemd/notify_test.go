@@ -0,0 +1,86 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func read(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotifier_DisabledWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n := NewNotifier()
+	if n.Enabled() {
+		t.Fatal("expected notifier to be disabled")
+	}
+	if err := n.Notify("READY=1"); err != nil {
+		t.Fatalf("expected no-op notify, got %v", err)
+	}
+}
+
+func TestNotifier_ReadsWatchdogInterval(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "/run/test.sock")
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := NewNotifier().WatchdogInterval; got != 30*time.Second {
+		t.Fatalf("unexpected watchdog interval: %s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := NewNotifier().WatchdogInterval; got != 0 {
+		t.Fatalf("expected watchdog meant for another pid to be ignored, got %s", got)
+	}
+}
+
+func TestNotifier_RunSendsReadyThenWatchdog(t *testing.T) {
+	conn, path := listen(t)
+	n := &Notifier{socket: path, WatchdogInterval: 20 * time.Millisecond}
+
+	var live atomic.Bool
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.Run(ctx, func() bool { return true }, func(context.Context) error {
+			if !live.Load() {
+				return errors.New("stalled")
+			}
+			return nil
+		}, t.Logf)
+	}()
+
+	if got := read(t, conn); got != "READY=1" {
+		t.Fatalf("expected READY=1, got %q", got)
+	}
+	live.Store(true)
+	if got := read(t, conn); got != "WATCHDOG=1" {
+		t.Fatalf("expected WATCHDOG=1, got %q", got)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,163 @@
+package testharness
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/bot"
+	"github.com/gotlou/hister-element-bot/bot/internal/extractor"
+	"github.com/gotlou/hister-element-bot/bot/internal/hister"
+	"github.com/gotlou/hister-element-bot/bot/internal/matrix"
+	"github.com/gotlou/hister-element-bot/bot/internal/triggers"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	botUser  = id.UserID("@bot:example.org")
+	userID   = id.UserID("@alice:example.org")
+	roomID   = id.RoomID("!room:example.org")
+	waitTime = 5 * time.Second
+)
+
+type testLogger struct{ t testing.TB }
+
+func (l testLogger) Printf(format string, args ...any) { l.t.Logf(format, args...) }
+
+// startBot wires the real Matrix client, Hister client and bot service to the
+// fakes and syncs until the test ends.
+func startBot(t *testing.T, hs *Homeserver, hi *Hister) {
+	t.Helper()
+	logger := testLogger{t}
+
+	mx, err := matrix.BuildMautrixClient(context.Background(), matrix.Config{
+		HomeserverURL: hs.URL,
+		UserID:        botUser,
+		AccessToken:   "token",
+	}, matrix.Stores{
+		SyncStore:  mautrix.NewMemorySyncStore(),
+		StateStore: mautrix.NewMemoryStateStore(),
+	})
+	if err != nil {
+		t.Fatalf("BuildMautrixClient() error = %v", err)
+	}
+
+	backend, err := hister.NewClient(hi.URL, time.Second, func(c *hister.Client) {
+		c.Extract = func(_ context.Context, rawURL string) (extractor.Result, error) {
+			return extractor.Result{Title: "Go release notes", Text: "What changed in " + rawURL}, nil
+		}
+	})
+	if err != nil {
+		t.Fatalf("hister.NewClient() error = %v", err)
+	}
+
+	rooms, err := matrix.NewAllowedRooms([]string{string(roomID)})
+	if err != nil {
+		t.Fatalf("NewAllowedRooms() error = %v", err)
+	}
+
+	var svc *bot.Service
+	handler := matrix.MessageHandlerFunc(func(ctx context.Context, msg matrix.Message) error {
+		return svc.HandleMatrixMessage(ctx, msg)
+	})
+	client, err := matrix.NewClient(mx, rooms, handler, logger)
+	if err != nil {
+		t.Fatalf("matrix.NewClient() error = %v", err)
+	}
+	svc, err = bot.NewService(bot.Config{
+		BotUserID:     botUser,
+		SearchCommand: "/search",
+		MaxResults:    5,
+		MaxQueryLen:   200,
+		ReplyMode:     "thread",
+	}, bot.Deps{
+		Backend: backend,
+		Parser:  triggers.NewParser("/search"),
+		Replier: client,
+		Logger:  logger,
+	})
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		client.Stop()
+		if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("Start() error = %v", err)
+		}
+	})
+}
+
+func TestIndexThenSearchRoundTrip(t *testing.T) {
+	hs := NewHomeserver(t)
+	hi := NewHister(t)
+	startBot(t, hs, hi)
+
+	hs.PushText(roomID, userID, "worth a read: https://go.dev/doc/go1.24")
+	waitFor(t, func() bool { return len(hi.Indexed()) == 1 })
+	if got := hi.Indexed()[0]; got != "https://go.dev/doc/go1.24" {
+		t.Fatalf("indexed URL = %q", got)
+	}
+
+	query := hs.PushText(roomID, userID, "/search release notes")
+	sent := hs.WaitForSent(t, 1, waitTime)
+	reply := sent[len(sent)-1]
+	if reply.RoomID != roomID {
+		t.Fatalf("reply room = %s, want %s", reply.RoomID, roomID)
+	}
+	if !strings.Contains(reply.Body(), "https://go.dev/doc/go1.24") {
+		t.Fatalf("reply body = %q, want indexed URL", reply.Body())
+	}
+	relates, _ := reply.Content["m.relates_to"].(map[string]any)
+	if relates["rel_type"] != "m.thread" || relates["event_id"] != string(query) {
+		t.Fatalf("reply relation = %#v, want thread on %s", relates, query)
+	}
+	if got := hi.Queries(); len(got) != 1 || got[0] != "release notes" {
+		t.Fatalf("queries = %#v", got)
+	}
+}
+
+func TestIgnoresOtherRooms(t *testing.T) {
+	hs := NewHomeserver(t)
+	hi := NewHister(t, Document{Title: "Go", URL: "https://go.dev"})
+	startBot(t, hs, hi)
+
+	hs.PushText("!elsewhere:example.org", userID, "/search go")
+	hs.PushText(roomID, userID, "/search go")
+	sent := hs.WaitForSent(t, 1, waitTime)
+	time.Sleep(100 * time.Millisecond)
+	if sent = hs.Sent(); len(sent) != 1 || sent[0].RoomID != roomID {
+		t.Fatalf("sent = %#v, want one reply in %s", sent, roomID)
+	}
+}
+
+func TestRetriesRateLimitedReply(t *testing.T) {
+	hs := NewHomeserver(t)
+	hi := NewHister(t, Document{Title: "Go", URL: "https://go.dev"})
+	startBot(t, hs, hi)
+
+	hs.RateLimitSends(2)
+	hs.PushText(roomID, userID, "/search go")
+	sent := hs.WaitForSent(t, 1, waitTime)
+	if !strings.Contains(sent[0].Body(), "https://go.dev") {
+		t.Fatalf("reply body = %q, want search result", sent[0].Body())
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTime)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,109 @@
+package testharness
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Document is a search result the fake Hister returns.
+type Document struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Text  string `json:"text"`
+}
+
+// Hister fakes Hister's POST /add and WebSocket /search endpoints. Added
+// URLs become searchable documents.
+type Hister struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	docs    []Document
+	indexed []string
+	queries []string
+}
+
+// NewHister starts a fake Hister seeded with docs, closed when the test ends.
+func NewHister(t testing.TB, docs ...Document) *Hister {
+	t.Helper()
+	h := &Hister{docs: append([]Document(nil), docs...)}
+	upgrader := websocket.Upgrader{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /add", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("url") == "" {
+			http.Error(w, "url is required", http.StatusBadRequest)
+			return
+		}
+		h.mu.Lock()
+		h.indexed = append(h.indexed, r.PostForm.Get("url"))
+		h.docs = append(h.docs, Document{Title: r.PostForm.Get("title"), URL: r.PostForm.Get("url"), Text: r.PostForm.Get("text")})
+		h.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var req struct {
+				Text string `json:"text"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if err := conn.WriteJSON(map[string]any{"documents": h.search(req.Text)}); err != nil {
+				return
+			}
+		}
+	})
+
+	h.Server = httptest.NewServer(mux)
+	t.Cleanup(h.Close)
+	return h
+}
+
+// Indexed returns the URLs added so far.
+func (h *Hister) Indexed() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.indexed...)
+}
+
+// Queries returns the search texts received so far.
+func (h *Hister) Queries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.queries...)
+}
+
+// search matches documents whose title, URL or text contains every query word.
+func (h *Hister) search(query string) []Document {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queries = append(h.queries, query)
+
+	words := strings.Fields(strings.ToLower(query))
+	out := []Document{}
+	for _, d := range h.docs {
+		haystack := strings.ToLower(d.Title + " " + d.URL + " " + d.Text)
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(haystack, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,251 @@
+// Package testharness provides in-process fakes of a Matrix homeserver and a
+// Hister server for integration tests that drive the real clients end to
+// end without credentials or network access.
+package testharness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxLongPoll caps how long a fake /sync waits for new events, so tests
+// that stop syncing do not wait for the client's full sync timeout.
+const maxLongPoll = 500 * time.Millisecond
+
+// SentEvent is an event a client sent through the fake homeserver.
+type SentEvent struct {
+	RoomID  id.RoomID
+	Type    string
+	Content map[string]any
+}
+
+// Body returns the content's body field, if any.
+func (s SentEvent) Body() string {
+	body, _ := s.Content["body"].(string)
+	return body
+}
+
+// Homeserver is a minimal Matrix client-server API: sync, send, messages,
+// single event fetch, room state (always unencrypted) and joined members.
+type Homeserver struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	changed chan struct{}
+	// timeline holds every pushed event; pending is the tail not yet synced.
+	timeline map[id.RoomID][]map[string]any
+	pending  []pendingEvent
+	batch    int
+	sent     []SentEvent
+	nextID   int
+	// limitSends rejects this many upcoming sends with M_LIMIT_EXCEEDED.
+	limitSends int
+}
+
+type pendingEvent struct {
+	roomID id.RoomID
+	raw    map[string]any
+}
+
+// NewHomeserver starts a fake homeserver that is closed when the test ends.
+func NewHomeserver(t testing.TB) *Homeserver {
+	t.Helper()
+	h := &Homeserver{
+		changed:  make(chan struct{}),
+		timeline: make(map[id.RoomID][]map[string]any),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/user/{user}/filter", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"filter_id": "1"})
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/sync", h.handleSync)
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/send/{type}/{txn}", h.handleSend)
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{room}/messages", h.handleMessages)
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{room}/event/{event}", h.handleEvent)
+	noState := func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"errcode": "M_NOT_FOUND", "error": "Event not found."})
+	}
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{room}/state/{type}", noState)
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{room}/state/{type}/{key...}", noState)
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{room}/joined_members", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"joined": map[string]any{}})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"errcode": "M_UNRECOGNIZED", "error": "Unrecognized request " + r.Method + " " + r.URL.Path})
+	})
+
+	h.Server = httptest.NewServer(mux)
+	t.Cleanup(h.Close)
+	return h
+}
+
+// PushText queues an m.text message from sender for the next sync and
+// returns its event ID.
+func (h *Homeserver) PushText(roomID id.RoomID, sender id.UserID, body string) id.EventID {
+	return h.Push(roomID, sender, event.EventMessage.Type, map[string]any{"msgtype": "m.text", "body": body})
+}
+
+// Push queues an event with the given type and content for the next sync.
+func (h *Homeserver) Push(roomID id.RoomID, sender id.UserID, eventType string, content map[string]any) id.EventID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	eventID := id.EventID(fmt.Sprintf("$event%d", h.nextID))
+	raw := map[string]any{
+		"type":             eventType,
+		"event_id":         eventID,
+		"sender":           sender,
+		"room_id":          roomID,
+		"origin_server_ts": time.Now().UnixMilli(),
+		"content":          content,
+	}
+	h.timeline[roomID] = append(h.timeline[roomID], raw)
+	h.pending = append(h.pending, pendingEvent{roomID: roomID, raw: raw})
+	h.notifyLocked()
+	return eventID
+}
+
+// Sent returns the events clients have sent so far.
+func (h *Homeserver) Sent() []SentEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]SentEvent(nil), h.sent...)
+}
+
+// WaitForSent blocks until at least n events were sent, failing the test
+// after timeout.
+func (h *Homeserver) WaitForSent(t testing.TB, n int, timeout time.Duration) []SentEvent {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		h.mu.Lock()
+		if len(h.sent) >= n {
+			sent := append([]SentEvent(nil), h.sent...)
+			h.mu.Unlock()
+			return sent
+		}
+		changed := h.changed
+		h.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("timed out waiting for %d sent events, got %#v", n, h.Sent())
+			return nil
+		}
+	}
+}
+
+// RateLimitSends makes the next n send requests fail with M_LIMIT_EXCEEDED
+// and a short retry_after_ms.
+func (h *Homeserver) RateLimitSends(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limitSends = n
+}
+
+// notifyLocked wakes long-polling syncs and waiters. h.mu must be held.
+func (h *Homeserver) notifyLocked() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+func (h *Homeserver) handleSync(w http.ResponseWriter, r *http.Request) {
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	wait := min(time.Duration(timeout)*time.Millisecond, maxLongPoll)
+
+	h.mu.Lock()
+	if len(h.pending) == 0 && wait > 0 {
+		changed := h.changed
+		h.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		h.mu.Lock()
+	}
+	pending := h.pending
+	h.pending = nil
+	h.batch++
+	batch := h.batch
+	h.mu.Unlock()
+
+	join := make(map[id.RoomID]any)
+	timelines := make(map[id.RoomID][]map[string]any)
+	for _, p := range pending {
+		timelines[p.roomID] = append(timelines[p.roomID], p.raw)
+	}
+	for roomID, events := range timelines {
+		join[roomID] = map[string]any{"timeline": map[string]any{"events": events}}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"next_batch": fmt.Sprintf("s%d", batch),
+		"rooms":      map[string]any{"join": join},
+	})
+}
+
+func (h *Homeserver) handleSend(w http.ResponseWriter, r *http.Request) {
+	var content map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errcode": "M_NOT_JSON", "error": err.Error()})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.limitSends > 0 {
+		h.limitSends--
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests", "retry_after_ms": 10})
+		return
+	}
+	h.nextID++
+	eventID := fmt.Sprintf("$sent%d", h.nextID)
+	h.sent = append(h.sent, SentEvent{RoomID: id.RoomID(r.PathValue("room")), Type: r.PathValue("type"), Content: content})
+	h.notifyLocked()
+	writeJSON(w, http.StatusOK, map[string]any{"event_id": eventID})
+}
+
+// handleMessages returns the whole room timeline, newest first, as a single
+// page of backward pagination.
+func (h *Homeserver) handleMessages(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	events := h.timeline[id.RoomID(r.PathValue("room"))]
+	chunk := make([]map[string]any, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		chunk = append(chunk, events[i])
+	}
+	h.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{"start": r.URL.Query().Get("from"), "chunk": chunk})
+}
+
+func (h *Homeserver) handleEvent(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, raw := range h.timeline[id.RoomID(r.PathValue("room"))] {
+		if raw["event_id"] == id.EventID(r.PathValue("event")) {
+			writeJSON(w, http.StatusOK, raw)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]any{"errcode": "M_NOT_FOUND", "error": "Event not found."})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,172 @@
+// Package toplinks posts a weekly report of each room's most shared and most
+// searched links, optionally with an LLM-written intro.
+package toplinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/i18n"
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// Window is how far back each report reaches.
+	Window       = 7 * 24 * time.Hour
+	DefaultLimit = 5
+)
+
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type Store interface {
+	TopSharedLinks(ctx context.Context, roomID id.RoomID, since time.Time, limit int) ([]storage.LinkCount, error)
+	TopSearchedLinks(ctx context.Context, roomID id.RoomID, since time.Time, limit int) ([]storage.LinkCount, error)
+}
+
+type Poster interface {
+	SendNotice(ctx context.Context, roomID id.RoomID, body string) (id.EventID, error)
+}
+
+type Config struct {
+	Rooms    []id.RoomID
+	Schedule schedule.Schedule
+	// Location is the time zone of the report date. Nil means time.Local.
+	Location *time.Location
+	// Limit caps how many links each list shows.
+	Limit    int
+	Messages *i18n.Catalog
+}
+
+// Deps are the collaborators used by Reporter. Intro is optional; when set it
+// writes an intro paragraph from the report's link lists, and a failure posts
+// the report without one.
+type Deps struct {
+	Store  Store
+	Poster Poster
+	Intro  func(ctx context.Context, links string) (string, error)
+	Logger Logger
+}
+
+// Reporter posts the top-links report to its rooms on a schedule.
+type Reporter struct {
+	cfg    Config
+	store  Store
+	poster Poster
+	intro  func(ctx context.Context, links string) (string, error)
+	logger Logger
+	now    func() time.Time
+}
+
+func New(cfg Config, deps Deps) (*Reporter, error) {
+	if deps.Store == nil {
+		return nil, errors.New("link store is required")
+	}
+	if deps.Poster == nil {
+		return nil, errors.New("poster is required")
+	}
+	if cfg.Schedule == nil {
+		return nil, errors.New("schedule is required")
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.Limit <= 0 {
+		cfg.Limit = DefaultLimit
+	}
+	return &Reporter{
+		cfg:    cfg,
+		store:  deps.Store,
+		poster: deps.Poster,
+		intro:  deps.Intro,
+		logger: deps.Logger,
+		now:    time.Now,
+	}, nil
+}
+
+// Run posts the report to every room at each scheduled time until ctx is
+// done. Reports missed while the bot was down are not posted late.
+func (r *Reporter) Run(ctx context.Context) {
+	if len(r.cfg.Rooms) == 0 {
+		return
+	}
+	for next := r.cfg.Schedule.Next(r.now()); !next.IsZero(); next = r.cfg.Schedule.Next(r.now()) {
+		r.logf("next top links report at %s rooms=%d", next.Format(time.RFC3339), len(r.cfg.Rooms))
+		if !schedule.Wait(ctx, next) {
+			return
+		}
+		for _, roomID := range r.cfg.Rooms {
+			if err := r.Post(ctx, roomID); err != nil {
+				r.logf("top links report failed room=%s err=%v", roomID, err)
+			}
+		}
+	}
+}
+
+// Post reports the last week's top links in roomID now. Rooms where nothing
+// was shared or searched get no report.
+func (r *Reporter) Post(ctx context.Context, roomID id.RoomID) error {
+	now := r.now()
+	since := now.Add(-Window)
+	shared, err := r.store.TopSharedLinks(ctx, roomID, since, r.cfg.Limit)
+	if err != nil {
+		return fmt.Errorf("load shared links: %w", err)
+	}
+	searched, err := r.store.TopSearchedLinks(ctx, roomID, since, r.cfg.Limit)
+	if err != nil {
+		return fmt.Errorf("load searched links: %w", err)
+	}
+	if len(shared) == 0 && len(searched) == 0 {
+		r.logf("top links report skipped room=%s: no links", roomID)
+		return nil
+	}
+
+	var lists strings.Builder
+	r.writeList(&lists, roomID, i18n.TopLinksShared, shared)
+	r.writeList(&lists, roomID, i18n.TopLinksSearched, searched)
+
+	var b strings.Builder
+	b.WriteString(r.cfg.Messages.Text(string(roomID), i18n.TopLinksReport, i18n.Vars{
+		"date": since.In(r.cfg.Location).Format("2006-01-02"),
+	}))
+	if r.intro != nil {
+		intro, err := r.intro(ctx, strings.TrimSpace(lists.String()))
+		if err != nil {
+			r.logf("top links intro failed room=%s err=%v", roomID, err)
+		} else if intro = strings.TrimSpace(intro); intro != "" {
+			b.WriteString("\n\n")
+			b.WriteString(intro)
+		}
+	}
+	b.WriteString(lists.String())
+
+	eventID, err := r.poster.SendNotice(ctx, roomID, b.String())
+	if err != nil {
+		return fmt.Errorf("post top links report: %w", err)
+	}
+	r.logf("top links report posted room=%s event=%s shared=%d searched=%d", roomID, eventID, len(shared), len(searched))
+	return nil
+}
+
+func (r *Reporter) writeList(b *strings.Builder, roomID id.RoomID, heading i18n.Key, links []storage.LinkCount) {
+	if len(links) == 0 {
+		return
+	}
+	b.WriteString("\n\n")
+	b.WriteString(r.cfg.Messages.Text(string(roomID), heading, nil))
+	for i, link := range links {
+		fmt.Fprintf(b, "\n%d. %s (%d×)", i+1, link.URL, link.Count)
+	}
+}
+
+func (r *Reporter) logf(format string, args ...any) {
+	if r.logger != nil {
+		r.logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,94 @@
+package toplinks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotlou/hister-element-bot/bot/internal/schedule"
+	"github.com/gotlou/hister-element-bot/bot/internal/storage"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeStore struct {
+	shared, searched []storage.LinkCount
+	since            time.Time
+}
+
+func (f *fakeStore) TopSharedLinks(_ context.Context, _ id.RoomID, since time.Time, limit int) ([]storage.LinkCount, error) {
+	f.since = since
+	return f.shared, nil
+}
+
+func (f *fakeStore) TopSearchedLinks(_ context.Context, _ id.RoomID, _ time.Time, _ int) ([]storage.LinkCount, error) {
+	return f.searched, nil
+}
+
+type fakePoster struct {
+	bodies []string
+}
+
+func (f *fakePoster) SendNotice(_ context.Context, _ id.RoomID, body string) (id.EventID, error) {
+	f.bodies = append(f.bodies, body)
+	return "$report", nil
+}
+
+func newTestReporter(t *testing.T, store *fakeStore, poster *fakePoster, intro func(context.Context, string) (string, error)) *Reporter {
+	t.Helper()
+	r, err := New(Config{Schedule: schedule.Every(Window), Location: time.UTC}, Deps{Store: store, Poster: poster, Intro: intro})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r.now = func() time.Time { return time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC) }
+	return r
+}
+
+func TestPostListsSharedAndSearchedLinks(t *testing.T) {
+	store := &fakeStore{
+		shared:   []storage.LinkCount{{URL: "https://a.example", Count: 3}, {URL: "https://b.example", Count: 1}},
+		searched: []storage.LinkCount{{URL: "https://c.example", Count: 2}},
+	}
+	poster := &fakePoster{}
+	var introInput string
+	r := newTestReporter(t, store, poster, func(_ context.Context, links string) (string, error) {
+		introInput = links
+		return " A busy week. ", nil
+	})
+
+	if err := r.Post(context.Background(), "!room:test"); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	lists := "Most shared:\n1. https://a.example (3×)\n2. https://b.example (1×)\n\nMost searched:\n1. https://c.example (2×)"
+	want := "Top links for the week of 2026-03-02\n\nA busy week.\n\n" + lists
+	if len(poster.bodies) != 1 || poster.bodies[0] != want {
+		t.Fatalf("unexpected report: %#v", poster.bodies)
+	}
+	if introInput != lists {
+		t.Fatalf("unexpected intro input: %q", introInput)
+	}
+	if !store.since.Equal(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected window start: %s", store.since)
+	}
+}
+
+func TestPostWithoutIntroOrLinks(t *testing.T) {
+	store := &fakeStore{}
+	poster := &fakePoster{}
+	r := newTestReporter(t, store, poster, func(context.Context, string) (string, error) {
+		return "", errors.New("llm down")
+	})
+
+	if err := r.Post(context.Background(), "!room:test"); err != nil || len(poster.bodies) != 0 {
+		t.Fatalf("expected quiet room to be skipped, got %v %#v", err, poster.bodies)
+	}
+
+	store.searched = []storage.LinkCount{{URL: "https://c.example", Count: 2}}
+	if err := r.Post(context.Background(), "!room:test"); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	want := "Top links for the week of 2026-03-02\n\nMost searched:\n1. https://c.example (2×)"
+	if len(poster.bodies) != 1 || poster.bodies[0] != want {
+		t.Fatalf("unexpected report: %#v", poster.bodies)
+	}
+}
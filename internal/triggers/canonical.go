@@ -0,0 +1,76 @@
+package triggers
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters that only identify where a link was
+// shared from. Parameters starting with "utm_" are dropped as well.
+var trackingParams = map[string]struct{}{
+	"fbclid":  {},
+	"gclid":   {},
+	"dclid":   {},
+	"gbraid":  {},
+	"wbraid":  {},
+	"msclkid": {},
+	"yclid":   {},
+	"igshid":  {},
+	"mc_cid":  {},
+	"mc_eid":  {},
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// CanonicalURL normalizes u so the same page shared in different ways is
+// indexed once: the scheme and host are lowercased, default ports, tracking
+// parameters and the fragment are dropped. The remaining query is kept as
+// written, and so are fragments that address a page of their own.
+func CanonicalURL(u *url.URL) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	host, port := strings.ToLower(c.Hostname()), c.Port()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" && port != defaultPorts[c.Scheme] {
+		host += ":" + port
+	}
+	c.Host = host
+	c.RawQuery = withoutTrackingParams(c.RawQuery)
+	c.ForceQuery = false
+	if !routeFragment(&c) {
+		c.Fragment, c.RawFragment = "", ""
+	}
+	return c.String()
+}
+
+// routeFragment reports whether u's fragment picks the page rather than a
+// spot on it: a matrix.to permalink, or a hash route such as "#/item/1" or
+// "#!/item/1" of a single-page app.
+func routeFragment(u *url.URL) bool {
+	if u.Fragment == "" {
+		return false
+	}
+	return u.Host == "matrix.to" || strings.HasPrefix(u.Fragment, "/") || strings.HasPrefix(u.Fragment, "!")
+}
+
+func withoutTrackingParams(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		key = strings.ToLower(key)
+		if _, ok := trackingParams[key]; ok || strings.HasPrefix(key, "utm_") || param == "" {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
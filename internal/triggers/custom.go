@@ -0,0 +1,90 @@
+package triggers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Action is what a custom trigger does when its pattern matches.
+type Action string
+
+const (
+	// ActionSearch searches for the first capture group (or the whole match).
+	ActionSearch Action = "search"
+	// ActionIndex indexes the URL in the first capture group (or the whole match).
+	ActionIndex Action = "index"
+	// ActionReply replies with a canned response; $1-style references are expanded.
+	ActionReply Action = "reply"
+)
+
+// CustomTrigger is an operator-defined regex trigger.
+type CustomTrigger struct {
+	Pattern *regexp.Regexp
+	Action  Action
+	Reply   string
+}
+
+// CustomMatch is the result of matching a message against custom triggers.
+// Value is the search query, URL, or expanded reply depending on Action.
+type CustomMatch struct {
+	Action Action
+	Value  string
+}
+
+// CompileCustomTrigger validates and compiles a custom trigger definition.
+func CompileCustomTrigger(pattern, action, reply string) (CustomTrigger, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return CustomTrigger{}, fmt.Errorf("pattern is required")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return CustomTrigger{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	a := Action(strings.ToLower(strings.TrimSpace(action)))
+	switch a {
+	case ActionSearch, ActionIndex:
+	case ActionReply:
+		if strings.TrimSpace(reply) == "" {
+			return CustomTrigger{}, fmt.Errorf("reply is required for action %q", a)
+		}
+	default:
+		return CustomTrigger{}, fmt.Errorf("unknown action %q (want search, index or reply)", action)
+	}
+	return CustomTrigger{Pattern: re, Action: a, Reply: reply}, nil
+}
+
+// MatchCustomTrigger returns the first trigger in order whose pattern matches msg.
+func MatchCustomTrigger(custom []CustomTrigger, msg string) (CustomMatch, bool) {
+	for _, t := range custom {
+		if t.Pattern == nil {
+			continue
+		}
+		idx := t.Pattern.FindStringSubmatchIndex(msg)
+		if idx == nil {
+			continue
+		}
+
+		var value string
+		switch t.Action {
+		case ActionReply:
+			value = string(t.Pattern.ExpandString(nil, t.Reply, msg, idx))
+		default:
+			value = firstGroup(msg, idx)
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		return CustomMatch{Action: t.Action, Value: value}, true
+	}
+	return CustomMatch{}, false
+}
+
+func firstGroup(msg string, idx []int) string {
+	if len(idx) >= 4 && idx[2] >= 0 {
+		return msg[idx[2]:idx[3]]
+	}
+	return msg[idx[0]:idx[1]]
+}
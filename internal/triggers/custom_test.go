@@ -0,0 +1,51 @@
+package triggers
+
+import "testing"
+
+func TestCompileCustomTrigger_Validates(t *testing.T) {
+	if _, err := CompileCustomTrigger("(", "search", ""); err == nil {
+		t.Fatal("expected invalid regex error")
+	}
+	if _, err := CompileCustomTrigger("x", "explode", ""); err == nil {
+		t.Fatal("expected unknown action error")
+	}
+	if _, err := CompileCustomTrigger("x", "reply", " "); err == nil {
+		t.Fatal("expected missing reply error")
+	}
+}
+
+func TestMatchCustomTrigger_FirstMatchWins(t *testing.T) {
+	search, err := CompileCustomTrigger(`(?i)^what is (.+?)\??$`, "search", "")
+	if err != nil {
+		t.Fatalf("compile search trigger: %v", err)
+	}
+	reply, err := CompileCustomTrigger(`(?i)^ping (\w+)$`, "Reply", "pong $1")
+	if err != nil {
+		t.Fatalf("compile reply trigger: %v", err)
+	}
+	index, err := CompileCustomTrigger(`^save (https?://\S+)`, "index", "")
+	if err != nil {
+		t.Fatalf("compile index trigger: %v", err)
+	}
+	custom := []CustomTrigger{search, reply, index}
+
+	cases := []struct {
+		msg    string
+		action Action
+		value  string
+	}{
+		{"What is golang?", ActionSearch, "golang"},
+		{"ping alice", ActionReply, "pong alice"},
+		{"save https://example.org/x later", ActionIndex, "https://example.org/x"},
+	}
+	for _, tc := range cases {
+		got, ok := MatchCustomTrigger(custom, tc.msg)
+		if !ok || got.Action != tc.action || got.Value != tc.value {
+			t.Fatalf("MatchCustomTrigger(%q) = %#v, %v", tc.msg, got, ok)
+		}
+	}
+
+	if _, ok := MatchCustomTrigger(custom, "unrelated"); ok {
+		t.Fatal("expected no match")
+	}
+}
@@ -0,0 +1,87 @@
+package triggers
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ClosestTitles returns the indexes of up to limit titles that fuzzily match
+// the words of text, best match first. A title word matches a query word when
+// it starts with it or is within a small edit distance of it (1, or 2 for
+// words of 8 or more runes). Titles matching more query words rank higher,
+// then those needing fewer edits; ties keep the order of titles.
+func ClosestTitles(text string, titles []string, limit int) []int {
+	words := fuzzyWords(text)
+	if len(words) == 0 || limit <= 0 {
+		return nil
+	}
+
+	type match struct {
+		index   int
+		matched int
+		edits   int
+	}
+	var matches []match
+	for i, title := range titles {
+		titleWords := fuzzyWords(title)
+		m := match{index: i}
+		for _, w := range words {
+			if d, ok := closestWord(w, titleWords); ok {
+				m.matched++
+				m.edits += d
+			}
+		}
+		if m.matched > 0 {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].matched != matches[b].matched {
+			return matches[a].matched > matches[b].matched
+		}
+		return matches[a].edits < matches[b].edits
+	})
+
+	out := make([]int, 0, min(len(matches), limit))
+	for _, m := range matches[:min(len(matches), limit)] {
+		out = append(out, m.index)
+	}
+	return out
+}
+
+// closestWord returns the fewest edits turning any of candidates into word,
+// if within the allowed distance.
+func closestWord(word string, candidates []string) (int, bool) {
+	maxDist := 1
+	if utf8.RuneCountInString(word) >= 8 {
+		maxDist = 2
+	}
+	best := -1
+	for _, c := range candidates {
+		d := 0
+		if !strings.HasPrefix(c, word) {
+			d = editDistance(word, c)
+		}
+		if d <= maxDist && (best < 0 || d < best) {
+			best = d
+		}
+	}
+	return best, best >= 0
+}
+
+// fuzzyWords lowercases s and splits it into words of at least three runes,
+// so short words such as "a" or "of" do not match everything.
+func fuzzyWords(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := fields[:0]
+	for _, f := range fields {
+		if utf8.RuneCountInString(f) >= 3 {
+			out = append(out, f)
+		}
+	}
+	return out
+}
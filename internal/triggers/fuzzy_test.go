@@ -0,0 +1,40 @@
+package triggers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClosestTitles(t *testing.T) {
+	titles := []string{
+		"Release notes",
+		"Generics in Go",
+		"An Introduction to Generics",
+		"Rust ownership",
+		"Go generic type parameters",
+	}
+	cases := []struct {
+		text string
+		want []int
+	}{
+		{"generic types", []int{4, 1, 2}},
+		{"genrics", []int{1, 2}},
+		{"ownrship", []int{3}},
+		{"kubernetes", []int{}},
+		{"a of", nil},
+	}
+	for _, c := range cases {
+		got := ClosestTitles(c.text, titles, 3)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ClosestTitles(%q) = %v, want %v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestSearchQueryString(t *testing.T) {
+	q := ParseSearchQuery(`before:2024-02-01 "type parameters" site:www.go.dev tag:#Go generics from:@alice`)
+	want := `"type parameters" generics site:go.dev tag:go from:@alice before:2024-02-01`
+	if got := q.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,30 @@
+package triggers
+
+import "strings"
+
+// StripMention removes a plain-text mention of the bot from the start or end
+// of body and returns the rest as the query. Clients write a mention listed
+// in m.mentions into the plain body as the user ID or the display name, with
+// or without a leading "@".
+func StripMention(body, botUserID, botDisplayName string) (query string, ok bool) {
+	body = strings.TrimSpace(body)
+	var names []string
+	if id := strings.TrimSpace(botUserID); id != "" {
+		names = append(names, id)
+	}
+	if name := normalizeDisplayName(botDisplayName); name != "" {
+		names = append(names, "@"+name, name)
+	}
+	for _, name := range names {
+		if len(body) > len(name) && strings.EqualFold(body[:len(name)], name) && strings.ContainsRune(mentionTrimChars, rune(body[len(name)])) {
+			body = body[len(name):]
+			break
+		}
+		if len(body) > len(name) && strings.EqualFold(body[len(body)-len(name):], name) && strings.ContainsRune(mentionTrimChars, rune(body[len(body)-len(name)-1])) {
+			body = body[:len(body)-len(name)]
+			break
+		}
+	}
+	query = strings.Trim(body, mentionTrimChars)
+	return query, query != ""
+}
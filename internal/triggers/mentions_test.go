@@ -0,0 +1,27 @@
+package triggers
+
+import "testing"
+
+func TestStripMention(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		want   string
+		wantOK bool
+	}{
+		{"display name prefix", "Hister Bot: golang generics", "golang generics", true},
+		{"at display name", "@hister bot golang", "golang", true},
+		{"user id suffix", "rust traits @bot:example.org", "rust traits", true},
+		{"name inside a word kept", "Hister Botany", "Hister Botany", true},
+		{"no mention text", "golang", "golang", true},
+		{"mention only", "Hister Bot:", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := StripMention(tc.body, "@bot:example.org", "Hister Bot")
+			if ok != tc.wantOK || got != tc.want {
+				t.Fatalf("StripMention() = %q, %v; want %q, %v", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"strings"
 )
 
-const defaultSearchCommand = "/search"
+const (
+	defaultSearchCommand = "/search"
+	// DefaultCommandPrefix is the prefix commands are written with.
+	DefaultCommandPrefix = "/"
+)
 
 var (
-	urlPattern          = regexp.MustCompile(`https?://[^\s<>"']+`)
+	urlPattern          = regexp.MustCompile(`(?i)https?://[^\s<>"']+`)
 	trailingPunctuation = "\"'.,!?;:"
 )
 
@@ -32,6 +36,18 @@ func NewParser(searchCommand ...string) *Parser {
 	}
 }
 
+// PrefixCommand rewrites a "/"-prefixed command to use prefix instead, so a
+// whole command family can move to e.g. "!" at once. Commands written with
+// another prefix, and an empty prefix, leave command unchanged.
+func PrefixCommand(command, prefix string) string {
+	command = strings.TrimSpace(command)
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" || !strings.HasPrefix(command, DefaultCommandPrefix) {
+		return command
+	}
+	return prefix + strings.TrimPrefix(command, DefaultCommandPrefix)
+}
+
 func (p *Parser) ExtractSearchQuery(msg, botDisplayName string) (query string, ok bool) {
 	if p == nil {
 		p = NewParser()
@@ -68,6 +84,8 @@ func (p *Parser) ExtractSearchQuery(msg, botDisplayName string) (query string, o
 	return "", false
 }
 
+// ExtractURLs returns the http(s) URLs in msg in their canonical form (see
+// CanonicalURL).
 func (Parser) ExtractURLs(msg string) []string {
 	matches := urlPattern.FindAllString(msg, -1)
 	if len(matches) == 0 {
@@ -87,7 +105,7 @@ func (Parser) ExtractURLs(msg string) []string {
 		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
 			continue
 		}
-		urls = append(urls, cleaned)
+		urls = append(urls, CanonicalURL(u))
 	}
 
 	if len(urls) == 0 {
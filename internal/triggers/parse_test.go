@@ -1,6 +1,9 @@
 package triggers
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestExtractSearchQuery_Precedence(t *testing.T) {
 	p := NewParser("/search")
@@ -38,3 +41,59 @@ func TestExtractURLs_Cleanup(t *testing.T) {
 		t.Fatalf("unexpected second URL: %q", urls[1])
 	}
 }
+
+func TestPrefixCommand(t *testing.T) {
+	cases := []struct{ command, prefix, want string }{
+		{"/search", "!", "!search"},
+		{"/catchmeup", ".", ".catchmeup"},
+		{"/search", "", "/search"},
+		{"?find", "!", "?find"},
+	}
+	for _, c := range cases {
+		if got := PrefixCommand(c.command, c.prefix); got != c.want {
+			t.Errorf("PrefixCommand(%q, %q) = %q, want %q", c.command, c.prefix, got, c.want)
+		}
+	}
+
+	p := NewParser(PrefixCommand("/search", "!"))
+	if q, ok := p.ExtractSearchQuery("!search golang", ""); !ok || q != "golang" {
+		t.Fatalf("prefixed command failed: ok=%v q=%q", ok, q)
+	}
+	if _, ok := p.ExtractSearchQuery("/search golang", ""); ok {
+		t.Fatal("expected default prefix to stop matching")
+	}
+}
+
+func TestExtractURLs_Canonical(t *testing.T) {
+	p := NewParser()
+	urls := p.ExtractURLs("HTTPS://Example.ORG:443/Post?id=7&utm_source=feed&fbclid=abc#comments " +
+		"http://example.org:8080/a?gclid=x " +
+		"https://example.org/b?q=a%20b&UTM_Medium=mail&page=2")
+	want := []string{
+		"https://example.org/Post?id=7",
+		"http://example.org:8080/a",
+		"https://example.org/b?q=a%20b&page=2",
+	}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Fatalf("ExtractURLs() = %q, want %q", urls, want)
+	}
+}
+
+func TestExtractURLs_KeepsRouteFragments(t *testing.T) {
+	p := NewParser()
+	urls := p.ExtractURLs("https://matrix.to/#/!room:example.org/$event?via=example.org " +
+		"https://matrix.to/#/!room:example.org/$other " +
+		"https://app.example.com/#/items/1?utm_source=feed " +
+		"https://app.example.com/#!/items/2 " +
+		"https://example.com/docs#install")
+	want := []string{
+		"https://matrix.to/#/!room:example.org/$event?via=example.org",
+		"https://matrix.to/#/!room:example.org/$other",
+		"https://app.example.com/#/items/1?utm_source=feed",
+		"https://app.example.com/#!/items/2",
+		"https://example.com/docs",
+	}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Fatalf("ExtractURLs() = %q, want %q", urls, want)
+	}
+}
@@ -0,0 +1,98 @@
+package triggers
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const mentionTrimChars = ":,;- \t\n"
+
+// ExtractPillQuery finds a mention pill for botUserID in an HTML
+// formatted_body and returns the remaining visible text as the query.
+// Reply fallbacks (<mx-reply>) are ignored.
+func ExtractPillQuery(formattedBody, botUserID string) (query string, ok bool) {
+	botUserID = strings.TrimSpace(botUserID)
+	if strings.TrimSpace(formattedBody) == "" || botUserID == "" {
+		return "", false
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(formattedBody), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", false
+	}
+
+	mentioned := false
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch strings.ToLower(n.Data) {
+			case "mx-reply":
+				return
+			case "a":
+				if pillTarget(attr(n, "href")) == botUserID {
+					mentioned = true
+					b.WriteByte(' ')
+					return
+				}
+			case "br", "p", "div":
+				b.WriteByte(' ')
+			}
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	if !mentioned {
+		return "", false
+	}
+
+	q := strings.Join(strings.Fields(b.String()), " ")
+	q = strings.Trim(q, mentionTrimChars)
+	if q == "" {
+		return "", false
+	}
+	return q, true
+}
+
+// pillTarget returns the user ID a matrix.to or matrix: URI points at, or "".
+func pillTarget(href string) string {
+	href = strings.TrimSpace(href)
+	switch {
+	case strings.HasPrefix(href, "https://matrix.to/#/"):
+		target := strings.TrimPrefix(href, "https://matrix.to/#/")
+		target, _, _ = strings.Cut(target, "?")
+		decoded, err := url.PathUnescape(target)
+		if err != nil || !strings.HasPrefix(decoded, "@") {
+			return ""
+		}
+		return decoded
+	case strings.HasPrefix(href, "matrix:u/"):
+		target := strings.TrimPrefix(href, "matrix:u/")
+		target, _, _ = strings.Cut(target, "?")
+		decoded, err := url.PathUnescape(target)
+		if err != nil || decoded == "" {
+			return ""
+		}
+		return "@" + decoded
+	}
+	return ""
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
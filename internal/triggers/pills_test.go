@@ -0,0 +1,27 @@
+package triggers
+
+import "testing"
+
+func TestExtractPillQuery(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		want   string
+		wantOK bool
+	}{
+		{"prefix pill", `<a href="https://matrix.to/#/@bot:example.org">Hister Bot</a>: golang generics`, "golang generics", true},
+		{"suffix pill", `golang generics <a href="https://matrix.to/#/%40bot%3Aexample.org">bot</a>`, "golang generics", true},
+		{"matrix uri", `<a href="matrix:u/bot:example.org?action=chat">bot</a> rust`, "rust", true},
+		{"reply fallback ignored", `<mx-reply><blockquote><a href="https://matrix.to/#/@bot:example.org">bot</a> old</blockquote></mx-reply>hello`, "", false},
+		{"other user", `<a href="https://matrix.to/#/@alice:example.org">alice</a> golang`, "", false},
+		{"pill only", `<a href="https://matrix.to/#/@bot:example.org">bot</a>: `, "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := ExtractPillQuery(tc.body, "@bot:example.org")
+			if ok != tc.wantOK || got != tc.want {
+				t.Fatalf("ExtractPillQuery() = %q, %v; want %q, %v", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
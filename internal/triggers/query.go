@@ -0,0 +1,192 @@
+package triggers
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+const dateLayout = "2006-01-02"
+
+// SearchQuery is a search query split into free text and operators.
+type SearchQuery struct {
+	// Text is the free text, with quoted phrases kept quoted, in original order.
+	Text    string
+	Phrases []string
+	Sites   []string
+	Tags    []string
+	From    []string
+	// Rooms are the room IDs of room: operators, limiting results to links
+	// shared there.
+	Rooms []string
+	// After and Before bound when a link was shared (UTC days, Before exclusive).
+	After  time.Time
+	Before time.Time
+}
+
+// HasLinkFilters reports whether the query filters on locally recorded link
+// metadata (tags, sharer, room or share date).
+func (q SearchQuery) HasLinkFilters() bool {
+	return len(q.Tags) > 0 || len(q.From) > 0 || len(q.Rooms) > 0 || !q.After.IsZero() || !q.Before.IsZero()
+}
+
+// HasFilters reports whether any operator beyond free text was used.
+func (q SearchQuery) HasFilters() bool {
+	return q.HasLinkFilters() || len(q.Sites) > 0
+}
+
+// String renders the query the way it was understood: free text followed by
+// one operator per filter, in a fixed order.
+func (q SearchQuery) String() string {
+	parts := make([]string, 0, 1+len(q.Sites)+len(q.Tags)+len(q.From)+len(q.Rooms)+2)
+	if q.Text != "" {
+		parts = append(parts, q.Text)
+	}
+	for _, site := range q.Sites {
+		parts = append(parts, "site:"+site)
+	}
+	for _, tag := range q.Tags {
+		parts = append(parts, "tag:"+tag)
+	}
+	for _, user := range q.From {
+		parts = append(parts, "from:"+user)
+	}
+	for _, room := range q.Rooms {
+		parts = append(parts, "room:"+room)
+	}
+	if !q.After.IsZero() {
+		parts = append(parts, "after:"+q.After.Format(dateLayout))
+	}
+	if !q.Before.IsZero() {
+		parts = append(parts, "before:"+q.Before.Format(dateLayout))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseSearchQuery understands site:, tag:, from:@user, room:!id:server, before:/after:
+// YYYY-MM-DD dates and "quoted phrases". Unknown or malformed operators are
+// kept as free text.
+func ParseSearchQuery(query string) SearchQuery {
+	var q SearchQuery
+	var text []string
+
+	for _, tok := range tokenizeQuery(query) {
+		if strings.HasPrefix(tok, `"`) {
+			phrase := strings.TrimSpace(strings.Trim(tok, `"`))
+			if phrase != "" {
+				q.Phrases = append(q.Phrases, phrase)
+				text = append(text, `"`+phrase+`"`)
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(tok, ":")
+		if !found || value == "" {
+			text = append(text, tok)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "site":
+			site := normalizeSite(value)
+			if site == "" {
+				text = append(text, tok)
+				continue
+			}
+			q.Sites = appendUnique(q.Sites, site)
+		case "tag":
+			tags := uniqueTags([][]string{{"", strings.TrimPrefix(value, "#")}})
+			if len(tags) == 0 {
+				text = append(text, tok)
+				continue
+			}
+			q.Tags = appendUnique(q.Tags, tags[0])
+		case "from":
+			user := strings.TrimSpace(value)
+			if !strings.HasPrefix(user, "@") || len(user) < 2 {
+				text = append(text, tok)
+				continue
+			}
+			q.From = appendUnique(q.From, user)
+		case "room":
+			room := strings.TrimSpace(value)
+			if !strings.HasPrefix(room, "!") || !strings.Contains(room, ":") {
+				text = append(text, tok)
+				continue
+			}
+			q.Rooms = appendUnique(q.Rooms, room)
+		case "before", "after":
+			day, err := time.Parse(dateLayout, value)
+			if err != nil {
+				text = append(text, tok)
+				continue
+			}
+			if strings.EqualFold(key, "before") {
+				q.Before = day
+			} else {
+				q.After = day
+			}
+		default:
+			text = append(text, tok)
+		}
+	}
+
+	q.Text = strings.Join(text, " ")
+	return q
+}
+
+// tokenizeQuery splits on whitespace while keeping "quoted phrases" together.
+func tokenizeQuery(query string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuote := false
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			if inQuote {
+				cur.WriteRune(r)
+				flush()
+				inQuote = false
+				continue
+			}
+			flush()
+			cur.WriteRune(r)
+			inQuote = true
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func normalizeSite(raw string) string {
+	site := strings.ToLower(strings.TrimSpace(raw))
+	site = strings.TrimPrefix(site, "https://")
+	site = strings.TrimPrefix(site, "http://")
+	site, _, _ = strings.Cut(site, "/")
+	return strings.TrimPrefix(site, "www.")
+}
+
+// MatchesSite reports whether host is site or one of its subdomains.
+func MatchesSite(host, site string) bool {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	return host == site || strings.HasSuffix(host, "."+site)
+}
+
+func appendUnique(values []string, v string) []string {
+	for _, existing := range values {
+		if existing == v {
+			return values
+		}
+	}
+	return append(values, v)
+}
@@ -0,0 +1,49 @@
+package triggers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery_Operators(t *testing.T) {
+	q := ParseSearchQuery(`generics site:https://www.Go.dev/blog tag:#Go from:@alice room:!abc:example.org "type parameters" after:2024-01-01 before:2024-02-01`)
+
+	if q.Text != `generics "type parameters"` {
+		t.Fatalf("unexpected text: %q", q.Text)
+	}
+	if !reflect.DeepEqual(q.Phrases, []string{"type parameters"}) {
+		t.Fatalf("unexpected phrases: %#v", q.Phrases)
+	}
+	if !reflect.DeepEqual(q.Sites, []string{"go.dev"}) || !reflect.DeepEqual(q.Tags, []string{"go"}) || !reflect.DeepEqual(q.From, []string{"@alice"}) {
+		t.Fatalf("unexpected filters: sites=%#v tags=%#v from=%#v", q.Sites, q.Tags, q.From)
+	}
+	if !reflect.DeepEqual(q.Rooms, []string{"!abc:example.org"}) {
+		t.Fatalf("unexpected rooms: %#v", q.Rooms)
+	}
+	if !q.After.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !q.Before.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected date range: %s - %s", q.After, q.Before)
+	}
+	if !q.HasLinkFilters() {
+		t.Fatal("expected link filters")
+	}
+}
+
+func TestParseSearchQuery_MalformedOperatorsStayText(t *testing.T) {
+	q := ParseSearchQuery("before:yesterday from:alice room:#go:example.org http://x foo:bar")
+	if q.Text != "before:yesterday from:alice room:#go:example.org http://x foo:bar" {
+		t.Fatalf("unexpected text: %q", q.Text)
+	}
+	if q.HasFilters() {
+		t.Fatalf("expected no filters, got %#v", q)
+	}
+}
+
+func TestMatchesSite(t *testing.T) {
+	if !MatchesSite("blog.go.dev", "go.dev") || !MatchesSite("www.go.dev", "go.dev") {
+		t.Fatal("expected subdomain matches")
+	}
+	if MatchesSite("notgo.dev", "go.dev") {
+		t.Fatal("unexpected suffix match without dot")
+	}
+}
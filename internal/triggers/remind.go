@@ -0,0 +1,94 @@
+package triggers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ReminderPhrase starts a natural-language reminder such as
+// "remind me in 2h about the deploy".
+const ReminderPhrase = "remind me"
+
+var (
+	durationPart = regexp.MustCompile(`(?i)^([0-9]+)\s*(weeks?|w|days?|d|hours?|hrs?|h|minutes?|mins?|m)`)
+	reminderLink = regexp.MustCompile(`(?i)^(?:about|to|that)\b`)
+)
+
+// Reminder is a parsed reminder request.
+type Reminder struct {
+	Delay time.Duration
+	Text  string
+}
+
+// ParseReminder parses what follows "remind me" or the /remind command:
+// an optional "in", a delay such as "2h", "1h30m" or "3 days", an optional
+// "about", "to" or "that", and the reminder text.
+func ParseReminder(args string) (Reminder, bool) {
+	rest := strings.TrimSpace(args)
+	if word, tail, _ := strings.Cut(rest, " "); strings.EqualFold(word, "in") {
+		rest = strings.TrimSpace(tail)
+	}
+
+	delay, rest, ok := cutDuration(rest)
+	if !ok {
+		return Reminder{}, false
+	}
+
+	if loc := reminderLink.FindStringIndex(rest); loc != nil {
+		rest = strings.TrimSpace(rest[loc[1]:])
+	}
+	if rest == "" {
+		return Reminder{}, false
+	}
+	return Reminder{Delay: delay, Text: rest}, true
+}
+
+// ParseDuration parses a duration such as "90d", "2w" or "1 day and 12h",
+// in the units reminders accept.
+func ParseDuration(s string) (time.Duration, bool) {
+	d, rest, ok := cutDuration(strings.TrimSpace(s))
+	return d, ok && rest == ""
+}
+
+// cutDuration parses the duration at the start of s and returns the rest.
+func cutDuration(s string) (time.Duration, string, bool) {
+	var d time.Duration
+	for {
+		m := durationPart.FindStringSubmatch(s)
+		if m == nil || startsWithLetter(s[len(m[0]):]) {
+			break
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > 100000 {
+			return 0, s, false
+		}
+		d += time.Duration(n) * durationUnit(m[2])
+		s = strings.TrimSpace(s[len(m[0]):])
+		if word, tail, _ := strings.Cut(s, " "); strings.EqualFold(word, "and") {
+			s = strings.TrimSpace(tail)
+		}
+	}
+	return d, s, d > 0
+}
+
+func startsWithLetter(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r)
+}
+
+func durationUnit(unit string) time.Duration {
+	switch strings.ToLower(unit)[0] {
+	case 'w':
+		return 7 * 24 * time.Hour
+	case 'd':
+		return 24 * time.Hour
+	case 'h':
+		return time.Hour
+	default:
+		return time.Minute
+	}
+}
@@ -0,0 +1,58 @@
+package triggers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReminder(t *testing.T) {
+	tests := []struct {
+		args  string
+		delay time.Duration
+		text  string
+		ok    bool
+	}{
+		{args: "in 2h about the deploy", delay: 2 * time.Hour, text: "the deploy", ok: true},
+		{args: "in 1h30m to check CI", delay: 90 * time.Minute, text: "check CI", ok: true},
+		{args: "3 days that the cert expires", delay: 72 * time.Hour, text: "the cert expires", ok: true},
+		{args: "in 1 hour and 15 minutes stand-up", delay: 75 * time.Minute, text: "stand-up", ok: true},
+		{args: "in 1w renew domain", delay: 7 * 24 * time.Hour, text: "renew domain", ok: true},
+		{args: "in 10 Mins about tea", delay: 10 * time.Minute, text: "tea", ok: true},
+		{args: "in 2h", ok: false},
+		{args: "tomorrow about lunch", ok: false},
+		{args: "in 0m about nothing", ok: false},
+		{args: "in 2hours about it", ok: true, delay: 2 * time.Hour, text: "it"},
+		{args: "in 5 mangoes", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseReminder(tt.args)
+		if ok != tt.ok {
+			t.Fatalf("ParseReminder(%q) ok = %v, want %v", tt.args, ok, tt.ok)
+		}
+		if ok && (got.Delay != tt.delay || got.Text != tt.text) {
+			t.Fatalf("ParseReminder(%q) = %#v, want delay %s text %q", tt.args, got, tt.delay, tt.text)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{in: "90d", want: 90 * 24 * time.Hour, ok: true},
+		{in: " 2w ", want: 14 * 24 * time.Hour, ok: true},
+		{in: "1 day and 12h", want: 36 * time.Hour, ok: true},
+		{in: "", ok: false},
+		{in: "0d", ok: false},
+		{in: "90d please", ok: false},
+		{in: "forever", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseDuration(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Fatalf("ParseDuration(%q) = %s, %v; want %s, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
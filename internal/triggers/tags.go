@@ -0,0 +1,41 @@
+package triggers
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	hashtagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+	digitsOnly     = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// ExtractHashtags returns unique lowercase #hashtags in msg. Tags must start
+// a word, so URL fragments are not picked up, and purely numeric tags such as
+// issue references are skipped.
+func ExtractHashtags(msg string) []string {
+	return uniqueTags(hashtagPattern.FindAllStringSubmatch(msg, -1))
+}
+
+func uniqueTags(matches [][]string) []string {
+	if len(matches) == 0 {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(matches))
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		tag := strings.ToLower(strings.Trim(m[1], "_-"))
+		if tag == "" || digitsOnly.MatchString(tag) {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
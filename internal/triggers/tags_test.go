@@ -0,0 +1,14 @@
+package triggers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	got := ExtractHashtags("#Go tips https://example.org/#anchor #golang #go issue #123 and #Über")
+	want := []string{"go", "golang", "über"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ExtractHashtags() = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package triggers
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// NearestCommand returns the command word is a likely typo of: within edit
+// distance 1 (2 for commands of 8 or more runes), counting adjacent
+// transpositions as one edit. word and the command must share their first
+// rune, so plain words never match a prefixed command. Exact and ambiguous
+// matches report false.
+func NearestCommand(word string, commands []string) (string, bool) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	first, _ := utf8.DecodeRuneInString(word)
+	if utf8.RuneCountInString(word) < 3 {
+		return "", false
+	}
+
+	best, bestDist, tied := "", -1, false
+	for _, command := range commands {
+		lower := strings.ToLower(strings.TrimSpace(command))
+		if r, _ := utf8.DecodeRuneInString(lower); r != first {
+			continue
+		}
+		maxDist := 1
+		if utf8.RuneCountInString(lower) >= 8 {
+			maxDist = 2
+		}
+		dist := editDistance(word, lower)
+		if dist == 0 {
+			return "", false
+		}
+		if dist > maxDist {
+			continue
+		}
+		switch {
+		case bestDist < 0 || dist < bestDist:
+			best, bestDist, tied = command, dist, false
+		case dist == bestDist && lower != strings.ToLower(best):
+			tied = true
+		}
+	}
+	if bestDist < 0 || tied {
+		return "", false
+	}
+	return best, true
+}
+
+// editDistance is the optimal string alignment distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,35 @@
+package triggers
+
+import "testing"
+
+func TestNearestCommand(t *testing.T) {
+	commands := []string{"/search", "/catchmeup"}
+	cases := []struct {
+		word string
+		want string
+		ok   bool
+	}{
+		{"/serach", "/search", true},
+		{"/Saerch", "/search", true},
+		{"/searc", "/search", true},
+		{"/cathcmeup", "/catchmeup", true},
+		{"/catchmup", "/catchmeup", true},
+		{"/search", "", false},
+		{"/sea", "", false},
+		{"search", "", false},
+		{"/shrug", "", false},
+		{"!serach", "", false},
+	}
+	for _, c := range cases {
+		got, ok := NearestCommand(c.word, commands)
+		if got != c.want || ok != c.ok {
+			t.Errorf("NearestCommand(%q) = %q, %v; want %q, %v", c.word, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestNearestCommand_Ambiguous(t *testing.T) {
+	if got, ok := NearestCommand("/bat", []string{"/cat", "/hat"}); ok {
+		t.Fatalf("expected ambiguous match to be rejected, got %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/gotlou/hister-element-bot/bot/internal/version.Version=v1.2.0 \
+//	  -X github.com/gotlou/hister-element-bot/bot/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/gotlou/hister-element-bot/bot/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info identifies the running build.
+type Info struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+// Get returns the ldflags values, filling a missing commit and build date
+// from the VCS stamp Go embeds when building inside a checkout.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		fillFromBuildInfo(&info, bi)
+	}
+	return info
+}
+
+func fillFromBuildInfo(info *Info, bi *debug.BuildInfo) {
+	modified, fromVCS := false, false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				fromVCS = true
+				info.Commit = s.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && fromVCS {
+		info.Commit += "-dirty"
+	}
+}
+
+func (i Info) String() string {
+	commit, date := i.Commit, i.BuildDate
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", i.Version, commit, date, i.GoVersion)
+}
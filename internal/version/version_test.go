@@ -0,0 +1,31 @@
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestFillFromBuildInfo(t *testing.T) {
+	info := Info{Version: "dev"}
+	fillFromBuildInfo(&info, &debug.BuildInfo{Settings: []debug.BuildSetting{
+		{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+		{Key: "vcs.time", Value: "2024-05-01T10:00:00Z"},
+		{Key: "vcs.modified", Value: "true"},
+	}})
+	if info.Commit != "0123456789ab-dirty" || info.BuildDate != "2024-05-01T10:00:00Z" {
+		t.Fatalf("unexpected info: %#v", info)
+	}
+
+	info = Info{Version: "v1.0.0", Commit: "abc123", BuildDate: "2024-06-01"}
+	fillFromBuildInfo(&info, &debug.BuildInfo{Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "ffff"}}})
+	if info.Commit != "abc123" || info.BuildDate != "2024-06-01" {
+		t.Fatalf("expected ldflags values to win, got %#v", info)
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	got := Info{Version: "v1.0.0", GoVersion: "go1.24.0"}.String()
+	if got != "v1.0.0 (commit unknown, built unknown, go1.24.0)" {
+		t.Fatalf("unexpected string: %q", got)
+	}
+}